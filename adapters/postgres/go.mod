@@ -0,0 +1,19 @@
+module github.com/resgateio/resgate/adapters/postgres
+
+go 1.21
+
+require (
+	github.com/jackc/pgx/v5 v5.5.5
+	github.com/resgateio/resgate v0.0.0
+)
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jirenius/timerqueue v1.0.0 // indirect
+	github.com/rs/xid v1.2.1 // indirect
+	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
+
+replace github.com/resgateio/resgate => ../..
@@ -0,0 +1,287 @@
+package postgres
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/mq"
+)
+
+const timeout = 5 * time.Second
+
+// pgServer is a fake PostgreSQL server, accepting any user, responding to
+// simple queries, and sending notifications to the latest connection.
+type pgServer struct {
+	ln      net.Listener
+	queries chan string
+	mu      sync.Mutex
+	conn    net.Conn
+}
+
+func newPGServer(t *testing.T) *pgServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting fake PostgreSQL server: %s", err)
+	}
+	ps := &pgServer{ln: ln, queries: make(chan string, 16)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ps.serve(conn)
+		}
+	}()
+	return ps
+}
+
+func (ps *pgServer) URL() string {
+	return "postgres://resgate@" + ps.ln.Addr().String() + "/test?sslmode=disable"
+}
+
+func (ps *pgServer) Close() {
+	ps.ln.Close()
+	ps.dropConn()
+}
+
+func (ps *pgServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	// Startup message
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &n) != nil || n < 4 {
+		return
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(n-4)); err != nil {
+		return
+	}
+	ps.mu.Lock()
+	ps.conn = conn
+	ps.send('R', []byte{0, 0, 0, 0})
+	ps.send('Z', []byte{'I'})
+	ps.mu.Unlock()
+	for {
+		t, err := r.ReadByte()
+		if err != nil || binary.Read(r, binary.BigEndian, &n) != nil || n < 4 {
+			return
+		}
+		body := make([]byte, n-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		if t == 'Q' {
+			ps.mu.Lock()
+			ps.send('C', []byte("LISTEN\x00"))
+			ps.send('Z', []byte{'I'})
+			ps.mu.Unlock()
+			ps.queries <- string(body[:len(body)-1])
+		}
+	}
+}
+
+// send writes a message to the latest connection.
+// ps.mu must be held when called.
+func (ps *pgServer) send(t byte, body []byte) {
+	b := make([]byte, 5, 5+len(body))
+	b[0] = t
+	binary.BigEndian.PutUint32(b[1:], uint32(4+len(body)))
+	ps.conn.Write(append(b, body...))
+}
+
+// Notify sends a notification on the channel.
+func (ps *pgServer) Notify(channel, payload string) {
+	b := append([]byte{0, 0, 0, 1}, channel...)
+	b = append(append(append(b, 0), payload...), 0)
+	ps.mu.Lock()
+	ps.send('A', b)
+	ps.mu.Unlock()
+}
+
+func (ps *pgServer) dropConn() {
+	ps.mu.Lock()
+	if ps.conn != nil {
+		ps.conn.Close()
+	}
+	ps.mu.Unlock()
+}
+
+// AssertQuery asserts that the next simple query equals the query.
+func (ps *pgServer) AssertQuery(t *testing.T, query string) {
+	select {
+	case q := <-ps.queries:
+		if q != query {
+			t.Fatalf("expected PostgreSQL query %q, but got %q", query, q)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("expected PostgreSQL query %q but found none", query)
+	}
+}
+
+// message is a message passed to a subscription.
+type message struct {
+	Subject string
+	Payload string
+}
+
+// mqClient is a fake underlying client, passing the messages to the
+// subscriptions to a channel.
+type mqClient struct {
+	msgs chan message
+}
+
+type unsubscriber struct{}
+
+func (unsubscriber) Unsubscribe() error { return nil }
+
+func (c *mqClient) Connect() error                                          { return nil }
+func (c *mqClient) SendRequest(subj string, payload []byte, cb mq.Response) {}
+func (c *mqClient) Publish(subj string, payload []byte) error               { return nil }
+func (c *mqClient) Close()                                                  {}
+func (c *mqClient) IsClosed() bool                                          { return false }
+func (c *mqClient) SetClosedHandler(cb func(error))                         {}
+func (c *mqClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	return unsubscriber{}, nil
+}
+
+// subscribe subscribes to the namespace, passing messages to the channel.
+func (c *mqClient) subscribe(t *testing.T, pc *Client, namespace string) {
+	_, err := pc.Subscribe(namespace, func(subj string, payload []byte, err error) {
+		c.msgs <- message{subj, string(payload)}
+	})
+	if err != nil {
+		t.Fatalf("error subscribing to %s: %s", namespace, err)
+	}
+}
+
+// AssertMessage asserts that the next message has the subject and payload.
+func (c *mqClient) AssertMessage(t *testing.T, subj string, payload string) {
+	select {
+	case m := <-c.msgs:
+		if m.Subject != subj || m.Payload != payload {
+			t.Fatalf("expected message %s %s, but got %s %s", subj, payload, m.Subject, m.Payload)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("expected message %s %s but found none", subj, payload)
+	}
+}
+
+// AssertNoMessage asserts that no message is received.
+func (c *mqClient) AssertNoMessage(t *testing.T) {
+	select {
+	case m := <-c.msgs:
+		t.Fatalf("expected no message, but got %s %s", m.Subject, m.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func runTest(t *testing.T, ps *pgServer, channels []Channel, cb func(c *mqClient, pc *Client)) {
+	c := &mqClient{msgs: make(chan message, 16)}
+	pc := &Client{
+		Client:        c,
+		URL:           ps.URL(),
+		Channels:      channels,
+		ReconnectWait: 10 * time.Millisecond,
+		Logger:        logger.NewMemLogger(true, false),
+	}
+	if err := pc.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer pc.Close()
+	cb(c, pc)
+}
+
+// Test that invalid settings are reported on Connect
+func TestConnect_InvalidSettings_ReturnsError(t *testing.T) {
+	tbl := []struct {
+		URL      string
+		Channels []Channel
+	}{
+		{"mysql://localhost", []Channel{{Channel: "resgate", Pattern: "library.>"}}},
+		{"postgres://localhost", nil},
+		{"postgres://localhost", []Channel{{Channel: "", Pattern: "library.>"}}},
+		{"postgres://localhost", []Channel{{Channel: "resgate", Pattern: "library.>.book"}}},
+	}
+
+	for i, l := range tbl {
+		pc := &Client{Client: &mqClient{}, URL: l.URL, Channels: l.Channels}
+		if err := pc.Connect(); err == nil {
+			t.Errorf("expected an error, but got none")
+		}
+		if t.Failed() {
+			t.Logf("failed on test %d", i)
+			break
+		}
+	}
+}
+
+// Test that PostgreSQL notifications are sent as events for subscribed
+// resources matching the channel pattern
+func TestNotification_MatchingPattern_SendsEvent(t *testing.T) {
+	ps := newPGServer(t)
+	defer ps.Close()
+
+	runTest(t, ps, []Channel{{Channel: "resgate", Pattern: "test.>"}}, func(c *mqClient, pc *Client) {
+		ps.AssertQuery(t, `LISTEN "resgate"`)
+		c.subscribe(t, pc, "event.test.model")
+
+		ps.Notify("resgate", `{"rid":"test.model","event":"change","data":{"values":{"string":"bar"}}}`)
+		c.AssertMessage(t, "event.test.model.change", `{"values":{"string":"bar"}}`)
+	})
+}
+
+// Test that PostgreSQL notifications for resources not matching the channel
+// pattern are ignored
+func TestNotification_NotMatchingPattern_Ignored(t *testing.T) {
+	ps := newPGServer(t)
+	defer ps.Close()
+
+	runTest(t, ps, []Channel{{Channel: "resgate", Pattern: "library.>"}, {Channel: "other", Pattern: "test.>"}}, func(c *mqClient, pc *Client) {
+		ps.AssertQuery(t, `LISTEN "resgate"`)
+		ps.AssertQuery(t, `LISTEN "other"`)
+		c.subscribe(t, pc, "event.test.model")
+
+		ps.Notify("resgate", `{"rid":"test.model","event":"change","data":{"values":{"string":"bar"}}}`)
+		c.AssertNoMessage(t)
+	})
+}
+
+// Test that malformed notifications are ignored
+func TestNotification_MalformedPayload_Ignored(t *testing.T) {
+	ps := newPGServer(t)
+	defer ps.Close()
+
+	runTest(t, ps, []Channel{{Channel: "resgate", Pattern: "test.>"}}, func(c *mqClient, pc *Client) {
+		ps.AssertQuery(t, `LISTEN "resgate"`)
+		c.subscribe(t, pc, "event.test.model")
+
+		ps.Notify("resgate", `{"rid":"test.model","event":"change.foo"}`)
+		ps.Notify("resgate", `test.model.change`)
+		c.AssertNoMessage(t)
+	})
+}
+
+// Test that the listener reconnects when the connection is lost, and resets
+// the resources matching the channel patterns
+func TestListen_ConnectionLost_ReconnectsAndResets(t *testing.T) {
+	ps := newPGServer(t)
+	defer ps.Close()
+
+	runTest(t, ps, []Channel{{Channel: "resgate", Pattern: "test.>"}}, func(c *mqClient, pc *Client) {
+		ps.AssertQuery(t, `LISTEN "resgate"`)
+		c.subscribe(t, pc, "system")
+
+		ps.dropConn()
+		ps.AssertQuery(t, `LISTEN "resgate"`)
+		b, _ := json.Marshal(map[string][]string{"resources": {"test.>"}})
+		c.AssertMessage(t, "system.reset", string(b))
+	})
+}
@@ -0,0 +1,15 @@
+module github.com/resgateio/resgate/adapters/wasm
+
+go 1.21
+
+require (
+	github.com/resgateio/resgate v0.0.0
+	github.com/tetratelabs/wazero v1.8.2
+)
+
+require (
+	github.com/jirenius/timerqueue v1.0.0 // indirect
+	github.com/rs/xid v1.2.1 // indirect
+)
+
+replace github.com/resgateio/resgate => ../..
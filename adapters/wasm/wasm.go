@@ -0,0 +1,398 @@
+// Package wasm implements a mq.Client wrapper serving get, call, and access
+// requests with WebAssembly modules, resolving requests for resources
+// matching a pattern inside the gateway instead of sending them to a service.
+//
+// The module must export its memory, and the function alloc(size i32) i32,
+// returning a pointer to size bytes of memory. It may export the functions
+// get, call, and access, each taking the i32 pointer and length of the
+// subject, and of the request payload, and returning an i64 with a pointer
+// to the response in the upper 32 bits and its length in the lower 32 bits.
+// The response is JSON in the same format as a service response. Requests
+// of a type not exported are sent with the underlying client. The module may
+// import the function env.log(ptr i32, len i32) to write debug log entries.
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+const (
+	// DefaultTimeout is the default time a resolver may run for each request.
+	DefaultTimeout = time.Second
+	// DefaultMaxMemory is the default memory limit in bytes of a resolver.
+	DefaultMaxMemory = 16 << 20
+
+	pageSize = 65536
+)
+
+// Resolver holds the settings for a WebAssembly module resolving requests
+// for resources matching a pattern.
+type Resolver struct {
+	// Pattern is the resource pattern of the resolved resources.
+	Pattern string `json:"pattern"`
+	// Path is the path to the WebAssembly module file.
+	Path string `json:"path"`
+	// Timeout is the time in milliseconds the module may run for each
+	// request. Defaults to 1000.
+	Timeout int `json:"timeout,omitempty"`
+	// MaxMemory is the memory limit of the module in bytes, rounded up to
+	// whole 64 KiB pages. Defaults to 16777216.
+	MaxMemory int `json:"maxMemory,omitempty"`
+}
+
+// Client is a mq.Client serving get, call, and access requests with the
+// resolvers. Other calls are passed on to the underlying client.
+type Client struct {
+	mq.Client            // Underlying client
+	Resolvers []Resolver // Resolvers, matched in order
+	Logger    logger.Logger
+
+	resolvers []*resolver
+}
+
+// resolver is a compiled Resolver. The module instance is created when
+// compiled, and recreated after a failure.
+type resolver struct {
+	Resolver
+	pattern rescache.ResourcePattern
+	timeout time.Duration
+	rt      wazero.Runtime
+	module  wazero.CompiledModule
+
+	mu sync.Mutex
+	in api.Module
+}
+
+// requestFuncs are the names of the functions resolving requests, by
+// subject prefix.
+var requestFuncs = map[string]string{
+	"get.":    "get",
+	"call.":   "call",
+	"access.": "access",
+}
+
+// Errorf writes a formatted error message
+func (c *Client) Errorf(format string, v ...interface{}) {
+	c.Logger.Error(fmt.Sprintf(format, v...))
+}
+
+// Debugf writes a formatted debug message
+func (c *Client) Debugf(format string, v ...interface{}) {
+	if c.Logger.IsDebug() {
+		c.Logger.Debug(fmt.Sprintf(format, v...))
+	}
+}
+
+// Connect compiles the resolvers, and connects the underlying client.
+func (c *Client) Connect() error {
+	ctx := context.Background()
+	for _, r := range c.Resolvers {
+		cr, err := c.compile(ctx, r)
+		if err != nil {
+			c.closeResolvers()
+			c.resolvers = nil
+			return fmt.Errorf("invalid wasm resolver: %s", err)
+		}
+		c.resolvers = append(c.resolvers, cr)
+	}
+	if err := c.Client.Connect(); err != nil {
+		c.closeResolvers()
+		c.resolvers = nil
+		return err
+	}
+	return nil
+}
+
+// Close closes the resolvers, failing any later requests to them, and
+// closes the underlying client.
+func (c *Client) Close() {
+	c.closeResolvers()
+	c.Client.Close()
+}
+
+// closeResolvers closes the runtimes of the compiled resolvers.
+func (c *Client) closeResolvers() {
+	for _, r := range c.resolvers {
+		r.mu.Lock()
+		r.rt.Close(context.Background())
+		r.in = nil
+		r.mu.Unlock()
+	}
+}
+
+// SetTraceFilter passes the trace filter to the underlying client, if
+// supported.
+func (c *Client) SetTraceFilter(f func(subject string) bool) {
+	if tf, ok := c.Client.(mq.TraceFilterer); ok {
+		tf.SetTraceFilter(f)
+	}
+}
+
+// SetReconnectHandler passes the reconnect handler to the underlying client,
+// if supported.
+func (c *Client) SetReconnectHandler(cb func()) {
+	if r, ok := c.Client.(mq.Reconnecter); ok {
+		r.SetReconnectHandler(cb)
+	}
+}
+
+// SetInstanceID passes the instance ID to the underlying client, if
+// supported.
+func (c *Client) SetInstanceID(id string) {
+	if i, ok := c.Client.(mq.Identifier); ok {
+		i.SetInstanceID(id)
+	}
+}
+
+// compile validates the resolver settings, compiles the module, and
+// instantiates it.
+func (c *Client) compile(ctx context.Context, r Resolver) (*resolver, error) {
+	p := rescache.ParseResourcePattern(r.Pattern)
+	if !p.IsValid() {
+		return nil, fmt.Errorf("pattern %q must be a valid resource pattern", r.Pattern)
+	}
+	if r.Path == "" {
+		return nil, errors.New("path must be set")
+	}
+	if r.Timeout < 0 {
+		return nil, errors.New("timeout must be zero or a positive number of milliseconds")
+	}
+	if r.MaxMemory < 0 {
+		return nil, errors.New("maxMemory must be zero or a positive number of bytes")
+	}
+	if r.Timeout == 0 {
+		r.Timeout = int(DefaultTimeout / time.Millisecond)
+	}
+	if r.MaxMemory == 0 {
+		r.MaxMemory = DefaultMaxMemory
+	}
+	b, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading module: %s", err)
+	}
+
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32((r.MaxMemory+pageSize-1)/pageSize)).
+		WithCloseOnContextDone(true))
+	cr := &resolver{
+		Resolver: r,
+		pattern:  p,
+		timeout:  time.Duration(r.Timeout) * time.Millisecond,
+		rt:       rt,
+	}
+	if err := cr.compile(ctx, b, c.logFunc(cr)); err != nil {
+		rt.Close(ctx)
+		return nil, fmt.Errorf("module %s %s", r.Path, err)
+	}
+	return cr, nil
+}
+
+// compile compiles and validates the module, and instantiates it together
+// with the env host module.
+func (r *resolver) compile(ctx context.Context, b []byte, log func(context.Context, api.Module, uint32, uint32)) error {
+	m, err := r.rt.CompileModule(ctx, b)
+	if err != nil {
+		return fmt.Errorf("failed to compile: %s", err)
+	}
+	fns := m.ExportedFunctions()
+	if !hasSignature(fns["alloc"], []api.ValueType{api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}) {
+		return errors.New("must export the function alloc(i32) i32")
+	}
+	found := false
+	for _, fn := range requestFuncs {
+		if d, ok := fns[fn]; ok {
+			if !hasSignature(d, []api.ValueType{api.ValueTypeI32, api.ValueTypeI32, api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI64}) {
+				return fmt.Errorf("function %s must have the signature (i32, i32, i32, i32) i64", fn)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("must export at least one of the functions get, call, or access")
+	}
+	if _, ok := m.ExportedMemories()["memory"]; !ok {
+		return errors.New("must export its memory")
+	}
+	for _, d := range m.ImportedFunctions() {
+		mod, name, _ := d.Import()
+		if mod != "env" || name != "log" {
+			return fmt.Errorf("imports unknown function %s.%s", mod, name)
+		}
+		if !hasSignature(d, []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, nil) {
+			return errors.New("function env.log must have the signature (i32, i32)")
+		}
+	}
+	if _, err := r.rt.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(log).Export("log").
+		Instantiate(ctx); err != nil {
+		return err
+	}
+	r.module = m
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.instantiate(ctx); err != nil {
+		return fmt.Errorf("failed to instantiate: %s", err)
+	}
+	return nil
+}
+
+// hasSignature reports whether the function has the parameter and result
+// types.
+func hasSignature(d api.FunctionDefinition, params, results []api.ValueType) bool {
+	if d == nil {
+		return false
+	}
+	return equalTypes(d.ParamTypes(), params) && equalTypes(d.ResultTypes(), results)
+}
+
+func equalTypes(a, b []api.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, t := range a {
+		if t != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// instantiate creates a new instance of the module.
+// r.mu must be held when called.
+func (r *resolver) instantiate(ctx context.Context) error {
+	in, err := r.rt.InstantiateModule(ctx, r.module, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return err
+	}
+	r.in = in
+	return nil
+}
+
+// exports reports whether the module exports the function.
+func (r *resolver) exports(fn string) bool {
+	_, ok := r.module.ExportedFunctions()[fn]
+	return ok
+}
+
+// resolver returns the first resolver matching the resource name, exporting
+// the function, or nil if there is none.
+func (c *Client) resolver(rname, fn string) *resolver {
+	for _, r := range c.resolvers {
+		if r.pattern.Match(rname) {
+			if r.exports(fn) {
+				return r
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// SendRequest serves get, call, and access requests for resources matching
+// a resolver exporting the function for the request. Other requests are
+// sent to the underlying client.
+func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
+	for prefix, fn := range requestFuncs {
+		if !strings.HasPrefix(subj, prefix) {
+			continue
+		}
+		rname := subj[len(prefix):]
+		if fn == "call" {
+			idx := strings.LastIndexByte(rname, '.')
+			if idx < 0 {
+				break
+			}
+			rname = rname[:idx]
+		}
+		if r := c.resolver(rname, fn); r != nil {
+			go func() {
+				cb(subj, c.resolve(r, fn, subj, payload), nil)
+			}()
+			return
+		}
+		break
+	}
+	c.Client.SendRequest(subj, payload, cb)
+}
+
+// resolve calls the resolver function, and returns the response, or an
+// internal error response if the module fails.
+func (c *Client) resolve(r *resolver, fn, subj string, payload []byte) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := r.call(fn, subj, payload)
+	if err != nil {
+		c.Errorf("WASM resolver %s failed on %s: %s", r.Path, subj, err)
+		// Recreate the instance, as a failure may leave its memory inconsistent.
+		if r.in != nil {
+			r.in.Close(context.Background())
+			r.in = nil
+		}
+		data, _ = json.Marshal(struct {
+			Error *reserr.Error `json:"error"`
+		}{reserr.ErrInternalError})
+	}
+	return data
+}
+
+// call writes the subject and payload to memory, calls the function, and
+// returns a copy of the response.
+// r.mu must be held when called.
+func (r *resolver) call(fn, subj string, payload []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	if r.in == nil {
+		if err := r.instantiate(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := r.in.ExportedFunction("alloc").Call(ctx, uint64(len(subj)+len(payload)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(res[0])
+	mem := r.in.Memory()
+	if !mem.Write(ptr, []byte(subj)) || !mem.Write(ptr+uint32(len(subj)), payload) {
+		return nil, errors.New("request out of memory bounds")
+	}
+
+	res, err = r.in.ExportedFunction(fn).Call(ctx, uint64(ptr), uint64(len(subj)), uint64(ptr)+uint64(len(subj)), uint64(len(payload)))
+	if err != nil {
+		return nil, err
+	}
+	b, ok := mem.Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, errors.New("response out of memory bounds")
+	}
+	if !json.Valid(b) {
+		return nil, errors.New("response is not valid JSON")
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// logFunc returns the env.log host function of the resolver, writing a debug
+// log entry.
+func (c *Client) logFunc(r *resolver) func(context.Context, api.Module, uint32, uint32) {
+	return func(_ context.Context, m api.Module, ptr, n uint32) {
+		if b, ok := m.Memory().Read(ptr, n); ok {
+			c.Debugf("WASM resolver %s: %s", r.Path, b)
+		} else {
+			panic("log message out of memory bounds")
+		}
+	}
+}
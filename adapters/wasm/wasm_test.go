@@ -0,0 +1,282 @@
+package wasm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/mq"
+)
+
+const timeout = 5 * time.Second
+
+// Instruction sequences of test resolvers.
+var (
+	unreachable  = []byte{0x00}
+	infiniteLoop = []byte{0x03, 0x40, 0x0C, 0x00, 0x0B, 0x42, 0x00}
+)
+
+const internalError = `{"error":{"code":"system.internalError","message":"Internal error"}}`
+
+func uleb(v uint64) []byte {
+	var b []byte
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func sleb(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func vec(items ...[]byte) []byte {
+	b := uleb(uint64(len(items)))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+func section(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb(uint64(len(content)))...), content...)
+}
+
+// resolverModule assembles a resolver module, with an alloc function and
+// the resolver functions, by name. A function given a response string
+// returns the response, and one given a byte slice runs the instructions.
+func resolverModule(funcs map[string]interface{}) []byte {
+	allocType := []byte{0x60, 1, 0x7F, 1, 0x7F}
+	reqType := []byte{0x60, 4, 0x7F, 0x7F, 0x7F, 0x7F, 1, 0x7E}
+	fns := [][]byte{{0}}
+	exps := [][]byte{[]byte("\x06memory\x02\x00"), []byte("\x05alloc\x00\x00")}
+	bodies := [][]byte{{5, 0, 0x41, 0x80, 0x08, 0x0B}} // i32.const 1024
+	var data []byte
+	for name, f := range funcs {
+		var code []byte
+		switch v := f.(type) {
+		case string:
+			code = append([]byte{0x42}, sleb(int64(len(data))<<32|int64(len(v)))...)
+			data = append(data, v...)
+		case []byte:
+			code = v
+		}
+		exps = append(exps, append(append(append([]byte{byte(len(name))}, name...), 0), byte(len(fns))))
+		fns = append(fns, []byte{1})
+		body := append(append([]byte{0}, code...), 0x0B)
+		bodies = append(bodies, append(uleb(uint64(len(body))), body...))
+	}
+
+	b := []byte("\x00asm\x01\x00\x00\x00")
+	b = append(b, section(1, vec(allocType, reqType))...)
+	b = append(b, section(3, vec(fns...))...)
+	b = append(b, section(5, vec([]byte{0, 1}))...)
+	b = append(b, section(7, vec(exps...))...)
+	b = append(b, section(10, vec(bodies...))...)
+	b = append(b, section(11, vec(append(append([]byte{0, 0x41, 0, 0x0B}, uleb(uint64(len(data)))...), data...)))...)
+	return b
+}
+
+// writeModule writes the module to a temporary file, and returns the path
+// and a function removing the file.
+func writeModule(t *testing.T, b []byte) (string, func()) {
+	dir, err := ioutil.TempDir("", "resgate-wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "resolver.wasm")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// mqClient is a fake underlying client, passing the subjects of the
+// requests sent to a channel.
+type mqClient struct {
+	reqs chan string
+}
+
+func (c *mqClient) Connect() error { return nil }
+func (c *mqClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.reqs <- subj
+}
+func (c *mqClient) Publish(subj string, payload []byte) error { return nil }
+func (c *mqClient) Close()                                    {}
+func (c *mqClient) IsClosed() bool                            { return false }
+func (c *mqClient) SetClosedHandler(cb func(error))           {}
+func (c *mqClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	return nil, nil
+}
+
+// AssertRequest asserts that the next request sent to the client has the
+// subject.
+func (c *mqClient) AssertRequest(t *testing.T, subj string) {
+	select {
+	case s := <-c.reqs:
+		if s != subj {
+			t.Fatalf("expected request %s, but got %s", subj, s)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("expected request %s but found none", subj)
+	}
+}
+
+// AssertNoRequest asserts that no request is sent to the client.
+func (c *mqClient) AssertNoRequest(t *testing.T) {
+	select {
+	case s := <-c.reqs:
+		t.Fatalf("expected no request, but got %s", s)
+	default:
+	}
+}
+
+// request sends a request with the client, and returns the response, or
+// nil if no response is received.
+func request(t *testing.T, wc *Client, subj string, payload string) []byte {
+	ch := make(chan []byte, 1)
+	wc.SendRequest(subj, []byte(payload), func(_ string, data []byte, err error) {
+		if err != nil {
+			t.Errorf("expected no error, but got %s", err)
+		}
+		ch <- data
+	})
+	select {
+	case data := <-ch:
+		return data
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+// assertResponse asserts that the request is responded to with the data.
+func assertResponse(t *testing.T, wc *Client, subj string, data string) {
+	resp := request(t, wc, subj, `{"cid":"test"}`)
+	if resp == nil {
+		t.Fatalf("expected response %s on %s, but got none", data, subj)
+	}
+	if string(resp) != data {
+		t.Fatalf("expected response %s on %s, but got %s", data, subj, resp)
+	}
+}
+
+func runTest(t *testing.T, r Resolver, funcs map[string]interface{}, cb func(c *mqClient, wc *Client, l *logger.MemLogger)) {
+	path, cleanup := writeModule(t, resolverModule(funcs))
+	defer cleanup()
+	r.Path = path
+	c := &mqClient{reqs: make(chan string, 16)}
+	l := logger.NewMemLogger(true, false)
+	wc := &Client{Client: c, Resolvers: []Resolver{r}, Logger: l}
+	if err := wc.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer wc.Close()
+	cb(c, wc, l)
+}
+
+// Test that invalid resolver settings, or an invalid module, are reported
+// on Connect
+func TestConnect_InvalidResolver_ReturnsError(t *testing.T) {
+	valid := resolverModule(map[string]interface{}{"get": `{}`})
+	noGet := resolverModule(nil)
+	invalid := valid[:len(valid)-1]
+	tbl := []struct {
+		Resolver Resolver
+		Module   []byte
+	}{
+		{Resolver{Pattern: "test..>"}, valid},
+		{Resolver{Pattern: "test.>", Path: "missing.wasm"}, valid},
+		{Resolver{Pattern: "test.>", Timeout: -1}, valid},
+		{Resolver{Pattern: "test.>", MaxMemory: -1}, valid},
+		{Resolver{Pattern: "test.>"}, noGet},
+		{Resolver{Pattern: "test.>"}, invalid},
+	}
+
+	for i, l := range tbl {
+		path, cleanup := writeModule(t, l.Module)
+		r := l.Resolver
+		if r.Path == "" {
+			r.Path = path
+		}
+		wc := &Client{Client: &mqClient{}, Resolvers: []Resolver{r}}
+		if err := wc.Connect(); err == nil {
+			t.Errorf("expected an error, but got none")
+		}
+		cleanup()
+		if t.Failed() {
+			t.Logf("failed on test %d", i)
+			break
+		}
+	}
+
+	wc := &Client{Client: &mqClient{}, Resolvers: []Resolver{{Pattern: "test.>"}}}
+	if err := wc.Connect(); err == nil {
+		t.Errorf("expected an error for a missing path, but got none")
+	}
+}
+
+// Test that get and access requests for resources matching the resolver are
+// resolved by the module, without any request to the underlying client
+func TestSendRequest_GetAndAccess_ResolvedByModule(t *testing.T) {
+	runTest(t, Resolver{Pattern: "test.>"}, map[string]interface{}{
+		"get":    `{"result":{"model":{"foo":"bar"}}}`,
+		"access": `{"result":{"get":true}}`,
+	}, func(c *mqClient, wc *Client, l *logger.MemLogger) {
+		assertResponse(t, wc, "get.test.model", `{"result":{"model":{"foo":"bar"}}}`)
+		assertResponse(t, wc, "access.test.model", `{"result":{"get":true}}`)
+		c.AssertNoRequest(t)
+	})
+}
+
+// Test that requests of a type not exported by the resolver, or for
+// resources not matching the pattern, are sent with the underlying client
+func TestSendRequest_NotResolved_SentWithUnderlyingClient(t *testing.T) {
+	runTest(t, Resolver{Pattern: "test.>"}, map[string]interface{}{
+		"access": `{"result":{"get":true,"call":"*"}}`,
+	}, func(c *mqClient, wc *Client, l *logger.MemLogger) {
+		wc.SendRequest("call.test.model.method", nil, nil)
+		c.AssertRequest(t, "call.test.model.method")
+		wc.SendRequest("access.other.model", nil, nil)
+		c.AssertRequest(t, "access.other.model")
+	})
+}
+
+// Test that a resolver trapping, exceeding its timeout, or responding with
+// invalid JSON, responds with an internal error, and that the module is
+// instantiated again for the next request
+func TestSendRequest_ModuleFailure_RespondsWithInternalError(t *testing.T) {
+	for i, code := range []interface{}{unreachable, infiniteLoop, `{"result":`} {
+		runTest(t, Resolver{Pattern: "test.>", Timeout: 50}, map[string]interface{}{
+			"access": `{"result":{"get":true,"call":"*"}}`,
+			"call":   code,
+		}, func(c *mqClient, wc *Client, l *logger.MemLogger) {
+			resp := request(t, wc, "call.test.model.method", `{}`)
+			if string(resp) != internalError {
+				t.Fatalf("expected internal error response, but got %s", resp)
+			}
+			if !strings.Contains(l.String(), "[ERR] WASM resolver") {
+				t.Fatalf("expected an error to be logged, but got:\n%s", l.String())
+			}
+			assertResponse(t, wc, "access.test.model", `{"result":{"get":true,"call":"*"}}`)
+		})
+		if t.Failed() {
+			t.Logf("failed on test %d", i)
+			break
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix is the prefix of environment variables setting config options.
+const EnvPrefix = "RESGATE_"
+
+// LoadEnv sets config options from environment variables. The variable name
+// of an option is EnvPrefix followed by its JSON property name in upper case
+// snake case, such as RESGATE_NATS_URL for natsUrl. String values are used as
+// is, and string list values are comma separated unless given as a JSON
+// array. Any other value is JSON decoded.
+//
+// The lookup function is usually os.LookupEnv.
+func (c *Config) LoadEnv(lookup func(key string) (string, bool)) error {
+	return loadEnv(reflect.ValueOf(c).Elem(), lookup)
+}
+
+func loadEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			if err := loadEnv(v.Field(i), lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "" || name == "-" || f.PkgPath != "" {
+			continue
+		}
+		key := envName(name)
+		s, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(v.Field(i), s); err != nil {
+			return fmt.Errorf("invalid %s environment variable: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// setEnvValue sets the field to the environment variable value.
+func setEnvValue(fv reflect.Value, s string) error {
+	ft := fv.Type()
+	switch {
+	case ft.Kind() == reflect.String:
+		fv.SetString(s)
+		return nil
+	case ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.String:
+		fv.Set(reflect.ValueOf(&s).Convert(ft))
+		return nil
+	case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "["):
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+			for i, p := range parts {
+				parts[i] = strings.TrimSpace(p)
+			}
+		}
+		fv.Set(reflect.ValueOf(parts).Convert(ft))
+		return nil
+	}
+	nv := reflect.New(ft)
+	if err := json.Unmarshal([]byte(s), nv.Interface()); err != nil {
+		return err
+	}
+	fv.Set(nv.Elem())
+	return nil
+}
+
+// envName returns the environment variable name for a JSON property name,
+// such as RESGATE_NATS_URL for natsUrl.
+func envName(name string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	prev := rune(0)
+	for _, r := range name {
+		if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+		prev = r
+	}
+	return b.String()
+}
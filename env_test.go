@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/resgateio/resgate/nats"
+	"github.com/resgateio/resgate/server"
+)
+
+// mapLookup returns a lookup function for the environment variables in m.
+func mapLookup(m map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+// setEnv sets the environment variables, and returns a function restoring
+// them.
+func setEnv(t *testing.T, m map[string]string) func() {
+	for k, v := range m {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for k := range m {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+// Test envName mapping of JSON property names
+func TestEnvName(t *testing.T) {
+	tbl := []struct {
+		Name     string
+		Expected string
+	}{
+		{"debug", "RESGATE_DEBUG"},
+		{"natsUrl", "RESGATE_NATS_URL"},
+		{"natsTlsCert", "RESGATE_NATS_TLS_CERT"},
+		{"natsRootCa", "RESGATE_NATS_ROOT_CA"},
+		{"wsPath", "RESGATE_WS_PATH"},
+		{"certFile", "RESGATE_CERT_FILE"},
+		{"subscriptionTTL", "RESGATE_SUBSCRIPTION_TTL"},
+		{"http2Push", "RESGATE_HTTP2_PUSH"},
+		{"ipv6Only", "RESGATE_IPV6_ONLY"},
+	}
+
+	for i, l := range tbl {
+		if got := envName(l.Name); got != l.Expected {
+			t.Errorf("expected envName(%q) to be %q, but got %q in test %d", l.Name, l.Expected, got, i+1)
+		}
+	}
+}
+
+// Test LoadEnv setting config options from environment variables
+func TestLoadEnv(t *testing.T) {
+	natsCreds := "./nats.creds"
+	emptyString := ""
+	headerAuth := "auth.test.header"
+
+	tbl := []struct {
+		Env      map[string]string
+		Expected Config
+	}{
+		// No variables
+		{map[string]string{}, Config{}},
+		{map[string]string{"NATS_URL": "nats://10.0.0.1:4222", "RESGATE": "", "RESGATE_": ""}, Config{}},
+		// Strings
+		{map[string]string{"RESGATE_WS_PATH": "/ws"}, Config{Config: server.Config{WSPath: "/ws"}}},
+		{map[string]string{"RESGATE_CERT_FILE": "server.crt", "RESGATE_KEY_FILE": "server.key"}, Config{Config: server.Config{TLSCert: "server.crt", TLSKey: "server.key"}}},
+		{map[string]string{"RESGATE_API_ENCODING": `"json"`}, Config{Config: server.Config{APIEncoding: `"json"`}}},
+		{map[string]string{"RESGATE_NATS_CREDS": "./nats.creds"}, Config{NatsCreds: &natsCreds}},
+		{map[string]string{"RESGATE_NATS_CREDS": ""}, Config{NatsCreds: &emptyString}},
+		{map[string]string{"RESGATE_HEADER_AUTH": "auth.test.header"}, Config{Config: server.Config{HeaderAuth: &headerAuth}}},
+		// Integers and durations in milliseconds
+		{map[string]string{"RESGATE_PORT": "8080"}, Config{Config: server.Config{Port: 8080}}},
+		{map[string]string{"RESGATE_REQUEST_TIMEOUT": "5000"}, Config{RequestTimeout: 5000}},
+		{map[string]string{"RESGATE_SUBSCRIPTION_TTL": "60000", "RESGATE_REACCESS_INTERVAL": "0"}, Config{Config: server.Config{SubscriptionTTL: 60000}}},
+		// Booleans
+		{map[string]string{"RESGATE_DEBUG": "true", "RESGATE_TRACE": "false"}, Config{Debug: true}},
+		{map[string]string{"RESGATE_TLS": "true", "RESGATE_WS_COMPRESSION": "true"}, Config{Config: server.Config{TLS: true, WSCompression: true}}},
+		// String lists
+		{map[string]string{"RESGATE_NATS_URL": "nats://10.0.0.1:4222"}, Config{NatsURL: URLList{"nats://10.0.0.1:4222"}}},
+		{map[string]string{"RESGATE_NATS_URL": "nats://10.0.0.1:4222, nats://10.0.0.2:4222"}, Config{NatsURL: URLList{"nats://10.0.0.1:4222", "nats://10.0.0.2:4222"}}},
+		{map[string]string{"RESGATE_NATS_URL": `["nats://10.0.0.1:4222","nats://10.0.0.2:4222"]`}, Config{NatsURL: URLList{"nats://10.0.0.1:4222", "nats://10.0.0.2:4222"}}},
+		{map[string]string{"RESGATE_LISTEN": ":8081,:8082"}, Config{Config: server.Config{Listen: []string{":8081", ":8082"}}}},
+		{map[string]string{"RESGATE_LISTEN": `[":8081,8082"]`}, Config{Config: server.Config{Listen: []string{":8081,8082"}}}},
+		{map[string]string{"RESGATE_LISTEN": ""}, Config{}},
+		// Nested structs
+		{map[string]string{"RESGATE_NATS_RECONNECT": `{"maxAttempts":5,"wait":100,"jitter":50}`}, Config{NatsReconnect: &nats.ReconnectConfig{MaxAttempts: 5, Wait: 100, Jitter: 50}}},
+		{map[string]string{"RESGATE_PROBES": `{"healthPath":"/live","drainDelay":500}`}, Config{Config: server.Config{Probes: &server.ProbesConfig{HealthPath: "/live", DrainDelay: 500}}}},
+		{map[string]string{"RESGATE_PROBES": `null`}, Config{}},
+	}
+
+	for i, l := range tbl {
+		var cfg Config
+		if err := cfg.LoadEnv(mapLookup(l.Env)); err != nil {
+			t.Fatalf("expected no error, but got:\n%s\nin test %d", err, i+1)
+		}
+		if !reflect.DeepEqual(cfg, l.Expected) {
+			t.Fatalf("expected config to be:\n%+v\nbut got:\n%+v\nin test %d", l.Expected, cfg, i+1)
+		}
+	}
+}
+
+// Test LoadEnv returning an error on invalid environment variable values
+func TestLoadEnv_InvalidValue_ReturnsError(t *testing.T) {
+	tbl := []map[string]string{
+		{"RESGATE_PORT": "http"},
+		{"RESGATE_PORT": "70000"},
+		{"RESGATE_PORT": "-1"},
+		{"RESGATE_PORT": ""},
+		{"RESGATE_REQUEST_TIMEOUT": "5s"},
+		{"RESGATE_REQUEST_TIMEOUT": "1.5"},
+		{"RESGATE_DEBUG": "yes"},
+		{"RESGATE_DEBUG": "1"},
+		{"RESGATE_NATS_URL": `["nats://10.0.0.1:4222"`},
+		{"RESGATE_NATS_URL": `[4222]`},
+		{"RESGATE_NATS_RECONNECT": `{"wait":"1s"}`},
+		{"RESGATE_PROBES": `/live`},
+		{"RESGATE_API_MOUNTS": `{"path":"/v1"}`},
+	}
+
+	for i, l := range tbl {
+		var cfg Config
+		if err := cfg.LoadEnv(mapLookup(l)); err == nil {
+			t.Fatalf("expected an error, but got none in test %d", i+1)
+		}
+	}
+}
+
+// Test that config options set by environment variables take precedence
+// over the config file, and that command line options take precedence over
+// both.
+func TestConfigInit_EnvPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resgate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(configFile, []byte(`{
+		"natsUrl": "nats://file:4222",
+		"wsPath": "/file",
+		"apiPath": "/file/",
+		"requestTimeout": 1000,
+		"listen": [":8081"]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setEnv(t, map[string]string{
+		"RESGATE_API_PATH":        "/env/",
+		"RESGATE_REQUEST_TIMEOUT": "2000",
+		"RESGATE_LISTEN":          ":8082",
+	})()
+
+	var cfg Config
+	cfg.Init(flag.NewFlagSet("resgate", flag.ContinueOnError), []string{
+		"-c", configFile,
+		"-r", "3000",
+		"--listen", ":8083",
+		"-n", "nats://flag:4222",
+	})
+
+	compare := func(name string, got, exp interface{}) {
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("expected %s to be %v, but got %v", name, exp, got)
+		}
+	}
+	compare("wsPath", cfg.WSPath, "/file")
+	compare("apiPath", cfg.APIPath, "/env/")
+	compare("requestTimeout", cfg.RequestTimeout, 3000)
+	compare("listen", cfg.Listen, []string{":8083"})
+	compare("natsUrl", cfg.NatsURL, URLList{"nats://flag:4222"})
+}
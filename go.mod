@@ -6,8 +6,7 @@ require (
 	github.com/gorilla/websocket v1.4.2
 	github.com/jirenius/timerqueue v1.0.0
 	github.com/nats-io/nats-server/v2 v2.1.4 // indirect
-	github.com/nats-io/nats.go v1.9.1
+	github.com/nats-io/nats.go v1.11.0
 	github.com/posener/wstest v1.2.0
 	github.com/rs/xid v1.2.1
-	golang.org/x/crypto v0.0.0-20200317142112-1b76d66859c6 // indirect
 )
@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry is a log entry kept by a RingLogger.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// RingLogger passes log messages to another logger, while keeping the most
+// recent entries in memory. Debug and trace entries may be kept even if the
+// other logger does not have them active.
+type RingLogger struct {
+	l       Logger
+	debug   bool
+	trace   bool
+	entries []Entry
+	next    int
+	full    bool
+	mu      sync.Mutex
+}
+
+// NewRingLogger returns a new logger that passes log messages to l, keeping
+// the last size entries. If debug or trace is true, such entries are kept
+// regardless of the level of l.
+func NewRingLogger(l Logger, size int, debug bool, trace bool) *RingLogger {
+	return &RingLogger{
+		l:       l,
+		debug:   debug,
+		trace:   trace,
+		entries: make([]Entry, size),
+	}
+}
+
+// Log writes a log entry
+func (l *RingLogger) Log(s string) {
+	l.add("INF", s)
+	l.l.Log(s)
+}
+
+// Error writes an error entry
+func (l *RingLogger) Error(s string) {
+	l.add("ERR", s)
+	l.l.Error(s)
+}
+
+// Debug writes a debug entry
+func (l *RingLogger) Debug(s string) {
+	l.add("DBG", s)
+	if l.l.IsDebug() {
+		l.l.Debug(s)
+	}
+}
+
+// Trace writes a trace entry
+func (l *RingLogger) Trace(s string) {
+	l.add("TRC", s)
+	if l.l.IsTrace() {
+		l.l.Trace(s)
+	}
+}
+
+// IsDebug returns true if debug logging is active
+func (l *RingLogger) IsDebug() bool {
+	return l.debug || l.l.IsDebug()
+}
+
+// IsTrace returns true if trace logging is active
+func (l *RingLogger) IsTrace() bool {
+	return l.trace || l.l.IsTrace()
+}
+
+// Entries returns the kept entries, oldest first.
+func (l *RingLogger) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]Entry(nil), l.entries[:l.next]...)
+	}
+	return append(append([]Entry(nil), l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+func (l *RingLogger) add(level, s string) {
+	if len(l.entries) == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.entries[l.next] = Entry{Time: time.Now(), Level: level, Message: s}
+	l.next++
+	if l.next == len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+	l.mu.Unlock()
+}
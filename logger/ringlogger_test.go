@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test that the ring logger keeps the most recent entries, oldest first
+func TestRingLogger_Entries_ReturnsMostRecent(t *testing.T) {
+	l := NewRingLogger(NewMemLogger(false, false), 2, false, false)
+	l.Log("first")
+	l.Error("second")
+	l.Log("third")
+
+	e := l.Entries()
+	if len(e) != 2 || e[0].Level != "ERR" || e[0].Message != "second" || e[1].Level != "INF" || e[1].Message != "third" {
+		t.Fatalf("expected the two most recent entries, but got %+v", e)
+	}
+}
+
+// Test that the ring logger keeps debug entries without passing them to a
+// logger with debug inactive
+func TestRingLogger_Debug_KeepsEntryWithoutPassingIt(t *testing.T) {
+	ml := NewMemLogger(false, false)
+	l := NewRingLogger(ml, 10, true, false)
+	if !l.IsDebug() || l.IsTrace() {
+		t.Fatal("expected debug to be active, but not trace")
+	}
+	l.Debug("debug")
+
+	e := l.Entries()
+	if len(e) != 1 || e[0].Level != "DBG" || e[0].Message != "debug" {
+		t.Fatalf("expected debug entry, but got %+v", e)
+	}
+	if strings.Contains(ml.String(), "debug") {
+		t.Fatalf("expected debug entry not to be passed on, but got:\n%s", ml.String())
+	}
+}
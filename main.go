@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -30,11 +31,13 @@ const (
 
 var usageStr = `
 Usage: resgate [options]
+       resgate verify [options] <resourceID> [<resourceID> ...]
 
 Server Options:
-    -n, --nats <url>                 NATS Server URL (default: nats://127.0.0.1:4222)
+    -n, --nats <url>[,<url>...]      NATS Server URLs, comma separated (default: nats://127.0.0.1:4222)
     -i  --addr <host>                Bind to HOST address (default: 0.0.0.0)
     -p, --port <port>                HTTP port for client connections (default: 8080)
+        --listen <host>:<port>       Additional address to listen on (may be repeated)
     -w, --wspath <path>              WebSocket path for clients (default: /)
     -a, --apipath <path>             Web resource path for clients (default: /api/)
     -r, --reqtimeout <milliseconds>  Timeout duration for NATS requests (default: 3000)
@@ -44,10 +47,14 @@ Server Options:
         --tlskey <file>              Private key for HTTP server certificate
         --apiencoding <type>         Encoding for web resources: json, jsonflat (default: json)
         --creds <file>               NATS User Credentials file
+        --natstlscert <file>         NATS client certificate file
+        --natstlskey <file>          Private key for NATS client certificate
+        --natsrootca <file>          NATS root CA certificate file
         --alloworigin <origin>       Allowed origin(s): *, or <scheme>://<hostname>[:<port>] (default: *)
         --putmethod <methodName>     Call method name mapped to HTTP PUT requests
         --deletemethod <methodName>  Call method name mapped to HTTP DELETE requests
         --patchmethod <methodName>   Call method name mapped to HTTP PATCH requests
+        --strict                     Reject service messages not following the RES protocol
     -c, --config <file>              Configuration file
 
 Logging Options:
@@ -59,16 +66,27 @@ Common Options:
     -h, --help                       Show this message
     -v, --version                    Show version
 
+Environment Variables:
+    Any configuration option may be set by an environment variable named
+    RESGATE_ followed by the option name in upper snake case, such as
+    RESGATE_NATS_URL or RESGATE_WS_PATH. Command line options take
+    precedence over environment variables, which take precedence over the
+    configuration file.
+
 Configuration Documentation:         https://resgate.io/docs/get-started/configuration/
 `
 
 // Config holds server configuration
 type Config struct {
-	NatsURL        string  `json:"natsUrl"`
-	NatsCreds      *string `json:"natsCreds"`
-	RequestTimeout int     `json:"requestTimeout"`
-	Debug          bool    `json:"debug"`
-	Trace          bool    `json:"trace"`
+	NatsURL        URLList               `json:"natsUrl"`
+	NatsCreds      *string               `json:"natsCreds"`
+	NatsTLSCert    *string               `json:"natsTlsCert"`
+	NatsTLSKey     *string               `json:"natsTlsKey"`
+	NatsRootCA     *string               `json:"natsRootCa"`
+	NatsReconnect  *nats.ReconnectConfig `json:"natsReconnect"`
+	RequestTimeout int                   `json:"requestTimeout"`
+	Debug          bool                  `json:"debug"`
+	Trace          bool                  `json:"trace"`
 	server.Config
 }
 
@@ -88,10 +106,56 @@ func (s *StringSlice) Set(v string) error {
 	return nil
 }
 
+// URLList is a list of URLs implementing the flag.Value interface, set from
+// a comma separated list. It is JSON encoded as a string if it holds a single
+// URL, and decoded from either a string or an array of strings.
+type URLList []string
+
+func (l *URLList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+// Set replaces the list with the comma separated URLs.
+func (l *URLList) Set(v string) error {
+	var urls []string
+	for _, u := range strings.Split(v, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	*l = urls
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (l URLList) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 {
+		return json.Marshal(l[0])
+	}
+	return json.Marshal([]string(l))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (l *URLList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return l.Set(s)
+	}
+	var urls []string
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return errors.New("natsUrl must be a string or an array of strings")
+	}
+	*l = urls
+	return nil
+}
+
 // SetDefault sets the default values
 func (c *Config) SetDefault() {
-	if c.NatsURL == "" {
-		c.NatsURL = DefaultNatsURL
+	if len(c.NatsURL) == 0 {
+		c.NatsURL = URLList{DefaultNatsURL}
 	}
 	if c.RequestTimeout == 0 {
 		c.RequestTimeout = DefaultRequestTimeout
@@ -110,8 +174,12 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 		headauth     string
 		addr         string
 		natsCreds    string
+		natsTLSCert  string
+		natsTLSKey   string
+		natsRootCA   string
 		debugTrace   bool
 		allowOrigin  StringSlice
+		listen       StringSlice
 		putMethod    string
 		deleteMethod string
 		patchMethod  string
@@ -121,12 +189,13 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 	fs.BoolVar(&showHelp, "help", false, "Show this message.")
 	fs.StringVar(&configFile, "c", "", "Configuration file.")
 	fs.StringVar(&configFile, "config", "", "Configuration file.")
-	fs.StringVar(&c.NatsURL, "n", "", "NATS Server URL.")
-	fs.StringVar(&c.NatsURL, "nats", "", "NATS Server URL.")
+	fs.Var(&c.NatsURL, "n", "NATS Server URL(s), comma separated.")
+	fs.Var(&c.NatsURL, "nats", "NATS Server URL(s), comma separated.")
 	fs.StringVar(&addr, "i", "", "Bind to HOST address.")
 	fs.StringVar(&addr, "addr", "", "Bind to HOST address.")
 	fs.UintVar(&port, "p", 0, "HTTP port for client connections.")
 	fs.UintVar(&port, "port", 0, "HTTP port for client connections.")
+	fs.Var(&listen, "listen", "Additional address to listen on.")
 	fs.StringVar(&c.WSPath, "w", "", "WebSocket path for clients.")
 	fs.StringVar(&c.WSPath, "wspath", "", "WebSocket path for clients.")
 	fs.StringVar(&c.APIPath, "a", "", "Web resource path for clients.")
@@ -140,10 +209,14 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 	fs.IntVar(&c.RequestTimeout, "r", 0, "Timeout in milliseconds for NATS requests.")
 	fs.IntVar(&c.RequestTimeout, "reqtimeout", 0, "Timeout in milliseconds for NATS requests.")
 	fs.StringVar(&natsCreds, "creds", "", "NATS User Credentials file.")
+	fs.StringVar(&natsTLSCert, "natstlscert", "", "NATS client certificate file.")
+	fs.StringVar(&natsTLSKey, "natstlskey", "", "Private key for NATS client certificate.")
+	fs.StringVar(&natsRootCA, "natsrootca", "", "NATS root CA certificate file.")
 	fs.Var(&allowOrigin, "alloworigin", "Allowed origin(s) for CORS.")
 	fs.StringVar(&putMethod, "putmethod", "", "Call method name mapped to HTTP PUT requests.")
 	fs.StringVar(&deleteMethod, "deletemethod", "", "Call method name mapped to HTTP DELETE requests.")
 	fs.StringVar(&patchMethod, "patchmethod", "", "Call method name mapped to HTTP PATCH requests.")
+	fs.BoolVar(&c.Strict, "strict", false, "Enable strict protocol validation.")
 	fs.BoolVar(&c.Debug, "D", false, "Enable debugging output.")
 	fs.BoolVar(&c.Debug, "debug", false, "Enable debugging output.")
 	fs.BoolVar(&c.Trace, "V", false, "Enable trace logging.")
@@ -183,12 +256,18 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 			if err != nil {
 				printAndDie(fmt.Sprintf("Error parsing config file: %s", err), false)
 			}
-
-			// Overwrite configFile options with command line options
-			fs.Parse(args)
 		}
 	}
 
+	// Overwrite configFile options with environment variables, and then with
+	// command line options. Repeatable options are reset to not be added
+	// twice.
+	if err := c.LoadEnv(os.LookupEnv); err != nil {
+		printAndDie(fmt.Sprintf("Error loading environment variables: %s", err), false)
+	}
+	listen, allowOrigin = nil, nil
+	fs.Parse(args)
+
 	if port > 0 {
 		c.Port = uint16(port)
 	}
@@ -209,6 +288,14 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 			setString(headauth, &c.HeaderAuth)
 		case "creds":
 			setString(natsCreds, &c.NatsCreds)
+		case "natstlscert":
+			setString(natsTLSCert, &c.NatsTLSCert)
+		case "natstlskey":
+			setString(natsTLSKey, &c.NatsTLSKey)
+		case "natsrootca":
+			setString(natsRootCA, &c.NatsRootCA)
+		case "listen":
+			c.Listen = listen
 		case "alloworigin":
 			str := allowOrigin.String()
 			c.AllowOrigin = &str
@@ -228,6 +315,15 @@ func (c *Config) Init(fs *flag.FlagSet, args []string) {
 		}
 	})
 
+	if (c.NatsTLSCert == nil) != (c.NatsTLSKey == nil) {
+		printAndDie("NATS client certificate requires both natsTlsCert and natsTlsKey to be set", false)
+	}
+	if c.NatsReconnect != nil {
+		if err := c.NatsReconnect.Validate(); err != nil {
+			printAndDie(fmt.Sprintf("Invalid natsReconnect setting: %s", err), false)
+		}
+	}
+
 	// Any value not set, set it now
 	c.SetDefault()
 
@@ -262,6 +358,11 @@ func printAndDie(msg string, showUsage bool) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		runVerify(os.Args[2:])
+		return
+	}
+
 	fs := flag.NewFlagSet("resgate", flag.ExitOnError)
 	fs.Usage = usage
 
@@ -277,8 +378,12 @@ func main() {
 		cfg.RequestTimeout *= 1000
 	}
 	serv, err := server.NewService(&nats.Client{
-		URL:            cfg.NatsURL,
+		URL:            cfg.NatsURL.String(),
 		Creds:          cfg.NatsCreds,
+		TLSCert:        cfg.NatsTLSCert,
+		TLSKey:         cfg.NatsTLSKey,
+		RootCA:         cfg.NatsRootCA,
+		Reconnect:      cfg.NatsReconnect,
 		RequestTimeout: time.Duration(cfg.RequestTimeout) * time.Millisecond,
 		Logger:         l,
 	}, cfg.Config)
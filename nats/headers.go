@@ -0,0 +1,84 @@
+package nats
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/rs/xid"
+)
+
+// Headers set on outgoing messages when the NATS server supports message
+// headers.
+const (
+	// HeaderInstanceID is the instance ID of the gateway.
+	HeaderInstanceID = "Resgate-Instance"
+	// HeaderCorrelationID is a unique ID of the message, included in the
+	// trace log of the gateway.
+	HeaderCorrelationID = "Resgate-Correlation-Id"
+	// HeaderTraceParent is a W3C Trace Context traceparent, starting a new
+	// trace for the message. The trace is flagged as sampled if the message
+	// is trace logged by the gateway.
+	HeaderTraceParent = "traceparent"
+)
+
+// SetInstanceID sets the gateway instance ID sent in the headers of
+// outgoing messages. It must be called before Connect.
+func (c *Client) SetInstanceID(id string) {
+	c.instanceID = id
+}
+
+// header returns the headers of an outgoing message on the subject, or nil
+// if message headers are not supported by the server, as negotiated on
+// connect.
+func (c *Client) header(nc *nats.Conn, subj string) nats.Header {
+	if !nc.HeadersSupported() {
+		return nil
+	}
+	h := nats.Header{}
+	if c.instanceID != "" {
+		h.Set(HeaderInstanceID, c.instanceID)
+	}
+	h.Set(HeaderCorrelationID, xid.New().String())
+	h.Set(HeaderTraceParent, traceParent(c.Logger.IsTrace() && (c.traceFilter == nil || c.traceFilter(subj))))
+	return h
+}
+
+// logHeaderSupport logs whether message headers are supported by the server
+// of the connection.
+func (c *Client) logHeaderSupport(nc *nats.Conn) {
+	if nc.HeadersSupported() {
+		c.Debugf("NATS server supports message headers")
+	} else {
+		c.Debugf("NATS server does not support message headers")
+	}
+}
+
+// publishMsg publishes a message, with the headers unless nil.
+func publishMsg(nc *nats.Conn, subj, reply string, h nats.Header, payload []byte) error {
+	if h == nil {
+		return nc.PublishRequest(subj, reply, payload)
+	}
+	return nc.PublishMsg(&nats.Msg{Subject: subj, Reply: reply, Header: h, Data: payload})
+}
+
+// correlationStr returns the correlation ID of the headers, formatted for
+// the trace log, or an empty string if there are no headers.
+func correlationStr(h nats.Header) string {
+	if h == nil {
+		return ""
+	}
+	return " [" + h.Get(HeaderCorrelationID) + "]"
+}
+
+// traceParent returns a W3C Trace Context traceparent value with a random
+// trace ID and parent ID.
+func traceParent(sampled bool) string {
+	var b [24]byte
+	rand.Read(b[:])
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(b[:16]) + "-" + hex.EncodeToString(b[16:]) + "-" + flags
+}
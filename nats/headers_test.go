@@ -0,0 +1,104 @@
+package nats
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/logger"
+)
+
+var traceParentPattern = regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-0[01]$`)
+
+// connectClient returns a client connected to the server.
+func connectClient(t *testing.T, s *fakeServer, l logger.Logger) *Client {
+	c := &Client{
+		RequestTimeout: time.Second,
+		URL:            s.URL(),
+		Logger:         l,
+	}
+	c.SetInstanceID("instance")
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPublishWithHeaders(t *testing.T) {
+	s := startFakeServer(t, true)
+	defer s.Close()
+	c := connectClient(t, s, logger.NewMemLogger(false, false))
+	defer c.Close()
+
+	if err := c.Publish("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	c.SendRequest("get.test.model", []byte("{}"), func(string, []byte, error) {})
+	if err := c.mq.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, pubs := s.Subjects()
+	hdrs := s.Headers()
+	if len(pubs) != 2 || pubs[0] != "foo: bar" || pubs[1] != "get.test.model: {}" {
+		t.Fatalf("expected messages without headers in payload, but got %q", pubs)
+	}
+	for i, h := range hdrs {
+		if h[HeaderInstanceID] != "instance" {
+			t.Errorf("message %d: expected header %s to be %q, but got %q", i, HeaderInstanceID, "instance", h[HeaderInstanceID])
+		}
+		if len(h[HeaderCorrelationID]) != 20 {
+			t.Errorf("message %d: expected header %s to be an ID, but got %q", i, HeaderCorrelationID, h[HeaderCorrelationID])
+		}
+		if !traceParentPattern.MatchString(h[HeaderTraceParent]) {
+			t.Errorf("message %d: expected header %s to be a traceparent, but got %q", i, HeaderTraceParent, h[HeaderTraceParent])
+		}
+	}
+	if hdrs[0][HeaderCorrelationID] == hdrs[1][HeaderCorrelationID] {
+		t.Errorf("expected unique correlation IDs, but got %q for both messages", hdrs[0][HeaderCorrelationID])
+	}
+}
+
+func TestPublishWithHeadersTracedIsSampled(t *testing.T) {
+	s := startFakeServer(t, true)
+	defer s.Close()
+	c := connectClient(t, s, logger.NewMemLogger(false, true))
+	c.SetTraceFilter(func(subj string) bool { return subj == "traced" })
+	defer c.Close()
+
+	c.Publish("traced", nil)
+	c.Publish("untraced", nil)
+	if err := c.mq.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	hdrs := s.Headers()
+	if len(hdrs) != 2 {
+		t.Fatalf("expected 2 messages, but got %d", len(hdrs))
+	}
+	for i, flags := range []string{"01", "00"} {
+		if tp := hdrs[i][HeaderTraceParent]; tp[len(tp)-2:] != flags {
+			t.Errorf("message %d: expected traceparent flags %s, but got %q", i, flags, tp)
+		}
+	}
+}
+
+func TestPublishWithoutHeaderSupport(t *testing.T) {
+	s := startFakeServer(t, false)
+	defer s.Close()
+	c := connectClient(t, s, logger.NewMemLogger(false, false))
+	defer c.Close()
+
+	if err := c.Publish("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.mq.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, pubs := s.Subjects()
+	hdrs := s.Headers()
+	if len(pubs) != 1 || pubs[0] != "foo: bar" || hdrs[0] != nil {
+		t.Fatalf("expected message without headers, but got %q with headers %v", pubs, hdrs)
+	}
+}
@@ -20,8 +20,12 @@ const (
 // Client holds a client connection to a nats server.
 type Client struct {
 	RequestTimeout time.Duration
-	URL            string
+	URL            string // Server URL, or comma separated list of server URLs
 	Creds          *string
+	TLSCert        *string
+	TLSKey         *string
+	RootCA         *string
+	Reconnect      *ReconnectConfig // Reconnect settings, or nil to only reconnect to other known servers
 	Logger         logger.Logger
 
 	mq           *nats.Conn
@@ -30,7 +34,14 @@ type Client struct {
 	tq           *timerqueue.Queue
 	mu           sync.Mutex
 	closeHandler func(error)
+	reconnected  func()
 	stopped      chan struct{}
+	traceFilter  func(string) bool
+	instanceID   string
+	reconnecting bool
+	stopReconn   chan struct{}
+	pending      []*pendingMsg
+	pendingSize  int
 }
 
 // Subscription implements the mq.Unsubscriber interface.
@@ -41,8 +52,10 @@ type Subscription struct {
 
 type responseCont struct {
 	isReq bool
+	subj  string
 	f     mq.Response
 	t     *time.Timer
+	us    *Subscription // Event subscription to resubscribe on reconnect
 }
 
 // Logf writes a formatted log message
@@ -64,6 +77,20 @@ func (c *Client) Tracef(format string, v ...interface{}) {
 	}
 }
 
+// tracef writes a formatted trace message for a message on the subject,
+// unless excluded by the trace filter.
+func (c *Client) tracef(subj string, format string, v ...interface{}) {
+	if c.Logger.IsTrace() && (c.traceFilter == nil || c.traceFilter(subj)) {
+		c.Logger.Trace(fmt.Sprintf(format, v...))
+	}
+}
+
+// SetTraceFilter sets a filter that returns true for subjects to trace.
+// It must be called before Connect.
+func (c *Client) SetTraceFilter(f func(subject string) bool) {
+	c.traceFilter = f
+}
+
 // Connect creates a connection to the nats server.
 func (c *Client) Connect() error {
 	c.mu.Lock()
@@ -71,29 +98,44 @@ func (c *Client) Connect() error {
 
 	c.Logf("Connecting to NATS at %s", c.URL)
 
-	// Create connection options
-	opts := []nats.Option{nats.NoReconnect(), nats.ClosedHandler(c.onClose)}
-	if c.Creds != nil {
-		opts = append(opts, nats.UserCredentials(*c.Creds))
-	}
-
-	// No reconnects as all resources are instantly stale anyhow
-	nc, err := nats.Connect(c.URL, opts...)
+	nc, err := c.dial(c.URL)
 	if err != nil {
 		return err
 	}
 
+	c.logHeaderSupport(nc)
 	c.mq = nc
 	c.mqCh = make(chan *nats.Msg, natsChannelSize)
 	c.mqReqs = make(map[*nats.Subscription]*responseCont)
 	c.tq = timerqueue.New(c.onTimeout, c.RequestTimeout)
 	c.stopped = make(chan struct{})
+	c.stopReconn = make(chan struct{})
 
 	go c.listener(c.mqCh, c.stopped)
 
 	return nil
 }
 
+// dial creates a new connection to any of the servers in the comma
+// separated list. Reconnecting is handled by the client rather than by
+// the connection, to allow backoff between attempts.
+func (c *Client) dial(url string) (*nats.Conn, error) {
+	opts := []nats.Option{
+		nats.NoReconnect(),
+		nats.ClosedHandler(c.onClose),
+	}
+	if c.Creds != nil {
+		opts = append(opts, nats.UserCredentials(*c.Creds))
+	}
+	if c.TLSCert != nil && c.TLSKey != nil {
+		opts = append(opts, nats.ClientCert(*c.TLSCert, *c.TLSKey))
+	}
+	if c.RootCA != nil {
+		opts = append(opts, nats.RootCAs(*c.RootCA))
+	}
+	return nats.Connect(url, opts...)
+}
+
 // IsClosed tests if the client connection has been closed.
 func (c *Client) IsClosed() bool {
 	c.mu.Lock()
@@ -120,6 +162,11 @@ func (c *Client) Close() {
 		c.Debugf("NATS connection closed")
 	}
 
+	if c.reconnecting {
+		c.dropPending()
+	}
+	close(c.stopReconn)
+
 	c.Debugf("Stopping NATS listener...")
 	close(c.mqCh)
 	c.mqCh = nil
@@ -145,9 +192,38 @@ func (c *Client) SetClosedHandler(cb func(error)) {
 	c.closeHandler = cb
 }
 
+// SetReconnectHandler sets the handler called when the client has
+// reconnected after losing the connection.
+func (c *Client) SetReconnectHandler(cb func()) {
+	c.reconnected = cb
+}
+
+// onClose starts reconnecting when the connection is lost, if a reconnect
+// strategy is configured or there are other servers to fail over to, either
+// given by URL or discovered from the cluster topology. Without any, the
+// close handler is called, as reconnecting to the same server gives no
+// benefit over restarting. All resources are instantly stale anyhow.
 func (c *Client) onClose(conn *nats.Conn) {
+	err := conn.LastError()
+	servers := conn.Servers()
+
+	c.mu.Lock()
+	if c.mq != conn {
+		// Closed by the client, or a failed reconnect attempt
+		c.mu.Unlock()
+		return
+	}
+	if c.Reconnect != nil || len(servers) > 1 {
+		c.reconnecting = true
+		stop := c.stopReconn
+		c.mu.Unlock()
+		c.Logf("Disconnected from NATS: %s", err)
+		go c.reconnect(servers, stop)
+		return
+	}
+	c.mu.Unlock()
+
 	if c.closeHandler != nil {
-		err := conn.LastError()
 		c.closeHandler(fmt.Errorf("lost NATS connection: %s", err))
 	}
 }
@@ -159,15 +235,36 @@ func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.reconnecting {
+		if err := c.buffer(subj, payload, cb); err != nil {
+			cb("", nil, err)
+		}
+		return
+	}
+	c.sendRequestInbox(subj, inbox, payload, cb)
+}
+
+// sendRequest sends a request with a new inbox.
+//
+// It must be called with c.mu locked.
+func (c *Client) sendRequest(subj string, payload []byte, cb mq.Response) {
+	c.sendRequestInbox(subj, nats.NewInbox(), payload, cb)
+}
+
+// sendRequestInbox sends a request to the MQ, with responses sent to inbox.
+//
+// It must be called with c.mu locked.
+func (c *Client) sendRequestInbox(subj string, inbox string, payload []byte, cb mq.Response) {
 	sub, err := c.mq.ChanSubscribe(inbox, c.mqCh)
 	if err != nil {
 		cb("", nil, err)
 		return
 	}
 
-	c.Tracef("<== (%s) %s: %s", inboxSubstr(inbox), subj, payload)
+	h := c.header(c.mq, subj)
+	c.tracef(subj, "<== (%s) %s%s: %s", inboxSubstr(inbox), subj, correlationStr(h), payload)
 
-	err = c.mq.PublishRequest(subj, inbox, payload)
+	err = publishMsg(c.mq, subj, inbox, h, payload)
 	if err != nil {
 		sub.Unsubscribe()
 		cb("", nil, err)
@@ -175,7 +272,24 @@ func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
 	}
 
 	c.tq.Add(sub)
-	c.mqReqs[sub] = &responseCont{isReq: true, f: cb}
+	c.mqReqs[sub] = &responseCont{isReq: true, subj: subj, f: cb}
+}
+
+// Publish sends a message to the MQ without expecting a response.
+func (c *Client) Publish(subj string, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.mq == nil {
+		return nats.ErrConnectionClosed
+	}
+	if c.reconnecting {
+		return c.buffer(subj, payload, nil)
+	}
+
+	h := c.header(c.mq, subj)
+	c.tracef(subj, "<=P %s%s: %s", subj, correlationStr(h), payload)
+	return publishMsg(c.mq, subj, "", h, payload)
 }
 
 // Subscribe to all events on a resource namespace.
@@ -189,11 +303,10 @@ func (c *Client) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, e
 		return nil, err
 	}
 
-	c.Tracef("S=> %s", sub.Subject)
-
-	c.mqReqs[sub] = &responseCont{f: cb}
+	c.tracef(namespace, "S=> %s", sub.Subject)
 
 	us := &Subscription{c: c, sub: sub}
+	c.mqReqs[sub] = &responseCont{f: cb, us: us}
 	return us, nil
 }
 
@@ -202,9 +315,13 @@ func (s *Subscription) Unsubscribe() error {
 	s.c.mu.Lock()
 	defer s.c.mu.Unlock()
 
-	s.c.Tracef("U=> %s", s.sub.Subject)
+	s.c.tracef(s.sub.Subject, "U=> %s", s.sub.Subject)
 
 	delete(s.c.mqReqs, s.sub)
+	if s.c.reconnecting {
+		// The subscription was lost with the connection
+		return nil
+	}
 	return s.sub.Unsubscribe()
 }
 
@@ -218,7 +335,7 @@ func (c *Client) listener(ch chan *nats.Msg, stopped chan struct{}) {
 			if len(msg.Data) > 0 && (msg.Data[0]|32)-'a' < 26 {
 				c.parseMeta(msg, rc)
 				c.mu.Unlock()
-				c.Tracef("==> (%s): %s", inboxSubstr(msg.Subject), msg.Data)
+				c.tracef(rc.subj, "==> (%s): %s", inboxSubstr(msg.Subject), msg.Data)
 				continue
 			}
 
@@ -233,9 +350,9 @@ func (c *Client) listener(ch chan *nats.Msg, stopped chan struct{}) {
 
 		if ok {
 			if rc.isReq {
-				c.Tracef("==> (%s): %s", inboxSubstr(msg.Subject), msg.Data)
+				c.tracef(rc.subj, "==> (%s): %s", inboxSubstr(msg.Subject), msg.Data)
 			} else {
-				c.Tracef("=>> %s: %s", msg.Subject, msg.Data)
+				c.tracef(msg.Subject, "=>> %s: %s", msg.Subject, msg.Data)
 			}
 			rc.f(msg.Subject, msg.Data, nil)
 		}
@@ -283,7 +400,7 @@ func (c *Client) onTimeout(v interface{}) {
 	}
 	sub.Unsubscribe()
 
-	c.Tracef("x=> (%s) Request timeout", inboxSubstr(sub.Subject))
+	c.tracef(rc.subj, "x=> (%s) Request timeout", inboxSubstr(sub.Subject))
 	rc.f("", nil, mq.ErrRequestTimeout)
 }
 
@@ -0,0 +1,257 @@
+package nats
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/resgateio/resgate/server/mq"
+)
+
+const (
+	// DefaultReconnectAttempts is the default number of attempts to
+	// reconnect before giving up. Same as nats.DefaultMaxReconnect.
+	DefaultReconnectAttempts = 60
+	// DefaultReconnectWait is the default time in milliseconds to wait
+	// between reconnect attempts. Same as nats.DefaultReconnectWait.
+	DefaultReconnectWait = 2000
+	// DefaultReconnectBufferSize is the default size in bytes of the buffer
+	// holding outgoing messages while reconnecting. Same as
+	// nats.DefaultReconnectBufSize.
+	DefaultReconnectBufferSize = 8 * 1024 * 1024
+)
+
+// ReconnectConfig holds the configuration for reconnecting to NATS when the
+// connection is lost. A zero value uses the default for the setting.
+type ReconnectConfig struct {
+	// MaxAttempts is the number of attempts to reconnect before giving up.
+	// Each attempt tries all known servers. A value of -1 retries
+	// indefinitely.
+	MaxAttempts int `json:"maxAttempts"`
+	// Wait is the time in milliseconds to wait between reconnect attempts.
+	Wait int `json:"wait"`
+	// MaxWait is the maximum time in milliseconds to wait between reconnect
+	// attempts. If greater than Wait, the wait is doubled for each failed
+	// attempt until reaching MaxWait.
+	MaxWait int `json:"maxWait"`
+	// Jitter is the maximum random time in milliseconds added to each wait,
+	// to avoid gateways reconnecting at the same time.
+	Jitter int `json:"jitter"`
+	// BufferSize is the size in bytes of the buffer holding outgoing
+	// requests and published messages while reconnecting. A value of -1
+	// disables buffering.
+	BufferSize int `json:"bufferSize"`
+}
+
+// pendingMsg is an outgoing message buffered while reconnecting.
+type pendingMsg struct {
+	subj    string
+	payload []byte
+	cb      mq.Response // Nil for published messages
+	t       *time.Timer
+	done    bool
+}
+
+// Validate returns an error if any of the settings is invalid.
+func (rc *ReconnectConfig) Validate() error {
+	if rc.MaxAttempts < -1 {
+		return errors.New("maxAttempts must be -1 or a positive number")
+	}
+	if rc.Wait < 0 {
+		return errors.New("wait must be zero or a positive number of milliseconds")
+	}
+	if rc.MaxWait < 0 {
+		return errors.New("maxWait must be zero or a positive number of milliseconds")
+	}
+	if rc.Jitter < 0 {
+		return errors.New("jitter must be zero or a positive number of milliseconds")
+	}
+	if rc.BufferSize < -1 {
+		return errors.New("bufferSize must be -1 or a positive number of bytes")
+	}
+	return nil
+}
+
+// settings returns the reconnect configuration with defaults set. A nil
+// config uses the default for all settings.
+func (rc *ReconnectConfig) settings() ReconnectConfig {
+	var s ReconnectConfig
+	if rc != nil {
+		s = *rc
+	}
+	if s.MaxAttempts == 0 {
+		s.MaxAttempts = DefaultReconnectAttempts
+	}
+	if s.Wait == 0 {
+		s.Wait = DefaultReconnectWait
+	}
+	if s.BufferSize == 0 {
+		s.BufferSize = DefaultReconnectBufferSize
+	}
+	return s
+}
+
+// delay returns the time to wait before the reconnect attempt, counting
+// from zero.
+func (rc ReconnectConfig) delay(attempt int) time.Duration {
+	if attempt == 0 {
+		return 0
+	}
+	wait := rc.Wait
+	for i := 1; i < attempt && wait < rc.MaxWait; i++ {
+		wait *= 2
+	}
+	if rc.MaxWait > rc.Wait && wait > rc.MaxWait {
+		wait = rc.MaxWait
+	}
+	if rc.Jitter > 0 {
+		wait += rand.Intn(rc.Jitter + 1)
+	}
+	return time.Duration(wait) * time.Millisecond
+}
+
+// reconnect tries to connect to any of the servers until succeeding, giving
+// up after the configured number of attempts. It stops if the client is
+// closed.
+func (c *Client) reconnect(servers []string, stop chan struct{}) {
+	rc := c.Reconnect.settings()
+	url := strings.Join(servers, ",")
+	for attempt := 0; rc.MaxAttempts < 0 || attempt < rc.MaxAttempts; attempt++ {
+		select {
+		case <-stop:
+			return
+		case <-time.After(rc.delay(attempt)):
+		}
+
+		nc, err := c.dial(url)
+		if err != nil {
+			c.Debugf("Failed to reconnect to NATS: %s", err)
+			continue
+		}
+		if !c.swapConn(nc) {
+			nc.Close()
+			return
+		}
+		c.Logf("Reconnected to NATS at %s", nc.ConnectedUrl())
+		if c.reconnected != nil {
+			c.reconnected()
+		}
+		return
+	}
+
+	c.mu.Lock()
+	c.dropPending()
+	c.mu.Unlock()
+	if c.closeHandler != nil {
+		c.closeHandler(errors.New("lost NATS connection: reconnect attempts exhausted"))
+	}
+}
+
+// swapConn replaces the lost connection with the new one, subscribing
+// again to all event subscriptions and sending any buffered messages.
+// Returns false if the client has been closed.
+func (c *Client) swapConn(nc *nats.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.mq == nil {
+		return false
+	}
+
+	c.logHeaderSupport(nc)
+	c.mq = nc
+	c.reconnecting = false
+
+	// Subscriptions are added to a new map, as keys added to a map while
+	// ranging over it may be visited by the same loop.
+	reqs := make(map[*nats.Subscription]*responseCont, len(c.mqReqs))
+	for sub, rc := range c.mqReqs {
+		// Pending requests to the lost connection are left to time out.
+		if rc.isReq {
+			reqs[sub] = rc
+			continue
+		}
+		nsub, err := nc.ChanSubscribe(sub.Subject, c.mqCh)
+		if err != nil {
+			c.Logf("Failed to subscribe to %s: %s", sub.Subject, err)
+			reqs[sub] = rc
+			continue
+		}
+		rc.us.sub = nsub
+		reqs[nsub] = rc
+	}
+	c.mqReqs = reqs
+
+	pending := c.pending
+	c.pending = nil
+	c.pendingSize = 0
+	for _, pm := range pending {
+		if pm.done {
+			continue
+		}
+		pm.done = true
+		if pm.cb == nil {
+			h := c.header(nc, pm.subj)
+			c.tracef(pm.subj, "<=P %s%s: %s", pm.subj, correlationStr(h), pm.payload)
+			publishMsg(nc, pm.subj, "", h, pm.payload)
+			continue
+		}
+		pm.t.Stop()
+		c.sendRequest(pm.subj, pm.payload, pm.cb)
+	}
+	return true
+}
+
+// buffer adds an outgoing message to the buffer while reconnecting. A
+// request responds with a timeout error, unless sent within the request
+// timeout. Returns nats.ErrReconnectBufExceeded if the buffer is full.
+//
+// It must be called with c.mu locked.
+func (c *Client) buffer(subj string, payload []byte, cb mq.Response) error {
+	size := c.Reconnect.settings().BufferSize
+	if size < 0 || c.pendingSize+len(payload) > size {
+		return nats.ErrReconnectBufExceeded
+	}
+	pm := &pendingMsg{subj: subj, payload: payload, cb: cb}
+	if cb != nil {
+		pm.t = time.AfterFunc(c.RequestTimeout, func() {
+			c.mu.Lock()
+			done := pm.done
+			if !done {
+				pm.done = true
+				c.pendingSize -= len(pm.payload)
+			}
+			c.mu.Unlock()
+			if !done {
+				c.tracef(subj, "x=> Buffered request timeout: %s", subj)
+				cb("", nil, mq.ErrRequestTimeout)
+			}
+		})
+	}
+	c.pending = append(c.pending, pm)
+	c.pendingSize += len(payload)
+	return nil
+}
+
+// dropPending discards all buffered messages, responding to requests with
+// a connection closed error.
+//
+// It must be called with c.mu locked.
+func (c *Client) dropPending() {
+	pending := c.pending
+	c.pending = nil
+	c.pendingSize = 0
+	c.reconnecting = false
+	for _, pm := range pending {
+		if pm.done {
+			continue
+		}
+		pm.done = true
+		if pm.cb != nil {
+			pm.t.Stop()
+			go pm.cb("", nil, nats.ErrConnectionClosed)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package nats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// fakeServer is a NATS server speaking just enough of the protocol to
+// accept connections, recording subscribed and published subjects, and the
+// headers of published messages.
+type fakeServer struct {
+	l       net.Listener
+	headers bool
+	mu      sync.Mutex
+	subs    []string
+	pubs    []string
+	hdrs    []map[string]string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	return startFakeServer(t, false)
+}
+
+// startFakeServer starts a fake server, with or without support for
+// message headers.
+func startFakeServer(t *testing.T, headers bool) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{l: l, headers: headers}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) URL() string {
+	return "nats://" + s.l.Addr().String()
+}
+
+func (s *fakeServer) Close() {
+	s.l.Close()
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.2.0\",\"max_payload\":1048576,\"headers\":%t}\r\n", s.headers)
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+			s.mu.Lock()
+			s.subs = append(s.subs, args[1])
+			s.mu.Unlock()
+		case "PUB", "HPUB":
+			var hlen int
+			if args[0] == "HPUB" {
+				hlen, _ = strconv.Atoi(args[len(args)-2])
+			}
+			n, _ := strconv.Atoi(args[len(args)-1])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			var hdr map[string]string
+			if hlen > 0 {
+				hdr = make(map[string]string)
+				for _, l := range strings.Split(string(payload[:hlen]), "\r\n")[1:] {
+					if i := strings.Index(l, ": "); i > 0 {
+						hdr[l[:i]] = l[i+2:]
+					}
+				}
+			}
+			s.mu.Lock()
+			s.pubs = append(s.pubs, args[1]+": "+string(payload[hlen:n]))
+			s.hdrs = append(s.hdrs, hdr)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Subjects returns the sorted subscribed subjects, excluding inboxes, and
+// the published messages.
+func (s *fakeServer) Subjects() (subs []string, pubs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		if !strings.HasPrefix(sub, "_INBOX.") {
+			subs = append(subs, sub)
+		}
+	}
+	sort.Strings(subs)
+	return subs, append(pubs, s.pubs...)
+}
+
+// Headers returns the headers of the published messages, with nil for
+// messages without headers.
+func (s *fakeServer) Headers() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]string(nil), s.hdrs...)
+}
+
+// connectReconnecting returns a client connected to the server, set as
+// having lost the connection.
+func connectReconnecting(t *testing.T, s *fakeServer, rc *ReconnectConfig) *Client {
+	c := &Client{
+		RequestTimeout: time.Second,
+		URL:            s.URL(),
+		Reconnect:      rc,
+		Logger:         logger.NewMemLogger(false, false),
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	c.reconnecting = true
+	c.mu.Unlock()
+	return c
+}
+
+func TestReconnectConfigValidate(t *testing.T) {
+	tbl := []struct {
+		Config ReconnectConfig
+		Valid  bool
+	}{
+		{ReconnectConfig{}, true},
+		{ReconnectConfig{MaxAttempts: -1, Wait: 100, MaxWait: 1000, Jitter: 50, BufferSize: -1}, true},
+		{ReconnectConfig{MaxAttempts: -2}, false},
+		{ReconnectConfig{Wait: -1}, false},
+		{ReconnectConfig{MaxWait: -1}, false},
+		{ReconnectConfig{Jitter: -1}, false},
+		{ReconnectConfig{BufferSize: -2}, false},
+	}
+	for i, l := range tbl {
+		err := l.Config.Validate()
+		if l.Valid && err != nil {
+			t.Errorf("test %d: expected no error, but got %s", i+1, err)
+		}
+		if !l.Valid && err == nil {
+			t.Errorf("test %d: expected an error, but got none", i+1)
+		}
+	}
+}
+
+func TestReconnectConfigSettings(t *testing.T) {
+	tbl := []struct {
+		Config   *ReconnectConfig
+		Expected ReconnectConfig
+	}{
+		{nil, ReconnectConfig{MaxAttempts: DefaultReconnectAttempts, Wait: DefaultReconnectWait, BufferSize: DefaultReconnectBufferSize}},
+		{&ReconnectConfig{}, ReconnectConfig{MaxAttempts: DefaultReconnectAttempts, Wait: DefaultReconnectWait, BufferSize: DefaultReconnectBufferSize}},
+		{&ReconnectConfig{MaxAttempts: -1, Wait: 100, MaxWait: 1000, Jitter: 50, BufferSize: -1}, ReconnectConfig{MaxAttempts: -1, Wait: 100, MaxWait: 1000, Jitter: 50, BufferSize: -1}},
+	}
+	for i, l := range tbl {
+		if s := l.Config.settings(); s != l.Expected {
+			t.Errorf("test %d: expected settings %+v, but got %+v", i+1, l.Expected, s)
+		}
+	}
+}
+
+func TestReconnectConfigDelay(t *testing.T) {
+	tbl := []struct {
+		Wait     int
+		MaxWait  int
+		Attempt  int
+		Expected time.Duration
+	}{
+		{100, 0, 0, 0},
+		{100, 0, 1, 100 * time.Millisecond},
+		{100, 0, 5, 100 * time.Millisecond},
+		{100, 50, 3, 100 * time.Millisecond},
+		{100, 1000, 0, 0},
+		{100, 1000, 1, 100 * time.Millisecond},
+		{100, 1000, 2, 200 * time.Millisecond},
+		{100, 1000, 4, 800 * time.Millisecond},
+		{100, 1000, 5, 1000 * time.Millisecond},
+		{100, 1000, 50, 1000 * time.Millisecond},
+	}
+	for i, l := range tbl {
+		rc := ReconnectConfig{Wait: l.Wait, MaxWait: l.MaxWait}
+		if d := rc.delay(l.Attempt); d != l.Expected {
+			t.Errorf("test %d: expected delay %s, but got %s", i+1, l.Expected, d)
+		}
+	}
+}
+
+func TestReconnectConfigDelayWithJitter(t *testing.T) {
+	rc := ReconnectConfig{Wait: 100, MaxWait: 400, Jitter: 50}
+	for i := 0; i < 100; i++ {
+		d := rc.delay(3)
+		if d < 400*time.Millisecond || d > 450*time.Millisecond {
+			t.Fatalf("expected delay between 400ms and 450ms, but got %s", d)
+		}
+	}
+}
+
+func TestBufferExceedingSize(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := connectReconnecting(t, s, &ReconnectConfig{BufferSize: 5})
+	defer c.Close()
+
+	if err := c.Publish("foo", []byte("123")); err != nil {
+		t.Fatalf("expected no error, but got %s", err)
+	}
+	if err := c.Publish("bar", []byte("456")); err != nats.ErrReconnectBufExceeded {
+		t.Fatalf("expected error %s, but got %v", nats.ErrReconnectBufExceeded, err)
+	}
+	var rerr error
+	c.SendRequest("baz", []byte("789"), func(_ string, _ []byte, err error) { rerr = err })
+	if rerr != nats.ErrReconnectBufExceeded {
+		t.Fatalf("expected request error %s, but got %v", nats.ErrReconnectBufExceeded, rerr)
+	}
+}
+
+func TestBufferDisabled(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := connectReconnecting(t, s, &ReconnectConfig{BufferSize: -1})
+	defer c.Close()
+
+	if err := c.Publish("foo", nil); err != nats.ErrReconnectBufExceeded {
+		t.Fatalf("expected error %s, but got %v", nats.ErrReconnectBufExceeded, err)
+	}
+}
+
+func TestBufferedRequestTimeout(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := connectReconnecting(t, s, nil)
+	c.RequestTimeout = 10 * time.Millisecond
+	defer c.Close()
+
+	ch := make(chan error, 1)
+	c.SendRequest("foo", []byte("123"), func(_ string, _ []byte, err error) { ch <- err })
+	select {
+	case err := <-ch:
+		if err != mq.ErrRequestTimeout {
+			t.Fatalf("expected error %s, but got %v", mq.ErrRequestTimeout, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected buffered request to time out")
+	}
+	c.mu.Lock()
+	size := c.pendingSize
+	c.mu.Unlock()
+	if size != 0 {
+		t.Fatalf("expected buffer size 0 after timeout, but got %d", size)
+	}
+}
+
+func TestCloseDropsBufferedRequests(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	c := connectReconnecting(t, s, nil)
+
+	ch := make(chan error, 1)
+	c.SendRequest("foo", nil, func(_ string, _ []byte, err error) { ch <- err })
+	c.Close()
+	select {
+	case err := <-ch:
+		if err != nats.ErrConnectionClosed {
+			t.Fatalf("expected error %s, but got %v", nats.ErrConnectionClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected buffered request to be dropped on close")
+	}
+}
+
+func TestSwapConnSubscribesOnce(t *testing.T) {
+	s1 := newFakeServer(t)
+	defer s1.Close()
+	s2 := newFakeServer(t)
+	defer s2.Close()
+	c := connectReconnecting(t, s1, nil)
+	defer c.Close()
+
+	cb := func(string, []byte, error) {}
+	var expected []string
+	for i := 0; i < 100; i++ {
+		ns := fmt.Sprintf("event.test.model%d", i)
+		c.mu.Lock()
+		c.reconnecting = false
+		c.mu.Unlock()
+		if _, err := c.Subscribe(ns, cb); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, ns+".*")
+	}
+	sort.Strings(expected)
+	c.SendRequest("get.test.model", nil, cb)
+	c.mu.Lock()
+	c.reconnecting = true
+	c.mu.Unlock()
+
+	nc, err := c.dial(s2.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.swapConn(nc) {
+		t.Fatal("expected swapConn to return true")
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, _ := s2.Subjects()
+	if strings.Join(subs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected subscriptions:\n%s\nbut got:\n%s", expected, subs)
+	}
+	c.mu.Lock()
+	n := len(c.mqReqs)
+	for sub, rc := range c.mqReqs {
+		if !rc.isReq && (rc.us.sub != sub || !sub.IsValid()) {
+			t.Errorf("expected subscription %s to be replaced", sub.Subject)
+		}
+	}
+	c.mu.Unlock()
+	if n != len(expected)+1 {
+		t.Fatalf("expected %d subscriptions and requests, but got %d", len(expected)+1, n)
+	}
+}
+
+func TestSwapConnSendsBufferedMessages(t *testing.T) {
+	s1 := newFakeServer(t)
+	defer s1.Close()
+	s2 := newFakeServer(t)
+	defer s2.Close()
+	c := connectReconnecting(t, s1, nil)
+	defer c.Close()
+
+	if err := c.Publish("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	c.SendRequest("get.test.model", []byte("{}"), func(string, []byte, error) {})
+
+	nc, err := c.dial(s2.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.swapConn(nc) {
+		t.Fatal("expected swapConn to return true")
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, pubs := s2.Subjects()
+	expected := []string{"foo: bar", "get.test.model: {}"}
+	if strings.Join(pubs, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected published messages %q, but got %q", expected, pubs)
+	}
+	c.mu.Lock()
+	pending, size, reconnecting := len(c.pending), c.pendingSize, c.reconnecting
+	c.mu.Unlock()
+	if pending != 0 || size != 0 || reconnecting {
+		t.Fatalf("expected empty buffer after swap, but got %d messages of %d bytes", pending, size)
+	}
+}
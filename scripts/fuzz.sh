@@ -0,0 +1,12 @@
+#!/bin/bash -e
+# Run from directory above via ./scripts/fuzz.sh [fuzztime]
+# Requires Go 1.18 or later.
+
+FUZZTIME=${1:-30s}
+
+for pkg in ./server/codec ./server/rpc; do
+    for target in $(go test -list '^Fuzz' $pkg | grep '^Fuzz'); do
+        echo "Fuzzing $pkg $target for $FUZZTIME..."
+        go test $pkg -run '^$' -fuzz "^$target\$" -fuzztime $FUZZTIME
+    done
+done
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func (s *Service) initAdminServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/maintenance", s.adminMaintenanceHandler)
+	mux.HandleFunc("/blocked", s.adminBlockedHandler)
+	mux.HandleFunc("/canary", s.adminCanaryHandler)
+	mux.HandleFunc("/shadow", s.adminShadowHandler)
+	mux.HandleFunc("/peers", s.adminPeersHandler)
+	mux.HandleFunc("/bandwidth", s.adminBandwidthHandler)
+	mux.HandleFunc("/bruteforce", s.adminBruteForceHandler)
+	mux.HandleFunc("/quarantine", s.adminQuarantineHandler)
+	mux.HandleFunc("/latency", s.adminLatencyHandler)
+	mux.HandleFunc("/payloads", s.adminPayloadsHandler)
+	mux.HandleFunc("/neverupdated", s.adminNeverUpdatedHandler)
+	mux.HandleFunc("/allocations", s.adminAllocationsHandler)
+	mux.HandleFunc("/events", s.adminEventsHandler)
+	mux.HandleFunc("/logs", s.adminLogsHandler)
+	s.adminMux = mux
+}
+
+// AdminHandler returns the admin endpoint http.Handler
+// Used for testing purposes
+func (s *Service) AdminHandler() http.Handler {
+	return s.adminMux
+}
+
+// startAdminServer starts a goroutine with a http server for the admin
+// endpoint, if an admin port is configured.
+// Service.mu is held when called
+func (s *Service) startAdminServer() {
+	if s.cfg.NoHTTP || s.cfg.adminNetAddr == "" {
+		return
+	}
+
+	s.Logf("Admin endpoint listening on http://%s", s.cfg.adminNetAddr)
+	h := &http.Server{Addr: s.cfg.adminNetAddr, Handler: s.adminMux}
+	s.adminH = h
+
+	go func() {
+		if err := h.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.Stop(err)
+		}
+	}()
+}
+
+// stopAdminServer stops the admin http server
+func (s *Service) stopAdminServer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.adminH == nil {
+		return
+	}
+
+	s.Debugf("Stopping admin server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.adminH.Shutdown(ctx)
+	s.adminH = nil
+}
+
+// adminResponse writes a JSON encoded admin response.
+func adminResponse(w http.ResponseWriter, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, reserr.InternalError(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(out)
+}
+
+// adminError writes a JSON encoded error as an admin response.
+func adminError(w http.ResponseWriter, code int, err error) {
+	out, _ := json.Marshal(reserr.RESError(err))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(out)
+}
+
+// decodeAdminRequest decodes a JSON encoded admin request body into v.
+// On failure, an error response is written and false is returned.
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		adminError(w, http.StatusBadRequest, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding request body: " + err.Error()})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Default allocation audit settings
+const (
+	AllocationAuditSampleRate = 100
+	AllocationAuditTop        = 20
+)
+
+// AllocationAuditConfig holds the configuration for auditing the memory
+// allocations made on the hot path, while handling client requests and
+// service events.
+type AllocationAuditConfig struct {
+	// Enabled starts the gateway with the allocation audit enabled. The audit
+	// may also be enabled or disabled through the admin endpoint.
+	Enabled bool `json:"enabled,omitempty"`
+	// SampleRate is the number of messages per measured message.
+	// Defaults to 100.
+	SampleRate int `json:"sampleRate,omitempty"`
+	// Top is the number of top allocating functions reported.
+	// Defaults to 20.
+	Top int `json:"top,omitempty"`
+}
+
+// allocAudit holds the allocation audit state. Sampled messages are measured
+// by the difference in the runtime memory statistics before and after being
+// handled, which is approximate as it includes the allocations of any other
+// goroutine running meanwhile.
+type allocAudit struct {
+	enabled int32
+	rate    uint64
+	count   uint64
+	top     int
+
+	mu       sync.Mutex
+	since    time.Time
+	types    map[string]*allocTypeStats
+	baseline map[string]allocFuncStats
+}
+
+type allocTypeStats struct {
+	Type             string  `json:"type"`
+	Samples          int64   `json:"samples"`
+	Allocs           uint64  `json:"allocs"`
+	Bytes            uint64  `json:"bytes"`
+	AllocsPerMessage float64 `json:"allocsPerMessage"`
+	BytesPerMessage  float64 `json:"bytesPerMessage"`
+}
+
+type allocFuncStats struct {
+	Function string `json:"function"`
+	Allocs   int64  `json:"allocs"`
+	Bytes    int64  `json:"bytes"`
+}
+
+type allocAuditStats struct {
+	Enabled       bool             `json:"enabled"`
+	SampleRate    int              `json:"sampleRate"`
+	Since         *time.Time       `json:"since,omitempty"`
+	Messages      []allocTypeStats `json:"messages"`
+	TopAllocators []allocFuncStats `json:"topAllocators"`
+}
+
+type allocAuditRequest struct {
+	Enabled    bool `json:"enabled"`
+	SampleRate int  `json:"sampleRate,omitempty"`
+}
+
+// allocRequestTypes are the client request actions audited by name. Other
+// actions are audited as request.invalid.
+var allocRequestTypes = map[string]string{
+	"version":     "request.version",
+	"export":      "request.export",
+	"import":      "request.import",
+	"cancel":      "request.cancel",
+	"get":         "request.get",
+	"subscribe":   "request.subscribe",
+	"unsubscribe": "request.unsubscribe",
+	"call":        "request.call",
+	"auth":        "request.auth",
+	"new":         "request.new",
+}
+
+// allocEventTypes are the service events audited by name. Other events are
+// audited as event.custom.
+var allocEventTypes = map[string]string{
+	"change":   "event.change",
+	"add":      "event.add",
+	"remove":   "event.remove",
+	"delete":   "event.delete",
+	"create":   "event.create",
+	"reaccess": "event.reaccess",
+}
+
+// resgatePackage is the package path prefix of functions attributed
+// allocations in the top allocators.
+const resgatePackage = "github.com/resgateio/resgate/"
+
+// prepare validates the allocation audit configuration and sets default
+// values.
+func (c *AllocationAuditConfig) prepare() error {
+	if c.SampleRate < 0 || c.Top < 0 {
+		return errors.New("sampleRate and top must be zero or a positive number")
+	}
+	if c.SampleRate == 0 {
+		c.SampleRate = AllocationAuditSampleRate
+	}
+	if c.Top == 0 {
+		c.Top = AllocationAuditTop
+	}
+	return nil
+}
+
+// initAllocationAudit creates the allocation audit state, enabled if
+// configured to be.
+func (s *Service) initAllocationAudit() {
+	cfg := AllocationAuditConfig{SampleRate: AllocationAuditSampleRate, Top: AllocationAuditTop}
+	if s.cfg.AllocationAudit != nil {
+		cfg = *s.cfg.AllocationAudit
+	}
+	s.allocAudit = &allocAudit{rate: uint64(cfg.SampleRate), top: cfg.Top}
+	if cfg.Enabled {
+		s.allocAudit.enable(cfg.SampleRate)
+	}
+}
+
+// enable resets the audit statistics and enables the audit.
+func (a *allocAudit) enable(rate int) {
+	baseline := a.profile()
+	a.mu.Lock()
+	a.since = time.Now()
+	a.types = make(map[string]*allocTypeStats)
+	a.baseline = baseline
+	a.mu.Unlock()
+	atomic.StoreUint64(&a.rate, uint64(rate))
+	atomic.StoreInt32(&a.enabled, 1)
+}
+
+// disable disables the audit, keeping the statistics.
+func (a *allocAudit) disable() {
+	atomic.StoreInt32(&a.enabled, 0)
+}
+
+// start returns a function to call with the message type once the message is
+// handled, if the audit is enabled and the message is sampled. Otherwise nil
+// is returned.
+func (a *allocAudit) start() func(typ string) {
+	if a == nil || atomic.LoadInt32(&a.enabled) == 0 {
+		return nil
+	}
+	if atomic.AddUint64(&a.count, 1)%atomic.LoadUint64(&a.rate) != 0 {
+		return nil
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	mallocs, bytes := ms.Mallocs, ms.TotalAlloc
+	return func(typ string) {
+		runtime.ReadMemStats(&ms)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		st, ok := a.types[typ]
+		if !ok {
+			st = &allocTypeStats{Type: typ}
+			a.types[typ] = st
+		}
+		st.Samples++
+		st.Allocs += ms.Mallocs - mallocs
+		st.Bytes += ms.TotalAlloc - bytes
+	}
+}
+
+// profile returns the allocations of the heap profile, attributed to the
+// innermost resgate function of each stack.
+func (a *allocAudit) profile() map[string]allocFuncStats {
+	var p []runtime.MemProfileRecord
+	n, _ := runtime.MemProfile(nil, true)
+	for {
+		var ok bool
+		p = make([]runtime.MemProfileRecord, n+50)
+		if n, ok = runtime.MemProfile(p, true); ok {
+			p = p[:n]
+			break
+		}
+	}
+	fns := make(map[string]allocFuncStats)
+	for i := range p {
+		frames := runtime.CallersFrames(p[i].Stack())
+		for {
+			f, more := frames.Next()
+			if strings.HasPrefix(f.Function, resgatePackage) {
+				st := fns[f.Function]
+				st.Function = f.Function
+				st.Allocs += p[i].AllocObjects
+				st.Bytes += p[i].AllocBytes
+				fns[f.Function] = st
+				break
+			}
+			if !more {
+				break
+			}
+		}
+	}
+	return fns
+}
+
+// stats returns the audit statistics, with the message types and top
+// allocators sorted by bytes allocated.
+func (a *allocAudit) stats() allocAuditStats {
+	st := allocAuditStats{
+		Enabled:       atomic.LoadInt32(&a.enabled) == 1,
+		SampleRate:    int(atomic.LoadUint64(&a.rate)),
+		Messages:      []allocTypeStats{},
+		TopAllocators: []allocFuncStats{},
+	}
+	fns := a.profile()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.types == nil {
+		return st
+	}
+	since := a.since
+	st.Since = &since
+	for _, t := range a.types {
+		ts := *t
+		ts.AllocsPerMessage = float64(ts.Allocs) / float64(ts.Samples)
+		ts.BytesPerMessage = float64(ts.Bytes) / float64(ts.Samples)
+		st.Messages = append(st.Messages, ts)
+	}
+	for fn, f := range fns {
+		b := a.baseline[fn]
+		f.Allocs -= b.Allocs
+		f.Bytes -= b.Bytes
+		if f.Bytes > 0 {
+			st.TopAllocators = append(st.TopAllocators, f)
+		}
+	}
+	sort.Slice(st.Messages, func(i, j int) bool {
+		if st.Messages[i].Bytes != st.Messages[j].Bytes {
+			return st.Messages[i].Bytes > st.Messages[j].Bytes
+		}
+		return st.Messages[i].Type < st.Messages[j].Type
+	})
+	sort.Slice(st.TopAllocators, func(i, j int) bool {
+		if st.TopAllocators[i].Bytes != st.TopAllocators[j].Bytes {
+			return st.TopAllocators[i].Bytes > st.TopAllocators[j].Bytes
+		}
+		return st.TopAllocators[i].Function < st.TopAllocators[j].Function
+	})
+	if len(st.TopAllocators) > a.top {
+		st.TopAllocators = st.TopAllocators[:a.top]
+	}
+	return st
+}
+
+// StartAudit starts the allocation audit of a client request, if sampled.
+func (c *wsConn) StartAudit() func(method string) {
+	end := c.serv.allocAudit.start()
+	if end == nil {
+		return nil
+	}
+	return func(method string) {
+		action := method
+		if idx := strings.IndexByte(method, '.'); idx >= 0 {
+			action = method[:idx]
+		}
+		typ, ok := allocRequestTypes[action]
+		if !ok {
+			typ = "request.invalid"
+		}
+		end(typ)
+	}
+}
+
+// StartEventAudit starts the allocation audit of a service event, if
+// sampled. The returned function, if not nil, is called once the event is
+// handled.
+func (c *wsConn) StartEventAudit(event string) func() {
+	end := c.serv.allocAudit.start()
+	if end == nil {
+		return nil
+	}
+	return func() {
+		typ, ok := allocEventTypes[event]
+		if !ok {
+			typ = "event.custom"
+		}
+		end(typ)
+	}
+}
+
+// adminAllocationsHandler gets the allocation audit statistics, or enables or
+// disables the audit.
+func (s *Service) adminAllocationsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var req allocAuditRequest
+		if !decodeAdminRequest(w, r, &req) {
+			return
+		}
+		if req.SampleRate < 0 {
+			adminError(w, http.StatusBadRequest, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Sample rate must be zero or a positive integer"})
+			return
+		}
+		if req.SampleRate == 0 {
+			req.SampleRate = int(atomic.LoadUint64(&s.allocAudit.rate))
+		}
+		if req.Enabled {
+			s.allocAudit.enable(req.SampleRate)
+			s.Logf("Allocation audit enabled (sample rate %d)", req.SampleRate)
+		} else {
+			s.allocAudit.disable()
+			s.Logf("Allocation audit disabled")
+		}
+	default:
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	adminResponse(w, s.allocAudit.stats())
+}
@@ -124,18 +124,18 @@ func init() {
 	}
 
 	RegisterAPIEncoderFactory("json", func(cfg Config) APIEncoder {
-		return &encoderJSON{apiPath: cfg.APIPath, notFoundBytes: b}
+		return &encoderJSON{resourcePath: cfg.ResourcePath, notFoundBytes: b}
 
 	})
 	RegisterAPIEncoderFactory("jsonflat", func(cfg Config) APIEncoder {
-		return &encoderJSONFlat{apiPath: cfg.APIPath, notFoundBytes: b}
+		return &encoderJSONFlat{resourcePath: cfg.ResourcePath, notFoundBytes: b}
 	})
 }
 
 type encoderJSON struct {
 	b             bytes.Buffer
 	path          []string
-	apiPath       string
+	resourcePath  func(rid string) string
 	notFoundBytes []byte
 }
 
@@ -146,7 +146,7 @@ func (e *encoderJSON) ContentType() string {
 func (e *encoderJSON) EncodeGET(s *Subscription) ([]byte, error) {
 	// Clone encoder for concurrency safety
 	ec := encoderJSON{
-		apiPath:       e.apiPath,
+		resourcePath:  e.resourcePath,
 		notFoundBytes: e.notFoundBytes,
 	}
 
@@ -178,7 +178,7 @@ func (e *encoderJSON) encodeSubscription(s *Subscription, wrap bool) error {
 
 	if wrap {
 		e.b.Write([]byte(`{"href":`))
-		dta, err := json.Marshal(RIDToPath(rid, e.apiPath))
+		dta, err := json.Marshal(e.resourcePath(rid))
 		if err != nil {
 			return err
 		}
@@ -196,7 +196,7 @@ func (e *encoderJSON) encodeSubscription(s *Subscription, wrap bool) error {
 		if wrap {
 			e.b.Write([]byte(`,"error":`))
 		}
-		e.b.Write(jsonEncodeError(reserr.RESError(err)))
+		e.b.Write(jsonEncodeError(s.c.ClientError(err)))
 		return nil
 	}
 
@@ -257,6 +257,16 @@ func (e *encoderJSON) encodeSubscription(s *Subscription, wrap bool) error {
 			}
 		}
 		e.b.WriteByte('}')
+
+	case rescache.TypeStream:
+		if wrap {
+			e.b.Write([]byte(`,"stream":`))
+		}
+		dta, err := s.StreamValues().MarshalJSON()
+		if err != nil {
+			return err
+		}
+		e.b.Write(dta)
 	}
 
 	// Remove itself from path
@@ -268,7 +278,7 @@ func (e *encoderJSON) encodeSubscription(s *Subscription, wrap bool) error {
 type encoderJSONFlat struct {
 	b             bytes.Buffer
 	path          []string
-	apiPath       string
+	resourcePath  func(rid string) string
 	notFoundBytes []byte
 }
 
@@ -279,7 +289,7 @@ func (e *encoderJSONFlat) ContentType() string {
 func (e *encoderJSONFlat) EncodeGET(s *Subscription) ([]byte, error) {
 	// Clone encoder for concurrency safety
 	ec := encoderJSONFlat{
-		apiPath:       e.apiPath,
+		resourcePath:  e.resourcePath,
 		notFoundBytes: e.notFoundBytes,
 	}
 
@@ -312,7 +322,7 @@ func (e *encoderJSONFlat) encodeSubscription(s *Subscription) error {
 	// Check for cyclic reference
 	if containsString(e.path, rid) {
 		e.b.Write([]byte(`{"href":`))
-		dta, err := json.Marshal(RIDToPath(rid, e.apiPath))
+		dta, err := json.Marshal(e.resourcePath(rid))
 		if err != nil {
 			return err
 		}
@@ -323,7 +333,7 @@ func (e *encoderJSONFlat) encodeSubscription(s *Subscription) error {
 
 	// Check for errors
 	if err := s.Error(); err != nil {
-		e.b.Write(jsonEncodeError(reserr.RESError(err)))
+		e.b.Write(jsonEncodeError(s.c.ClientError(err)))
 		return nil
 	}
 
@@ -378,6 +388,13 @@ func (e *encoderJSONFlat) encodeSubscription(s *Subscription) error {
 			}
 		}
 		e.b.WriteByte('}')
+
+	case rescache.TypeStream:
+		dta, err := s.StreamValues().MarshalJSON()
+		if err != nil {
+			return err
+		}
+		e.b.Write(dta)
 	}
 
 	// Remove itself from path
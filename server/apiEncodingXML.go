@@ -0,0 +1,437 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Default XML encoding element names
+const (
+	XMLRootElement  = "resource"
+	XMLItemElement  = "item"
+	XMLErrorElement = "error"
+)
+
+// XML element naming rules.
+const (
+	XMLNamingPreserve = "preserve"
+	XMLNamingCamel    = "camel"
+	XMLNamingPascal   = "pascal"
+	XMLNamingSnake    = "snake"
+	XMLNamingKebab    = "kebab"
+)
+
+// XMLEncodingConfig holds the configuration for XML encoding of HTTP API
+// responses, used for requests with an Accept header preferring
+// application/xml or text/xml over the apiEncoding content type.
+//
+// A model is encoded with an element for each property, and a collection
+// with an element for each value. A resource reference is encoded as an
+// element with an href attribute, holding the referenced resource:
+//
+//	<?xml version="1.0" encoding="UTF-8"?>
+//	<resource><title>Dune</title><author href="/api/library/author/1"><name>Frank Herbert</name></author></resource>
+type XMLEncodingConfig struct {
+	// RootElement is the name of the root element. Defaults to "resource".
+	RootElement string `json:"rootElement,omitempty"`
+	// ItemElement is the name of collection and array value elements.
+	// Defaults to "item".
+	ItemElement string `json:"itemElement,omitempty"`
+	// ErrorElement is the name of error elements. Defaults to "error".
+	ErrorElement string `json:"errorElement,omitempty"`
+	// Naming is the rule for converting property names to element names:
+	// preserve, camel, pascal, snake, or kebab. Defaults to "preserve".
+	// Characters not allowed in an element name are replaced with an
+	// underscore (_).
+	Naming string `json:"naming,omitempty"`
+}
+
+// prepare validates the XML encoding configuration and sets default values.
+func (c *XMLEncodingConfig) prepare() error {
+	if c.RootElement == "" {
+		c.RootElement = XMLRootElement
+	}
+	if c.ItemElement == "" {
+		c.ItemElement = XMLItemElement
+	}
+	if c.ErrorElement == "" {
+		c.ErrorElement = XMLErrorElement
+	}
+	for _, name := range []string{c.RootElement, c.ItemElement, c.ErrorElement} {
+		if xmlName(name) != name {
+			return fmt.Errorf("%q is not a valid element name", name)
+		}
+	}
+	switch c.Naming {
+	case "":
+		c.Naming = XMLNamingPreserve
+	case XMLNamingPreserve, XMLNamingCamel, XMLNamingPascal, XMLNamingSnake, XMLNamingKebab:
+	default:
+		return errors.New("naming must be preserve, camel, pascal, snake, or kebab")
+	}
+	return nil
+}
+
+func init() {
+	RegisterAPIEncoderFactory("xml", func(cfg Config) APIEncoder {
+		xc := cfg.XMLEncoding
+		if xc == nil {
+			xc = &XMLEncodingConfig{}
+			xc.prepare()
+		}
+		e := &encoderXML{cfg: *xc, resourcePath: cfg.ResourcePath}
+		e.notFoundBytes = e.EncodeError(reserr.ErrNotFound)
+		return e
+	})
+}
+
+// initXMLEncoder creates the XML encoder used for requests preferring XML.
+func (s *Service) initXMLEncoder() {
+	if s.cfg.XMLEncoding == nil {
+		return
+	}
+	if _, ok := s.enc.(*encoderXML); !ok {
+		s.xmlEnc = apiEncoderFactories["xml"](s.cfg)
+	}
+}
+
+// encoder returns the encoder to use for the HTTP API request, being the XML
+// encoder if enabled and preferred by the Accept header.
+func (s *Service) encoder(r *http.Request) APIEncoder {
+	if s.xmlEnc != nil && prefersXML(r.Header["Accept"], s.mimetype) {
+		return s.xmlEnc
+	}
+	return s.enc
+}
+
+// prefersXML reports whether the Accept header values give application/xml
+// or text/xml a higher quality than the default mimetype.
+func prefersXML(accept []string, mimetype string) bool {
+	var xq, dq float64 = -1, -1
+	for _, h := range accept {
+		for _, part := range strings.Split(h, ",") {
+			mt, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+			switch mt {
+			case "application/xml", "text/xml":
+				if q > xq {
+					xq = q
+				}
+			case mimetype, "*/*", mimetype[:strings.IndexByte(mimetype, '/')+1] + "*":
+				if q > dq {
+					dq = q
+				}
+			}
+		}
+	}
+	return xq > 0 && xq > dq
+}
+
+type encoderXML struct {
+	b             bytes.Buffer
+	path          []string
+	cfg           XMLEncodingConfig
+	resourcePath  func(rid string) string
+	notFoundBytes []byte
+}
+
+var xmlHeader = []byte(xml.Header[:len(xml.Header)-1])
+
+func (e *encoderXML) ContentType() string {
+	return "application/xml; charset=utf-8"
+}
+
+func (e *encoderXML) EncodeGET(s *Subscription) ([]byte, error) {
+	// Clone encoder for concurrency safety
+	ec := encoderXML{
+		cfg:          e.cfg,
+		resourcePath: e.resourcePath,
+	}
+
+	ec.b.Write(xmlHeader)
+	if err := ec.encodeSubscription(e.cfg.RootElement, s, false); err != nil {
+		return nil, err
+	}
+	return ec.b.Bytes(), nil
+}
+
+func (e *encoderXML) EncodePOST(r json.RawMessage) ([]byte, error) {
+	if r == nil || bytes.Equal(r, nullBytes) {
+		return nil, nil
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(r))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	ec := encoderXML{cfg: e.cfg}
+	ec.b.Write(xmlHeader)
+	ec.encodeValue(e.cfg.RootElement, v)
+	return ec.b.Bytes(), nil
+}
+
+func (e *encoderXML) EncodeError(rerr *reserr.Error) []byte {
+	ec := encoderXML{cfg: e.cfg}
+	ec.b.Write(xmlHeader)
+	ec.encodeError(rerr)
+	return ec.b.Bytes()
+}
+
+func (e *encoderXML) NotFoundError() []byte {
+	return e.notFoundBytes
+}
+
+func (e *encoderXML) encodeSubscription(name string, s *Subscription, wrap bool) error {
+	rid := s.RID()
+
+	e.b.WriteByte('<')
+	e.b.WriteString(name)
+	if wrap {
+		e.b.WriteString(` href="`)
+		xml.EscapeText(&e.b, []byte(e.resourcePath(rid)))
+		e.b.WriteByte('"')
+	}
+
+	// Check for cyclic reference
+	if containsString(e.path, rid) {
+		e.b.WriteString("/>")
+		return nil
+	}
+	e.b.WriteByte('>')
+
+	// Check for errors
+	if err := s.Error(); err != nil {
+		e.encodeError(s.c.ClientError(err))
+		e.closeElement(name)
+		return nil
+	}
+
+	// Add itself to path
+	e.path = append(e.path, s.rid)
+
+	switch s.ResourceType() {
+	case rescache.TypeCollection:
+		for _, v := range s.CollectionValues() {
+			if err := e.encodeResourceValue(e.cfg.ItemElement, s, v); err != nil {
+				return err
+			}
+		}
+
+	case rescache.TypeModel:
+		vals := s.ModelValues()
+		keys := make([]string, 0, len(vals))
+		for k := range vals {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := e.encodeResourceValue(e.elementName(k), s, vals[k]); err != nil {
+				return err
+			}
+		}
+
+	case rescache.TypeStream:
+		dta, err := s.StreamValues().MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if err := e.encodeContent(dta); err != nil {
+			return err
+		}
+	}
+
+	// Remove itself from path
+	e.path = e.path[:len(e.path)-1]
+
+	e.closeElement(name)
+	return nil
+}
+
+// encodeResourceValue encodes a model or collection value as an element.
+func (e *encoderXML) encodeResourceValue(name string, s *Subscription, v codec.Value) error {
+	if v.Type == codec.ValueTypeResource {
+		return e.encodeSubscription(name, s.Ref(v.RID), true)
+	}
+	var dv interface{}
+	dec := json.NewDecoder(bytes.NewReader(v.RawMessage))
+	dec.UseNumber()
+	if err := dec.Decode(&dv); err != nil {
+		return err
+	}
+	e.encodeValue(name, dv)
+	return nil
+}
+
+// encodeContent encodes the JSON object or array as child elements.
+func (e *encoderXML) encodeContent(dta []byte) error {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(dta))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	e.encodeChildren(v)
+	return nil
+}
+
+// encodeValue encodes a decoded JSON value as an element. A null value is
+// encoded as an empty element.
+func (e *encoderXML) encodeValue(name string, v interface{}) {
+	if v == nil {
+		e.b.WriteByte('<')
+		e.b.WriteString(name)
+		e.b.WriteString("/>")
+		return
+	}
+	e.b.WriteByte('<')
+	e.b.WriteString(name)
+	e.b.WriteByte('>')
+	e.encodeChildren(v)
+	e.closeElement(name)
+}
+
+// encodeChildren encodes the content of a decoded JSON value.
+func (e *encoderXML) encodeChildren(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e.encodeValue(e.elementName(k), t[k])
+		}
+	case []interface{}:
+		for _, iv := range t {
+			e.encodeValue(e.cfg.ItemElement, iv)
+		}
+	case string:
+		xml.EscapeText(&e.b, []byte(t))
+	case json.Number:
+		e.b.WriteString(string(t))
+	case bool:
+		e.b.WriteString(strconv.FormatBool(t))
+	}
+}
+
+func (e *encoderXML) encodeError(rerr *reserr.Error) {
+	name := e.cfg.ErrorElement
+	e.b.WriteByte('<')
+	e.b.WriteString(name)
+	e.b.WriteString("><code>")
+	xml.EscapeText(&e.b, []byte(rerr.Code))
+	e.b.WriteString("</code><message>")
+	xml.EscapeText(&e.b, []byte(rerr.Message))
+	e.b.WriteString("</message>")
+	if rerr.Data != nil {
+		if dta, err := json.Marshal(rerr.Data); err == nil {
+			var v interface{}
+			dec := json.NewDecoder(bytes.NewReader(dta))
+			dec.UseNumber()
+			if dec.Decode(&v) == nil {
+				e.encodeValue("data", v)
+			}
+		}
+	}
+	e.closeElement(name)
+}
+
+func (e *encoderXML) closeElement(name string) {
+	e.b.WriteString("</")
+	e.b.WriteString(name)
+	e.b.WriteByte('>')
+}
+
+// elementName converts a property name to an element name using the naming
+// rule.
+func (e *encoderXML) elementName(k string) string {
+	switch e.cfg.Naming {
+	case XMLNamingCamel, XMLNamingPascal:
+		words := splitWords(k)
+		for i, w := range words {
+			if i > 0 || e.cfg.Naming == XMLNamingPascal {
+				r, n := utf8.DecodeRuneInString(w)
+				w = string(unicode.ToUpper(r)) + w[n:]
+			}
+			words[i] = w
+		}
+		k = strings.Join(words, "")
+	case XMLNamingSnake:
+		k = strings.Join(splitWords(k), "_")
+	case XMLNamingKebab:
+		k = strings.Join(splitWords(k), "-")
+	}
+	return xmlName(k)
+}
+
+// splitWords splits a property name into lower case words, separated by
+// underscores, dashes, spaces, or a change from lower to upper case.
+func splitWords(s string) []string {
+	var words []string
+	var w []rune
+	var prev rune
+	for _, r := range s {
+		switch {
+		case r == '_' || r == '-' || r == ' ':
+			if len(w) > 0 {
+				words = append(words, string(w))
+				w = w[:0]
+			}
+			prev = r
+			continue
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) && len(w) > 0:
+			words = append(words, string(w))
+			w = w[:0]
+		}
+		w = append(w, unicode.ToLower(r))
+		prev = r
+	}
+	if len(w) > 0 {
+		words = append(words, string(w))
+	}
+	return words
+}
+
+// xmlName replaces characters not allowed in an XML element name with an
+// underscore (_). A name not starting with a letter or underscore, or
+// starting with "xml", is prefixed with an underscore.
+func xmlName(s string) string {
+	if s == "" {
+		return "_"
+	}
+	b := []rune(s)
+	for i, r := range b {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.' {
+			b[i] = '_'
+		}
+	}
+	name := string(b)
+	if !unicode.IsLetter(b[0]) && b[0] != '_' || len(name) >= 3 && strings.EqualFold(name[:3], "xml") {
+		name = "_" + name
+	}
+	return name
+}
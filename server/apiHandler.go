@@ -10,6 +10,7 @@ import (
 
 	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
 )
 
 func (s *Service) initAPIHandler() error {
@@ -24,6 +25,7 @@ func (s *Service) initAPIHandler() error {
 	s.enc = f(s.cfg)
 	mimetype, _, err := mime.ParseMediaType(s.enc.ContentType())
 	s.mimetype = mimetype
+	s.initXMLEncoder()
 	return err
 }
 
@@ -54,13 +56,21 @@ func (s *Service) setCommonHeaders(w http.ResponseWriter, r *http.Request) error
 }
 
 func (s *Service) apiHandler(w http.ResponseWriter, r *http.Request) {
+	enc := s.encoder(r)
 	err := s.setCommonHeaders(w, r)
+	if s.xmlEnc != nil {
+		w.Header().Add("Vary", "Accept")
+	}
 	if r.Method == "OPTIONS" {
 		w.Header().Set("Access-Control-Allow-Methods", s.cfg.allowMethods)
 		return
 	}
 	if err != nil {
-		httpError(w, err, s.enc)
+		s.httpError(w, r, err, enc)
+		return
+	}
+	if err := s.maintenanceConnError(); err != nil {
+		s.rejectConn(w, r, err)
 		return
 	}
 
@@ -69,11 +79,28 @@ func (s *Service) apiHandler(w http.ResponseWriter, r *http.Request) {
 		path = r.URL.Path
 	}
 
-	apiPath := s.cfg.APIPath
+	mount := s.cfg.matchAPIMount(path)
+	if mount == nil {
+		s.notFoundHandler(w, r, enc)
+		return
+	}
+	apiPath := mount.path
+
+	// Normalize the path, redirecting GET requests to the normalized path
+	if np := s.normalizePath(path, mount, r.Method == "POST"); np != path {
+		if r.Method == "GET" || r.Method == "HEAD" {
+			if r.URL.RawQuery != "" {
+				np += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, np, http.StatusMovedPermanently)
+			return
+		}
+		path = np
+	}
 
 	// NotFound on oaths with trailing slash (unless it is only the APIPath)
 	if len(path) > len(apiPath) && path[len(path)-1] == '/' {
-		notFoundHandler(w, r, s.enc)
+		s.notFoundHandler(w, r, enc)
 		return
 	}
 
@@ -82,9 +109,15 @@ func (s *Service) apiHandler(w http.ResponseWriter, r *http.Request) {
 	case "HEAD":
 		fallthrough
 	case "GET":
-		rid = PathToRID(path, r.URL.RawQuery, apiPath)
+		rid = mount.rid(PathToRID(path, r.URL.RawQuery, apiPath))
 		if !codec.IsValidRID(rid, true) {
-			notFoundHandler(w, r, s.enc)
+			s.notFoundHandler(w, r, enc)
+			return
+		}
+		s.setDeprecationHeaders(w, rid, "")
+
+		if rule := s.blobRule(rid); rule != nil {
+			s.handleBlob(w, r, rid, rule)
 			return
 		}
 
@@ -94,13 +127,25 @@ func (s *Service) apiHandler(w http.ResponseWriter, r *http.Request) {
 					cb(nil, err)
 					return
 				}
-				cb(s.enc.EncodeGET(sub))
+				start := c.timing.now()
+				out, err := enc.EncodeGET(sub)
+				c.timing.span("encode", start)
+				if err == nil && sub.Error() == nil {
+					if s.hasLinkHeaders(rid) {
+						s.setLinkHeaders(w, sub)
+					}
+					if s.cfg.SurrogateKeys != nil {
+						s.setSurrogateKeys(w, sub)
+					}
+				}
+				cb(out, err)
 			})
 		})
 		return
 
 	case "POST":
 		rid, action = PathToRIDAction(path, r.URL.RawQuery, apiPath)
+		rid = mount.rid(rid)
 	default:
 		var m *string
 		switch r.Method {
@@ -119,85 +164,191 @@ func (s *Service) apiHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		// Return error if we have no mapping for the method
 		if m == nil {
-			httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+			s.httpError(w, r, reserr.ErrMethodNotAllowed, enc)
 			return
 		}
-		rid = PathToRID(path, r.URL.RawQuery, apiPath)
+		rid = mount.rid(PathToRID(path, r.URL.RawQuery, apiPath))
 		action = *m
 	}
 
 	s.handleCall(w, r, rid, action)
 }
 
-func notFoundHandler(w http.ResponseWriter, r *http.Request, enc APIEncoder) {
+func (s *Service) notFoundHandler(w http.ResponseWriter, r *http.Request, enc APIEncoder) {
+	if s.writeHTTPErrorBody(w, r, http.StatusNotFound, reserr.ErrNotFound) {
+		return
+	}
 	w.Header().Set("Content-Type", enc.ContentType())
 	w.WriteHeader(http.StatusNotFound)
+	if rerr := s.localizeError(reserr.ErrNotFound, r); rerr != reserr.ErrNotFound {
+		w.Write(enc.EncodeError(rerr))
+		return
+	}
 	w.Write(enc.NotFoundError())
 }
 
 func (s *Service) handleCall(w http.ResponseWriter, r *http.Request, rid string, action string) {
+	enc := s.encoder(r)
 	if !codec.IsValidRID(rid, true) || !codec.IsValidRIDPart(action) {
-		notFoundHandler(w, r, s.enc)
-		return
-	}
-
-	// Try to parse the body
-	b, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		httpError(w, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error reading request body: " + err.Error()}, s.enc)
+		s.notFoundHandler(w, r, enc)
 		return
 	}
+	s.setDeprecationHeaders(w, rid, action)
 
 	var params json.RawMessage
-	if strings.TrimSpace(string(b)) != "" {
-		err = json.Unmarshal(b, &params)
+	var files []*uploadedFile
+	ur := s.uploadRule(r, rid, action)
+	if ur != nil {
+		// Store uploaded files and pass references as parameters
+		var err error
+		params, files, err = s.readUpload(ur, r)
+		if err != nil {
+			s.httpError(w, r, err, enc)
+			return
+		}
+	} else {
+		// Try to parse the body
+		b, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			httpError(w, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding request body: " + err.Error()}, s.enc)
+			s.httpError(w, r, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error reading request body: " + err.Error()}, enc)
 			return
 		}
+
+		if strings.TrimSpace(string(b)) != "" {
+			err = json.Unmarshal(b, &params)
+			if err != nil {
+				s.httpError(w, r, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding request body: " + err.Error()}, enc)
+				return
+			}
+		}
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > rpc.IdempotencyKeyMaxLength {
+		s.httpError(w, r, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Idempotency key too long"}, enc)
+		return
 	}
 
 	s.temporaryConn(w, r, func(c *wsConn, cb func([]byte, error)) {
-		c.CallHTTPResource(rid, s.cfg.APIPath, action, params, func(r json.RawMessage, href string, err error) {
+		opts := rpc.CallOptions{IdempotencyKey: key, IfMatch: ifMatch(r)}
+		c.CallHTTPResource(rid, action, params, opts, func(result json.RawMessage, refRID string, err error) {
 			if err != nil {
+				if parent := s.putCreateParent(r, rid, err); parent != "" && ur == nil {
+					s.putCreate(w, r, c, parent, rid, params, opts, cb)
+					return
+				}
+				if ur != nil {
+					s.removeUploads(ur, files)
+				}
 				cb(nil, err)
-			} else if href != "" {
-				w.Header().Set("Location", href)
+			} else if refRID != "" && action == "new" {
+				s.created(w, r, c, refRID, cb)
+			} else if refRID != "" {
+				w.Header().Set("Location", s.cfg.ResourcePath(refRID))
+				c.timing.setHeader(w)
 				w.WriteHeader(http.StatusOK)
-				cb(nil, nil)
+				cb(nil, errResponseDeferred)
 			} else {
-				cb(s.enc.EncodePOST(r))
+				start := c.timing.now()
+				out, err := enc.EncodePOST(result)
+				c.timing.span("encode", start)
+				cb(out, err)
 			}
 		})
 	})
 }
 
+// created responds with status 201 and the Location of the resource returned
+// by a new call, or by the create call of a PUT request. If the request has a
+// Prefer header with return=representation, the resource is also fetched and
+// sent as the body. If fetching fails, the response has no body, as the call
+// itself has succeeded.
+func (s *Service) created(w http.ResponseWriter, r *http.Request, c *wsConn, refRID string, cb func([]byte, error)) {
+	enc := s.encoder(r)
+	w.Header().Set("Location", s.cfg.ResourcePath(refRID))
+	if !preferRepresentation(r) {
+		c.timing.setHeader(w)
+		w.WriteHeader(http.StatusCreated)
+		cb(nil, errResponseDeferred)
+		return
+	}
+	c.GetSubscription(refRID, func(sub *Subscription, err error) {
+		var out []byte
+		if err == nil {
+			start := c.timing.now()
+			out, err = enc.EncodeGET(sub)
+			c.timing.span("encode", start)
+		}
+		c.timing.setHeader(w)
+		if err != nil {
+			s.Debugf("Error getting created resource %s: %s", refRID, err)
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.Header().Set("Content-Type", enc.ContentType())
+			w.Header().Set("Preference-Applied", "return=representation")
+			w.WriteHeader(http.StatusCreated)
+			w.Write(out)
+		}
+		cb(nil, errResponseDeferred)
+	})
+}
+
+// preferRepresentation reports whether the request has a Prefer header
+// asking for the resource to be returned.
+func preferRepresentation(r *http.Request) bool {
+	for _, h := range r.Header["Prefer"] {
+		for _, p := range strings.Split(h, ",") {
+			if strings.EqualFold(strings.TrimSpace(p), "return=representation") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ifMatch returns the expected resource version of the If-Match header,
+// passed to the service with call requests. A single strong entity tag is
+// passed without the quotes, while any other value is passed as is.
+func ifMatch(r *http.Request) string {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' && !strings.ContainsAny(v[1:len(v)-1], "\",") {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
 func (s *Service) temporaryConn(w http.ResponseWriter, r *http.Request, cb func(*wsConn, func([]byte, error))) {
-	c := s.newWSConn(nil, r, latestProtocol)
+	enc := s.encoder(r)
+	c := s.newWSConn(nil, r, codec.LatestProtocol)
 	if c == nil {
-		httpError(w, reserr.ErrServiceUnavailable, s.enc)
+		s.httpError(w, r, reserr.ErrServiceUnavailable, enc)
 		return
 	}
+	c.timing = s.newServerTiming()
 
 	done := make(chan struct{})
 	rs := func(out []byte, err error) {
 		defer c.dispose()
 		defer close(done)
 
+		if err == errResponseDeferred {
+			return
+		}
+		c.timing.setHeader(w)
 		if err != nil {
 			// Convert system.methodNotFound to system.methodNotAllowed for PUT/DELETE/PATCH
 			if rerr, ok := err.(*reserr.Error); ok {
 				if rerr.Code == reserr.CodeMethodNotFound && (r.Method == "PUT" || r.Method == "DELETE" || r.Method == "PATCH") {
-					httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+					s.httpError(w, r, reserr.ErrMethodNotAllowed, enc)
 					return
 				}
 			}
-			httpError(w, err, s.enc)
+			s.httpError(w, r, err, enc)
 			return
 		}
 
 		if len(out) > 0 {
-			w.Header().Set("Content-Type", s.enc.ContentType())
+			w.Header().Set("Content-Type", enc.ContentType())
 			w.Write(out)
 			return
 		}
@@ -206,7 +357,13 @@ func (s *Service) temporaryConn(w http.ResponseWriter, r *http.Request, cb func(
 	}
 	c.Enqueue(func() {
 		if s.cfg.HeaderAuth != nil {
+			start := c.timing.now()
 			c.AuthResource(s.cfg.headerAuthRID, s.cfg.headerAuthAction, nil, func(_ interface{}, err error) {
+				c.timing.span("auth", start)
+				if reserr.IsError(err, codeChallengeRequired) {
+					rs(nil, err)
+					return
+				}
 				cb(c, rs)
 			})
 		} else {
@@ -216,8 +373,14 @@ func (s *Service) temporaryConn(w http.ResponseWriter, r *http.Request, cb func(
 	<-done
 }
 
-func httpError(w http.ResponseWriter, err error, enc APIEncoder) {
-	rerr := reserr.RESError(err)
+func (s *Service) httpError(w http.ResponseWriter, r *http.Request, err error, enc APIEncoder) {
+	rerr := s.clientError(err, r)
+
+	// Redirect to the challenge page of auth requests requiring a challenge
+	if d, ok := rerr.Data.(challengeData); ok {
+		http.Redirect(w, r, d.URL, http.StatusSeeOther)
+		return
+	}
 
 	var code int
 	switch rerr.Code {
@@ -228,6 +391,8 @@ func httpError(w http.ResponseWriter, err error, enc APIEncoder) {
 	case reserr.CodeTimeout:
 		code = http.StatusNotFound
 	case reserr.CodeAccessDenied:
+		fallthrough
+	case codeChallengeRequired:
 		code = http.StatusUnauthorized
 	case reserr.CodeMethodNotAllowed:
 		code = http.StatusMethodNotAllowed
@@ -237,10 +402,20 @@ func httpError(w http.ResponseWriter, err error, enc APIEncoder) {
 		code = http.StatusServiceUnavailable
 	case reserr.CodeForbidden:
 		code = http.StatusForbidden
+	case reserr.CodePreconditionFailed:
+		code = http.StatusPreconditionFailed
+	case reserr.CodeTooManyRequests:
+		code = http.StatusTooManyRequests
+	case codeUpgradeRequired:
+		code = http.StatusUpgradeRequired
 	default:
 		code = http.StatusBadRequest
 	}
 
+	if s.writeHTTPErrorBody(w, r, code, rerr) {
+		return
+	}
+
 	w.Header().Set("Content-Type", enc.ContentType())
 	w.WriteHeader(code)
 	w.Write(enc.EncodeError(rerr))
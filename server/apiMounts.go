@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/resgateio/resgate/server/codec"
+)
+
+// APIMount holds the configuration for an additional HTTP API path prefix,
+// mapped to a resource namespace. A request on the path, Path, followed by a
+// resource path is made on the resource ID prefixed by Namespace.
+//
+// Eg. { "path": "/internal/", "namespace": "int" } maps the path
+// /internal/orders/42 to the resource ID int.orders.42.
+type APIMount struct {
+	Path      string `json:"path"`
+	Namespace string `json:"namespace"`
+}
+
+// apiMount is a prepared API mount, with prefix being the namespace followed
+// by a dot, or empty for the apiPath mount.
+type apiMount struct {
+	path   string
+	prefix string
+}
+
+// prepareAPIMounts validates the API mounts and prepares the list of mounts,
+// including the apiPath, ordered by path length with the longest first.
+// Must be called after apiPath is prepared.
+func (c *Config) prepareAPIMounts() error {
+	mounts := []apiMount{{path: c.APIPath}}
+	for _, m := range c.APIMounts {
+		if m.Path == "" || m.Path[0] != '/' {
+			return fmt.Errorf("path %q must start with a slash (/)", m.Path)
+		}
+		if m.Path[len(m.Path)-1] != '/' {
+			m.Path += "/"
+		}
+		if m.Path == c.WSPath {
+			return fmt.Errorf("path %q must not be the wsPath", m.Path)
+		}
+		if !codec.IsValidRID(m.Namespace, false) {
+			return fmt.Errorf("namespace %q must be a valid resource name", m.Namespace)
+		}
+		for _, am := range mounts {
+			if am.path == m.Path {
+				return fmt.Errorf("path %q must not be used by another mount or the apiPath", m.Path)
+			}
+		}
+		mounts = append(mounts, apiMount{path: m.Path, prefix: m.Namespace + "."})
+	}
+	if len(mounts) > 1 && c.APIPath == "/" {
+		return errors.New("apiPath must not be the root path (/) when using apiMounts")
+	}
+	sort.SliceStable(mounts, func(i, j int) bool { return len(mounts[i].path) > len(mounts[j].path) })
+	c.apiMounts = mounts
+	return nil
+}
+
+// matchAPIMount returns the mount with the longest path prefix matching the
+// path, or nil if no mount matches.
+func (c *Config) matchAPIMount(path string) *apiMount {
+	for i := range c.apiMounts {
+		if strings.HasPrefix(path, c.apiMounts[i].path) {
+			return &c.apiMounts[i]
+		}
+	}
+	if len(c.apiMounts) == 0 && strings.HasPrefix(path, c.APIPath) {
+		return &apiMount{path: c.APIPath}
+	}
+	return nil
+}
+
+// apiMountError returns an error if the path of an HTTP endpoint is handled
+// by an API mount, including the apiPath.
+// Must be called after the API mounts are prepared.
+func (c *Config) apiMountError(path string) error {
+	if c.matchAPIMount(path) != nil {
+		return fmt.Errorf("path %q must not be within the apiPath or the path of an apiMounts mount", path)
+	}
+	return nil
+}
+
+// rid returns the resource ID within the namespace of the mount. An empty
+// resource ID is returned as is.
+func (m *apiMount) rid(rid string) string {
+	if rid == "" {
+		return rid
+	}
+	return m.prefix + rid
+}
+
+// ResourcePath returns the HTTP API path of a resource. A resource within the
+// namespace of an API mount uses the mount path, preferring the longest
+// namespace, while other resources use the apiPath.
+func (c *Config) ResourcePath(rid string) string {
+	var best *apiMount
+	for i := range c.apiMounts {
+		m := &c.apiMounts[i]
+		if m.prefix != "" && strings.HasPrefix(rid, m.prefix) && (best == nil || len(m.prefix) > len(best.prefix)) {
+			best = m
+		}
+	}
+	if best != nil {
+		return RIDToPath(rid[len(best.prefix):], best.path)
+	}
+	return RIDToPath(rid, c.APIPath)
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// AuditConfig holds the configuration for writing connection audit records.
+// A record is written to each configured sink when a WebSocket connection is
+// closed, and optionally for each call and new request.
+type AuditConfig struct {
+	// File is the path of a file to append records to, one JSON object per
+	// line.
+	File *string `json:"file,omitempty"`
+	// Subject is a NATS subject to publish records on.
+	Subject *string `json:"subject,omitempty"`
+	// URL is an HTTP endpoint to POST records to.
+	URL *string `json:"url,omitempty"`
+	// TokenClaim is the name of the token claim to include as the subject
+	// of the record. Defaults to "sub".
+	TokenClaim string `json:"tokenClaim,omitempty"`
+	// Calls enables writing a record for each call and new request made by
+	// clients, including the parameters.
+	Calls bool `json:"calls,omitempty"`
+	// Redact is a list of paths of parameter fields to redact in call
+	// records. Path segments are separated by dots, and a * segment matches
+	// any field or array element. Eg. "card.number" or "items.*.password"
+	Redact []string `json:"redact,omitempty"`
+
+	redact [][]string
+}
+
+// AuditRecord describes the lifecycle of a client connection.
+type AuditRecord struct {
+	Type          string `json:"type"`
+	CID           string `json:"cid"`
+	Subject       string `json:"subject,omitempty"`
+	IP            string `json:"ip"`
+	Connected     string `json:"connected"`
+	Disconnected  string `json:"disconnected"`
+	Duration      int64  `json:"duration"`
+	BytesIn       int64  `json:"bytesIn"`
+	BytesOut      int64  `json:"bytesOut"`
+	Subscriptions int    `json:"subscriptions"`
+}
+
+// CallAuditRecord describes a call or new request made by a client. Error
+// holds the error code if the request failed.
+type CallAuditRecord struct {
+	Type     string          `json:"type"`
+	CID      string          `json:"cid"`
+	Subject  string          `json:"subject,omitempty"`
+	IP       string          `json:"ip"`
+	Time     string          `json:"time"`
+	Resource string          `json:"resource"`
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Duration int64           `json:"duration"`
+}
+
+// Audit record types
+const (
+	auditTypeConnection = "connection"
+	auditTypeCall       = "call"
+)
+
+// auditRedacted is the value replacing redacted parameter fields.
+const auditRedacted = "[REDACTED]"
+
+// auditLog writes audit records to the configured sinks.
+type auditLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	client *http.Client
+}
+
+// prepare validates the audit configuration and sets default values.
+func (c *AuditConfig) prepare() error {
+	if c.File != nil && *c.File == "" {
+		return errors.New("file must be a file path")
+	}
+	if c.Subject != nil && !codec.IsValidRID(*c.Subject, false) {
+		return fmt.Errorf("subject %q must be a valid NATS subject", *c.Subject)
+	}
+	if c.URL != nil {
+		u, err := url.Parse(*c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url %q must be an absolute http or https URL", *c.URL)
+		}
+	}
+	if c.TokenClaim == "" {
+		c.TokenClaim = "sub"
+	}
+	c.redact = make([][]string, 0, len(c.Redact))
+	for _, path := range c.Redact {
+		segs := strings.Split(path, ".")
+		for _, seg := range segs {
+			if seg == "" {
+				return fmt.Errorf("redact path %q must be dot separated field names", path)
+			}
+		}
+		c.redact = append(c.redact, segs)
+	}
+	return nil
+}
+
+// initAudit opens the audit file, if configured.
+func (s *Service) initAudit() error {
+	if s.cfg.Audit == nil {
+		return nil
+	}
+	a := &auditLog{}
+	if s.cfg.Audit.File != nil {
+		f, err := os.OpenFile(*s.cfg.Audit.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return err
+		}
+		a.file = f
+	}
+	if s.cfg.Audit.URL != nil {
+		a.client = &http.Client{Timeout: AuditHTTPTimeout}
+	}
+	s.audit = a
+	return nil
+}
+
+// stopAudit closes the audit file.
+func (s *Service) stopAudit() {
+	if s.audit == nil || s.audit.file == nil {
+		return
+	}
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+	if err := s.audit.file.Close(); err != nil {
+		s.Errorf("Error closing audit file: %s", err)
+	}
+}
+
+// writeAudit writes an audit record for the closed connection.
+// It is called by the wsConn worker goroutine on dispose.
+func (c *wsConn) writeAudit(subs int) {
+	s := c.serv
+	if s.audit == nil || !c.persistent() {
+		return
+	}
+	now := time.Now()
+	rec := AuditRecord{
+		Type:          auditTypeConnection,
+		CID:           c.cid,
+		Subject:       tokenClaim(c.token, s.cfg.Audit.TokenClaim),
+		IP:            c.serv.remoteIP(c.request),
+		Connected:     c.connected.UTC().Format(time.RFC3339Nano),
+		Disconnected:  now.UTC().Format(time.RFC3339Nano),
+		Duration:      int64(now.Sub(c.connected) / time.Millisecond),
+		BytesIn:       c.bytesIn(),
+		BytesOut:      c.bytesOut(),
+		Subscriptions: subs,
+	}
+	data, _ := json.Marshal(rec)
+	c.writeAuditRecord(data)
+}
+
+// auditCall returns a callback for a call or new request that writes a call
+// audit record before calling cb, if call audit records are enabled.
+// Otherwise cb is returned as is.
+func (c *wsConn) auditCall(rid, action string, params interface{}, cb func(result json.RawMessage, refRID string, err error)) func(result json.RawMessage, refRID string, err error) {
+	s := c.serv
+	if s.audit == nil || !s.cfg.Audit.Calls {
+		return cb
+	}
+	start := time.Now()
+	return func(result json.RawMessage, refRID string, err error) {
+		rec := CallAuditRecord{
+			Type:     auditTypeCall,
+			CID:      c.cid,
+			Subject:  tokenClaim(c.token, s.cfg.Audit.TokenClaim),
+			IP:       c.serv.remoteIP(c.request),
+			Time:     start.UTC().Format(time.RFC3339Nano),
+			Resource: c.ExpandCID(rid),
+			Method:   action,
+			Duration: int64(time.Since(start) / time.Millisecond),
+		}
+		if p, _ := json.Marshal(params); string(p) != "null" {
+			rec.Params = redactParams(p, s.cfg.Audit.redact)
+		}
+		if err != nil {
+			rec.Error = reserr.RESError(err).Code
+		}
+		data, _ := json.Marshal(rec)
+		c.writeAuditRecord(data)
+		cb(result, refRID, err)
+	}
+}
+
+// writeAuditRecord writes an encoded audit record to each configured sink.
+func (c *wsConn) writeAuditRecord(data []byte) {
+	s := c.serv
+	if s.audit.file != nil {
+		s.audit.mu.Lock()
+		_, err := s.audit.file.Write(append(data, '\n'))
+		s.audit.mu.Unlock()
+		if err != nil {
+			c.Errorf("Error writing audit record: %s", err)
+		}
+	}
+	if s.cfg.Audit.Subject != nil {
+		if err := s.mq.Publish(*s.cfg.Audit.Subject, data); err != nil {
+			c.Errorf("Error publishing audit record: %s", err)
+		}
+	}
+	if s.cfg.Audit.URL != nil {
+		cid := c.cid
+		go func() {
+			defer s.recoverFatal("audit")
+			resp, err := s.postWebhook(s.audit.client, *s.cfg.Audit.URL, data)
+			if err != nil {
+				s.Errorf("Error posting audit record for %s: %s", cid, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				s.Errorf("Error posting audit record for %s: %s", cid, resp.Status)
+			}
+		}()
+	}
+}
+
+// redactParams returns the parameters with the fields matching any of the
+// paths replaced by a redacted value. Parameters that are not a JSON object
+// or array are returned as is.
+func redactParams(params json.RawMessage, paths [][]string) json.RawMessage {
+	if len(paths) == 0 {
+		return params
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+	if dec.Decode(&v) != nil {
+		return params
+	}
+	for _, path := range paths {
+		v = redactPath(v, path)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return params
+	}
+	return out
+}
+
+// redactPath replaces the values matching the path within v.
+func redactPath(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return auditRedacted
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if path[0] == "*" || path[0] == k {
+				t[k] = redactPath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, child := range t {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				t[i] = redactPath(child, path[1:])
+			}
+		}
+	}
+	return v
+}
+
+// tokenClaim returns the string value of a token claim, or an empty string
+// if the token has no such claim.
+func tokenClaim(token json.RawMessage, claim string) string {
+	var m map[string]interface{}
+	if json.Unmarshal(token, &m) != nil {
+		return ""
+	}
+	switch v := m[claim].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Default back-pressure settings
+const (
+	BackPressureQueueSize = 256
+	BackPressureInterval  = 1000
+)
+
+// BackPressureConfig holds the configuration for signaling services when the
+// outbound queues of the subscribers of a resource are saturated, allowing
+// services to slow down their event rate.
+//
+// While saturated, the gateway publishes on the subject flow.<resourceName>
+// the payload:
+//
+//	{"saturated":true}
+//
+// once, and then once per interval while it persists. When no saturation has
+// been observed for an interval, it publishes:
+//
+//	{"saturated":false}
+type BackPressureConfig struct {
+	// QueueSize is the number of messages queued for a connection at which
+	// its outbound queue is saturated. Defaults to 256.
+	QueueSize int `json:"queueSize,omitempty"`
+	// Interval is the time in milliseconds between signals while saturated.
+	// Defaults to 1000.
+	Interval int `json:"interval,omitempty"`
+}
+
+// backPressureTable holds the saturation state by resource name.
+type backPressureTable struct {
+	BackPressureConfig
+	s   *Service
+	mu  sync.Mutex
+	res map[string]*backPressureState
+}
+
+// backPressureState holds the saturation state of a resource.
+type backPressureState struct {
+	last  time.Time // Last observed saturation
+	timer *time.Timer
+}
+
+var (
+	flowSaturated = []byte(`{"saturated":true}`)
+	flowResumed   = []byte(`{"saturated":false}`)
+)
+
+// prepare validates the back-pressure configuration and sets default values.
+func (c *BackPressureConfig) prepare() error {
+	if c.QueueSize < 0 || c.Interval < 0 {
+		return errors.New("queueSize and interval must be zero or a positive number")
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = BackPressureQueueSize
+	}
+	if c.Interval == 0 {
+		c.Interval = BackPressureInterval
+	}
+	return nil
+}
+
+// initBackPressure creates the back-pressure table, if configured.
+func (s *Service) initBackPressure() {
+	if s.cfg.BackPressure == nil {
+		return
+	}
+	s.backPressure = &backPressureTable{
+		BackPressureConfig: *s.cfg.BackPressure,
+		s:                  s,
+		res:                make(map[string]*backPressureState),
+	}
+}
+
+// saturated records an observed saturation for the resource, signaling the
+// service if the resource was not already saturated.
+func (t *backPressureTable) saturated(rname string) {
+	t.mu.Lock()
+	now := time.Now()
+	if st, ok := t.res[rname]; ok {
+		st.last = now
+		t.mu.Unlock()
+		return
+	}
+	st := &backPressureState{last: now}
+	st.timer = time.AfterFunc(msDuration(t.Interval), func() { t.check(rname, st) })
+	t.res[rname] = st
+	t.mu.Unlock()
+	t.signal(rname, flowSaturated)
+}
+
+// check signals the service again if the resource has been saturated within
+// the last interval. Otherwise the resource is signaled to have resumed.
+func (t *backPressureTable) check(rname string, st *backPressureState) {
+	t.mu.Lock()
+	if t.res[rname] != st {
+		t.mu.Unlock()
+		return
+	}
+	payload := flowResumed
+	if time.Since(st.last) < msDuration(t.Interval) {
+		st.timer.Reset(msDuration(t.Interval))
+		payload = flowSaturated
+	} else {
+		delete(t.res, rname)
+	}
+	t.mu.Unlock()
+	t.signal(rname, payload)
+}
+
+// signal publishes the flow-control payload for the resource.
+func (t *backPressureTable) signal(rname string, payload []byte) {
+	if err := t.s.mq.Publish("flow."+rname, payload); err != nil {
+		t.s.Debugf("Error publishing flow control for %s: %s", rname, err)
+	}
+}
+
+// ObserveQueue records a saturation of the outbound queue for the resource
+// if the connection has reached the back-pressure queue size.
+func (c *wsConn) ObserveQueue(rname string) {
+	t := c.serv.backPressure
+	if t == nil {
+		return
+	}
+	c.mu.Lock()
+	n := len(c.queue)
+	c.mu.Unlock()
+	if n >= t.QueueSize {
+		t.saturated(rname)
+	}
+}
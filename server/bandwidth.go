@@ -0,0 +1,401 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// BandwidthConfig holds the configuration for accounting the bytes read from
+// and written to WebSocket connections, aggregated by token subject.
+type BandwidthConfig struct {
+	// TokenClaim is the name of the token claim used as the subject to
+	// aggregate by. Defaults to "sub".
+	TokenClaim string `json:"tokenClaim,omitempty"`
+	// Cap is the number of bytes, read and written, that the connections of
+	// a token subject may transfer within the window. Zero means no cap.
+	Cap int64 `json:"cap,omitempty"`
+	// Window is the duration in milliseconds of the cap window.
+	Window int `json:"window,omitempty"`
+	// ThrottleAt is the fraction of the cap, above zero and below one, at
+	// which the connections of a token subject are sent a throttle event
+	// once per window. Zero means no throttle events are sent.
+	ThrottleAt float64 `json:"throttleAt,omitempty"`
+	// ThrottleDelay is the delay in milliseconds between requests suggested
+	// in the throttle event. Defaults to 1000.
+	ThrottleDelay int `json:"throttleDelay,omitempty"`
+}
+
+// bandwidthUsage holds the transferred bytes for a token subject.
+type bandwidthUsage struct {
+	subject     string
+	conns       int
+	in          int64
+	out         int64
+	windowStart time.Time
+	windowBytes int64
+	// Bytes of the window not yet added to the shared counter
+	pending int64
+}
+
+// bandwidthTable holds the bandwidth usage by token subject. With a shared
+// store and a cap, the window bytes are counted by the fleet in fixed
+// windows, with the bytes transferred by the instance added to the shared
+// counter on interval.
+type bandwidthTable struct {
+	mu         sync.Mutex
+	cap        int64
+	window     time.Duration
+	throttleAt int64
+	subjects   map[string]*bandwidthUsage
+	shared     sharedCounter
+	logger     interface{ Errorf(string, ...interface{}) }
+	stop       chan struct{}
+}
+
+// bandwidthStats is the bandwidth usage returned by the admin endpoint.
+type bandwidthStats struct {
+	CID         string `json:"cid,omitempty"`
+	Subject     string `json:"subject"`
+	Connections int    `json:"connections,omitempty"`
+	BytesIn     int64  `json:"bytesIn"`
+	BytesOut    int64  `json:"bytesOut"`
+	WindowBytes *int64 `json:"windowBytes,omitempty"`
+}
+
+var errBandwidthExceeded = &reserr.Error{Code: "system.bandwidthExceeded", Message: "Bandwidth cap exceeded"}
+
+// prepare validates the bandwidth configuration and sets default values.
+func (c *BandwidthConfig) prepare() error {
+	if c.Cap < 0 {
+		return errors.New("cap must be zero or a positive number of bytes")
+	}
+	if c.Window < 0 || (c.Cap > 0 && c.Window == 0) {
+		return errors.New("window must be a positive number of milliseconds when cap is set")
+	}
+	if c.ThrottleAt < 0 || c.ThrottleAt >= 1 || (c.ThrottleAt > 0 && c.Cap == 0) {
+		return errors.New("throttleAt must be a fraction between 0 and 1 when cap is set")
+	}
+	if c.ThrottleDelay < 0 {
+		return errors.New("throttleDelay must be zero or a positive number of milliseconds")
+	}
+	if c.ThrottleDelay == 0 {
+		c.ThrottleDelay = 1000
+	}
+	if c.TokenClaim == "" {
+		c.TokenClaim = "sub"
+	}
+	return nil
+}
+
+// initBandwidth creates the bandwidth table, if configured.
+func (s *Service) initBandwidth() {
+	if s.cfg.Bandwidth == nil {
+		return
+	}
+	s.bandwidth = &bandwidthTable{
+		cap:        s.cfg.Bandwidth.Cap,
+		window:     time.Duration(s.cfg.Bandwidth.Window) * time.Millisecond,
+		throttleAt: int64(s.cfg.Bandwidth.ThrottleAt * float64(s.cfg.Bandwidth.Cap)),
+		subjects:   make(map[string]*bandwidthUsage),
+	}
+	if s.redis != nil && s.cfg.Bandwidth.Cap > 0 {
+		s.bandwidth.shared = s.redis
+		s.bandwidth.logger = s
+	}
+}
+
+// startBandwidth starts adding the window bytes to the shared counters on
+// interval, if the bandwidth cap is shared.
+// Service.mu is held when called
+func (s *Service) startBandwidth() {
+	t := s.bandwidth
+	if t == nil || t.shared == nil {
+		return
+	}
+	stop := make(chan struct{})
+	t.stop = stop
+	go func() {
+		defer s.recoverFatal("bandwidth")
+		ticker := time.NewTicker(BandwidthSyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.sync()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopBandwidth stops adding window bytes to the shared counters.
+func (s *Service) stopBandwidth() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.bandwidth; t != nil && t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+}
+
+// acquire returns the usage for the subject, increasing its connection
+// count.
+func (t *bandwidthTable) acquire(subject string) *bandwidthUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.subjects[subject]
+	if !ok {
+		u = &bandwidthUsage{subject: subject, windowStart: time.Now()}
+		t.subjects[subject] = u
+	}
+	u.conns++
+	return u
+}
+
+// release decreases the connection count of the usage, and removes it once
+// it has no connections and its cap window has passed.
+func (t *bandwidthTable) release(u *bandwidthUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u.conns--
+	t.evict(u, time.Now())
+}
+
+// evict removes the usage if it no longer affects any cap.
+// bandwidthTable.mu is held when called
+func (t *bandwidthTable) evict(u *bandwidthUsage, now time.Time) {
+	if u.conns == 0 && (t.cap == 0 || now.Sub(u.windowStart) >= t.window) {
+		delete(t.subjects, u.subject)
+	}
+}
+
+// add adds transferred bytes to the usage.
+func (t *bandwidthTable) add(u *bandwidthUsage, in, out int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u.in += in
+	u.out += out
+	if t.cap > 0 {
+		now := time.Now()
+		if t.shared != nil {
+			// Shared windows are aligned for all instances
+			if start := now.Truncate(t.window); !start.Equal(u.windowStart) {
+				u.windowStart = start
+				u.windowBytes = 0
+				u.pending = 0
+			}
+			u.pending += in + out
+		} else if now.Sub(u.windowStart) >= t.window {
+			u.windowStart = now
+			u.windowBytes = 0
+		}
+		u.windowBytes += in + out
+	}
+}
+
+// sync adds the pending window bytes of each usage to the shared counter,
+// and sets the window bytes to the total of the fleet. If the shared store
+// is unavailable, the bytes are kept pending, while the window bytes
+// counted by the instance still apply to the cap.
+func (t *bandwidthTable) sync() {
+	type pendingBytes struct {
+		u     *bandwidthUsage
+		start time.Time
+		n     int64
+	}
+	var ps []pendingBytes
+	t.mu.Lock()
+	for _, u := range t.subjects {
+		if u.pending > 0 {
+			ps = append(ps, pendingBytes{u, u.windowStart, u.pending})
+			u.pending = 0
+		}
+	}
+	t.mu.Unlock()
+
+	for i, p := range ps {
+		key := SharedBandwidthPrefix + p.u.subject + ":" + strconv.FormatInt(p.start.UnixNano()/int64(t.window), 10)
+		total, err := t.shared.IncrBy(key, p.n, t.window)
+		t.mu.Lock()
+		if err != nil {
+			for _, p := range ps[i:] {
+				if p.u.windowStart.Equal(p.start) {
+					p.u.pending += p.n
+				}
+			}
+			t.mu.Unlock()
+			t.logger.Errorf("Error counting bandwidth in shared store: %s", err)
+			return
+		}
+		if p.u.windowStart.Equal(p.start) {
+			p.u.windowBytes = total + p.u.pending
+		}
+		t.mu.Unlock()
+	}
+}
+
+// exceeded reports whether the usage exceeds the cap within the current
+// window.
+func (t *bandwidthTable) exceeded(u *bandwidthUsage) bool {
+	if t.cap == 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return u.windowBytes > t.cap && time.Since(u.windowStart) < t.window
+}
+
+// approaching reports whether the usage exceeds the throttle fraction of the
+// cap within the current window, and returns the start of the window and the
+// time remaining of it.
+func (t *bandwidthTable) approaching(u *bandwidthUsage) (time.Time, time.Duration, bool) {
+	if t.throttleAt == 0 {
+		return time.Time{}, 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	left := t.window - time.Since(u.windowStart)
+	if u.windowBytes <= t.throttleAt || left <= 0 {
+		return time.Time{}, 0, false
+	}
+	return u.windowStart, left, true
+}
+
+// countIn adds bytes received from the client.
+func (c *wsConn) countIn(n int) {
+	atomic.AddInt64(&c.nIn, int64(n))
+	if u := c.bandwidthUsage(); u != nil {
+		c.serv.bandwidth.add(u, int64(n), 0)
+	}
+}
+
+// countOut adds bytes sent to the client.
+func (c *wsConn) countOut(n int) {
+	atomic.AddInt64(&c.nOut, int64(n))
+	if u := c.bandwidthUsage(); u != nil {
+		c.serv.bandwidth.add(u, 0, int64(n))
+	}
+}
+
+// bandwidthUsage returns the usage of the connection token subject, or nil
+// if bandwidth accounting is disabled or the connection has no subject.
+func (c *wsConn) bandwidthUsage() *bandwidthUsage {
+	if c.serv.bandwidth == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bw
+}
+
+// bandwidthError returns errBandwidthExceeded if the connection token subject
+// has exceeded the bandwidth cap, otherwise nil.
+func (c *wsConn) bandwidthError() error {
+	if u := c.bandwidthUsage(); u != nil && c.serv.bandwidth.exceeded(u) {
+		return errBandwidthExceeded
+	}
+	return nil
+}
+
+// bandwidthHint sends a throttle event to the client once per window when
+// the connection token subject approaches the bandwidth cap, suggesting the
+// configured delay between requests until the window ends.
+// Must be called on the listen goroutine.
+func (c *wsConn) bandwidthHint() {
+	u := c.bandwidthUsage()
+	if u == nil {
+		return
+	}
+	start, left, ok := c.serv.bandwidth.approaching(u)
+	if !ok || start.Equal(c.bwHinted) {
+		return
+	}
+	c.bwHinted = start
+	ev := rpc.NewThrottleEvent(c.serv.cfg.Bandwidth.ThrottleDelay, ceilMs(left))
+	c.Enqueue(func() {
+		c.Send(ev)
+	})
+}
+
+// setBandwidthSubject sets the token subject that the connection bandwidth
+// is aggregated by. It is called when the token changes, and with an empty
+// subject when the connection is disposed.
+func (c *wsConn) setBandwidthSubject(subject string) {
+	t := c.serv.bandwidth
+	if t == nil || !c.persistent() {
+		return
+	}
+	c.mu.Lock()
+	u := c.bw
+	if u != nil && u.subject == subject {
+		c.mu.Unlock()
+		return
+	}
+	var nu *bandwidthUsage
+	if subject != "" {
+		nu = t.acquire(subject)
+	}
+	c.bw = nu
+	c.mu.Unlock()
+	if u != nil {
+		t.release(u)
+	}
+}
+
+// adminBandwidthHandler returns the bytes transferred by each client
+// connection, including Server-Sent Events streams, and token subject.
+func (s *Service) adminBandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	conns := []bandwidthStats{}
+	s.forEachConn(func(c *wsConn) {
+		if !c.persistent() && c.sse == nil {
+			return
+		}
+		bs := bandwidthStats{CID: c.cid, BytesIn: c.bytesIn(), BytesOut: c.bytesOut()}
+		if u := c.bandwidthUsage(); u != nil {
+			bs.Subject = u.subject
+		}
+		conns = append(conns, bs)
+	})
+	sort.Slice(conns, func(i, j int) bool { return conns[i].CID < conns[j].CID })
+
+	subjects := []bandwidthStats{}
+	if t := s.bandwidth; t != nil {
+		t.mu.Lock()
+		now := time.Now()
+		for _, u := range t.subjects {
+			t.evict(u, now)
+			if t.subjects[u.subject] != u {
+				continue
+			}
+			bs := bandwidthStats{Subject: u.subject, Connections: u.conns, BytesIn: u.in, BytesOut: u.out}
+			if t.cap > 0 {
+				wb := u.windowBytes
+				if now.Sub(u.windowStart) >= t.window {
+					wb = 0
+				}
+				bs.WindowBytes = &wb
+			}
+			subjects = append(subjects, bs)
+		}
+		t.mu.Unlock()
+		sort.Slice(subjects, func(i, j int) bool { return subjects[i].Subject < subjects[j].Subject })
+	}
+
+	adminResponse(w, struct {
+		Connections []bandwidthStats `json:"connections"`
+		Subjects    []bandwidthStats `json:"subjects"`
+	}{conns, subjects})
+}
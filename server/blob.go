@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// BlobConfig holds the configuration for serving binary blob resources, such
+// as images or files, over the HTTP API.
+//
+// HTTP GET and HEAD requests for resources matching the pattern are served as
+// blobs. Access is checked with an access request, as for any get request,
+// and the blob is requested with a blob request on the subject
+// blob.<resourceName>. The service responds with either the base64 encoded
+// data of the blob, or a URL where the gateway fetches it:
+//
+//	{"result":{"contentType":"image/png","data":"iVBORw0KGgo..."}}
+//	{"result":{"contentType":"image/png","url":"https://files.example.com/42.png"}}
+//
+// Range and conditional requests are handled by the gateway for blob data,
+// and passed on to the URL for fetched blobs. Blob data larger than the NATS
+// max payload may be delivered in chunks, see ChunkingConfig.
+type BlobConfig struct {
+	// Pattern is the resource pattern of the blob resources.
+	Pattern string `json:"pattern"`
+	// MaxAge is the time in seconds that clients may cache the blobs, set in
+	// the Cache-Control header. Zero means no Cache-Control header is set.
+	MaxAge int `json:"maxAge,omitempty"`
+}
+
+// blobRule is a prepared BlobConfig.
+type blobRule struct {
+	BlobConfig
+	pattern rescache.ResourcePattern
+}
+
+// errResponseDeferred is passed to the response callback of a temporary
+// connection when the response is written by the caller once the temporary
+// connection is disposed.
+var errResponseDeferred = errors.New("response deferred")
+
+var errInvalidBlobURL = &reserr.Error{Code: reserr.CodeInternalError, Message: "Invalid blob URL"}
+var errBlobUnavailable = &reserr.Error{Code: reserr.CodeInternalError, Message: "Blob unavailable"}
+
+// blobRequestHeaders are the request headers passed on when fetching a blob
+// from its URL.
+var blobRequestHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+
+// blobResponseHeaders are the response headers passed on from a fetched blob.
+var blobResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified", "Cache-Control"}
+
+// prepare validates the blob configuration, and returns the prepared rule.
+func (c BlobConfig) prepare() (blobRule, error) {
+	p := rescache.ParseResourcePattern(c.Pattern)
+	if !p.IsValid() {
+		return blobRule{}, fmt.Errorf("pattern %q must be a valid resource pattern", c.Pattern)
+	}
+	if c.MaxAge < 0 {
+		return blobRule{}, errors.New("maxAge must be zero or a positive number of seconds")
+	}
+	return blobRule{BlobConfig: c, pattern: p}, nil
+}
+
+// initBlobs creates the HTTP client for fetching blobs, if blobs are
+// configured.
+func (s *Service) initBlobs() {
+	if len(s.cfg.blobRules) > 0 {
+		s.blobClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: BlobFetchTimeout,
+			},
+		}
+	}
+}
+
+// blobRule returns the first blob rule matching the resource name of the
+// resource ID, or nil if the resource is not a blob.
+func (s *Service) blobRule(rid string) *blobRule {
+	rname, _ := parseRID(rid)
+	for i := range s.cfg.blobRules {
+		if s.cfg.blobRules[i].pattern.Match(rname) {
+			return &s.cfg.blobRules[i]
+		}
+	}
+	return nil
+}
+
+// GetBlob checks access to get the resource, and requests the blob from the
+// service.
+func (c *wsConn) GetBlob(rid string, cb func(b *codec.BlobResult, err error)) {
+	sub, ok := c.subs[rid]
+	if !ok {
+		sub = NewSubscription(c, rid)
+	}
+
+	if err := c.authRequiredError(); err != nil {
+		cb(nil, err)
+		return
+	}
+
+	if err := c.serv.resourceAllowedError(sub.ResourceName()); err != nil {
+		cb(nil, err)
+		return
+	}
+
+	sub.CanGet(func(err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+		c.serv.cache.Blob(c, sub.ResourceName(), sub.ResourceQuery(), c.token, func(b *codec.BlobResult, err error) {
+			c.Enqueue(func() {
+				cb(b, err)
+			})
+		})
+	})
+}
+
+// handleBlob handles an HTTP GET or HEAD request for a blob resource.
+func (s *Service) handleBlob(w http.ResponseWriter, r *http.Request, rid string, rule *blobRule) {
+	var blob *codec.BlobResult
+	s.temporaryConn(w, r, func(c *wsConn, cb func([]byte, error)) {
+		c.GetBlob(rid, func(b *codec.BlobResult, err error) {
+			if err != nil {
+				cb(nil, err)
+				return
+			}
+			blob = b
+			cb(nil, errResponseDeferred)
+		})
+	})
+	if blob == nil {
+		return
+	}
+
+	if blob.URL != "" {
+		s.fetchBlob(w, r, rid, rule, blob)
+		return
+	}
+
+	h := w.Header()
+	if blob.ContentType != "" {
+		h.Set("Content-Type", blob.ContentType)
+	}
+	if rule.MaxAge > 0 {
+		h.Set("Cache-Control", "max-age="+strconv.Itoa(rule.MaxAge))
+	}
+	etag := blob.ETag
+	if etag == "" {
+		sum := sha256.Sum256(blob.Data)
+		etag = hex.EncodeToString(sum[:16])
+	}
+	h.Set("ETag", strconv.Quote(etag))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob.Data))
+}
+
+// fetchBlob fetches a blob from its URL and passes on the response.
+func (s *Service) fetchBlob(w http.ResponseWriter, r *http.Request, rid string, rule *blobRule, blob *codec.BlobResult) {
+	u, err := url.Parse(blob.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		s.Errorf("Invalid blob URL for %s: %s", rid, blob.URL)
+		s.httpError(w, r, errInvalidBlobURL, s.enc)
+		return
+	}
+
+	req, err := http.NewRequest(r.Method, blob.URL, nil)
+	if err != nil {
+		s.httpError(w, r, reserr.InternalError(err), s.enc)
+		return
+	}
+	for _, k := range blobRequestHeaders {
+		if v := r.Header.Get(k); v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+
+	resp, err := s.blobClient.Do(req)
+	if err != nil {
+		s.Errorf("Error fetching blob for %s: %s", rid, err)
+		s.httpError(w, r, errBlobUnavailable, s.enc)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		s.httpError(w, r, reserr.ErrNotFound, s.enc)
+		return
+	case resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable:
+		s.Errorf("Error fetching blob for %s: %s", rid, resp.Status)
+		s.httpError(w, r, errBlobUnavailable, s.enc)
+		return
+	}
+
+	h := w.Header()
+	for _, k := range blobResponseHeaders {
+		if v := resp.Header.Get(k); v != "" {
+			h.Set(k, v)
+		}
+	}
+	if blob.ContentType != "" {
+		h.Set("Content-Type", blob.ContentType)
+	}
+	if rule.MaxAge > 0 {
+		h.Set("Cache-Control", "max-age="+strconv.Itoa(rule.MaxAge))
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
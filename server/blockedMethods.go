@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// errMethodBlocked is returned for call and new requests to a blocked method.
+var errMethodBlocked = &reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Method temporarily disabled"}
+
+// blockedMethods is the list of blocked method patterns set through config or
+// the admin endpoint.
+type blockedMethods struct {
+	Methods []string `json:"methods"`
+}
+
+// parseBlockedMethods parses a list of method patterns, where each pattern is
+// a resource pattern matched against the resource name and method name
+// joined by a dot. Eg. "orders.*.delete" or "orders.>"
+func parseBlockedMethods(methods []string) ([]rescache.ResourcePattern, error) {
+	ps := make([]rescache.ResourcePattern, 0, len(methods))
+	for _, m := range methods {
+		p := rescache.ParseResourcePattern(m)
+		if !p.IsValid() {
+			return nil, fmt.Errorf("'%s' must be a valid method pattern", m)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// initBlockedMethods sets the blocked methods from config.
+func (s *Service) initBlockedMethods() {
+	s.blocked = s.cfg.blockedMethods
+	s.blockedRaw = s.cfg.BlockedMethods
+}
+
+// methodBlockedError returns errMethodBlocked if the method on the resource
+// matches any of the blocked method patterns, otherwise nil.
+func (s *Service) methodBlockedError(rname, action string) error {
+	s.blockMu.RLock()
+	defer s.blockMu.RUnlock()
+	if len(s.blocked) == 0 {
+		return nil
+	}
+	method := rname + "." + action
+	for _, p := range s.blocked {
+		if p.Match(method) {
+			return errMethodBlocked
+		}
+	}
+	return nil
+}
+
+// adminBlockedHandler gets or sets the blocked method patterns.
+func (s *Service) adminBlockedHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var b blockedMethods
+		if !decodeAdminRequest(w, r, &b) {
+			return
+		}
+		ps, err := parseBlockedMethods(b.Methods)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Invalid methods: " + err.Error()})
+			return
+		}
+		s.blockMu.Lock()
+		s.blocked = ps
+		s.blockedRaw = b.Methods
+		s.blockMu.Unlock()
+		if len(ps) == 0 {
+			s.Logf("Blocked methods cleared")
+		} else {
+			s.Logf("Blocked methods set: %v", b.Methods)
+		}
+	default:
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+
+	s.blockMu.RLock()
+	b := blockedMethods{Methods: s.blockedRaw}
+	s.blockMu.RUnlock()
+	if b.Methods == nil {
+		b.Methods = []string{}
+	}
+	adminResponse(w, b)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// Bootstrap makes direct subscriptions to the resources on behalf of the
+// client once the connection is authenticated, and sends a single bootstrap
+// event with the resource set of all resources once they are loaded.
+// Resources already directly subscribed are skipped, and resources that fail
+// to be subscribed to are included as errors in the resource set.
+func (c *wsConn) Bootstrap(rids []string) {
+	if !c.persistent() || len(rids) == 0 {
+		return
+	}
+
+	subs := make([]*Subscription, 0, len(rids))
+	seen := make(map[string]bool, len(rids))
+	for _, rid := range rids {
+		rid = c.ExpandCID(rid)
+		if seen[rid] {
+			continue
+		}
+		seen[rid] = true
+		if sub, ok := c.subs[rid]; ok && sub.direct > 0 {
+			continue
+		}
+		sub, err := c.Subscribe(rid, true)
+		if err != nil {
+			c.Debugf("Failed to bootstrap subscription %s: %s", rid, err)
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	if len(subs) == 0 {
+		return
+	}
+
+	r := &rpc.Resources{}
+	failed := make([]bool, len(subs))
+	pending := len(subs)
+	done := func() {
+		pending--
+		if pending > 0 {
+			return
+		}
+		subscribed := make([]string, 0, len(subs))
+		for i, sub := range subs {
+			if failed[i] {
+				continue
+			}
+			sub.populateResources(r)
+			if sub.Error() == nil {
+				subscribed = append(subscribed, sub.RID())
+			}
+		}
+		c.Send(rpc.NewBootstrapEvent(subscribed, r))
+		for i, sub := range subs {
+			if failed[i] || sub.Error() != nil {
+				c.Unsubscribe(sub, true, 1, true)
+			} else {
+				sub.ReleaseRPCResources()
+			}
+		}
+	}
+
+	for i, sub := range subs {
+		i, sub := i, sub
+		sub.CanGet(func(err error) {
+			if err != nil {
+				if r.Errors == nil {
+					r.Errors = make(map[string]*reserr.Error)
+				}
+				r.Errors[sub.RID()] = c.ClientError(err)
+				failed[i] = true
+				done()
+				return
+			}
+			sub.OnReady(done)
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// BruteForceRule holds the configuration for protecting auth methods
+// matching a pattern against brute-force attempts. Failed attempts are
+// tracked by client IP, and optionally by an identifier in the auth
+// parameters, such as a user name. Durations are in milliseconds.
+type BruteForceRule struct {
+	// Pattern is matched against the resource name and auth method name
+	// joined by a dot. Eg. "auth.login"
+	Pattern string `json:"pattern"`
+	// IdentifierParam is the name of an auth parameter to track failed
+	// attempts by, in addition to the client IP.
+	IdentifierParam string `json:"identifierParam,omitempty"`
+	// MaxAttempts is the number of failed attempts within the window that
+	// results in a ban.
+	MaxAttempts int `json:"maxAttempts"`
+	// Window is the time within which failed attempts are counted.
+	Window int `json:"window"`
+	// Delay is the time an auth request is delayed for each previous failed
+	// attempt within the window.
+	Delay int `json:"delay,omitempty"`
+	// MaxDelay is the maximum delay. Zero means no maximum.
+	MaxDelay int `json:"maxDelay,omitempty"`
+	// BanDuration is the time auth requests are rejected after a ban.
+	BanDuration int `json:"banDuration"`
+	// Challenge requires flagged client IPs to respond to a challenge before
+	// further auth requests are sent to the service.
+	Challenge *ChallengeConfig `json:"challenge,omitempty"`
+}
+
+// bruteForceGuard tracks the failed attempts for a brute-force rule.
+type bruteForceGuard struct {
+	BruteForceRule
+	pattern rescache.ResourcePattern
+
+	client *http.Client
+
+	mu         sync.Mutex
+	windows    *windowTable
+	failures   uint64
+	bans       uint64
+	rejected   uint64
+	challenges uint64
+}
+
+// bruteForceStats is the state of a brute-force rule returned by the admin
+// endpoint.
+type bruteForceStats struct {
+	Pattern    string   `json:"pattern"`
+	Failures   uint64   `json:"failures"`
+	Bans       uint64   `json:"bans"`
+	Rejected   uint64   `json:"rejected"`
+	Challenges uint64   `json:"challenges"`
+	Banned     []string `json:"banned"`
+}
+
+var errAuthBanned = &reserr.Error{Code: reserr.CodeAccessDenied, Message: "Too many failed attempts"}
+
+// prepare validates the brute-force rule, and returns the parsed pattern.
+func (r BruteForceRule) prepare() (rescache.ResourcePattern, error) {
+	p := rescache.ParseResourcePattern(r.Pattern)
+	if !p.IsValid() {
+		return p, fmt.Errorf("'%s' must be a valid method pattern", r.Pattern)
+	}
+	if r.MaxAttempts < 1 {
+		return p, errors.New("maxAttempts must be a positive number")
+	}
+	if r.Window < 1 || r.BanDuration < 1 {
+		return p, errors.New("window and banDuration must be a positive number of milliseconds")
+	}
+	if r.Delay < 0 || r.MaxDelay < 0 {
+		return p, errors.New("delay and maxDelay must be zero or a positive number of milliseconds")
+	}
+	if r.Challenge != nil {
+		if err := r.Challenge.prepare(); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// initBruteForce creates a guard for each brute-force rule.
+func (s *Service) initBruteForce() {
+	s.bruteForce = make([]*bruteForceGuard, len(s.cfg.BruteForce))
+	for i, r := range s.cfg.BruteForce {
+		g := &bruteForceGuard{
+			BruteForceRule: r,
+			pattern:        s.cfg.bruteForcePatterns[i],
+			windows:        newWindowTable(r.Window),
+		}
+		if r.Challenge != nil {
+			g.client = &http.Client{Timeout: ChallengeVerifyTimeout}
+		}
+		s.bruteForce[i] = g
+	}
+}
+
+// bruteForceGuard returns the guard of the first rule matching the auth
+// method on the resource, or nil if none matches.
+func (s *Service) bruteForceGuard(rname, action string) *bruteForceGuard {
+	method := rname + "." + action
+	for _, g := range s.bruteForce {
+		if g.pattern.Match(method) {
+			return g
+		}
+	}
+	return nil
+}
+
+// keys returns the keys to track failed attempts by.
+func (g *bruteForceGuard) keys(ip string, params interface{}) []string {
+	keys := []string{"ip:" + ip}
+	if g.IdentifierParam != "" {
+		if p, ok := params.(json.RawMessage); ok {
+			if id := tokenClaim(p, g.IdentifierParam); id != "" {
+				keys = append(keys, "id:"+id)
+			}
+		}
+	}
+	return keys
+}
+
+// check returns errAuthBanned if any of the keys is banned, or if its failed
+// attempts within the window, together with the attempts awaiting a
+// response, reach maxAttempts. Otherwise it counts the auth request as
+// awaiting a response, and returns the delay for it, based on the same
+// number of attempts. An accepted request must be settled by calling either
+// done or cancel.
+func (g *bruteForceGuard) check(keys []string) (time.Duration, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	failures := 0
+	for _, k := range keys {
+		e := g.windows.get(k, now)
+		if e == nil {
+			continue
+		}
+		if e.banned(now) {
+			g.rejected++
+			return 0, errAuthBanned
+		}
+		if n := e.count + e.held; n > failures {
+			failures = n
+		}
+	}
+	if failures >= g.MaxAttempts {
+		g.rejected++
+		return 0, errAuthBanned
+	}
+	for _, k := range keys {
+		g.windows.hold(k, now)
+	}
+	delay := g.Delay * failures
+	if g.MaxDelay > 0 && delay > g.MaxDelay {
+		delay = g.MaxDelay
+	}
+	return msDuration(delay), nil
+}
+
+// cancel settles an auth request accepted by check, but not sent to the
+// service.
+func (g *bruteForceGuard) cancel(keys []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, k := range keys {
+		g.windows.release(k)
+	}
+}
+
+// done settles an auth request accepted by check, and records its outcome.
+// Errors from the service, other than internal errors and timeouts, count as
+// failed attempts, while a successful request clears the failed attempts of
+// the keys. Returns the keys banned by the attempt.
+func (g *bruteForceGuard) done(keys []string, err error) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for _, k := range keys {
+		g.windows.release(k)
+	}
+	if err == nil {
+		for _, k := range keys {
+			g.windows.clear(k, now)
+		}
+		return nil
+	}
+	rerr := reserr.RESError(err)
+	if rerr.Code == reserr.CodeInternalError || rerr.Code == reserr.CodeTimeout {
+		return nil
+	}
+
+	g.windows.sweep(now)
+	g.failures++
+	var banned []string
+	for _, k := range keys {
+		e := g.windows.add(k, now)
+		e.count++
+		if e.count >= g.MaxAttempts {
+			g.windows.ban(e, now, msDuration(g.BanDuration))
+			g.bans++
+			banned = append(banned, k)
+		}
+	}
+	return banned
+}
+
+// stats returns the counters and currently banned keys of the guard.
+func (g *bruteForceGuard) stats() bruteForceStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	return bruteForceStats{
+		Pattern:    g.Pattern,
+		Failures:   g.failures,
+		Bans:       g.bans,
+		Rejected:   g.rejected,
+		Challenges: g.challenges,
+		Banned:     g.windows.list(now, func(e *windowEntry) bool { return e.banned(now) }),
+	}
+}
+
+// adminBruteForceHandler returns the counters and banned keys for each
+// brute-force rule.
+func (s *Service) adminBruteForceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	rules := make([]bruteForceStats, len(s.bruteForce))
+	for i, g := range s.bruteForce {
+		rules[i] = g.stats()
+	}
+	adminResponse(w, struct {
+		Rules []bruteForceStats `json:"rules"`
+	}{rules})
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+	"github.com/rs/xid"
+)
+
+// Default bulk call settings
+const (
+	BulkCallPath          = "/bulk"
+	BulkCallParallelism   = 8
+	BulkCallMaxOperations = 1000
+)
+
+// BulkCallConfig holds the configuration for the HTTP bulk call endpoint,
+// accepting a list of call operations in a single POST request:
+//
+//	{
+//	    "atomic": true,
+//	    "operations": [
+//	        { "rid": "library.books", "method": "new", "params": { "title": "Dune" } },
+//	        { "rid": "library.book.42", "method": "set", "params": { "title": "Emma" }, "ifMatch": "7" }
+//	    ]
+//	}
+//
+// The response holds a result for each operation, in the same order, being
+// either a "result", an "href" to a resource returned by the call, or an
+// "error".
+type BulkCallConfig struct {
+	// Path is the HTTP path of the endpoint. Defaults to "/bulk".
+	Path string `json:"path,omitempty"`
+	// Parallelism is the maximum number of operations of a request being
+	// called at the same time. Defaults to 8.
+	Parallelism int `json:"parallelism,omitempty"`
+	// MaxOperations is the maximum number of operations in a request.
+	// Defaults to 1000.
+	MaxOperations int `json:"maxOperations,omitempty"`
+}
+
+// bulkRequest is the body of a bulk call request.
+type bulkRequest struct {
+	Atomic     bool            `json:"atomic"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+// bulkOperation is a single call operation of a bulk call request.
+type bulkOperation struct {
+	RID            string          `json:"rid"`
+	Method         string          `json:"method"`
+	Params         json.RawMessage `json:"params"`
+	IdempotencyKey string          `json:"idempotencyKey"`
+	IfMatch        string          `json:"ifMatch"`
+}
+
+// bulkResult is the result of a single call operation.
+type bulkResult struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Href   string          `json:"href,omitempty"`
+	Error  *reserr.Error   `json:"error,omitempty"`
+}
+
+// bulkResponse is the body of a bulk call response.
+type bulkResponse struct {
+	Results []bulkResult `json:"results"`
+}
+
+// prepare validates the bulk call configuration and sets default values.
+// The path is validated against the API mounts by Config.apiMountError.
+func (c *BulkCallConfig) prepare(wsPath string) error {
+	if c.Path == "" {
+		c.Path = BulkCallPath
+	}
+	if c.Path[0] != '/' {
+		return errors.New("path must start with a slash (/)")
+	}
+	if c.Path == wsPath {
+		return fmt.Errorf("path %q must not be the wsPath", c.Path)
+	}
+	if c.Parallelism < 0 || c.MaxOperations < 0 {
+		return errors.New("parallelism and maxOperations must be zero or a positive number")
+	}
+	if c.Parallelism == 0 {
+		c.Parallelism = BulkCallParallelism
+	}
+	if c.MaxOperations == 0 {
+		c.MaxOperations = BulkCallMaxOperations
+	}
+	return nil
+}
+
+// isBulkCallPath reports whether the path is that of the bulk call endpoint.
+func (s *Service) isBulkCallPath(path string) bool {
+	return s.cfg.BulkCall != nil && path == s.cfg.BulkCall.Path
+}
+
+// bulkCallHandler handles bulk call requests, calling each operation with
+// bounded parallelism, and responding once all operations are done. Each call
+// request is passed a bulk property holding the bulk request ID, the index of
+// the operation, the number of operations, and the atomic hint.
+func (s *Service) bulkCallHandler(w http.ResponseWriter, r *http.Request) {
+	enc := s.encoder(r)
+	err := s.setCommonHeaders(w, r)
+	if s.xmlEnc != nil {
+		w.Header().Add("Vary", "Accept")
+	}
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		return
+	}
+	if err != nil {
+		s.httpError(w, r, err, enc)
+		return
+	}
+	if r.Method != "POST" {
+		s.httpError(w, r, reserr.ErrMethodNotAllowed, enc)
+		return
+	}
+	if err := s.maintenanceConnError(); err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+
+	var req bulkRequest
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.httpError(w, r, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error reading request body: " + err.Error()}, enc)
+		return
+	}
+	if err := json.Unmarshal(b, &req); err != nil {
+		s.httpError(w, r, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding request body: " + err.Error()}, enc)
+		return
+	}
+	cfg := s.cfg.BulkCall
+	n := len(req.Operations)
+	if n == 0 {
+		s.httpError(w, r, &reserr.Error{Code: reserr.CodeBadRequest, Message: "No operations"}, enc)
+		return
+	}
+	if n > cfg.MaxOperations {
+		s.httpError(w, r, &reserr.Error{Code: reserr.CodeBadRequest, Message: fmt.Sprintf("Too many operations: max %d", cfg.MaxOperations)}, enc)
+		return
+	}
+	for i, op := range req.Operations {
+		if !codec.IsValidRID(op.RID, true) || !codec.IsValidRIDPart(op.Method) {
+			s.httpError(w, r, &reserr.Error{Code: reserr.CodeBadRequest, Message: fmt.Sprintf("Invalid resource ID or method of operation %d", i)}, enc)
+			return
+		}
+		if len(op.IdempotencyKey) > rpc.IdempotencyKeyMaxLength {
+			s.httpError(w, r, &reserr.Error{Code: reserr.CodeBadRequest, Message: fmt.Sprintf("Idempotency key too long in operation %d", i)}, enc)
+			return
+		}
+	}
+
+	id := xid.New().String()
+	results := make([]bulkResult, n)
+	s.temporaryConn(w, r, func(c *wsConn, cb func([]byte, error)) {
+		next, pending := 0, 0
+		var callNext func()
+		callNext = func() {
+			for pending < cfg.Parallelism && next < n {
+				i := next
+				op := req.Operations[i]
+				next++
+				pending++
+				var params interface{}
+				if op.Params != nil {
+					params = op.Params
+				}
+				opts := rpc.CallOptions{
+					IdempotencyKey: op.IdempotencyKey,
+					IfMatch:        op.IfMatch,
+					Bulk:           &codec.BulkInfo{ID: id, Index: i, Count: n, Atomic: req.Atomic},
+				}
+				c.CallHTTPResource(op.RID, op.Method, params, opts, func(result json.RawMessage, refRID string, err error) {
+					switch {
+					case err != nil:
+						results[i].Error = s.clientError(err, r)
+					case refRID != "":
+						results[i].Href = s.cfg.ResourcePath(refRID)
+					case result == nil:
+						results[i].Result = nullBytes
+					default:
+						results[i].Result = result
+					}
+					pending--
+					if next == n && pending == 0 {
+						out, err := json.Marshal(bulkResponse{Results: results})
+						if err == nil {
+							out, err = enc.EncodePOST(out)
+						}
+						cb(out, err)
+						return
+					}
+					callNext()
+				})
+			}
+		}
+		callNext()
+	})
+}
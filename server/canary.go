@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// canaryRouteStats holds the request counters for a configured canary route.
+type canaryRouteStats struct {
+	CanaryRoute
+	Primary rescache.CanaryStats `json:"primary"`
+	Canary  rescache.CanaryStats `json:"canary"`
+}
+
+// adminCanaryHandler returns the request counters for each canary route
+// variant.
+func (s *Service) adminCanaryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	routes := make([]canaryRouteStats, len(s.cfg.canaryRoutes))
+	for i, cr := range s.cfg.canaryRoutes {
+		primary, canary := cr.Stats()
+		routes[i] = canaryRouteStats{
+			CanaryRoute: s.cfg.CanaryRoutes[i],
+			Primary:     primary,
+			Canary:      canary,
+		}
+	}
+	adminResponse(w, struct {
+		Routes []canaryRouteStats `json:"routes"`
+	}{routes})
+}
+
+// shadowRouteStats holds the request counters for a configured shadow route.
+type shadowRouteStats struct {
+	ShadowRoute
+	Shadow rescache.ShadowStats `json:"shadow"`
+}
+
+// adminShadowHandler returns the request and comparison counters for each
+// shadow route.
+func (s *Service) adminShadowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	routes := make([]shadowRouteStats, len(s.cfg.shadowRoutes))
+	for i, sr := range s.cfg.shadowRoutes {
+		routes[i] = shadowRouteStats{
+			ShadowRoute: s.cfg.ShadowRoutes[i],
+			Shadow:      sr.Stats(),
+		}
+	}
+	adminResponse(w, struct {
+		Routes []shadowRouteStats `json:"routes"`
+	}{routes})
+}
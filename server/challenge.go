@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// ChallengeConfig holds the configuration for requiring a challenge response,
+// such as a CAPTCHA token, on auth requests from flagged client IPs. A client
+// IP is flagged once it has a number of failed attempts within the window of
+// the brute-force rule. Challenge responses are verified by posting them to a
+// webhook, which may in turn verify them with a challenge provider.
+type ChallengeConfig struct {
+	// After is the number of failed attempts by a client IP after which a
+	// challenge response is required.
+	After int `json:"after"`
+	// Param is the name of the auth parameter holding the challenge response.
+	// The parameter is removed before the auth request is sent to the service.
+	Param string `json:"param,omitempty"`
+	// Header is the name of the HTTP header holding the challenge response.
+	Header string `json:"header,omitempty"`
+	// VerifyURL is the webhook URL that challenge responses are posted to.
+	VerifyURL string `json:"verifyUrl"`
+	// URL is the URL of the challenge page. It is included in challenge
+	// errors, and HTTP requests requiring a challenge are redirected to it.
+	URL string `json:"url,omitempty"`
+}
+
+// challengeRequest is the body posted to the challenge webhook.
+type challengeRequest struct {
+	Response string `json:"response"`
+	RemoteIP string `json:"remoteIp"`
+}
+
+// challengeResult is the body returned by the challenge webhook.
+type challengeResult struct {
+	Success bool `json:"success"`
+}
+
+// challengeData is the data of a challenge required error.
+type challengeData struct {
+	URL string `json:"url"`
+}
+
+// codeChallengeRequired is the error code for auth requests requiring a
+// challenge response.
+const codeChallengeRequired = "system.challengeRequired"
+
+// prepare validates the challenge configuration.
+func (c ChallengeConfig) prepare() error {
+	if c.After < 1 {
+		return errors.New("challenge after must be a positive number")
+	}
+	if c.Param == "" && c.Header == "" {
+		return errors.New("challenge param or header must be set")
+	}
+	u, err := url.Parse(c.VerifyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("challenge verifyUrl %q must be an absolute http or https URL", c.VerifyURL)
+	}
+	if _, err := url.Parse(c.URL); err != nil {
+		return fmt.Errorf("challenge url %q must be a valid URL", c.URL)
+	}
+	return nil
+}
+
+// challengeError returns the error sent to clients required to respond to a
+// challenge.
+func (g *bruteForceGuard) challengeError() *reserr.Error {
+	rerr := &reserr.Error{Code: codeChallengeRequired, Message: "Challenge required"}
+	if g.Challenge.URL != "" {
+		rerr.Data = challengeData{URL: g.Challenge.URL}
+	}
+	return rerr
+}
+
+// challenged reports whether the client IP has reached the number of failed
+// attempts that requires a challenge response.
+func (g *bruteForceGuard) challenged(ip string) bool {
+	if g.Challenge == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e := g.windows.get("ip:"+ip, time.Now())
+	if e == nil || e.count < g.Challenge.After {
+		return false
+	}
+	g.challenges++
+	return true
+}
+
+// challengeResponse returns the challenge response of an auth request, and the
+// auth parameters with the challenge parameter removed.
+func (g *bruteForceGuard) challengeResponse(r *http.Request, params interface{}) (string, interface{}) {
+	ch := g.Challenge
+	if ch.Param != "" {
+		if p, ok := params.(json.RawMessage); ok {
+			var m map[string]json.RawMessage
+			if json.Unmarshal(p, &m) == nil {
+				if v, ok := m[ch.Param]; ok {
+					var resp string
+					json.Unmarshal(v, &resp)
+					delete(m, ch.Param)
+					p, _ = json.Marshal(m)
+					return resp, p
+				}
+			}
+		}
+	}
+	if ch.Header != "" && r != nil {
+		return r.Header.Get(ch.Header), params
+	}
+	return "", params
+}
+
+// verifyChallenge posts the challenge response to the challenge webhook of
+// the guard, and reports whether it was accepted.
+func (s *Service) verifyChallenge(g *bruteForceGuard, resp, ip string) (bool, error) {
+	data, _ := json.Marshal(challengeRequest{Response: resp, RemoteIP: ip})
+	hr, err := s.postWebhook(g.client, g.Challenge.VerifyURL, data)
+	if err != nil {
+		return false, err
+	}
+	defer hr.Body.Close()
+	if hr.StatusCode >= 300 {
+		return false, errors.New(hr.Status)
+	}
+	var result challengeResult
+	if err := json.NewDecoder(hr.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Default chunking limits
+const (
+	ChunkingMaxChunks = 64
+	ChunkingMaxSize   = 64 << 20
+	ChunkingTimeout   = 30000
+)
+
+// ChunkingConfig holds the configuration for reassembling service responses
+// delivered in chunks, allowing responses larger than the NATS max payload.
+//
+// A service responds to a request with:
+//
+//	{"chunked":{"subject":"<subject>","count":<count>}}
+//
+// The gateway then sends a request with the payload {"index":<index>} on
+// the subject for each chunk, in order, and the raw chunk responses are
+// joined to make the response of the original request.
+type ChunkingConfig struct {
+	// MaxChunks is the maximum number of chunks in a response.
+	// Defaults to 64.
+	MaxChunks int `json:"maxChunks,omitempty"`
+	// MaxSize is the maximum size in bytes of a reassembled response.
+	// Defaults to 64 MiB.
+	MaxSize int `json:"maxSize,omitempty"`
+	// Timeout is the time in milliseconds for fetching all chunks of a
+	// response. Defaults to 30000.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// chunkedResponse is the response of a service delivering a response in
+// chunks.
+type chunkedResponse struct {
+	Subject string `json:"subject"`
+	Count   int    `json:"count"`
+}
+
+// chunkRequest is the payload of a request for a chunk.
+type chunkRequest struct {
+	Index int `json:"index"`
+}
+
+// chunkedClient is a mq.Client reassembling chunked responses.
+type chunkedClient struct {
+	mqWrapper
+	s   *Service
+	cfg ChunkingConfig
+}
+
+// chunkTransfer holds the state of fetching the chunks of a response.
+type chunkTransfer struct {
+	c        *chunkedClient
+	subj     string
+	rsubj    string
+	info     chunkedResponse
+	deadline time.Time
+	buf      []byte
+	cb       mq.Response
+}
+
+var errInvalidChunkedResponse = &reserr.Error{Code: reserr.CodeInternalError, Message: "Invalid chunked response"}
+var errChunkedResponseTooLarge = &reserr.Error{Code: reserr.CodeInternalError, Message: "Chunked response too large"}
+
+// chunkedPrefix is the start of a chunked response.
+var chunkedPrefix = []byte(`{"chunked":`)
+
+// prepare validates the chunking configuration and sets default values.
+func (c *ChunkingConfig) prepare() error {
+	if c.MaxChunks < 0 || c.MaxSize < 0 || c.Timeout < 0 {
+		return errors.New("maxChunks, maxSize, and timeout must be zero or a positive number")
+	}
+	if c.MaxChunks == 0 {
+		c.MaxChunks = ChunkingMaxChunks
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = ChunkingMaxSize
+	}
+	if c.Timeout == 0 {
+		c.Timeout = ChunkingTimeout
+	}
+	return nil
+}
+
+// initChunking wraps the messaging client to reassemble chunked responses,
+// if chunking is configured.
+func (s *Service) initChunking() {
+	if s.cfg.Chunking != nil {
+		s.mq = &chunkedClient{mqWrapper: mqWrapper{s.mq}, s: s, cfg: *s.cfg.Chunking}
+	}
+}
+
+// SendRequest sends the request, and fetches the chunks of the response if
+// the service responds with a chunked response.
+func (c *chunkedClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.Client.SendRequest(subj, payload, func(rsubj string, data []byte, err error) {
+		if err != nil || !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), chunkedPrefix) {
+			cb(rsubj, data, err)
+			return
+		}
+		var r struct {
+			Chunked chunkedResponse `json:"chunked"`
+		}
+		err = json.Unmarshal(data, &r)
+		if err != nil || !codec.IsValidRID(r.Chunked.Subject, false) || r.Chunked.Count < 1 {
+			c.s.Errorf("Invalid chunked response on %s: %s", subj, data)
+			cb(rsubj, nil, errInvalidChunkedResponse)
+			return
+		}
+		if r.Chunked.Count > c.cfg.MaxChunks {
+			c.s.Errorf("Chunked response on %s has %d chunks, exceeding the limit of %d", subj, r.Chunked.Count, c.cfg.MaxChunks)
+			cb(rsubj, nil, errChunkedResponseTooLarge)
+			return
+		}
+		t := &chunkTransfer{
+			c:        c,
+			subj:     subj,
+			rsubj:    rsubj,
+			info:     r.Chunked,
+			deadline: time.Now().Add(msDuration(c.cfg.Timeout)),
+			cb:       cb,
+		}
+		t.fetch(0)
+	})
+}
+
+// fetch requests the chunk with the given index, and calls the callback
+// with the reassembled response once the last chunk is received.
+func (t *chunkTransfer) fetch(idx int) {
+	if time.Now().After(t.deadline) {
+		t.c.s.Errorf("Timeout fetching chunk %d of %d for %s", idx+1, t.info.Count, t.subj)
+		t.cb(t.rsubj, nil, reserr.ErrTimeout)
+		return
+	}
+	payload, _ := json.Marshal(chunkRequest{Index: idx})
+	t.c.Client.SendRequest(t.info.Subject, payload, func(_ string, data []byte, err error) {
+		if err != nil {
+			t.cb(t.rsubj, nil, err)
+			return
+		}
+		if len(t.buf)+len(data) > t.c.cfg.MaxSize {
+			t.c.s.Errorf("Chunked response on %s exceeds the size limit of %d bytes", t.subj, t.c.cfg.MaxSize)
+			t.cb(t.rsubj, nil, errChunkedResponseTooLarge)
+			return
+		}
+		t.buf = append(t.buf, data...)
+		if idx+1 < t.info.Count {
+			t.fetch(idx + 1)
+			return
+		}
+		t.cb(t.rsubj, t.buf, nil)
+	})
+}
@@ -0,0 +1,14 @@
+package server
+
+import "strconv"
+
+// newCID returns a new connection ID, made up of the instance ID followed by
+// a dash and a monotonically increasing connection counter in base 32.
+// As the instance ID is generated on service creation, the connection ID is
+// unique across restarts and gateway instances, and the instance that made
+// the connection can be identified from it.
+// Service.mu is held when called
+func (s *Service) newCID() string {
+	s.cidN++
+	return s.id + "-" + strconv.FormatUint(s.cidN, 32)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// ClientVersionConfig holds the configuration for rejecting connections from
+// clients older than a minimum supported version. Clients declare their name
+// and version in the handshake as "<name>/<version>", eg. "ios/2.3.1".
+type ClientVersionConfig struct {
+	// Header is the name of the handshake header holding the client version.
+	// Defaults to "X-Client-Version".
+	Header string `json:"header,omitempty"`
+	// Query is the name of a URL query parameter holding the client version,
+	// used if the header is not set, since browser clients cannot set
+	// WebSocket handshake headers.
+	Query string `json:"query,omitempty"`
+	// MinVersions maps client names to the minimum supported version. The
+	// name "*" applies to clients not otherwise listed.
+	MinVersions map[string]string `json:"minVersions"`
+	// UpgradeURLs maps client names to a URL where an upgrade is found,
+	// included in the upgrade-required error.
+	UpgradeURLs map[string]string `json:"upgradeUrls,omitempty"`
+	// Require rejects clients not declaring a version.
+	Require bool `json:"require,omitempty"`
+
+	minVersions map[string]version
+}
+
+// version is a parsed version number.
+type version struct {
+	nums []int
+	pre  bool
+}
+
+// codeUpgradeRequired is the error code for connections from clients older
+// than the minimum supported version.
+const codeUpgradeRequired = "system.upgradeRequired"
+
+// upgradeRequired is the data of an upgrade-required error, and the reason
+// of the WebSocket close frame.
+type upgradeRequired struct {
+	Code       string `json:"code,omitempty"`
+	Client     string `json:"client,omitempty"`
+	Version    string `json:"version,omitempty"`
+	MinVersion string `json:"minVersion,omitempty"`
+	UpgradeURL string `json:"upgradeUrl,omitempty"`
+}
+
+// prepare validates the client version configuration, sets default values,
+// and parses the minimum versions.
+func (c *ClientVersionConfig) prepare() error {
+	if len(c.MinVersions) == 0 {
+		return errors.New("minVersions must not be empty")
+	}
+	if c.Header == "" {
+		c.Header = "X-Client-Version"
+	}
+	c.minVersions = make(map[string]version, len(c.MinVersions))
+	for name, v := range c.MinVersions {
+		if name == "" || strings.ContainsRune(name, '/') {
+			return fmt.Errorf("client name %q must be non-empty and not contain a slash", name)
+		}
+		pv, ok := parseVersion(v)
+		if !ok {
+			return fmt.Errorf("minimum version %q for %s must be a dot separated version number", v, name)
+		}
+		c.minVersions[name] = pv
+	}
+	return nil
+}
+
+// parseVersion parses a version of dot separated numbers, such as "2.3.1".
+// An optional leading "v", and any build suffix following a plus sign, are
+// ignored. A pre-release suffix following a dash, such as "2.3.1-beta",
+// makes the version lower than the same version without it.
+func parseVersion(s string) (version, bool) {
+	var v version
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s = s[:i]
+		v.pre = true
+	}
+	if s == "" {
+		return v, false
+	}
+	parts := strings.Split(s, ".")
+	v.nums = make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.nums[i] = n
+	}
+	return v, true
+}
+
+// compareVersions returns -1, 0, or 1 if a is less than, equal to, or
+// greater than b. Missing numbers are treated as zero.
+func compareVersions(a, b version) int {
+	for i := 0; i < len(a.nums) || i < len(b.nums); i++ {
+		var x, y int
+		if i < len(a.nums) {
+			x = a.nums[i]
+		}
+		if i < len(b.nums) {
+			y = b.nums[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case a.pre && !b.pre:
+		return -1
+	case !a.pre && b.pre:
+		return 1
+	}
+	return 0
+}
+
+// clientVersionError returns an upgrade-required error if the client declared
+// in the handshake request is older than its minimum supported version.
+func (s *Service) clientVersionError(r *http.Request) error {
+	c := s.cfg.ClientVersions
+	if c == nil {
+		return nil
+	}
+	decl := r.Header.Get(c.Header)
+	if decl == "" && c.Query != "" {
+		decl = r.URL.Query().Get(c.Query)
+	}
+	if decl == "" {
+		if c.Require {
+			return &reserr.Error{Code: codeUpgradeRequired, Message: "Client version required", Data: upgradeRequired{UpgradeURL: c.UpgradeURLs["*"]}}
+		}
+		return nil
+	}
+
+	name, ver := decl, ""
+	if i := strings.IndexByte(decl, '/'); i >= 0 {
+		name, ver = decl[:i], decl[i+1:]
+	}
+	key := name
+	min, ok := c.minVersions[key]
+	if !ok {
+		key = "*"
+		if min, ok = c.minVersions[key]; !ok {
+			return nil
+		}
+	}
+	if v, ok := parseVersion(ver); ok && compareVersions(v, min) >= 0 {
+		return nil
+	}
+	url, ok := c.UpgradeURLs[name]
+	if !ok {
+		url = c.UpgradeURLs["*"]
+	}
+	return &reserr.Error{Code: codeUpgradeRequired, Message: "Client upgrade required", Data: upgradeRequired{
+		Client:     name,
+		Version:    ver,
+		MinVersion: c.MinVersions[key],
+		UpgradeURL: url,
+	}}
+}
+
+// rejectUpgradeRequired rejects a connection with an upgrade-required error.
+// WebSocket upgrade requests are upgraded and closed with a close frame with
+// status 1008 (Policy Violation), and the error code and version data as
+// reason, since browser clients cannot read the body of a rejected handshake.
+func (s *Service) rejectUpgradeRequired(w http.ResponseWriter, r *http.Request, err error) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		s.httpError(w, r, err, s.enc)
+		return
+	}
+	ws, uerr := s.upgrader.Upgrade(w, r, nil)
+	if uerr != nil {
+		s.Debugf("Failed to upgrade rejected connection from %s: %s", r.RemoteAddr, uerr.Error())
+		return
+	}
+	rerr := reserr.RESError(err)
+	hint, _ := rerr.Data.(upgradeRequired)
+	hint.Code = rerr.Code
+	reason, _ := json.Marshal(hint)
+	// Control frame payloads are limited to 125 bytes, including the 2 byte
+	// status code.
+	if len(reason) > 123 {
+		hint.UpgradeURL = ""
+		reason, _ = json.Marshal(hint)
+	}
+	if len(reason) > 123 {
+		reason, _ = json.Marshal(upgradeRequired{Code: rerr.Code, MinVersion: hint.MinVersion})
+	}
+	_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, string(reason)), time.Now().Add(WSTimeout))
+	ws.Close()
+}
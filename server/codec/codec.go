@@ -10,10 +10,17 @@ import (
 )
 
 var (
-	noQueryGetRequest  = []byte(`{}`)
-	errMissingResult   = reserr.InternalError(errors.New("response missing result"))
-	errInvalidResponse = reserr.InternalError(errors.New("invalid service response"))
-	errInvalidValue    = reserr.InternalError(errors.New("invalid value"))
+	noQueryGetRequest      = []byte(`{}`)
+	errMissingResult       = reserr.InternalError(errors.New("response missing result"))
+	errInvalidResponse     = reserr.InternalError(errors.New("invalid service response"))
+	errInvalidValue        = reserr.InternalError(errors.New("invalid value"))
+	errInvalidRID          = reserr.InternalError(errors.New("invalid resource ID"))
+	errMissingConnSelector = reserr.InternalError(errors.New("missing token claims or tags"))
+	errInvalidRate         = reserr.InternalError(errors.New("invalid rate"))
+	errInvalidDuration     = reserr.InternalError(errors.New("invalid duration"))
+	errMissingResources    = reserr.InternalError(errors.New("missing resources"))
+	errInvalidReason       = reserr.InternalError(errors.New("invalid reason"))
+	errInvalidEventName    = reserr.InternalError(errors.New("invalid event name"))
 )
 
 const (
@@ -23,10 +30,42 @@ const (
 // Request represents a RES-service request
 // https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#requests
 type Request struct {
-	Params interface{} `json:"params,omitempty"`
-	Token  interface{} `json:"token,omitempty"`
-	Query  string      `json:"query,omitempty"`
-	CID    string      `json:"cid"`
+	Params       interface{}   `json:"params,omitempty"`
+	Token        interface{}   `json:"token,omitempty"`
+	Query        string        `json:"query,omitempty"`
+	CID          string        `json:"cid"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+}
+
+// Capabilities represents the negotiated capabilities of the client
+// connection making a request.
+type Capabilities struct {
+	Protocol         string `json:"protocol"`
+	ResourceResponse bool   `json:"resourceResponse"`
+	HTTP             bool   `json:"http,omitempty"`
+}
+
+// CallRequest represents a RES-service call request
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#call-request
+type CallRequest struct {
+	Request
+	CallMeta
+}
+
+// CallMeta holds the optional properties of a RES-service call request,
+// provided by the client.
+type CallMeta struct {
+	IdempotencyKey string    `json:"idempotencyKey,omitempty"`
+	IfMatch        string    `json:"ifMatch,omitempty"`
+	Bulk           *BulkInfo `json:"bulk,omitempty"`
+}
+
+// BulkInfo describes the bulk request a call request is part of.
+type BulkInfo struct {
+	ID     string `json:"id"`
+	Index  int    `json:"index"`
+	Count  int    `json:"count"`
+	Atomic bool   `json:"atomic,omitempty"`
 }
 
 // Response represents a RES-service response
@@ -42,12 +81,30 @@ type Response struct {
 type AccessResponse struct {
 	Result *AccessResult `json:"result"`
 	Error  *reserr.Error `json:"error"`
+	Tags   Tags          `json:"tags"`
+}
+
+// AuthResponse represents the response of a RES-service auth request
+type AuthResponse struct {
+	Response
+	Tags Tags `json:"tags"`
 }
 
+// Tags represents connection tags set by an access or auth response.
+// A nil value removes the tag.
+type Tags map[string]*string
+
 // AccessResult represents the response result of a RES-service access request
 type AccessResult struct {
 	Get  bool   `json:"get"`
 	Call string `json:"call"`
+	// Claims are the token claims that the access depends on. If not nil, a
+	// change of token invalidates the access only if the value of any of the
+	// claims has changed.
+	Claims []string `json:"claims"`
+	// Filter is an event filter expression that all collection add events
+	// and model change events must match to be delivered to the client.
+	Filter string `json:"filter"`
 }
 
 // GetRequest represents a RES-service get request
@@ -66,7 +123,25 @@ type GetResponse struct {
 type GetResult struct {
 	Model      map[string]Value `json:"model"`
 	Collection []Value          `json:"collection"`
+	Stream     []Value          `json:"stream"`
+	Offset     int64            `json:"offset"`
 	Query      string           `json:"query"`
+	TTL        int64            `json:"ttl"`
+}
+
+// BlobResponse represents the response of a RES-service blob request
+type BlobResponse struct {
+	Result *BlobResult   `json:"result"`
+	Error  *reserr.Error `json:"error"`
+}
+
+// BlobResult represent the response result of a RES-service blob request.
+// Either Data or URL is set.
+type BlobResult struct {
+	ContentType string `json:"contentType"`
+	ETag        string `json:"etag"`
+	Data        []byte `json:"data"`
+	URL         string `json:"url"`
 }
 
 // AuthRequest represents a RES-service auth request
@@ -77,6 +152,7 @@ type AuthRequest struct {
 	Host       string      `json:"host,omitempty"`
 	RemoteAddr string      `json:"remoteAddr,omitempty"`
 	URI        string      `json:"uri,omitempty"`
+	Flags      []string    `json:"flags,omitempty"`
 }
 
 // NewResponse represents the response of a RES-service new call request
@@ -126,7 +202,63 @@ type EventQueryEvent struct {
 // ConnTokenEvent represents a RES-server connection token event
 // https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#connection-token-event
 type ConnTokenEvent struct {
-	Token json.RawMessage `json:"token"`
+	Token     json.RawMessage `json:"token"`
+	Bootstrap []string        `json:"bootstrap"`
+}
+
+// ConnSubscribeEvent represents a RES-server connection subscribe event
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#connection-subscribe-event
+type ConnSubscribeEvent struct {
+	RID string `json:"rid"`
+}
+
+// ConnSelector represents the connection selector of a RES-server system event
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#connection-selector
+type ConnSelector struct {
+	Token map[string]json.RawMessage `json:"token"`
+	Tags  map[string]string          `json:"tags"`
+}
+
+// IsEmpty reports whether the selector has neither token claims nor tags.
+func (cs ConnSelector) IsEmpty() bool {
+	return cs.Token == nil && cs.Tags == nil
+}
+
+// SystemSubscribeEvent represents a RES-server system subscribe event
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#system-subscribe-event
+type SystemSubscribeEvent struct {
+	RID string `json:"rid"`
+	ConnSelector
+}
+
+// SystemDisconnectEvent represents a RES-server system disconnect event
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#system-disconnect-event
+type SystemDisconnectEvent struct {
+	ConnSelector
+}
+
+// SystemThrottleEvent represents a RES-server system throttle event
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#system-throttle-event
+type SystemThrottleEvent struct {
+	Rate     float64 `json:"rate"`
+	Duration int     `json:"duration"`
+	ConnSelector
+}
+
+// SystemRevokeEvent represents a RES-server system revoke event
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#system-revoke-event
+type SystemRevokeEvent struct {
+	Resources []string      `json:"resources"`
+	Reason    *reserr.Error `json:"reason"`
+	ConnSelector
+}
+
+// SystemBroadcastEvent represents a RES-server system broadcast event
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#system-broadcast-event
+type SystemBroadcastEvent struct {
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data"`
+	ConnSelector
 }
 
 // ChangeEvent represent a RES-server model change event
@@ -142,6 +274,12 @@ type AddEvent struct {
 	Value Value `json:"value"`
 }
 
+// AppendEvent represent a RES-server stream append event
+// https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#stream-append-event
+type AppendEvent struct {
+	Value Value `json:"value"`
+}
+
 // RemoveEvent represent a RES-server collection remove event
 // https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#collection-remove-event
 type RemoveEvent struct {
@@ -159,14 +297,23 @@ type SystemReset struct {
 type Requester interface {
 	// CID returns the connection of the requester
 	CID() string
+	// Capabilities returns the capabilities of the requester's connection,
+	// or nil if they should not be included in the request.
+	Capabilities() *Capabilities
 }
 
 // AuthRequester is the connection making the auth request
 type AuthRequester interface {
 	// CID returns the connection of the requester
 	CID() string
+	// Capabilities returns the capabilities of the requester's connection,
+	// or nil if they should not be included in the request.
+	Capabilities() *Capabilities
 	// HTTPRequest returns the http.Request from requesters (upgraded) HTTP connection
 	HTTPRequest() *http.Request
+	// HeaderFlags returns the names of the headers not meeting a flagged
+	// header requirement.
+	HeaderFlags() []string
 }
 
 // ValueType is an enum reprenting the value type
@@ -221,30 +368,30 @@ func (v *Value) UnmarshalJSON(data []byte) error {
 	switch c {
 	case '{':
 		var mvo ValueObject
-		err = json.Unmarshal(v.RawMessage, &mvo)
+		err = unmarshal(v.RawMessage, &mvo)
 		if err != nil {
 			return err
 		}
 
 		if mvo.RID != nil {
 			// Invalid to have both RID and Action set, or if RID is empty
-			if mvo.Action != nil || *mvo.RID == "" {
-				return errInvalidValue
+			if mvo.Action != nil {
+				return invalidValue(data, "both rid and action set")
 			}
 			v.Type = ValueTypeResource
 			v.RID = *mvo.RID
 			if !IsValidRID(v.RID, true) {
-				return errInvalidValue
+				return invalidValue(data, "invalid resource ID")
 			}
 		} else {
 			// Must be an action of type actionDelete
 			if mvo.Action == nil || *mvo.Action != actionDelete {
-				return errInvalidValue
+				return invalidValue(data, "object must be a resource reference or a delete action")
 			}
 			v.Type = ValueTypeDelete
 		}
 	case '[':
-		return errInvalidValue
+		return invalidValue(data, "arrays are not allowed")
 	default:
 		v.Type = ValueTypePrimitive
 	}
@@ -270,7 +417,13 @@ func (v Value) Equal(w Value) bool {
 
 // CreateRequest creates a JSON encoded RES-service request
 func CreateRequest(params interface{}, r Requester, query string, token interface{}) []byte {
-	out, _ := json.Marshal(Request{Params: params, Token: token, Query: query, CID: r.CID()})
+	out, _ := json.Marshal(Request{Params: params, Token: token, Query: query, CID: r.CID(), Capabilities: r.Capabilities()})
+	return out
+}
+
+// CreateCallRequest creates a JSON encoded RES-service call request
+func CreateCallRequest(params interface{}, r Requester, query string, token interface{}, meta CallMeta) []byte {
+	out, _ := json.Marshal(CallRequest{Request: Request{Params: params, Token: token, Query: query, CID: r.CID(), Capabilities: r.Capabilities()}, CallMeta: meta})
 	return out
 }
 
@@ -287,11 +440,12 @@ func CreateGetRequest(query string) []byte {
 func CreateAuthRequest(params interface{}, r AuthRequester, query string, token interface{}) []byte {
 	hr := r.HTTPRequest()
 	out, _ := json.Marshal(AuthRequest{
-		Request:    Request{Params: params, Token: token, Query: query, CID: r.CID()},
+		Request:    Request{Params: params, Token: token, Query: query, CID: r.CID(), Capabilities: r.Capabilities()},
 		Header:     hr.Header,
 		Host:       hr.Host,
 		RemoteAddr: hr.RemoteAddr,
 		URI:        hr.RequestURI,
+		Flags:      r.HeaderFlags(),
 	})
 	return out
 }
@@ -299,9 +453,13 @@ func CreateAuthRequest(params interface{}, r AuthRequester, query string, token
 // DecodeGetResponse decodes a JSON encoded RES-service get response
 func DecodeGetResponse(payload []byte) (*GetResult, error) {
 	var r GetResponse
-	err := json.Unmarshal(payload, &r)
+	err := unmarshal(payload, &r)
 	if err != nil {
-		return nil, reserr.InternalError(err)
+		return nil, reserr.RESError(err)
+	}
+
+	if err := checkResultAndError(r.Result != nil, r.Error); err != nil {
+		return nil, err
 	}
 
 	if r.Error != nil {
@@ -312,10 +470,10 @@ func DecodeGetResponse(payload []byte) (*GetResult, error) {
 		return nil, errMissingResult
 	}
 
-	// Assert we got either a model or a collection
+	// Assert we got either a model, a collection, or a stream
 	res := r.Result
 	if res.Model != nil {
-		if res.Collection != nil {
+		if res.Collection != nil || res.Stream != nil {
 			return nil, errInvalidResponse
 		}
 		// Assert model only has proper values
@@ -325,16 +483,61 @@ func DecodeGetResponse(payload []byte) (*GetResult, error) {
 			}
 		}
 	} else if res.Collection != nil {
+		if res.Stream != nil {
+			return nil, errInvalidResponse
+		}
 		// Assert collection only has proper values
 		for _, v := range res.Collection {
 			if v.Type != ValueTypeResource && v.Type != ValueTypePrimitive {
 				return nil, errInvalidResponse
 			}
 		}
+	} else if res.Stream != nil {
+		// Assert stream only has primitive values and a valid offset
+		if res.Offset < 0 {
+			return nil, errInvalidResponse
+		}
+		for _, v := range res.Stream {
+			if v.Type != ValueTypePrimitive {
+				return nil, errInvalidResponse
+			}
+		}
 	} else {
 		return nil, errInvalidResponse
 	}
 
+	if res.TTL < 0 {
+		return nil, errInvalidResponse
+	}
+
+	return r.Result, nil
+}
+
+// DecodeBlobResponse decodes a JSON encoded RES-service blob response
+func DecodeBlobResponse(payload []byte) (*BlobResult, error) {
+	var r BlobResponse
+	err := unmarshal(payload, &r)
+	if err != nil {
+		return nil, reserr.RESError(err)
+	}
+
+	if err := checkResultAndError(r.Result != nil, r.Error); err != nil {
+		return nil, err
+	}
+
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	if r.Result == nil {
+		return nil, errMissingResult
+	}
+
+	// Assert we got either data or a URL
+	if (r.Result.Data == nil) == (r.Result.URL == "") {
+		return nil, errInvalidResponse
+	}
+
 	return r.Result, nil
 }
 
@@ -345,7 +548,7 @@ func DecodeEvent(payload []byte) (json.RawMessage, error) {
 		return ev, nil
 	}
 
-	err := json.Unmarshal(payload, &ev)
+	err := unmarshal(payload, &ev)
 	if err != nil {
 		return nil, reserr.RESError(err)
 	}
@@ -355,7 +558,7 @@ func DecodeEvent(payload []byte) (json.RawMessage, error) {
 // DecodeQueryEvent decodes a JSON encoded query event
 func DecodeQueryEvent(payload []byte) (*QueryEvent, error) {
 	var qe QueryEvent
-	err := json.Unmarshal(payload, &qe)
+	err := unmarshal(payload, &qe)
 	if err != nil {
 		return nil, reserr.RESError(err)
 	}
@@ -371,11 +574,15 @@ func CreateEventQueryRequest(query string) []byte {
 // DecodeEventQueryResponse decodes a JSON encoded RES-service event query response
 func DecodeEventQueryResponse(payload []byte) (*EventQueryResult, error) {
 	var r EventQueryResponse
-	err := json.Unmarshal(payload, &r)
+	err := unmarshal(payload, &r)
 	if err != nil {
 		return nil, reserr.RESError(err)
 	}
 
+	if err := checkResultAndError(r.Result != nil, r.Error); err != nil {
+		return nil, err
+	}
+
 	if r.Error != nil {
 		return nil, r.Error
 	}
@@ -413,33 +620,6 @@ func DecodeEventQueryResponse(payload []byte) (*EventQueryResult, error) {
 	return res, nil
 }
 
-// IsLegacyChangeEvent returns true if the model change event is detected as v1.0 legacy
-// [DEPRECATED:deprecatedModelChangeEvent]
-func IsLegacyChangeEvent(data json.RawMessage) bool {
-	var r map[string]json.RawMessage
-	err := json.Unmarshal(data, &r)
-	if err != nil {
-		return false
-	}
-
-	if len(r) != 1 {
-		return true
-	}
-
-	v, ok := r["values"]
-	if !ok {
-		return true
-	}
-
-	for _, c := range v {
-		// Check character unless it is a whitespace
-		if c != '\t' && c != '\n' && c != '\r' && c != ' ' {
-			return c != '{'
-		}
-	}
-	return true
-}
-
 // EncodeChangeEvent creates a JSON encoded RES-service change event
 func EncodeChangeEvent(values map[string]Value) json.RawMessage {
 	data, _ := json.Marshal(ChangeEvent{Values: values})
@@ -449,7 +629,7 @@ func EncodeChangeEvent(values map[string]Value) json.RawMessage {
 // DecodeChangeEvent decodes a JSON encoded RES-service model change event
 func DecodeChangeEvent(data json.RawMessage) (map[string]Value, error) {
 	var r ChangeEvent
-	err := json.Unmarshal(data, &r)
+	err := unmarshal(data, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -457,17 +637,6 @@ func DecodeChangeEvent(data json.RawMessage) (map[string]Value, error) {
 	return r.Values, nil
 }
 
-// DecodeLegacyChangeEvent decodes a JSON encoded RES-service v1.0 model change event
-func DecodeLegacyChangeEvent(data json.RawMessage) (map[string]Value, error) {
-	var r map[string]Value
-	err := json.Unmarshal(data, &r)
-	if err != nil {
-		return nil, err
-	}
-
-	return r, nil
-}
-
 // EncodeAddEvent creates a JSON encoded RES-service collection add event
 func EncodeAddEvent(d *AddEvent) json.RawMessage {
 	data, _ := json.Marshal(d)
@@ -477,7 +646,7 @@ func EncodeAddEvent(d *AddEvent) json.RawMessage {
 // DecodeAddEvent decodes a JSON encoded RES-service collection add event
 func DecodeAddEvent(data json.RawMessage) (*AddEvent, error) {
 	var d AddEvent
-	err := json.Unmarshal(data, &d)
+	err := unmarshal(data, &d)
 	if err != nil {
 		return nil, err
 	}
@@ -491,6 +660,28 @@ func DecodeAddEvent(data json.RawMessage) (*AddEvent, error) {
 	return &d, nil
 }
 
+// EncodeAppendEvent creates a JSON encoded RES-service stream append event
+func EncodeAppendEvent(d *AppendEvent) json.RawMessage {
+	data, _ := json.Marshal(d)
+	return json.RawMessage(data)
+}
+
+// DecodeAppendEvent decodes a JSON encoded RES-service stream append event
+func DecodeAppendEvent(data json.RawMessage) (*AppendEvent, error) {
+	var d AppendEvent
+	err := unmarshal(data, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assert it is a primitive value
+	if d.Value.Type != ValueTypePrimitive {
+		return nil, errInvalidValue
+	}
+
+	return &d, nil
+}
+
 // EncodeRemoveEvent creates a JSON encoded RES-service collection remove event
 func EncodeRemoveEvent(d *RemoveEvent) json.RawMessage {
 	data, _ := json.Marshal(d)
@@ -500,7 +691,7 @@ func EncodeRemoveEvent(d *RemoveEvent) json.RawMessage {
 // DecodeRemoveEvent decodes a JSON encoded RES-service collection remove event
 func DecodeRemoveEvent(data json.RawMessage) (*RemoveEvent, error) {
 	var d RemoveEvent
-	err := json.Unmarshal(data, &d)
+	err := unmarshal(data, &d)
 	if err != nil {
 		return nil, err
 	}
@@ -509,31 +700,59 @@ func DecodeRemoveEvent(data json.RawMessage) (*RemoveEvent, error) {
 }
 
 // DecodeAccessResponse decodes a JSON encoded RES-service access response
-func DecodeAccessResponse(payload []byte) (*AccessResult, *reserr.Error) {
+func DecodeAccessResponse(payload []byte) (*AccessResult, Tags, *reserr.Error) {
 	var r AccessResponse
-	err := json.Unmarshal(payload, &r)
+	err := unmarshal(payload, &r)
 	if err != nil {
-		return nil, reserr.RESError(err)
+		return nil, nil, reserr.RESError(err)
+	}
+
+	if err := checkResultAndError(r.Result != nil, r.Error); err != nil {
+		return nil, nil, reserr.RESError(err)
 	}
 
 	if r.Error != nil {
-		return nil, r.Error
+		return nil, nil, r.Error
 	}
 
 	if r.Result == nil {
-		return nil, errMissingResult
+		return nil, nil, errMissingResult
 	}
 
-	return r.Result, nil
+	return r.Result, r.Tags, nil
 }
 
 // DecodeCallResponse decodes a JSON encoded RES-service call response
 func DecodeCallResponse(payload []byte) (json.RawMessage, string, error) {
 	var r Response
-	err := json.Unmarshal(payload, &r)
+	err := unmarshal(payload, &r)
 	if err != nil {
 		return nil, "", reserr.RESError(err)
 	}
+	return decodeResponse(&r)
+}
+
+// DecodeAuthResponse decodes a JSON encoded RES-service auth response
+func DecodeAuthResponse(payload []byte) (json.RawMessage, string, Tags, error) {
+	var r AuthResponse
+	err := unmarshal(payload, &r)
+	if err != nil {
+		return nil, "", nil, reserr.RESError(err)
+	}
+	result, rid, err := decodeResponse(&r.Response)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return result, rid, r.Tags, nil
+}
+
+func decodeResponse(r *Response) (json.RawMessage, string, error) {
+	if err := checkResultAndError(r.Result != nil || r.Resource != nil, r.Error); err != nil {
+		return nil, "", err
+	}
+	if r.Resource != nil && r.Result != nil && IsStrict() {
+		return nil, "", strictError("response must not contain both result and resource")
+	}
 
 	if r.Error != nil {
 		return nil, "", r.Error
@@ -542,6 +761,9 @@ func DecodeCallResponse(payload []byte) (json.RawMessage, string, error) {
 	if r.Resource != nil {
 		rid := r.Resource.RID
 		if !IsValidRID(rid, true) {
+			if IsStrict() {
+				return nil, "", strictError("invalid resource ID %q in resource response", rid)
+			}
 			return nil, "", errInvalidResponse
 		}
 		return nil, rid, nil
@@ -554,39 +776,108 @@ func DecodeCallResponse(payload []byte) (json.RawMessage, string, error) {
 	return r.Result, "", nil
 }
 
-// TryDecodeLegacyNewResult tries to detect legacy v1.1.1 behavior.
-// Returns empty string and nil error when the result is not detected as legacy.
-// [DEPRECATED:deprecatedNewCallRequest]
-func TryDecodeLegacyNewResult(result json.RawMessage) (string, error) {
-	var r map[string]interface{}
-	err := json.Unmarshal(result, &r)
+// DecodeConnTokenEvent decodes a JSON encoded RES-service connection token event
+func DecodeConnTokenEvent(payload []byte) (*ConnTokenEvent, error) {
+	var e ConnTokenEvent
+	err := unmarshal(payload, &e)
+	if err != nil {
+		return nil, reserr.RESError(err)
+	}
+	for _, rid := range e.Bootstrap {
+		if !IsValidRID(rid, true) {
+			return nil, errInvalidRID
+		}
+	}
+	return &e, nil
+}
+
+// DecodeConnSubscribeEvent decodes a JSON encoded RES-service connection subscribe event
+func DecodeConnSubscribeEvent(payload []byte) (*ConnSubscribeEvent, error) {
+	var e ConnSubscribeEvent
+	err := unmarshal(payload, &e)
 	if err != nil {
-		return "", nil
+		return nil, reserr.RESError(err)
+	}
+	if !IsValidRID(e.RID, true) {
+		return nil, errInvalidRID
 	}
+	return &e, nil
+}
 
-	if len(r) != 1 {
-		return "", nil
+// DecodeSystemSubscribeEvent decodes a JSON encoded RES-service system subscribe event
+func DecodeSystemSubscribeEvent(payload []byte) (*SystemSubscribeEvent, error) {
+	var e SystemSubscribeEvent
+	err := unmarshal(payload, &e)
+	if err != nil {
+		return nil, reserr.RESError(err)
+	}
+	if !IsValidRID(e.RID, true) {
+		return nil, errInvalidRID
+	}
+	if e.IsEmpty() {
+		return nil, errMissingConnSelector
 	}
+	return &e, nil
+}
 
-	rid, ok := r["rid"].(string)
-	if !ok {
-		return "", nil
+// DecodeSystemDisconnectEvent decodes a JSON encoded RES-service system disconnect event
+func DecodeSystemDisconnectEvent(payload []byte) (*SystemDisconnectEvent, error) {
+	var e SystemDisconnectEvent
+	err := unmarshal(payload, &e)
+	if err != nil {
+		return nil, reserr.RESError(err)
+	}
+	if e.IsEmpty() {
+		return nil, errMissingConnSelector
 	}
+	return &e, nil
+}
 
-	if !IsValidRID(rid, true) {
-		return "", errInvalidResponse
+// DecodeSystemRevokeEvent decodes a JSON encoded RES-service system revoke event
+func DecodeSystemRevokeEvent(payload []byte) (*SystemRevokeEvent, error) {
+	var e SystemRevokeEvent
+	err := unmarshal(payload, &e)
+	if err != nil {
+		return nil, reserr.RESError(err)
+	}
+	if len(e.Resources) == 0 {
+		return nil, errMissingResources
+	}
+	if e.Reason != nil && e.Reason.Code == "" {
+		return nil, errInvalidReason
 	}
+	return &e, nil
+}
 
-	return rid, nil
+// DecodeSystemThrottleEvent decodes a JSON encoded RES-service system throttle event
+func DecodeSystemThrottleEvent(payload []byte) (*SystemThrottleEvent, error) {
+	var e SystemThrottleEvent
+	err := unmarshal(payload, &e)
+	if err != nil {
+		return nil, reserr.RESError(err)
+	}
+	if e.IsEmpty() {
+		return nil, errMissingConnSelector
+	}
+	if e.Rate < 0 {
+		return nil, errInvalidRate
+	}
+	if e.Duration < 0 {
+		return nil, errInvalidDuration
+	}
+	return &e, nil
 }
 
-// DecodeConnTokenEvent decodes a JSON encoded RES-service connection token event
-func DecodeConnTokenEvent(payload []byte) (*ConnTokenEvent, error) {
-	var e ConnTokenEvent
-	err := json.Unmarshal(payload, &e)
+// DecodeSystemBroadcastEvent decodes a JSON encoded RES-service system broadcast event
+func DecodeSystemBroadcastEvent(payload []byte) (*SystemBroadcastEvent, error) {
+	var e SystemBroadcastEvent
+	err := unmarshal(payload, &e)
 	if err != nil {
 		return nil, reserr.RESError(err)
 	}
+	if !IsValidRIDPart(e.Name) {
+		return nil, errInvalidEventName
+	}
 	return &e, nil
 }
 
@@ -597,7 +888,7 @@ func DecodeSystemReset(data json.RawMessage) (SystemReset, error) {
 		return r, nil
 	}
 
-	err := json.Unmarshal(data, &r)
+	err := unmarshal(data, &r)
 	if err != nil {
 		return r, err
 	}
@@ -0,0 +1,159 @@
+//go:build go1.18
+// +build go1.18
+
+package codec
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Fuzz test that decoding any service message payload, as received over
+// NATS, never panics, and that successfully decoded values are valid.
+func FuzzDecodeServiceMessage(f *testing.F) {
+	f.Add([]byte(`{"result":{"model":{"foo":"bar","ref":{"rid":"test.model"}}}}`))
+	f.Add([]byte(`{"result":{"collection":[1,{"rid":"test.model"}]},"query":"q=foo"}`))
+	f.Add([]byte(`{"result":{"get":true,"call":"*"},"tags":{"plan":"free","region":null}}`))
+	f.Add([]byte(`{"resource":{"rid":"test.model"}}`))
+	f.Add([]byte(`{"error":{"code":"system.notFound","message":"Not found"}}`))
+	f.Add([]byte(`{"values":{"foo":{"action":"delete"}}}`))
+	f.Add([]byte(`{"idx":0,"value":{"rid":"test.model"}}`))
+	f.Add([]byte(`{"subject":"_EVENT_01_"}`))
+	f.Add([]byte(`{"events":[{"event":"add","data":{"idx":0,"value":1}}]}`))
+	f.Add([]byte(`{"resources":["test.>"],"access":["test.model"]}`))
+	f.Add([]byte(`{"token":{"user":"foo"}}`))
+	f.Add([]byte(`{"rid":"test.model","token":{"user":"foo"},"tags":{"plan":"free"}}`))
+	f.Add([]byte(`{"name":"upgrade","data":{"foo":"bar"},"tags":{"plan":"free"}}`))
+	f.Add([]byte(`{"rate":10,"tags":{"plan":"free"}}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if r, err := DecodeGetResponse(data); err == nil {
+			if r.Model == nil && r.Collection == nil {
+				t.Fatalf("expected decoded get result to have a model or collection")
+			}
+			assertValidValues(t, r.Model)
+			for _, v := range r.Collection {
+				assertValidValue(t, v)
+			}
+		}
+		if r, _, err := DecodeAccessResponse(data); err == nil && r == nil {
+			t.Fatalf("expected decoded access result not to be nil")
+		}
+		if result, rid, err := DecodeCallResponse(data); err == nil {
+			assertRIDOrResult(t, result, rid)
+		}
+		if result, rid, _, err := DecodeAuthResponse(data); err == nil {
+			assertRIDOrResult(t, result, rid)
+		}
+		DecodeEvent(data)
+		DecodeQueryEvent(data)
+		DecodeEventQueryResponse(data)
+		if values, _, err := DecodeAnyChangeEvent(data); err == nil {
+			assertValidValues(t, values)
+		}
+		if ev, err := DecodeAddEvent(data); err == nil {
+			assertValidValue(t, ev.Value)
+		}
+		DecodeRemoveEvent(data)
+		DecodeConnTokenEvent(data)
+		if ev, err := DecodeConnSubscribeEvent(data); err == nil && !IsValidRID(ev.RID, true) {
+			t.Fatalf("expected connection subscribe event rid to be valid, but got %#v", ev.RID)
+		}
+		if ev, err := DecodeSystemSubscribeEvent(data); err == nil && !IsValidRID(ev.RID, true) {
+			t.Fatalf("expected system subscribe event rid to be valid, but got %#v", ev.RID)
+		}
+		DecodeSystemDisconnectEvent(data)
+		DecodeSystemThrottleEvent(data)
+		if ev, err := DecodeSystemBroadcastEvent(data); err == nil && !IsValidRIDPart(ev.Name) {
+			t.Fatalf("expected system broadcast event name to be valid, but got %#v", ev.Name)
+		}
+		DecodeSystemReset(data)
+	})
+}
+
+// Fuzz test that decoding any change event payload does not panic, and that
+// decoded values survive a round trip through both the legacy and current
+// event formats.
+func FuzzDecodeAnyChangeEvent(f *testing.F) {
+	f.Add([]byte(`{"values":{"foo":"bar"}}`))
+	f.Add([]byte(`{"foo":"bar","ref":{"rid":"test.model"}}`))
+	f.Add([]byte(`{"values":"bar"}`))
+	f.Add([]byte(`{"deleted":{"action":"delete"}}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		values, _, err := DecodeAnyChangeEvent(json.RawMessage(data))
+		if err != nil {
+			return
+		}
+		assertChangeEventRoundTrip(t, values, 0)
+	})
+}
+
+// Fuzz test that detecting and decoding a legacy new call result does not
+// panic, and that a detected resource ID survives a round trip.
+func FuzzTryDecodeLegacyNewResult(f *testing.F) {
+	f.Add([]byte(`{"rid":"test.model"}`))
+	f.Add([]byte(`{"rid":"test.model?q=foo"}`))
+	f.Add([]byte(`{"rid":""}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rid, err := TryDecodeLegacyNewResult(json.RawMessage(data))
+		if err != nil || rid == "" {
+			return
+		}
+		rid2, err := TryDecodeLegacyNewResult(EncodeLegacyNewResult(rid))
+		if err != nil || rid2 != rid {
+			t.Fatalf("expected round trip rid %#v, but got %#v (%v)", rid, rid2, err)
+		}
+	})
+}
+
+// Fuzz test that a parsed protocol version contains only digits and dots,
+// and is formatted back to a version that parses to the same value.
+func FuzzParseProtocolVersion(f *testing.F) {
+	f.Add("1.2.0")
+	f.Add("1.999.999")
+	f.Add("0.0.1")
+	f.Fuzz(func(t *testing.T, protocol string) {
+		v, err := ParseProtocolVersion(protocol)
+		if err != nil {
+			return
+		}
+		for _, c := range protocol {
+			if c != '.' && (c < '0' || c > '9') {
+				t.Fatalf("expected %#v to be rejected, but got %d", protocol, v)
+			}
+		}
+		v2, err := ParseProtocolVersion(FormatProtocolVersion(v))
+		if err != nil || v2 != v {
+			t.Fatalf("expected %#v to round trip as %d, but got %d (%v)", protocol, v, v2, err)
+		}
+	})
+}
+
+func assertRIDOrResult(t *testing.T, result json.RawMessage, rid string) {
+	if rid != "" {
+		if !IsValidRID(rid, true) {
+			t.Fatalf("expected decoded resource ID to be valid, but got %#v", rid)
+		}
+		return
+	}
+	if !json.Valid(result) {
+		t.Fatalf("expected decoded result to be valid JSON, but got %s", result)
+	}
+}
+
+func assertValidValues(t *testing.T, values map[string]Value) {
+	for _, v := range values {
+		assertValidValue(t, v)
+	}
+}
+
+func assertValidValue(t *testing.T, v Value) {
+	switch v.Type {
+	case ValueTypeResource:
+		if !IsValidRID(v.RID, true) {
+			t.Fatalf("expected resource value rid to be valid, but got %#v", v.RID)
+		}
+	case ValueTypePrimitive, ValueTypeDelete:
+	default:
+		t.Fatalf("expected a valid value type, but got %d for %s", v.Type, v.RawMessage)
+	}
+}
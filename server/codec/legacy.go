@@ -0,0 +1,193 @@
+package codec
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Protocol versions are encoded as integers:
+//
+//	MAJOR * 1000000 + MINOR * 1000 + PATCH
+const (
+	// LegacyProtocol is the client protocol version assumed when a client
+	// makes no version request.
+	LegacyProtocol = 1001001
+	// LatestProtocol is the highest client protocol version supported.
+	LatestProtocol = 1999999
+
+	// versionCallResourceResponse is the last client protocol version
+	// without support for resource responses to call and auth requests.
+	versionCallResourceResponse = 1001001
+)
+
+// ParseProtocolVersion parses a protocol version string in the format
+// "[MAJOR].[MINOR].[PATCH]". It returns reserr.ErrInvalidParams if the
+// string is malformed, and reserr.ErrUnsupportedProtocol if the major version
+// is not 1.
+func ParseProtocolVersion(protocol string) (int, error) {
+	parts := strings.Split(protocol, ".")
+	if len(parts) != 3 {
+		return 0, reserr.ErrInvalidParams
+	}
+
+	v := 0
+	for i := 0; i < 3; i++ {
+		// Only allow digits, as Atoi also accepts a sign
+		if !isDigits(parts[i]) {
+			return 0, reserr.ErrInvalidParams
+		}
+		p, err := strconv.Atoi(parts[i])
+		if err != nil || p >= 1000 {
+			return 0, reserr.ErrInvalidParams
+		}
+		v *= 1000
+		v += p
+	}
+
+	if v < 1000000 || v >= 2000000 {
+		return 0, reserr.ErrUnsupportedProtocol
+	}
+	return v, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatProtocolVersion formats an encoded protocol version as a string in
+// the format "[MAJOR].[MINOR].[PATCH]".
+func FormatProtocolVersion(v int) string {
+	return strconv.Itoa(v/1000000) + "." + strconv.Itoa(v/1000%1000) + "." + strconv.Itoa(v%1000)
+}
+
+// SupportsResourceResponse reports whether a client using the protocol
+// version supports resource responses to call and auth requests.
+func SupportsResourceResponse(v int) bool {
+	return v > versionCallResourceResponse
+}
+
+// EncodeLegacyCallResult translates the result of a call or auth request
+// into the format used by clients not supporting resource responses. A
+// resource response is translated into a result object with the resource
+// ID, without a subscription being made. Otherwise the result is returned
+// as it is.
+func EncodeLegacyCallResult(result json.RawMessage, rid string) json.RawMessage {
+	if rid == "" {
+		return result
+	}
+	out, _ := json.Marshal(Resource{RID: rid})
+	return out
+}
+
+// IsLegacyChangeEvent returns true if the model change event is detected as v1.0 legacy
+// [DEPRECATED:deprecatedModelChangeEvent]
+func IsLegacyChangeEvent(data json.RawMessage) bool {
+	var r map[string]json.RawMessage
+	err := json.Unmarshal(data, &r)
+	if err != nil {
+		return false
+	}
+
+	if len(r) != 1 {
+		return true
+	}
+
+	v, ok := r["values"]
+	if !ok {
+		return true
+	}
+
+	for _, c := range v {
+		// Check character unless it is a whitespace
+		if c != '\t' && c != '\n' && c != '\r' && c != ' ' {
+			return c != '{'
+		}
+	}
+	return true
+}
+
+// EncodeLegacyChangeEvent creates a JSON encoded RES-service v1.0 model change event
+// [DEPRECATED:deprecatedModelChangeEvent]
+func EncodeLegacyChangeEvent(values map[string]Value) json.RawMessage {
+	if values == nil {
+		values = map[string]Value{}
+	}
+	data, _ := json.Marshal(values)
+	return json.RawMessage(data)
+}
+
+// DecodeLegacyChangeEvent decodes a JSON encoded RES-service v1.0 model change event
+// [DEPRECATED:deprecatedModelChangeEvent]
+func DecodeLegacyChangeEvent(data json.RawMessage) (map[string]Value, error) {
+	var r map[string]Value
+	err := json.Unmarshal(data, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// DecodeAnyChangeEvent decodes a JSON encoded RES-service model change event,
+// translating a v1.0 legacy event into the current format. The legacy flag is
+// true if the event was detected as legacy.
+func DecodeAnyChangeEvent(data json.RawMessage) (values map[string]Value, legacy bool, err error) {
+	if IsLegacyChangeEvent(data) {
+		if IsStrict() {
+			return nil, true, strictError("legacy change event without values property: %s", data)
+		}
+		values, err = DecodeLegacyChangeEvent(data)
+		return values, true, err
+	}
+	values, err = DecodeChangeEvent(data)
+	return values, false, err
+}
+
+// EncodeLegacyNewResult creates a JSON encoded RES-service v1.1 new call
+// request result, containing only the resource ID.
+// [DEPRECATED:deprecatedNewCallRequest]
+func EncodeLegacyNewResult(rid string) json.RawMessage {
+	out, _ := json.Marshal(Resource{RID: rid})
+	return out
+}
+
+// TryDecodeLegacyNewResult tries to detect legacy v1.1.1 behavior.
+// Returns empty string and nil error when the result is not detected as legacy.
+// [DEPRECATED:deprecatedNewCallRequest]
+func TryDecodeLegacyNewResult(result json.RawMessage) (string, error) {
+	var r map[string]interface{}
+	err := json.Unmarshal(result, &r)
+	if err != nil {
+		return "", nil
+	}
+
+	if len(r) != 1 {
+		return "", nil
+	}
+
+	rid, ok := r["rid"].(string)
+	if !ok {
+		return "", nil
+	}
+
+	if !IsValidRID(rid, true) {
+		return "", errInvalidResponse
+	}
+
+	if IsStrict() {
+		return "", strictError("legacy new call result %s; use a resource response instead", result)
+	}
+
+	return rid, nil
+}
@@ -0,0 +1,203 @@
+package codec
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test parsing and formatting protocol versions
+func TestParseProtocolVersion(t *testing.T) {
+	tbl := []struct {
+		Protocol string
+		Expected int
+		Err      error
+	}{
+		{"1.0.0", 1000000, nil},
+		{"1.1.1", 1001001, nil},
+		{"1.2.0", 1002000, nil},
+		{"1.999.999", 1999999, nil},
+		{"0.999.999", 0, reserr.ErrUnsupportedProtocol},
+		{"2.0.0", 0, reserr.ErrUnsupportedProtocol},
+		{"1.2", 0, reserr.ErrInvalidParams},
+		{"1.2.0.0", 0, reserr.ErrInvalidParams},
+		{"1.1000.0", 0, reserr.ErrInvalidParams},
+		{"1.a.0", 0, reserr.ErrInvalidParams},
+		{"1.2.-5", 0, reserr.ErrInvalidParams},
+		{"1.+2.0", 0, reserr.ErrInvalidParams},
+		{"+1.2.0", 0, reserr.ErrInvalidParams},
+		{"1..0", 0, reserr.ErrInvalidParams},
+		{"", 0, reserr.ErrInvalidParams},
+	}
+
+	for i, l := range tbl {
+		v, err := ParseProtocolVersion(l.Protocol)
+		if err != l.Err {
+			t.Fatalf("expected error %v, but got %v, in test #%d", l.Err, err, i+1)
+		}
+		if v != l.Expected {
+			t.Fatalf("expected version %d, but got %d, in test #%d", l.Expected, v, i+1)
+		}
+		if err == nil && FormatProtocolVersion(v) != l.Protocol {
+			t.Fatalf("expected formatted version %#v, but got %#v, in test #%d", l.Protocol, FormatProtocolVersion(v), i+1)
+		}
+	}
+}
+
+// Test that resource responses are supported for protocol versions above v1.1.1
+func TestSupportsResourceResponse(t *testing.T) {
+	tbl := []struct {
+		Version  int
+		Expected bool
+	}{
+		{1000000, false},
+		{LegacyProtocol, false},
+		{1002000, true},
+		{LatestProtocol, true},
+	}
+
+	for i, l := range tbl {
+		if SupportsResourceResponse(l.Version) != l.Expected {
+			t.Fatalf("expected SupportsResourceResponse(%d) to be %v, in test #%d", l.Version, l.Expected, i+1)
+		}
+	}
+}
+
+// Test translating call results into the legacy format
+func TestEncodeLegacyCallResult(t *testing.T) {
+	tbl := []struct {
+		Result   string
+		RID      string
+		Expected string
+	}{
+		{`{"foo":"bar"}`, "", `{"foo":"bar"}`},
+		{`null`, "", `null`},
+		{``, "test.model", `{"rid":"test.model"}`},
+		{``, "test.model?q=foo", `{"rid":"test.model?q=foo"}`},
+	}
+
+	for i, l := range tbl {
+		out := EncodeLegacyCallResult(json.RawMessage(l.Result), l.RID)
+		if string(out) != l.Expected {
+			t.Fatalf("expected %s, but got %s, in test #%d", l.Expected, out, i+1)
+		}
+	}
+}
+
+// Test decoding current and legacy model change events
+func TestDecodeAnyChangeEvent(t *testing.T) {
+	tbl := []struct {
+		Payload  string
+		Expected string
+		Legacy   bool
+	}{
+		{`{"values":{"foo":"bar"}}`, `{"foo":"bar"}`, false},
+		{`{"values":{}}`, `{}`, false},
+		{`{"values": {"ref":{"rid":"test.model"}}}`, `{"ref":{"rid":"test.model"}}`, false},
+		{`{"foo":"bar"}`, `{"foo":"bar"}`, true},
+		{`{"values":"bar"}`, `{"values":"bar"}`, true},
+		{`{"values":{"rid":"test.model"},"foo":2}`, `{"values":{"rid":"test.model"},"foo":2}`, true},
+		{`{}`, `{}`, true},
+	}
+
+	for i, l := range tbl {
+		values, legacy, err := DecodeAnyChangeEvent(json.RawMessage(l.Payload))
+		if err != nil {
+			t.Fatalf("expected no error, but got %s, in test #%d", err, i+1)
+		}
+		if legacy != l.Legacy {
+			t.Fatalf("expected legacy to be %v, but got %v, in test #%d", l.Legacy, legacy, i+1)
+		}
+		assertValues(t, values, l.Expected, i)
+	}
+}
+
+// Test that encoding change events to legacy and current format and back
+// returns the same values
+func TestChangeEventRoundTrip(t *testing.T) {
+	tbl := []string{
+		`{"foo":"bar"}`,
+		`{"foo":42,"bar":null,"baz":true}`,
+		`{"ref":{"rid":"test.model"}}`,
+		`{"values":"bar"}`,
+		`{"values":{"rid":"test.model"}}`,
+		`{"deleted":{"action":"delete"}}`,
+	}
+
+	for i, l := range tbl {
+		var values map[string]Value
+		if err := json.Unmarshal([]byte(l), &values); err != nil {
+			t.Fatalf("expected no error, but got %s, in test #%d", err, i+1)
+		}
+		assertChangeEventRoundTrip(t, values, i)
+	}
+}
+
+// Test translating new call results to and from the legacy format
+func TestLegacyNewResultRoundTrip(t *testing.T) {
+	tbl := []struct {
+		Result   string
+		Expected string
+		Err      bool
+	}{
+		{`{"rid":"test.model"}`, "test.model", false},
+		{`{"rid":"test.model?q=foo"}`, "test.model?q=foo", false},
+		{`{"rid":"test..model"}`, "", true},
+		{`{"rid":"test.model","foo":"bar"}`, "", false},
+		{`{"rid":42}`, "", false},
+		{`"test.model"`, "", false},
+		{`null`, "", false},
+	}
+
+	for i, l := range tbl {
+		rid, err := TryDecodeLegacyNewResult(json.RawMessage(l.Result))
+		if (err != nil) != l.Err {
+			t.Fatalf("expected error to be %v, but got %v, in test #%d", l.Err, err, i+1)
+		}
+		if rid != l.Expected {
+			t.Fatalf("expected rid %#v, but got %#v, in test #%d", l.Expected, rid, i+1)
+		}
+		if rid != "" {
+			rid2, err := TryDecodeLegacyNewResult(EncodeLegacyNewResult(rid))
+			if err != nil || rid2 != rid {
+				t.Fatalf("expected round trip rid %#v, but got %#v (%v), in test #%d", rid, rid2, err, i+1)
+			}
+		}
+	}
+}
+
+func assertChangeEventRoundTrip(t *testing.T, values map[string]Value, i int) {
+	expected, _ := json.Marshal(values)
+
+	legacy, err := DecodeLegacyChangeEvent(EncodeLegacyChangeEvent(values))
+	if err != nil {
+		t.Fatalf("expected no error decoding legacy event, but got %s, in test #%d", err, i+1)
+	}
+	assertValues(t, legacy, string(expected), i)
+
+	current, isLegacy, err := DecodeAnyChangeEvent(EncodeChangeEvent(values))
+	if err != nil {
+		t.Fatalf("expected no error decoding current event, but got %s, in test #%d", err, i+1)
+	}
+	if isLegacy {
+		t.Fatalf("expected current event not to be detected as legacy, in test #%d", i+1)
+	}
+	assertValues(t, current, string(expected), i)
+}
+
+func assertValues(t *testing.T, values map[string]Value, expected string, i int) {
+	var exp map[string]Value
+	if err := json.Unmarshal([]byte(expected), &exp); err != nil {
+		panic("test: error unmarshaling expected values: " + err.Error())
+	}
+	if len(values) != len(exp) {
+		t.Fatalf("expected values %s, but got %d values, in test #%d", expected, len(values), i+1)
+	}
+	for k, v := range exp {
+		if w, ok := values[k]; !ok || !v.Equal(w) {
+			out, _ := json.Marshal(values)
+			t.Fatalf("expected values %s, but got %s, in test #%d", expected, out, i+1)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// strict is 1 when strict protocol validation is enabled.
+var strict int32
+
+// SetStrict enables or disables strict protocol validation.
+//
+// In strict mode, service messages deviating from the RES protocol
+// specification are rejected with a detailed error, instead of being
+// tolerated. This includes unknown fields, legacy message formats, and both
+// result and error being set in the same response. Intended for use during
+// development.
+func SetStrict(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&strict, v)
+}
+
+// IsStrict reports whether strict protocol validation is enabled.
+func IsStrict() bool {
+	return atomic.LoadInt32(&strict) == 1
+}
+
+// strictError returns an internal error describing a protocol deviation
+// detected in strict mode.
+func strictError(format string, v ...interface{}) *reserr.Error {
+	return reserr.InternalError(fmt.Errorf("strict mode: "+format, v...))
+}
+
+// unmarshal parses the JSON encoded data and stores the result in the value
+// pointed to by v. In strict mode, unknown fields and trailing data are
+// disallowed.
+func unmarshal(data []byte, v interface{}) error {
+	if !IsStrict() {
+		return json.Unmarshal(data, v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if rerr, ok := err.(*reserr.Error); ok {
+			return rerr
+		}
+		return strictError("%s in %s", err, data)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return strictError("unexpected data after JSON value in %s", data)
+	}
+	return nil
+}
+
+// invalidValue returns the error for an invalid RES value. In strict mode,
+// the error includes the value and the reason.
+func invalidValue(data []byte, reason string) error {
+	if !IsStrict() {
+		return errInvalidValue
+	}
+	return strictError("invalid value %s: %s", data, reason)
+}
+
+// checkResultAndError returns an error in strict mode if a response has both
+// an error and a result set.
+func checkResultAndError(hasResult bool, rerr *reserr.Error) error {
+	if rerr != nil && hasResult && IsStrict() {
+		return strictError("response must not contain both result and error")
+	}
+	return nil
+}
@@ -1,44 +1,180 @@
 package server
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"os"
 	"sort"
 	"strings"
 	"unicode/utf8"
 
 	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/redis"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/sentry"
 )
 
 // Config holds server configuration
 type Config struct {
-	Addr         *string `json:"addr"`
-	Port         uint16  `json:"port"`
-	WSPath       string  `json:"wsPath"`
-	APIPath      string  `json:"apiPath"`
-	APIEncoding  string  `json:"apiEncoding"`
-	HeaderAuth   *string `json:"headerAuth"`
-	AllowOrigin  *string `json:"allowOrigin"`
-	PUTMethod    *string `json:"putMethod"`
-	DELETEMethod *string `json:"deleteMethod"`
-	PATCHMethod  *string `json:"patchMethod"`
+	Addr        *string    `json:"addr"`
+	Port        uint16     `json:"port"`
+	Listen      []string   `json:"listen"`
+	Socket      *string    `json:"socket"`
+	SocketMode  string     `json:"socketMode"`
+	WSPath      string     `json:"wsPath"`
+	APIPath     string     `json:"apiPath"`
+	APIEncoding string     `json:"apiEncoding"`
+	APIMounts   []APIMount `json:"apiMounts"`
+	HeaderAuth  *string    `json:"headerAuth"`
+	RequireAuth bool       `json:"requireAuth"`
+
+	BruteForce      []BruteForceRule `json:"bruteForce"`
+	AllowOrigin     *string          `json:"allowOrigin"`
+	TrustedProxies  []string         `json:"trustedProxies"`
+	PUTMethod       *string          `json:"putMethod"`
+	PUTCreateMethod *string          `json:"putCreateMethod"`
+	DELETEMethod    *string          `json:"deleteMethod"`
+	PATCHMethod     *string          `json:"patchMethod"`
 
 	TLS     bool   `json:"tls"`
 	TLSCert string `json:"certFile"`
 	TLSKey  string `json:"keyFile"`
 
-	WSCompression bool `json:"wsCompression"`
+	WSCompression       bool `json:"wsCompression"`
+	RequestCapabilities bool `json:"requestCapabilities"`
+	Strict              bool `json:"strict"`
+	HideErrorDetails    bool `json:"hideErrorDetails"`
+	SubscriptionTTL     int  `json:"subscriptionTTL"`
+	ReaccessInterval    int  `json:"reaccessInterval"`
+
+	AdminAddr *string `json:"adminAddr"`
+	AdminPort uint16  `json:"adminPort"`
+
+	AnnounceInterval int  `json:"announceInterval"`
+	DiscoverPeers    bool `json:"discoverPeers"`
+	LeaderElection   bool `json:"leaderElection"`
+
+	IdempotencyWindow   int     `json:"idempotencyWindow"`
+	EventDedupWindow    int     `json:"eventDedupWindow"`
+	EventSequence       bool    `json:"eventSequence"`
+	OutboxPath          *string `json:"outboxPath"`
+	OutboxTokenKey      *string `json:"outboxTokenKey"`
+	OutboxExcludeTokens bool    `json:"outboxExcludeTokens"`
+	RedisURL            *string `json:"redisUrl"`
+
+	Audit         *AuditConfig         `json:"audit"`
+	Bandwidth     *BandwidthConfig     `json:"bandwidth"`
+	RateLimit     *RateLimitConfig     `json:"rateLimit"`
+	GroupAccess   *GroupAccessConfig   `json:"groupAccess"`
+	Quarantine    *QuarantineConfig    `json:"quarantine"`
+	SlowStart     *SlowStartConfig     `json:"slowStart"`
+	RetryAfter    *RetryAfterConfig    `json:"retryAfter"`
+	HTTPLimits    *HTTPLimitsConfig    `json:"httpLimits"`
+	WSLimits      *WSLimitsConfig      `json:"wsLimits"`
+	Chunking      *ChunkingConfig      `json:"chunking"`
+	BackPressure  *BackPressureConfig  `json:"backPressure"`
+	BulkCall      *BulkCallConfig      `json:"bulkCall"`
+	XMLEncoding   *XMLEncodingConfig   `json:"xmlEncoding"`
+	Probes        *ProbesConfig        `json:"probes"`
+	SSE           *SSEConfig           `json:"sse"`
+	LongPoll      *LongPollConfig      `json:"longPoll"`
+	SurrogateKeys *SurrogateKeysConfig `json:"surrogateKeys"`
+
+	PathNormalization *PathNormalizationConfig `json:"pathNormalization"`
+
+	SocketTuning       []SocketTuning         `json:"socketTuning"`
+	HeaderRequirements []HeaderRequirement    `json:"headerRequirements"`
+	ClientVersions     *ClientVersionConfig   `json:"clientVersions"`
+	ServerTiming       *ServerTimingConfig    `json:"serverTiming"`
+	LatencyHeatmap     *LatencyHeatmapConfig  `json:"latencyHeatmap"`
+	PayloadStats       *PayloadStatsConfig    `json:"payloadStats"`
+	NeverUpdated       *NeverUpdatedConfig    `json:"neverUpdated"`
+	AllocationAudit    *AllocationAuditConfig `json:"allocationAudit"`
+
+	AllowedResources   []string         `json:"allowedResources"`
+	DeniedResources    []string         `json:"deniedResources"`
+	UniqueCollections  []string         `json:"uniqueCollections"`
+	LinkHeaders        []string         `json:"linkHeaders"`
+	BootstrapResources []string         `json:"bootstrapResources"`
+	AllowedMethods     []MethodPolicy   `json:"allowedMethods"`
+	BlockedMethods     []string         `json:"blockedMethods"`
+	CanaryRoutes       []CanaryRoute    `json:"canaryRoutes"`
+	ShadowRoutes       []ShadowRoute    `json:"shadowRoutes"`
+	Blobs              []BlobConfig     `json:"blobs"`
+	Uploads            []UploadConfig   `json:"uploads"`
+	Deprecations       []Deprecation    `json:"deprecations"`
+	Polling            []PollingRule    `json:"polling"`
+	Upstreams          []UpstreamConfig `json:"upstreams"`
+
+	FeatureFlags map[string]FeatureFlag `json:"featureFlags"`
+
+	ErrorMappings   map[string]ErrorMapping  `json:"errorMappings"`
+	HTTPErrorBodies map[string]HTTPErrorBody `json:"httpErrorBodies"`
+	MessageCatalogs *string                  `json:"messageCatalogs"`
+
+	StartupSummary  bool    `json:"startupSummary"`
+	DiagnosticsPath *string `json:"diagnosticsPath"`
+	SentryDSN       *string `json:"sentryDsn"`
+	LogBufferSize   int     `json:"logBufferSize"`
+	LogBufferLevel  string  `json:"logBufferLevel"`
+
+	TraceSampling *TraceSamplingConfig `json:"traceSampling"`
+
+	PayloadEncryption     []PayloadEncryption     `json:"payloadEncryption"`
+	PayloadCompression    []PayloadCompression    `json:"payloadCompression"`
+	SignatureVerification []SignatureVerification `json:"signatureVerification"`
+	WebhookSigning        []WebhookSigning        `json:"webhookSigning"`
 
 	NoHTTP bool `json:"-"` // Disable start of the HTTP server. Used for testing
 
-	scheme           string
-	netAddr          string
-	headerAuthRID    string
-	headerAuthAction string
-	allowOrigin      []string
-	allowMethods     string
+	scheme             string
+	netAddr            string
+	adminNetAddr       string
+	listenAddrs        []listenAddr
+	tracePatterns      []rescache.ResourcePattern
+	encryptionRules    []encryptionRule
+	compressionRules   []compressionRule
+	verificationRules  []verificationRule
+	outboxTokenKey     []byte
+	socketMode         os.FileMode
+	socketTunings      map[string]*SocketTuning
+	headerAuthRID      string
+	headerAuthAction   string
+	allowOrigin        []string
+	trustedProxies     []*net.IPNet
+	allowMethods       string
+	allowedResources   []rescache.ResourcePattern
+	deniedResources    []rescache.ResourcePattern
+	uniqueCollections  []rescache.ResourcePattern
+	linkHeaders        []rescache.ResourcePattern
+	methodPolicies     []methodPolicy
+	bruteForcePatterns []rescache.ResourcePattern
+	blockedMethods     []rescache.ResourcePattern
+	headerRules        []headerRule
+	canaryRoutes       []*rescache.CanaryRoute
+	shadowRoutes       []*rescache.ShadowRoute
+	blobRules          []blobRule
+	uploadRules        []uploadRule
+	deprecationRules   []deprecationRule
+	pollRules          []rescache.PollRule
+	upstreamRules      []upstreamRule
+	errorMappings      map[string]ErrorMapping
+	httpErrorBodies    map[string]*httpErrorTemplate
+	apiMounts          []apiMount
+}
+
+// CanaryRoute holds the configuration for routing, or mirroring, a
+// percentage of get and call requests for resources matching a pattern to an
+// alternate subject prefix.
+type CanaryRoute struct {
+	Pattern string  `json:"pattern"`
+	Prefix  string  `json:"prefix"`
+	Percent float64 `json:"percent"`
+	Flag    string  `json:"flag,omitempty"`
 }
 
 // SetDefault sets the default values
@@ -80,26 +216,51 @@ func (c *Config) prepare() error {
 	}
 
 	// Resolve network address
-	c.netAddr = ""
-	if c.Addr != nil {
-		s := *c.Addr
-		if s != "" {
-			ip := net.ParseIP(s)
-			if len(ip) > 0 {
-				// Test if it is an IPv6 address
-				if ip.To4() == nil {
-					c.netAddr = "[" + ip.String() + "]"
-				} else {
-					c.netAddr = ip.String()
-				}
-			} else {
-				return fmt.Errorf("invalid addr setting (%s)\n\tmust be a valid IPv4 or IPv6 address", s)
-			}
+	host, err := resolveHost(c.Addr, DefaultAddr)
+	if err != nil {
+		return fmt.Errorf("invalid addr setting (%s)\n\t%s", *c.Addr, err)
+	}
+	c.netAddr = host + fmt.Sprintf(":%d", c.Port)
+
+	// Resolve additional network addresses
+	c.listenAddrs = nil
+	for _, l := range c.Listen {
+		la, err := parseListenAddr(l)
+		if err != nil {
+			return fmt.Errorf("invalid listen setting (%s)\n\t%s", l, err)
 		}
-	} else {
-		c.netAddr = DefaultAddr
+		if la.addr == c.netAddr {
+			return fmt.Errorf("invalid listen setting (%s)\n\tmust not be the same as addr and port", l)
+		}
+		la.name = l
+		c.listenAddrs = append(c.listenAddrs, la)
+	}
+	if err := c.prepareSocketTuning(); err != nil {
+		return fmt.Errorf("invalid socketTuning setting\n\t%s", err)
+	}
+
+	// Validate Unix domain socket
+	c.socketMode = 0
+	if c.Socket != nil && *c.Socket == "" {
+		return errors.New("invalid socket setting\n\tmust be a file path")
+	}
+	if c.SocketMode != "" {
+		mode, err := parseSocketMode(c.SocketMode)
+		if err != nil {
+			return fmt.Errorf("invalid socketMode setting (%s)\n\t%s", c.SocketMode, err)
+		}
+		c.socketMode = mode
+	}
+
+	// Resolve admin network address
+	c.adminNetAddr = ""
+	if c.AdminPort != 0 {
+		host, err := resolveHost(c.AdminAddr, DefaultAdminAddr)
+		if err != nil {
+			return fmt.Errorf("invalid adminAddr setting (%s)\n\t%s", *c.AdminAddr, err)
+		}
+		c.adminNetAddr = host + fmt.Sprintf(":%d", c.AdminPort)
 	}
-	c.netAddr += fmt.Sprintf(":%d", c.Port)
 
 	if c.HeaderAuth != nil {
 		s := *c.HeaderAuth
@@ -129,6 +290,14 @@ func (c *Config) prepare() error {
 		}
 		c.allowMethods += ", PUT"
 	}
+	if c.PUTCreateMethod != nil {
+		if !codec.IsValidRIDPart(*c.PUTCreateMethod) {
+			return fmt.Errorf("invalid putCreateMethod setting (%s)\n\tmust be a valid call method name", *c.PUTCreateMethod)
+		}
+		if c.PUTMethod == nil {
+			return errors.New("invalid putCreateMethod setting\n\trequires putMethod to be set")
+		}
+	}
 	if c.DELETEMethod != nil {
 		if !codec.IsValidRIDPart(*c.DELETEMethod) {
 			return fmt.Errorf("invalid deleteMethod setting (%s)\n\tmust be a valid call method name", *c.DELETEMethod)
@@ -142,16 +311,449 @@ func (c *Config) prepare() error {
 		c.allowMethods += ", PATCH"
 	}
 
+	if c.AnnounceInterval < 0 {
+		return fmt.Errorf("invalid announceInterval setting (%d)\n\tmust be zero or a positive number of milliseconds", c.AnnounceInterval)
+	}
+	if c.LeaderElection && c.AnnounceInterval == 0 {
+		return errors.New("invalid leaderElection setting\n\trequires announceInterval to be set")
+	}
+
+	if c.SubscriptionTTL < 0 {
+		return fmt.Errorf("invalid subscriptionTTL setting (%d)\n\tmust be zero or a positive number of milliseconds", c.SubscriptionTTL)
+	}
+
+	if c.ReaccessInterval < 0 {
+		return fmt.Errorf("invalid reaccessInterval setting (%d)\n\tmust be zero or a positive number of milliseconds", c.ReaccessInterval)
+	}
+
+	if c.IdempotencyWindow < 0 {
+		return fmt.Errorf("invalid idempotencyWindow setting (%d)\n\tmust be zero or a positive number of milliseconds", c.IdempotencyWindow)
+	}
+
+	if c.EventDedupWindow < 0 {
+		return fmt.Errorf("invalid eventDedupWindow setting (%d)\n\tmust be zero or a positive number of milliseconds", c.EventDedupWindow)
+	}
+
+	if c.OutboxPath != nil && *c.OutboxPath == "" {
+		return errors.New("invalid outboxPath setting\n\tmust be a directory path")
+	}
+
+	if c.OutboxTokenKey != nil {
+		if c.OutboxExcludeTokens {
+			return errors.New("invalid outboxTokenKey setting\n\tcannot be combined with outboxExcludeTokens")
+		}
+		key, err := base64.StdEncoding.DecodeString(*c.OutboxTokenKey)
+		if err != nil || len(key) != 32 {
+			return errors.New("invalid outboxTokenKey setting\n\tmust be a base64 encoded 32 byte key")
+		}
+		c.outboxTokenKey = key
+	}
+
+	if c.DiagnosticsPath != nil && *c.DiagnosticsPath == "" {
+		return errors.New("invalid diagnosticsPath setting\n\tmust be a directory path")
+	}
+
+	if c.SentryDSN != nil {
+		if _, _, err := sentry.ParseDSN(*c.SentryDSN); err != nil {
+			return fmt.Errorf("invalid sentryDsn setting (%s)\n\t%s", *c.SentryDSN, err)
+		}
+	}
+
+	c.tracePatterns = nil
+	if c.TraceSampling != nil {
+		ps, err := c.TraceSampling.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid traceSampling setting\n\t%s", err)
+		}
+		c.tracePatterns = ps
+	}
+
+	if c.LogBufferSize < 0 {
+		return fmt.Errorf("invalid logBufferSize setting (%d)\n\tmust be zero or a positive number of entries", c.LogBufferSize)
+	}
+	if c.LogBufferLevel == "" {
+		c.LogBufferLevel = "debug"
+	}
+	if _, ok := logBufferLevels[c.LogBufferLevel]; !ok {
+		return fmt.Errorf("invalid logBufferLevel setting (%s)\n\tmust be error, info, debug, or trace", c.LogBufferLevel)
+	}
+
+	for _, ws := range c.WebhookSigning {
+		if err := ws.prepare(); err != nil {
+			return fmt.Errorf("invalid webhookSigning setting\n\t%s", err)
+		}
+	}
+
+	if c.Audit != nil {
+		if err := c.Audit.prepare(); err != nil {
+			return fmt.Errorf("invalid audit setting\n\t%s", err)
+		}
+	}
+
+	if c.Chunking != nil {
+		if err := c.Chunking.prepare(); err != nil {
+			return fmt.Errorf("invalid chunking setting\n\t%s", err)
+		}
+	}
+
+	if c.Bandwidth != nil {
+		if err := c.Bandwidth.prepare(); err != nil {
+			return fmt.Errorf("invalid bandwidth setting\n\t%s", err)
+		}
+	}
+	if c.RateLimit != nil {
+		if err := c.RateLimit.prepare(); err != nil {
+			return fmt.Errorf("invalid rateLimit setting\n\t%s", err)
+		}
+	}
+	if c.GroupAccess != nil {
+		if err := c.GroupAccess.prepare(); err != nil {
+			return fmt.Errorf("invalid groupAccess setting\n\t%s", err)
+		}
+	}
+	if c.Quarantine != nil {
+		if err := c.Quarantine.prepare(); err != nil {
+			return fmt.Errorf("invalid quarantine setting\n\t%s", err)
+		}
+	}
+
+	if c.SlowStart != nil {
+		if err := c.SlowStart.prepare(); err != nil {
+			return fmt.Errorf("invalid slowStart setting\n\t%s", err)
+		}
+	}
+
+	if c.RetryAfter != nil {
+		if err := c.RetryAfter.prepare(); err != nil {
+			return fmt.Errorf("invalid retryAfter setting\n\t%s", err)
+		}
+	}
+
+	if c.HTTPLimits != nil {
+		if err := c.HTTPLimits.prepare(); err != nil {
+			return fmt.Errorf("invalid httpLimits setting\n\t%s", err)
+		}
+	}
+
+	if c.WSLimits != nil {
+		if err := c.WSLimits.prepare(); err != nil {
+			return fmt.Errorf("invalid wsLimits setting\n\t%s", err)
+		}
+	}
+
+	c.headerRules = make([]headerRule, 0, len(c.HeaderRequirements))
+	for _, r := range c.HeaderRequirements {
+		hr, err := r.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid headerRequirements setting\n\t%s", err)
+		}
+		c.headerRules = append(c.headerRules, hr)
+	}
+	if c.ClientVersions != nil {
+		if err := c.ClientVersions.prepare(); err != nil {
+			return fmt.Errorf("invalid clientVersions setting\n\t%s", err)
+		}
+	}
+	if c.LatencyHeatmap != nil {
+		if err := c.LatencyHeatmap.prepare(); err != nil {
+			return fmt.Errorf("invalid latencyHeatmap setting\n\t%s", err)
+		}
+	}
+	if c.PayloadStats != nil {
+		if err := c.PayloadStats.prepare(); err != nil {
+			return fmt.Errorf("invalid payloadStats setting\n\t%s", err)
+		}
+	}
+	if c.NeverUpdated != nil {
+		if err := c.NeverUpdated.prepare(); err != nil {
+			return fmt.Errorf("invalid neverUpdated setting\n\t%s", err)
+		}
+	}
+	if c.AllocationAudit != nil {
+		if err := c.AllocationAudit.prepare(); err != nil {
+			return fmt.Errorf("invalid allocationAudit setting\n\t%s", err)
+		}
+	}
+	if c.BackPressure != nil {
+		if err := c.BackPressure.prepare(); err != nil {
+			return fmt.Errorf("invalid backPressure setting\n\t%s", err)
+		}
+	}
+	if c.BulkCall != nil {
+		if err := c.BulkCall.prepare(c.WSPath); err != nil {
+			return fmt.Errorf("invalid bulkCall setting\n\t%s", err)
+		}
+	}
+	if c.SSE != nil {
+		if err := c.SSE.prepare(c.APIPath, c.WSPath); err != nil {
+			return fmt.Errorf("invalid sse setting\n\t%s", err)
+		}
+	}
+	if c.LongPoll != nil {
+		if err := c.LongPoll.prepare(c.APIPath, c.WSPath); err != nil {
+			return fmt.Errorf("invalid longPoll setting\n\t%s", err)
+		}
+		if c.SSE != nil && (strings.HasPrefix(c.SSE.Path, c.LongPoll.Path) || strings.HasPrefix(c.LongPoll.Path, c.SSE.Path)) {
+			return fmt.Errorf("invalid longPoll setting\n\tpath %q must not overlap the sse path", c.LongPoll.Path)
+		}
+	}
+	if c.SurrogateKeys != nil {
+		if err := c.SurrogateKeys.prepare(); err != nil {
+			return fmt.Errorf("invalid surrogateKeys setting\n\t%s", err)
+		}
+	}
+	if c.XMLEncoding != nil {
+		if err := c.XMLEncoding.prepare(); err != nil {
+			return fmt.Errorf("invalid xmlEncoding setting\n\t%s", err)
+		}
+	}
+	if c.Probes != nil {
+		if err := c.Probes.prepare(c.WSPath); err != nil {
+			return fmt.Errorf("invalid probes setting\n\t%s", err)
+		}
+	}
+	if c.PathNormalization != nil {
+		if err := c.PathNormalization.prepare(); err != nil {
+			return fmt.Errorf("invalid pathNormalization setting\n\t%s", err)
+		}
+	}
+
+	if c.RedisURL != nil {
+		if _, err := redis.New(*c.RedisURL, 0); err != nil {
+			return fmt.Errorf("invalid redisUrl setting (%s)\n\t%s", *c.RedisURL, err)
+		}
+	}
+
+	c.allowedResources, err = parseResourcePatterns(c.AllowedResources)
+	if err != nil {
+		return fmt.Errorf("invalid allowedResources setting\n\t%s", err)
+	}
+	c.deniedResources, err = parseResourcePatterns(c.DeniedResources)
+	if err != nil {
+		return fmt.Errorf("invalid deniedResources setting\n\t%s", err)
+	}
+	c.uniqueCollections, err = parseResourcePatterns(c.UniqueCollections)
+	if err != nil {
+		return fmt.Errorf("invalid uniqueCollections setting\n\t%s", err)
+	}
+	c.linkHeaders, err = parseResourcePatterns(c.LinkHeaders)
+	if err != nil {
+		return fmt.Errorf("invalid linkHeaders setting\n\t%s", err)
+	}
+	for _, rid := range c.BootstrapResources {
+		if !codec.IsValidRID(rid, true) {
+			return fmt.Errorf("invalid bootstrapResources setting\n\t%q must be a valid resource ID", rid)
+		}
+	}
+	c.methodPolicies = make([]methodPolicy, 0, len(c.AllowedMethods))
+	for _, mp := range c.AllowedMethods {
+		p, err := mp.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid allowedMethods setting\n\t%s", err)
+		}
+		c.methodPolicies = append(c.methodPolicies, p)
+	}
+
+	c.bruteForcePatterns = make([]rescache.ResourcePattern, 0, len(c.BruteForce))
+	for _, r := range c.BruteForce {
+		p, err := r.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid bruteForce setting\n\t%s", err)
+		}
+		c.bruteForcePatterns = append(c.bruteForcePatterns, p)
+	}
+
+	c.blockedMethods, err = parseBlockedMethods(c.BlockedMethods)
+	if err != nil {
+		return fmt.Errorf("invalid blockedMethods setting\n\t%s", err)
+	}
+
+	c.canaryRoutes = make([]*rescache.CanaryRoute, 0, len(c.CanaryRoutes))
+	for _, r := range c.CanaryRoutes {
+		p, err := r.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid canaryRoutes setting\n\t%s", err)
+		}
+		c.canaryRoutes = append(c.canaryRoutes, rescache.NewCanaryRoute(p, r.Prefix, r.Percent))
+	}
+	c.pollRules = make([]rescache.PollRule, 0, len(c.Polling))
+	for _, pr := range c.Polling {
+		r, err := pr.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid polling setting\n\t%s", err)
+		}
+		c.pollRules = append(c.pollRules, r)
+	}
+	c.upstreamRules = make([]upstreamRule, 0, len(c.Upstreams))
+	for _, uc := range c.Upstreams {
+		r, err := uc.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid upstreams setting\n\t%s", err)
+		}
+		c.upstreamRules = append(c.upstreamRules, r)
+	}
+	c.encryptionRules = make([]encryptionRule, 0, len(c.PayloadEncryption))
+	for _, pe := range c.PayloadEncryption {
+		r, err := pe.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid payloadEncryption setting\n\t%s", err)
+		}
+		c.encryptionRules = append(c.encryptionRules, r)
+	}
+	c.compressionRules = make([]compressionRule, 0, len(c.PayloadCompression))
+	for _, pc := range c.PayloadCompression {
+		r, err := pc.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid payloadCompression setting\n\t%s", err)
+		}
+		c.compressionRules = append(c.compressionRules, r)
+	}
+	c.verificationRules = make([]verificationRule, 0, len(c.SignatureVerification))
+	for _, sv := range c.SignatureVerification {
+		r, err := sv.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid signatureVerification setting\n\t%s", err)
+		}
+		c.verificationRules = append(c.verificationRules, r)
+	}
+	c.shadowRoutes = make([]*rescache.ShadowRoute, 0, len(c.ShadowRoutes))
+	for _, r := range c.ShadowRoutes {
+		p, err := r.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid shadowRoutes setting\n\t%s", err)
+		}
+		c.shadowRoutes = append(c.shadowRoutes, rescache.NewShadowRoute(p, r.Prefix, r.Percent, r.Compare))
+	}
+	c.blobRules = make([]blobRule, 0, len(c.Blobs))
+	for _, b := range c.Blobs {
+		r, err := b.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid blobs setting\n\t%s", err)
+		}
+		c.blobRules = append(c.blobRules, r)
+	}
+	c.uploadRules = make([]uploadRule, 0, len(c.Uploads))
+	for _, u := range c.Uploads {
+		r, err := u.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid uploads setting\n\t%s", err)
+		}
+		c.uploadRules = append(c.uploadRules, r)
+	}
+	c.deprecationRules = make([]deprecationRule, 0, len(c.Deprecations))
+	for _, d := range c.Deprecations {
+		r, err := d.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid deprecations setting\n\t%s", err)
+		}
+		c.deprecationRules = append(c.deprecationRules, r)
+	}
+
+	for name, f := range c.FeatureFlags {
+		if len(f.Value) == 0 || !json.Valid(f.Value) {
+			return fmt.Errorf("invalid featureFlags setting (%s)\n\tvalue must be a valid JSON value", name)
+		}
+		if f.Percent != nil && (*f.Percent < 0 || *f.Percent > 100) {
+			return fmt.Errorf("invalid featureFlags setting (%s)\n\tpercent must be a number between 0 and 100", name)
+		}
+	}
+
+	c.errorMappings = make(map[string]ErrorMapping, len(c.ErrorMappings))
+	for code, m := range c.ErrorMappings {
+		if !codec.IsValidRID(code, false) {
+			return fmt.Errorf("invalid errorMappings setting (%s)\n\tmust be a valid error code", code)
+		}
+		pm, err := m.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid errorMappings setting (%s)\n\t%s", code, err)
+		}
+		c.errorMappings[code] = pm
+	}
+
+	c.httpErrorBodies = make(map[string]*httpErrorTemplate, len(c.HTTPErrorBodies))
+	for code, b := range c.HTTPErrorBodies {
+		if !codec.IsValidRID(code, false) {
+			return fmt.Errorf("invalid httpErrorBodies setting (%s)\n\tmust be a valid error code", code)
+		}
+		t, err := b.prepare()
+		if err != nil {
+			return fmt.Errorf("invalid httpErrorBodies setting (%s)\n\t%s", code, err)
+		}
+		c.httpErrorBodies[code] = t
+	}
+
 	if c.WSPath == "" {
 		c.WSPath = "/"
 	}
 	if c.APIPath == "" || c.APIPath[len(c.APIPath)-1] != '/' {
 		c.APIPath = c.APIPath + "/"
 	}
+	if err := c.prepareAPIMounts(); err != nil {
+		return fmt.Errorf("invalid apiMounts setting\n\t%s", err)
+	}
+	if c.BulkCall != nil {
+		if err := c.apiMountError(c.BulkCall.Path); err != nil {
+			return fmt.Errorf("invalid bulkCall setting\n\t%s", err)
+		}
+	}
+	if c.Probes != nil {
+		for _, p := range []string{c.Probes.HealthPath, c.Probes.ReadyPath} {
+			if err := c.apiMountError(p); err != nil {
+				return fmt.Errorf("invalid probes setting\n\t%s", err)
+			}
+		}
+	}
+	if err := c.prepareTrustedProxies(); err != nil {
+		return fmt.Errorf("invalid trustedProxies setting\n\t%s", err)
+	}
 
 	return nil
 }
 
+// ShadowRoute holds the configuration for mirroring a percentage of get and
+// call requests, optionally comparing the shadow responses with the primary
+// responses.
+type ShadowRoute struct {
+	CanaryRoute
+	Compare bool `json:"compare"`
+}
+
+// prepare validates the route and returns its parsed resource pattern.
+func (r CanaryRoute) prepare() (rescache.ResourcePattern, error) {
+	p := rescache.ParseResourcePattern(r.Pattern)
+	if !p.IsValid() {
+		return p, fmt.Errorf("pattern '%s' must be a valid resource pattern", r.Pattern)
+	}
+	if !codec.IsValidRID(r.Prefix, false) {
+		return p, fmt.Errorf("prefix '%s' must be a valid resource name", r.Prefix)
+	}
+	if r.Percent < 0 || r.Percent > 100 {
+		return p, fmt.Errorf("percent %g must be a number between 0 and 100", r.Percent)
+	}
+	return p, nil
+}
+
+// resolveHost returns the host part of a network address from an addr
+// setting, or def if the setting is nil.
+func resolveHost(addr *string, def string) (string, error) {
+	if addr == nil {
+		return def, nil
+	}
+	s := *addr
+	if s == "" {
+		return "", nil
+	}
+	ip := net.ParseIP(s)
+	if len(ip) == 0 {
+		return "", errors.New("must be a valid IPv4 or IPv6 address")
+	}
+	// Test if it is an IPv6 address
+	if ip.To4() == nil {
+		return "[" + ip.String() + "]", nil
+	}
+	return ip.String(), nil
+}
+
 func validateAllowOrigin(s []string) error {
 	for i, o := range s {
 		o = toLowerASCII(o)
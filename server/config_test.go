@@ -40,6 +40,15 @@ func TestConfigPrepare(t *testing.T) {
 	allowOriginInvalidMultipleAll := "http://localhost;*"
 	allowOriginInvalidMultipleSame := "http://localhost;*"
 	allowOriginInvalidOrigin := "http://this.is/invalid"
+	redisHTTPURL := "http://localhost:6379"
+	redisNoHostURL := "redis://"
+	redisInvalidDBURL := "redis://localhost/db"
+	invalidSentryDSN := "https://sentry.example.com/1"
+	shortTokenKey := "AAAAAAAAAAAAAAAAAAAAAA=="
+	tokenKey := "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	auditEmpty := ""
+	auditInvalidSubject := "audit..log"
+	auditInvalidURL := "ftp://example.com"
 	method := "foo"
 	invalidMethod := "foo.bar"
 	defaultCfg := Config{}
@@ -65,6 +74,8 @@ func TestConfigPrepare(t *testing.T) {
 		{Config{WSPath: "/", DELETEMethod: &method}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", DELETEMethod: &method, scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST, DELETE"}, false},
 		{Config{WSPath: "/", PATCHMethod: &method}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", PATCHMethod: &method, scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST, PATCH"}, false},
 		{Config{WSPath: "/", PUTMethod: &method, DELETEMethod: &method, PATCHMethod: &method}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", PUTMethod: &method, DELETEMethod: &method, PATCHMethod: &method, scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST, PUT, DELETE, PATCH"}, false},
+		// Listen
+		{Config{Listen: []string{"[::1]:8080", "10.0.0.5:8081", "0.0.0.0:8082", ":8083"}, WSPath: "/"}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST", listenAddrs: []listenAddr{{"[::1]:8080", "tcp6", "[::1]:8080"}, {"10.0.0.5:8081", "tcp4", "10.0.0.5:8081"}, {"0.0.0.0:8082", "tcp4", "0.0.0.0:8082"}, {":8083", "tcp", ":8083"}}}, false},
 		// Invalid config
 		{Config{Addr: &invalidAddr, WSPath: "/"}, Config{}, true},
 		{Config{HeaderAuth: &invalidHeaderAuth, WSPath: "/"}, Config{}, true},
@@ -73,9 +84,158 @@ func TestConfigPrepare(t *testing.T) {
 		{Config{AllowOrigin: &allowOriginInvalidMultipleAll, WSPath: "/"}, Config{}, true},
 		{Config{AllowOrigin: &allowOriginInvalidMultipleSame, WSPath: "/"}, Config{}, true},
 		{Config{AllowOrigin: &allowOriginInvalidOrigin, WSPath: "/"}, Config{}, true},
+		{Config{TrustedProxies: []string{"10.0.0.300"}, WSPath: "/"}, Config{}, true},
+		{Config{TrustedProxies: []string{"10.0.0.0/33"}, WSPath: "/"}, Config{}, true},
 		{Config{PUTMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
+		{Config{PUTMethod: &method, PUTCreateMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
+		{Config{PUTCreateMethod: &method, WSPath: "/"}, Config{}, true},
 		{Config{DELETEMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
 		{Config{PATCHMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
+		{Config{BlockedMethods: []string{"test..delete"}, WSPath: "/"}, Config{}, true},
+		{Config{AllowedResources: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{BruteForce: []BruteForceRule{{Pattern: "test..login", MaxAttempts: 1, Window: 1, BanDuration: 1}}, WSPath: "/"}, Config{}, true},
+		{Config{BruteForce: []BruteForceRule{{Pattern: "test.login", Window: 1, BanDuration: 1}}, WSPath: "/"}, Config{}, true},
+		{Config{BruteForce: []BruteForceRule{{Pattern: "test.login", MaxAttempts: 1, BanDuration: 1}}, WSPath: "/"}, Config{}, true},
+		{Config{BruteForce: []BruteForceRule{{Pattern: "test.login", MaxAttempts: 1, Window: 1, BanDuration: 1, Challenge: &ChallengeConfig{After: 1, VerifyURL: "http://localhost/verify"}}}, WSPath: "/"}, Config{}, true},
+		{Config{BruteForce: []BruteForceRule{{Pattern: "test.login", MaxAttempts: 1, Window: 1, BanDuration: 1, Challenge: &ChallengeConfig{After: 1, Param: "captcha", VerifyURL: "/verify"}}}, WSPath: "/"}, Config{}, true},
+		{Config{DeniedResources: []string{"test.>.model"}, WSPath: "/"}, Config{}, true},
+		{Config{BootstrapResources: []string{"test.*"}, WSPath: "/"}, Config{}, true},
+		{Config{BootstrapResources: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{AllowedMethods: []MethodPolicy{{Pattern: "test..model", Methods: []string{"set"}}}, WSPath: "/"}, Config{}, true},
+		{Config{AllowedMethods: []MethodPolicy{{Pattern: "test.model", Methods: []string{"set.foo"}}}, WSPath: "/"}, Config{}, true},
+		{Config{CanaryRoutes: []CanaryRoute{{Pattern: "test..>", Prefix: "v2", Percent: 10}}, WSPath: "/"}, Config{}, true},
+		{Config{CanaryRoutes: []CanaryRoute{{Pattern: "test.>", Prefix: "v2.", Percent: 10}}, WSPath: "/"}, Config{}, true},
+		{Config{CanaryRoutes: []CanaryRoute{{Pattern: "test.>", Prefix: "v2", Percent: 101}}, WSPath: "/"}, Config{}, true},
+		{Config{FeatureFlags: map[string]FeatureFlag{"test": {}}, WSPath: "/"}, Config{}, true},
+		{Config{ShadowRoutes: []ShadowRoute{{CanaryRoute: CanaryRoute{Pattern: "test.>", Prefix: "shadow", Percent: -1}}}, WSPath: "/"}, Config{}, true},
+		{Config{ErrorMappings: map[string]ErrorMapping{"test.*": {Code: "test.error"}}, WSPath: "/"}, Config{}, true},
+		{Config{ErrorMappings: map[string]ErrorMapping{"test.error": {Code: "test..error"}}, WSPath: "/"}, Config{}, true},
+		{Config{ErrorMappings: map[string]ErrorMapping{"test.error": {Messages: map[string]string{"en,sv": "Error"}}}, WSPath: "/"}, Config{}, true},
+		{Config{HTTPErrorBodies: map[string]HTTPErrorBody{"system.notFound": {JSON: `{"code":{{json .Code}`}}, WSPath: "/"}, Config{}, true},
+		{Config{HTTPErrorBodies: map[string]HTTPErrorBody{"system.notFound": {HTML: `<p>{{.Message</p>`}}, WSPath: "/"}, Config{}, true},
+		{Config{AnnounceInterval: -1, WSPath: "/"}, Config{}, true},
+		{Config{ReaccessInterval: -1, WSPath: "/"}, Config{}, true},
+		{Config{EventDedupWindow: -1, WSPath: "/"}, Config{}, true},
+		{Config{SubscriptionTTL: -1, WSPath: "/"}, Config{}, true},
+		{Config{LeaderElection: true, WSPath: "/"}, Config{}, true},
+		{Config{RedisURL: &redisHTTPURL, WSPath: "/"}, Config{}, true},
+		{Config{RedisURL: &redisNoHostURL, WSPath: "/"}, Config{}, true},
+		{Config{RedisURL: &redisInvalidDBURL, WSPath: "/"}, Config{}, true},
+		{Config{Audit: &AuditConfig{File: &auditEmpty}, WSPath: "/"}, Config{}, true},
+		{Config{Audit: &AuditConfig{Subject: &auditInvalidSubject}, WSPath: "/"}, Config{}, true},
+		{Config{Audit: &AuditConfig{URL: &auditInvalidURL}, WSPath: "/"}, Config{}, true},
+		{Config{Audit: &AuditConfig{Redact: []string{"card..number"}}, WSPath: "/"}, Config{}, true},
+		{Config{PayloadCompression: []PayloadCompression{{Pattern: "test..model"}}, WSPath: "/"}, Config{}, true},
+		{Config{Blobs: []BlobConfig{{Pattern: "test..blob"}}, WSPath: "/"}, Config{}, true},
+		{Config{Blobs: []BlobConfig{{Pattern: "test.>", MaxAge: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{Uploads: []UploadConfig{{Pattern: "test.>", Method: "upload.file", Dir: "/tmp", URL: "/files/"}}, WSPath: "/"}, Config{}, true},
+		{Config{Uploads: []UploadConfig{{Pattern: "test.>", Method: "upload", URL: "/files/"}}, WSPath: "/"}, Config{}, true},
+		{Config{Uploads: []UploadConfig{{Pattern: "test.>", Method: "upload", Dir: "/tmp", URL: "/files/", MaxSize: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{Uploads: []UploadConfig{{Pattern: "test.>", Method: "upload", Store: &UploadStoreConfig{Type: "ftp"}}}, WSPath: "/"}, Config{}, true},
+		{Config{Uploads: []UploadConfig{{Pattern: "test.>", Method: "upload", URL: "/files/", Store: &UploadStoreConfig{Type: "disk"}}}, WSPath: "/"}, Config{}, true},
+		{Config{Deprecations: []Deprecation{{Pattern: "test..model"}}, WSPath: "/"}, Config{}, true},
+		{Config{Deprecations: []Deprecation{{Pattern: "test.>", Method: "set.foo"}}, WSPath: "/"}, Config{}, true},
+		{Config{Deprecations: []Deprecation{{Pattern: "test.>", Since: "2026-01-01"}}, WSPath: "/"}, Config{}, true},
+		{Config{Deprecations: []Deprecation{{Pattern: "test.>", Sunset: "tomorrow"}}, WSPath: "/"}, Config{}, true},
+		{Config{Polling: []PollingRule{{Pattern: "test..>", Interval: 1000}}, WSPath: "/"}, Config{}, true},
+		{Config{Polling: []PollingRule{{Pattern: "test.>"}}, WSPath: "/"}, Config{}, true},
+		{Config{Upstreams: []UpstreamConfig{{Pattern: "test.>"}}, WSPath: "/"}, Config{}, true},
+		{Config{BulkCall: &BulkCallConfig{Path: "/api/bulk"}, APIPath: "/api", WSPath: "/"}, Config{}, true},
+		{Config{BulkCall: &BulkCallConfig{Path: "/internal/bulk"}, APIPath: "/api/", APIMounts: []APIMount{{Path: "/internal/", Namespace: "int"}}, WSPath: "/"}, Config{}, true},
+		{Config{Probes: &ProbesConfig{HealthPath: "/internal/healthz"}, APIPath: "/api/", APIMounts: []APIMount{{Path: "/internal/", Namespace: "int"}}, WSPath: "/"}, Config{}, true},
+		{Config{Probes: &ProbesConfig{ReadyPath: "/api/readyz"}, APIPath: "/api", WSPath: "/"}, Config{}, true},
+		{Config{SSE: &SSEConfig{Path: "/events"}, WSPath: "/"}, Config{}, true},
+		{Config{SSE: &SSEConfig{Path: "/api/events/"}, WSPath: "/"}, Config{}, true},
+		{Config{SSE: &SSEConfig{KeepAlive: -1}, WSPath: "/"}, Config{}, true},
+		{Config{LongPoll: &LongPollConfig{Path: "poll/"}, WSPath: "/"}, Config{}, true},
+		{Config{LongPoll: &LongPollConfig{Timeout: -1}, WSPath: "/"}, Config{}, true},
+		{Config{LongPoll: &LongPollConfig{Path: "/events/"}, SSE: &SSEConfig{}, WSPath: "/"}, Config{}, true},
+		{Config{SurrogateKeys: &SurrogateKeysConfig{MaxAge: -1}, WSPath: "/"}, Config{}, true},
+		{Config{SurrogateKeys: &SurrogateKeysConfig{Prefix: "api v1"}, WSPath: "/"}, Config{}, true},
+		{Config{SurrogateKeys: &SurrogateKeysConfig{Purge: &SurrogatePurgeConfig{Provider: "akamai"}}, WSPath: "/"}, Config{}, true},
+		{Config{SurrogateKeys: &SurrogateKeysConfig{Purge: &SurrogatePurgeConfig{Provider: "fastly", Token: "token"}}, WSPath: "/"}, Config{}, true},
+		{Config{SurrogateKeys: &SurrogateKeysConfig{Purge: &SurrogatePurgeConfig{Provider: "cloudfront", DistributionID: "EDFDVBD6EXAMPLE"}}, WSPath: "/"}, Config{}, true},
+		{Config{Upstreams: []UpstreamConfig{{Pattern: "test.>", URL: "http://localhost/{{.Name"}}, WSPath: "/"}, Config{}, true},
+		{Config{Upstreams: []UpstreamConfig{{Pattern: "test.>", URL: "http://localhost/", Interval: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{Chunking: &ChunkingConfig{MaxChunks: -1}, WSPath: "/"}, Config{}, true},
+		{Config{PayloadCompression: []PayloadCompression{{Pattern: "test.>", Threshold: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{WebhookSigning: []WebhookSigning{{URL: "/audit", Keys: []WebhookKey{{ID: "k1", Secret: "secret"}}}}, WSPath: "/"}, Config{}, true},
+		{Config{WebhookSigning: []WebhookSigning{{URL: "http://localhost/audit"}}, WSPath: "/"}, Config{}, true},
+		{Config{WebhookSigning: []WebhookSigning{{URL: "http://localhost/audit", Keys: []WebhookKey{{ID: "k=1", Secret: "secret"}}}}, WSPath: "/"}, Config{}, true},
+		{Config{Bandwidth: &BandwidthConfig{Cap: -1}, WSPath: "/"}, Config{}, true},
+		{Config{Bandwidth: &BandwidthConfig{Cap: 1000}, WSPath: "/"}, Config{}, true},
+		{Config{Bandwidth: &BandwidthConfig{ThrottleAt: 0.5}, WSPath: "/"}, Config{}, true},
+		{Config{RateLimit: &RateLimitConfig{WS: &RateLimit{Rate: 0}}, WSPath: "/"}, Config{}, true},
+		{Config{RateLimit: &RateLimitConfig{HTTP: &RateLimit{Rate: 10, Burst: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{Bandwidth: &BandwidthConfig{Cap: 1000, Window: 1000, ThrottleAt: 1}, WSPath: "/"}, Config{}, true},
+		{Config{Quarantine: &QuarantineConfig{Window: 1000}, WSPath: "/"}, Config{}, true},
+		{Config{HeaderRequirements: []HeaderRequirement{{Pattern: "^1"}}, WSPath: "/"}, Config{}, true},
+		{Config{ClientVersions: &ClientVersionConfig{}, WSPath: "/"}, Config{}, true},
+		{Config{LatencyHeatmap: &LatencyHeatmapConfig{Patterns: []string{"test.>.foo"}}, WSPath: "/"}, Config{}, true},
+		{Config{LatencyHeatmap: &LatencyHeatmapConfig{Buckets: []float64{10, 5}}, WSPath: "/"}, Config{}, true},
+		{Config{LatencyHeatmap: &LatencyHeatmapConfig{Interval: -1}, WSPath: "/"}, Config{}, true},
+		{Config{PayloadStats: &PayloadStatsConfig{Patterns: []string{"test..foo"}}, WSPath: "/"}, Config{}, true},
+		{Config{PayloadStats: &PayloadStatsConfig{Samples: -1}, WSPath: "/"}, Config{}, true},
+		{Config{NeverUpdated: &NeverUpdatedConfig{Window: -1}, WSPath: "/"}, Config{}, true},
+		{Config{NeverUpdated: &NeverUpdatedConfig{Ignore: []string{"test.>.foo"}}, WSPath: "/"}, Config{}, true},
+		{Config{AllocationAudit: &AllocationAuditConfig{SampleRate: -1}, WSPath: "/"}, Config{}, true},
+		{Config{BackPressure: &BackPressureConfig{QueueSize: -1}, WSPath: "/"}, Config{}, true},
+		{Config{BackPressure: &BackPressureConfig{Interval: -1}, WSPath: "/"}, Config{}, true},
+		{Config{BulkCall: &BulkCallConfig{Path: "bulk"}, WSPath: "/"}, Config{}, true},
+		{Config{BulkCall: &BulkCallConfig{Path: "/api/bulk"}, WSPath: "/"}, Config{}, true},
+		{Config{BulkCall: &BulkCallConfig{Parallelism: -1}, WSPath: "/"}, Config{}, true},
+		{Config{XMLEncoding: &XMLEncodingConfig{RootElement: "1st"}, WSPath: "/"}, Config{}, true},
+		{Config{XMLEncoding: &XMLEncodingConfig{ItemElement: "an item"}, WSPath: "/"}, Config{}, true},
+		{Config{XMLEncoding: &XMLEncodingConfig{Naming: "upper"}, WSPath: "/"}, Config{}, true},
+		{Config{Probes: &ProbesConfig{HealthPath: "healthz"}, WSPath: "/"}, Config{}, true},
+		{Config{Probes: &ProbesConfig{ReadyPath: "/api/readyz"}, WSPath: "/"}, Config{}, true},
+		{Config{Probes: &ProbesConfig{HealthPath: "/probe", ReadyPath: "/probe"}, WSPath: "/"}, Config{}, true},
+		{Config{Probes: &ProbesConfig{DrainDelay: -1}, WSPath: "/"}, Config{}, true},
+		{Config{APIMounts: []APIMount{{Path: "internal/", Namespace: "int"}}, APIPath: "/api/", WSPath: "/"}, Config{}, true},
+		{Config{APIMounts: []APIMount{{Path: "/internal/", Namespace: "int..v1"}}, APIPath: "/api/", WSPath: "/"}, Config{}, true},
+		{Config{APIMounts: []APIMount{{Path: "/api/", Namespace: "int"}}, APIPath: "/api/", WSPath: "/"}, Config{}, true},
+		{Config{APIMounts: []APIMount{{Path: "/ws", Namespace: "int"}}, APIPath: "/api/", WSPath: "/ws/"}, Config{}, true},
+		{Config{PathNormalization: &PathNormalizationConfig{CaseFolding: []string{"library..book"}}, WSPath: "/"}, Config{}, true},
+		{Config{UniqueCollections: []string{"test..list"}, WSPath: "/"}, Config{}, true},
+		{Config{LinkHeaders: []string{"test.>.model"}, WSPath: "/"}, Config{}, true},
+		{Config{SocketTuning: []SocketTuning{{Listener: ":8081"}}, WSPath: "/"}, Config{}, true},
+		{Config{SocketTuning: []SocketTuning{{Coalesce: "cork"}}, WSPath: "/"}, Config{}, true},
+		{Config{SocketTuning: []SocketTuning{{WriteBuffer: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{SocketTuning: []SocketTuning{{}, {Listener: ""}}, WSPath: "/"}, Config{}, true},
+		{Config{ClientVersions: &ClientVersionConfig{MinVersions: map[string]string{"ios": "2.x"}}, WSPath: "/"}, Config{}, true},
+		{Config{ClientVersions: &ClientVersionConfig{MinVersions: map[string]string{"ios/app": "2.0"}}, WSPath: "/"}, Config{}, true},
+		{Config{HeaderRequirements: []HeaderRequirement{{Header: "X-App-Version", Pattern: "(1"}}, WSPath: "/"}, Config{}, true},
+		{Config{Quarantine: &QuarantineConfig{MaxErrors: 5}, WSPath: "/"}, Config{}, true},
+		{Config{Quarantine: &QuarantineConfig{MaxErrors: 5, Window: 1000, Rate: -1}, WSPath: "/"}, Config{}, true},
+		{Config{GroupAccess: &GroupAccessConfig{TTL: -1}, WSPath: "/"}, Config{}, true},
+		{Config{SlowStart: &SlowStartConfig{Connections: 10}, WSPath: "/"}, Config{}, true},
+		{Config{SlowStart: &SlowStartConfig{Duration: 1000, Requests: -1}, WSPath: "/"}, Config{}, true},
+		{Config{RetryAfter: &RetryAfterConfig{}, WSPath: "/"}, Config{}, true},
+		{Config{RetryAfter: &RetryAfterConfig{Delay: 1000, Jitter: -1}, WSPath: "/"}, Config{}, true},
+		{Config{Listen: []string{"10.0.0.5"}, WSPath: "/"}, Config{}, true},
+		{Config{Listen: []string{"localhost:8080"}, WSPath: "/"}, Config{}, true},
+		{Config{Listen: []string{":0"}, WSPath: "/"}, Config{}, true},
+		{Config{Listen: []string{"0.0.0.0:80"}, WSPath: "/"}, Config{}, true},
+		{Config{Socket: &emptyAddr, WSPath: "/"}, Config{}, true},
+		{Config{DiagnosticsPath: &emptyAddr, WSPath: "/"}, Config{}, true},
+		{Config{SentryDSN: &emptyAddr, WSPath: "/"}, Config{}, true},
+		{Config{SentryDSN: &invalidSentryDSN, WSPath: "/"}, Config{}, true},
+		{Config{OutboxTokenKey: &shortTokenKey, WSPath: "/"}, Config{}, true},
+		{Config{OutboxTokenKey: &tokenKey, OutboxExcludeTokens: true, WSPath: "/"}, Config{}, true},
+		{Config{LogBufferSize: -1, WSPath: "/"}, Config{}, true},
+		{Config{LogBufferLevel: "warn", WSPath: "/"}, Config{}, true},
+		{Config{TraceSampling: &TraceSamplingConfig{Percent: 101}, WSPath: "/"}, Config{}, true},
+		{Config{TraceSampling: &TraceSamplingConfig{Patterns: []string{"test..model"}}, WSPath: "/"}, Config{}, true},
+		{Config{PayloadEncryption: []PayloadEncryption{{Pattern: "test..model", Key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}}, WSPath: "/"}, Config{}, true},
+		{Config{PayloadEncryption: []PayloadEncryption{{Pattern: "test.model", Key: "MDEyMzQ1Njc4OWFiY2RlZg=="}}, WSPath: "/"}, Config{}, true},
+		{Config{SignatureVerification: []SignatureVerification{{Pattern: "test.model"}}, WSPath: "/"}, Config{}, true},
+		{Config{SignatureVerification: []SignatureVerification{{Pattern: "test.model", PublicKeys: []string{"MDEyMzQ1Njc4OWFiY2RlZg=="}}}, WSPath: "/"}, Config{}, true},
+		{Config{SocketMode: "rw", WSPath: "/"}, Config{}, true},
+		{Config{SocketMode: "1777", WSPath: "/"}, Config{}, true},
+		{Config{HTTPLimits: &HTTPLimitsConfig{ReadTimeout: -1}, WSPath: "/"}, Config{}, true},
+		{Config{HTTPLimits: &HTTPLimitsConfig{MaxHeaderBytes: -1}, WSPath: "/"}, Config{}, true},
+		{Config{WSLimits: &WSLimitsConfig{IdleTimeout: -1}, WSPath: "/"}, Config{}, true},
+		{Config{WSLimits: &WSLimitsConfig{MaxHeaderBytes: -1}, WSPath: "/"}, Config{}, true},
 	}
 
 	for i, r := range tbl {
@@ -117,6 +277,15 @@ func TestConfigPrepare(t *testing.T) {
 			}
 		}
 
+		if len(cfg.listenAddrs) != len(r.Expected.listenAddrs) {
+			t.Fatalf("expected listenAddrs to be:\n%+v\nbut got:\n%+v\nin test %d", r.Expected.listenAddrs, cfg.listenAddrs, i+1)
+		}
+		for j, la := range cfg.listenAddrs {
+			if la != r.Expected.listenAddrs[j] {
+				t.Fatalf("expected listenAddrs to be:\n%+v\nbut got:\n%+v\nin test %d", r.Expected.listenAddrs, cfg.listenAddrs, i+1)
+			}
+		}
+
 		compareStringPtr(t, "HeaderAuth", cfg.HeaderAuth, r.Expected.HeaderAuth, i)
 	}
 }
@@ -15,6 +15,9 @@ const (
 	// DefaultPort is the default port for client connections.
 	DefaultPort = 8080
 
+	// DefaultAdminAddr is the default host for the admin endpoint.
+	DefaultAdminAddr = "127.0.0.1"
+
 	// DefaultWSPath is the default path for WebSocket connections.
 	DefaultWSPath = "/"
 
@@ -24,6 +27,9 @@ const (
 	// DefaultAPIEncoding is the default encoding for web resources.
 	DefaultAPIEncoding = "json"
 
+	// WellKnownPath is the path for the document describing the gateway capabilities.
+	WellKnownPath = "/.well-known/resgate"
+
 	// WSTimeout is the wait time for WebSocket connections to close on shutdown.
 	WSTimeout = 3 * time.Second
 
@@ -41,4 +47,58 @@ const (
 
 	// UnsubscribeDelay is the delay for the cache to unsubscribe and evict resources no longer used.
 	UnsubscribeDelay = 5 * time.Second
+
+	// AnnounceNamespace is the subject namespace for discovery announcements.
+	AnnounceNamespace = "resgate.announce"
+
+	// PeerExpireIntervals is the number of missed announce intervals before a peer is removed.
+	PeerExpireIntervals = 3
+
+	// RedisTimeout is the timeout for connecting to, and sending commands to, Redis.
+	RedisTimeout = 3 * time.Second
+
+	// SharedIdempotencyPrefix is the prefix for idempotency keys stored in Redis.
+	SharedIdempotencyPrefix = "resgate:idempotency:"
+
+	// SharedRateLimitPrefix is the prefix for HTTP rate limit counters stored in Redis.
+	SharedRateLimitPrefix = "resgate:ratelimit:"
+
+	// SharedBandwidthPrefix is the prefix for bandwidth counters stored in Redis.
+	SharedBandwidthPrefix = "resgate:bandwidth:"
+
+	// BandwidthSyncInterval is the interval for adding the bytes transferred by the connections of the instance to the bandwidth counters stored in Redis.
+	BandwidthSyncInterval = 500 * time.Millisecond
+
+	// IdempotencyPollInterval is the interval for polling Redis for the response to a request in progress on another instance.
+	IdempotencyPollInterval = 100 * time.Millisecond
+
+	// SlowStartInitialFraction is the fraction of the full slow-start rate allowed at the beginning of the ramp-up.
+	SlowStartInitialFraction = 0.1
+
+	// DiagnosticsLogSize is the number of recent log entries included in a diagnostics bundle.
+	DiagnosticsLogSize = 500
+
+	// AuditHTTPTimeout is the timeout for posting audit records to an HTTP endpoint.
+	AuditHTTPTimeout = 5 * time.Second
+
+	// OutboxResendInterval is the interval for resending call requests stored in the outbox.
+	OutboxResendInterval = 10 * time.Second
+
+	// ChallengeVerifyTimeout is the timeout for verifying challenge responses with the challenge webhook.
+	ChallengeVerifyTimeout = 5 * time.Second
+
+	// BlobFetchTimeout is the timeout for receiving the response headers when fetching a blob from its URL.
+	BlobFetchTimeout = 10 * time.Second
+
+	// UploadStoreTimeout is the timeout for putting or deleting an uploaded file in a remote upload store.
+	UploadStoreTimeout = 60 * time.Second
+
+	// UpstreamTimeout is the timeout for fetching a resource from an upstream HTTP endpoint.
+	UpstreamTimeout = 3 * time.Second
+
+	// UpstreamCacheSize is the maximum number of upstream responses cached for conditional requests.
+	UpstreamCacheSize = 10000
+
+	// SurrogatePurgeTimeout is the timeout for a purge request to a CDN API.
+	SurrogatePurgeTimeout = 10 * time.Second
 )
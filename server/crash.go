@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/resgateio/resgate/logger"
+)
+
+// diagnostics is the bundle written on panics.
+type diagnostics struct {
+	Time    string             `json:"time"`
+	Source  string             `json:"source"`
+	Panic   string             `json:"panic"`
+	Fatal   bool               `json:"fatal"`
+	Stack   string             `json:"stack"`
+	Summary startupSummary     `json:"summary"`
+	Metrics diagnosticsMetrics `json:"metrics"`
+	Logs    []logger.Entry     `json:"logs"`
+}
+
+// diagnosticsMetrics is a snapshot of runtime and service metrics.
+type diagnosticsMetrics struct {
+	Uptime      int64  `json:"uptime"`
+	Connections int    `json:"connections"`
+	Goroutines  int    `json:"goroutines"`
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	Sys         uint64 `json:"sys"`
+	NumGC       uint32 `json:"numGC"`
+}
+
+// handlePanic logs a panic recovered in a connection handler, writes a
+// diagnostics bundle, and disconnects the connection to isolate it from the
+// rest of the service.
+func (c *wsConn) handlePanic(v interface{}) {
+	stack := debug.Stack()
+	msg := fmt.Sprintf("Panic in connection handler: %v", v)
+	c.serv.logger.Error(fmt.Sprintf("%s %s\n%s", c.connStr, msg, stack))
+	c.serv.report(ErrorEvent{Message: msg, Source: "panic", CID: c.cid, Stack: string(stack)})
+	c.serv.writeDiagnostics("connection "+c.cid, v, stack, false)
+	c.Disconnect("panic")
+}
+
+// safeCall calls f, recovering any panic.
+func (c *wsConn) safeCall(f func()) {
+	defer func() {
+		if v := recover(); v != nil {
+			c.handlePanic(v)
+		}
+	}()
+	f()
+}
+
+// recoverFatal reports a panic and writes a diagnostics bundle, before
+// letting the panic crash the process. It is deferred in goroutines started by
+// the service.
+func (s *Service) recoverFatal(source string) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	stack := debug.Stack()
+	s.report(ErrorEvent{Level: "fatal", Message: fmt.Sprintf("Panic in %s: %v", source, v), Source: "panic", Stack: string(stack)})
+	s.writeDiagnostics(source, v, stack, true)
+	s.flushErrorReporter()
+	panic(v)
+}
+
+// writeDiagnostics writes a diagnostics bundle with the stack of all
+// goroutines, a configuration summary, a metrics snapshot, and the recent log
+// entries to the diagnostics directory, if configured.
+func (s *Service) writeDiagnostics(source string, v interface{}, stack []byte, fatal bool) {
+	if s.cfg.DiagnosticsPath == nil {
+		return
+	}
+	now := time.Now()
+	// Include the stack of all goroutines after that of the panic
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s.mu.Lock()
+	conns := len(s.conns)
+	started := s.started
+	s.mu.Unlock()
+
+	d := diagnostics{
+		Time:    now.UTC().Format(time.RFC3339Nano),
+		Source:  source,
+		Panic:   fmt.Sprint(v),
+		Fatal:   fatal,
+		Stack:   string(stack) + "\n" + string(buf),
+		Summary: s.summary(),
+		Metrics: diagnosticsMetrics{
+			Uptime:      int64(now.Sub(started) / time.Millisecond),
+			Connections: conns,
+			Goroutines:  runtime.NumGoroutine(),
+			HeapAlloc:   ms.HeapAlloc,
+			Sys:         ms.Sys,
+			NumGC:       ms.NumGC,
+		},
+		Logs: []logger.Entry{},
+	}
+	if s.ring != nil {
+		d.Logs = s.ring.Entries()
+	}
+	data, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		s.Errorf("Error encoding diagnostics bundle: %s", err)
+		return
+	}
+	if err := os.MkdirAll(*s.cfg.DiagnosticsPath, 0750); err != nil {
+		s.Errorf("Error creating diagnostics directory: %s", err)
+		return
+	}
+	path := filepath.Join(*s.cfg.DiagnosticsPath, fmt.Sprintf("resgate-%s-%s.json", s.id, now.UTC().Format("20060102T150405.000000000")))
+	if err := ioutil.WriteFile(path, data, 0640); err != nil {
+		s.Errorf("Error writing diagnostics bundle: %s", err)
+		return
+	}
+	s.Logf("Diagnostics bundle written to %s", path)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/logger"
+)
+
+// Test that a panic in a connection handler is recovered, and that a
+// diagnostics bundle is written
+func TestSafeCall_Panic_WritesDiagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resgate-diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := Config{DiagnosticsPath: &dir}
+	cfg.SetDefault()
+	s, err := NewService(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := logger.NewMemLogger(false, false)
+	s.SetLogger(l)
+	s.Logf("Before panic")
+
+	c := &wsConn{serv: s, cid: "test"}
+	c.safeCall(func() { panic("boom") })
+
+	if !strings.Contains(l.String(), "Panic in connection handler: boom") {
+		t.Fatalf("expected panic to be logged, but got:\n%s", l.String())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "resgate-*.json"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 diagnostics bundle, but got %d", len(files))
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d diagnostics
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatalf("error decoding diagnostics bundle: %s", err)
+	}
+	if d.Source != "connection test" || d.Panic != "boom" || d.Fatal || !strings.Contains(d.Stack, "TestSafeCall_Panic_WritesDiagnostics") {
+		t.Fatalf("expected diagnostics with panic and stack, but got %+v", d)
+	}
+	if len(d.Logs) < 2 || d.Logs[0].Message != "Before panic" {
+		t.Fatalf("expected diagnostics with recent logs, but got %+v", d.Logs)
+	}
+	if d.Summary.Version != Version || d.Metrics.Goroutines == 0 {
+		t.Fatalf("expected diagnostics with summary and metrics, but got %+v", d)
+	}
+}
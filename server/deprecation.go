@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// Deprecation holds the configuration for marking resources, or methods on
+// resources, as deprecated.
+//
+// HTTP API responses for a deprecated resource or method include a
+// Deprecation header, and a Sunset and a Link header with the deprecation
+// relation type if set. WebSocket responses include a warning:
+//
+//	{"result":{...},"id":1,"warning":{"code":"system.deprecated","message":"Deprecated resource","data":{"sunset":"2027-01-01T00:00:00Z"}}}
+type Deprecation struct {
+	// Pattern is the resource pattern of the deprecated resources.
+	Pattern string `json:"pattern"`
+	// Method is the name of the deprecated method. Empty means the resources
+	// are deprecated, including all methods on them.
+	Method string `json:"method,omitempty"`
+	// Since is the RFC 3339 time of the deprecation. Empty means the
+	// Deprecation header is set to true.
+	Since string `json:"since,omitempty"`
+	// Sunset is the RFC 3339 time after which the resource or method may
+	// become unavailable.
+	Sunset string `json:"sunset,omitempty"`
+	// Link is a URL to information about the deprecation.
+	Link string `json:"link,omitempty"`
+	// Message is the warning message. Defaults to "Deprecated resource" or
+	// "Deprecated method".
+	Message string `json:"message,omitempty"`
+}
+
+// deprecationRule is a prepared deprecation.
+type deprecationRule struct {
+	pattern     rescache.ResourcePattern
+	method      string
+	deprecation string
+	sunset      string
+	link        string
+	warning     *rpc.Warning
+}
+
+// deprecationData is the data of the warning included in WebSocket
+// responses.
+type deprecationData struct {
+	Since  string `json:"since,omitempty"`
+	Sunset string `json:"sunset,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// prepare validates the deprecation, and returns the prepared rule.
+func (d Deprecation) prepare() (deprecationRule, error) {
+	p := rescache.ParseResourcePattern(d.Pattern)
+	if !p.IsValid() {
+		return deprecationRule{}, fmt.Errorf("pattern %q must be a valid resource pattern", d.Pattern)
+	}
+	if d.Method != "" && !codec.IsValidRIDPart(d.Method) {
+		return deprecationRule{}, fmt.Errorf("method %q must be a valid method name", d.Method)
+	}
+	r := deprecationRule{pattern: p, method: d.Method, deprecation: "true", link: d.Link}
+	if d.Since != "" {
+		t, err := time.Parse(time.RFC3339, d.Since)
+		if err != nil {
+			return deprecationRule{}, errors.New("since must be a valid RFC 3339 time")
+		}
+		r.deprecation = "@" + strconv.FormatInt(t.Unix(), 10)
+	}
+	if d.Sunset != "" {
+		t, err := time.Parse(time.RFC3339, d.Sunset)
+		if err != nil {
+			return deprecationRule{}, errors.New("sunset must be a valid RFC 3339 time")
+		}
+		r.sunset = t.UTC().Format(http.TimeFormat)
+	}
+	msg := d.Message
+	if msg == "" {
+		if d.Method == "" {
+			msg = "Deprecated resource"
+		} else {
+			msg = "Deprecated method"
+		}
+	}
+	r.warning = &rpc.Warning{Code: "system.deprecated", Message: msg}
+	if d.Since != "" || d.Sunset != "" || d.Link != "" {
+		r.warning.Data = deprecationData{Since: d.Since, Sunset: d.Sunset, Link: d.Link}
+	}
+	return r, nil
+}
+
+// deprecationRule returns the first deprecation rule matching the resource
+// and method, or nil if none matches. The method is empty for get requests.
+func (s *Service) deprecationRule(rid, method string) *deprecationRule {
+	if len(s.cfg.deprecationRules) == 0 {
+		return nil
+	}
+	rname, _ := parseRID(rid)
+	for i := range s.cfg.deprecationRules {
+		r := &s.cfg.deprecationRules[i]
+		if (r.method == "" || r.method == method) && r.pattern.Match(rname) {
+			return r
+		}
+	}
+	return nil
+}
+
+// setDeprecationHeaders sets the Deprecation, Sunset, and Link headers if the
+// resource or method is deprecated.
+func (s *Service) setDeprecationHeaders(w http.ResponseWriter, rid, method string) {
+	r := s.deprecationRule(rid, method)
+	if r == nil {
+		return
+	}
+	h := w.Header()
+	h.Set("Deprecation", r.deprecation)
+	if r.sunset != "" {
+		h.Set("Sunset", r.sunset)
+	}
+	if r.link != "" {
+		h.Add("Link", "<"+r.link+">; rel=\"deprecation\"")
+	}
+}
+
+// DeprecationWarning returns the warning to include in the response of a
+// request on a deprecated resource or method, or nil if not deprecated.
+func (c *wsConn) DeprecationWarning(rid, method string) *rpc.Warning {
+	if r := c.serv.deprecationRule(rid, method); r != nil {
+		return r.warning
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// announcement is the payload published by each gateway instance on
+// AnnounceNamespace+"."+id.
+type announcement struct {
+	ID          string `json:"id"`
+	Version     string `json:"version"`
+	Protocol    string `json:"protocol"`
+	Addr        string `json:"addr"`
+	AdminAddr   string `json:"adminAddr,omitempty"`
+	Connections int    `json:"connections"`
+	Started     string `json:"started"`
+	Interval    int    `json:"interval"`
+	Leader      bool   `json:"leader,omitempty"`
+	Stopping    bool   `json:"stopping,omitempty"`
+}
+
+// peer is an announcement received from another gateway instance.
+type peer struct {
+	announcement
+	LastSeen string `json:"lastSeen"`
+	lastSeen time.Time
+}
+
+// peerTable holds the gateway instances discovered through announcements.
+type peerTable struct {
+	mu    sync.Mutex
+	peers map[string]*peer
+}
+
+// startDiscovery subscribes to peer announcements, if enabled, and starts
+// publishing announcements at an interval.
+// Service.mu is held when called
+func (s *Service) startDiscovery() error {
+	s.started = time.Now()
+	if s.cfg.DiscoverPeers || s.cfg.LeaderElection {
+		s.peers.peers = make(map[string]*peer)
+		if _, err := s.mq.Subscribe(AnnounceNamespace, s.handleAnnouncement); err != nil {
+			return err
+		}
+	}
+	if s.cfg.AnnounceInterval == 0 {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	s.announceStop = stop
+	s.announceDone = done
+	go func() {
+		defer s.recoverFatal("discovery")
+		defer close(done)
+		ticker := time.NewTicker(time.Duration(s.cfg.AnnounceInterval) * time.Millisecond)
+		defer ticker.Stop()
+		s.announce(false)
+		for {
+			select {
+			case <-ticker.C:
+				s.updateLeadership()
+				s.announce(false)
+			case <-stop:
+				s.announce(true)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// stopDiscovery stops publishing announcements, publishing a final
+// announcement to let peers know the instance is stopping.
+func (s *Service) stopDiscovery() {
+	s.mu.Lock()
+	stop, done := s.announceStop, s.announceDone
+	s.announceStop, s.announceDone = nil, nil
+	s.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// self returns the announcement describing this instance.
+func (s *Service) self(stopping bool) announcement {
+	s.mu.Lock()
+	conns := len(s.conns)
+	s.mu.Unlock()
+
+	a := announcement{
+		ID:          s.id,
+		Version:     Version,
+		Protocol:    ProtocolVersion,
+		Addr:        s.cfg.scheme + "://" + s.cfg.netAddr,
+		Connections: conns,
+		Started:     s.started.UTC().Format(time.RFC3339),
+		Interval:    s.cfg.AnnounceInterval,
+		Leader:      !stopping && s.IsLeader(),
+		Stopping:    stopping,
+	}
+	if s.cfg.Socket != nil {
+		a.Addr = s.cfg.scheme + "+unix://" + *s.cfg.Socket
+	}
+	if s.cfg.adminNetAddr != "" {
+		a.AdminAddr = "http://" + s.cfg.adminNetAddr
+	}
+	return a
+}
+
+// announce publishes an announcement of this instance.
+func (s *Service) announce(stopping bool) {
+	data, _ := json.Marshal(s.self(stopping))
+	if err := s.mq.Publish(AnnounceNamespace+"."+s.id, data); err != nil {
+		s.Errorf("Error publishing announcement: %s", err)
+	}
+}
+
+// handleAnnouncement updates the peer table with an announcement received
+// from another instance.
+func (s *Service) handleAnnouncement(subj string, payload []byte, err error) {
+	if err != nil {
+		return
+	}
+	var a announcement
+	if err := json.Unmarshal(payload, &a); err != nil || a.ID == "" {
+		s.Errorf("Error processing announcement %s: invalid payload", subj)
+		return
+	}
+	if a.ID == s.id {
+		return
+	}
+
+	s.peers.mu.Lock()
+	defer s.peers.mu.Unlock()
+	if a.Stopping {
+		if _, ok := s.peers.peers[a.ID]; ok {
+			s.Debugf("Peer %s stopped", a.ID)
+			delete(s.peers.peers, a.ID)
+		}
+		return
+	}
+	if _, ok := s.peers.peers[a.ID]; !ok {
+		s.Debugf("Peer %s discovered at %s", a.ID, a.Addr)
+	}
+	s.peers.peers[a.ID] = &peer{announcement: a, lastSeen: time.Now()}
+}
+
+// list returns the peers sorted by ID, removing any peer not heard from
+// within PeerExpireIntervals of its announce interval.
+func (t *peerTable) list() []peer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	l := make([]peer, 0, len(t.peers))
+	for id, p := range t.peers {
+		if now.Sub(p.lastSeen) > time.Duration(p.Interval*PeerExpireIntervals)*time.Millisecond {
+			delete(t.peers, id)
+			continue
+		}
+		pc := *p
+		pc.LastSeen = p.lastSeen.UTC().Format(time.RFC3339)
+		l = append(l, pc)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].ID < l[j].ID })
+	return l
+}
+
+// adminPeersHandler returns the announcement of this instance together with
+// the discovered peers.
+func (s *Service) adminPeersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	peers := []peer{}
+	if s.peers.peers != nil {
+		peers = s.peers.list()
+	}
+	adminResponse(w, struct {
+		Self   announcement `json:"self"`
+		Peers  []peer       `json:"peers"`
+		Leader string       `json:"leader,omitempty"`
+	}{s.self(false), peers, s.leaderID()})
+}
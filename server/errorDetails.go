@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/rs/xid"
+)
+
+// correlationData is the data of an error with hidden details.
+type correlationData struct {
+	CorrelationID string `json:"correlationId"`
+}
+
+// clientError converts an error into the error sent to a client, mapped by
+// the errorMappings setting using the client request r, which may be nil.
+//
+// If hideErrorDetails is set, internal errors and timeouts are replaced
+// with a generic error, with a correlation ID as data. The original error
+// is logged together with the correlation ID.
+func (s *Service) clientError(err error, r *http.Request) *reserr.Error {
+	rerr := s.mapError(reserr.RESError(err), r)
+	if !s.cfg.HideErrorDetails {
+		return s.localizeError(rerr, r)
+	}
+
+	var generic *reserr.Error
+	switch rerr.Code {
+	case reserr.CodeInternalError:
+		generic = reserr.ErrInternalError
+	case reserr.CodeTimeout:
+		generic = reserr.ErrTimeout
+	default:
+		return s.localizeError(rerr, r)
+	}
+
+	id := xid.New().String()
+	details, _ := json.Marshal(rerr)
+	s.Errorf("Client error %s: %s", id, details)
+	return s.localizeError(&reserr.Error{Code: generic.Code, Message: generic.Message, Data: correlationData{CorrelationID: id}}, r)
+}
+
+// ClientError converts an error into the error sent to the client.
+// A system.invalidRequest reply to a client request counts as a protocol
+// error.
+func (c *wsConn) ClientError(err error) *reserr.Error {
+	if err == reserr.ErrInvalidRequest {
+		c.protocolError()
+	}
+	return c.serv.clientError(err, c.request)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// ErrorMapping holds the client facing code and message for a service
+// error code. Empty values are not mapped.
+type ErrorMapping struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Messages holds localized messages by language tag, selected using
+	// the Accept-Language header of the client request.
+	Messages map[string]string `json:"messages,omitempty"`
+}
+
+// prepare returns a copy of the mapping with lower case language tags.
+func (m ErrorMapping) prepare() (ErrorMapping, error) {
+	if m.Code != "" && !codec.IsValidRID(m.Code, false) {
+		return m, fmt.Errorf("code '%s' must be a valid error code", m.Code)
+	}
+	msgs := make(map[string]string, len(m.Messages))
+	for tag, msg := range m.Messages {
+		if tag == "" || tag == "*" || strings.ContainsAny(tag, ",; ") {
+			return m, fmt.Errorf("messages language tag '%s' must be a valid language tag", tag)
+		}
+		msgs[strings.ToLower(tag)] = msg
+	}
+	m.Messages = msgs
+	return m, nil
+}
+
+// mapError returns the error mapped by the errorMappings setting. If no
+// mapping exists for the error code, rerr is returned.
+func (s *Service) mapError(rerr *reserr.Error, r *http.Request) *reserr.Error {
+	m, ok := s.cfg.errorMappings[rerr.Code]
+	if !ok {
+		return rerr
+	}
+
+	mapped := *rerr
+	if m.Code != "" {
+		mapped.Code = m.Code
+	}
+	if m.Message != "" {
+		mapped.Message = m.Message
+	}
+	if len(m.Messages) > 0 && r != nil {
+		for _, tag := range acceptLanguages(r.Header.Get("Accept-Language")) {
+			if msg, ok := m.Messages[tag]; ok {
+				mapped.Message = msg
+				break
+			}
+		}
+	}
+	return &mapped
+}
+
+// acceptLanguages parses an Accept-Language header value, returning the
+// lower case language tags ordered by quality value. Each tag with a
+// subtag, such as "sv-se", is followed by its primary tag, "sv".
+func acceptLanguages(h string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(h, ",") {
+		tag := strings.TrimSpace(part)
+		q := 1.0
+		if i := strings.IndexByte(tag, ';'); i >= 0 {
+			p := strings.TrimSpace(tag[i+1:])
+			tag = strings.TrimSpace(tag[:i])
+			if strings.HasPrefix(p, "q=") {
+				v, err := strconv.ParseFloat(p[2:], 64)
+				if err != nil {
+					continue
+				}
+				q = v
+			}
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		langs = append(langs, lang{tag: strings.ToLower(tag), q: q})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, 0, len(langs))
+	for _, l := range langs {
+		tags = append(tags, l.tag)
+		if i := strings.IndexByte(l.tag, '-'); i > 0 {
+			tags = append(tags, l.tag[:i])
+		}
+	}
+	return tags
+}
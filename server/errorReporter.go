@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/resgateio/resgate/server/sentry"
+)
+
+// ErrorReporterFlushTimeout is the time to wait for pending error reports to
+// be sent when the service stops, or before crashing on a panic.
+const ErrorReporterFlushTimeout = 2 * time.Second
+
+// ErrorReporter is an error reporting service, receiving errors logged by the
+// gateway, such as recovered panics, NATS failures, and protocol violations
+// by services.
+type ErrorReporter interface {
+	// Report reports an error. It must not block.
+	Report(ev ErrorEvent)
+	// Flush waits until pending reports are sent, or until the timeout.
+	Flush(timeout time.Duration)
+}
+
+// ErrorEvent is an error passed to an ErrorReporter.
+type ErrorEvent struct {
+	// Level is either "error" or "fatal".
+	Level string
+	// Message is the error message.
+	Message string
+	// Source is the part of the gateway reporting the error, such as
+	// "service", "nats", "connection", "cache", or "panic".
+	Source string
+	// CID is the ID of the client connection, if any.
+	CID string
+	// RID is the ID of the resource, if any.
+	RID string
+	// Stack is the stack trace of a panic, if any.
+	Stack string
+}
+
+// sentryReporter is an ErrorReporter sending errors to Sentry.
+type sentryReporter struct {
+	c  *sentry.Client
+	id string
+}
+
+// SetErrorReporter sets the error reporter, replacing the one created from
+// the sentryDsn configuration.
+func (s *Service) SetErrorReporter(r ErrorReporter) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		panic("SetErrorReporter must be called before starting server")
+	}
+
+	s.reporter = r
+	return s
+}
+
+func (s *Service) initErrorReporter() error {
+	s.cache.SetErrorReporter(func(rid string, msg string) {
+		s.report(ErrorEvent{Message: msg, Source: "cache", RID: rid})
+	})
+	if s.cfg.SentryDSN == nil {
+		return nil
+	}
+	host, _ := os.Hostname()
+	c, err := sentry.NewClient(*s.cfg.SentryDSN, Version, host)
+	if err != nil {
+		return err
+	}
+	s.reporter = sentryReporter{c: c, id: s.id}
+	return nil
+}
+
+// report passes an error to the error reporter, if any.
+func (s *Service) report(ev ErrorEvent) {
+	if s.reporter == nil {
+		return
+	}
+	if ev.Level == "" {
+		ev.Level = "error"
+	}
+	s.reporter.Report(ev)
+}
+
+// flushErrorReporter waits for pending error reports to be sent.
+func (s *Service) flushErrorReporter() {
+	if s.reporter != nil {
+		s.reporter.Flush(ErrorReporterFlushTimeout)
+	}
+}
+
+// errorf writes a formatted log message, and reports it with the source.
+func (s *Service) errorf(source string, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	s.logger.Error(msg)
+	s.report(ErrorEvent{Message: msg, Source: source})
+}
+
+// Report sends the error to Sentry, tagged with the source, instance ID,
+// connection ID, and resource ID.
+func (r sentryReporter) Report(ev ErrorEvent) {
+	tags := map[string]string{
+		"source":   ev.Source,
+		"instance": r.id,
+	}
+	if ev.CID != "" {
+		tags["cid"] = ev.CID
+	}
+	if ev.RID != "" {
+		tags["rid"] = ev.RID
+	}
+	var extra map[string]string
+	if ev.Stack != "" {
+		extra = map[string]string{"stack": ev.Stack}
+	}
+	r.c.Capture(&sentry.Event{
+		Level:   ev.Level,
+		Logger:  "resgate",
+		Message: ev.Message,
+		Tags:    tags,
+		Extra:   extra,
+	})
+}
+
+// Flush waits until pending events are sent to Sentry.
+func (r sentryReporter) Flush(timeout time.Duration) {
+	r.c.Flush(timeout)
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// eventStats holds the resource event counters of the cache.
+type eventStats struct {
+	Suppressed rescache.SuppressedStats `json:"suppressed"`
+	Sequence   rescache.SequenceStats   `json:"sequence"`
+}
+
+// adminEventsHandler returns the resource event counters, such as the number
+// of events suppressed for not changing the cached resource state, or not
+// applied for being out of sequence.
+func (s *Service) adminEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	adminResponse(w, eventStats{
+		Suppressed: s.cache.SuppressedEvents(),
+		Sequence:   s.cache.SequenceEvents(),
+	})
+}
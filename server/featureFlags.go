@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"hash/fnv"
+)
+
+// FlagProvider is a feature flag provider used to toggle gateway behaviors
+// without restarts. It follows the flag evaluation API of OpenFeature, and
+// may be implemented by an adapter for an OpenFeature client.
+//
+// Flags evaluated by the gateway:
+//
+//	wsCompression (boolean)    - WebSocket write compression for a connection.
+//	<canary/shadow flag> (float) - Percentage for a canary or shadow route.
+type FlagProvider interface {
+	// BooleanValue returns the value of a boolean flag, or defaultValue if
+	// the flag is not set for the context.
+	BooleanValue(flag string, defaultValue bool, ec FlagContext) bool
+	// FloatValue returns the value of a number flag, or defaultValue if the
+	// flag is not set for the context.
+	FloatValue(flag string, defaultValue float64, ec FlagContext) float64
+}
+
+// FlagContext is the context used when evaluating a feature flag.
+type FlagContext struct {
+	// TargetingKey identifies the subject of the evaluation, such as a
+	// connection ID or a resource name. It is used for percentage rollouts.
+	TargetingKey string
+	// Token is the access token of the connection, if any.
+	Token json.RawMessage
+}
+
+// FeatureFlag holds the configuration for a feature flag of the built-in
+// flag provider.
+type FeatureFlag struct {
+	// Value of the flag, when the flag applies.
+	Value json.RawMessage `json:"value"`
+	// Percent of targeting keys that the flag applies to. Defaults to 100.
+	Percent *float64 `json:"percent,omitempty"`
+	// Token claims that must match the connection token for the flag to
+	// apply.
+	Token map[string]json.RawMessage `json:"token,omitempty"`
+}
+
+// configFlagProvider is a FlagProvider using the featureFlags configuration.
+type configFlagProvider map[string]FeatureFlag
+
+// SetFlagProvider sets the feature flag provider, replacing the one created
+// from the featureFlags configuration.
+func (s *Service) SetFlagProvider(p FlagProvider) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		panic("SetFlagProvider must be called before starting server")
+	}
+
+	s.flags = p
+	s.upgrader.EnableCompression = true
+	return s
+}
+
+func (s *Service) initFeatureFlags() {
+	if len(s.cfg.FeatureFlags) > 0 {
+		s.flags = configFlagProvider(s.cfg.FeatureFlags)
+		s.upgrader.EnableCompression = true
+	}
+	for i, r := range s.cfg.canaryRoutes {
+		if flag := s.cfg.CanaryRoutes[i].Flag; flag != "" {
+			r.PercentFunc = s.percentFlag(flag, r.Percent)
+		}
+	}
+	for i, r := range s.cfg.shadowRoutes {
+		if flag := s.cfg.ShadowRoutes[i].Flag; flag != "" {
+			r.PercentFunc = s.percentFlag(flag, r.Percent)
+		}
+	}
+}
+
+// percentFlag returns a function evaluating a number flag for a request on a
+// resource, used as percentage for canary and shadow routes.
+func (s *Service) percentFlag(flag string, def float64) func(rname string, token interface{}) float64 {
+	return func(rname string, token interface{}) float64 {
+		if s.flags == nil {
+			return def
+		}
+		tok, _ := token.(json.RawMessage)
+		return s.flags.FloatValue(flag, def, FlagContext{TargetingKey: rname, Token: tok})
+	}
+}
+
+// flagBool evaluates a boolean flag, returning def if no flag provider is set.
+func (s *Service) flagBool(flag string, def bool, ec FlagContext) bool {
+	if s.flags == nil {
+		return def
+	}
+	return s.flags.BooleanValue(flag, def, ec)
+}
+
+// BooleanValue implements FlagProvider.
+func (p configFlagProvider) BooleanValue(flag string, defaultValue bool, ec FlagContext) bool {
+	var v bool
+	if !p.value(flag, ec, &v) {
+		return defaultValue
+	}
+	return v
+}
+
+// FloatValue implements FlagProvider.
+func (p configFlagProvider) FloatValue(flag string, defaultValue float64, ec FlagContext) float64 {
+	var v float64
+	if !p.value(flag, ec, &v) {
+		return defaultValue
+	}
+	return v
+}
+
+// value decodes the flag value into v if the flag applies to the context.
+func (p configFlagProvider) value(flag string, ec FlagContext, v interface{}) bool {
+	f, ok := p[flag]
+	if !ok {
+		return false
+	}
+	if f.Token != nil && !tokenMatches(ec.Token, f.Token) {
+		return false
+	}
+	if f.Percent != nil && rolloutBucket(flag, ec.TargetingKey) >= *f.Percent {
+		return false
+	}
+	return json.Unmarshal(f.Value, v) == nil
+}
+
+// rolloutBucket returns a stable number in the range [0, 100) for a flag and
+// targeting key.
+func rolloutBucket(flag, key string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(flag))
+	h.Write([]byte{'.'})
+	h.Write([]byte(key))
+	return float64(h.Sum32()%10000) / 100
+}
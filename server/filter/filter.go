@@ -0,0 +1,454 @@
+// Package filter implements expressions used to filter the events delivered
+// to a client for a subscription.
+//
+// An expression is evaluated against the data of an event, such as
+// {"idx":2,"value":"foo"} for a collection add event, or
+// {"values":{"status":"done"}} for a model change event. Fields are selected
+// with dot separated paths, where a numeric segment selects an array element.
+// A path to a missing field evaluates to null.
+//
+// Supported are the literals null, true, false, numbers, and double quoted
+// strings, the comparison operators ==, !=, <, <=, >, >=, the membership
+// operator in with a list of literals, the logical operators !, &&, ||, and
+// parentheses. Eg.:
+//
+//	values.status == "done" && values.priority >= 3
+//	value.data.type in ["alert", "warning"]
+//
+// Ordering operators are only true when comparing two numbers or two
+// strings. A path used as an operand of a logical operator is true only if
+// it evaluates to true.
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MaxLength is the maximum length in bytes of an expression.
+const MaxLength = 1024
+
+// Expr is a parsed filter expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+type node interface {
+	eval(data interface{}) bool
+}
+
+type operand interface {
+	value(data interface{}) interface{}
+}
+
+type literal struct{ v interface{} }
+
+type path []string
+
+type orNode struct{ a, b node }
+
+type andNode struct{ a, b node }
+
+type notNode struct{ x node }
+
+type truthNode struct{ x operand }
+
+type cmpNode struct {
+	op   string
+	l, r operand
+}
+
+type inNode struct {
+	x    operand
+	list []interface{}
+}
+
+// Parse parses a filter expression.
+func Parse(s string) (*Expr, error) {
+	if len(s) > MaxLength {
+		return nil, fmt.Errorf("expression exceeds %d bytes", MaxLength)
+	}
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].s)
+	}
+	return &Expr{src: s, root: root}, nil
+}
+
+// Match reports whether the JSON encoded data matches the expression.
+// Data that is not valid JSON never matches.
+func (e *Expr) Match(data json.RawMessage) bool {
+	var v interface{}
+	if json.Unmarshal(data, &v) != nil {
+		return false
+	}
+	return e.MatchValue(v)
+}
+
+// MatchValue reports whether data, as decoded by encoding/json into an
+// interface{}, matches the expression.
+func (e *Expr) MatchValue(data interface{}) bool {
+	return e.root.eval(data)
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+func (l literal) value(data interface{}) interface{} {
+	return l.v
+}
+
+func (p path) value(data interface{}) interface{} {
+	v := data
+	for _, seg := range p {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[seg]
+		case []interface{}:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil
+			}
+			v = t[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func (n orNode) eval(data interface{}) bool {
+	return n.a.eval(data) || n.b.eval(data)
+}
+
+func (n andNode) eval(data interface{}) bool {
+	return n.a.eval(data) && n.b.eval(data)
+}
+
+func (n notNode) eval(data interface{}) bool {
+	return !n.x.eval(data)
+}
+
+func (n truthNode) eval(data interface{}) bool {
+	b, ok := n.x.value(data).(bool)
+	return ok && b
+}
+
+func (n inNode) eval(data interface{}) bool {
+	v := n.x.value(data)
+	for _, lv := range n.list {
+		if reflect.DeepEqual(v, lv) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n cmpNode) eval(data interface{}) bool {
+	l, r := n.l.value(data), n.r.value(data)
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(l, r)
+	case "!=":
+		return !reflect.DeepEqual(l, r)
+	}
+	var c int
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case lv < rv:
+			c = -1
+		case lv > rv:
+			c = 1
+		}
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(lv, rv)
+	default:
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default: // >=
+		return c >= 0
+	}
+}
+
+type tokenType byte
+
+const (
+	tokIdent tokenType = iota
+	tokNumber
+	tokString
+	tokPunct
+)
+
+type token struct {
+	typ tokenType
+	s   string
+}
+
+var puncts = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", "."}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	i := 0
+outer:
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, errors.New("unterminated string")
+			}
+			str, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", s[i:j+1])
+			}
+			toks = append(toks, token{tokString, str})
+			i = j + 1
+		case isDigit(c) && len(toks) > 0 && toks[len(toks)-1] == token{tokPunct, "."}:
+			// Array index path segment
+			j := i + 1
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			toks = append(toks, token{tokNumber, s[i:j]})
+			i = j
+		case isDigit(c) || (c == '-' && i+1 < len(s) && isDigit(s[i+1])):
+			j := i + 1
+			for j < len(s) && (isDigit(s[j]) || s[j] == '.' || s[j] == 'e' || s[j] == 'E' || ((s[j] == '+' || s[j] == '-') && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			toks = append(toks, token{tokNumber, s[i:j]})
+			i = j
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(s) && (isIdentStart(s[j]) || isDigit(s[j])) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, s[i:j]})
+			i = j
+		default:
+			for _, p := range puncts {
+				if strings.HasPrefix(s[i:], p) {
+					toks = append(toks, token{tokPunct, p})
+					i += len(p)
+					continue outer
+				}
+			}
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return toks, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek(s string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].typ == tokPunct && p.toks[p.pos].s == s
+}
+
+func (p *parser) expect(s string) error {
+	if !p.peek(s) {
+		return p.unexpected(s)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	if p.pos >= len(p.toks) {
+		return fmt.Errorf("expected %s but found end of expression", want)
+	}
+	return fmt.Errorf("expected %s but found %q", want, p.toks[p.pos].s)
+}
+
+func (p *parser) parseOr() (node, error) {
+	n, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("||") {
+		p.pos++
+		b, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		n = orNode{n, b}
+	}
+	return n, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("&&") {
+		p.pos++
+		b, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		n = andNode{n, b}
+	}
+	return n, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek("!") {
+		p.pos++
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	if p.peek("(") {
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	}
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		t := p.toks[p.pos]
+		switch {
+		case t.typ == tokPunct && (t.s == "==" || t.s == "!=" || t.s == "<" || t.s == "<=" || t.s == ">" || t.s == ">="):
+			p.pos++
+			r, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return cmpNode{op: t.s, l: l, r: r}, nil
+		case t.typ == tokIdent && t.s == "in":
+			p.pos++
+			list, err := p.parseList()
+			if err != nil {
+				return nil, err
+			}
+			return inNode{x: l, list: list}, nil
+		}
+	}
+	return truthNode{l}, nil
+}
+
+func (p *parser) parseList() ([]interface{}, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	list := []interface{}{}
+	for !p.peek("]") {
+		if len(list) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		o, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		l, ok := o.(literal)
+		if !ok {
+			return nil, errors.New("list values must be literals")
+		}
+		list = append(list, l.v)
+	}
+	p.pos++
+	return list, nil
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	if p.pos >= len(p.toks) {
+		return nil, p.unexpected("operand")
+	}
+	t := p.toks[p.pos]
+	switch t.typ {
+	case tokString:
+		p.pos++
+		return literal{t.s}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t.s)
+		}
+		p.pos++
+		return literal{f}, nil
+	case tokIdent:
+		p.pos++
+		switch t.s {
+		case "null":
+			return literal{nil}, nil
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "in":
+			return nil, errors.New("unexpected \"in\"")
+		}
+		pth := path{t.s}
+		for p.peek(".") {
+			p.pos++
+			if p.pos >= len(p.toks) || (p.toks[p.pos].typ != tokIdent && p.toks[p.pos].typ != tokNumber) {
+				return nil, p.unexpected("field name")
+			}
+			pth = append(pth, p.toks[p.pos].s)
+			p.pos++
+		}
+		return pth, nil
+	}
+	return nil, p.unexpected("operand")
+}
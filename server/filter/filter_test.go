@@ -0,0 +1,70 @@
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	data := json.RawMessage(`{"idx":2,"value":{"data":{"type":"alert","tags":["a","b"],"level":3,"ok":true,"none":null}}}`)
+	tbl := []struct {
+		Expr     string
+		Expected bool
+	}{
+		{`idx == 2`, true},
+		{`idx != 2`, false},
+		{`idx < 3 && idx >= 2`, true},
+		{`idx > 2 || idx <= 1`, false},
+		{`value.data.type == "alert"`, true},
+		{`value.data.type < "b"`, true},
+		{`value.data.type > 1`, false},
+		{`value.data.tags.1 == "b"`, true},
+		{`value.data.tags.2 == null`, true},
+		{`value.data.missing == null`, true},
+		{`value.data.none == null`, true},
+		{`value.data.ok`, true},
+		{`value.data.level`, false},
+		{`!value.data.ok`, false},
+		{`!(idx == 1 || idx == 3)`, true},
+		{`value.data.type in ["warning", "alert"]`, true},
+		{`value.data.level in [1, 2]`, false},
+		{`value.data.level in []`, false},
+		{`value.data.level >= -1.5e0`, true},
+		{`idx == 2.0`, true},
+	}
+	for _, l := range tbl {
+		e, err := Parse(l.Expr)
+		if err != nil {
+			t.Errorf("expected %s to parse, but got error: %s", l.Expr, err)
+			continue
+		}
+		if got := e.Match(data); got != l.Expected {
+			t.Errorf("expected %s to return %v, but got %v", l.Expr, l.Expected, got)
+		}
+	}
+}
+
+func TestParse_InvalidExpression_ReturnsError(t *testing.T) {
+	tbl := []string{
+		``,
+		`idx ==`,
+		`idx == 1 &&`,
+		`(idx == 1`,
+		`idx == 1)`,
+		`idx = 1`,
+		`"foo`,
+		`idx in [value]`,
+		`idx in 1`,
+		`value.`,
+		`idx == 1 idx`,
+		`#`,
+		`in == 1`,
+		strings.Repeat("a", MaxLength+1),
+	}
+	for _, l := range tbl {
+		if _, err := Parse(l); err == nil {
+			t.Errorf("expected %q to return an error, but got none", l)
+		}
+	}
+}
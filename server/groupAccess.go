@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// GroupAccessConfig holds the configuration for sharing access responses
+// between the connections of the same token subject.
+type GroupAccessConfig struct {
+	// TokenClaim is the name of the token claim used as the subject to
+	// group by. Defaults to "sub".
+	TokenClaim string `json:"tokenClaim,omitempty"`
+	// TTL is the duration in milliseconds that a shared access response is
+	// reused. Defaults to 60000.
+	TTL int `json:"ttl,omitempty"`
+}
+
+// groupAccessSweepSize is the number of resource names in the group access
+// table at which expired entries are swept.
+const groupAccessSweepSize = 1024
+
+// groupAccessEntry holds the shared access response of a token subject for a
+// resource.
+type groupAccessEntry struct {
+	access  *rescache.Access
+	expires time.Time
+	cbs     []func(*rescache.Access)
+}
+
+// groupAccessTable holds the shared access responses by resource name, and by
+// token subject and query.
+type groupAccessTable struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	sweepAt int
+	names   map[string]map[string]*groupAccessEntry
+}
+
+func (c *GroupAccessConfig) prepare() error {
+	if c.TTL < 0 {
+		return errors.New("ttl must be a positive number of milliseconds")
+	}
+	if c.TTL == 0 {
+		c.TTL = 60000
+	}
+	if c.TokenClaim == "" {
+		c.TokenClaim = "sub"
+	}
+	return nil
+}
+
+// initGroupAccess creates the group access table, if configured.
+func (s *Service) initGroupAccess() {
+	if s.cfg.GroupAccess == nil {
+		return
+	}
+	s.groupAccess = &groupAccessTable{
+		ttl:     time.Duration(s.cfg.GroupAccess.TTL) * time.Millisecond,
+		sweepAt: groupAccessSweepSize,
+		names:   make(map[string]map[string]*groupAccessEntry),
+	}
+}
+
+// access calls cb with the shared access response of the subject for the
+// resource, or else calls load to get a new one. Concurrent calls for the
+// same subject and resource are made with a single load.
+func (t *groupAccessTable) access(subject, rname, query string, load func(func(*rescache.Access)), cb func(*rescache.Access)) {
+	key := subject + "\x00" + query
+	t.mu.Lock()
+	subs, ok := t.names[rname]
+	if !ok {
+		if len(t.names) >= t.sweepAt {
+			t.sweep()
+		}
+		subs = make(map[string]*groupAccessEntry)
+		t.names[rname] = subs
+	}
+	e, ok := subs[key]
+	if ok {
+		if e.access == nil {
+			e.cbs = append(e.cbs, cb)
+			t.mu.Unlock()
+			return
+		}
+		if time.Now().Before(e.expires) {
+			a := e.access
+			t.mu.Unlock()
+			cb(a)
+			return
+		}
+	}
+	e = &groupAccessEntry{cbs: []func(*rescache.Access){cb}}
+	subs[key] = e
+	t.mu.Unlock()
+
+	load(func(a *rescache.Access) {
+		t.mu.Lock()
+		cbs := e.cbs
+		e.cbs = nil
+		// Only share in case of an actual result or system.accessDenied
+		// error, and if not invalidated while loading.
+		if subs, ok := t.names[rname]; ok && subs[key] == e && (a.Error == nil || a.Error.Code == reserr.CodeAccessDenied) {
+			e.access = a
+			e.expires = time.Now().Add(t.ttl)
+		} else if ok && subs[key] == e {
+			delete(subs, key)
+		}
+		t.mu.Unlock()
+		for _, cb := range cbs {
+			cb(a)
+		}
+	})
+}
+
+// invalidate removes the shared access responses for the resource, with any
+// query. Pending loads will still call their callbacks, but are not shared.
+func (t *groupAccessTable) invalidate(rname string) {
+	t.mu.Lock()
+	delete(t.names, rname)
+	t.mu.Unlock()
+}
+
+// forget removes the shared access response of the subject for the resource.
+func (t *groupAccessTable) forget(subject, rname, query string) {
+	t.mu.Lock()
+	if subs, ok := t.names[rname]; ok {
+		delete(subs, subject+"\x00"+query)
+	}
+	t.mu.Unlock()
+}
+
+// sweep removes expired entries, and doubles the sweep size if at least half
+// of the resource names remain. It must be called with the mutex locked.
+func (t *groupAccessTable) sweep() {
+	now := time.Now()
+	for rname, subs := range t.names {
+		for key, e := range subs {
+			if e.access != nil && !now.Before(e.expires) {
+				delete(subs, key)
+			}
+		}
+		if len(subs) == 0 {
+			delete(t.names, rname)
+		}
+	}
+	if len(t.names) >= t.sweepAt/2 {
+		t.sweepAt *= 2
+	} else if t.sweepAt > groupAccessSweepSize && len(t.names) < t.sweepAt/4 {
+		t.sweepAt /= 2
+	}
+}
+
+// groupSubject returns the token subject used to share access responses, or
+// an empty string if access responses are not shared for the connection.
+func (c *wsConn) groupSubject() string {
+	if c.serv.groupAccess == nil || c.token == nil {
+		return ""
+	}
+	return tokenClaim(c.token, c.serv.cfg.GroupAccess.TokenClaim)
+}
+
+// forgetGroupAccess removes the access response for the subscription shared
+// under the token subject of the replaced token, so that the new token gets a
+// new access response.
+func (c *wsConn) forgetGroupAccess(old json.RawMessage, sub *Subscription) {
+	if c.serv.groupAccess == nil {
+		return
+	}
+	if subject := tokenClaim(old, c.serv.cfg.GroupAccess.TokenClaim); subject != "" {
+		c.serv.groupAccess.forget(subject, sub.ResourceName(), sub.ResourceQuery())
+	}
+}
+
+// handleAccessReset removes the shared access responses for a resource when
+// its access is reset by an access event or system reset.
+func (s *Service) handleAccessReset(rname string) {
+	if s.groupAccess != nil {
+		s.groupAccess.invalidate(rname)
+	}
+}
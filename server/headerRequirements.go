@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// HeaderRequirement holds a requirement on a header of the WebSocket
+// handshake request, such as an app version header or the User-Agent.
+type HeaderRequirement struct {
+	// Header is the name of the header. Eg. "X-App-Version"
+	Header string `json:"header"`
+	// Pattern is a regular expression that the header value must match. If
+	// empty, the header must be set with a non-empty value.
+	Pattern string `json:"pattern,omitempty"`
+	// Flag accepts connections not meeting the requirement, listing the
+	// header in the flags of auth requests instead of rejecting the
+	// handshake.
+	Flag bool `json:"flag,omitempty"`
+	// Tag is the name of a connection tag to set to the header value.
+	Tag string `json:"tag,omitempty"`
+}
+
+// headerRule is a prepared header requirement.
+type headerRule struct {
+	HeaderRequirement
+	pattern *regexp.Regexp
+}
+
+// prepare validates the header requirement, and compiles its pattern.
+func (r HeaderRequirement) prepare() (headerRule, error) {
+	hr := headerRule{HeaderRequirement: r}
+	if r.Header == "" {
+		return hr, errors.New("header must not be empty")
+	}
+	hr.Header = http.CanonicalHeaderKey(r.Header)
+	if r.Pattern != "" {
+		p, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return hr, fmt.Errorf("invalid pattern for %s: %s", hr.Header, err)
+		}
+		hr.pattern = p
+	}
+	return hr, nil
+}
+
+// matches reports whether the header value meets the requirement.
+func (r headerRule) matches(v string) bool {
+	if r.pattern == nil {
+		return v != ""
+	}
+	return r.pattern.MatchString(v)
+}
+
+// headerRequirementsError returns an error if the handshake request does not
+// meet a header requirement that is not flagged. Otherwise it returns the
+// connection tags set by the requirements, and the names of the flagged
+// headers not meeting their requirement.
+func (s *Service) headerRequirementsError(r *http.Request) (codec.Tags, []string, error) {
+	var tags codec.Tags
+	var flags []string
+	for _, hr := range s.cfg.headerRules {
+		v := r.Header.Get(hr.Header)
+		if !hr.matches(v) {
+			if !hr.Flag {
+				return nil, nil, &reserr.Error{Code: reserr.CodeForbidden, Message: "Missing or invalid header: " + hr.Header}
+			}
+			flags = append(flags, hr.Header)
+		}
+		if hr.Tag != "" && v != "" {
+			if tags == nil {
+				tags = make(codec.Tags)
+			}
+			v := v
+			tags[hr.Tag] = &v
+		}
+	}
+	return tags, flags, nil
+}
+
+// HeaderFlags returns the names of the headers of the handshake request not
+// meeting a flagged header requirement.
+func (c *wsConn) HeaderFlags() []string {
+	return c.headerFlags
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"net/http"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/rs/xid"
+)
+
+// HTTPErrorBody holds templates for the body of HTTP API error responses
+// with a specific error code.
+type HTTPErrorBody struct {
+	// JSON is a text/template used for the JSON encoded body.
+	JSON string `json:"json,omitempty"`
+	// HTML is a html/template used for the body of requests accepting
+	// text/html, such as from browsers.
+	HTML string `json:"html,omitempty"`
+}
+
+// httpErrorTemplate holds the parsed templates of a HTTPErrorBody.
+type httpErrorTemplate struct {
+	json *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// httpErrorData is the data passed to the HTTP error body templates.
+type httpErrorData struct {
+	Code          string
+	Message       string
+	Data          interface{}
+	Status        int
+	Path          string
+	CorrelationID string
+}
+
+var httpErrorFuncs = texttemplate.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+}
+
+// prepare parses the templates of the error body.
+func (b HTTPErrorBody) prepare() (*httpErrorTemplate, error) {
+	var t httpErrorTemplate
+	var err error
+	if b.JSON != "" {
+		t.json, err = texttemplate.New("json").Funcs(httpErrorFuncs).Parse(b.JSON)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b.HTML != "" {
+		t.html, err = htmltemplate.New("html").Funcs(htmltemplate.FuncMap(httpErrorFuncs)).Parse(b.HTML)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// writeHTTPErrorBody writes an error response using the httpErrorBodies
+// template for the error code, if one exists. Returns false if no template
+// was used.
+func (s *Service) writeHTTPErrorBody(w http.ResponseWriter, r *http.Request, status int, rerr *reserr.Error) bool {
+	t, ok := s.cfg.httpErrorBodies[rerr.Code]
+	if !ok {
+		return false
+	}
+
+	d := httpErrorData{
+		Code:    rerr.Code,
+		Message: rerr.Message,
+		Data:    rerr.Data,
+		Status:  status,
+		Path:    r.URL.Path,
+	}
+	if cd, ok := rerr.Data.(correlationData); ok {
+		d.CorrelationID = cd.CorrelationID
+	} else {
+		d.CorrelationID = xid.New().String()
+	}
+
+	var b bytes.Buffer
+	var contentType string
+	var err error
+	switch {
+	case t.html != nil && acceptsHTML(r):
+		contentType = "text/html; charset=utf-8"
+		err = t.html.Execute(&b, d)
+	case t.json != nil:
+		contentType = "application/json; charset=utf-8"
+		err = t.json.Execute(&b, d)
+	default:
+		return false
+	}
+	if err != nil {
+		s.Errorf("Error executing httpErrorBodies template for %s: %s", rerr.Code, err)
+		return false
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
+	return true
+}
+
+// acceptsHTML reports whether the request Accept header includes text/html.
+func acceptsHTML(r *http.Request) bool {
+	for _, v := range r.Header["Accept"] {
+		for _, t := range strings.Split(v, ",") {
+			if i := strings.IndexByte(t, ';'); i >= 0 {
+				t = t[:i]
+			}
+			if strings.TrimSpace(t) == "text/html" {
+				return true
+			}
+		}
+	}
+	return false
+}
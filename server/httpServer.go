@@ -2,8 +2,8 @@ package server
 
 import (
 	"context"
+	"net"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -17,30 +17,36 @@ func (s *Service) startHTTPServer() {
 		return
 	}
 
-	s.Logf("Listening on %s://%s", s.cfg.scheme, s.cfg.netAddr)
 	h := &http.Server{Addr: s.cfg.netAddr, Handler: s}
+	s.applyServerLimits(h)
 	s.h = h
+	s.startListeners(h)
 
-	go func() {
-		var err error
-		if s.cfg.TLS {
-			err = h.ListenAndServeTLS(s.cfg.TLSCert, s.cfg.TLSKey)
-		} else {
-			err = h.ListenAndServe()
-		}
+	if s.cfg.Socket != nil {
+		s.startSocketListener(h)
+		return
+	}
 
+	s.Logf("Listening on %s://%s", s.cfg.scheme, s.cfg.netAddr)
+	go s.serve(h, func() (net.Listener, error) {
+		ln, err := net.Listen("tcp", s.cfg.netAddr)
 		if err != nil {
-			s.Stop(err)
+			return nil, err
 		}
-	}()
+		return s.tuneListener("main", ln), nil
+	})
 }
 
 // stopHTTPServer stops the http server
+// The lock is not held while shutting down, to not block requests that
+// need it, such as probe requests, until the shutdown is done.
 func (s *Service) stopHTTPServer() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	h := s.h
+	s.h = nil
+	s.mu.Unlock()
 
-	if s.h == nil {
+	if h == nil {
 		return
 	}
 
@@ -49,8 +55,7 @@ func (s *Service) stopHTTPServer() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	s.h.Shutdown(ctx)
-	s.h = nil
+	h.Shutdown(ctx)
 
 	if ctx.Err() == context.DeadlineExceeded {
 		s.Errorf("HTTP server forcefully stopped after timeout")
@@ -68,12 +73,37 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == s.cfg.WSPath {
+		if s.applyWSLimits(w, r) {
+			s.wsHandler(w, r)
+		}
+		return
+	}
+	if h := s.probeHandler(r.URL.Path); h != nil {
+		h(w, r)
+		return
+	}
+	if !s.applyHTTPLimits(w, r) {
+		return
+	}
+	// Preflight requests are not limited, to not hide the 429 response of
+	// the actual request from the browser.
+	if r.Method != "OPTIONS" && !s.rateLimitHTTP(w, r) {
+		return
+	}
+
 	switch {
-	case r.URL.Path == s.cfg.WSPath:
-		s.wsHandler(w, r)
-	case strings.HasPrefix(r.URL.Path, s.cfg.APIPath):
+	case r.URL.Path == WellKnownPath:
+		s.wellKnownHandler(w, r)
+	case s.isBulkCallPath(r.URL.Path):
+		s.bulkCallHandler(w, r)
+	case s.isSSEPath(r.URL.Path):
+		s.sseHandler(w, r)
+	case s.isLongPollPath(r.URL.Path):
+		s.longPollHandler(w, r)
+	case s.cfg.matchAPIMount(r.URL.Path) != nil:
 		s.apiHandler(w, r)
 	default:
-		notFoundHandler(w, r, s.enc)
+		s.notFoundHandler(w, r, s.enc)
 	}
 }
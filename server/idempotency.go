@@ -0,0 +1,257 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jirenius/timerqueue"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func (s *Service) initIdempotencyCache() {
+	if s.cfg.IdempotencyWindow > 0 {
+		s.idem = newIdempotencyCache(time.Duration(s.cfg.IdempotencyWindow) * time.Millisecond)
+		if s.redis != nil {
+			s.idem.shared = s.redis
+			s.idem.logger = s
+		}
+	}
+}
+
+// errIdempotencyKeyReused is the error for a request reusing an idempotency
+// key with different parameters.
+var errIdempotencyKeyReused = &reserr.Error{Code: reserr.CodeInvalidParams, Message: "Idempotency key reused with different parameters"}
+
+// stopIdempotencyCache clears all stored responses.
+func (s *Service) stopIdempotencyCache() {
+	if s.idem != nil {
+		s.idem.clear()
+	}
+}
+
+// idempotencyCache stores call responses by idempotency key, so that
+// retried requests within the window get the original response instead
+// of being passed to the service again.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	tq      *timerqueue.Queue
+	window  time.Duration
+	shared  sharedStore
+	logger  interface{ Errorf(string, ...interface{}) }
+}
+
+// sharedStore is a key-value store shared by the gateway fleet, such as
+// Redis.
+type sharedStore interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+	Del(key string) error
+}
+
+// sharedResponse is a response stored in the shared store. A response that
+// is not done is a marker for a request in progress on another instance.
+type sharedResponse struct {
+	Done   bool            `json:"done"`
+	Params string          `json:"params"`
+	Result json.RawMessage `json:"result,omitempty"`
+	RID    string          `json:"rid,omitempty"`
+	Error  *reserr.Error   `json:"error,omitempty"`
+}
+
+type idempotencyEntry struct {
+	key    string
+	params string
+	done   bool
+	result json.RawMessage
+	refRID string
+	err    error
+	cbs    []func(result json.RawMessage, refRID string, err error)
+}
+
+func newIdempotencyCache(window time.Duration) *idempotencyCache {
+	ic := &idempotencyCache{
+		entries: make(map[string]*idempotencyEntry),
+		window:  window,
+	}
+	ic.tq = timerqueue.New(ic.evict, window)
+	return ic
+}
+
+// idempotencyKey returns the key to store the response of a call to a
+// method by, scoped to the token of the caller. Callers without a token share
+// the same scope.
+func idempotencyKey(token json.RawMessage, method, key string) string {
+	var scope string
+	if token != nil {
+		sum := sha256.Sum256(token)
+		scope = hex.EncodeToString(sum[:16])
+	}
+	return method + ":" + scope + ":" + key
+}
+
+// idempotencyParams returns a hash of the call parameters, to compare with
+// the parameters of the request that stored a response.
+func idempotencyParams(params interface{}) string {
+	b, _ := json.Marshal(params)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
+
+// do calls f unless a response for the key is already stored, or a request
+// with the same key is in progress. The callback will be called with the
+// response from the first request made with the key, or with
+// errIdempotencyKeyReused if that request had different parameters.
+func (ic *idempotencyCache) do(key, params string, f func(cb func(result json.RawMessage, refRID string, err error)), cb func(result json.RawMessage, refRID string, err error)) {
+	ic.mu.Lock()
+	e, ok := ic.entries[key]
+	if ok {
+		if e.params != params {
+			ic.mu.Unlock()
+			cb(nil, "", errIdempotencyKeyReused)
+			return
+		}
+		if e.done {
+			ic.mu.Unlock()
+			cb(e.result, e.refRID, e.err)
+			return
+		}
+		e.cbs = append(e.cbs, cb)
+		ic.mu.Unlock()
+		return
+	}
+	e = &idempotencyEntry{key: key, params: params, cbs: []func(json.RawMessage, string, error){cb}}
+	ic.entries[key] = e
+	ic.mu.Unlock()
+
+	if ic.shared != nil {
+		go ic.doShared(e, f)
+		return
+	}
+	f(func(result json.RawMessage, refRID string, err error) {
+		ic.complete(e, result, refRID, err)
+	})
+}
+
+// doShared checks the shared store for a response from any gateway instance
+// before calling f, and stores the response of f for the other instances.
+// If the shared store is unavailable, f is called without it.
+func (ic *idempotencyCache) doShared(e *idempotencyEntry, f func(cb func(result json.RawMessage, refRID string, err error))) {
+	skey := SharedIdempotencyPrefix + e.key
+	pending, _ := json.Marshal(sharedResponse{Params: e.params})
+	deadline := time.Now().Add(ic.window)
+	for {
+		ok, err := ic.shared.SetNX(skey, pending, ic.window)
+		if err != nil {
+			ic.logger.Errorf("Error storing idempotency key in shared store: %s", err)
+			break
+		}
+		if ok {
+			f(func(result json.RawMessage, refRID string, err error) {
+				go ic.storeShared(skey, e.params, result, refRID, err)
+				ic.complete(e, result, refRID, err)
+			})
+			return
+		}
+
+		data, err := ic.shared.Get(skey)
+		if err != nil {
+			ic.logger.Errorf("Error getting idempotency key from shared store: %s", err)
+			break
+		}
+		var sr sharedResponse
+		if data != nil {
+			if err := json.Unmarshal(data, &sr); err != nil {
+				ic.logger.Errorf("Error decoding shared idempotency response: %s", err)
+				break
+			}
+		}
+		if data != nil && sr.Params != e.params {
+			ic.complete(e, nil, "", errIdempotencyKeyReused)
+			return
+		}
+		if sr.Done {
+			var err error
+			if sr.Error != nil {
+				err = sr.Error
+			}
+			ic.complete(e, sr.Result, sr.RID, err)
+			return
+		}
+		// Request in progress on another instance
+		if time.Now().After(deadline) {
+			ic.complete(e, nil, "", reserr.ErrTimeout)
+			return
+		}
+		time.Sleep(IdempotencyPollInterval)
+	}
+
+	f(func(result json.RawMessage, refRID string, err error) {
+		ic.complete(e, result, refRID, err)
+	})
+}
+
+// storeShared stores a response in the shared store, or removes the
+// in-progress marker on timeout.
+func (ic *idempotencyCache) storeShared(skey, params string, result json.RawMessage, refRID string, err error) {
+	if reserr.IsError(err, reserr.CodeTimeout) {
+		if err := ic.shared.Del(skey); err != nil {
+			ic.logger.Errorf("Error removing idempotency key from shared store: %s", err)
+		}
+		return
+	}
+	sr := sharedResponse{Done: true, Params: params, Result: result, RID: refRID}
+	if err != nil {
+		sr.Error = reserr.RESError(err)
+	}
+	data, _ := json.Marshal(sr)
+	if err := ic.shared.Set(skey, data, ic.window); err != nil {
+		ic.logger.Errorf("Error storing idempotency response in shared store: %s", err)
+	}
+}
+
+// complete stores the response for the entry, unless it is a timeout or a
+// reused key, and calls the callbacks waiting for it.
+func (ic *idempotencyCache) complete(e *idempotencyEntry, result json.RawMessage, refRID string, err error) {
+	ic.mu.Lock()
+	cbs := e.cbs
+	e.cbs = nil
+	// Timeouts are not stored, allowing a retry to reach the service.
+	if reserr.IsError(err, reserr.CodeTimeout) || err == errIdempotencyKeyReused {
+		if ic.entries[e.key] == e {
+			delete(ic.entries, e.key)
+		}
+	} else {
+		e.done = true
+		e.result = result
+		e.refRID = refRID
+		e.err = err
+		ic.tq.Add(e)
+	}
+	ic.mu.Unlock()
+
+	for _, cb := range cbs {
+		cb(result, refRID, err)
+	}
+}
+
+func (ic *idempotencyCache) evict(v interface{}) {
+	e := v.(*idempotencyEntry)
+	ic.mu.Lock()
+	if ic.entries[e.key] == e {
+		delete(ic.entries, e.key)
+	}
+	ic.mu.Unlock()
+}
+
+// clear removes all stored responses.
+func (ic *idempotencyCache) clear() {
+	ic.mu.Lock()
+	ic.tq.Clear()
+	ic.entries = make(map[string]*idempotencyEntry)
+	ic.mu.Unlock()
+}
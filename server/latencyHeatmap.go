@@ -0,0 +1,329 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Default latency heatmap settings
+const (
+	LatencyHeatmapInterval = 60000
+	LatencyHeatmapSlots    = 60
+)
+
+// LatencyHeatmapBuckets are the default upper bounds, in milliseconds, of the
+// latency buckets.
+var LatencyHeatmapBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// LatencyHeatmapConfig holds the configuration for recording the latency of
+// service requests in histograms per request type and resource pattern,
+// available as a heatmap on the admin endpoint.
+type LatencyHeatmapConfig struct {
+	// Patterns are the resource patterns to keep a histogram for. A request
+	// is recorded for the first pattern matching its resource. Defaults to
+	// [">"].
+	Patterns []string `json:"patterns,omitempty"`
+	// Buckets are the ascending upper bounds of the latency buckets, in
+	// milliseconds. Latencies above the last bound are counted in an
+	// overflow bucket.
+	Buckets []float64 `json:"buckets,omitempty"`
+	// Interval is the duration of each time slot, in milliseconds.
+	// Defaults to 60000.
+	Interval int `json:"interval,omitempty"`
+	// Slots is the number of time slots kept. Defaults to 60.
+	Slots int `json:"slots,omitempty"`
+
+	patterns []rescache.ResourcePattern
+}
+
+// latencyHeatmap holds the latency histograms, each divided into a ring of
+// time slots.
+type latencyHeatmap struct {
+	LatencyHeatmapConfig
+	mu    sync.Mutex
+	hists map[string]*latencyHistogram
+}
+
+type latencyHistogram struct {
+	typ     string
+	pattern string
+	slots   []latencySlot
+}
+
+type latencySlot struct {
+	n      int64
+	counts []int64
+}
+
+// latencyClient is a mq.Client recording the latency of requests, from sent
+// until the response callback is called.
+type latencyClient struct {
+	mqWrapper
+	h *latencyHeatmap
+}
+
+type latencyHeatmapStats struct {
+	Interval   int                     `json:"interval"`
+	Buckets    []float64               `json:"buckets"`
+	Start      int64                   `json:"start"`
+	Histograms []latencyHistogramStats `json:"histograms"`
+}
+
+type latencyHistogramStats struct {
+	Type    string    `json:"type"`
+	Pattern string    `json:"pattern"`
+	Count   int64     `json:"count"`
+	Totals  []int64   `json:"totals"`
+	Slots   [][]int64 `json:"slots"`
+}
+
+// prepare validates the latency heatmap configuration, sets default values,
+// and parses the patterns.
+func (c *LatencyHeatmapConfig) prepare() error {
+	if c.Interval < 0 || c.Slots < 0 {
+		return errors.New("interval and slots must be zero or a positive number")
+	}
+	if c.Interval == 0 {
+		c.Interval = LatencyHeatmapInterval
+	}
+	if c.Slots == 0 {
+		c.Slots = LatencyHeatmapSlots
+	}
+	if len(c.Buckets) == 0 {
+		c.Buckets = LatencyHeatmapBuckets
+	}
+	for i, b := range c.Buckets {
+		if b <= 0 || (i > 0 && b <= c.Buckets[i-1]) {
+			return errors.New("buckets must be positive and in ascending order")
+		}
+	}
+	if len(c.Patterns) == 0 {
+		c.Patterns = []string{">"}
+	}
+	c.patterns = make([]rescache.ResourcePattern, len(c.Patterns))
+	for i, pattern := range c.Patterns {
+		p := rescache.ParseResourcePattern(pattern)
+		if !p.IsValid() {
+			return fmt.Errorf("pattern %q must be a valid resource pattern", pattern)
+		}
+		c.patterns[i] = p
+	}
+	return nil
+}
+
+// initLatencyHeatmap wraps the messaging client to record request latencies,
+// if the latency heatmap is configured.
+func (s *Service) initLatencyHeatmap() {
+	if s.cfg.LatencyHeatmap == nil {
+		return
+	}
+	s.latency = &latencyHeatmap{
+		LatencyHeatmapConfig: *s.cfg.LatencyHeatmap,
+		hists:                make(map[string]*latencyHistogram),
+	}
+	s.mq = &latencyClient{mqWrapper: mqWrapper{s.mq}, h: s.latency}
+}
+
+// SendRequest sends the request, and records the time until the response
+// callback is called.
+func (c *latencyClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	start := time.Now()
+	c.Client.SendRequest(subj, payload, func(rsubj string, data []byte, err error) {
+		c.h.record(subj, time.Since(start))
+		cb(rsubj, data, err)
+	})
+}
+
+// record adds the latency of a request to the histogram of the request
+// type and the first pattern matching the resource of the subject.
+func (h *latencyHeatmap) record(subj string, d time.Duration) {
+	idx := strings.IndexByte(subj, '.')
+	if idx < 0 {
+		return
+	}
+	pi := -1
+	matchSubject(subj, func(rid string) bool {
+		for i, p := range h.patterns {
+			if p.Match(rid) {
+				pi = i
+				return true
+			}
+		}
+		return false
+	})
+	if pi < 0 {
+		return
+	}
+	typ := subj[:idx]
+	key := typ + " " + h.Patterns[pi]
+	bucket := sort.SearchFloat64s(h.Buckets, float64(d)/float64(time.Millisecond))
+	n := h.slot(time.Now())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.hists[key]
+	if !ok {
+		hist = &latencyHistogram{typ: typ, pattern: h.Patterns[pi], slots: make([]latencySlot, h.Slots)}
+		h.hists[key] = hist
+	}
+	sl := &hist.slots[n%int64(h.Slots)]
+	if sl.n != n || sl.counts == nil {
+		sl.n = n
+		sl.counts = make([]int64, len(h.Buckets)+1)
+	}
+	sl.counts[bucket]++
+}
+
+// slot returns the number of the time slot for t.
+func (h *latencyHeatmap) slot(t time.Time) int64 {
+	return t.UnixNano() / (int64(h.Interval) * int64(time.Millisecond))
+}
+
+// stats returns the histograms, sorted by type and pattern, with the counts
+// of the kept time slots, oldest first.
+func (h *latencyHeatmap) stats() latencyHeatmapStats {
+	last := h.slot(time.Now())
+	first := last - int64(h.Slots) + 1
+	st := latencyHeatmapStats{
+		Interval:   h.Interval,
+		Buckets:    h.Buckets,
+		Start:      first * int64(h.Interval),
+		Histograms: []latencyHistogramStats{},
+	}
+
+	h.mu.Lock()
+	for _, hist := range h.hists {
+		hs := latencyHistogramStats{
+			Type:    hist.typ,
+			Pattern: hist.pattern,
+			Totals:  make([]int64, len(h.Buckets)+1),
+			Slots:   make([][]int64, h.Slots),
+		}
+		for n := first; n <= last; n++ {
+			counts := make([]int64, len(h.Buckets)+1)
+			if sl := hist.slots[n%int64(h.Slots)]; sl.n == n && sl.counts != nil {
+				copy(counts, sl.counts)
+			}
+			for i, v := range counts {
+				hs.Totals[i] += v
+				hs.Count += v
+			}
+			hs.Slots[n-first] = counts
+		}
+		st.Histograms = append(st.Histograms, hs)
+	}
+	h.mu.Unlock()
+
+	sort.Slice(st.Histograms, func(i, j int) bool {
+		a, b := st.Histograms[i], st.Histograms[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Pattern < b.Pattern
+	})
+	return st
+}
+
+// adminLatencyHandler returns the latency histograms. The response is an HTML
+// heatmap if the format query parameter is html, or if the request accepts
+// text/html, such as when opened in a browser. Otherwise it is JSON.
+func (s *Service) adminLatencyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	if s.latency == nil {
+		adminError(w, http.StatusNotFound, &reserr.Error{Code: reserr.CodeNotFound, Message: "Latency heatmap not enabled"})
+		return
+	}
+	st := s.latency.stats()
+	format := r.URL.Query().Get("format")
+	if format == "html" || (format == "" && strings.Contains(r.Header.Get("Accept"), "text/html")) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := latencyHeatmapTemplate.Execute(w, st); err != nil {
+			s.Errorf("Error writing latency heatmap: %s", err)
+		}
+		return
+	}
+	adminResponse(w, st)
+}
+
+// heatmapRow is a bucket row of an HTML heatmap.
+type heatmapRow struct {
+	Label string
+	Cells []heatmapCell
+}
+
+type heatmapCell struct {
+	Count int64
+	Alpha string
+}
+
+// Rows returns the bucket rows of the heatmap, highest latency first.
+func (hs latencyHistogramStats) Rows(buckets []float64) []heatmapRow {
+	var max int64
+	for _, counts := range hs.Slots {
+		for _, v := range counts {
+			if v > max {
+				max = v
+			}
+		}
+	}
+	rows := make([]heatmapRow, 0, len(buckets)+1)
+	for i := len(buckets); i >= 0; i-- {
+		label := fmt.Sprintf("> %g ms", buckets[len(buckets)-1])
+		if i < len(buckets) {
+			label = fmt.Sprintf("≤ %g ms", buckets[i])
+		}
+		row := heatmapRow{Label: label, Cells: make([]heatmapCell, len(hs.Slots))}
+		for j, counts := range hs.Slots {
+			c := heatmapCell{Count: counts[i], Alpha: "0"}
+			if max > 0 && c.Count > 0 {
+				c.Alpha = fmt.Sprintf("%.2f", 0.15+0.85*float64(c.Count)/float64(max))
+			}
+			row.Cells[j] = c
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+var latencyHeatmapTemplate = template.Must(template.New("latency").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Resgate latency</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th { font-weight: normal; text-align: right; padding-right: 6px; white-space: nowrap; }
+td { width: 12px; height: 14px; border: 1px solid #eee; }
+</style>
+</head>
+<body>
+<h1>Service request latency</h1>
+<p>One column per {{.Interval}} ms, oldest first.</p>
+{{- $buckets := .Buckets}}
+{{- range .Histograms}}
+<h2>{{.Type}} {{.Pattern}} ({{.Count}})</h2>
+<table>
+{{- range .Rows $buckets}}
+<tr><th>{{.Label}}</th>{{range .Cells}}<td style="background: rgba(200, 40, 40, {{.Alpha}})" title="{{.Count}}"></td>{{end}}</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No requests recorded.</p>
+{{- end}}
+</body>
+</html>
+`))
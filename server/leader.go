@@ -0,0 +1,50 @@
+package server
+
+import "time"
+
+// leaderID returns the ID of the elected leader among this instance and the
+// discovered peers, or an empty string if leader election is disabled, or if
+// the instance has not yet been running for an announce interval.
+//
+// The instance with the lowest ID is elected. As instance IDs are sortable by
+// creation time, leadership stays with the oldest running instance, and fails
+// over once the leader stops or its announcements expire.
+func (s *Service) leaderID() string {
+	if !s.cfg.LeaderElection || s.started.IsZero() {
+		return ""
+	}
+	// Wait an interval to receive announcements from running peers before
+	// claiming leadership.
+	if time.Since(s.started) < time.Duration(s.cfg.AnnounceInterval)*time.Millisecond {
+		return ""
+	}
+	id := s.id
+	for _, p := range s.peers.list() {
+		if p.ID < id {
+			id = p.ID
+		}
+	}
+	return id
+}
+
+// IsLeader reports whether the instance is the elected leader of the fleet,
+// responsible for singleton duties. Always false when leader election is
+// disabled.
+func (s *Service) IsLeader() bool {
+	return s.leaderID() == s.id
+}
+
+// updateLeadership logs changes in leadership. It is called by the announce
+// goroutine only.
+func (s *Service) updateLeadership() {
+	leader := s.IsLeader()
+	if leader == s.leader {
+		return
+	}
+	s.leader = leader
+	if leader {
+		s.Logf("Elected leader")
+	} else {
+		s.Logf("Leadership lost to %s", s.leaderID())
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// hasLinkHeaders reports whether HTTP GET responses for the resource should
+// include Link headers for its referenced resources.
+func (s *Service) hasLinkHeaders(rid string) bool {
+	for _, p := range s.cfg.linkHeaders {
+		if p.Match(rid) {
+			return true
+		}
+	}
+	return false
+}
+
+// setLinkHeaders adds a Link header with the related relation type for each
+// resource referenced by the subscription, directly or through other
+// references, allowing HTTP clients to prefetch them. Resources with errors
+// are left out.
+func (s *Service) setLinkHeaders(w http.ResponseWriter, sub *Subscription) {
+	seen := map[string]bool{sub.RID(): true}
+	var add func(sub *Subscription)
+	add = func(sub *Subscription) {
+		var refs []string
+		switch sub.ResourceType() {
+		case rescache.TypeModel:
+			vals := sub.ModelValues()
+			keys := make([]string, 0, len(vals))
+			for k := range vals {
+				keys = append(keys, k)
+			}
+			// Sort by property name for a consistent order
+			sort.Strings(keys)
+			for _, k := range keys {
+				if v := vals[k]; v.Type == codec.ValueTypeResource {
+					refs = append(refs, v.RID)
+				}
+			}
+		case rescache.TypeCollection:
+			for _, v := range sub.CollectionValues() {
+				if v.Type == codec.ValueTypeResource {
+					refs = append(refs, v.RID)
+				}
+			}
+		}
+		for _, rid := range refs {
+			if seen[rid] {
+				continue
+			}
+			seen[rid] = true
+			ref := sub.Ref(rid)
+			if ref == nil || ref.Error() != nil {
+				continue
+			}
+			w.Header().Add("Link", "<"+s.cfg.ResourcePath(rid)+`>; rel="related"`)
+			add(ref)
+		}
+	}
+	add(sub)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// listenAddr is an additional network address for the HTTP server to listen
+// on.
+type listenAddr struct {
+	name    string
+	network string
+	addr    string
+}
+
+// parseListenAddr parses an address in the form of <host>:<port>. An empty
+// host listens on all interfaces for both IPv4 and IPv6, while an IPv4 or IPv6
+// host, including the 0.0.0.0 and [::] wildcards, only listens for that
+// family.
+func parseListenAddr(s string) (listenAddr, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return listenAddr{}, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return listenAddr{}, fmt.Errorf("port %q must be a number between 1 and 65535", port)
+	}
+	if host == "" {
+		return listenAddr{network: "tcp", addr: ":" + port}, nil
+	}
+	h, err := resolveHost(&host, "")
+	if err != nil {
+		return listenAddr{}, err
+	}
+	network := "tcp4"
+	if h[0] == '[' {
+		network = "tcp6"
+	}
+	return listenAddr{network: network, addr: h + ":" + port}, nil
+}
+
+// startListeners listens on the additional addresses, and starts a goroutine
+// serving the HTTP server on each.
+// Service.mu is held when called
+func (s *Service) startListeners(h *http.Server) {
+	for _, la := range s.cfg.listenAddrs {
+		la := la
+		s.Logf("Listening on %s://%s", s.cfg.scheme, la.addr)
+		go s.serve(h, func() (net.Listener, error) {
+			ln, err := net.Listen(la.network, la.addr)
+			if err != nil {
+				return nil, err
+			}
+			return s.tuneListener(la.name, ln), nil
+		})
+	}
+}
+
+// startSocketListener listens on the Unix domain socket, and starts a
+// goroutine serving the HTTP server on it.
+// Service.mu is held when called
+func (s *Service) startSocketListener(h *http.Server) {
+	path := *s.cfg.Socket
+	s.Logf("Listening on %s+unix://%s", s.cfg.scheme, path)
+	go s.serve(h, func() (net.Listener, error) {
+		// Remove any stale socket left by a previous process
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if s.cfg.socketMode != 0 {
+			if err := os.Chmod(path, s.cfg.socketMode); err != nil {
+				ln.Close()
+				return nil, err
+			}
+		}
+		return s.tuneListener("socket", ln), nil
+	})
+}
+
+// serve serves the HTTP server on the listener returned by listen, stopping
+// the service on error.
+func (s *Service) serve(h *http.Server, listen func() (net.Listener, error)) {
+	ln, err := listen()
+	if err == nil {
+		if s.cfg.TLS {
+			err = h.ServeTLS(ln, s.cfg.TLSCert, s.cfg.TLSKey)
+		} else {
+			err = h.Serve(ln)
+		}
+	}
+	if err != nil {
+		s.Stop(err)
+	}
+}
+
+// parseSocketMode parses the file permissions of the Unix domain socket, in
+// octal notation.
+func parseSocketMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m == 0 || m > 0777 {
+		return 0, errors.New("must be octal file permissions, such as 0660")
+	}
+	return os.FileMode(m), nil
+}
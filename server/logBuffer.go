@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// logLevels maps log buffer levels to their order.
+var logLevels = map[string]int{"ERR": 0, "INF": 1, "DBG": 2, "TRC": 3}
+
+// logBufferLevels maps the logBufferLevel setting values to entry levels.
+var logBufferLevels = map[string]string{"error": "ERR", "info": "INF", "debug": "DBG", "trace": "TRC"}
+
+// initLogBuffer wraps the logger in a ring logger if the log buffer or
+// diagnostics bundles are enabled. Returns the logger to use.
+func (s *Service) initLogBuffer(l logger.Logger) logger.Logger {
+	size := s.cfg.LogBufferSize
+	if size == 0 && s.cfg.DiagnosticsPath != nil {
+		size = DiagnosticsLogSize
+	}
+	if size == 0 {
+		s.ring = nil
+		return l
+	}
+	lvl := logLevels[logBufferLevels[s.cfg.LogBufferLevel]]
+	s.ring = logger.NewRingLogger(l, size, lvl >= logLevels["DBG"], lvl >= logLevels["TRC"])
+	return s.ring
+}
+
+// adminLogsHandler returns the recent log entries kept in the log buffer.
+// The entries may be filtered by the level query parameter, and limited to
+// the most recent by the limit query parameter.
+func (s *Service) adminLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	if s.ring == nil {
+		adminError(w, http.StatusNotFound, &reserr.Error{Code: reserr.CodeNotFound, Message: "Log buffer not enabled"})
+		return
+	}
+	q := r.URL.Query()
+	max := logLevels["TRC"]
+	if v := q.Get("level"); v != "" {
+		lvl, ok := logBufferLevels[v]
+		if !ok {
+			adminError(w, http.StatusBadRequest, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Level must be error, info, debug, or trace"})
+			return
+		}
+		max = logLevels[lvl]
+	}
+	limit := -1
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			adminError(w, http.StatusBadRequest, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Limit must be zero or a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	entries := []logger.Entry{}
+	for _, e := range s.ring.Entries() {
+		if logLevels[e.Level] <= max {
+			entries = append(entries, e)
+		}
+	}
+	if limit >= 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	adminResponse(w, struct {
+		Entries []logger.Entry `json:"entries"`
+	}{entries})
+}
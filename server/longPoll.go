@@ -0,0 +1,407 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+const (
+	// LongPollPath is the default path prefix of the long-polling endpoint.
+	LongPollPath = "/poll/"
+	// LongPollTimeout is the default time in milliseconds a poll request
+	// waits for messages.
+	LongPollTimeout = 25000
+	// LongPollSessionTimeout is the default time in milliseconds a session
+	// is kept without any poll request.
+	LongPollSessionTimeout = 60000
+	// LongPollMaxQueue is the default number of messages queued for a
+	// session before it is closed as a slow consumer.
+	LongPollMaxQueue = 1000
+)
+
+// LongPollConfig holds the configuration for connecting over HTTP
+// long-polling, a fallback for clients unable to use WebSocket.
+//
+// A session is created with a POST request to the path, responding with the
+// session ID:
+//
+//	{"sid":"5f1c..."}
+//
+// RES client requests are sent with a POST request to the path followed by
+// the session ID, with a body holding a single request, or an array of
+// requests. Responses and events are returned, in order, by GET requests to
+// the same path, as an array of messages in the same format as over
+// WebSocket. A poll request responds once messages are available, or with an
+// empty array after the timeout. A DELETE request closes the session.
+//
+// The session is a connection like any WebSocket connection, with a
+// connection ID, token, and subscriptions.
+type LongPollConfig struct {
+	// Path is the path prefix of the endpoint. Defaults to "/poll/".
+	Path string `json:"path,omitempty"`
+	// Timeout is the time in milliseconds a poll request waits for
+	// messages. Defaults to 25000.
+	Timeout int `json:"timeout,omitempty"`
+	// SessionTimeout is the time in milliseconds a session is kept without
+	// any poll request before it is closed. Defaults to 60000.
+	SessionTimeout int `json:"sessionTimeout,omitempty"`
+	// MaxQueue is the number of messages queued for a session before it is
+	// closed as a slow consumer. Defaults to 1000.
+	MaxQueue int `json:"maxQueue,omitempty"`
+}
+
+// longPollSession queues the messages of a connection until polled by the
+// client.
+type longPollSession struct {
+	sid    string
+	c      *wsConn
+	cfg    *LongPollConfig
+	mu     sync.Mutex
+	msgs   []json.RawMessage
+	wait   chan struct{} // Closed when a waiting poll should respond
+	polls  int           // Number of ongoing poll requests
+	expiry *time.Timer
+	closed bool
+}
+
+var errLongPollSessionNotFound = &reserr.Error{Code: reserr.CodeNotFound, Message: "Session not found"}
+
+// prepare validates the long-polling configuration and sets default values.
+func (c *LongPollConfig) prepare(apiPath, wsPath string) error {
+	if c.Path == "" {
+		c.Path = LongPollPath
+	}
+	if c.Path[0] != '/' || c.Path[len(c.Path)-1] != '/' {
+		return errors.New("path must start and end with a slash (/)")
+	}
+	apiPath = strings.TrimSuffix(apiPath, "/") + "/"
+	if strings.HasPrefix(wsPath, c.Path) || strings.HasPrefix(c.Path, apiPath) || strings.HasPrefix(apiPath, c.Path) {
+		return fmt.Errorf("path %q must not overlap the wsPath or the apiPath", c.Path)
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must be zero or a positive number of milliseconds")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = LongPollTimeout
+	}
+	if c.SessionTimeout < 0 {
+		return errors.New("sessionTimeout must be zero or a positive number of milliseconds")
+	}
+	if c.SessionTimeout == 0 {
+		c.SessionTimeout = LongPollSessionTimeout
+	}
+	if c.MaxQueue < 0 {
+		return errors.New("maxQueue must be zero or a positive number")
+	}
+	if c.MaxQueue == 0 {
+		c.MaxQueue = LongPollMaxQueue
+	}
+	return nil
+}
+
+// isLongPollPath reports whether the path is within the long-polling
+// endpoint.
+func (s *Service) isLongPollPath(path string) bool {
+	return s.cfg.LongPoll != nil && strings.HasPrefix(path, s.cfg.LongPoll.Path)
+}
+
+// persistent reports whether the connection is a client connection, over
+// WebSocket or long-polling, rather than a connection for a single HTTP
+// request.
+func (c *wsConn) persistent() bool {
+	return c.ws != nil || c.lp != nil
+}
+
+// longPollHandler handles requests to create, poll, send to, and close
+// long-polling sessions.
+func (s *Service) longPollHandler(w http.ResponseWriter, r *http.Request) {
+	enc := s.encoder(r)
+	err := s.setCommonHeaders(w, r)
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		return
+	}
+	if err != nil {
+		s.httpError(w, r, err, enc)
+		return
+	}
+
+	sid := r.URL.Path[len(s.cfg.LongPoll.Path):]
+	if sid == "" {
+		if r.Method != "POST" {
+			s.httpError(w, r, reserr.ErrMethodNotAllowed, enc)
+			return
+		}
+		s.longPollCreate(w, r)
+		return
+	}
+
+	ls := s.longPollSession(sid)
+	if ls == nil {
+		s.httpError(w, r, errLongPollSessionNotFound, enc)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		msgs, ok := ls.poll(r)
+		if !ok {
+			s.httpError(w, r, errLongPollSessionNotFound, enc)
+			return
+		}
+		if msgs == nil {
+			msgs = []json.RawMessage{}
+		}
+		out, _ := json.Marshal(msgs)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(out)
+	case "POST":
+		if err := ls.receive(r); err != nil {
+			s.httpError(w, r, err, enc)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		ls.c.Disconnect("closed by client")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.httpError(w, r, reserr.ErrMethodNotAllowed, enc)
+	}
+}
+
+// longPollCreate creates a new long-polling session, applying the same
+// checks as for a WebSocket connection.
+func (s *Service) longPollCreate(w http.ResponseWriter, r *http.Request) {
+	enc := s.encoder(r)
+	if err := s.maintenanceConnError(); err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+	if err := s.slowStartConnError(); err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+	if err := s.quarantineConnError(r); err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+	if err := s.clientVersionError(r); err != nil {
+		s.rejectUpgradeRequired(w, r, err)
+		return
+	}
+	tags, flags, err := s.headerRequirementsError(r)
+	if err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		s.httpError(w, r, reserr.RESError(err), enc)
+		return
+	}
+	cfg := s.cfg.LongPoll
+	ls := &longPollSession{sid: hex.EncodeToString(b), cfg: cfg}
+
+	conn := s.newWSConn(nil, r, codec.LegacyProtocol)
+	if conn == nil {
+		s.httpError(w, r, reserr.ErrServiceUnavailable, enc)
+		return
+	}
+	ls.c = conn
+	conn.lp = ls
+	ls.expiry = time.AfterFunc(time.Duration(cfg.SessionTimeout)*time.Millisecond, ls.expire)
+	conn.Enqueue(func() {
+		conn.scheduleReaccess()
+		if tags != nil || flags != nil {
+			conn.setTags(tags)
+			conn.headerFlags = flags
+		}
+	})
+
+	s.lpMu.Lock()
+	if s.lpSessions == nil {
+		s.lpSessions = make(map[string]*longPollSession)
+	}
+	s.lpSessions[ls.sid] = ls
+	s.lpMu.Unlock()
+
+	conn.Tracef("Connected over long-polling: %s", r.RemoteAddr)
+
+	out, _ := json.Marshal(struct {
+		SID string `json:"sid"`
+	}{ls.sid})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(out)
+}
+
+// longPollSession returns the session with the ID, or nil if not found.
+func (s *Service) longPollSession(sid string) *longPollSession {
+	s.lpMu.Lock()
+	defer s.lpMu.Unlock()
+	return s.lpSessions[sid]
+}
+
+// receive reads the RES client requests in the request body, and handles
+// them the same way as requests received over WebSocket.
+func (ls *longPollSession) receive(r *http.Request) error {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error reading request body: " + err.Error()}
+	}
+	var reqs []json.RawMessage
+	if t := strings.TrimSpace(string(b)); strings.HasPrefix(t, "[") {
+		if err := json.Unmarshal(b, &reqs); err != nil {
+			return &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding request body: " + err.Error()}
+		}
+	} else {
+		reqs = []json.RawMessage{b}
+	}
+
+	c := ls.c
+	for _, in := range reqs {
+		in := []byte(in)
+		c.countIn(len(in))
+		c.Tracef("--> %s", in)
+		c.throttleWait()
+		c.slowStartWait()
+		handle := func() {
+			if rpc.HandleRequest(in, c) != nil {
+				c.protocolError()
+			}
+		}
+		c.bandwidthHint()
+		err := c.bandwidthError()
+		if err == nil {
+			err = c.rateLimitError()
+		}
+		if err != nil {
+			handle = func() {
+				if rpc.RejectRequest(in, c, err) != nil {
+					c.protocolError()
+				}
+			}
+		}
+		if !c.Enqueue(handle) {
+			return errLongPollSessionNotFound
+		}
+	}
+	return nil
+}
+
+// push queues a message to be returned by the next poll. The session is
+// closed if the queue is full.
+func (ls *longPollSession) push(data []byte) {
+	ls.mu.Lock()
+	if ls.closed {
+		ls.mu.Unlock()
+		return
+	}
+	if len(ls.msgs) >= ls.cfg.MaxQueue {
+		ls.mu.Unlock()
+		ls.c.Errorf("Long-polling session queue full, closing session")
+		ls.c.Disconnect("slow consumer")
+		return
+	}
+	ls.msgs = append(ls.msgs, json.RawMessage(data))
+	ls.wakeup()
+	ls.mu.Unlock()
+}
+
+// poll returns the queued messages, waiting for messages up to the timeout
+// if none are queued. A poll replaces any ongoing poll, which responds
+// without messages. It returns false if the session is closed and there
+// are no more messages.
+func (ls *longPollSession) poll(r *http.Request) ([]json.RawMessage, bool) {
+	ls.mu.Lock()
+	if ls.closed && len(ls.msgs) == 0 {
+		ls.mu.Unlock()
+		return nil, false
+	}
+	ls.polls++
+	if len(ls.msgs) == 0 && !ls.closed {
+		ls.wakeup()
+		wait := make(chan struct{})
+		ls.wait = wait
+		ls.mu.Unlock()
+
+		t := time.NewTimer(time.Duration(ls.cfg.Timeout) * time.Millisecond)
+		select {
+		case <-wait:
+		case <-t.C:
+		case <-r.Context().Done():
+		}
+		t.Stop()
+
+		ls.mu.Lock()
+		if ls.wait == wait {
+			ls.wait = nil
+		}
+	}
+	msgs := ls.msgs
+	ls.msgs = nil
+	ls.polls--
+	if ls.polls == 0 && !ls.closed {
+		ls.expiry.Reset(time.Duration(ls.cfg.SessionTimeout) * time.Millisecond)
+	}
+	ls.mu.Unlock()
+	return msgs, true
+}
+
+// wakeup makes any waiting poll respond.
+// ls.mu must be held when called.
+func (ls *longPollSession) wakeup() {
+	if ls.wait != nil {
+		close(ls.wait)
+		ls.wait = nil
+	}
+}
+
+// expire closes the session if no poll was made within the session timeout.
+func (ls *longPollSession) expire() {
+	ls.mu.Lock()
+	idle := ls.polls == 0
+	ls.mu.Unlock()
+	if idle {
+		ls.c.Disconnect("session timeout")
+	}
+}
+
+// close closes the session and disposes the connection. Messages already
+// queued may still be polled.
+func (ls *longPollSession) close() {
+	ls.mu.Lock()
+	if ls.closed {
+		ls.mu.Unlock()
+		return
+	}
+	ls.closed = true
+	ls.expiry.Stop()
+	ls.wakeup()
+	ls.mu.Unlock()
+
+	// Keep the session for a last poll, to let the client get any final
+	// messages.
+	s := ls.c.serv
+	time.AfterFunc(time.Duration(ls.cfg.Timeout)*time.Millisecond, func() {
+		s.lpMu.Lock()
+		delete(s.lpSessions, ls.sid)
+		s.lpMu.Unlock()
+	})
+	go ls.c.Dispose()
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// errMaintenance is the default error used while in maintenance mode.
+var errMaintenance = &reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Service under maintenance"}
+
+// maintenanceMode holds the maintenance mode state set through the admin endpoint.
+type maintenanceMode struct {
+	Enabled  bool          `json:"enabled"`
+	ReadOnly bool          `json:"readOnly"`
+	Error    *reserr.Error `json:"error,omitempty"`
+}
+
+// maintenanceCallError returns the maintenance error if maintenance mode is
+// enabled, otherwise nil. Used to reject call and new requests.
+func (s *Service) maintenanceCallError() error {
+	s.maintMu.RLock()
+	defer s.maintMu.RUnlock()
+	if !s.maint.Enabled {
+		return nil
+	}
+	return s.maint.err()
+}
+
+// maintenanceConnError returns the maintenance error if maintenance mode is
+// enabled without being read-only, otherwise nil. Used to reject new
+// connections and HTTP requests.
+func (s *Service) maintenanceConnError() error {
+	s.maintMu.RLock()
+	defer s.maintMu.RUnlock()
+	if !s.maint.Enabled || s.maint.ReadOnly {
+		return nil
+	}
+	return s.maint.err()
+}
+
+func (m maintenanceMode) err() *reserr.Error {
+	if m.Error != nil {
+		return m.Error
+	}
+	return errMaintenance
+}
+
+// adminMaintenanceHandler gets or sets the maintenance mode.
+func (s *Service) adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var m maintenanceMode
+		if !decodeAdminRequest(w, r, &m) {
+			return
+		}
+		if m.Error != nil && (m.Error.Code == "" || m.Error.Message == "") {
+			adminError(w, http.StatusBadRequest, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error must have a code and a message"})
+			return
+		}
+		s.maintMu.Lock()
+		s.maint = m
+		s.maintMu.Unlock()
+		switch {
+		case !m.Enabled:
+			s.Logf("Maintenance mode disabled")
+		case m.ReadOnly:
+			s.Logf("Maintenance mode enabled (read-only)")
+		default:
+			s.Logf("Maintenance mode enabled")
+		}
+	default:
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+
+	s.maintMu.RLock()
+	m := s.maint
+	s.maintMu.RUnlock()
+	if m.Enabled {
+		m.Error = m.err()
+	}
+	adminResponse(w, m)
+}
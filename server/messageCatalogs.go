@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// initMessageCatalogs loads the message catalogs from the messageCatalogs
+// directory. Each file named <languageTag>.json holds a JSON object of error
+// messages and their localized translation:
+//
+//	{ "Not found": "Hittades inte", "Access denied": "Åtkomst nekad" }
+func (s *Service) initMessageCatalogs() error {
+	if s.cfg.MessageCatalogs == nil {
+		return nil
+	}
+	dir := *s.cfg.MessageCatalogs
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("invalid messageCatalogs setting (%s)\n\t%s", dir, err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("invalid messageCatalogs setting (%s)\n\tno message catalog files found", dir)
+	}
+	s.catalogs = make(map[string]map[string]string, len(files))
+	for _, file := range files {
+		tag := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if tag == "" || strings.ContainsAny(tag, ",; ") {
+			return fmt.Errorf("invalid messageCatalogs setting (%s)\n\t'%s' must be a valid language tag", dir, tag)
+		}
+		dta, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("invalid messageCatalogs setting (%s)\n\t%s", dir, err)
+		}
+		var msgs map[string]string
+		if err := json.Unmarshal(dta, &msgs); err != nil {
+			return fmt.Errorf("invalid messageCatalogs setting (%s)\n\terror parsing %s: %s", dir, filepath.Base(file), err)
+		}
+		s.catalogs[tag] = msgs
+	}
+	return nil
+}
+
+// localizeError returns the error with the message translated by the message
+// catalog of the language best matching the Accept-Language header of the
+// client request. If no catalog holds a translation of the message, rerr is
+// returned.
+func (s *Service) localizeError(rerr *reserr.Error, r *http.Request) *reserr.Error {
+	if s.catalogs == nil || r == nil {
+		return rerr
+	}
+	h := r.Header.Get("Accept-Language")
+	if h == "" {
+		return rerr
+	}
+	for _, tag := range acceptLanguages(h) {
+		if msg, ok := s.catalogs[tag][rerr.Message]; ok {
+			localized := *rerr
+			localized.Message = msg
+			return &localized
+		}
+	}
+	return rerr
+}
@@ -20,6 +20,9 @@ type Client interface {
 	// callback to be called once.
 	SendRequest(subject string, payload []byte, cb Response)
 
+	// Publish sends a message on a subject without expecting a response.
+	Publish(subject string, payload []byte) error
+
 	// Subscribe to all events on a resource namespace.
 	// The namespace has the format "event."+resource
 	Subscribe(namespace string, cb Response) (Unsubscriber, error)
@@ -34,6 +37,29 @@ type Client interface {
 	SetClosedHandler(cb func(error))
 }
 
+// TraceFilterer is implemented by clients that can limit trace logging to
+// messages on some subjects.
+type TraceFilterer interface {
+	// SetTraceFilter sets a filter that returns true for subjects to trace.
+	// A nil filter traces all subjects.
+	SetTraceFilter(f func(subject string) bool)
+}
+
+// Reconnecter is implemented by clients that may reconnect after losing the
+// connection, such as by failing over to another server. Messages sent while
+// disconnected may be lost.
+type Reconnecter interface {
+	// SetReconnectHandler sets the handler called when reconnected.
+	SetReconnectHandler(cb func())
+}
+
+// Identifier is implemented by clients that identify the gateway instance in
+// the messages sent, such as by message headers.
+type Identifier interface {
+	// SetInstanceID sets the instance ID of the gateway.
+	SetInstanceID(id string)
+}
+
 // ErrRequestTimeout is the error the client should pass to the Response
 // when a call to SendRequest times out
 var ErrRequestTimeout = reserr.ErrTimeout
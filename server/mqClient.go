@@ -1,11 +1,50 @@
 package server
 
 import (
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
 	"github.com/resgateio/resgate/server/rescache"
 )
 
+// mqWrapper is embedded by clients wrapping the messaging system client, to
+// pass the calls they do not intercept on to the underlying client.
+type mqWrapper struct {
+	mq.Client
+}
+
+// SetTraceFilter passes the trace filter to the underlying client, if
+// supported.
+func (w mqWrapper) SetTraceFilter(f func(subject string) bool) {
+	if tf, ok := w.Client.(mq.TraceFilterer); ok {
+		tf.SetTraceFilter(f)
+	}
+}
+
 func (s *Service) initMQClient() {
+	if r, ok := s.mq.(mq.Reconnecter); ok {
+		r.SetReconnectHandler(s.handleReconnectedMQ)
+	}
+	if i, ok := s.mq.(mq.Identifier); ok {
+		i.SetInstanceID(s.id)
+	}
+	s.initChunking()
+	s.initPayloadEncryption()
+	s.initPayloadCompression()
+	s.initSignatureVerification()
+	s.initUpstreams()
+	s.initSurrogateKeys()
+	s.initLatencyHeatmap()
+	s.initPayloadStats()
 	s.cache = rescache.NewCache(s.mq, CacheWorkers, UnsubscribeDelay, s.logger)
+	s.cache.SetSystemEventHandler(s.handleSystemEvent)
+	s.cache.SetAccessResetHandler(s.handleAccessReset)
+	s.cache.SetCanaryRoutes(s.cfg.canaryRoutes)
+	s.cache.SetShadowRoutes(s.cfg.shadowRoutes)
+	s.cache.SetPollRules(s.cfg.pollRules)
+	s.cache.SetUniqueCollections(s.cfg.uniqueCollections)
+	s.cache.SetEventDedupWindow(time.Duration(s.cfg.EventDedupWindow) * time.Millisecond)
+	s.cache.SetEventSequence(s.cfg.EventSequence)
 }
 
 // startMQClients creates a connection to the messaging system.
@@ -34,3 +73,9 @@ func (s *Service) stopMQClient() {
 func (s *Service) handleClosedMQ(err error) {
 	s.Stop(err)
 }
+
+// handleReconnectedMQ resets all cached resources and access, as any events
+// sent while disconnected are lost.
+func (s *Service) handleReconnectedMQ() {
+	s.cache.ResetAll()
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// NeverUpdatedWindow is the default time in milliseconds a resource must
+// have been subscribed to without any event to be reported as never updated.
+const NeverUpdatedWindow = 3600000
+
+// NeverUpdatedConfig holds the configuration for reporting subscribed
+// resources that have not received any event, which may be caused by a
+// service not sending events, or clients subscribing to the wrong resource.
+type NeverUpdatedConfig struct {
+	// Window is the time in milliseconds a resource must have been subscribed
+	// to without any event to be reported. Defaults to 3600000.
+	Window int `json:"window,omitempty"`
+	// Ignore are the resource patterns of resources not expected to change,
+	// which are never reported.
+	Ignore []string `json:"ignore,omitempty"`
+
+	ignore []rescache.ResourcePattern
+}
+
+type neverUpdatedResponse struct {
+	Window    int                    `json:"window"`
+	Resources []neverUpdatedResource `json:"resources"`
+}
+
+type neverUpdatedResource struct {
+	RID        string `json:"rid"`
+	Subscribed int64  `json:"subscribed"`
+	Count      int64  `json:"count"`
+}
+
+// prepare validates the never updated configuration, sets default values,
+// and parses the ignore patterns.
+func (c *NeverUpdatedConfig) prepare() error {
+	if c.Window < 0 {
+		return errors.New("window must be zero or a positive number of milliseconds")
+	}
+	if c.Window == 0 {
+		c.Window = NeverUpdatedWindow
+	}
+	var err error
+	c.ignore, err = parseResourcePatterns(c.Ignore)
+	if err != nil {
+		return fmt.Errorf("ignore %s", err)
+	}
+	return nil
+}
+
+// neverUpdated returns the resources subscribed to for at least the window
+// without any event, leaving out resources matching an ignore pattern.
+func (s *Service) neverUpdated(window int) []neverUpdatedResource {
+	var ignore []rescache.ResourcePattern
+	if s.cfg.NeverUpdated != nil {
+		ignore = s.cfg.NeverUpdated.ignore
+	}
+	nus := s.cache.NeverUpdated(time.Duration(window) * time.Millisecond)
+	rs := make([]neverUpdatedResource, 0, len(nus))
+Loop:
+	for _, nu := range nus {
+		for _, p := range ignore {
+			if p.Match(nu.ResourceName) {
+				continue Loop
+			}
+		}
+		rs = append(rs, neverUpdatedResource{
+			RID:        nu.ResourceName,
+			Subscribed: nu.Subscribed.UnixNano() / int64(time.Millisecond),
+			Count:      nu.Count,
+		})
+	}
+	return rs
+}
+
+// adminNeverUpdatedHandler returns the subscribed resources that have not
+// received any event within the window, which may be set by the window query
+// parameter in milliseconds.
+func (s *Service) adminNeverUpdatedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	window := NeverUpdatedWindow
+	if s.cfg.NeverUpdated != nil {
+		window = s.cfg.NeverUpdated.Window
+	}
+	if v := r.URL.Query().Get("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			adminError(w, http.StatusBadRequest, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Window must be zero or a positive number of milliseconds"})
+			return
+		}
+		window = n
+	}
+	adminResponse(w, neverUpdatedResponse{
+		Window:    window,
+		Resources: s.neverUpdated(window),
+	})
+}
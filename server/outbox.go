@@ -0,0 +1,79 @@
+package server
+
+import (
+	"time"
+
+	"github.com/resgateio/resgate/server/outbox"
+)
+
+// initOutbox opens the outbox directory, if configured, and sets the cache
+// to store call requests made with an idempotency key. Tokens of stored
+// requests are encrypted or excluded, if configured. With leader election,
+// scheduled calls are sent by the leader only.
+func (s *Service) initOutbox() error {
+	if s.cfg.OutboxPath == nil {
+		return nil
+	}
+	o, err := outbox.OpenWithOptions(*s.cfg.OutboxPath, outbox.Options{
+		TokenKey:      s.cfg.outboxTokenKey,
+		ExcludeTokens: s.cfg.OutboxExcludeTokens,
+	})
+	if err != nil {
+		return err
+	}
+	s.outbox = o
+	s.cache.SetOutbox(o)
+	if s.cfg.LeaderElection {
+		s.cache.SetOutboxLeader(s.IsLeader)
+	}
+	return nil
+}
+
+// startOutbox resends any call requests remaining in the outbox from a
+// previous run, and starts resending unanswered requests at an interval.
+// Service.mu is held when called
+func (s *Service) startOutbox() {
+	if s.outbox == nil {
+		return
+	}
+	s.resendOutbox()
+
+	stop := make(chan struct{})
+	s.outboxStop = stop
+	go func() {
+		defer s.recoverFatal("outbox")
+		ticker := time.NewTicker(OutboxResendInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.resendOutbox()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// resendOutbox resends the call requests in the outbox not in flight. With
+// leader election, the outbox may be shared by the fleet, and is resent by
+// the leader only. As the leader is not elected until an announce interval
+// after start, requests remaining from a previous run are then resent on
+// interval.
+func (s *Service) resendOutbox() {
+	if s.cfg.LeaderElection && !s.IsLeader() {
+		return
+	}
+	s.cache.ResendOutbox()
+}
+
+// stopOutbox stops resending call requests. Requests remaining in the outbox
+// are kept to be resent on next start.
+func (s *Service) stopOutbox() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outboxStop != nil {
+		close(s.outboxStop)
+		s.outboxStop = nil
+	}
+}
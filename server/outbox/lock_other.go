@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package outbox
+
+import "os"
+
+// lock is a no-op on systems without advisory file locks. Requests are only
+// protected from being acquired twice within the same process, and the
+// directory must not be shared.
+func lock(f *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,21 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package outbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// lock places an exclusive advisory lock on the file, reporting false if it
+// is locked by another open file, such as by another process sharing the
+// directory. The lock is released when the file is closed, or when the
+// process exits.
+func lock(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
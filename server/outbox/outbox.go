@@ -0,0 +1,390 @@
+// Package outbox provides a durable disk store for requests awaiting
+// a response from a service.
+package outbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+const (
+	fileExt = ".json"
+	badExt  = ".bad"
+)
+
+// Outbox stores requests as files in a directory until they are removed.
+// All operations are safe for concurrent use.
+//
+// The file of a request in flight is kept open with an advisory lock, so
+// that processes sharing the directory never acquire the same request. A
+// lock is released when the request is released, or when the process exits.
+// On systems without advisory file locks, requests are only locked within
+// the process.
+type Outbox struct {
+	dir      string
+	aead     cipher.AEAD
+	exclude  bool
+	mu       sync.Mutex
+	inflight map[string]*os.File
+}
+
+// Options holds the options for how requests are stored.
+type Options struct {
+	// TokenKey is a 256-bit AES key used to encrypt the token of stored
+	// requests.
+	TokenKey []byte
+	// ExcludeTokens removes the token from requests before they are stored.
+	// Requests are resent without a token.
+	ExcludeTokens bool
+}
+
+// Entry represents a stored request.
+type Entry struct {
+	ID      string          `json:"-"`
+	Subject string          `json:"subject"`
+	Payload json.RawMessage `json:"payload"`
+	SendAt  *time.Time      `json:"sendAt,omitempty"`
+}
+
+// record is an entry as it is stored on disk.
+type record struct {
+	Entry
+	EncryptedToken string `json:"encryptedToken,omitempty"`
+}
+
+// Open returns an Outbox storing requests in the directory dir.
+// The directory is created if it doesn't exist.
+func Open(dir string) (*Outbox, error) {
+	return OpenWithOptions(dir, Options{})
+}
+
+// OpenWithOptions returns an Outbox storing requests in the directory dir
+// using the given options. The directory is created if it doesn't exist.
+func OpenWithOptions(dir string, opts Options) (*Outbox, error) {
+	o := &Outbox{
+		dir:      dir,
+		exclude:  opts.ExcludeTokens,
+		inflight: make(map[string]*os.File),
+	}
+	if opts.TokenKey != nil {
+		block, err := aes.NewCipher(opts.TokenKey)
+		if err != nil {
+			return nil, err
+		}
+		if o.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Add stores a request and returns its ID. The entry is considered in flight
+// until either Remove or Release is called.
+func (o *Outbox) Add(subj string, payload []byte) (string, error) {
+	return o.add(Entry{Subject: subj, Payload: payload})
+}
+
+// Schedule stores a request to be sent at a later time, and returns its ID.
+// The entry is considered in flight until either Remove or Release is called.
+func (o *Outbox) Schedule(subj string, payload []byte, at time.Time) (string, error) {
+	return o.add(Entry{Subject: subj, Payload: payload, SendAt: &at})
+}
+
+func (o *Outbox) add(e Entry) (string, error) {
+	rec, err := o.seal(e)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+
+	id := xid.New().String()
+	tmp := filepath.Join(o.dir, "."+id+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = lock(f)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, o.path(id))
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+
+	o.mu.Lock()
+	o.inflight[id] = f
+	o.mu.Unlock()
+	return id, nil
+}
+
+// Remove deletes a stored request.
+func (o *Outbox) Remove(id string) error {
+	o.mu.Lock()
+	f := o.inflight[id]
+	delete(o.inflight, id)
+	o.mu.Unlock()
+
+	// The file is removed before it is unlocked, for no other process to
+	// acquire it in between.
+	err := os.Remove(o.path(id))
+	if f != nil {
+		f.Close()
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Release marks a stored request as no longer in flight, making it
+// available to be returned by Acquire.
+func (o *Outbox) Release(id string) {
+	o.mu.Lock()
+	f := o.inflight[id]
+	delete(o.inflight, id)
+	o.mu.Unlock()
+	if f != nil {
+		f.Close()
+	}
+}
+
+// Close releases all stored requests in flight.
+func (o *Outbox) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for id, f := range o.inflight {
+		f.Close()
+		delete(o.inflight, id)
+	}
+}
+
+// Exists reports whether a stored request has not been removed, such as by
+// another process sharing the directory.
+func (o *Outbox) Exists(id string) bool {
+	_, err := os.Stat(o.path(id))
+	return err == nil
+}
+
+// Get returns a stored request without acquiring it. If the request does
+// not exist, the returned error satisfies os.IsNotExist.
+func (o *Outbox) Get(id string) (*Entry, error) {
+	data, err := ioutil.ReadFile(o.path(id))
+	if err != nil {
+		return nil, err
+	}
+	e, err := o.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	e.ID = id
+	return &e, nil
+}
+
+// Acquire returns all stored requests not in flight in any process, ordered
+// by the time they were added, and marks them as in flight. Scheduled
+// requests are included regardless of their send time.
+//
+// A stored request that cannot be decoded or decrypted is moved to a file
+// with a .bad extension, and reported in the returned error, while the
+// remaining requests are still returned.
+func (o *Outbox) Acquire() ([]*Entry, error) {
+	files, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, fileExt))
+	}
+	sort.Strings(ids)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries := make([]*Entry, 0, len(ids))
+	var errs []string
+	for _, id := range ids {
+		if _, ok := o.inflight[id]; ok {
+			continue
+		}
+		f, err := o.claim(id)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("entry %s: %s", id, err))
+			continue
+		}
+		if f == nil {
+			continue
+		}
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			f.Close()
+			errs = append(errs, fmt.Sprintf("entry %s: %s", id, err))
+			continue
+		}
+		e, err := o.decode(data)
+		if err != nil {
+			errs = append(errs, o.discard(id, err))
+			f.Close()
+			continue
+		}
+		e.ID = id
+		o.inflight[id] = f
+		entries = append(entries, &e)
+	}
+	if errs != nil {
+		return entries, errors.New(strings.Join(errs, "; "))
+	}
+	return entries, nil
+}
+
+// claim opens and locks the file of a stored request. A nil file is
+// returned if the request is removed, or locked by another process.
+func (o *Outbox) claim(id string) (*os.File, error) {
+	f, err := os.Open(o.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ok, err := lock(f)
+	if !ok {
+		f.Close()
+		return nil, err
+	}
+	// The request may have been removed by the process holding the lock
+	// before it was released.
+	fi, err := f.Stat()
+	if err == nil {
+		var pfi os.FileInfo
+		if pfi, err = os.Stat(o.path(id)); err == nil && !os.SameFile(fi, pfi) {
+			err = os.ErrNotExist
+		}
+	}
+	if err != nil {
+		f.Close()
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// decode returns the entry of the data of a stored record.
+func (o *Outbox) decode(data []byte) (Entry, error) {
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Entry{}, err
+	}
+	return o.open(rec)
+}
+
+// discard moves a stored request that cannot be read to a file with a .bad
+// extension, and returns a description of the error.
+func (o *Outbox) discard(id string, err error) string {
+	bad := filepath.Join(o.dir, id+badExt)
+	if rerr := os.Rename(o.path(id), bad); rerr != nil {
+		return fmt.Sprintf("entry %s: %s (failed to move to %s: %s)", id, err, bad, rerr)
+	}
+	return fmt.Sprintf("entry %s: %s (moved to %s)", id, err, bad)
+}
+
+func (o *Outbox) path(id string) string {
+	return filepath.Join(o.dir, id+fileExt)
+}
+
+// seal returns the record to store for the entry, with the token of the
+// request payload removed or encrypted, as set by the options.
+func (o *Outbox) seal(e Entry) (record, error) {
+	if o.aead == nil && !o.exclude {
+		return record{Entry: e}, nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(e.Payload, &m); err != nil {
+		return record{}, err
+	}
+	tok, ok := m["token"]
+	if !ok {
+		return record{Entry: e}, nil
+	}
+	delete(m, "token")
+	var rec record
+	if !o.exclude {
+		nonce := make([]byte, o.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return record{}, err
+		}
+		rec.EncryptedToken = base64.StdEncoding.EncodeToString(o.aead.Seal(nonce, nonce, tok, nil))
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return record{}, err
+	}
+	rec.Entry = e
+	rec.Payload = payload
+	return rec, nil
+}
+
+// open returns the entry of a stored record, with any encrypted token
+// decrypted and added back to the request payload.
+func (o *Outbox) open(rec record) (Entry, error) {
+	e := rec.Entry
+	if rec.EncryptedToken == "" {
+		return e, nil
+	}
+	if o.aead == nil {
+		return e, errors.New("token is encrypted but no token key is set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(rec.EncryptedToken)
+	if err != nil || len(sealed) < o.aead.NonceSize() {
+		return e, errors.New("invalid encrypted token")
+	}
+	n := o.aead.NonceSize()
+	tok, err := o.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return e, errors.New("failed to decrypt token")
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(e.Payload, &m); err != nil {
+		return e, err
+	}
+	m["token"] = tok
+	if e.Payload, err = json.Marshal(m); err != nil {
+		return e, err
+	}
+	return e, nil
+}
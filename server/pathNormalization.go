@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+)
+
+// PathNormalizationConfig holds the configuration for normalizing HTTP API
+// paths before they are mapped to resource IDs. GET and HEAD requests on a
+// path not in its normalized form are redirected with status 301 to the
+// normalized path, while other requests are handled as if made on the
+// normalized path.
+type PathNormalizationConfig struct {
+	// TrailingSlash flags that trailing slashes are removed from the path.
+	TrailingSlash bool `json:"trailingSlash"`
+	// CaseFolding is a list of resource patterns with segments matched
+	// regardless of case. A path matching a pattern has the segments
+	// matching the literal tokens of the pattern folded to the case of the
+	// pattern, while segments matching wildcards are kept as is.
+	// Eg. ["library.books", "library.book.*"]
+	CaseFolding []string `json:"caseFolding"`
+
+	caseFolding [][]string
+}
+
+// prepare validates the path normalization configuration.
+func (c *PathNormalizationConfig) prepare() error {
+	if _, err := parseResourcePatterns(c.CaseFolding); err != nil {
+		return err
+	}
+	c.caseFolding = make([][]string, len(c.CaseFolding))
+	for i, p := range c.CaseFolding {
+		c.caseFolding[i] = strings.Split(p, ".")
+	}
+	return nil
+}
+
+// normalizePath returns the raw path of the API mount in its normalized form.
+// If action is true, the last segment of the path is a method name, which is
+// not case folded.
+func (s *Service) normalizePath(path string, mount *apiMount, action bool) string {
+	c := s.cfg.PathNormalization
+	apiPath := mount.path
+	if c == nil || len(path) <= len(apiPath) {
+		return path
+	}
+	rest := path[len(apiPath):]
+	if c.TrailingSlash {
+		rest = strings.TrimRight(rest, "/")
+	}
+	if len(c.caseFolding) > 0 && rest != "" {
+		// Namespace tokens of the mount are prepended to match the patterns
+		var ns []string
+		if mount.prefix != "" {
+			ns = strings.Split(mount.prefix[:len(mount.prefix)-1], ".")
+		}
+		parts := append(ns, strings.Split(rest, "/")...)
+		ridParts := parts
+		if action {
+			ridParts = parts[:len(parts)-1]
+		}
+		for _, tokens := range c.caseFolding {
+			if foldSegments(ridParts, tokens) {
+				break
+			}
+		}
+		rest = strings.Join(parts[len(ns):], "/")
+	}
+	return apiPath + rest
+}
+
+// foldSegments folds the raw path segments matching the literal tokens of a
+// resource pattern to the case of the token, if all segments match the
+// pattern case-insensitively. It reports whether the segments matched.
+func foldSegments(parts []string, tokens []string) bool {
+	n := len(tokens)
+	for i, t := range tokens {
+		if t == ">" {
+			if i >= len(parts) {
+				return false
+			}
+			n = i
+			break
+		}
+		if i >= len(parts) {
+			return false
+		}
+		if t == "*" {
+			continue
+		}
+		part, err := url.PathUnescape(parts[i])
+		if err != nil || !strings.EqualFold(part, t) {
+			return false
+		}
+	}
+	if n == len(tokens) && len(parts) != n {
+		return false
+	}
+	for i, t := range tokens[:n] {
+		if t != "*" {
+			parts[i] = url.PathEscape(t)
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// PayloadCompressionMaxSize is the default maximum size of a decompressed
+// payload.
+const PayloadCompressionMaxSize = 64 << 20
+
+// PayloadCompression holds the configuration for gzip compression of the
+// payloads of NATS messages for resources matching a pattern.
+type PayloadCompression struct {
+	// Pattern is the resource pattern of the messages to compress.
+	Pattern string `json:"pattern"`
+	// Threshold is the size in bytes at which request payloads are
+	// compressed. Zero means requests are never compressed, while compressed
+	// responses and events are still accepted.
+	Threshold int `json:"threshold,omitempty"`
+	// MaxSize is the maximum size in bytes of a decompressed payload.
+	// Defaults to 64 MiB.
+	MaxSize int `json:"maxSize,omitempty"`
+}
+
+// compressionRule is a prepared PayloadCompression configuration.
+type compressionRule struct {
+	PayloadCompression
+	pattern rescache.ResourcePattern
+}
+
+// compressedClient is a mq.Client compressing and decompressing the payloads
+// of messages on subjects matching a compression rule.
+//
+// A compressed payload is the gzip stream of the payload. Since a JSON
+// message never starts with the gzip magic bytes, compressed and
+// uncompressed payloads may be mixed on the same subjects.
+type compressedClient struct {
+	mqWrapper
+	s     *Service
+	rules []compressionRule
+}
+
+var errDecompressPayload = &reserr.Error{Code: reserr.CodeInternalError, Message: "Failed to decompress payload"}
+var errDecompressedTooLarge = &reserr.Error{Code: reserr.CodeInternalError, Message: "Decompressed payload too large"}
+
+// gzipMagic is the header starting each gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// prepare validates the payload compression configuration, and returns the
+// prepared rule.
+func (c PayloadCompression) prepare() (compressionRule, error) {
+	p := rescache.ParseResourcePattern(c.Pattern)
+	if !p.IsValid() {
+		return compressionRule{}, fmt.Errorf("pattern %q must be a valid resource pattern", c.Pattern)
+	}
+	if c.Threshold < 0 {
+		return compressionRule{}, errors.New("threshold must be zero or a positive number of bytes")
+	}
+	if c.MaxSize < 0 {
+		return compressionRule{}, errors.New("maxSize must be zero or a positive number of bytes")
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = PayloadCompressionMaxSize
+	}
+	return compressionRule{PayloadCompression: c, pattern: p}, nil
+}
+
+// initPayloadCompression wraps the messaging client to compress payloads, if
+// payload compression is configured.
+func (s *Service) initPayloadCompression() {
+	if len(s.cfg.compressionRules) > 0 {
+		s.mq = &compressedClient{mqWrapper: mqWrapper{s.mq}, s: s, rules: s.cfg.compressionRules}
+	}
+}
+
+// rule returns the first compression rule matching the subject, or nil if
+// the payload is not compressed.
+func (c *compressedClient) rule(subj string) (rule *compressionRule) {
+	matchSubject(subj, func(rid string) bool {
+		for i := range c.rules {
+			if c.rules[i].pattern.Match(rid) {
+				rule = &c.rules[i]
+				return true
+			}
+		}
+		return false
+	})
+	return rule
+}
+
+// SendRequest compresses the request payload, and decompresses the response
+// payload, if the subject matches a compression rule.
+func (c *compressedClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	r := c.rule(subj)
+	if r == nil {
+		c.Client.SendRequest(subj, payload, cb)
+		return
+	}
+	c.Client.SendRequest(subj, r.compress(payload), func(rsubj string, data []byte, err error) {
+		if err == nil {
+			data, err = r.decompress(data)
+			if err != nil {
+				c.s.Errorf("Error decompressing response on %s: %s", subj, err)
+			}
+		}
+		cb(rsubj, data, err)
+	})
+}
+
+// Publish compresses the payload if the subject matches a compression rule.
+func (c *compressedClient) Publish(subj string, payload []byte) error {
+	if r := c.rule(subj); r != nil {
+		payload = r.compress(payload)
+	}
+	return c.Client.Publish(subj, payload)
+}
+
+// Subscribe decompresses the payload of messages on subjects matching a
+// compression rule. Messages that fail to decompress are dropped.
+func (c *compressedClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	return c.Client.Subscribe(namespace, func(subj string, payload []byte, err error) {
+		if r := c.rule(subj); r != nil && err == nil {
+			payload, err = r.decompress(payload)
+			if err != nil {
+				c.s.Errorf("Error decompressing message on %s: %s", subj, err)
+				return
+			}
+		}
+		cb(subj, payload, err)
+	})
+}
+
+// compress returns the gzip compressed payload, if it is at least the
+// threshold size. Otherwise the payload is returned as is.
+func (r *compressionRule) compress(payload []byte) []byte {
+	if r.Threshold == 0 || len(payload) < r.Threshold {
+		return payload
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(payload)
+	w.Close()
+	return buf.Bytes()
+}
+
+// decompress returns the decompressed payload if it is gzip compressed.
+// Otherwise the payload is returned as is.
+func (r *compressionRule) decompress(payload []byte) ([]byte, error) {
+	if !bytes.HasPrefix(payload, gzipMagic) {
+		return payload, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, errDecompressPayload
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(zr, int64(r.MaxSize)+1))
+	if err != nil {
+		return nil, errDecompressPayload
+	}
+	if len(data) > r.MaxSize {
+		return nil, errDecompressedTooLarge
+	}
+	return data, nil
+}
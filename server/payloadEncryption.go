@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// PayloadEncryption holds the configuration for encrypting the payloads of
+// NATS messages for resources matching a pattern.
+type PayloadEncryption struct {
+	// Pattern is the resource pattern of the messages to encrypt.
+	Pattern string `json:"pattern"`
+	// Key is the base64 encoded 256-bit AES key shared with the services.
+	Key string `json:"key"`
+}
+
+// encryptionRule is a prepared PayloadEncryption configuration.
+type encryptionRule struct {
+	pattern rescache.ResourcePattern
+	aead    cipher.AEAD
+}
+
+// encryptedClient is a mq.Client encrypting and decrypting the payloads of
+// messages on subjects matching an encryption rule.
+//
+// An encrypted payload is a JSON string with the base64 encoded 12 byte nonce
+// followed by the AES-256-GCM sealed payload.
+type encryptedClient struct {
+	mqWrapper
+	s     *Service
+	rules []encryptionRule
+}
+
+var errNotEncrypted = &reserr.Error{Code: reserr.CodeInternalError, Message: "Payload not encrypted"}
+var errDecryptPayload = &reserr.Error{Code: reserr.CodeInternalError, Message: "Failed to decrypt payload"}
+
+// prepare validates the payload encryption configuration, and returns the
+// prepared rule.
+func (c PayloadEncryption) prepare() (encryptionRule, error) {
+	p := rescache.ParseResourcePattern(c.Pattern)
+	if !p.IsValid() {
+		return encryptionRule{}, fmt.Errorf("pattern %q must be a valid resource pattern", c.Pattern)
+	}
+	key, err := base64.StdEncoding.DecodeString(c.Key)
+	if err != nil || len(key) != 32 {
+		return encryptionRule{}, fmt.Errorf("key for pattern %q must be a base64 encoded 32 byte key", c.Pattern)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return encryptionRule{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return encryptionRule{}, err
+	}
+	return encryptionRule{pattern: p, aead: aead}, nil
+}
+
+// initPayloadEncryption wraps the messaging client to encrypt payloads, if
+// payload encryption is configured.
+func (s *Service) initPayloadEncryption() {
+	if len(s.cfg.encryptionRules) > 0 {
+		s.mq = &encryptedClient{mqWrapper: mqWrapper{s.mq}, s: s, rules: s.cfg.encryptionRules}
+	}
+}
+
+// rule returns the cipher of the first encryption rule matching the subject,
+// or nil if the payload is not encrypted.
+func (c *encryptedClient) rule(subj string) (aead cipher.AEAD) {
+	matchSubject(subj, func(rid string) bool {
+		for _, r := range c.rules {
+			if r.pattern.Match(rid) {
+				aead = r.aead
+				return true
+			}
+		}
+		return false
+	})
+	return aead
+}
+
+// SendRequest encrypts the request payload, and decrypts the response
+// payload, if the subject matches an encryption rule.
+func (c *encryptedClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	aead := c.rule(subj)
+	if aead == nil {
+		c.Client.SendRequest(subj, payload, cb)
+		return
+	}
+	c.Client.SendRequest(subj, encryptPayload(aead, payload), func(rsubj string, data []byte, err error) {
+		if err == nil {
+			data, err = decryptPayload(aead, data)
+			if err != nil {
+				c.s.Errorf("Error decrypting response on %s: %s", subj, err)
+			}
+		}
+		cb(rsubj, data, err)
+	})
+}
+
+// Publish encrypts the payload if the subject matches an encryption rule.
+func (c *encryptedClient) Publish(subj string, payload []byte) error {
+	if aead := c.rule(subj); aead != nil {
+		payload = encryptPayload(aead, payload)
+	}
+	return c.Client.Publish(subj, payload)
+}
+
+// Subscribe decrypts the payload of messages on subjects matching an
+// encryption rule. Messages that fail to decrypt are dropped.
+func (c *encryptedClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	return c.Client.Subscribe(namespace, func(subj string, payload []byte, err error) {
+		if aead := c.rule(subj); aead != nil && err == nil {
+			payload, err = decryptPayload(aead, payload)
+			if err != nil {
+				c.s.Errorf("Error decrypting message on %s: %s", subj, err)
+				return
+			}
+		}
+		cb(subj, payload, err)
+	})
+}
+
+// encryptPayload seals the payload with a random nonce, and returns it as a
+// JSON string.
+func encryptPayload(aead cipher.AEAD, payload []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	sealed := aead.Seal(nonce, nonce, payload, nil)
+	data, _ := json.Marshal(base64.StdEncoding.EncodeToString(sealed))
+	return data
+}
+
+// decryptPayload opens a payload encrypted by encryptPayload.
+func decryptPayload(aead cipher.AEAD, payload []byte) ([]byte, error) {
+	var str string
+	if err := json.Unmarshal(payload, &str); err != nil {
+		return nil, errNotEncrypted
+	}
+	sealed, err := base64.StdEncoding.DecodeString(str)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errNotEncrypted
+	}
+	n := aead.NonceSize()
+	data, err := aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, errDecryptPayload
+	}
+	return data, nil
+}
+
+// redactPayloadEncryption returns a copy of the configuration with the keys
+// replaced.
+func redactPayloadEncryption(pe []PayloadEncryption) []PayloadEncryption {
+	if pe == nil {
+		return nil
+	}
+	r := make([]PayloadEncryption, len(pe))
+	for i, c := range pe {
+		r[i] = PayloadEncryption{Pattern: c.Pattern, Key: "xxxxx"}
+	}
+	return r
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// PayloadStatsSamples is the default number of get responses kept per
+// pattern for calculating percentiles.
+const PayloadStatsSamples = 1000
+
+// PayloadStatsConfig holds the configuration for recording the payload size
+// and item count of service get responses per resource pattern, available
+// as percentiles on the admin endpoint.
+type PayloadStatsConfig struct {
+	// Patterns are the resource patterns to keep stats for. A response is
+	// recorded for the first pattern matching its resource. Defaults to
+	// [">"].
+	Patterns []string `json:"patterns,omitempty"`
+	// Samples is the number of most recent get responses kept per pattern
+	// for calculating percentiles. Defaults to 1000.
+	Samples int `json:"samples,omitempty"`
+
+	patterns []rescache.ResourcePattern
+}
+
+// payloadStats holds the payload samples of each pattern.
+type payloadStats struct {
+	PayloadStatsConfig
+	mu   sync.Mutex
+	pats []*payloadSamples
+}
+
+// payloadSamples is a ring of the most recent payload samples of a pattern,
+// and the largest payload recorded.
+type payloadSamples struct {
+	count   int64
+	sizes   []int64
+	items   []int64
+	largest *payloadLargest
+}
+
+type payloadLargest struct {
+	RID   string `json:"rid"`
+	Size  int64  `json:"size"`
+	Items int64  `json:"items"`
+}
+
+// payloadClient is a mq.Client recording the payload of get responses.
+type payloadClient struct {
+	mqWrapper
+	p *payloadStats
+}
+
+type payloadStatsResponse struct {
+	Samples  int                   `json:"samples"`
+	Patterns []payloadPatternStats `json:"patterns"`
+}
+
+type payloadPatternStats struct {
+	Pattern string             `json:"pattern"`
+	Count   int64              `json:"count"`
+	Size    payloadPercentiles `json:"size"`
+	Items   payloadPercentiles `json:"items"`
+	Largest *payloadLargest    `json:"largest"`
+}
+
+type payloadPercentiles struct {
+	P50 int64 `json:"p50"`
+	P90 int64 `json:"p90"`
+	P99 int64 `json:"p99"`
+	Max int64 `json:"max"`
+}
+
+// prepare validates the payload stats configuration, sets default values,
+// and parses the patterns.
+func (c *PayloadStatsConfig) prepare() error {
+	if c.Samples < 0 {
+		return errors.New("samples must be zero or a positive number")
+	}
+	if c.Samples == 0 {
+		c.Samples = PayloadStatsSamples
+	}
+	if len(c.Patterns) == 0 {
+		c.Patterns = []string{">"}
+	}
+	c.patterns = make([]rescache.ResourcePattern, len(c.Patterns))
+	for i, pattern := range c.Patterns {
+		p := rescache.ParseResourcePattern(pattern)
+		if !p.IsValid() {
+			return fmt.Errorf("pattern %q must be a valid resource pattern", pattern)
+		}
+		c.patterns[i] = p
+	}
+	return nil
+}
+
+// initPayloadStats wraps the messaging client to record get response
+// payloads, if payload stats are configured.
+func (s *Service) initPayloadStats() {
+	if s.cfg.PayloadStats == nil {
+		return
+	}
+	s.payloads = &payloadStats{
+		PayloadStatsConfig: *s.cfg.PayloadStats,
+		pats:               make([]*payloadSamples, len(s.cfg.PayloadStats.Patterns)),
+	}
+	s.mq = &payloadClient{mqWrapper: mqWrapper{s.mq}, p: s.payloads}
+}
+
+// SendRequest sends the request, and records the payload of successful get
+// responses.
+func (c *payloadClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	if !strings.HasPrefix(subj, "get.") {
+		c.Client.SendRequest(subj, payload, cb)
+		return
+	}
+	c.Client.SendRequest(subj, payload, func(rsubj string, data []byte, err error) {
+		if err == nil {
+			c.p.record(subj[len("get."):], data)
+		}
+		cb(rsubj, data, err)
+	})
+}
+
+// record adds the size and item count of a get response payload to the
+// samples of the first pattern matching the resource. Error responses are
+// not recorded.
+func (p *payloadStats) record(rid string, data []byte) {
+	pi := -1
+	for i, pat := range p.patterns {
+		if pat.Match(rid) {
+			pi = i
+			break
+		}
+	}
+	if pi < 0 {
+		return
+	}
+	result, err := codec.DecodeGetResponse(data)
+	if err != nil {
+		return
+	}
+	size := int64(len(data))
+	items := int64(len(result.Model) + len(result.Collection) + len(result.Stream))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ps := p.pats[pi]
+	if ps == nil {
+		ps = &payloadSamples{
+			sizes: make([]int64, 0, p.Samples),
+			items: make([]int64, 0, p.Samples),
+		}
+		p.pats[pi] = ps
+	}
+	if len(ps.sizes) < p.Samples {
+		ps.sizes = append(ps.sizes, size)
+		ps.items = append(ps.items, items)
+	} else {
+		i := ps.count % int64(p.Samples)
+		ps.sizes[i] = size
+		ps.items[i] = items
+	}
+	ps.count++
+	if ps.largest == nil || size > ps.largest.Size {
+		ps.largest = &payloadLargest{RID: rid, Size: size, Items: items}
+	}
+}
+
+// stats returns the percentiles of the kept samples of each pattern, in
+// the order of the patterns.
+func (p *payloadStats) stats() payloadStatsResponse {
+	st := payloadStatsResponse{
+		Samples:  p.Samples,
+		Patterns: make([]payloadPatternStats, len(p.Patterns)),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, pattern := range p.Patterns {
+		ps := payloadPatternStats{Pattern: pattern}
+		if s := p.pats[i]; s != nil {
+			ps.Count = s.count
+			ps.Size = percentiles(s.sizes)
+			ps.Items = percentiles(s.items)
+			l := *s.largest
+			ps.Largest = &l
+		}
+		st.Patterns[i] = ps
+	}
+	return st
+}
+
+// percentiles returns the nearest-rank percentiles of the samples.
+func percentiles(samples []int64) payloadPercentiles {
+	if len(samples) == 0 {
+		return payloadPercentiles{}
+	}
+	sorted := make([]int64, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(pct int) int64 {
+		return sorted[(len(sorted)*pct+99)/100-1]
+	}
+	return payloadPercentiles{
+		P50: rank(50),
+		P90: rank(90),
+		P99: rank(99),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// adminPayloadsHandler returns the payload size and item count percentiles
+// of get responses per pattern.
+func (s *Service) adminPayloadsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	if s.payloads == nil {
+		adminError(w, http.StatusNotFound, &reserr.Error{Code: reserr.CodeNotFound, Message: "Payload stats not enabled"})
+		return
+	}
+	adminResponse(w, s.payloads.stats())
+}
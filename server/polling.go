@@ -0,0 +1,32 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// PollingRule holds the configuration for periodically refetching cached
+// resources matching a pattern, sending change events to subscribers when
+// the resource has changed. It lets services unable to send events provide
+// live resources.
+type PollingRule struct {
+	// Pattern is the resource pattern of resources to poll.
+	Pattern string `json:"pattern"`
+	// Interval is the time in milliseconds between get requests.
+	Interval int `json:"interval"`
+}
+
+// prepare validates the polling rule and returns the parsed rule.
+func (r PollingRule) prepare() (rescache.PollRule, error) {
+	p := rescache.ParseResourcePattern(r.Pattern)
+	if !p.IsValid() {
+		return rescache.PollRule{}, fmt.Errorf("pattern '%s' must be a valid resource pattern", r.Pattern)
+	}
+	if r.Interval <= 0 {
+		return rescache.PollRule{}, errors.New("interval must be a positive number of milliseconds")
+	}
+	return rescache.PollRule{Pattern: p, Interval: time.Duration(r.Interval) * time.Millisecond}, nil
+}
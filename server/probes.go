@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Default probe endpoint paths
+const (
+	ProbesHealthPath = "/healthz"
+	ProbesReadyPath  = "/readyz"
+)
+
+// Service states, as stored in Service.state
+const (
+	serviceStopped int32 = iota
+	serviceRunning
+	serviceStopping
+)
+
+// Server states reported by the probe endpoints
+const (
+	probeStateRunning     = "running"
+	probeStateMaintenance = "maintenance"
+	probeStateStopping    = "stopping"
+	probeStateStopped     = "stopped"
+)
+
+// ProbesConfig holds the configuration for the HTTP health and readiness
+// probe endpoints, used by liveness and readiness probes of orchestrators
+// such as Kubernetes.
+//
+// The health endpoint responds with status 200 while connected to NATS. The
+// readiness endpoint additionally requires the server to be running, and not
+// be in maintenance mode or stopping. Otherwise, the response has status 503.
+//
+// When stopping, the readiness endpoint reports the server as stopping for
+// the drain delay before the listeners are closed, giving load balancers
+// time to stop routing new clients to the server.
+type ProbesConfig struct {
+	// HealthPath is the HTTP path of the health endpoint. Defaults to
+	// "/healthz".
+	HealthPath string `json:"healthPath,omitempty"`
+	// ReadyPath is the HTTP path of the readiness endpoint. Defaults to
+	// "/readyz".
+	ReadyPath string `json:"readyPath,omitempty"`
+	// DrainDelay is the time in milliseconds to wait when stopping, after
+	// the readiness endpoint responds with status 503, before closing any
+	// connections. Defaults to 0.
+	DrainDelay int `json:"drainDelay,omitempty"`
+}
+
+// probeStatus is the body of a probe response.
+type probeStatus struct {
+	Status string `json:"status"`
+	NATS   string `json:"nats"`
+	State  string `json:"state"`
+}
+
+// prepare validates the probes configuration and sets default values.
+// The paths are validated against the API mounts by Config.apiMountError.
+func (c *ProbesConfig) prepare(wsPath string) error {
+	if c.HealthPath == "" {
+		c.HealthPath = ProbesHealthPath
+	}
+	if c.ReadyPath == "" {
+		c.ReadyPath = ProbesReadyPath
+	}
+	for _, p := range []string{c.HealthPath, c.ReadyPath} {
+		if p[0] != '/' {
+			return errors.New("paths must start with a slash (/)")
+		}
+		if p == wsPath {
+			return fmt.Errorf("path %q must not be the wsPath", p)
+		}
+	}
+	if c.HealthPath == c.ReadyPath {
+		return errors.New("healthPath and readyPath must not be the same")
+	}
+	if c.DrainDelay < 0 {
+		return errors.New("drainDelay must be zero or a positive number of milliseconds")
+	}
+	return nil
+}
+
+// probeHandler returns the handler of the probe endpoint at the path, or nil
+// if the path is not that of a probe endpoint.
+func (s *Service) probeHandler(path string) http.HandlerFunc {
+	if s.cfg.Probes == nil {
+		return nil
+	}
+	switch path {
+	case s.cfg.Probes.HealthPath:
+		return s.healthHandler
+	case s.cfg.Probes.ReadyPath:
+		return s.readyHandler
+	}
+	return nil
+}
+
+// healthHandler responds to liveness probes, reporting the server as healthy
+// while connected to NATS.
+func (s *Service) healthHandler(w http.ResponseWriter, r *http.Request) {
+	st := s.probeStatus()
+	s.writeProbeStatus(w, r, st, st.NATS == "connected" && st.State != probeStateStopped)
+}
+
+// readyHandler responds to readiness probes, reporting the server as ready
+// while connected to NATS and running.
+func (s *Service) readyHandler(w http.ResponseWriter, r *http.Request) {
+	st := s.probeStatus()
+	s.writeProbeStatus(w, r, st, st.NATS == "connected" && st.State == probeStateRunning)
+}
+
+// probeStatus returns the current NATS connectivity and server state. The
+// state is read without locking s.mu, which is held while starting.
+func (s *Service) probeStatus() probeStatus {
+	st := probeStatus{NATS: "connected", State: probeStateRunning}
+	if s.mq.IsClosed() {
+		st.NATS = "disconnected"
+	}
+	switch atomic.LoadInt32(&s.state) {
+	case serviceStopping:
+		st.State = probeStateStopping
+	case serviceStopped:
+		st.State = probeStateStopped
+	}
+	if st.State == probeStateRunning && s.maintenanceConnError() != nil {
+		st.State = probeStateMaintenance
+	}
+	return st
+}
+
+// drainProbes waits for the drain delay, letting load balancers see the
+// server as not ready before the listeners are closed.
+func (s *Service) drainProbes() {
+	if s.cfg.Probes == nil || s.cfg.Probes.DrainDelay == 0 {
+		return
+	}
+	s.Logf("Draining for %dms before stopping...", s.cfg.Probes.DrainDelay)
+	time.Sleep(time.Duration(s.cfg.Probes.DrainDelay) * time.Millisecond)
+}
+
+func (s *Service) writeProbeStatus(w http.ResponseWriter, r *http.Request, st probeStatus, ok bool) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.httpError(w, r, reserr.ErrMethodNotAllowed, s.enc)
+		return
+	}
+	code := http.StatusOK
+	st.Status = "ok"
+	if !ok {
+		code = http.StatusServiceUnavailable
+		st.Status = "unavailable"
+	}
+	out, _ := json.Marshal(st)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(out)
+}
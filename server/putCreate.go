@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// putCreateParams is the params of a create call request made for a PUT
+// request on a resource that does not exist.
+type putCreateParams struct {
+	RID    string          `json:"rid"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// putCreateParent returns the parent resource ID to call putCreateMethod on
+// for a PUT request on the resource, if the call of putMethod failed with
+// err. If no create call should be made, an empty string is returned.
+func (s *Service) putCreateParent(r *http.Request, rid string, err error) string {
+	if r.Method != "PUT" || s.cfg.PUTCreateMethod == nil || !reserr.IsError(err, reserr.CodeNotFound) {
+		return ""
+	}
+	if strings.IndexByte(rid, '?') >= 0 {
+		return ""
+	}
+	idx := strings.LastIndexByte(rid, '.')
+	if idx < 0 {
+		return ""
+	}
+	return rid[:idx]
+}
+
+// putCreate calls putCreateMethod on the parent resource, to create the
+// resource of a PUT request with the resource ID derived from the path. On
+// success, the response has status 201 with a Location header.
+func (s *Service) putCreate(w http.ResponseWriter, r *http.Request, c *wsConn, parent, rid string, params json.RawMessage, opts rpc.CallOptions, cb func([]byte, error)) {
+	enc := s.encoder(r)
+	cp := putCreateParams{RID: rid, Params: params}
+	c.CallHTTPResource(parent, *s.cfg.PUTCreateMethod, cp, opts, func(result json.RawMessage, refRID string, err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+		if refRID == "" {
+			refRID = rid
+		}
+		if result == nil {
+			s.created(w, r, c, refRID, cb)
+			return
+		}
+		out, err := enc.EncodePOST(result)
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+		c.timing.setHeader(w)
+		w.Header().Set("Location", s.cfg.ResourcePath(refRID))
+		if len(out) > 0 {
+			w.Header().Set("Content-Type", enc.ContentType())
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write(out)
+		cb(nil, errResponseDeferred)
+	})
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// QuarantineConfig holds the configuration for quarantining client IPs
+// sending repeated protocol errors, such as malformed frames or invalid
+// requests. Durations are in milliseconds.
+type QuarantineConfig struct {
+	// MaxErrors is the number of protocol errors from a client IP within the
+	// window that results in quarantine.
+	MaxErrors int `json:"maxErrors"`
+	// Window is the time within which protocol errors are counted.
+	Window int `json:"window"`
+	// Rate is the number of requests per second that a quarantined
+	// connection is throttled to. Zero means no throttling.
+	Rate float64 `json:"rate,omitempty"`
+	// BanDuration is the time a client IP is banned after reaching
+	// MaxErrors while quarantined, or on quarantine if Rate is zero. Zero
+	// means no bans.
+	BanDuration int `json:"banDuration,omitempty"`
+}
+
+// quarantineTable tracks the protocol errors by client IP.
+type quarantineTable struct {
+	QuarantineConfig
+
+	mu          sync.Mutex
+	windows     *windowTable
+	errors      uint64
+	quarantines uint64
+	bans        uint64
+	rejected    uint64
+}
+
+// quarantineAction is the action to take on a connection after a protocol
+// error.
+type quarantineAction byte
+
+const (
+	quarantineNone quarantineAction = iota
+	quarantineThrottle
+	quarantineBan
+)
+
+// quarantineConnStats is the protocol errors of a connection returned by the
+// admin endpoint.
+type quarantineConnStats struct {
+	CID         string `json:"cid"`
+	IP          string `json:"ip"`
+	Errors      int64  `json:"errors"`
+	Quarantined bool   `json:"quarantined"`
+}
+
+// quarantineStats is the state of the quarantine returned by the admin
+// endpoint.
+type quarantineStats struct {
+	Errors      uint64                `json:"errors"`
+	Quarantines uint64                `json:"quarantines"`
+	Bans        uint64                `json:"bans"`
+	Rejected    uint64                `json:"rejected"`
+	Quarantined []string              `json:"quarantined"`
+	Banned      []string              `json:"banned"`
+	Connections []quarantineConnStats `json:"connections"`
+}
+
+var errClientBanned = &reserr.Error{Code: reserr.CodeForbidden, Message: "Too many protocol errors"}
+
+// prepare validates the quarantine configuration.
+func (c *QuarantineConfig) prepare() error {
+	if c.MaxErrors < 1 {
+		return errors.New("maxErrors must be a positive number")
+	}
+	if c.Window < 1 {
+		return errors.New("window must be a positive number of milliseconds")
+	}
+	if c.Rate < 0 {
+		return errors.New("rate must be zero or a positive number of requests per second")
+	}
+	if c.BanDuration < 0 {
+		return errors.New("banDuration must be zero or a positive number of milliseconds")
+	}
+	return nil
+}
+
+// initQuarantine creates the quarantine table, if configured.
+func (s *Service) initQuarantine() {
+	if s.cfg.Quarantine == nil {
+		return
+	}
+	s.quarantine = &quarantineTable{
+		QuarantineConfig: *s.cfg.Quarantine,
+		windows:          newWindowTable(s.cfg.Quarantine.Window),
+	}
+}
+
+// banned reports whether the client IP is banned, counting it as a rejected
+// connection if so.
+func (t *quarantineTable) banned(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	e := t.windows.get(ip, now)
+	if e == nil || !e.banned(now) {
+		return false
+	}
+	t.rejected++
+	return true
+}
+
+// record counts a protocol error for the client IP, and returns the action to
+// take on the offending connection.
+func (t *quarantineTable) record(ip string) quarantineAction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.windows.sweep(now)
+	t.errors++
+	e := t.windows.add(ip, now)
+	if e.banned(now) {
+		return quarantineBan
+	}
+	e.count++
+	if e.count < t.MaxErrors {
+		if e.flagged && t.Rate > 0 {
+			return quarantineThrottle
+		}
+		return quarantineNone
+	}
+	// A quarantine lasts until a window passes without protocol errors.
+	e.count = 0
+	e.windowStart = now
+	if t.BanDuration > 0 && (e.flagged || t.Rate == 0) {
+		t.windows.ban(e, now, msDuration(t.BanDuration))
+		t.bans++
+		return quarantineBan
+	}
+	if !e.flagged {
+		e.flagged = true
+		t.quarantines++
+	}
+	if t.Rate > 0 {
+		return quarantineThrottle
+	}
+	return quarantineNone
+}
+
+// quarantineConnError returns errClientBanned if the client IP of the request
+// is banned.
+func (s *Service) quarantineConnError(r *http.Request) error {
+	if s.quarantine != nil && s.quarantine.banned(s.remoteIP(r)) {
+		return errClientBanned
+	}
+	return nil
+}
+
+// protocolError counts a protocol error for the connection, and throttles or
+// disconnects the connection if its client IP is quarantined or banned.
+// Must be called on the connection worker goroutine.
+func (c *wsConn) protocolError() {
+	if !c.persistent() {
+		return
+	}
+	atomic.AddInt64(&c.nProtocolErrors, 1)
+	t := c.serv.quarantine
+	if t == nil {
+		return
+	}
+	switch t.record(c.serv.remoteIP(c.request)) {
+	case quarantineThrottle:
+		c.mu.Lock()
+		quarantined := c.quarantined
+		c.quarantined = true
+		c.mu.Unlock()
+		if !quarantined {
+			c.setThrottle(t.Rate, 0)
+		}
+	case quarantineBan:
+		c.Disconnect("banned for protocol errors")
+	}
+}
+
+// adminQuarantineHandler returns the protocol error counters, the quarantined
+// and banned client IPs, and the connections with protocol errors.
+func (s *Service) adminQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		adminError(w, http.StatusMethodNotAllowed, reserr.ErrMethodNotAllowed)
+		return
+	}
+	st := quarantineStats{
+		Quarantined: []string{},
+		Banned:      []string{},
+		Connections: []quarantineConnStats{},
+	}
+	if t := s.quarantine; t != nil {
+		t.mu.Lock()
+		now := time.Now()
+		st.Errors = t.errors
+		st.Quarantines = t.quarantines
+		st.Bans = t.bans
+		st.Rejected = t.rejected
+		st.Banned = t.windows.list(now, func(e *windowEntry) bool { return e.banned(now) })
+		st.Quarantined = t.windows.list(now, func(e *windowEntry) bool { return e.flagged && !e.banned(now) })
+		t.mu.Unlock()
+	}
+	s.forEachConn(func(c *wsConn) {
+		n := atomic.LoadInt64(&c.nProtocolErrors)
+		if n == 0 {
+			return
+		}
+		c.mu.Lock()
+		quarantined := c.quarantined
+		c.mu.Unlock()
+		st.Connections = append(st.Connections, quarantineConnStats{
+			CID:         c.cid,
+			IP:          c.serv.remoteIP(c.request),
+			Errors:      n,
+			Quarantined: quarantined,
+		})
+	})
+	sort.Slice(st.Connections, func(i, j int) bool { return st.Connections[i].CID < st.Connections[j].CID })
+	adminResponse(w, st)
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// RateLimitConfig holds the configuration for limiting the rate of client
+// requests, per WebSocket connection, and per client IP for HTTP requests.
+// Requests exceeding the limit get a system.tooManyRequests error without
+// being passed on to the services.
+type RateLimitConfig struct {
+	// WS is the limit for requests on each WebSocket connection. Nil means
+	// no limit.
+	WS *RateLimit `json:"ws,omitempty"`
+	// HTTP is the limit for HTTP requests from each client IP, to the HTTP
+	// API, bulk call, SSE, and long-polling endpoints. Nil means no limit.
+	HTTP *RateLimit `json:"http,omitempty"`
+}
+
+// RateLimit is a token bucket rate limit.
+type RateLimit struct {
+	// Rate is the sustained number of requests allowed per second.
+	Rate float64 `json:"rate"`
+	// Burst is the number of requests allowed at once, above the rate.
+	// Defaults to the rate, rounded up.
+	Burst int `json:"burst,omitempty"`
+}
+
+// tokenBucket limits the rate of requests.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitTable holds the HTTP token buckets by client IP. With a shared
+// store, the fleet instead counts the requests of each client IP in fixed
+// windows, allowing the burst within each window of burst/rate seconds.
+// The token buckets are used if the shared store is unavailable.
+type rateLimitTable struct {
+	mu      sync.Mutex
+	limit   RateLimit
+	buckets map[string]*tokenBucket
+	swept   time.Time
+	shared  sharedCounter
+	window  time.Duration
+	logger  interface{ Errorf(string, ...interface{}) }
+}
+
+// rateLimitSweepInterval is the interval for removing the token buckets of
+// client IPs not making any requests.
+const rateLimitSweepInterval = time.Minute
+
+// prepare validates the rate limit configuration and sets default values.
+func (c *RateLimitConfig) prepare() error {
+	if c.WS != nil {
+		if err := c.WS.prepare(); err != nil {
+			return errors.New("ws " + err.Error())
+		}
+	}
+	if c.HTTP != nil {
+		if err := c.HTTP.prepare(); err != nil {
+			return errors.New("http " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (l *RateLimit) prepare() error {
+	if l.Rate <= 0 || math.IsInf(l.Rate, 0) || math.IsNaN(l.Rate) {
+		return errors.New("rate must be a positive number of requests per second")
+	}
+	if l.Burst < 0 {
+		return errors.New("burst must be zero or a positive number of requests")
+	}
+	if l.Burst == 0 {
+		l.Burst = int(math.Ceil(l.Rate))
+	}
+	return nil
+}
+
+// take takes a token from the bucket. If no token is available, it returns
+// false and the duration until one is.
+func (b *tokenBucket) take(l *RateLimit, now time.Time) (bool, time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = float64(l.Burst)
+	} else {
+		b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// full reports whether the bucket is refilled to its burst.
+func (b *tokenBucket) full(l *RateLimit, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*l.Rate >= float64(l.Burst)
+}
+
+// initRateLimit creates the HTTP rate limit table, if configured.
+func (s *Service) initRateLimit() {
+	if s.cfg.RateLimit == nil || s.cfg.RateLimit.HTTP == nil {
+		return
+	}
+	l := *s.cfg.RateLimit.HTTP
+	s.rateLimits = &rateLimitTable{
+		limit:   l,
+		buckets: make(map[string]*tokenBucket),
+		swept:   time.Now(),
+	}
+	if s.redis != nil {
+		s.rateLimits.shared = s.redis
+		s.rateLimits.logger = s
+		s.rateLimits.window = time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+		if s.rateLimits.window < time.Millisecond {
+			s.rateLimits.window = time.Millisecond
+		}
+	}
+}
+
+// tooManyRequestsError returns a system.tooManyRequests error, with the
+// number of milliseconds to wait before retrying as data.
+func tooManyRequestsError(wait time.Duration) *reserr.Error {
+	return &reserr.Error{
+		Code:    reserr.CodeTooManyRequests,
+		Message: reserr.ErrTooManyRequests.Message,
+		Data:    retryHint{RetryAfter: int64((wait + time.Millisecond - 1) / time.Millisecond)},
+	}
+}
+
+// take takes a token from the bucket of the client IP, removing the buckets
+// of idle client IPs once per sweep interval. With a shared store, the
+// request is counted in the shared window instead.
+func (t *rateLimitTable) take(ip string, now time.Time) (bool, time.Duration) {
+	if t.shared != nil {
+		ok, wait, err := t.takeShared(ip, now)
+		if err == nil {
+			return ok, wait
+		}
+		t.logger.Errorf("Error counting rate limit in shared store: %s", err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.swept) >= rateLimitSweepInterval {
+		for k, b := range t.buckets {
+			if b.full(&t.limit, now) {
+				delete(t.buckets, k)
+			}
+		}
+		t.swept = now
+	}
+	b, ok := t.buckets[ip]
+	if !ok {
+		b = &tokenBucket{}
+		t.buckets[ip] = b
+	}
+	return b.take(&t.limit, now)
+}
+
+// takeShared counts a request from the client IP in the shared window, and
+// returns false and the duration until the next window if the burst is
+// exceeded.
+func (t *rateLimitTable) takeShared(ip string, now time.Time) (bool, time.Duration, error) {
+	idx := now.UnixNano() / int64(t.window)
+	n, err := t.shared.IncrBy(SharedRateLimitPrefix+ip+":"+strconv.FormatInt(idx, 10), 1, t.window)
+	if err != nil {
+		return false, 0, err
+	}
+	if n <= int64(t.limit.Burst) {
+		return true, 0, nil
+	}
+	return false, time.Duration((idx+1)*int64(t.window) - now.UnixNano()), nil
+}
+
+// rateLimitHTTP takes a token for the client IP of the HTTP request, made to
+// any HTTP endpoint other than the WebSocket and probe endpoints. If the
+// limit is exceeded, it responds with a 429 Too Many Requests error and a
+// Retry-After header, and returns false.
+func (s *Service) rateLimitHTTP(w http.ResponseWriter, r *http.Request) bool {
+	if s.rateLimits == nil {
+		return true
+	}
+	ok, wait := s.rateLimits.take(s.remoteIP(r), time.Now())
+	if ok {
+		return true
+	}
+	s.setCommonHeaders(w, r)
+	w.Header().Set("Retry-After", strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10))
+	s.httpError(w, r, tooManyRequestsError(wait), s.encoder(r))
+	return false
+}
+
+// rateLimitError takes a token for a request on the connection, returning a
+// system.tooManyRequests error if the limit is exceeded.
+func (c *wsConn) rateLimitError() error {
+	l := c.serv.cfg.RateLimit
+	if l == nil || l.WS == nil {
+		return nil
+	}
+	c.mu.Lock()
+	ok, wait := c.limiter.take(l.WS, time.Now())
+	c.mu.Unlock()
+	if ok {
+		return nil
+	}
+	return tooManyRequestsError(wait)
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"time"
+
+	"github.com/resgateio/resgate/server/redis"
+)
+
+// sharedCounter is a counter store shared by the gateway fleet, such as
+// Redis.
+type sharedCounter interface {
+	IncrBy(key string, n int64, ttl time.Duration) (int64, error)
+}
+
+// initRedis creates the Redis client, if configured, used to share
+// idempotency responses, HTTP rate limits, and bandwidth caps across the
+// gateway fleet.
+func (s *Service) initRedis() {
+	if s.cfg.RedisURL == nil {
+		return
+	}
+	// The URL is validated by Config.prepare
+	s.redis, _ = redis.New(*s.cfg.RedisURL, RedisTimeout)
+}
+
+// stopRedis closes the Redis connection.
+func (s *Service) stopRedis() {
+	if s.redis != nil {
+		s.redis.Close()
+	}
+}
@@ -0,0 +1,260 @@
+// Package redis implements a minimal Redis client, used for sharing state
+// between the gateway instances of a fleet.
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNil is returned by Do when the reply is a nil bulk string or array.
+var ErrNil = errors.New("redis: nil reply")
+
+// Error is an error reply from the Redis server.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Client holds a single connection to a Redis server. Commands are sent one
+// at a time, and the connection is reestablished on the next command after
+// a network error.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// New creates a new Client from a URL on the format:
+//
+//	redis://[:password@]host[:port][/db]
+//
+// No connection is made until the first command.
+func New(rawurl string, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("missing host")
+	}
+	c := &Client{
+		addr:    u.Host,
+		timeout: timeout,
+	}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if p := strings.TrimPrefix(u.Path, "/"); p != "" {
+		c.db, err = strconv.Atoi(p)
+		if err != nil || c.db < 0 {
+			return nil, fmt.Errorf("invalid database %q", p)
+		}
+	}
+	return c, nil
+}
+
+// Do sends a command and returns the reply. Replies are of type string for
+// simple and bulk strings, int64 for integers, and []interface{} for arrays.
+// A nil reply returns ErrNil, and an error reply returns an Error.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	v, err := c.do(args)
+	if err != nil {
+		if _, ok := err.(Error); !ok && err != ErrNil {
+			c.close()
+		}
+	}
+	return v, err
+}
+
+// Get returns the value of a key, or nil if the key does not exist.
+func (c *Client) Get(key string) ([]byte, error) {
+	v, err := c.Do("GET", key)
+	if err == ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(v.(string)), nil
+}
+
+// Set sets the value of a key, expiring after ttl.
+func (c *Client) Set(key string, value []byte, ttl time.Duration) error {
+	_, err := c.Do("SET", key, string(value), "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	return err
+}
+
+// SetNX sets the value of a key, expiring after ttl, only if the key does
+// not already exist. Returns false if the key exists.
+func (c *Client) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	_, err := c.Do("SET", key, string(value), "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10), "NX")
+	if err == ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IncrBy increments the integer value of a key by n, and returns the new
+// value. A key created by the increment expires after ttl.
+func (c *Client) IncrBy(key string, n int64, ttl time.Duration) (int64, error) {
+	v, err := c.Do("INCRBY", key, strconv.FormatInt(n, 10))
+	if err != nil {
+		return 0, err
+	}
+	total, ok := v.(int64)
+	if !ok {
+		return 0, errors.New("redis: invalid reply")
+	}
+	if total == n {
+		ms := int64((ttl + time.Millisecond - 1) / time.Millisecond)
+		if _, err := c.Do("PEXPIRE", key, strconv.FormatInt(ms, 10)); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Del removes a key.
+func (c *Client) Del(key string) error {
+	_, err := c.Do("DEL", key)
+	return err
+}
+
+// Close closes the connection.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.close()
+}
+
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	c.w = bufio.NewWriter(conn)
+	if c.password != "" {
+		if _, err := c.do([]string{"AUTH", c.password}); err != nil {
+			c.close()
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.do([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+		c.r = nil
+		c.w = nil
+	}
+}
+
+func (c *Client) do(args []string) (interface{}, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	c.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		c.w.WriteString("$" + strconv.Itoa(len(a)) + "\r\n")
+		c.w.WriteString(a)
+		c.w.WriteString("\r\n")
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", errors.New("redis: invalid reply")
+	}
+	return line[:len(line)-2], nil
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		vs := make([]interface{}, n)
+		for i := range vs {
+			vs[i], err = readReply(r)
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+		}
+		return vs, nil
+	}
+	return nil, errors.New("redis: invalid reply")
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// errAuthRequired is returned for get, subscribe, call, and new requests on
+// connections without a token, when requireAuth is set.
+var errAuthRequired = &reserr.Error{Code: reserr.CodeAccessDenied, Message: "Authentication required"}
+
+// authRequiredError returns errAuthRequired if authentication is required
+// and the connection has no token, otherwise nil. A token is set by header
+// authentication or an auth request handled by an auth service.
+func (c *wsConn) authRequiredError() error {
+	if !c.serv.cfg.RequireAuth {
+		return nil
+	}
+	if len(c.token) == 0 || string(c.token) == string(nullBytes) {
+		return errAuthRequired
+	}
+	return nil
+}
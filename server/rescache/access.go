@@ -2,13 +2,18 @@ package rescache
 
 import (
 	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/filter"
 	"github.com/resgateio/resgate/server/reserr"
 )
 
 // Access represents a RES-service access response
 type Access struct {
 	*codec.AccessResult
+	Tags  codec.Tags
 	Error *reserr.Error
+	// EventFilter is the parsed filter expression of the access result, or
+	// nil if no filter is set.
+	EventFilter *filter.Expr
 }
 
 // CanGet reports whether get access is granted.
@@ -0,0 +1,122 @@
+package rescache
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// CanaryRoute routes a percentage of get and call requests for resources
+// matching a pattern to an alternate subject prefix.
+type CanaryRoute struct {
+	Pattern ResourcePattern
+	Prefix  string
+	Percent float64
+
+	// PercentFunc, if set, returns the percentage to use for a request,
+	// instead of Percent. The token is nil for get requests.
+	PercentFunc func(rname string, token interface{}) float64
+
+	primary canaryCounter
+	canary  canaryCounter
+}
+
+// CanaryStats holds request counters for a canary route variant.
+type CanaryStats struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+}
+
+type canaryCounter struct {
+	requests uint64
+	errors   uint64
+}
+
+// NewCanaryRoute creates a new canary route. Requests for resources matching
+// the pattern are, with the given percentage, routed to subjects with the
+// prefix inserted after the request type. Eg. "call.v2.example.model.method"
+func NewCanaryRoute(pattern ResourcePattern, prefix string, percent float64) *CanaryRoute {
+	return &CanaryRoute{
+		Pattern: pattern,
+		Prefix:  prefix,
+		Percent: percent,
+	}
+}
+
+// Stats returns the request counters for the primary and canary variants.
+func (r *CanaryRoute) Stats() (primary CanaryStats, canary CanaryStats) {
+	return r.primary.stats(), r.canary.stats()
+}
+
+func (cc *canaryCounter) stats() CanaryStats {
+	return CanaryStats{
+		Requests: atomic.LoadUint64(&cc.requests),
+		Errors:   atomic.LoadUint64(&cc.errors),
+	}
+}
+
+// done updates the counters with the outcome of a request.
+// It is safe to call on a nil counter.
+func (cc *canaryCounter) done(err error) {
+	if cc == nil {
+		return
+	}
+	atomic.AddUint64(&cc.requests, 1)
+	if err != nil {
+		atomic.AddUint64(&cc.errors, 1)
+	}
+}
+
+// doneResponse updates the counters with the outcome of a request, treating
+// an error response payload as an error.
+// It is safe to call on a nil counter.
+func (cc *canaryCounter) doneResponse(data []byte, err error) {
+	if cc == nil {
+		return
+	}
+	if err == nil {
+		var r struct {
+			Error *reserr.Error `json:"error"`
+		}
+		if json.Unmarshal(data, &r) != nil || r.Error != nil {
+			err = reserr.ErrInternalError
+		}
+	}
+	cc.done(err)
+}
+
+// SetCanaryRoutes sets the canary routes used for get and call requests.
+// The first route matching a resource is used. It must be called before
+// Start.
+func (c *Cache) SetCanaryRoutes(routes []*CanaryRoute) {
+	c.canaryRoutes = routes
+}
+
+// routeSubject returns the subject for a request of type typ, for the resource
+// rname, with an optional suffix, such as ".method", made on behalf of token.
+// If the resource matches a canary route, the subject may be routed to the
+// canary prefix. The returned counter, which is nil if no route matches,
+// should be updated with the outcome of the request.
+func (c *Cache) routeSubject(typ, rname, suffix string, token interface{}) (string, *canaryCounter) {
+	for _, r := range c.canaryRoutes {
+		if !r.Pattern.Match(rname) {
+			continue
+		}
+		if sample(r.Percent, r.PercentFunc, rname, token) {
+			return typ + "." + r.Prefix + "." + rname + suffix, &r.canary
+		}
+		return typ + "." + rname + suffix, &r.primary
+	}
+	return typ + "." + rname + suffix, nil
+}
+
+// sample reports whether a request should be sampled, using the percentage
+// returned by f if set, or else percent.
+func sample(percent float64, f func(rname string, token interface{}) float64, rname string, token interface{}) bool {
+	if f != nil {
+		percent = f(rname, token)
+	}
+	return rand.Float64()*100 < percent
+}
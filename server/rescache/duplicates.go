@@ -0,0 +1,59 @@
+package rescache
+
+import (
+	"sync/atomic"
+
+	"github.com/resgateio/resgate/server/codec"
+)
+
+// SuppressedStats holds the number of events suppressed for not changing the
+// cached resource state, or for being duplicates of events already received.
+type SuppressedStats struct {
+	Change    uint64 `json:"change"`
+	Add       uint64 `json:"add"`
+	Duplicate uint64 `json:"duplicate"`
+}
+
+type suppressedCounter struct {
+	change    uint64
+	add       uint64
+	duplicate uint64
+}
+
+// SetUniqueCollections sets the resource patterns of collections holding
+// unique values. An add event for such a collection, with a value already in
+// the collection, is suppressed.
+// Must be called before Start.
+func (c *Cache) SetUniqueCollections(patterns []ResourcePattern) {
+	c.uniqueCollections = patterns
+}
+
+// SuppressedEvents returns the number of suppressed events by event type.
+func (c *Cache) SuppressedEvents() SuppressedStats {
+	return SuppressedStats{
+		Change:    atomic.LoadUint64(&c.suppressed.change),
+		Add:       atomic.LoadUint64(&c.suppressed.add),
+		Duplicate: atomic.LoadUint64(&c.suppressed.duplicate),
+	}
+}
+
+// isUniqueCollection reports whether the collection matches any of the
+// unique collection patterns.
+func (c *Cache) isUniqueCollection(rname string) bool {
+	for _, p := range c.uniqueCollections {
+		if p.Match(rname) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsValue reports whether the value is in the collection values.
+func containsValue(vals []codec.Value, v codec.Value) bool {
+	for _, cv := range vals {
+		if cv.Equal(v) {
+			return true
+		}
+	}
+	return false
+}
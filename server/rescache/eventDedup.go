@@ -0,0 +1,132 @@
+package rescache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jirenius/timerqueue"
+)
+
+// eventDedup holds the event IDs received within the deduplication window.
+type eventDedup struct {
+	mu    sync.Mutex
+	ids   map[string]struct{}
+	queue *timerqueue.Queue
+}
+
+// SetEventDedupWindow sets the duration an event ID is remembered for a
+// resource. A resource event with an ID already received for the resource
+// within the window is dropped. A zero duration disables deduplication.
+// Must be called before Start.
+func (c *Cache) SetEventDedupWindow(window time.Duration) {
+	if window <= 0 {
+		c.dedup = nil
+		return
+	}
+	d := &eventDedup{ids: make(map[string]struct{})}
+	d.queue = timerqueue.New(d.expire, window)
+	c.dedup = d
+}
+
+// isDuplicate reports whether the event ID has already been received for
+// the resource within the window. If not, the ID is stored.
+func (d *eventDedup) isDuplicate(rname, id string) bool {
+	key := rname + " " + id
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.ids[key]; ok {
+		return true
+	}
+	d.ids[key] = struct{}{}
+	d.queue.Add(key)
+	return false
+}
+
+// expire removes an event ID once its window has passed.
+func (d *eventDedup) expire(v interface{}) {
+	d.mu.Lock()
+	delete(d.ids, v.(string))
+	d.mu.Unlock()
+}
+
+// eventMeta holds the meta data a service may include in a resource event
+// payload, as the meta property of the event object, such as:
+//
+//	{"values":{"foo":"bar"},"meta":{"id":"e7f2","seq":42}}
+//
+// A zero Seq means the event has no sequence number.
+type eventMeta struct {
+	ID  string `json:"id"`
+	Seq uint64 `json:"seq"`
+}
+
+var metaKey = []byte(`"meta"`)
+
+// hasEventMeta reports whether events of the cache may carry meta data,
+// being the case for change, add, and remove events when either event
+// deduplication or sequence checking is enabled. Other events are handled
+// with the payload as is.
+func (c *Cache) hasEventMeta(event string) bool {
+	if c.dedup == nil && !c.sequenceCheck {
+		return false
+	}
+	switch event {
+	case "change", "add", "remove":
+		return true
+	}
+	return false
+}
+
+// splitEventMeta splits a resource event payload into its meta data and the
+// event data, with the meta property removed. If the payload is not a JSON
+// object with a meta property, the meta data is empty and the payload is
+// returned as is. An error is returned if the meta property is malformed, or
+// if seq is not a positive integer, together with the event data.
+func splitEventMeta(payload []byte) (eventMeta, []byte, error) {
+	var m eventMeta
+	if !bytes.Contains(payload, metaKey) {
+		return m, payload, nil
+	}
+	var ev map[string]json.RawMessage
+	if json.Unmarshal(payload, &ev) != nil {
+		return m, payload, nil
+	}
+	raw, ok := ev["meta"]
+	if !ok {
+		return m, payload, nil
+	}
+	delete(ev, "meta")
+	data, _ := json.Marshal(ev)
+	var v struct {
+		ID  string  `json:"id"`
+		Seq *uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return m, data, fmt.Errorf("malformed meta %s", raw)
+	}
+	if v.Seq != nil {
+		if *v.Seq == 0 {
+			return m, data, fmt.Errorf("malformed meta %s: seq must be a positive integer", raw)
+		}
+		m.Seq = *v.Seq
+	}
+	m.ID = v.ID
+	return m, data, nil
+}
+
+// isDuplicate reports whether the event should be dropped for having an ID
+// already received for the resource.
+func (c *Cache) isDuplicate(rname, id string) bool {
+	if id == "" || c.dedup == nil {
+		return false
+	}
+	if c.dedup.isDuplicate(rname, id) {
+		atomic.AddUint64(&c.suppressed.duplicate, 1)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package rescache
+
+import (
+	"sync/atomic"
+)
+
+// SequenceStats holds the number of resource events not applied for being
+// out of sequence.
+type SequenceStats struct {
+	Gaps  uint64 `json:"gaps"`
+	Stale uint64 `json:"stale"`
+}
+
+type sequenceCounter struct {
+	gaps  uint64
+	stale uint64
+}
+
+// SetEventSequence sets whether the sequence numbers of resource events are
+// checked. Must be called before Start.
+func (c *Cache) SetEventSequence(enabled bool) {
+	c.sequenceCheck = enabled
+}
+
+// SequenceEvents returns the number of out of sequence events by cause.
+func (c *Cache) SequenceEvents() SequenceStats {
+	return SequenceStats{
+		Gaps:  atomic.LoadUint64(&c.sequence.gaps),
+		Stale: atomic.LoadUint64(&c.sequence.stale),
+	}
+}
+
+// inSequence reports whether an event with the sequence number should be
+// applied. An event with a sequence number not greater than the last one
+// received is stale and not applied. An event skipping sequence numbers is
+// not applied, and the resource is refetched instead.
+// A zero sequence number, set for events without one, is always in sequence,
+// as is any event if sequence checking is disabled.
+// Must be called from within the event queue.
+func (e *EventSubscription) inSequence(subj string, n uint64) bool {
+	if n == 0 || !e.cache.sequenceCheck {
+		return true
+	}
+	switch {
+	case e.seq == 0 || n == e.seq+1:
+		e.seq = n
+		return true
+	case n <= e.seq:
+		atomic.AddUint64(&e.cache.sequence.stale, 1)
+		e.cache.Logf("Stale event %s with sequence number %d, after %d", subj, n, e.seq)
+		return false
+	}
+	atomic.AddUint64(&e.cache.sequence.gaps, 1)
+	e.cache.Logf("Sequence gap for event %s with sequence number %d, after %d: refetching %s", subj, n, e.seq, e.ResourceName)
+	e.seq = n
+	e.resetResource()
+	return false
+}
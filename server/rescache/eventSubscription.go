@@ -2,6 +2,8 @@ package rescache
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/mq"
@@ -15,6 +17,7 @@ type ResourceType byte
 const (
 	TypeCollection ResourceType = ResourceType(stateCollection)
 	TypeModel      ResourceType = ResourceType(stateModel)
+	TypeStream     ResourceType = ResourceType(stateStream)
 	TypeError      ResourceType = ResourceType(stateError)
 )
 
@@ -25,13 +28,18 @@ type EventSubscription struct {
 	cache        *Cache
 
 	// Protected by cache mutex
-	mqSub mq.Unsubscriber
-	count int64
+	mqSub      mq.Unsubscriber
+	count      int64
+	subscribed time.Time // Time the mq subscription was made
+
+	// Atomic
+	events uint64 // Number of events received since subscribed
 
 	// Protected by single goroutine
 	base    *ResourceSubscription
 	queries map[string]*ResourceSubscription
 	links   map[string]*ResourceSubscription
+	seq     uint64 // Last event sequence number, or 0 if none received
 
 	// Mutex protected
 	mu    sync.Mutex
@@ -83,9 +91,12 @@ func (e *EventSubscription) addSubscriber(sub Subscriber) {
 			// Progress state
 			rs.state = stateRequested
 			// Create request
-			subj := "get." + e.ResourceName
+			subj, cc := e.cache.routeSubject("get", e.ResourceName, "", nil)
 			payload := codec.CreateGetRequest(q)
+			compare := e.cache.mirror("get", e.ResourceName, "", nil, payload)
 			e.cache.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
+				cc.doneResponse(data, err)
+				compare(data, err)
 				rs.enqueueGetResponse(data, err)
 			})
 
@@ -102,7 +113,7 @@ func (e *EventSubscription) addSubscriber(sub Subscriber) {
 			defer e.mu.Lock()
 			sub.Loaded(nil, rs.err)
 
-		// stateModel or stateCollection
+		// stateModel, stateCollection, or stateStream
 		default:
 			e.mu.Unlock()
 			defer e.mu.Lock()
@@ -125,7 +136,7 @@ func (e *EventSubscription) Enqueue(f func()) {
 	// assigned to the event subscription, so we pass it to one.
 	// This only applies if no locks are active
 	if locks == nil && count == 0 {
-		e.cache.inCh <- e
+		e.cache.enqueue(e)
 	}
 }
 
@@ -139,7 +150,7 @@ func (e *EventSubscription) enqueueUnlock(f func()) {
 	e.mu.Unlock()
 
 	if count == 0 {
-		e.cache.inCh <- e
+		e.cache.enqueue(e)
 	}
 }
 
@@ -214,10 +225,26 @@ func (e *EventSubscription) removeCount(n int64) {
 }
 
 func (e *EventSubscription) enqueueEvent(subj string, payload []byte) {
+	atomic.AddUint64(&e.events, 1)
+	idx := len(e.ResourceName) + 7 // Length of "event." + "."
+	var meta eventMeta
+	if idx < len(subj) && e.cache.hasEventMeta(subj[idx:]) {
+		var err error
+		meta, payload, err = splitEventMeta(payload)
+		if err != nil {
+			e.cache.resourceErrorf(e.ResourceName, "Error processing event %s: %s", subj, err)
+		}
+		if e.cache.isDuplicate(e.ResourceName, meta.ID) {
+			return
+		}
+	}
 	e.Enqueue(func() {
-		idx := len(e.ResourceName) + 7 // Length of "event." + "."
+		if !e.inSequence(subj, meta.Seq) {
+			return
+		}
+
 		if idx >= len(subj) {
-			e.cache.Errorf("Error processing event %s: malformed event subject", subj)
+			e.cache.resourceErrorf(e.ResourceName, "Error processing event %s: malformed event subject", subj)
 			return
 		}
 
@@ -235,7 +262,7 @@ func (e *EventSubscription) enqueueEvent(subj string, payload []byte) {
 
 			ev, err := codec.DecodeEvent(payload)
 			if err != nil {
-				e.cache.Errorf("Error processing event %s: malformed payload %s", subj, payload)
+				e.cache.resourceErrorf(e.ResourceName, "Error processing event %s: malformed payload %s", subj, payload)
 				return
 			}
 
@@ -252,12 +279,12 @@ func (e *EventSubscription) handleQueryEvent(subj string, payload []byte) {
 
 	qe, err := codec.DecodeQueryEvent(payload)
 	if err != nil {
-		e.cache.Errorf("Error processing event %s: malformed payload %s", subj, payload)
+		e.cache.resourceErrorf(e.ResourceName, "Error processing event %s: malformed payload %s", subj, payload)
 		return
 	}
 
 	if qe.Subject == "" {
-		e.cache.Errorf("Missing subject in event %s: %s", subj, payload)
+		e.cache.resourceErrorf(e.ResourceName, "Missing subject in event %s: %s", subj, payload)
 		return
 	}
 
@@ -286,7 +313,7 @@ func (e *EventSubscription) handleQueryEvent(subj string, payload []byte) {
 					if reserr.IsError(err, reserr.CodeNotFound) {
 						rs.handleEvent(&ResourceEvent{Event: "delete"})
 					} else {
-						e.cache.Errorf("Error processing query event for %s?%s: %s", e.ResourceName, rs.query, err)
+						e.cache.resourceErrorf(e.ResourceName, "Error processing query event for %s?%s: %s", e.ResourceName, rs.query, err)
 					}
 					return
 				}
@@ -300,14 +327,14 @@ func (e *EventSubscription) handleQueryEvent(subj string, payload []byte) {
 				// Handle model response
 				case result.Model != nil:
 					if rs.state != stateModel {
-						e.cache.Errorf("Error processing query event for %s?%s: non-model payload on model %s", e.ResourceName, rs.query, data)
+						e.cache.resourceErrorf(e.ResourceName, "Error processing query event for %s?%s: non-model payload on model %s", e.ResourceName, rs.query, data)
 						return
 					}
 					rs.processResetModel(result.Model)
 				// Handle collection response
 				case result.Collection != nil:
 					if rs.state != stateCollection {
-						e.cache.Errorf("Error processing query event for %s?%s: non-model payload on model %s", e.ResourceName, rs.query, data)
+						e.cache.resourceErrorf(e.ResourceName, "Error processing query event for %s?%s: non-model payload on model %s", e.ResourceName, rs.query, data)
 						return
 					}
 					rs.processResetCollection(result.Collection)
@@ -338,7 +365,7 @@ func (e *EventSubscription) mqUnsubscribe() bool {
 	if e.mqSub != nil {
 		err := e.mqSub.Unsubscribe()
 		if err != nil {
-			e.cache.Errorf("Error unsubscribing to %s: %s", e.ResourceName, err)
+			e.cache.resourceErrorf(e.ResourceName, "Error unsubscribing to %s: %s", e.ResourceName, err)
 			return false
 		}
 	}
@@ -347,16 +374,24 @@ func (e *EventSubscription) mqUnsubscribe() bool {
 
 func (e *EventSubscription) handleResetResource() {
 	e.Enqueue(func() {
-		if e.base != nil && e.base.query == "" {
-			e.base.handleResetResource()
-		}
-
-		for _, rs := range e.queries {
-			rs.handleResetResource()
-		}
+		// The service may restart its sequence numbers on reset
+		e.seq = 0
+		e.resetResource()
 	})
 }
 
+// resetResource refetches the base resource and all query resources.
+// Must be called from within the event queue.
+func (e *EventSubscription) resetResource() {
+	if e.base != nil && e.base.query == "" {
+		e.base.handleResetResource()
+	}
+
+	for _, rs := range e.queries {
+		rs.handleResetResource()
+	}
+}
+
 func (e *EventSubscription) handleResetAccess() {
 	e.Enqueue(func() {
 		if e.base != nil && e.base.query == "" {
@@ -0,0 +1,41 @@
+package rescache
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// NeverUpdated describes a resource subscribed to for events without any
+// event received.
+type NeverUpdated struct {
+	ResourceName string
+	Subscribed   time.Time
+	Count        int64
+}
+
+// NeverUpdated returns the resources that have been subscribed to for events
+// for at least the duration, without any event received, sorted by
+// resource name. Count is the number of subscriptions and pending requests
+// for the resource. Resources with no subscriptions left are not included.
+func (c *Cache) NeverUpdated(d time.Duration) []NeverUpdated {
+	before := time.Now().Add(-d)
+	c.mu.Lock()
+	nus := make([]NeverUpdated, 0)
+	for name, e := range c.eventSubs {
+		if e.mqSub == nil || e.subscribed.After(before) || atomic.LoadUint64(&e.events) > 0 {
+			continue
+		}
+		e.mu.Lock()
+		count := e.count
+		e.mu.Unlock()
+		// Subscriptions awaiting unsubscribe are left out
+		if count > 0 {
+			nus = append(nus, NeverUpdated{ResourceName: name, Subscribed: e.subscribed, Count: count})
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(nus, func(i, j int) bool { return nus[i].ResourceName < nus[j].ResourceName })
+	return nus
+}
@@ -0,0 +1,181 @@
+package rescache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/outbox"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var errOutboxDisabled = errors.New("cache: no outbox set")
+
+type scheduledCall struct {
+	timer *time.Timer
+	token []byte
+}
+
+// SetOutbox sets the outbox used to store call requests made with an
+// idempotency key until a response is received, and to store scheduled
+// call requests.
+func (c *Cache) SetOutbox(o *outbox.Outbox) {
+	c.outbox = o
+	c.scheduled = make(map[string]*scheduledCall)
+}
+
+// SetOutboxLeader sets a function reporting whether the instance is the
+// leader sending the scheduled calls of an outbox shared with other
+// instances. While it returns false, scheduled calls are stored without
+// being scheduled, to be acquired by the leader when resending the outbox.
+// It must be called before Start.
+func (c *Cache) SetOutboxLeader(f func() bool) {
+	c.outboxLeader = f
+}
+
+// ResendOutbox sends all call requests in the outbox that are not awaiting
+// a response. Requests are removed from the outbox once a response, other
+// than a timeout, is received. Scheduled requests are sent once their
+// send time is reached.
+func (c *Cache) ResendOutbox() {
+	if c.outbox == nil {
+		return
+	}
+	entries, err := c.outbox.Acquire()
+	if err != nil {
+		c.Errorf("Error reading outbox: %s", err)
+	}
+	now := time.Now()
+	for _, e := range entries {
+		if e.SendAt != nil && e.SendAt.After(now) {
+			c.schedule(e)
+		} else {
+			c.sendOutboxEntry(e)
+		}
+	}
+}
+
+// ScheduleCall stores a call request in the outbox, to be sent at the given
+// time on behalf of the token. The returned ID may be used with CancelCall.
+func (c *Cache) ScheduleCall(req codec.Requester, rname, query, action string, meta codec.CallMeta, token, params interface{}, at time.Time) (string, error) {
+	if c.outbox == nil {
+		return "", errOutboxDisabled
+	}
+	payload := codec.CreateCallRequest(params, req, query, token, meta)
+	subj := "call." + rname + "." + action
+	id, err := c.outbox.Schedule(subj, payload, at)
+	if err != nil {
+		c.Errorf("Error storing scheduled %s request in outbox: %s", subj, err)
+		return "", reserr.InternalError(err)
+	}
+	if c.outboxLeader != nil && !c.outboxLeader() {
+		c.outbox.Release(id)
+		return id, nil
+	}
+	c.schedule(&outbox.Entry{ID: id, Subject: subj, Payload: payload, SendAt: &at})
+	return id, nil
+}
+
+// CancelCall cancels a scheduled call request not yet sent. The token must
+// be the same as the one the call was scheduled with. A call scheduled by
+// another instance sharing the outbox is removed from the outbox, for that
+// instance not to send it.
+func (c *Cache) CancelCall(id string, token interface{}) error {
+	tok, _ := json.Marshal(token)
+
+	c.outboxMu.Lock()
+	sc, ok := c.scheduled[id]
+	if ok {
+		if !bytes.Equal(sc.token, tok) {
+			c.outboxMu.Unlock()
+			return reserr.ErrNotFound
+		}
+		sc.timer.Stop()
+		delete(c.scheduled, id)
+	}
+	c.outboxMu.Unlock()
+
+	if !ok {
+		e, err := c.outbox.Get(id)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return reserr.ErrNotFound
+			}
+			c.Errorf("Error reading scheduled request from outbox: %s", err)
+			return reserr.InternalError(err)
+		}
+		if e.SendAt == nil || !bytes.Equal(payloadToken(e.Payload), tok) {
+			return reserr.ErrNotFound
+		}
+	}
+
+	if err := c.outbox.Remove(id); err != nil {
+		c.Errorf("Error removing scheduled request from outbox: %s", err)
+		return reserr.InternalError(err)
+	}
+	return nil
+}
+
+// payloadToken returns the token of a request payload, encoded as JSON.
+func payloadToken(payload []byte) []byte {
+	var r struct {
+		Token json.RawMessage `json:"token"`
+	}
+	json.Unmarshal(payload, &r)
+	tok, _ := json.Marshal(r.Token)
+	return tok
+}
+
+func (c *Cache) schedule(e *outbox.Entry) {
+	c.outboxMu.Lock()
+	defer c.outboxMu.Unlock()
+	c.scheduled[e.ID] = &scheduledCall{
+		token: payloadToken(e.Payload),
+		timer: time.AfterFunc(time.Until(*e.SendAt), func() {
+			c.outboxMu.Lock()
+			_, ok := c.scheduled[e.ID]
+			delete(c.scheduled, e.ID)
+			c.outboxMu.Unlock()
+			if !ok {
+				return
+			}
+			// The call may have been canceled on another instance.
+			if !c.outbox.Exists(e.ID) {
+				c.outbox.Release(e.ID)
+				return
+			}
+			c.sendOutboxEntry(e)
+		}),
+	}
+}
+
+// stopScheduled stops all scheduled call timers. The requests remain in the
+// outbox to be scheduled again on next start.
+func (c *Cache) stopScheduled() {
+	c.outboxMu.Lock()
+	defer c.outboxMu.Unlock()
+	for id, sc := range c.scheduled {
+		sc.timer.Stop()
+		c.outbox.Release(id)
+		delete(c.scheduled, id)
+	}
+}
+
+func (c *Cache) sendOutboxEntry(e *outbox.Entry) {
+	c.mq.SendRequest(e.Subject, e.Payload, func(_ string, _ []byte, err error) {
+		c.outboxResponse(e.ID, e.Subject, err)
+	})
+}
+
+func (c *Cache) outboxResponse(id, subj string, err error) {
+	if reserr.IsError(err, reserr.CodeTimeout) {
+		c.outbox.Release(id)
+		return
+	}
+	if err := c.outbox.Remove(id); err != nil {
+		c.Errorf("Error removing %s request from outbox: %s", subj, err)
+	}
+}
@@ -0,0 +1,28 @@
+package rescache
+
+import "time"
+
+// PollRule sets an interval for refetching cached resources matching a
+// pattern, for services unable to send events on changes.
+type PollRule struct {
+	Pattern  ResourcePattern
+	Interval time.Duration
+}
+
+// SetPollRules sets the rules for polling resources. A resource is polled
+// using the interval of the first matching rule, unless the get response
+// has a ttl.
+func (c *Cache) SetPollRules(rules []PollRule) {
+	c.pollRules = rules
+}
+
+// pollInterval returns the interval of the first poll rule matching the
+// resource, or 0 if none matches.
+func (c *Cache) pollInterval(rname string) time.Duration {
+	for _, r := range c.pollRules {
+		if r.Pattern.Match(rname) {
+			return r.Interval
+		}
+	}
+	return 0
+}
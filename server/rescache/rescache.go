@@ -10,7 +10,9 @@ import (
 	"github.com/jirenius/timerqueue"
 	"github.com/resgateio/resgate/logger"
 	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/filter"
 	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/outbox"
 	"github.com/resgateio/resgate/server/reserr"
 )
 
@@ -25,9 +27,30 @@ type Cache struct {
 	started    bool
 	eventSubs  map[string]*EventSubscription
 	inCh       chan *EventSubscription
+	stopCh     chan struct{}
 	unsubQueue *timerqueue.Queue
 	resetSub   mq.Unsubscriber
 
+	systemHandler func(event string, payload []byte)
+	accessReset   func(rname string)
+	errorReporter func(rid string, msg string)
+	canaryRoutes  []*CanaryRoute
+	shadowRoutes  []*ShadowRoute
+	pollRules     []PollRule
+
+	// Suppression of events not changing the resource state
+	uniqueCollections []ResourcePattern
+	suppressed        suppressedCounter
+	dedup             *eventDedup
+	sequenceCheck     bool
+	sequence          sequenceCounter
+
+	// Outbox for call requests
+	outbox       *outbox.Outbox
+	outboxMu     sync.Mutex
+	scheduled    map[string]*scheduledCall
+	outboxLeader func() bool
+
 	// Deprecated behavior logging
 	depMutex  sync.Mutex
 	depLogged map[string]featureType
@@ -36,6 +59,7 @@ type Cache struct {
 // Subscriber interface represents a subscription made on a client connection
 type Subscriber interface {
 	CID() string
+	Capabilities() *codec.Capabilities
 	Loaded(resourceSub *ResourceSubscription, err error)
 	Event(event *ResourceEvent)
 	ResourceName() string
@@ -48,6 +72,7 @@ type ResourceEvent struct {
 	Event     string
 	Payload   json.RawMessage
 	Idx       int
+	Offset    int64
 	Value     codec.Value
 	Changed   map[string]codec.Value
 	OldValues map[string]codec.Value
@@ -69,6 +94,25 @@ func (c *Cache) SetLogger(l logger.Logger) {
 	c.logger = l
 }
 
+// SetSystemEventHandler sets a handler for system events not handled by
+// the cache. It must be called before Start.
+func (c *Cache) SetSystemEventHandler(h func(event string, payload []byte)) {
+	c.systemHandler = h
+}
+
+// SetAccessResetHandler sets a handler called with the resource name of each
+// subscribed resource that has its access reset, before the subscribers are
+// notified. It must be called before Start.
+func (c *Cache) SetAccessResetHandler(h func(rname string)) {
+	c.accessReset = h
+}
+
+// SetErrorReporter sets a function called for each logged error, with the
+// resource ID the error concerns, if any. It must be called before Start.
+func (c *Cache) SetErrorReporter(f func(rid string, msg string)) {
+	c.errorReporter = f
+}
+
 // Start will initialize the cache, subscribing to global events
 // It is assumed mq.Connect has already been called
 func (c *Cache) Start() error {
@@ -78,10 +122,12 @@ func (c *Cache) Start() error {
 	inCh := make(chan *EventSubscription, 100)
 	c.eventSubs = make(map[string]*EventSubscription)
 	c.unsubQueue = timerqueue.New(c.mqUnsubscribe, c.unsubscribeDelay)
+	stopCh := make(chan struct{})
 	c.inCh = inCh
+	c.stopCh = stopCh
 
 	for i := 0; i < c.workers; i++ {
-		go c.startWorker(inCh)
+		go c.startWorker(inCh, stopCh)
 	}
 
 	resetSub, err := c.mq.Subscribe("system", func(subj string, payload []byte, _ error) {
@@ -89,6 +135,10 @@ func (c *Cache) Start() error {
 		switch ev {
 		case "reset":
 			c.handleSystemReset(payload)
+		default:
+			if c.systemHandler != nil {
+				c.systemHandler(ev, payload)
+			}
 		}
 	})
 	if err != nil {
@@ -108,7 +158,17 @@ func (c *Cache) Logf(format string, v ...interface{}) {
 
 // Errorf writes a formatted log message
 func (c *Cache) Errorf(format string, v ...interface{}) {
-	c.logger.Error(fmt.Sprintf(format, v...))
+	c.resourceErrorf("", format, v...)
+}
+
+// resourceErrorf writes a formatted log message for an error concerning the
+// resource rid, and passes it to the error reporter.
+func (c *Cache) resourceErrorf(rid string, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	c.logger.Error(msg)
+	if c.errorReporter != nil {
+		c.errorReporter(rid, msg)
+	}
 }
 
 // Subscribe fetches a resource from the cache, and if it is
@@ -134,16 +194,48 @@ func (c *Cache) Access(sub Subscriber, token interface{}, callback func(access *
 			return
 		}
 
-		access, rerr := codec.DecodeAccessResponse(data)
-		callback(&Access{AccessResult: access, Error: rerr})
+		access, tags, rerr := codec.DecodeAccessResponse(data)
+		a := &Access{AccessResult: access, Tags: tags, Error: rerr}
+		if access != nil && access.Filter != "" {
+			f, err := filter.Parse(access.Filter)
+			if err != nil {
+				c.Errorf("Invalid filter in access response for %s: %s", rname, err)
+				a = &Access{Error: reserr.InternalError(fmt.Errorf("invalid access filter: %s", err))}
+			} else {
+				a.EventFilter = f
+			}
+		}
+		callback(a)
 	})
 }
 
 // Call sends a method call request
-func (c *Cache) Call(req codec.Requester, rname, query, action string, token, params interface{}, callback func(result json.RawMessage, rid string, err error)) {
-	payload := codec.CreateRequest(params, req, query, token)
-	subj := "call." + rname + "." + action
+func (c *Cache) Call(req codec.Requester, rname, query, action string, meta codec.CallMeta, token, params interface{}, callback func(result json.RawMessage, rid string, err error)) {
+	payload := codec.CreateCallRequest(params, req, query, token, meta)
+	subj, cc := c.routeSubject("call", rname, "."+action, token)
+	var id string
+	if meta.IdempotencyKey != "" && c.outbox != nil {
+		var err error
+		id, err = c.outbox.Add(subj, payload)
+		if err != nil {
+			c.Errorf("Error storing %s request in outbox: %s", subj, err)
+			callback(nil, "", reserr.InternalError(err))
+			return
+		}
+	}
+	if cc != nil {
+		cb := callback
+		callback = func(result json.RawMessage, rid string, err error) {
+			cc.done(err)
+			cb(result, rid, err)
+		}
+	}
+	compare := c.mirror("call", rname, "."+action, token, payload)
 	c.sendRequest(rname, subj, payload, func(data []byte, err error) {
+		compare(data, err)
+		if id != "" {
+			c.outboxResponse(id, subj, err)
+		}
 		if err != nil {
 			callback(nil, "", err)
 			return
@@ -169,16 +261,30 @@ func (c *Cache) Call(req codec.Requester, rname, query, action string, token, pa
 }
 
 // Auth sends an auth method call
-func (c *Cache) Auth(req codec.AuthRequester, rname, query, action string, token, params interface{}, callback func(result json.RawMessage, rid string, err error)) {
+func (c *Cache) Auth(req codec.AuthRequester, rname, query, action string, token, params interface{}, callback func(result json.RawMessage, rid string, tags codec.Tags, err error)) {
 	payload := codec.CreateAuthRequest(params, req, query, token)
 	subj := "auth." + rname + "." + action
 	c.sendRequest(rname, subj, payload, func(data []byte, err error) {
 		if err != nil {
-			callback(nil, "", err)
+			callback(nil, "", nil, err)
 			return
 		}
 
-		callback(codec.DecodeCallResponse(data))
+		callback(codec.DecodeAuthResponse(data))
+	})
+}
+
+// Blob sends a blob request
+func (c *Cache) Blob(req codec.Requester, rname, query string, token interface{}, callback func(result *codec.BlobResult, err error)) {
+	payload := codec.CreateRequest(nil, req, query, token)
+	subj := "blob." + rname
+	c.sendRequest(rname, subj, payload, func(data []byte, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+
+		callback(codec.DecodeBlobResponse(data))
 	})
 }
 
@@ -220,26 +326,45 @@ func (c *Cache) getSubscription(name string, subscribe bool) (*EventSubscription
 		}
 
 		eventSub.mqSub = mqSub
+		eventSub.subscribed = time.Now()
 	}
 
 	return eventSub, nil
 }
 
-// Stop closes the worker channel, stops all the workers,  and clears
-// the unsubscribe queue
+// Stop stops all the workers, and clears the unsubscribe queue. The worker
+// channel is left open, as subscriptions may still be enqueued by
+// connections being closed.
 func (c *Cache) Stop() {
 	if !c.started {
 		return
 	}
-	close(c.inCh)
+	c.mu.Lock()
+	close(c.stopCh)
+	c.started = false
+	c.mu.Unlock()
 	c.unsubQueue.Clear()
+	c.stopScheduled()
 	c.resetSub = nil
-	c.started = false
 }
 
-func (c *Cache) startWorker(ch chan *EventSubscription) {
-	for eventSub := range ch {
-		eventSub.processQueue()
+func (c *Cache) startWorker(ch chan *EventSubscription, stop chan struct{}) {
+	for {
+		select {
+		case eventSub := <-ch:
+			eventSub.processQueue()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// enqueue passes the event subscription to one of the workers, unless the
+// cache is stopped.
+func (c *Cache) enqueue(e *EventSubscription) {
+	select {
+	case c.inCh <- e:
+	case <-c.stopCh:
 	}
 }
 
@@ -255,6 +380,18 @@ func (c *Cache) mqUnsubscribe(v interface{}) {
 	delete(c.eventSubs, eventSub.ResourceName)
 }
 
+// ResetAll refetches all cached resources and resets the access of all
+// subscriptions, as if receiving a system reset event for all resources.
+func (c *Cache) ResetAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, eventSub := range c.eventSubs {
+		eventSub.handleResetResource()
+		eventSub.handleResetAccess()
+	}
+}
+
 func (c *Cache) handleSystemReset(payload []byte) {
 	r, err := codec.DecodeSystemReset(payload)
 	if err != nil {
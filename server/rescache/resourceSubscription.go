@@ -2,6 +2,8 @@ package rescache
 
 import (
 	"encoding/json"
+	"sync/atomic"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/reserr"
@@ -15,8 +17,13 @@ const (
 	stateRequested
 	stateCollection
 	stateModel
+	stateStream
 )
 
+// StreamMaxEntries is the maximum number of entries of a stream kept in the
+// cache for replay.
+const StreamMaxEntries = 1000
+
 // Model represents a RES model
 // https://github.com/resgateio/resgate/blob/master/docs/res-protocol.md#models
 type Model struct {
@@ -55,6 +62,65 @@ func (c *Collection) MarshalJSON() ([]byte, error) {
 	return c.data, nil
 }
 
+// Stream represents a RES stream, an append-only sequence of primitive values
+// where each entry is identified by its offset. Only the most recent entries
+// are kept.
+type Stream struct {
+	Offset int64
+	Values []codec.Value
+	data   []byte
+}
+
+// streamJSON is the JSON encoded representation of a stream.
+type streamJSON struct {
+	Offset  int64         `json:"offset"`
+	Entries []codec.Value `json:"entries"`
+}
+
+// newStream creates a stream with entries starting at offset, trimmed to
+// the StreamMaxEntries most recent entries.
+func newStream(offset int64, values []codec.Value) *Stream {
+	if l := len(values); l > StreamMaxEntries {
+		offset += int64(l - StreamMaxEntries)
+		values = values[l-StreamMaxEntries:]
+	}
+	return &Stream{Offset: offset, Values: values}
+}
+
+// Next returns the offset of the next entry appended to the stream.
+func (st *Stream) Next() int64 {
+	return st.Offset + int64(len(st.Values))
+}
+
+// From returns a stream with the entries from the offset. Entries no longer
+// kept are omitted.
+func (st *Stream) From(offset int64) *Stream {
+	if offset <= st.Offset {
+		return st
+	}
+	next := st.Next()
+	if offset > next {
+		offset = next
+	}
+	return &Stream{Offset: offset, Values: st.Values[offset-st.Offset:]}
+}
+
+// MarshalJSON creates a JSON encoded representation of the stream
+func (st *Stream) MarshalJSON() ([]byte, error) {
+	if st.data == nil {
+		vals := st.Values
+		if vals == nil {
+			vals = []codec.Value{}
+		}
+		data, err := json.Marshal(streamJSON{Offset: st.Offset, Entries: vals})
+		if err != nil {
+			return nil, err
+		}
+		st.data = data
+	}
+	return st.data, nil
+}
+
 // ResourceSubscription represents a client subscription for a resource or query resource
 type ResourceSubscription struct {
 	e         *EventSubscription
@@ -63,9 +129,12 @@ type ResourceSubscription struct {
 	subs      map[Subscriber]struct{}
 	resetting bool
 	links     []string
-	// Three types of values stored
+	ttl       *time.Timer   // Timer refetching the resource on expiry
+	ttlDur    time.Duration // Time to live of the last get response
+	// Four types of values stored
 	model      *Model
 	collection *Collection
+	stream     *Stream
 	err        error
 }
 
@@ -107,7 +176,15 @@ func (rs *ResourceSubscription) GetModel() *Model {
 	return rs.model
 }
 
-// Release releases the lock obtained by calling GetCollection or GetModel
+// GetStream will lock the EventSubscription for any changes
+// and return the stream.
+// The lock must be released by calling Release
+func (rs *ResourceSubscription) GetStream() *Stream {
+	rs.e.mu.Lock()
+	return rs.stream
+}
+
+// Release releases the lock obtained by calling GetCollection, GetModel, or GetStream
 func (rs *ResourceSubscription) Release() {
 	rs.e.mu.Unlock()
 }
@@ -148,6 +225,10 @@ func (rs *ResourceSubscription) handleEvent(r *ResourceEvent) {
 		if rs.resetting || !rs.handleEventRemove(r) {
 			return
 		}
+	case "append":
+		if rs.resetting || !rs.handleEventAppend(r) {
+			return
+		}
 	case "delete":
 		if !rs.resetting {
 			rs.handleEventDelete(r)
@@ -163,24 +244,19 @@ func (rs *ResourceSubscription) handleEvent(r *ResourceEvent) {
 }
 
 func (rs *ResourceSubscription) handleEventChange(r *ResourceEvent) bool {
-	if rs.state == stateCollection {
-		rs.e.cache.Errorf("Error processing event %s.%s: change event on collection", rs.e.ResourceName, r.Event)
+	if rs.state != stateModel {
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: change event on %s", rs.e.ResourceName, r.Event, rs.typeName())
 		return false
 	}
 
-	var props map[string]codec.Value
-	var err error
-
+	props, legacy, err := codec.DecodeAnyChangeEvent(r.Payload)
 	// [DEPRECATED:deprecatedModelChangeEvent]
-	if codec.IsLegacyChangeEvent(r.Payload) {
+	if legacy {
 		rs.e.cache.deprecated(rs.e.ResourceName, deprecatedModelChangeEvent)
-		props, err = codec.DecodeLegacyChangeEvent(r.Payload)
-	} else {
-		props, err = codec.DecodeChangeEvent(r.Payload)
 	}
 
 	if err != nil {
-		rs.e.cache.Errorf("Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
 	}
 
 	// Clone old map using old map size as capacity.
@@ -209,6 +285,9 @@ func (rs *ResourceSubscription) handleEventChange(r *ResourceEvent) bool {
 
 	// No actual changes
 	if len(props) == 0 {
+		if err == nil {
+			atomic.AddUint64(&rs.e.cache.suppressed.change, 1)
+		}
 		return false
 	}
 
@@ -219,14 +298,14 @@ func (rs *ResourceSubscription) handleEventChange(r *ResourceEvent) bool {
 }
 
 func (rs *ResourceSubscription) handleEventAdd(r *ResourceEvent) bool {
-	if rs.state == stateModel {
-		rs.e.cache.Errorf("Error processing event %s.%s: add event on model", rs.e.ResourceName, r.Event)
+	if rs.state != stateCollection {
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: add event on %s", rs.e.ResourceName, r.Event, rs.typeName())
 		return false
 	}
 
 	params, err := codec.DecodeAddEvent(r.Payload)
 	if err != nil {
-		rs.e.cache.Errorf("Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
 		return false
 	}
 
@@ -235,7 +314,13 @@ func (rs *ResourceSubscription) handleEventAdd(r *ResourceEvent) bool {
 	l := len(old)
 
 	if idx < 0 || idx > l {
-		rs.e.cache.Errorf("Error processing event %s.%s: idx %d is out of bounds", rs.e.ResourceName, r.Event, idx)
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: idx %d is out of bounds", rs.e.ResourceName, r.Event, idx)
+		return false
+	}
+
+	// Suppress re-added values of unique collections
+	if rs.e.cache.isUniqueCollection(rs.e.ResourceName) && containsValue(old, params.Value) {
+		atomic.AddUint64(&rs.e.cache.suppressed.add, 1)
 		return false
 	}
 
@@ -254,14 +339,14 @@ func (rs *ResourceSubscription) handleEventAdd(r *ResourceEvent) bool {
 }
 
 func (rs *ResourceSubscription) handleEventRemove(r *ResourceEvent) bool {
-	if rs.state == stateModel {
-		rs.e.cache.Errorf("Error processing event %s.%s: remove event on model", rs.e.ResourceName, r.Event)
+	if rs.state != stateCollection {
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: remove event on %s", rs.e.ResourceName, r.Event, rs.typeName())
 		return false
 	}
 
 	params, err := codec.DecodeRemoveEvent(r.Payload)
 	if err != nil {
-		rs.e.cache.Errorf("Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
 		return false
 	}
 
@@ -270,7 +355,7 @@ func (rs *ResourceSubscription) handleEventRemove(r *ResourceEvent) bool {
 	l := len(old)
 
 	if idx < 0 || idx >= l {
-		rs.e.cache.Errorf("Error processing event %s.%s: idx %d is out of bounds", rs.e.ResourceName, r.Event, idx)
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: idx %d is out of bounds", rs.e.ResourceName, r.Event, idx)
 		return false
 	}
 
@@ -286,6 +371,46 @@ func (rs *ResourceSubscription) handleEventRemove(r *ResourceEvent) bool {
 	return true
 }
 
+func (rs *ResourceSubscription) handleEventAppend(r *ResourceEvent) bool {
+	if rs.state != stateStream {
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: append event on %s", rs.e.ResourceName, r.Event, rs.typeName())
+		return false
+	}
+
+	params, err := codec.DecodeAppendEvent(r.Payload)
+	if err != nil {
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
+		return false
+	}
+
+	// Copy stream values as the old slice might have been
+	// passed to a Subscriber and should be considered immutable
+	old := rs.stream.Values
+	vals := make([]codec.Value, len(old)+1)
+	copy(vals, old)
+	vals[len(old)] = params.Value
+
+	r.Offset = rs.stream.Next()
+	r.Value = params.Value
+	rs.stream = newStream(rs.stream.Offset, vals)
+
+	return true
+}
+
+// typeName returns the name of the loaded resource type, used in log
+// messages.
+func (rs *ResourceSubscription) typeName() string {
+	switch rs.state {
+	case stateModel:
+		return "model"
+	case stateCollection:
+		return "collection"
+	case stateStream:
+		return "stream"
+	}
+	return "unloaded resource"
+}
+
 func (rs *ResourceSubscription) handleEventDelete(r *ResourceEvent) {
 	subs := rs.subs
 	c := int64(len(subs))
@@ -415,10 +540,14 @@ func (rs *ResourceSubscription) processGetResponse(payload []byte, err error) (n
 	if result.Model != nil {
 		nrs.model = &Model{Values: result.Model}
 		nrs.state = stateModel
+	} else if result.Stream != nil {
+		nrs.stream = newStream(result.Offset, result.Stream)
+		nrs.state = stateStream
 	} else {
 		nrs.collection = &Collection{Values: result.Collection}
 		nrs.state = stateCollection
 	}
+	nrs.setTTL(result.TTL)
 	return
 }
 
@@ -431,9 +560,10 @@ func (rs *ResourceSubscription) handleResetResource() {
 	rs.resetting = true
 
 	// Create request
-	subj := "get." + rs.e.ResourceName
+	subj, cc := rs.e.cache.routeSubject("get", rs.e.ResourceName, "", nil)
 	payload := codec.CreateGetRequest(rs.query)
 	rs.e.cache.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
+		cc.doneResponse(data, err)
 		rs.e.Enqueue(func() {
 			rs.resetting = false
 			rs.processResetGetResponse(data, err)
@@ -442,6 +572,9 @@ func (rs *ResourceSubscription) handleResetResource() {
 }
 
 func (rs *ResourceSubscription) handleResetAccess() {
+	if h := rs.e.cache.accessReset; h != nil {
+		h(rs.e.ResourceName)
+	}
 	for sub := range rs.subs {
 		sub.Reaccess()
 	}
@@ -459,20 +592,25 @@ func (rs *ResourceSubscription) processResetGetResponse(payload []byte, err erro
 	if err != nil {
 		// In case of a system.notFound error,
 		// a delete event is generated. Otherwise we
-		// just log the error.
+		// just log the error, and retry on expiry.
 		if reserr.IsError(err, reserr.CodeNotFound) {
+			rs.scheduleExpiry(0)
 			rs.handleEvent(&ResourceEvent{Event: "delete"})
 		} else {
-			rs.e.cache.Errorf("Subscription %s: Reset get error - %s", rs.e.ResourceName, err)
+			rs.e.cache.resourceErrorf(rs.e.ResourceName, "Subscription %s: Reset get error - %s", rs.e.ResourceName, err)
+			rs.scheduleExpiry(rs.ttlDur)
 		}
 		return
 	}
+	rs.setTTL(result.TTL)
 
 	switch rs.state {
 	case stateModel:
 		rs.processResetModel(result.Model)
 	case stateCollection:
 		rs.processResetCollection(result.Collection)
+	case stateStream:
+		rs.processResetStream(result.Offset, result.Stream)
 	}
 }
 
@@ -513,6 +651,22 @@ func (rs *ResourceSubscription) processResetCollection(collection []codec.Value)
 	}
 }
 
+func (rs *ResourceSubscription) processResetStream(offset int64, values []codec.Value) {
+	next := rs.stream.Next()
+	if offset > next {
+		rs.e.cache.resourceErrorf(rs.e.ResourceName, "Subscription %s: Reset get stream offset %d is beyond the next cached offset %d", rs.e.ResourceName, offset, next)
+		return
+	}
+
+	// Append entries not yet in the cached stream
+	for i := next - offset; i < int64(len(values)); i++ {
+		rs.handleEvent(&ResourceEvent{
+			Event:   "append",
+			Payload: codec.EncodeAppendEvent(&codec.AppendEvent{Value: values[i]}),
+		})
+	}
+}
+
 func lcs(a, b []codec.Value) []*ResourceEvent {
 	var i, j int
 	// Do a LCS matric calculation
@@ -0,0 +1,207 @@
+package rescache
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxShadowDiffs is the maximum number of differing paths logged for a
+// compared shadow response.
+const maxShadowDiffs = 10
+
+// ShadowRoute mirrors a sampled percentage of get and call requests for
+// resources matching a pattern to a secondary subject prefix. Responses to
+// mirrored requests are discarded, or compared with the primary response.
+type ShadowRoute struct {
+	Pattern ResourcePattern
+	Prefix  string
+	Percent float64
+	Compare bool
+
+	// PercentFunc, if set, returns the percentage to use for a request,
+	// instead of Percent. The token is nil for get requests.
+	PercentFunc func(rname string, token interface{}) float64
+
+	counter    canaryCounter
+	matches    uint64
+	mismatches uint64
+}
+
+// ShadowStats holds request counters for a shadow route.
+type ShadowStats struct {
+	CanaryStats
+	Matches    uint64 `json:"matches"`
+	Mismatches uint64 `json:"mismatches"`
+}
+
+// shadowCompare waits for both the primary and the shadow response of a
+// mirrored request, and compares them once both are received.
+type shadowCompare struct {
+	c       *Cache
+	r       *ShadowRoute
+	subj    string
+	mu      sync.Mutex
+	pending int
+	primary []byte
+	shadow  []byte
+}
+
+// NewShadowRoute creates a new shadow route. Requests for resources matching
+// the pattern are, with the given percentage, mirrored to subjects with the
+// prefix inserted after the request type. Eg. "get.shadow.example.model"
+// If compare is true, shadow responses are compared with primary responses,
+// and any structural differences are logged.
+func NewShadowRoute(pattern ResourcePattern, prefix string, percent float64, compare bool) *ShadowRoute {
+	return &ShadowRoute{
+		Pattern: pattern,
+		Prefix:  prefix,
+		Percent: percent,
+		Compare: compare,
+	}
+}
+
+// Stats returns the request and comparison counters for mirrored requests.
+func (r *ShadowRoute) Stats() ShadowStats {
+	return ShadowStats{
+		CanaryStats: r.counter.stats(),
+		Matches:     atomic.LoadUint64(&r.matches),
+		Mismatches:  atomic.LoadUint64(&r.mismatches),
+	}
+}
+
+// SetShadowRoutes sets the shadow routes used for mirroring get and call
+// requests. All matching routes are used. It must be called before Start.
+func (c *Cache) SetShadowRoutes(routes []*ShadowRoute) {
+	c.shadowRoutes = routes
+}
+
+// mirror sends a copy of a request of type typ, for the resource rname, with an
+// optional suffix, such as ".method", made on behalf of token, to each matching
+// and sampled shadow route. The returned function must be called with the
+// primary response, to be compared with shadow responses for routes with
+// comparison enabled.
+func (c *Cache) mirror(typ, rname, suffix string, token interface{}, payload []byte) func(data []byte, err error) {
+	var scs []*shadowCompare
+	for _, r := range c.shadowRoutes {
+		if !r.Pattern.Match(rname) || !sample(r.Percent, r.PercentFunc, rname, token) {
+			continue
+		}
+		cc := &r.counter
+		subj := typ + "." + r.Prefix + "." + rname + suffix
+		if !r.Compare {
+			c.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
+				cc.doneResponse(data, err)
+			})
+			continue
+		}
+		sc := &shadowCompare{c: c, r: r, subj: subj, pending: 2}
+		scs = append(scs, sc)
+		c.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
+			cc.doneResponse(data, err)
+			sc.setShadow(data, err)
+		})
+	}
+	return func(data []byte, err error) {
+		for _, sc := range scs {
+			sc.setPrimary(data, err)
+		}
+	}
+}
+
+func (sc *shadowCompare) setPrimary(data []byte, err error) {
+	sc.set(&sc.primary, data, err)
+}
+
+func (sc *shadowCompare) setShadow(data []byte, err error) {
+	sc.set(&sc.shadow, data, err)
+}
+
+// set stores a response, and compares the responses once both are received.
+// A request error, such as a timeout, is stored as an error response.
+func (sc *shadowCompare) set(dst *[]byte, data []byte, err error) {
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{"error": err.Error()})
+	}
+	sc.mu.Lock()
+	*dst = data
+	sc.pending--
+	done := sc.pending == 0
+	sc.mu.Unlock()
+	if done {
+		sc.compare()
+	}
+}
+
+func (sc *shadowCompare) compare() {
+	var p, s interface{}
+	if json.Unmarshal(sc.primary, &p) != nil {
+		p = string(sc.primary)
+	}
+	if json.Unmarshal(sc.shadow, &s) != nil {
+		s = string(sc.shadow)
+	}
+	var diffs []string
+	diffJSON("", p, s, &diffs)
+	if len(diffs) == 0 {
+		atomic.AddUint64(&sc.r.matches, 1)
+		return
+	}
+	atomic.AddUint64(&sc.r.mismatches, 1)
+	sc.c.Logf("Shadow response mismatch for %s at: %s", sc.subj, strings.Join(diffs, ", "))
+}
+
+// diffJSON appends the paths where two decoded JSON values structurally
+// differ, up to maxShadowDiffs paths.
+func diffJSON(path string, a, b interface{}, diffs *[]string) {
+	if len(*diffs) >= maxShadowDiffs {
+		return
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diffJSON(joinPath(path, k), av[k], bv[k], diffs)
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			break
+		}
+		for i := range av {
+			diffJSON(joinPath(path, strconv.Itoa(i)), av[i], bv[i], diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		if path == "" {
+			path = "."
+		}
+		*diffs = append(*diffs, path)
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
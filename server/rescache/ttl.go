@@ -0,0 +1,55 @@
+package rescache
+
+import "time"
+
+// setTTL sets the time to live in milliseconds given by a get response,
+// scheduling the resource to be refetched on expiry. A ttl of 0 uses the
+// interval of any matching poll rule, or else stops any scheduled refetch.
+// Must be called from within the event queue.
+func (rs *ResourceSubscription) setTTL(ttl int64) {
+	rs.ttlDur = time.Duration(ttl) * time.Millisecond
+	if rs.ttlDur == 0 {
+		rs.ttlDur = rs.e.cache.pollInterval(rs.e.ResourceName)
+	}
+	rs.scheduleExpiry(rs.ttlDur)
+}
+
+// scheduleExpiry replaces any scheduled refetch with one after the duration.
+// A duration of 0 only stops any scheduled refetch.
+// Must be called from within the event queue.
+func (rs *ResourceSubscription) scheduleExpiry(d time.Duration) {
+	if rs.ttl != nil {
+		rs.ttl.Stop()
+		rs.ttl = nil
+	}
+	if d > 0 {
+		rs.ttl = time.AfterFunc(d, rs.handleExpired)
+	}
+}
+
+// handleExpired refetches the resource, updating subscribers with any
+// changes, unless the resource subscription has been removed from the cache,
+// or the cache is stopped.
+func (rs *ResourceSubscription) handleExpired() {
+	e := rs.e
+	e.cache.mu.Lock()
+	defer e.cache.mu.Unlock()
+	if !e.cache.started || e.cache.eventSubs[e.ResourceName] != e {
+		return
+	}
+
+	e.Enqueue(func() {
+		rs.ttl = nil
+		if rs.state <= stateRequested || rs.state == stateError {
+			return
+		}
+		if rs.query == "" {
+			if e.base != rs {
+				return
+			}
+		} else if e.queries[rs.query] != rs {
+			return
+		}
+		rs.handleResetResource()
+	})
+}
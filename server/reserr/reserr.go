@@ -46,6 +46,9 @@ const (
 	CodeTimeout             = "system.timeout"
 	CodeInvalidRequest      = "system.invalidRequest"
 	CodeUnsupportedProtocol = "system.unsupportedProtocol"
+	CodeSubscriptionExpired = "system.subscriptionExpired"
+	CodePreconditionFailed  = "system.preconditionFailed"
+	CodeTooManyRequests     = "system.tooManyRequests"
 	// HTTP only error codes
 	CodeBadRequest         = "system.badRequest"
 	CodeMethodNotAllowed   = "system.methodNotAllowed"
@@ -68,6 +71,9 @@ var (
 	ErrTimeout             = &Error{Code: CodeTimeout, Message: "Request timeout"}
 	ErrInvalidRequest      = &Error{Code: CodeInvalidRequest, Message: "Invalid request"}
 	ErrUnsupportedProtocol = &Error{Code: CodeUnsupportedProtocol, Message: "Unsupported protocol"}
+	ErrSubscriptionExpired = &Error{Code: CodeSubscriptionExpired, Message: "Subscription expired"}
+	ErrPreconditionFailed  = &Error{Code: CodePreconditionFailed, Message: "Precondition failed"}
+	ErrTooManyRequests     = &Error{Code: CodeTooManyRequests, Message: "Too many requests"}
 	// HTTP only errors
 	ErrBadRequest         = &Error{Code: CodeBadRequest, Message: "Bad request"}
 	ErrMethodNotAllowed   = &Error{Code: CodeMethodNotAllowed, Message: "Method not allowed"}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// errResourceNotAllowed is returned for client requests for resources not
+// allowed by the allowedResources and deniedResources settings.
+var errResourceNotAllowed = &reserr.Error{Code: reserr.CodeAccessDenied, Message: "Resource not allowed"}
+
+// errMethodNotAllowed is returned for call and new requests for methods not
+// allowed by the allowedMethods setting.
+var errMethodNotAllowed = &reserr.Error{Code: reserr.CodeAccessDenied, Message: "Method not allowed"}
+
+// MethodPolicy holds the call methods that clients may call on resources
+// matching a pattern.
+type MethodPolicy struct {
+	Pattern string   `json:"pattern"`
+	Methods []string `json:"methods"`
+}
+
+// methodPolicy is a prepared MethodPolicy configuration.
+type methodPolicy struct {
+	pattern rescache.ResourcePattern
+	methods map[string]bool
+}
+
+// prepare validates the method policy configuration, and returns the
+// prepared policy.
+func (c MethodPolicy) prepare() (methodPolicy, error) {
+	p := rescache.ParseResourcePattern(c.Pattern)
+	if !p.IsValid() {
+		return methodPolicy{}, fmt.Errorf("'%s' must be a valid resource pattern", c.Pattern)
+	}
+	ms := make(map[string]bool, len(c.Methods))
+	for _, m := range c.Methods {
+		if !codec.IsValidRIDPart(m) {
+			return methodPolicy{}, fmt.Errorf("'%s' must be a valid method name", m)
+		}
+		ms[m] = true
+	}
+	return methodPolicy{pattern: p, methods: ms}, nil
+}
+
+// parseResourcePatterns parses a list of resource patterns.
+func parseResourcePatterns(patterns []string) ([]rescache.ResourcePattern, error) {
+	ps := make([]rescache.ResourcePattern, 0, len(patterns))
+	for _, p := range patterns {
+		rp := rescache.ParseResourcePattern(p)
+		if !rp.IsValid() {
+			return nil, fmt.Errorf("'%s' must be a valid resource pattern", p)
+		}
+		ps = append(ps, rp)
+	}
+	return ps, nil
+}
+
+// resourceAllowedError returns errResourceNotAllowed if the resource name
+// matches any of the denied resource patterns, or if allowed resource patterns
+// are set and none of them match. Otherwise nil.
+func (s *Service) resourceAllowedError(rname string) error {
+	for _, p := range s.cfg.deniedResources {
+		if p.Match(rname) {
+			return errResourceNotAllowed
+		}
+	}
+	if len(s.cfg.allowedResources) == 0 {
+		return nil
+	}
+	for _, p := range s.cfg.allowedResources {
+		if p.Match(rname) {
+			return nil
+		}
+	}
+	return errResourceNotAllowed
+}
+
+// methodAllowedError returns errMethodNotAllowed if the resource name matches
+// a method policy pattern, and the method is not among the methods of the
+// first matching policy. Otherwise nil.
+func (s *Service) methodAllowedError(rname, action string) error {
+	for _, p := range s.cfg.methodPolicies {
+		if p.pattern.Match(rname) {
+			if p.methods[action] {
+				return nil
+			}
+			return errMethodNotAllowed
+		}
+	}
+	return nil
+}
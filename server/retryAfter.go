@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// RetryAfterConfig holds the configuration for the backoff hints included
+// when rejecting connections and HTTP requests.
+type RetryAfterConfig struct {
+	// Delay is the minimum number of milliseconds a client should wait
+	// before retrying.
+	Delay int `json:"delay"`
+	// Jitter is the maximum number of random milliseconds added to the
+	// delay, to spread out reconnecting clients.
+	Jitter int `json:"jitter,omitempty"`
+}
+
+// retryHint is the backoff hint set as error data, and sent as the reason of
+// a WebSocket close frame.
+type retryHint struct {
+	Code       string `json:"code,omitempty"`
+	RetryAfter int64  `json:"retryAfter"`
+}
+
+// prepare validates the retry-after configuration.
+func (c *RetryAfterConfig) prepare() error {
+	if c.Delay <= 0 {
+		return errors.New("delay must be a positive number of milliseconds")
+	}
+	if c.Jitter < 0 {
+		return errors.New("jitter must be zero or a positive number of milliseconds")
+	}
+	return nil
+}
+
+// retryAfter returns the jittered delay for a client to wait before
+// retrying.
+func (s *Service) retryAfter() time.Duration {
+	c := s.cfg.RetryAfter
+	d := c.Delay
+	if c.Jitter > 0 {
+		d += rand.Intn(c.Jitter + 1)
+	}
+	return time.Duration(d) * time.Millisecond
+}
+
+// rejectConn rejects a new connection or HTTP request with the error. If
+// retryAfter is configured, the response includes a Retry-After header, and
+// the error data holds the delay in milliseconds unless already set.
+// WebSocket upgrade requests are upgraded and closed with a close frame
+// with status 1013 (Try Again Later), and the hint as reason, since browser
+// clients cannot read the body of a rejected handshake.
+func (s *Service) rejectConn(w http.ResponseWriter, r *http.Request, err error) {
+	if s.cfg.RetryAfter == nil {
+		s.httpError(w, r, err, s.enc)
+		return
+	}
+	d := s.retryAfter()
+	ms := int64(d / time.Millisecond)
+	rerr := *reserr.RESError(err)
+	if rerr.Data == nil {
+		rerr.Data = retryHint{RetryAfter: ms}
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		ws, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			s.Debugf("Failed to upgrade rejected connection from %s: %s", r.RemoteAddr, err.Error())
+			return
+		}
+		reason, _ := json.Marshal(retryHint{Code: rerr.Code, RetryAfter: ms})
+		// Control frame payloads are limited to 125 bytes, including the
+		// 2 byte status code.
+		if len(reason) > 123 {
+			reason, _ = json.Marshal(retryHint{RetryAfter: ms})
+		}
+		_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, string(reason)), time.Now().Add(WSTimeout))
+		ws.Close()
+		return
+	}
+
+	w.Header().Set("Retry-After", strconv.FormatInt((ms+999)/1000, 10))
+	s.httpError(w, r, &rerr, s.enc)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// HTTPLimitsConfig holds the timeouts and limits for HTTP API requests. All
+// durations are in milliseconds, and zero means no limit.
+type HTTPLimitsConfig struct {
+	// ReadTimeout is the time for reading the request body.
+	ReadTimeout int `json:"readTimeout,omitempty"`
+	// WriteTimeout is the time for writing the response.
+	WriteTimeout int `json:"writeTimeout,omitempty"`
+	// IdleTimeout is the time to wait for the next request on a keep-alive
+	// connection.
+	IdleTimeout int `json:"idleTimeout,omitempty"`
+	// MaxHeaderBytes is the maximum size of the request headers.
+	MaxHeaderBytes int `json:"maxHeaderBytes,omitempty"`
+	// KeepAlive enables HTTP keep-alive connections. Defaults to true.
+	KeepAlive *bool `json:"keepAlive,omitempty"`
+}
+
+// WSLimitsConfig holds the timeouts and limits for WebSocket connections. All
+// durations are in milliseconds, and zero means no limit.
+type WSLimitsConfig struct {
+	// HandshakeTimeout is the time for completing the upgrade handshake.
+	HandshakeTimeout int `json:"handshakeTimeout,omitempty"`
+	// WriteTimeout is the time for writing a single message.
+	WriteTimeout int `json:"writeTimeout,omitempty"`
+	// IdleTimeout is the time to wait for the next client message before
+	// closing the connection.
+	IdleTimeout int `json:"idleTimeout,omitempty"`
+	// MaxHeaderBytes is the maximum size of the upgrade request headers.
+	MaxHeaderBytes int `json:"maxHeaderBytes,omitempty"`
+}
+
+// connContextKey is the context key for the net.Conn of a request.
+type connContextKey struct{}
+
+var errHeaderTooLarge = &reserr.Error{Code: reserr.CodeBadRequest, Message: "Request header too large"}
+
+// prepare validates the HTTP limits configuration.
+func (c *HTTPLimitsConfig) prepare() error {
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		return errors.New("timeouts must be zero or a positive number of milliseconds")
+	}
+	if c.MaxHeaderBytes < 0 {
+		return errors.New("maxHeaderBytes must be zero or a positive number of bytes")
+	}
+	return nil
+}
+
+// prepare validates the WebSocket limits configuration.
+func (c *WSLimitsConfig) prepare() error {
+	if c.HandshakeTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		return errors.New("timeouts must be zero or a positive number of milliseconds")
+	}
+	if c.MaxHeaderBytes < 0 {
+		return errors.New("maxHeaderBytes must be zero or a positive number of bytes")
+	}
+	return nil
+}
+
+// applyServerLimits sets the http.Server settings that cannot be set per
+// request. The connection of each request is stored in the request context
+// to let the route handlers set its deadlines.
+func (s *Service) applyServerLimits(h *http.Server) {
+	h.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, connContextKey{}, c)
+	}
+	max := http.DefaultMaxHeaderBytes
+	if l := s.cfg.HTTPLimits; l != nil {
+		h.IdleTimeout = msDuration(l.IdleTimeout)
+		if l.MaxHeaderBytes > max {
+			max = l.MaxHeaderBytes
+		}
+		if l.KeepAlive != nil && !*l.KeepAlive {
+			h.SetKeepAlivesEnabled(false)
+		}
+	}
+	if l := s.cfg.WSLimits; l != nil && l.MaxHeaderBytes > max {
+		max = l.MaxHeaderBytes
+	}
+	h.MaxHeaderBytes = max
+}
+
+// applyHTTPLimits sets the deadlines of the HTTP API request connection,
+// and returns false if the request is rejected.
+func (s *Service) applyHTTPLimits(w http.ResponseWriter, r *http.Request) bool {
+	l := s.cfg.HTTPLimits
+	if l == nil {
+		return true
+	}
+	if l.MaxHeaderBytes > 0 && headerSize(r) > l.MaxHeaderBytes {
+		s.httpError(w, r, errHeaderTooLarge, s.enc)
+		return false
+	}
+	if c, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+		// Deadlines are always set, to reset any set for a previous request
+		// on the same keep-alive connection.
+		c.SetReadDeadline(deadline(l.ReadTimeout))
+		c.SetWriteDeadline(deadline(l.WriteTimeout))
+	}
+	return true
+}
+
+// applyWSLimits sets the deadlines of the WebSocket upgrade request
+// connection, and returns false if the request is rejected.
+func (s *Service) applyWSLimits(w http.ResponseWriter, r *http.Request) bool {
+	l := s.cfg.WSLimits
+	if l == nil {
+		return true
+	}
+	if l.MaxHeaderBytes > 0 && headerSize(r) > l.MaxHeaderBytes {
+		s.httpError(w, r, errHeaderTooLarge, s.enc)
+		return false
+	}
+	if c, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+		c.SetReadDeadline(deadline(l.HandshakeTimeout))
+		c.SetWriteDeadline(deadline(l.HandshakeTimeout))
+	}
+	return true
+}
+
+// headerSize returns the approximate size in bytes of the request headers.
+func headerSize(r *http.Request) int {
+	n := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4
+	for k, vs := range r.Header {
+		for _, v := range vs {
+			n += len(k) + len(v) + 4
+		}
+	}
+	return n
+}
+
+// deadline returns the deadline for a timeout in milliseconds, or the zero
+// time if ms is zero.
+func deadline(ms int) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(msDuration(ms))
+}
+
+// msDuration converts milliseconds to a time.Duration.
+func msDuration(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// clearHandshakeDeadlines clears the deadlines set for the upgrade handshake.
+func (c *wsConn) clearHandshakeDeadlines() {
+	if l := c.serv.cfg.WSLimits; l != nil && l.HandshakeTimeout > 0 {
+		c.ws.SetReadDeadline(time.Time{})
+		c.ws.SetWriteDeadline(time.Time{})
+	}
+}
+
+// setIdleDeadline sets the deadline for receiving the next client message.
+func (c *wsConn) setIdleDeadline() {
+	if l := c.serv.cfg.WSLimits; l != nil && l.IdleTimeout > 0 {
+		c.ws.SetReadDeadline(deadline(l.IdleTimeout))
+	}
+}
+
+// writeMessage writes a text message to the WebSocket within the configured
+// write timeout.
+func (c *wsConn) writeMessage(data []byte) error {
+	if l := c.serv.cfg.WSLimits; l != nil && l.WriteTimeout > 0 {
+		c.ws.SetWriteDeadline(deadline(l.WriteTimeout))
+	}
+	return c.ws.WriteMessage(websocket.TextMessage, data)
+}
@@ -0,0 +1,48 @@
+//go:build go1.18
+// +build go1.18
+
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Fuzz test that HandleRequest never panics, and that it either returns an
+// error, or replies exactly once with a valid JSON response with the same id.
+func FuzzHandleRequest(f *testing.F) {
+	f.Add([]byte(`{"id":1,"method":"version","params":{"protocol":"1.2.0"}}`))
+	f.Add([]byte(`{"id":2,"method":"subscribe.test.model?q=foo"}`))
+	f.Add([]byte(`{"id":3,"method":"call.test.model.method","params":{"foo":"bar"},"idempotencyKey":"key"}`))
+	f.Add([]byte(`{"id":4,"method":"call.test.model.method","executeAt":"2020-01-01T00:00:00Z"}`))
+	f.Add([]byte(`{"id":5,"method":"auth.test.login","params":null}`))
+	f.Add([]byte(`{"id":6,"method":"import","params":{"state":"foo"}}`))
+	f.Add([]byte(`{"id":7,"method":"cancel","params":{"scheduleId":"foo"}}`))
+	f.Add([]byte(`{"id":8,"method":"new.test.collection"}`))
+	f.Add([]byte(`{"id":9,"method":"unsubscribe.test.model"}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		req := &fuzzRequester{}
+		if err := HandleRequest(data, req); err != nil {
+			if len(req.replies) != 0 {
+				t.Fatalf("expected no reply on error, but got %d", len(req.replies))
+			}
+			return
+		}
+		if len(req.replies) != 1 {
+			t.Fatalf("expected 1 reply, but got %d", len(req.replies))
+		}
+		var in struct {
+			ID uint64 `json:"id"`
+		}
+		var out struct {
+			ID *uint64 `json:"id"`
+		}
+		json.Unmarshal(data, &in)
+		if err := json.Unmarshal(req.replies[0], &out); err != nil {
+			t.Fatalf("expected valid JSON reply, but got error %s:\n%s", err, req.replies[0])
+		}
+		if out.ID == nil || *out.ID != in.ID {
+			t.Fatalf("expected reply id %d, but got:\n%s", in.ID, req.replies[0])
+		}
+	})
+}
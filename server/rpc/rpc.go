@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/filter"
 	"github.com/resgateio/resgate/server/reserr"
 )
 
@@ -14,27 +16,109 @@ import (
 type Requester interface {
 	Reply(data []byte)
 	GetResource(rid string, callback func(data *Resources, err error))
-	SubscribeResource(rid string, callback func(data *Resources, err error))
+	SubscribeResource(rid string, opts SubscribeOptions, callback func(data *Resources, err error))
 	UnsubscribeResource(rid string, callback func(ok bool))
-	CallResource(rid, action string, params interface{}, callback func(result interface{}, err error))
+	CallResource(rid, action string, params interface{}, opts CallOptions, callback func(result interface{}, err error))
 	AuthResource(rid, action string, params interface{}, callback func(result interface{}, err error))
-	NewResource(rid string, params interface{}, callback func(result interface{}, err error))
+	NewResource(rid string, params interface{}, opts CallOptions, callback func(result interface{}, err error))
 	SetVersion(protocol string) (string, error)
 	ProtocolVersion() int
+	ExportState() (string, error)
+	ImportState(state string, callback func(data *Resources, err error))
+	ScheduleCall(rid, action string, params interface{}, opts CallOptions, callback func(id string, err error))
+	CancelCall(id string, callback func(err error))
+	ClientError(err error) *reserr.Error
+}
+
+// ResponseTimer is an optional interface of a Requester, reporting whether
+// the time spent handling a request is included in its response.
+type ResponseTimer interface {
+	ResponseTiming() bool
+}
+
+// Deprecator is an optional interface of a Requester, returning a warning to
+// include in responses to requests on a deprecated resource or method, or nil
+// if not deprecated. The method is empty for get and subscribe requests.
+type Deprecator interface {
+	DeprecationWarning(rid, method string) *Warning
+}
+
+// AllocationAuditor is an optional interface of a Requester, auditing the
+// memory allocations of handling requests. The function returned by
+// StartAudit, if not nil, is called with the request method once the
+// request is handled.
+type AllocationAuditor interface {
+	StartAudit() func(method string)
 }
 
 // Request represent a RES-client request
 // https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#requests
 type Request struct {
-	Method string          `json:"method"`
-	Params json.RawMessage `json:"params"`
-	ID     *uint64         `json:"id"`
+	Method         string          `json:"method"`
+	Params         json.RawMessage `json:"params"`
+	ID             *uint64         `json:"id"`
+	IdempotencyKey string          `json:"idempotencyKey"`
+	IfMatch        string          `json:"ifMatch"`
+	ExecuteAt      string          `json:"executeAt"`
+
+	handled time.Time // Time the request was handled, if timed
+	warning *Warning  // Warning included in the response, if any
+}
+
+// SubscribeOptions holds optional request properties for subscribe requests
+type SubscribeOptions struct {
+	// Offset is the offset from which to replay the entries of a subscribed
+	// stream. Nil means no entries are replayed.
+	Offset *int64
+	// TTL is the time in milliseconds after which the direct subscription
+	// expires. Zero means the gateway default is used.
+	TTL int
+	// Filter is the event filter expression that collection add events and
+	// model change events must match to be sent to the client. Nil means
+	// any previously set filter is kept.
+	Filter *filter.Expr
+}
+
+// SubscribeRequest represents the params of a subscribe request
+type SubscribeRequest struct {
+	Offset *int64  `json:"offset"`
+	TTL    *int    `json:"ttl"`
+	Filter *string `json:"filter"`
+}
+
+// CallOptions holds optional request properties for call and new requests
+type CallOptions struct {
+	IdempotencyKey string
+	IfMatch        string
+	ExecuteAt      time.Time
+	// Bulk describes the bulk request the call is part of, if any.
+	Bulk *codec.BulkInfo
+}
+
+// Meta returns the call request properties passed on to the service.
+func (o CallOptions) Meta() codec.CallMeta {
+	return codec.CallMeta{IdempotencyKey: o.IdempotencyKey, IfMatch: o.IfMatch, Bulk: o.Bulk}
 }
 
 // Response represents a RES-client response
 type Response struct {
-	Result interface{} `json:"result,omitempty"`
-	ID     *uint64     `json:"id"`
+	Result  interface{} `json:"result,omitempty"`
+	ID      *uint64     `json:"id"`
+	Timing  *Timing     `json:"timing,omitempty"`
+	Warning *Warning    `json:"warning,omitempty"`
+}
+
+// Timing holds the time in milliseconds spent handling a request
+type Timing struct {
+	Total float64 `json:"total"`
+}
+
+// Warning holds a warning about a request, such as the use of a deprecated
+// resource or method
+type Warning struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
 // Event represent a RES-client event object
@@ -46,14 +130,17 @@ type Event struct {
 
 // ErrorResponse represents a JSON-RPC error response
 type ErrorResponse struct {
-	Error *reserr.Error `json:"error"`
-	ID    *uint64       `json:"id"`
+	Error   *reserr.Error `json:"error"`
+	ID      *uint64       `json:"id"`
+	Timing  *Timing       `json:"timing,omitempty"`
+	Warning *Warning      `json:"warning,omitempty"`
 }
 
 // Resources holds a resource information to be sent to the client
 type Resources struct {
 	Models      map[string]interface{}   `json:"models,omitempty"`
 	Collections map[string]interface{}   `json:"collections,omitempty"`
+	Streams     map[string]interface{}   `json:"streams,omitempty"`
 	Errors      map[string]*reserr.Error `json:"errors,omitempty"`
 }
 
@@ -67,6 +154,26 @@ type VersionResult struct {
 	Protocol string `json:"protocol"`
 }
 
+// StateRequest represents the params of an import request
+type StateRequest struct {
+	State string `json:"state"`
+}
+
+// StateResult represents the results of an export request
+type StateResult struct {
+	State string `json:"state"`
+}
+
+// CancelRequest represents the params of a cancel request
+type CancelRequest struct {
+	ScheduleID string `json:"scheduleId"`
+}
+
+// CallScheduleResult represents a RES-client result to a scheduled call request
+type CallScheduleResult struct {
+	ScheduleID string `json:"scheduleId"`
+}
+
 // AddEvent represents a RES-client collection add event
 // https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#collection-add-event
 type AddEvent struct {
@@ -75,6 +182,19 @@ type AddEvent struct {
 	*Resources
 }
 
+// RemoveEvent represents a RES-client collection remove event
+// https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#collection-remove-event
+type RemoveEvent struct {
+	Idx int `json:"idx"`
+}
+
+// AppendEvent represents a RES-client stream append event
+// https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#stream-append-event
+type AppendEvent struct {
+	Offset int64       `json:"offset"`
+	Value  interface{} `json:"value"`
+}
+
 // ChangeEvent represents a RES-client model change event
 // https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#model-change-event
 type ChangeEvent struct {
@@ -88,6 +208,27 @@ type UnsubscribeEvent struct {
 	Reason *reserr.Error `json:"reason"`
 }
 
+// BroadcastEvent represents a RES-client broadcast event
+// https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#broadcast-event
+type BroadcastEvent struct {
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// BootstrapEvent represents a RES-client bootstrap event
+// https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#bootstrap-event
+type BootstrapEvent struct {
+	RIDs []string `json:"rids"`
+	*Resources
+}
+
+// ThrottleEvent represents a RES-client throttle event
+// https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#throttle-event
+type ThrottleEvent struct {
+	Delay  int `json:"delay"`
+	Resume int `json:"resume,omitempty"`
+}
+
 // CallPayloadResult represents a RES-client result to a call or auth request with payload response
 type CallPayloadResult struct {
 	Payload json.RawMessage `json:"payload"`
@@ -103,11 +244,33 @@ var (
 	errMissingID = errors.New("Request is missing id property")
 )
 
+// IdempotencyKeyMaxLength is the maximum length of an idempotency key.
+const IdempotencyKeyMaxLength = 256
+
 var nullBytes = []byte("null")
 
+// RejectRequest replies to a request with an error, without handling it.
+func RejectRequest(data []byte, req Requester, err error) error {
+	r := &Request{}
+	if uerr := json.Unmarshal(data, r); uerr != nil {
+		return uerr
+	}
+	if r.ID == nil {
+		return errMissingID
+	}
+	r.replyError(req, err)
+	return nil
+}
+
 // HandleRequest unmarshals a request byte array and dispatches the request to the requester
 func HandleRequest(data []byte, req Requester) error {
 	r := &Request{}
+	if aa, ok := req.(AllocationAuditor); ok {
+		if end := aa.StartAudit(); end != nil {
+			defer func() { end(r.Method) }()
+		}
+	}
+
 	err := json.Unmarshal(data, r)
 	if err != nil {
 		return err
@@ -117,26 +280,64 @@ func HandleRequest(data []byte, req Requester) error {
 		return errMissingID
 	}
 
+	if rt, ok := req.(ResponseTimer); ok && rt.ResponseTiming() {
+		r.handled = time.Now()
+	}
+
 	idx := strings.IndexByte(r.Method, '.')
 	if idx < 0 {
-		if r.Method == "version" {
+		switch r.Method {
+		case "version":
 			var vr VersionRequest
-			if data != nil && !bytes.Equal(r.Params, nullBytes) {
+			if len(r.Params) > 0 && !bytes.Equal(r.Params, nullBytes) {
 				err := json.Unmarshal(r.Params, &vr)
 				if err != nil {
-					req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+					r.replyError(req, reserr.ErrInvalidParams)
 					return nil
 				}
 			}
 			p, err := req.SetVersion(vr.Protocol)
 			if err != nil {
-				req.Reply(r.ErrorResponse(err))
+				r.replyError(req, err)
 				return nil
 			}
 			req.Reply(r.SuccessResponse(VersionResult{Protocol: p}))
-			return nil
+		case "export":
+			state, err := req.ExportState()
+			if err != nil {
+				r.replyError(req, err)
+				return nil
+			}
+			req.Reply(r.SuccessResponse(StateResult{State: state}))
+		case "import":
+			var sr StateRequest
+			if len(r.Params) == 0 || json.Unmarshal(r.Params, &sr) != nil || sr.State == "" {
+				r.replyError(req, reserr.ErrInvalidParams)
+				return nil
+			}
+			req.ImportState(sr.State, func(data *Resources, err error) {
+				if err != nil {
+					r.replyError(req, err)
+				} else {
+					req.Reply(r.SuccessResponse(data))
+				}
+			})
+		case "cancel":
+			var cr CancelRequest
+			if len(r.Params) == 0 || json.Unmarshal(r.Params, &cr) != nil || cr.ScheduleID == "" {
+				r.replyError(req, reserr.ErrInvalidParams)
+				return nil
+			}
+			req.CancelCall(cr.ScheduleID, func(err error) {
+				if err != nil {
+					r.replyError(req, err)
+				} else {
+					req.Reply(r.SuccessResponse(nil))
+				}
+			})
+		default:
+			r.replyError(req, reserr.ErrInvalidRequest)
 		}
-		req.Reply(r.ErrorResponse(reserr.ErrInvalidRequest))
 		return nil
 	}
 
@@ -147,35 +348,75 @@ func HandleRequest(data []byte, req Requester) error {
 	if action == "call" || action == "auth" {
 		idx = strings.LastIndexByte(rid, '.')
 		if idx < 0 {
-			req.Reply(r.ErrorResponse(reserr.ErrInvalidRequest))
+			r.replyError(req, reserr.ErrInvalidRequest)
 			return nil
 		}
 		method = rid[idx+1:]
 		if !codec.IsValidRIDPart(method) {
-			req.Reply(r.ErrorResponse(reserr.ErrInvalidRequest))
+			r.replyError(req, reserr.ErrInvalidRequest)
 			return nil
 		}
 		rid = rid[:idx]
 	}
 
-	if !codec.IsValidRID(rid, true) {
-		req.Reply(r.ErrorResponse(reserr.ErrInvalidRequest))
+	if !codec.IsValidRID(rid, true) || len(r.IdempotencyKey) > IdempotencyKeyMaxLength {
+		r.replyError(req, reserr.ErrInvalidRequest)
 		return nil
 	}
 
+	opts := CallOptions{IdempotencyKey: r.IdempotencyKey, IfMatch: r.IfMatch}
+	if r.ExecuteAt != "" {
+		t, err := time.Parse(time.RFC3339, r.ExecuteAt)
+		if err != nil || action != "call" {
+			r.replyError(req, reserr.ErrInvalidRequest)
+			return nil
+		}
+		opts.ExecuteAt = t
+	}
+
+	if d, ok := req.(Deprecator); ok {
+		switch action {
+		case "get", "subscribe", "call":
+			r.warning = d.DeprecationWarning(rid, method)
+		case "new":
+			r.warning = d.DeprecationWarning(rid, "new")
+		}
+	}
+
 	switch action {
 	case "get":
 		req.GetResource(rid, func(data *Resources, err error) {
 			if err != nil {
-				req.Reply(r.ErrorResponse(err))
+				r.replyError(req, err)
 			} else {
 				req.Reply(r.SuccessResponse(data))
 			}
 		})
 	case "subscribe":
-		req.SubscribeResource(rid, func(data *Resources, err error) {
+		var sr SubscribeRequest
+		if len(r.Params) > 0 && !bytes.Equal(r.Params, nullBytes) {
+			if json.Unmarshal(r.Params, &sr) != nil ||
+				(sr.Offset != nil && *sr.Offset < 0) ||
+				(sr.TTL != nil && *sr.TTL <= 0) {
+				r.replyError(req, reserr.ErrInvalidParams)
+				return nil
+			}
+		}
+		opts := SubscribeOptions{Offset: sr.Offset}
+		if sr.TTL != nil {
+			opts.TTL = *sr.TTL
+		}
+		if sr.Filter != nil {
+			f, err := filter.Parse(*sr.Filter)
+			if err != nil {
+				r.replyError(req, reserr.ErrInvalidParams)
+				return nil
+			}
+			opts.Filter = f
+		}
+		req.SubscribeResource(rid, opts, func(data *Resources, err error) {
 			if err != nil {
-				req.Reply(r.ErrorResponse(err))
+				r.replyError(req, err)
 			} else {
 				req.Reply(r.SuccessResponse(data))
 			}
@@ -185,13 +426,23 @@ func HandleRequest(data []byte, req Requester) error {
 			if ok {
 				req.Reply(r.SuccessResponse(nil))
 			} else {
-				req.Reply(r.ErrorResponse(reserr.ErrNoSubscription))
+				r.replyError(req, reserr.ErrNoSubscription)
 			}
 		})
 	case "call":
-		req.CallResource(rid, method, r.Params, func(result interface{}, err error) {
+		if !opts.ExecuteAt.IsZero() {
+			req.ScheduleCall(rid, method, r.Params, opts, func(id string, err error) {
+				if err != nil {
+					r.replyError(req, err)
+				} else {
+					req.Reply(r.SuccessResponse(CallScheduleResult{ScheduleID: id}))
+				}
+			})
+			return nil
+		}
+		req.CallResource(rid, method, r.Params, opts, func(result interface{}, err error) {
 			if err != nil {
-				req.Reply(r.ErrorResponse(err))
+				r.replyError(req, err)
 			} else {
 				req.Reply(r.SuccessResponse(result))
 			}
@@ -200,23 +451,23 @@ func HandleRequest(data []byte, req Requester) error {
 	case "auth":
 		req.AuthResource(rid, method, r.Params, func(result interface{}, err error) {
 			if err != nil {
-				req.Reply(r.ErrorResponse(err))
+				r.replyError(req, err)
 			} else {
 				req.Reply(r.SuccessResponse(result))
 			}
 		})
 
 	case "new":
-		req.NewResource(rid, r.Params, func(result interface{}, err error) {
+		req.NewResource(rid, r.Params, opts, func(result interface{}, err error) {
 			if err != nil {
-				req.Reply(r.ErrorResponse(err))
+				r.replyError(req, err)
 			} else {
 				req.Reply(r.SuccessResponse(result))
 			}
 		})
 
 	default:
-		req.Reply(r.ErrorResponse(reserr.ErrInvalidRequest))
+		r.replyError(req, reserr.ErrInvalidRequest)
 	}
 
 	return nil
@@ -224,20 +475,55 @@ func HandleRequest(data []byte, req Requester) error {
 
 // SuccessResponse encodes a result to a request response
 func (r *Request) SuccessResponse(result interface{}) []byte {
-	out, _ := json.Marshal(Response{Result: result, ID: r.ID})
+	out, _ := json.Marshal(Response{Result: result, ID: r.ID, Timing: r.timing(), Warning: r.warning})
 	return out
 }
 
+// timing returns the time spent handling the request, or nil if the request
+// is not timed.
+func (r *Request) timing() *Timing {
+	if r.handled.IsZero() {
+		return nil
+	}
+	return &Timing{Total: float64(time.Since(r.handled).Microseconds()) / 1000}
+}
+
 // NewEvent creates an encoded event to be sent to the client
 func NewEvent(rid string, event string, data interface{}) []byte {
 	out, _ := json.Marshal(Event{Event: rid + "." + event, Data: data})
 	return out
 }
 
+// NewBootstrapEvent creates an encoded bootstrap event to be sent to the
+// client
+func NewBootstrapEvent(rids []string, r *Resources) []byte {
+	out, _ := json.Marshal(Event{Event: "bootstrap", Data: BootstrapEvent{RIDs: rids, Resources: r}})
+	return out
+}
+
+// NewThrottleEvent creates an encoded throttle event to be sent to the
+// client, with the delay and resume time in milliseconds.
+func NewThrottleEvent(delay, resume int) []byte {
+	out, _ := json.Marshal(Event{Event: "throttle", Data: ThrottleEvent{Delay: delay, Resume: resume}})
+	return out
+}
+
+// NewBroadcastEvent creates an encoded broadcast event to be sent to the client
+func NewBroadcastEvent(name string, data json.RawMessage) []byte {
+	out, _ := json.Marshal(Event{Event: "broadcast", Data: BroadcastEvent{Name: name, Data: data}})
+	return out
+}
+
+// replyError replies with an error response, using the error as converted
+// by the requester for the client.
+func (r *Request) replyError(req Requester, err error) {
+	req.Reply(r.ErrorResponse(req.ClientError(err)))
+}
+
 // ErrorResponse encodes an error to a request response
 func (r *Request) ErrorResponse(err error) []byte {
 	rerr := reserr.RESError(err)
-	d, err := json.Marshal(ErrorResponse{Error: rerr, ID: r.ID})
+	d, err := json.Marshal(ErrorResponse{Error: rerr, ID: r.ID, Timing: r.timing(), Warning: r.warning})
 	if err != nil {
 		return r.ErrorResponse(reserr.InternalError(err))
 	}
@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// fuzzRequester is a Requester responding to all requests synchronously,
+// recording the replies.
+type fuzzRequester struct {
+	replies [][]byte
+}
+
+func (r *fuzzRequester) Reply(data []byte) { r.replies = append(r.replies, data) }
+func (r *fuzzRequester) GetResource(rid string, cb func(data *Resources, err error)) {
+	cb(&Resources{}, nil)
+}
+func (r *fuzzRequester) SubscribeResource(rid string, opts SubscribeOptions, cb func(data *Resources, err error)) {
+	cb(nil, reserr.ErrNotFound)
+}
+func (r *fuzzRequester) UnsubscribeResource(rid string, cb func(ok bool)) { cb(false) }
+func (r *fuzzRequester) CallResource(rid, action string, params interface{}, opts CallOptions, cb func(result interface{}, err error)) {
+	cb(CallPayloadResult{Payload: params.(json.RawMessage)}, nil)
+}
+func (r *fuzzRequester) AuthResource(rid, action string, params interface{}, cb func(result interface{}, err error)) {
+	cb(nil, reserr.ErrAccessDenied)
+}
+func (r *fuzzRequester) NewResource(rid string, params interface{}, opts CallOptions, cb func(result interface{}, err error)) {
+	cb(CallResourceResult{RID: rid}, nil)
+}
+func (r *fuzzRequester) SetVersion(protocol string) (string, error) {
+	if protocol == "" {
+		return "1.2.0", nil
+	}
+	_, err := codec.ParseProtocolVersion(protocol)
+	return "1.2.0", err
+}
+func (r *fuzzRequester) ProtocolVersion() int         { return codec.LatestProtocol }
+func (r *fuzzRequester) ExportState() (string, error) { return "state", nil }
+func (r *fuzzRequester) ImportState(state string, cb func(data *Resources, err error)) {
+	cb(&Resources{}, nil)
+}
+func (r *fuzzRequester) ScheduleCall(rid, action string, params interface{}, opts CallOptions, cb func(id string, err error)) {
+	cb("id", nil)
+}
+func (r *fuzzRequester) CancelCall(id string, cb func(err error)) { cb(reserr.ErrNotFound) }
+func (r *fuzzRequester) ClientError(err error) *reserr.Error      { return reserr.RESError(err) }
+
+// Test that HandleRequest replies with the expected response
+func TestHandleRequest(t *testing.T) {
+	tbl := []struct {
+		Request  string
+		Expected string
+	}{
+		{`{"id":1,"method":"version","params":{"protocol":"1.2.0"}}`, `{"result":{"protocol":"1.2.0"},"id":1}`},
+		{`{"id":1,"method":"version","params":null}`, `{"result":{"protocol":"1.2.0"},"id":1}`},
+		{`{"id":1,"method":"version"}`, `{"result":{"protocol":"1.2.0"},"id":1}`},
+		{`{"id":1,"method":"version","params":{"protocol":"1.2"}}`, `{"error":{"code":"system.invalidParams","message":"Invalid parameters"},"id":1}`},
+		{`{"id":1,"method":"version","params":"foo"}`, `{"error":{"code":"system.invalidParams","message":"Invalid parameters"},"id":1}`},
+		{`{"id":1,"method":"get.test.model"}`, `{"result":{},"id":1}`},
+		{`{"id":1,"method":"call.test.model.method","params":{"foo":"bar"}}`, `{"result":{"payload":{"foo":"bar"}},"id":1}`},
+		{`{"id":1,"method":"call.test"}`, `{"error":{"code":"system.invalidRequest","message":"Invalid request"},"id":1}`},
+		{`{"id":1,"method":"call.test.model.method","executeAt":"foo"}`, `{"error":{"code":"system.invalidRequest","message":"Invalid request"},"id":1}`},
+		{`{"id":1,"method":"new.test.collection"}`, `{"result":{"rid":"test.collection"},"id":1}`},
+		{`{"id":1,"method":"get.test..model"}`, `{"error":{"code":"system.invalidRequest","message":"Invalid request"},"id":1}`},
+		{`{"id":1,"method":"foo"}`, `{"error":{"code":"system.invalidRequest","message":"Invalid request"},"id":1}`},
+		{`{"id":1,"method":"cancel","params":{}}`, `{"error":{"code":"system.invalidParams","message":"Invalid parameters"},"id":1}`},
+	}
+
+	for i, l := range tbl {
+		req := &fuzzRequester{}
+		if err := HandleRequest([]byte(l.Request), req); err != nil {
+			t.Fatalf("expected no error, but got %s, in test #%d", err, i+1)
+		}
+		if len(req.replies) != 1 {
+			t.Fatalf("expected 1 reply, but got %d, in test #%d", len(req.replies), i+1)
+		}
+		if string(req.replies[0]) != l.Expected {
+			t.Fatalf("expected reply:\n%s\nbut got:\n%s\nin test #%d", l.Expected, req.replies[0], i+1)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package sentry implements a minimal Sentry client, used for reporting
+// errors to a Sentry server.
+package sentry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueueSize is the maximum number of events waiting to be sent. Events
+// reported when the queue is full are dropped.
+const QueueSize = 100
+
+// Event is an event sent to Sentry.
+type Event struct {
+	EventID    string            `json:"event_id"`
+	Timestamp  string            `json:"timestamp"`
+	Level      string            `json:"level"`
+	Platform   string            `json:"platform"`
+	Logger     string            `json:"logger,omitempty"`
+	Message    string            `json:"message"`
+	Release    string            `json:"release,omitempty"`
+	ServerName string            `json:"server_name,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Extra      map[string]string `json:"extra,omitempty"`
+}
+
+// Client sends events to a Sentry server. Events are sent one at a time by a
+// background goroutine, running for the lifetime of the process.
+type Client struct {
+	storeURL   string
+	auth       string
+	release    string
+	serverName string
+	hc         *http.Client
+
+	ch      chan *Event
+	pending sync.WaitGroup
+}
+
+// ParseDSN parses a Sentry DSN, in the form of
+// <scheme>://<key>@<host>[/<path>]/<project>, and returns the URL of the store
+// endpoint and the public key.
+func ParseDSN(dsn string) (storeURL string, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", errors.New("scheme must be http or https")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", errors.New("missing public key")
+	}
+	if u.Host == "" {
+		return "", "", errors.New("missing host")
+	}
+	idx := strings.LastIndexByte(u.Path, '/')
+	if idx == -1 || idx == len(u.Path)-1 {
+		return "", "", errors.New("missing project ID")
+	}
+	project := u.Path[idx+1:]
+	storeURL = fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:idx], project)
+	return storeURL, u.User.Username(), nil
+}
+
+// NewClient returns a new client for the DSN. The release and server name are
+// set on each event sent.
+func NewClient(dsn string, release string, serverName string) (*Client, error) {
+	storeURL, key, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		storeURL:   storeURL,
+		auth:       fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=resgate/%s", key, release),
+		release:    release,
+		serverName: serverName,
+		hc:         &http.Client{Timeout: 5 * time.Second},
+		ch:         make(chan *Event, QueueSize),
+	}
+	go c.sender()
+	return c, nil
+}
+
+// Capture queues the event for sending. Missing ID, timestamp, platform,
+// release, and server name are set by the client. Returns false if the event
+// was dropped.
+func (c *Client) Capture(ev *Event) bool {
+	if ev.EventID == "" {
+		ev.EventID = newEventID()
+	}
+	if ev.Timestamp == "" {
+		ev.Timestamp = time.Now().UTC().Format("2006-01-02T15:04:05.000000Z")
+	}
+	if ev.Platform == "" {
+		ev.Platform = "go"
+	}
+	if ev.Release == "" {
+		ev.Release = c.release
+	}
+	if ev.ServerName == "" {
+		ev.ServerName = c.serverName
+	}
+
+	c.pending.Add(1)
+	select {
+	case c.ch <- ev:
+		return true
+	default:
+		c.pending.Done()
+		return false
+	}
+}
+
+// Flush waits until all queued events are sent, or until the timeout.
+// Returns false on timeout.
+func (c *Client) Flush(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (c *Client) sender() {
+	for ev := range c.ch {
+		// Errors are ignored, as there is nowhere to report them
+		c.send(ev)
+		c.pending.Done()
+	}
+}
+
+func (c *Client) send(ev *Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.storeURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// newEventID returns a random 32 character hex string.
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerTimingConfig holds the configuration for reporting the time spent
+// handling client requests.
+type ServerTimingConfig struct {
+	// WebSocket includes the total time spent handling a request as a timing
+	// field in WebSocket responses.
+	WebSocket bool `json:"webSocket,omitempty"`
+}
+
+// serverTiming records the time spans of the phases of an HTTP API request,
+// such as access and get. Spans of a phase recorded more than once, such as
+// access requests for references, are merged into a single span from the
+// earliest start to the latest end. A nil serverTiming records nothing.
+type serverTiming struct {
+	mu    sync.Mutex
+	start time.Time
+	names []string
+	spans map[string]*timingSpan
+}
+
+type timingSpan struct {
+	start time.Time
+	end   time.Time
+}
+
+// newServerTiming returns a serverTiming started now, or nil if server
+// timing is not configured.
+func (s *Service) newServerTiming() *serverTiming {
+	if s.cfg.ServerTiming == nil {
+		return nil
+	}
+	return &serverTiming{start: time.Now(), spans: make(map[string]*timingSpan)}
+}
+
+// now returns the current time, or the zero time if t is nil.
+func (t *serverTiming) now() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// span records a span of the phase from start until now.
+func (t *serverTiming) span(name string, start time.Time) {
+	if t == nil {
+		return
+	}
+	end := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sp, ok := t.spans[name]
+	if !ok {
+		t.spans[name] = &timingSpan{start: start, end: end}
+		t.names = append(t.names, name)
+		return
+	}
+	if start.Before(sp.start) {
+		sp.start = start
+	}
+	if end.After(sp.end) {
+		sp.end = end
+	}
+}
+
+// setHeader sets the Server-Timing header with the duration in milliseconds
+// of each recorded phase, in the order first recorded, followed by the total
+// time since start.
+func (t *serverTiming) setHeader(w http.ResponseWriter) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var b strings.Builder
+	for _, name := range t.names {
+		sp := t.spans[name]
+		writeTimingMetric(&b, name, sp.end.Sub(sp.start))
+		b.WriteString(", ")
+	}
+	writeTimingMetric(&b, "total", time.Since(t.start))
+	w.Header().Set("Server-Timing", b.String())
+}
+
+func writeTimingMetric(b *strings.Builder, name string, d time.Duration) {
+	b.WriteString(name)
+	b.WriteString(";dur=")
+	b.WriteString(strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64))
+}
+
+// ResponseTiming reports whether the time spent handling a request should be
+// included in WebSocket responses.
+func (c *wsConn) ResponseTiming() bool {
+	return c.serv.cfg.ServerTiming != nil && c.serv.cfg.ServerTiming.WebSocket
+}
@@ -6,11 +6,17 @@ import (
 	"net/http"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/outbox"
+	"github.com/resgateio/resgate/server/redis"
 	"github.com/resgateio/resgate/server/rescache"
+	"github.com/rs/xid"
 )
 
 // Service is a RES gateway implementation
@@ -20,14 +26,90 @@ type Service struct {
 	mu       sync.Mutex
 	stopping bool
 	stop     chan error
+	state    int32 // Service state reported by the probe endpoints. Accessed atomically.
 
-	mq    mq.Client
-	cache *rescache.Cache
+	id       string // Instance ID
+	cidN     uint64 // Connection counter used for connection IDs
+	mq       mq.Client
+	cache    *rescache.Cache
+	idem     *idempotencyCache
+	redis    *redis.Client
+	audit    *auditLog
+	flags    FlagProvider
+	reporter ErrorReporter
+	ring     *logger.RingLogger
+
+	// brute-force protection
+	bruteForce []*bruteForceGuard
+
+	// bandwidth accounting
+	bandwidth *bandwidthTable
+
+	// HTTP API rate limits by client IP
+	rateLimits *rateLimitTable
+
+	// group access
+	groupAccess *groupAccessTable
+
+	// protocol error quarantine
+	quarantine *quarantineTable
+	// service request latency histograms
+	latency *latencyHeatmap
+	// get response payload stats
+	payloads *payloadStats
+	// hot path allocation audit
+	allocAudit *allocAudit
+	// outbound queue back-pressure signaling
+	backPressure *backPressureTable
+
+	// connections coalescing writes, by local and remote address
+	corkConns sync.Map
+
+	// slow-start
+	slowConns *slowStartBucket
+	slowReqs  *slowStartBucket
+
+	// outbox
+	outbox     *outbox.Outbox
+	outboxStop chan struct{}
+
+	// discovery
+	started      time.Time
+	announceStop chan struct{}
+	announceDone chan struct{}
+	peers        peerTable
+	leader       bool
 
 	// httpServer
-	h        *http.Server
-	enc      APIEncoder
-	mimetype string
+	h         *http.Server
+	enc       APIEncoder
+	xmlEnc    APIEncoder
+	catalogs  map[string]map[string]string
+	mimetype  string
+	wellKnown []byte
+
+	// CDN surrogate key purging
+	surrogate *surrogateClient
+
+	// blobs
+	blobClient *http.Client
+
+	// adminServer
+	adminMux *http.ServeMux
+	adminH   *http.Server
+
+	// maintenance
+	maintMu sync.RWMutex
+	maint   maintenanceMode
+
+	// blocked methods
+	blockMu    sync.RWMutex
+	blocked    []rescache.ResourcePattern
+	blockedRaw []string
+
+	// long-polling sessions
+	lpMu       sync.Mutex
+	lpSessions map[string]*longPollSession
 
 	// wsListener/wsConn
 	upgrader websocket.Upgrader
@@ -39,6 +121,7 @@ type Service struct {
 func NewService(mq mq.Client, cfg Config) (*Service, error) {
 	s := &Service{
 		cfg: cfg,
+		id:  xid.New().String(),
 		mq:  mq,
 	}
 
@@ -46,11 +129,41 @@ func NewService(mq mq.Client, cfg Config) (*Service, error) {
 		return nil, err
 	}
 	s.initHTTPServer()
+	s.initAdminServer()
+	s.initBlockedMethods()
 	s.initWSHandler()
 	s.initMQClient()
+	s.initTraceSampling()
+	s.initFeatureFlags()
+	if err := s.initErrorReporter(); err != nil {
+		return nil, err
+	}
+	s.initRedis()
+	s.initBruteForce()
+	s.initBandwidth()
+	s.initRateLimit()
+	s.initGroupAccess()
+	s.initQuarantine()
+	s.initAllocationAudit()
+	s.initBackPressure()
+	s.initIdempotencyCache()
+	if err := s.initOutbox(); err != nil {
+		return nil, err
+	}
+	if err := s.initAudit(); err != nil {
+		return nil, err
+	}
 	if err := s.initAPIHandler(); err != nil {
 		return nil, err
 	}
+	if err := s.initMessageCatalogs(); err != nil {
+		return nil, err
+	}
+	s.initBlobs()
+	if err := s.initUploads(); err != nil {
+		return nil, err
+	}
+	s.initWellKnown()
 	return s, nil
 }
 
@@ -63,6 +176,7 @@ func (s *Service) SetLogger(l logger.Logger) *Service {
 		panic("SetLogger must be called before starting server")
 	}
 
+	l = s.initLogBuffer(l)
 	s.logger = l
 	s.cache.SetLogger(l)
 	return s
@@ -89,7 +203,7 @@ func (s *Service) Tracef(format string, v ...interface{}) {
 
 // Errorf writes a formatted error message
 func (s *Service) Errorf(format string, v ...interface{}) {
-	s.logger.Error(fmt.Sprintf(format, v...))
+	s.errorf("service", format, v...)
 }
 
 // Start connects the Service to the nats server
@@ -114,14 +228,29 @@ func (s *Service) start() error {
 	}
 
 	s.Logf("Starting resgate version %s", Version)
+	s.Logf("Instance ID %s", s.id)
 	s.Debugf("Go runtime version %s", runtime.Version())
 	s.stop = make(chan error, 1)
 
+	codec.SetStrict(s.cfg.Strict)
+	if s.cfg.Strict {
+		s.Logf("Strict protocol validation enabled")
+	}
+
 	if err := s.startMQClient(); err != nil {
 		return err
 	}
+	if err := s.startDiscovery(); err != nil {
+		return err
+	}
+	s.startOutbox()
+	s.startBandwidth()
+	s.startSlowStart()
 
 	s.startHTTPServer()
+	s.startAdminServer()
+	s.logStartupSummary()
+	atomic.StoreInt32(&s.state, serviceRunning)
 	s.Logf("Server ready")
 
 	return nil
@@ -136,21 +265,38 @@ func (s *Service) Stop(err error) {
 	}
 	s.stopping = true
 	s.mu.Unlock()
+	atomic.StoreInt32(&s.state, serviceStopping)
 
 	if err != nil {
-		s.Errorf("Problem encountered: %s", err)
+		source := "service"
+		if s.mq.IsClosed() {
+			source = "nats"
+		}
+		s.errorf(source, "Problem encountered: %s", err)
 	}
 	s.Logf("Stopping server...")
 
+	s.drainProbes()
+	s.stopDiscovery()
 	s.stopWSHandler()
+	s.stopAudit()
 	s.stopHTTPServer()
+	s.stopAdminServer()
+	s.stopOutbox()
+	s.stopBandwidth()
+	s.stopSurrogateKeys()
 	s.stopMQClient()
+	s.stopIdempotencyCache()
+	s.stopUploads()
+	s.stopRedis()
+	s.flushErrorReporter()
 
 	s.mu.Lock()
 	s.stop <- err
 	close(s.stop)
 	s.stop = nil
 	s.stopping = false
+	atomic.StoreInt32(&s.state, serviceStopped)
 	s.Logf("Server stopped")
 	s.mu.Unlock()
 }
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// SignatureVerification holds the configuration for verifying the signatures
+// of resources matching a pattern.
+type SignatureVerification struct {
+	// Pattern is the resource pattern of the resources to verify.
+	Pattern string `json:"pattern"`
+	// PublicKeys are the base64 encoded Ed25519 public keys of the services
+	// signing the resources. A signature made by any of the keys is valid.
+	PublicKeys []string `json:"publicKeys"`
+}
+
+// verificationRule is a prepared SignatureVerification configuration.
+type verificationRule struct {
+	pattern rescache.ResourcePattern
+	keys    []ed25519.PublicKey
+}
+
+// signedMessage is a message payload with a detached signature.
+type signedMessage struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"`
+}
+
+// verifiedClient is a mq.Client verifying the signatures of get responses
+// and events for resources matching a verification rule, before passing on
+// the signed payload.
+type verifiedClient struct {
+	mqWrapper
+	s     *Service
+	rules []verificationRule
+}
+
+var errMissingSignature = &reserr.Error{Code: reserr.CodeInternalError, Message: "Missing signature"}
+var errInvalidSignature = &reserr.Error{Code: reserr.CodeInternalError, Message: "Invalid signature"}
+
+// prepare validates the signature verification configuration, and returns
+// the prepared rule.
+func (c SignatureVerification) prepare() (verificationRule, error) {
+	p := rescache.ParseResourcePattern(c.Pattern)
+	if !p.IsValid() {
+		return verificationRule{}, fmt.Errorf("pattern %q must be a valid resource pattern", c.Pattern)
+	}
+	if len(c.PublicKeys) == 0 {
+		return verificationRule{}, fmt.Errorf("missing public keys for pattern %q", c.Pattern)
+	}
+	keys := make([]ed25519.PublicKey, 0, len(c.PublicKeys))
+	for _, k := range c.PublicKeys {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return verificationRule{}, fmt.Errorf("public key %q must be a base64 encoded Ed25519 public key", k)
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	return verificationRule{pattern: p, keys: keys}, nil
+}
+
+// initSignatureVerification wraps the messaging client to verify resource
+// signatures, if signature verification is configured.
+func (s *Service) initSignatureVerification() {
+	if len(s.cfg.verificationRules) > 0 {
+		s.mq = &verifiedClient{mqWrapper: mqWrapper{s.mq}, s: s, rules: s.cfg.verificationRules}
+	}
+}
+
+// keys returns the public keys of the first verification rule matching the
+// resource ID, or nil if the resource is not verified.
+func (c *verifiedClient) keys(rid string) []ed25519.PublicKey {
+	for _, r := range c.rules {
+		if r.pattern.Match(rid) {
+			return r.keys
+		}
+	}
+	return nil
+}
+
+// SendRequest verifies the response payload of get requests for matching
+// resources.
+func (c *verifiedClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	var keys []ed25519.PublicKey
+	if strings.HasPrefix(subj, "get.") {
+		keys = c.keys(subj[4:])
+	}
+	if keys == nil {
+		c.Client.SendRequest(subj, payload, cb)
+		return
+	}
+	c.Client.SendRequest(subj, payload, func(rsubj string, data []byte, err error) {
+		if err == nil {
+			data, err = verifyPayload(keys, data)
+			if err != nil {
+				c.s.Errorf("Error verifying response on %s: %s", subj, err)
+			}
+		}
+		cb(rsubj, data, err)
+	})
+}
+
+// Subscribe verifies the payload of events for matching resources. Events
+// failing verification are dropped.
+func (c *verifiedClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	return c.Client.Subscribe(namespace, func(subj string, payload []byte, err error) {
+		var keys []ed25519.PublicKey
+		if strings.HasPrefix(subj, "event.") {
+			matchSubject(subj, func(rid string) bool {
+				keys = c.keys(rid)
+				return keys != nil
+			})
+		}
+		if keys != nil && err == nil {
+			payload, err = verifyPayload(keys, payload)
+			if err != nil {
+				c.s.Errorf("Error verifying event on %s: %s", subj, err)
+				return
+			}
+		}
+		cb(subj, payload, err)
+	})
+}
+
+// verifyPayload verifies the detached signature of a signed message, and
+// returns the signed payload.
+func verifyPayload(keys []ed25519.PublicKey, data []byte) ([]byte, error) {
+	var m signedMessage
+	if err := json.Unmarshal(data, &m); err != nil || len(m.Payload) == 0 || m.Signature == "" {
+		return nil, errMissingSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return nil, errInvalidSignature
+	}
+	for _, k := range keys {
+		if ed25519.Verify(k, m.Payload, sig) {
+			return m.Payload, nil
+		}
+	}
+	return nil, errInvalidSignature
+}
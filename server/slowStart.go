@@ -0,0 +1,155 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// SlowStartConfig holds the configuration for gradually increasing the rate
+// of accepted connections and client requests after the service starts.
+type SlowStartConfig struct {
+	// Duration is the ramp-up duration in milliseconds.
+	Duration int `json:"duration"`
+	// Connections is the number of new WebSocket connections per second
+	// accepted at the end of the ramp-up.
+	Connections float64 `json:"connections"`
+	// Requests is the number of client requests per second handled at the
+	// end of the ramp-up.
+	Requests float64 `json:"requests"`
+}
+
+// slowStartBucket is a token bucket with a rate increasing linearly from
+// SlowStartInitialFraction of the full rate to the full rate during the
+// ramp-up duration. Once the ramp-up is over, the bucket is unlimited.
+type slowStartBucket struct {
+	mu       sync.Mutex
+	start    time.Time
+	duration time.Duration
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+var errSlowStart = &reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Service starting up"}
+
+// prepare validates the slow-start configuration.
+func (c *SlowStartConfig) prepare() error {
+	if c.Duration <= 0 {
+		return errors.New("duration must be a positive number of milliseconds")
+	}
+	if c.Connections < 0 || c.Requests < 0 {
+		return errors.New("connections and requests must be zero or positive rates per second")
+	}
+	return nil
+}
+
+// startSlowStart starts the ramp-up of the slow-start buckets, if
+// configured.
+// Service.mu is held when called
+func (s *Service) startSlowStart() {
+	if s.cfg.SlowStart == nil {
+		return
+	}
+	now := time.Now()
+	d := time.Duration(s.cfg.SlowStart.Duration) * time.Millisecond
+	s.slowConns = newSlowStartBucket(now, d, s.cfg.SlowStart.Connections)
+	s.slowReqs = newSlowStartBucket(now, d, s.cfg.SlowStart.Requests)
+	s.Logf("Slow-start ramp-up for %s", d)
+}
+
+// newSlowStartBucket returns a new bucket, or nil if rate is zero.
+func newSlowStartBucket(start time.Time, duration time.Duration, rate float64) *slowStartBucket {
+	if rate == 0 {
+		return nil
+	}
+	return &slowStartBucket{
+		start:    start,
+		duration: duration,
+		rate:     rate,
+		tokens:   1,
+		last:     start,
+	}
+}
+
+// refill adds tokens for the time passed since last refill, and returns the
+// current rate. Returns a zero rate once the ramp-up is over.
+// slowStartBucket.mu is held when called
+func (b *slowStartBucket) refill(now time.Time) float64 {
+	elapsed := now.Sub(b.start)
+	if elapsed >= b.duration {
+		return 0
+	}
+	frac := float64(elapsed) / float64(b.duration)
+	if frac < SlowStartInitialFraction {
+		frac = SlowStartInitialFraction
+	}
+	rate := b.rate * frac
+	// Allow bursts of up to a second's worth of tokens, but at least one.
+	max := rate
+	if max < 1 {
+		max = 1
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+	return rate
+}
+
+// allow takes a token from the bucket, and returns false if none is
+// available.
+func (b *slowStartBucket) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.refill(time.Now()) == 0 {
+		return true
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes a token from the bucket, and returns the duration to wait
+// before it is available.
+func (b *slowStartBucket) reserve() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rate := b.refill(time.Now())
+	if rate == 0 {
+		return 0
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// slowStartConnError returns errSlowStart if a new connection exceeds the
+// slow-start connection rate, otherwise nil.
+func (s *Service) slowStartConnError() error {
+	if !s.slowConns.allow() {
+		return errSlowStart
+	}
+	return nil
+}
+
+// slowStartWait waits until a client request is within the slow-start
+// request rate.
+func (c *wsConn) slowStartWait() {
+	if d := c.serv.slowReqs.reserve(); d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Socket tuning coalescing strategies
+const (
+	CoalesceNone  = "none"
+	CoalesceBatch = "batch"
+)
+
+// SocketTuningCoalesceSize is the default maximum number of bytes held by
+// the batch coalescing strategy before being written.
+const SocketTuningCoalesceSize = 64 << 10
+
+// SocketTuning holds the socket level tuning of the connections accepted by
+// an HTTP listener, trading latency for packet rate.
+type SocketTuning struct {
+	// Listener is the listener to tune: "main" for the listener on addr and
+	// port, "socket" for the Unix domain socket, or an address as written in
+	// listen. Empty tunes all listeners without their own tuning.
+	Listener string `json:"listener,omitempty"`
+	// NoDelay sets TCP_NODELAY, disabling Nagle's algorithm. Defaults to
+	// true, favoring latency.
+	NoDelay *bool `json:"noDelay,omitempty"`
+	// ReadBuffer is the size in bytes of the socket receive buffer.
+	// Zero uses the operating system default.
+	ReadBuffer int `json:"readBuffer,omitempty"`
+	// WriteBuffer is the size in bytes of the socket send buffer.
+	// Zero uses the operating system default.
+	WriteBuffer int `json:"writeBuffer,omitempty"`
+	// Coalesce is the strategy for writing WebSocket messages: "none" writes
+	// each message as sent, while "batch" holds the messages sent while the
+	// connection handles queued work, and writes them together once the
+	// queue is drained. Defaults to "none".
+	Coalesce string `json:"coalesce,omitempty"`
+	// CoalesceSize is the maximum number of bytes held by the batch strategy
+	// before they are written. Defaults to 65536.
+	CoalesceSize int `json:"coalesceSize,omitempty"`
+}
+
+// tunedListener is a net.Listener applying socket tuning to accepted
+// connections.
+type tunedListener struct {
+	net.Listener
+	s *Service
+	t *SocketTuning
+}
+
+// corkConn is a net.Conn holding writes while corked, writing them together
+// when flushed.
+type corkConn struct {
+	net.Conn
+	s      *Service
+	key    string
+	size   int
+	mu     sync.Mutex
+	corked bool
+	buf    []byte
+	err    error
+}
+
+// prepare validates the socket tuning configuration and sets default values.
+func (t *SocketTuning) prepare() error {
+	if t.ReadBuffer < 0 || t.WriteBuffer < 0 || t.CoalesceSize < 0 {
+		return errors.New("readBuffer, writeBuffer, and coalesceSize must be zero or a positive number of bytes")
+	}
+	switch t.Coalesce {
+	case "":
+		t.Coalesce = CoalesceNone
+	case CoalesceNone, CoalesceBatch:
+	default:
+		return fmt.Errorf("coalesce %q must be %q or %q", t.Coalesce, CoalesceNone, CoalesceBatch)
+	}
+	if t.CoalesceSize == 0 {
+		t.CoalesceSize = SocketTuningCoalesceSize
+	}
+	return nil
+}
+
+// prepareSocketTuning validates the socket tuning of each listener, and maps
+// them by listener name.
+func (c *Config) prepareSocketTuning() error {
+	c.socketTunings = make(map[string]*SocketTuning, len(c.SocketTuning))
+	for i := range c.SocketTuning {
+		t := &c.SocketTuning[i]
+		if err := t.prepare(); err != nil {
+			return err
+		}
+		if _, ok := c.socketTunings[t.Listener]; ok {
+			return fmt.Errorf("listener %q must not be tuned more than once", t.Listener)
+		}
+		if !c.isListener(t.Listener) {
+			return fmt.Errorf("listener %q must be main, socket, or an address in listen", t.Listener)
+		}
+		c.socketTunings[t.Listener] = t
+	}
+	return nil
+}
+
+// isListener reports whether name is the empty name, or the name of a
+// configured listener.
+func (c *Config) isListener(name string) bool {
+	switch name {
+	case "", "main":
+		return true
+	case "socket":
+		return c.Socket != nil
+	}
+	for _, l := range c.Listen {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tuneListener returns the listener wrapped to apply the socket tuning of the
+// named listener, if any.
+func (s *Service) tuneListener(name string, ln net.Listener) net.Listener {
+	t, ok := s.cfg.socketTunings[name]
+	if !ok {
+		if t, ok = s.cfg.socketTunings[""]; !ok {
+			return ln
+		}
+	}
+	return &tunedListener{Listener: ln, s: s, t: t}
+}
+
+// Accept accepts a connection, and applies the socket tuning.
+func (l *tunedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		if l.t.NoDelay != nil {
+			tc.SetNoDelay(*l.t.NoDelay)
+		}
+		if l.t.ReadBuffer > 0 {
+			tc.SetReadBuffer(l.t.ReadBuffer)
+		}
+		if l.t.WriteBuffer > 0 {
+			tc.SetWriteBuffer(l.t.WriteBuffer)
+		}
+	}
+	if l.t.Coalesce != CoalesceBatch {
+		return c, nil
+	}
+	cc := &corkConn{Conn: c, s: l.s, size: l.t.CoalesceSize}
+	// With TLS, the connection is wrapped by the TLS server, and is instead
+	// found by its addresses when upgraded.
+	if l.s.cfg.TLS {
+		cc.key = connKey(c.LocalAddr().String(), c.RemoteAddr().String())
+		l.s.corkConns.Store(cc.key, cc)
+	}
+	return cc, nil
+}
+
+// connKey returns the key of a connection by its local and remote address.
+func connKey(local, remote string) string {
+	return local + " " + remote
+}
+
+// corkConn returns the corkable network connection of a WebSocket, or nil if
+// its writes are not coalesced.
+func (s *Service) corkConn(ws *websocket.Conn, r *http.Request) *corkConn {
+	if ws == nil {
+		return nil
+	}
+	if cc, ok := ws.UnderlyingConn().(*corkConn); ok {
+		return cc
+	}
+	if !s.cfg.TLS || r == nil {
+		return nil
+	}
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return nil
+	}
+	if v, ok := s.corkConns.Load(connKey(local.String(), r.RemoteAddr)); ok {
+		return v.(*corkConn)
+	}
+	return nil
+}
+
+// cork holds any following writes until flushed. A nil corkConn does
+// nothing.
+func (c *corkConn) cork() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.corked = true
+	c.mu.Unlock()
+}
+
+// flush writes any held writes, and stops holding writes until corked
+// again. On write error, the connection is closed.
+func (c *corkConn) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.corked = false
+	c.flushLocked()
+}
+
+// flushLocked writes the held writes. corkConn.mu is held when called.
+func (c *corkConn) flushLocked() {
+	if len(c.buf) == 0 || c.err != nil {
+		return
+	}
+	if _, c.err = c.Conn.Write(c.buf); c.err != nil {
+		c.Conn.Close()
+	}
+	if cap(c.buf) > c.size {
+		c.buf = nil
+	} else {
+		c.buf = c.buf[:0]
+	}
+}
+
+// Write writes the data, or holds it if corked until flushed or the held
+// data reaches the coalesce size.
+func (c *corkConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if !c.corked {
+		return c.Conn.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.size {
+		c.flushLocked()
+	}
+	return len(p), nil
+}
+
+// Close closes the connection, dropping any held writes.
+func (c *corkConn) Close() error {
+	if c.key != "" {
+		c.s.corkConns.Delete(c.key)
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+const (
+	// SSEPath is the default path prefix of the Server-Sent Events endpoint.
+	SSEPath = "/events/"
+	// SSEKeepAlive is the default interval in milliseconds for sending
+	// keep-alive comments on Server-Sent Events streams.
+	SSEKeepAlive = 30000
+)
+
+// SSEConfig holds the configuration for subscribing to resources over
+// Server-Sent Events, a read-only alternative to WebSocket for clients
+// behind proxies not supporting WebSocket.
+//
+// A HTTP GET request to the path followed by the resource ID, with dots
+// replaced by slashes, subscribes to the resource. The stream starts with a
+// subscribe event holding the resources, in the same format as a subscribe
+// result, followed by the events for the resource and any referenced
+// resources, using the event names as the SSE event type:
+//
+//	event: subscribe
+//	data: {"models":{"example.model":{"message":"Hello"}}}
+//
+//	event: example.model.change
+//	data: {"values":{"message":"Hello world"}}
+//
+// The stream ends if the subscription is removed, such as when access is
+// revoked, after sending the unsubscribe event.
+type SSEConfig struct {
+	// Path is the path prefix of the endpoint. Defaults to "/events/".
+	Path string `json:"path,omitempty"`
+	// KeepAlive is the interval in milliseconds for sending keep-alive
+	// comments, to prevent proxies from closing idle streams. Defaults to
+	// 30000.
+	KeepAlive int `json:"keepAlive,omitempty"`
+}
+
+// sseStream writes the events of a connection to a Server-Sent Events
+// stream.
+type sseStream struct {
+	rid     string
+	w       http.ResponseWriter
+	flusher http.Flusher
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	done    chan struct{}
+}
+
+// prepare validates the SSE configuration and sets default values.
+func (c *SSEConfig) prepare(apiPath, wsPath string) error {
+	if c.Path == "" {
+		c.Path = SSEPath
+	}
+	if c.Path[0] != '/' || c.Path[len(c.Path)-1] != '/' {
+		return errors.New("path must start and end with a slash (/)")
+	}
+	apiPath = strings.TrimSuffix(apiPath, "/") + "/"
+	if strings.HasPrefix(wsPath, c.Path) || strings.HasPrefix(c.Path, apiPath) || strings.HasPrefix(apiPath, c.Path) {
+		return fmt.Errorf("path %q must not overlap the wsPath or the apiPath", c.Path)
+	}
+	if c.KeepAlive < 0 {
+		return errors.New("keepAlive must be zero or a positive number of milliseconds")
+	}
+	if c.KeepAlive == 0 {
+		c.KeepAlive = SSEKeepAlive
+	}
+	return nil
+}
+
+// isSSEPath reports whether the path is within the Server-Sent Events
+// endpoint.
+func (s *Service) isSSEPath(path string) bool {
+	return s.cfg.SSE != nil && strings.HasPrefix(path, s.cfg.SSE.Path)
+}
+
+// sseHandler handles Server-Sent Events requests, subscribing to the
+// resource and streaming its events until the client disconnects or the
+// subscription is removed.
+func (s *Service) sseHandler(w http.ResponseWriter, r *http.Request) {
+	enc := s.encoder(r)
+	err := s.setCommonHeaders(w, r)
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET")
+		return
+	}
+	if err != nil {
+		s.httpError(w, r, err, enc)
+		return
+	}
+	if r.Method != "GET" {
+		s.httpError(w, r, reserr.ErrMethodNotAllowed, enc)
+		return
+	}
+	if err := s.maintenanceConnError(); err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.httpError(w, r, reserr.ErrInternalError, enc)
+		return
+	}
+
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	rid := PathToRID(path, r.URL.RawQuery, s.cfg.SSE.Path)
+	if !codec.IsValidRID(rid, true) {
+		s.notFoundHandler(w, r, enc)
+		return
+	}
+
+	c := s.newWSConn(nil, r, codec.LatestProtocol)
+	if c == nil {
+		s.httpError(w, r, reserr.ErrServiceUnavailable, enc)
+		return
+	}
+	st := &sseStream{rid: rid, w: w, flusher: flusher, done: make(chan struct{})}
+	c.sse = st
+	defer c.Dispose()
+
+	subscribed := make(chan error, 1)
+	subscribe := func() {
+		c.SubscribeResource(rid, rpc.SubscribeOptions{}, func(data *rpc.Resources, err error) {
+			if err == nil {
+				c.countOut(st.start(data))
+			}
+			subscribed <- err
+		})
+	}
+	c.Enqueue(func() {
+		if s.cfg.HeaderAuth != nil {
+			c.AuthResource(s.cfg.headerAuthRID, s.cfg.headerAuthAction, nil, func(_ interface{}, err error) {
+				if reserr.IsError(err, codeChallengeRequired) {
+					subscribed <- err
+					return
+				}
+				subscribe()
+			})
+		} else {
+			subscribe()
+		}
+	})
+	if err := <-subscribed; err != nil {
+		s.httpError(w, r, err, enc)
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(s.cfg.SSE.KeepAlive) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			st.close()
+			return
+		case <-st.done:
+			return
+		case <-ticker.C:
+			st.write([]byte(": keep-alive\n\n"))
+		}
+	}
+}
+
+// start writes the response headers and the subscribe event, and returns the
+// size of the event data. Any event sent before the stream is started is
+// discarded.
+func (st *sseStream) start(r *rpc.Resources) int {
+	h := st.w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	st.mu.Lock()
+	st.started = true
+	st.w.WriteHeader(http.StatusOK)
+	st.mu.Unlock()
+	data, _ := json.Marshal(r)
+	st.writeEvent("subscribe", data)
+	return len(data)
+}
+
+// send writes an encoded RES client event to the stream, and closes the
+// stream after an unsubscribe event for the subscribed resource.
+func (st *sseStream) send(data []byte) {
+	var ev struct {
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(data, &ev) != nil {
+		return
+	}
+	if ev.Data == nil {
+		ev.Data = json.RawMessage("null")
+	}
+	st.writeEvent(ev.Event, ev.Data)
+	if ev.Event == st.rid+".unsubscribe" {
+		st.close()
+	}
+}
+
+func (st *sseStream) writeEvent(event string, data []byte) {
+	b := make([]byte, 0, len(event)+len(data)+16)
+	b = append(b, "event: "...)
+	b = append(b, event...)
+	b = append(b, "\ndata: "...)
+	b = append(b, data...)
+	b = append(b, "\n\n"...)
+	st.write(b)
+}
+
+func (st *sseStream) write(b []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.started || st.closed {
+		return
+	}
+	st.w.Write(b)
+	st.flusher.Flush()
+}
+
+// close ends the stream, making the handler return.
+func (st *sseStream) close() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.closed {
+		st.closed = true
+		close(st.done)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/filter"
 	"github.com/resgateio/resgate/server/rescache"
 	"github.com/resgateio/resgate/server/reserr"
 	"github.com/resgateio/resgate/server/rpc"
@@ -19,6 +21,7 @@ type ConnSubscriber interface {
 	Debugf(format string, v ...interface{})
 	Errorf(format string, v ...interface{})
 	CID() string
+	Capabilities() *codec.Capabilities
 	Token() json.RawMessage
 	Subscribe(rid string, direct bool) (*Subscription, error)
 	Unsubscribe(sub *Subscription, direct bool, count int, tryDelete bool)
@@ -27,6 +30,9 @@ type ConnSubscriber interface {
 	Enqueue(f func()) bool
 	ExpandCID(string) string
 	Disconnect(reason string)
+	ClientError(err error) *reserr.Error
+	StartEventAudit(event string) func()
+	ObserveQueue(rname string)
 }
 
 // Subscription represents a resource subscription made by a client connection
@@ -44,6 +50,7 @@ type Subscription struct {
 	typ             rescache.ResourceType
 	model           *rescache.Model
 	collection      *rescache.Collection
+	stream          *rescache.Stream
 	refs            map[string]*reference
 	err             error
 	queueFlag       uint8
@@ -51,6 +58,9 @@ type Subscription struct {
 	access          *rescache.Access
 	accessCallbacks []func(*rescache.Access)
 	flags           uint8
+	expiry          *time.Timer
+	filter          *filter.Expr
+	hidden          []int // Sorted indexes of collection items hidden by the filter
 
 	// Protected by conn
 	direct   int // Number of direct subscriptions
@@ -139,6 +149,12 @@ func (s *Subscription) CID() string {
 	return s.c.CID()
 }
 
+// Capabilities returns the capabilities of the client connection, to be
+// included in requests
+func (s *Subscription) Capabilities() *codec.Capabilities {
+	return s.c.Capabilities()
+}
+
 // IsReady returns true if the subscription and all of its dependencies are loaded.
 func (s *Subscription) IsReady() bool {
 	return s.state >= stateReady
@@ -169,6 +185,12 @@ func (s *Subscription) CollectionValues() []codec.Value {
 	return s.collection.Values
 }
 
+// StreamValues returns the subscriptions stream.
+// Panics if the subscription is not a loaded stream.
+func (s *Subscription) StreamValues() *rescache.Stream {
+	return s.stream
+}
+
 // Ref returns the referenced subscription, or nil if subscription has no such reference.
 func (s *Subscription) Ref(rid string) *Subscription {
 	r := s.refs[rid]
@@ -224,6 +246,8 @@ func (s *Subscription) setResource() {
 		s.setCollection()
 	case rescache.TypeModel:
 		s.setModel()
+	case rescache.TypeStream:
+		s.setStream()
 	default:
 		err := fmt.Errorf("subscription %s: unknown resource type", s.rid)
 		s.c.Errorf("Error loading %s", err)
@@ -331,7 +355,7 @@ func (s *Subscription) populateResources(r *rpc.Resources) {
 		if r.Errors == nil {
 			r.Errors = make(map[string]*reserr.Error)
 		}
-		r.Errors[s.rid] = reserr.RESError(err)
+		r.Errors[s.rid] = s.c.ClientError(err)
 		return
 	}
 
@@ -349,6 +373,14 @@ func (s *Subscription) populateResources(r *rpc.Resources) {
 			r.Models = make(map[string]interface{})
 		}
 		r.Models[s.rid] = s.model
+
+	case rescache.TypeStream:
+		// Create Streams map if needed
+		if r.Streams == nil {
+			r.Streams = make(map[string]interface{})
+		}
+		// Entries are only replayed on request
+		r.Streams[s.rid] = s.stream.From(s.stream.Next())
 	}
 
 	s.state = stateToSend
@@ -384,6 +416,23 @@ func (s *Subscription) setCollection() {
 	s.collection = c
 }
 
+// setStream sets the stream. Streams have no resource references.
+func (s *Subscription) setStream() {
+	st := s.resourceSub.GetStream()
+	s.queueEvents(queueReasonLoading)
+	s.resourceSub.Release()
+	s.stream = st
+}
+
+// replayStream replaces the subscription's stream in the resource set with
+// the stream entries from the offset. If the stream is not included in the
+// resource set, nothing will happen.
+func (s *Subscription) replayStream(r *rpc.Resources, offset int64) {
+	if _, ok := r.Streams[s.rid]; ok {
+		r.Streams[s.rid] = s.stream.From(offset)
+	}
+}
+
 // subscribeRef subscribes to any resource reference value
 // and adds it to s.refs.
 // If an error is encountered, all subscriptions in s.refs will
@@ -490,6 +539,9 @@ func (s *Subscription) removeReference(rid string) {
 // Event passes an event to the subscription to be processed.
 func (s *Subscription) Event(event *rescache.ResourceEvent) {
 	s.c.Enqueue(func() {
+		if end := s.c.StartEventAudit(event.Event); end != nil {
+			defer end()
+		}
 		if event.Event == "reaccess" {
 			s.reaccess()
 			return
@@ -507,6 +559,7 @@ func (s *Subscription) Event(event *rescache.ResourceEvent) {
 
 		s.processEvent(event)
 	})
+	s.c.ObserveQueue(s.ResourceName())
 }
 
 func (s *Subscription) processEvent(event *rescache.ResourceEvent) {
@@ -515,6 +568,8 @@ func (s *Subscription) processEvent(event *rescache.ResourceEvent) {
 		s.processCollectionEvent(event)
 	case rescache.TypeModel:
 		s.processModelEvent(event)
+	case rescache.TypeStream:
+		s.processStreamEvent(event)
 	default:
 		s.c.Errorf("Subscription %s: Unknown resource type: %d", s.rid, s.resourceSub.GetResourceType())
 	}
@@ -524,7 +579,10 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 	switch event.Event {
 	case "add":
 		v := event.Value
-		idx := event.Idx
+		idx, ok := s.filterAdd(event)
+		if !ok {
+			return
+		}
 
 		switch v.Type {
 		case codec.ValueTypeResource:
@@ -565,10 +623,18 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 	case "remove":
 		// Remove and unsubscribe to model
 		v := event.Value
+		idx, ok := s.filterRemove(event.Idx)
+		if !ok {
+			return
+		}
 
 		if v.Type == codec.ValueTypeResource {
 			s.removeReference(v.RID)
 		}
+		if idx != event.Idx {
+			s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.RemoveEvent{Idx: idx}))
+			return
+		}
 		s.c.Send(rpc.NewEvent(s.rid, event.Event, event.Payload))
 
 	case "delete":
@@ -579,9 +645,25 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 	}
 }
 
+func (s *Subscription) processStreamEvent(event *rescache.ResourceEvent) {
+	switch event.Event {
+	case "append":
+		s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.AppendEvent{Offset: event.Offset, Value: event.Value.RawMessage}))
+
+	case "delete":
+		s.state = stateDeleted
+		fallthrough
+	default:
+		s.c.Send(rpc.NewEvent(s.rid, event.Event, event.Payload))
+	}
+}
+
 func (s *Subscription) processModelEvent(event *rescache.ResourceEvent) {
 	switch event.Event {
 	case "change":
+		if !s.filterChange(event) {
+			return
+		}
 		ch := event.Changed
 		old := event.OldValues
 		var subs []*Subscription
@@ -678,8 +760,19 @@ func (s *Subscription) validateAccess(a *rescache.Access) {
 	err := a.CanGet()
 	if err != nil {
 		s.c.Unsubscribe(s, true, s.direct, true)
-		s.c.Send(rpc.NewEvent(s.rid, "unsubscribe", rpc.UnsubscribeEvent{Reason: reserr.RESError(err)}))
+		s.c.Send(rpc.NewEvent(s.rid, "unsubscribe", rpc.UnsubscribeEvent{Reason: s.c.ClientError(err)}))
+	}
+}
+
+// revoke clears any cached access, and removes all direct subscriptions,
+// sending an unsubscribe event with the reason.
+func (s *Subscription) revoke(reason error) {
+	s.access = nil
+	if s.direct == 0 {
+		return
 	}
+	s.c.Unsubscribe(s, true, s.direct, true)
+	s.c.Send(rpc.NewEvent(s.rid, "unsubscribe", rpc.UnsubscribeEvent{Reason: s.c.ClientError(reason)}))
 }
 
 // Dispose removes any resourceSubscription and sets
@@ -691,6 +784,7 @@ func (s *Subscription) Dispose() {
 
 	state := s.state
 	s.state = stateDisposed
+	s.setExpiry(0)
 	s.readyCallbacks = nil
 	s.eventQueue = nil
 
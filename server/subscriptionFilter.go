@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/filter"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// setFilter sets the event filter of the subscription, replacing any
+// previously set filter. A nil filter keeps the current filter.
+// Must be called on the connection worker goroutine.
+func (s *Subscription) setFilter(f *filter.Expr) {
+	if f != nil {
+		s.filter = f
+	}
+}
+
+// hasFilter reports whether the subscription has an event filter set by the
+// client, or by the access response.
+func (s *Subscription) hasFilter() bool {
+	return s.filter != nil || (s.access != nil && s.access.EventFilter != nil)
+}
+
+// matchFilter reports whether the event data matches both the filter set by
+// the client and the filter set by the access response.
+func (s *Subscription) matchFilter(data interface{}) bool {
+	if s.filter != nil && !s.filter.MatchValue(data) {
+		return false
+	}
+	if s.access != nil && s.access.EventFilter != nil && !s.access.EventFilter.MatchValue(data) {
+		return false
+	}
+	return true
+}
+
+// filterAdd reports whether a collection add event should be sent to the
+// client, and returns the index within the collection as seen by the client.
+// Hidden items are tracked so that the indexes of later events can be
+// adjusted.
+func (s *Subscription) filterAdd(event *rescache.ResourceEvent) (int, bool) {
+	idx := event.Idx
+	n := sort.SearchInts(s.hidden, idx)
+	for i := n; i < len(s.hidden); i++ {
+		s.hidden[i]++
+	}
+	if !s.hasFilter() || s.matchFilter(map[string]interface{}{
+		"idx":   float64(idx),
+		"value": decodeValue(event.Value),
+	}) {
+		return idx - n, true
+	}
+	s.hidden = append(s.hidden, 0)
+	copy(s.hidden[n+1:], s.hidden[n:])
+	s.hidden[n] = idx
+	return 0, false
+}
+
+// filterRemove reports whether a collection remove event should be sent to
+// the client, and returns the index within the collection as seen by the
+// client. A removed item that was hidden by the filter is not sent.
+func (s *Subscription) filterRemove(idx int) (int, bool) {
+	n := sort.SearchInts(s.hidden, idx)
+	hidden := n < len(s.hidden) && s.hidden[n] == idx
+	if hidden {
+		s.hidden = append(s.hidden[:n], s.hidden[n+1:]...)
+	}
+	for i := n; i < len(s.hidden); i++ {
+		s.hidden[i]--
+	}
+	return idx - n, !hidden
+}
+
+// filterChange reports whether a model change event should be sent to the
+// client. Changes setting or replacing resource references are always sent.
+func (s *Subscription) filterChange(event *rescache.ResourceEvent) bool {
+	if !s.hasFilter() {
+		return true
+	}
+	values := make(map[string]interface{}, len(event.Changed))
+	for k, v := range event.Changed {
+		if v.Type == codec.ValueTypeResource {
+			return true
+		}
+		if ov, ok := event.OldValues[k]; ok && ov.Type == codec.ValueTypeResource {
+			return true
+		}
+		values[k] = decodeValue(v)
+	}
+	return s.matchFilter(map[string]interface{}{"values": values})
+}
+
+// decodeValue decodes a value into the types used by encoding/json for an
+// interface{}.
+func decodeValue(v codec.Value) interface{} {
+	var d interface{}
+	if json.Unmarshal(v.RawMessage, &d) != nil {
+		return nil
+	}
+	return d
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// subscriptionTTL returns the time after which a direct subscription made
+// by a subscribe request expires, with the ttl in milliseconds requested by
+// the client capped by the subscriptionTTL setting. Zero means no expiry.
+func (c *wsConn) subscriptionTTL(ttl int) time.Duration {
+	max := c.serv.cfg.SubscriptionTTL
+	if ttl == 0 || (max > 0 && ttl > max) {
+		ttl = max
+	}
+	return time.Duration(ttl) * time.Millisecond
+}
+
+// setExpiry sets the time after which the direct subscriptions expire,
+// replacing any previously set expiry. Zero clears the expiry.
+// Must be called on the connection worker goroutine.
+func (s *Subscription) setExpiry(d time.Duration) {
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	if d <= 0 {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		s.c.Enqueue(func() {
+			// Ignore if the expiry has been replaced or cleared
+			if s.expiry == t {
+				s.expire()
+			}
+		})
+	})
+	s.expiry = t
+}
+
+// expire removes all direct subscriptions, and sends an unsubscribe event to
+// the client.
+func (s *Subscription) expire() {
+	s.expiry = nil
+	if s.direct == 0 || s.state == stateDisposed {
+		return
+	}
+	s.c.Debugf("Subscription %s: Subscription expired", s.rid)
+	s.c.Unsubscribe(s, true, s.direct, true)
+	s.c.Send(rpc.NewEvent(s.rid, "unsubscribe", rpc.UnsubscribeEvent{Reason: s.c.ClientError(reserr.ErrSubscriptionExpired)}))
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/url"
+	"runtime"
+)
+
+// startupSummary is the machine-readable summary logged on start.
+type startupSummary struct {
+	Version    string           `json:"version"`
+	Protocol   string           `json:"protocol"`
+	GoVersion  string           `json:"goVersion"`
+	InstanceID string           `json:"instanceId"`
+	Listeners  summaryListeners `json:"listeners"`
+	NATS       summaryNATS      `json:"nats"`
+	Config     Config           `json:"config"`
+}
+
+// summaryListeners holds the addresses the service listens on.
+type summaryListeners struct {
+	HTTP   []string `json:"http"`
+	Socket string   `json:"socket,omitempty"`
+	Admin  string   `json:"admin,omitempty"`
+}
+
+// summaryNATS holds the NATS connection status.
+type summaryNATS struct {
+	Connected bool `json:"connected"`
+}
+
+// logStartupSummary logs a JSON encoded summary of the version, listener
+// addresses, NATS status, and effective configuration, if enabled.
+// Service.mu is held when called
+func (s *Service) logStartupSummary() {
+	if !s.cfg.StartupSummary {
+		return
+	}
+	data, err := json.Marshal(s.summary())
+	if err != nil {
+		s.Errorf("Error encoding startup summary: %s", err)
+		return
+	}
+	s.Logf("Startup summary %s", data)
+}
+
+// summary returns a summary of the version, listener addresses, NATS
+// status, and effective configuration.
+func (s *Service) summary() startupSummary {
+	sum := startupSummary{
+		Version:    Version,
+		Protocol:   ProtocolVersion,
+		GoVersion:  runtime.Version(),
+		InstanceID: s.id,
+		Listeners:  summaryListeners{HTTP: []string{}},
+		NATS:       summaryNATS{Connected: s.mq != nil && !s.mq.IsClosed()},
+		Config:     s.cfg,
+	}
+	if !s.cfg.NoHTTP {
+		if s.cfg.Socket == nil {
+			sum.Listeners.HTTP = append(sum.Listeners.HTTP, s.cfg.scheme+"://"+s.cfg.netAddr)
+		} else {
+			sum.Listeners.Socket = *s.cfg.Socket
+		}
+		for _, la := range s.cfg.listenAddrs {
+			sum.Listeners.HTTP = append(sum.Listeners.HTTP, s.cfg.scheme+"://"+la.addr)
+		}
+		if s.cfg.adminNetAddr != "" {
+			sum.Listeners.Admin = "http://" + s.cfg.adminNetAddr
+		}
+	}
+	// Credentials in URLs are not logged
+	sum.Config.RedisURL = redactURL(s.cfg.RedisURL)
+	sum.Config.PayloadEncryption = redactPayloadEncryption(s.cfg.PayloadEncryption)
+	sum.Config.WebhookSigning = redactWebhookSigning(s.cfg.WebhookSigning)
+	sum.Config.Uploads = redactUploads(s.cfg.Uploads)
+	if s.cfg.OutboxTokenKey != nil {
+		redacted := "xxxxx"
+		sum.Config.OutboxTokenKey = &redacted
+	}
+	if a := s.cfg.Audit; a != nil {
+		ac := *a
+		ac.URL = redactURL(a.URL)
+		sum.Config.Audit = &ac
+	}
+	return sum
+}
+
+// redactURL returns a copy of the URL with any password replaced.
+func redactURL(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	u, err := url.Parse(*s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	r := u.String()
+	return &r
+}
@@ -0,0 +1,528 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jirenius/timerqueue"
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/rs/xid"
+)
+
+// SurrogateKeysConfig holds the configuration for surrogate keys on HTTP API
+// GET responses, and for purging them from a CDN when the resources change.
+//
+// Each GET response gets a header listing the name of the resource, and of
+// each resource it references, directly or through other references. After
+// a resource is served, its events are listened to for maxAge milliseconds,
+// and the keys of resources changed, or reset by a system reset event, are
+// purged.
+type SurrogateKeysConfig struct {
+	// Header is the name of the response header. Defaults to
+	// "Surrogate-Key".
+	Header string `json:"header,omitempty"`
+	// Prefix is prepended to each key, for CDN services shared with other
+	// content.
+	Prefix string `json:"prefix,omitempty"`
+	// MaxAge is the time in milliseconds to listen for changes to a
+	// resource after it was last served. It should be no shorter than the
+	// CDN cache lifetime. Defaults to 3600000.
+	MaxAge int `json:"maxAge,omitempty"`
+	// Purge is the CDN API to send purge requests to. Nil means keys are
+	// only set on responses.
+	Purge *SurrogatePurgeConfig `json:"purge,omitempty"`
+}
+
+// SurrogatePurgeConfig holds the configuration for sending purge requests
+// to a CDN API.
+type SurrogatePurgeConfig struct {
+	// Provider is the CDN provider: "fastly" or "cloudfront". Fastly purges
+	// by surrogate key, and CloudFront creates invalidations for the HTTP
+	// API paths of the resources, including any query.
+	Provider string `json:"provider"`
+	// URL is the base URL of the CDN API. Defaults to the API of the
+	// provider.
+	URL string `json:"url,omitempty"`
+	// ServiceID is the Fastly service ID.
+	ServiceID string `json:"serviceId,omitempty"`
+	// Token is the Fastly API token.
+	Token string `json:"token,omitempty"`
+	// SoftPurge marks Fastly content as stale instead of removing it.
+	SoftPurge bool `json:"softPurge,omitempty"`
+	// DistributionID is the CloudFront distribution ID.
+	DistributionID string `json:"distributionId,omitempty"`
+	// AccessKey is the AWS access key ID for CloudFront.
+	AccessKey string `json:"accessKey,omitempty"`
+	// SecretKey is the AWS secret access key for CloudFront.
+	SecretKey string `json:"secretKey,omitempty"`
+	// Delay is the time in milliseconds to collect keys before sending a
+	// batched purge request. Defaults to 1000.
+	Delay int `json:"delay,omitempty"`
+}
+
+// Surrogate key defaults
+const (
+	SurrogateKeyHeader  = "Surrogate-Key"
+	SurrogateKeyMaxAge  = 3600000
+	SurrogatePurgeDelay = 1000
+)
+
+// Maximum number of keys, or paths, in a single purge request.
+const (
+	fastlyPurgeBatch     = 256
+	cloudFrontPurgeBatch = 3000
+)
+
+// surrogateEvents are the resource events purging the resource.
+var surrogateEvents = map[string]bool{
+	"change": true,
+	"add":    true,
+	"remove": true,
+	"delete": true,
+	"query":  true,
+}
+
+// surrogateClient is a mq.Client keeping event subscriptions alive for
+// resources served with surrogate keys, purging the keys of resources
+// changed. Subscriptions made by the cache share the same underlying
+// subscription.
+type surrogateClient struct {
+	mqWrapper
+	s   *Service
+	cfg *SurrogateKeysConfig
+	tq  *timerqueue.Queue
+	do  func(keys []string) error
+
+	mu      sync.Mutex
+	subs    map[string]*surrogateSub // By resource name
+	pending map[string]bool
+	timer   *time.Timer
+}
+
+// surrogateSub is a shared event subscription for a resource.
+type surrogateSub struct {
+	c       *surrogateClient
+	rname   string
+	sub     mq.Unsubscriber
+	cb      mq.Response // Callback of the cache subscription, if any
+	tracked bool        // Tracked until max age after last served
+}
+
+// surrogateUnsub is the Unsubscriber of a cache subscription.
+type surrogateUnsub struct {
+	ss *surrogateSub
+}
+
+// prepare validates the surrogate keys configuration and sets default
+// values.
+func (c *SurrogateKeysConfig) prepare() error {
+	if c.Header == "" {
+		c.Header = SurrogateKeyHeader
+	}
+	if strings.ContainsAny(c.Prefix, " \t") {
+		return errors.New("prefix must not contain whitespace")
+	}
+	if c.MaxAge < 0 {
+		return errors.New("maxAge must be zero or a positive number of milliseconds")
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = SurrogateKeyMaxAge
+	}
+	if c.Purge != nil {
+		if err := c.Purge.prepare(); err != nil {
+			return fmt.Errorf("purge %s", err)
+		}
+	}
+	return nil
+}
+
+func (c *SurrogatePurgeConfig) prepare() error {
+	switch c.Provider {
+	case "fastly":
+		if c.URL == "" {
+			c.URL = "https://api.fastly.com"
+		}
+		if c.ServiceID == "" || c.Token == "" {
+			return errors.New("serviceId and token must not be empty for the fastly provider")
+		}
+	case "cloudfront":
+		if c.URL == "" {
+			c.URL = "https://cloudfront.amazonaws.com"
+		}
+		if c.DistributionID == "" || c.AccessKey == "" || c.SecretKey == "" {
+			return errors.New("distributionId, accessKey, and secretKey must not be empty for the cloudfront provider")
+		}
+	default:
+		return fmt.Errorf("provider %q must be fastly or cloudfront", c.Provider)
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be a valid http or https URL", c.URL)
+	}
+	c.URL = strings.TrimRight(c.URL, "/")
+	if c.Delay < 0 {
+		return errors.New("delay must be zero or a positive number of milliseconds")
+	}
+	if c.Delay == 0 {
+		c.Delay = SurrogatePurgeDelay
+	}
+	return nil
+}
+
+// initSurrogateKeys wraps the messaging client to purge surrogate keys, if
+// configured with a purge API.
+func (s *Service) initSurrogateKeys() {
+	cfg := s.cfg.SurrogateKeys
+	if cfg == nil || cfg.Purge == nil {
+		return
+	}
+	c := &surrogateClient{
+		mqWrapper: mqWrapper{s.mq},
+		s:         s,
+		cfg:       cfg,
+		subs:      make(map[string]*surrogateSub),
+		pending:   make(map[string]bool),
+	}
+	client := &http.Client{Timeout: SurrogatePurgeTimeout}
+	switch cfg.Purge.Provider {
+	case "fastly":
+		c.do = func(keys []string) error { return c.purgeFastly(client, keys) }
+	case "cloudfront":
+		c.do = func(keys []string) error { return c.purgeCloudFront(client, keys) }
+	}
+	c.tq = timerqueue.New(c.expire, time.Duration(cfg.MaxAge)*time.Millisecond)
+	s.surrogate = c
+	s.mq = c
+}
+
+// setSurrogateKeys sets the surrogate key header for the subscription,
+// listing the names of the resource and all resources it references, and
+// listens for changes to them.
+func (s *Service) setSurrogateKeys(w http.ResponseWriter, sub *Subscription) {
+	cfg := s.cfg.SurrogateKeys
+	seen := map[string]bool{}
+	names := map[string]bool{}
+	var rnames []string
+	var add func(sub *Subscription)
+	add = func(sub *Subscription) {
+		if seen[sub.RID()] {
+			return
+		}
+		seen[sub.RID()] = true
+		if rname := sub.ResourceName(); !names[rname] {
+			names[rname] = true
+			rnames = append(rnames, rname)
+		}
+		var vals []codec.Value
+		switch sub.ResourceType() {
+		case rescache.TypeModel:
+			for _, v := range sub.ModelValues() {
+				vals = append(vals, v)
+			}
+		case rescache.TypeCollection:
+			vals = sub.CollectionValues()
+		}
+		for _, v := range vals {
+			if v.Type != codec.ValueTypeResource {
+				continue
+			}
+			if ref := sub.Ref(v.RID); ref != nil && ref.Error() == nil {
+				add(ref)
+			}
+		}
+	}
+	add(sub)
+	sort.Strings(rnames)
+
+	keys := make([]string, len(rnames))
+	for i, rname := range rnames {
+		keys[i] = cfg.Prefix + rname
+	}
+	w.Header().Set(cfg.Header, strings.Join(keys, " "))
+	if s.surrogate != nil {
+		s.surrogate.track(rnames)
+	}
+}
+
+// Subscribe subscribes to the namespace, sharing any underlying event
+// subscription kept alive for purging. System events are passed through to
+// purge the keys of reset resources.
+func (c *surrogateClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	if namespace == "system" {
+		return c.Client.Subscribe(namespace, func(subj string, payload []byte, err error) {
+			if subj == "system.reset" {
+				c.handleReset(payload)
+			}
+			cb(subj, payload, err)
+		})
+	}
+	if !strings.HasPrefix(namespace, "event.") {
+		return c.Client.Subscribe(namespace, cb)
+	}
+	rname := namespace[len("event."):]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ss, ok := c.subs[rname]
+	if !ok {
+		ss = &surrogateSub{c: c, rname: rname}
+		sub, err := c.Client.Subscribe(namespace, ss.handle)
+		if err != nil {
+			return nil, err
+		}
+		ss.sub = sub
+		c.subs[rname] = ss
+	}
+	ss.cb = cb
+	return &surrogateUnsub{ss: ss}, nil
+}
+
+// Unsubscribe removes the cache subscription, and unsubscribes with the
+// underlying client unless the resource is tracked.
+func (u *surrogateUnsub) Unsubscribe() error {
+	c := u.ss.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u.ss.cb = nil
+	if u.ss.tracked {
+		return nil
+	}
+	delete(c.subs, u.ss.rname)
+	return u.ss.sub.Unsubscribe()
+}
+
+// handle purges the resource on events changing it, and passes the event on
+// to any cache subscription.
+func (ss *surrogateSub) handle(subj string, payload []byte, err error) {
+	c := ss.c
+	c.mu.Lock()
+	cb, tracked := ss.cb, ss.tracked
+	c.mu.Unlock()
+	if tracked && surrogateEvents[subj[len("event.")+len(ss.rname)+1:]] {
+		c.purge([]string{ss.rname})
+	}
+	if cb != nil {
+		cb(subj, payload, err)
+	}
+}
+
+// track listens for changes to the resources until max age after they were
+// last served.
+func (c *surrogateClient) track(rnames []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rname := range rnames {
+		ss, ok := c.subs[rname]
+		if !ok {
+			ss = &surrogateSub{c: c, rname: rname}
+			sub, err := c.Client.Subscribe("event."+rname, ss.handle)
+			if err != nil {
+				c.s.Errorf("Error subscribing to surrogate key events for %s: %s", rname, err)
+				continue
+			}
+			ss.sub = sub
+			c.subs[rname] = ss
+		}
+		if !ss.tracked || !c.tq.Reset(rname) {
+			ss.tracked = true
+			c.tq.Add(rname)
+		}
+	}
+}
+
+// expire stops tracking the resource, unsubscribing unless it is still
+// subscribed to by the cache.
+func (c *surrogateClient) expire(v interface{}) {
+	rname := v.(string)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ss, ok := c.subs[rname]
+	if !ok {
+		return
+	}
+	ss.tracked = false
+	if ss.cb == nil {
+		delete(c.subs, rname)
+		_ = ss.sub.Unsubscribe()
+	}
+}
+
+// handleReset purges the tracked resources matching the resource patterns
+// of a system reset event.
+func (c *surrogateClient) handleReset(payload []byte) {
+	r, err := codec.DecodeSystemReset(payload)
+	if err != nil {
+		return
+	}
+	patterns := make([]rescache.ResourcePattern, 0, len(r.Resources))
+	for _, p := range r.Resources {
+		patterns = append(patterns, rescache.ParseResourcePattern(p))
+	}
+	var rnames []string
+	c.mu.Lock()
+	for rname, ss := range c.subs {
+		if !ss.tracked {
+			continue
+		}
+		for _, p := range patterns {
+			if p.Match(rname) {
+				rnames = append(rnames, rname)
+				break
+			}
+		}
+	}
+	c.mu.Unlock()
+	if len(rnames) > 0 {
+		c.purge(rnames)
+	}
+}
+
+// purge adds the resources to the pending purge, sent after the delay.
+func (c *surrogateClient) purge(rnames []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rname := range rnames {
+		c.pending[rname] = true
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(time.Duration(c.cfg.Purge.Delay)*time.Millisecond, c.flush)
+	}
+}
+
+// flush sends purge requests for the pending resources, in batches.
+func (c *surrogateClient) flush() {
+	c.mu.Lock()
+	rnames := make([]string, 0, len(c.pending))
+	for rname := range c.pending {
+		rnames = append(rnames, rname)
+	}
+	c.pending = make(map[string]bool)
+	c.timer = nil
+	c.mu.Unlock()
+
+	sort.Strings(rnames)
+	if err := c.do(rnames); err != nil {
+		c.s.Errorf("Error purging surrogate keys: %s", err)
+	}
+}
+
+// stopSurrogateKeys stops any pending purge, and the tracking of resources.
+func (s *Service) stopSurrogateKeys() {
+	c := s.surrogate
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.mu.Unlock()
+	c.tq.Clear()
+}
+
+// purgeFastly purges the keys of the resources with the Fastly purge by
+// surrogate key API.
+func (c *surrogateClient) purgeFastly(client *http.Client, rnames []string) error {
+	p := c.cfg.Purge
+	for len(rnames) > 0 {
+		n := len(rnames)
+		if n > fastlyPurgeBatch {
+			n = fastlyPurgeBatch
+		}
+		keys := make([]string, n)
+		for i, rname := range rnames[:n] {
+			keys[i] = c.cfg.Prefix + rname
+		}
+		rnames = rnames[n:]
+
+		req, err := http.NewRequest("POST", p.URL+"/service/"+url.PathEscape(p.ServiceID)+"/purge", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Fastly-Key", p.Token)
+		req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+		req.Header.Set("Accept", "application/json")
+		if p.SoftPurge {
+			req.Header.Set("Fastly-Soft-Purge", "1")
+		}
+		if err := doPurge(client, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloudFrontInvalidation is the CreateInvalidation request body.
+type cloudFrontInvalidation struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	CallerReference string   `xml:"CallerReference"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Items           []string `xml:"Paths>Items>Path"`
+}
+
+// purgeCloudFront creates CloudFront invalidations for the HTTP API paths of
+// the resources, with a wildcard to include any query.
+func (c *surrogateClient) purgeCloudFront(client *http.Client, rnames []string) error {
+	p := c.cfg.Purge
+	for len(rnames) > 0 {
+		n := len(rnames)
+		if n > cloudFrontPurgeBatch {
+			n = cloudFrontPurgeBatch
+		}
+		paths := make([]string, n)
+		for i, rname := range rnames[:n] {
+			paths[i] = c.s.cfg.ResourcePath(rname) + "*"
+		}
+		rnames = rnames[n:]
+
+		body, err := xml.Marshal(cloudFrontInvalidation{
+			CallerReference: xid.New().String(),
+			Quantity:        len(paths),
+			Items:           paths,
+		})
+		if err != nil {
+			return err
+		}
+		body = append([]byte(xml.Header), body...)
+		req, err := http.NewRequest("POST", p.URL+"/2020-05-31/distribution/"+url.PathEscape(p.DistributionID)+"/invalidation", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/xml")
+		sum := sha256.Sum256(body)
+		signAWSv4(req, p.AccessKey, p.SecretKey, "us-east-1", "cloudfront", hex.EncodeToString(sum[:]), time.Now())
+		if err := doPurge(client, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doPurge sends the purge request and returns an error if it was
+// unsuccessful.
+func doPurge(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
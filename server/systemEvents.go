@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// handleSystemEvent handles system events not handled by the cache.
+func (s *Service) handleSystemEvent(event string, payload []byte) {
+	switch event {
+	case "subscribe":
+		s.handleSystemSubscribe(payload)
+	case "broadcast":
+		s.handleSystemBroadcast(payload)
+	case "disconnect":
+		s.handleSystemDisconnect(payload)
+	case "throttle":
+		s.handleSystemThrottle(payload)
+	case "revoke":
+		s.handleSystemRevoke(payload)
+	}
+}
+
+func (s *Service) handleSystemSubscribe(payload []byte) {
+	e, err := codec.DecodeSystemSubscribeEvent(payload)
+	if err != nil {
+		s.Errorf("Error processing system subscribe event: malformed event payload: %s", err)
+		return
+	}
+
+	s.forEachConn(func(c *wsConn) {
+		c.Enqueue(func() {
+			if c.matches(e.ConnSelector) {
+				c.PushSubscribe(e.RID)
+			}
+		})
+	})
+}
+
+func (s *Service) handleSystemBroadcast(payload []byte) {
+	e, err := codec.DecodeSystemBroadcastEvent(payload)
+	if err != nil {
+		s.Errorf("Error processing system broadcast event: malformed event payload: %s", err)
+		return
+	}
+
+	data := rpc.NewBroadcastEvent(e.Name, e.Data)
+	s.forEachConn(func(c *wsConn) {
+		c.Enqueue(func() {
+			if c.persistent() && c.matches(e.ConnSelector) {
+				c.Send(data)
+			}
+		})
+	})
+}
+
+func (s *Service) handleSystemDisconnect(payload []byte) {
+	e, err := codec.DecodeSystemDisconnectEvent(payload)
+	if err != nil {
+		s.Errorf("Error processing system disconnect event: malformed event payload: %s", err)
+		return
+	}
+
+	s.forEachConn(func(c *wsConn) {
+		c.Enqueue(func() {
+			if c.matches(e.ConnSelector) {
+				c.Disconnect("disconnected by system event")
+			}
+		})
+	})
+}
+
+func (s *Service) handleSystemThrottle(payload []byte) {
+	e, err := codec.DecodeSystemThrottleEvent(payload)
+	if err != nil {
+		s.Errorf("Error processing system throttle event: malformed event payload: %s", err)
+		return
+	}
+
+	s.forEachConn(func(c *wsConn) {
+		c.Enqueue(func() {
+			if c.matches(e.ConnSelector) {
+				c.setThrottle(e.Rate, time.Duration(e.Duration)*time.Millisecond)
+			}
+		})
+	})
+}
+
+func (s *Service) handleSystemRevoke(payload []byte) {
+	e, err := codec.DecodeSystemRevokeEvent(payload)
+	if err != nil {
+		s.Errorf("Error processing system revoke event: malformed event payload: %s", err)
+		return
+	}
+	patterns, err := parseResourcePatterns(e.Resources)
+	if err != nil {
+		s.Errorf("Error processing system revoke event: %s", err)
+		return
+	}
+	reason := reserr.ErrAccessDenied
+	if e.Reason != nil {
+		reason = e.Reason
+	}
+
+	s.forEachConn(func(c *wsConn) {
+		c.Enqueue(func() {
+			if c.matches(e.ConnSelector) {
+				c.RevokeSubscriptions(patterns, reason)
+			}
+		})
+	})
+}
+
+// forEachConn calls the callback for each open connection.
+func (s *Service) forEachConn(cb func(c *wsConn)) {
+	s.mu.Lock()
+	conns := make([]*wsConn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		cb(c)
+	}
+}
+
+// tokenClaimsEqual returns true if both tokens are JSON objects where each of
+// the claims is either missing in both, or set with equal values.
+func tokenClaimsEqual(a, b json.RawMessage, claims []string) bool {
+	var ta, tb map[string]interface{}
+	if json.Unmarshal(a, &ta) != nil || json.Unmarshal(b, &tb) != nil || ta == nil || tb == nil {
+		return false
+	}
+	for _, k := range claims {
+		va, oka := ta[k]
+		vb, okb := tb[k]
+		if oka != okb || !reflect.DeepEqual(va, vb) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenMatches returns true if the token is a JSON object containing all
+// the given claims with equal values.
+func tokenMatches(token json.RawMessage, claims map[string]json.RawMessage) bool {
+	if len(token) == 0 {
+		return false
+	}
+	var t map[string]interface{}
+	if json.Unmarshal(token, &t) != nil || t == nil {
+		return false
+	}
+	for k, raw := range claims {
+		v, ok := t[k]
+		if !ok {
+			return false
+		}
+		var cv interface{}
+		if json.Unmarshal(raw, &cv) != nil || !reflect.DeepEqual(v, cv) {
+			return false
+		}
+	}
+	return true
+}
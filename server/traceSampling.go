@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// TraceSamplingConfig holds the configuration for limiting trace logging to a
+// sample of connections and to resources matching patterns.
+type TraceSamplingConfig struct {
+	// Percent is the percentage of WebSocket connections to trace.
+	Percent float64 `json:"percent"`
+	// Patterns are the resource patterns for which NATS messages are traced.
+	Patterns []string `json:"patterns"`
+}
+
+// prepare validates the trace sampling configuration, and returns the
+// parsed resource patterns.
+func (c *TraceSamplingConfig) prepare() ([]rescache.ResourcePattern, error) {
+	if c.Percent < 0 || c.Percent > 100 {
+		return nil, errors.New("percent must be a number between 0 and 100")
+	}
+	ps := make([]rescache.ResourcePattern, 0, len(c.Patterns))
+	for _, p := range c.Patterns {
+		rp := rescache.ParseResourcePattern(p)
+		if !rp.IsValid() {
+			return nil, fmt.Errorf("pattern %q must be a valid resource pattern", p)
+		}
+		ps = append(ps, rp)
+	}
+	return ps, nil
+}
+
+// initTraceSampling sets the trace filter of the messaging client, if trace
+// sampling is configured and supported by the client.
+func (s *Service) initTraceSampling() {
+	if s.cfg.TraceSampling == nil {
+		return
+	}
+	if f, ok := s.mq.(mq.TraceFilterer); ok {
+		f.SetTraceFilter(s.traceSubject)
+	}
+}
+
+// sampleTrace returns true if a new connection should be traced.
+func (s *Service) sampleTrace() bool {
+	if s.cfg.TraceSampling == nil {
+		return true
+	}
+	return rand.Float64()*100 < s.cfg.TraceSampling.Percent
+}
+
+// traceSubject returns true if messages on the NATS subject should be traced.
+func (s *Service) traceSubject(subj string) bool {
+	return matchSubject(subj, func(rid string) bool {
+		for _, p := range s.cfg.tracePatterns {
+			if p.Match(rid) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// matchSubject returns true if the part of the NATS subject after the message
+// type, such as "get" or "event", is matched, either as a whole or without the
+// last token, which may be a method or event name.
+func matchSubject(subj string, match func(rid string) bool) bool {
+	idx := strings.IndexByte(subj, '.')
+	if idx < 0 {
+		return false
+	}
+	rid := subj[idx+1:]
+	if match(rid) {
+		return true
+	}
+	if idx = strings.LastIndexByte(rid, '.'); idx < 0 {
+		return false
+	}
+	return match(rid[:idx])
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// prepareTrustedProxies validates the trusted proxies, each being an IP
+// address or a CIDR range, and prepares the list of networks.
+func (c *Config) prepareTrustedProxies() error {
+	c.trustedProxies = nil
+	for _, p := range c.TrustedProxies {
+		if strings.IndexByte(p, '/') >= 0 {
+			_, n, err := net.ParseCIDR(p)
+			if err != nil {
+				return fmt.Errorf("proxy %q must be a valid IP address or CIDR range", p)
+			}
+			c.trustedProxies = append(c.trustedProxies, n)
+			continue
+		}
+		ip := net.ParseIP(p)
+		if ip == nil {
+			return fmt.Errorf("proxy %q must be a valid IP address or CIDR range", p)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		c.trustedProxies = append(c.trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nil
+}
+
+// remoteIP returns the IP address of the client making the request. If the
+// request is made from a trusted proxy, the address is taken from the
+// Forwarded header, or if missing, the X-Forwarded-For header, as the last
+// address not being a trusted proxy.
+func (s *Service) remoteIP(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !s.trustedProxy(ip) {
+		return ip
+	}
+	addrs := forwardedFor(r)
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr := addrs[i]
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		ip = addr
+		if !s.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// trustedProxy reports whether the IP address is a trusted proxy.
+func (s *Service) trustedProxy(ip string) bool {
+	if len(s.cfg.trustedProxies) == 0 {
+		return false
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range s.cfg.trustedProxies {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the forwarded addresses of the request, with the
+// client first, taken from the for parameters of the Forwarded header, or if
+// missing, from the X-Forwarded-For header. Ports and brackets around IPv6
+// addresses are removed, while obfuscated or unknown addresses are kept
+// as is.
+func forwardedFor(r *http.Request) []string {
+	var addrs []string
+	if fwd := r.Header["Forwarded"]; len(fwd) > 0 {
+		for _, h := range fwd {
+			for _, elem := range strings.Split(h, ",") {
+				addr := ""
+				for _, pair := range strings.Split(elem, ";") {
+					pair = strings.TrimSpace(pair)
+					if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+						addr = forwardedAddr(strings.Trim(pair[4:], `"`))
+					}
+				}
+				addrs = append(addrs, addr)
+			}
+		}
+		return addrs
+	}
+	for _, h := range r.Header["X-Forwarded-For"] {
+		for _, addr := range strings.Split(h, ",") {
+			addrs = append(addrs, forwardedAddr(strings.TrimSpace(addr)))
+		}
+	}
+	return addrs
+}
+
+// forwardedAddr returns the address without any port, or brackets around an
+// IPv6 address.
+func forwardedAddr(addr string) string {
+	if net.ParseIP(addr) != nil {
+		return addr
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
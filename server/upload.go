@@ -0,0 +1,264 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/rs/xid"
+)
+
+// UploadMaxSize is the default maximum size of an upload request.
+const UploadMaxSize = 32 << 20
+
+// UploadConfig holds the configuration for accepting file uploads as
+// multipart/form-data on HTTP POST call requests.
+//
+// Each file in the form is put in the upload store, and passed to the service
+// in the call parameters as a reference to the stored file:
+//
+//	{"url":"<url>","name":"<file name>","contentType":"<type>","size":<bytes>,"sha256":"<hex checksum>"}
+//
+// Other form fields are passed as string parameters. Stored files are deleted
+// if the call request fails.
+type UploadConfig struct {
+	// Pattern is the resource pattern of the call requests.
+	Pattern string `json:"pattern"`
+	// Method is the call method accepting uploads.
+	Method string `json:"method"`
+	// Dir is the directory where uploaded files are stored by the disk store.
+	// Other stores use it to stage files before they are put in the store,
+	// and default to the system temporary directory.
+	Dir string `json:"dir"`
+	// URL is the base URL of the stored files, to which the stored file name
+	// is appended. Required for the disk store. Other stores default to the
+	// URL of the stored object.
+	URL string `json:"url"`
+	// MaxSize is the maximum size in bytes of the files and fields of an
+	// upload. Defaults to 32 MiB.
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// Store is the configuration of the store that files are put in.
+	// Defaults to the disk store.
+	Store *UploadStoreConfig `json:"store,omitempty"`
+}
+
+// uploadRule is a prepared UploadConfig.
+type uploadRule struct {
+	UploadConfig
+	pattern rescache.ResourcePattern
+	store   UploadStore
+}
+
+// uploadedFile is the reference to a stored file passed in the call
+// parameters.
+type uploadedFile struct {
+	URL         string `json:"url"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+
+	key string
+}
+
+var errUploadTooLarge = &reserr.Error{Code: reserr.CodeBadRequest, Message: "Upload too large"}
+var errDuplicateFormField = &reserr.Error{Code: reserr.CodeBadRequest, Message: "Duplicate form field"}
+var errUploadStoreFailed = &reserr.Error{Code: reserr.CodeInternalError, Message: "Failed to store upload"}
+
+// prepare validates the upload configuration, and returns the prepared rule.
+func (c UploadConfig) prepare() (uploadRule, error) {
+	p := rescache.ParseResourcePattern(c.Pattern)
+	if !p.IsValid() {
+		return uploadRule{}, fmt.Errorf("pattern %q must be a valid resource pattern", c.Pattern)
+	}
+	if !codec.IsValidRIDPart(c.Method) {
+		return uploadRule{}, fmt.Errorf("method %q must be a valid call method", c.Method)
+	}
+	disk := c.storeType() == "disk"
+	if c.Dir == "" && disk {
+		return uploadRule{}, errors.New("dir must not be empty")
+	}
+	if _, err := url.Parse(c.URL); err != nil || (c.URL == "" && disk) {
+		return uploadRule{}, fmt.Errorf("url %q must be a valid URL", c.URL)
+	}
+	if _, ok := uploadStoreFactories[c.storeType()]; !ok {
+		return uploadRule{}, fmt.Errorf("store type %q is not a registered upload store", c.storeType())
+	}
+	if c.MaxSize < 0 {
+		return uploadRule{}, errors.New("maxSize must be zero or a positive number of bytes")
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = UploadMaxSize
+	}
+	return uploadRule{UploadConfig: c, pattern: p}, nil
+}
+
+// storeType returns the upload store type, defaulting to disk.
+func (c UploadConfig) storeType() string {
+	if c.Store == nil || c.Store.Type == "" {
+		return "disk"
+	}
+	return c.Store.Type
+}
+
+// uploadRule returns the first upload rule matching the call request if it
+// has a multipart/form-data body, or nil if it is not an upload.
+func (s *Service) uploadRule(r *http.Request, rid, action string) *uploadRule {
+	if len(s.cfg.uploadRules) == 0 {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/form-data" {
+		return nil
+	}
+	rname, _ := parseRID(rid)
+	for i := range s.cfg.uploadRules {
+		ur := &s.cfg.uploadRules[i]
+		if ur.Method == action && ur.pattern.Match(rname) {
+			return ur
+		}
+	}
+	return nil
+}
+
+// readUpload stores the files of a multipart/form-data upload, and returns
+// the call parameters with the file references and form fields.
+func (s *Service) readUpload(ur *uploadRule, r *http.Request) (json.RawMessage, []*uploadedFile, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error reading upload: " + err.Error()}
+	}
+
+	params := make(map[string]interface{})
+	var files []*uploadedFile
+	remaining := ur.MaxSize
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			if _, ok := params[p.FormName()]; ok {
+				err = errDuplicateFormField
+			} else if p.FileName() != "" {
+				var f *uploadedFile
+				f, err = s.storeFile(ur, p, &remaining)
+				if f != nil {
+					files = append(files, f)
+					params[p.FormName()] = f
+				}
+			} else {
+				var v []byte
+				v, err = ioutil.ReadAll(io.LimitReader(p, remaining+1))
+				remaining -= int64(len(v))
+				if err == nil && remaining < 0 {
+					err = errUploadTooLarge
+				}
+				params[p.FormName()] = string(v)
+			}
+		}
+		if err != nil {
+			s.removeUploads(ur, files)
+			if _, ok := err.(*reserr.Error); !ok {
+				err = &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error reading upload: " + err.Error()}
+			}
+			return nil, nil, err
+		}
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		s.removeUploads(ur, files)
+		return nil, nil, reserr.InternalError(err)
+	}
+	return data, files, nil
+}
+
+// storeFile stages the file of a form part, counting its size against the
+// remaining upload size, and puts it in the upload store.
+func (s *Service) storeFile(ur *uploadRule, p *multipart.Part, remaining *int64) (*uploadedFile, error) {
+	tmp, err := ioutil.TempFile(ur.Dir, ".upload-")
+	if err != nil {
+		return nil, reserr.InternalError(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(p, *remaining+1))
+	*remaining -= n
+	if err != nil {
+		return nil, err
+	}
+	if *remaining < 0 {
+		return nil, errUploadTooLarge
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, reserr.InternalError(err)
+	}
+
+	ct := p.Header.Get("Content-Type")
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	obj := UploadObject{
+		Name:        xid.New().String() + fileExt(p.FileName()),
+		ContentType: ct,
+		Size:        n,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+	}
+	u, err := ur.store.Put(obj, tmp)
+	if err != nil {
+		s.Errorf("Error putting upload %s in %s store: %s", obj.Name, ur.storeType(), err)
+		return nil, errUploadStoreFailed
+	}
+	if ur.URL != "" {
+		u = ur.URL + obj.Name
+	}
+	return &uploadedFile{
+		URL:         u,
+		Name:        p.FileName(),
+		ContentType: obj.ContentType,
+		Size:        obj.Size,
+		SHA256:      obj.SHA256,
+		key:         obj.Name,
+	}, nil
+}
+
+// removeUploads deletes stored files from the upload store.
+func (s *Service) removeUploads(ur *uploadRule, files []*uploadedFile) {
+	for _, f := range files {
+		if err := ur.store.Delete(f.key); err != nil {
+			s.Errorf("Error deleting upload %s from %s store: %s", f.key, ur.storeType(), err)
+		}
+	}
+}
+
+// fileExt returns the lower case extension of a file name, or an empty string
+// if the extension has other characters than letters and digits.
+func fileExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(filepath.Base(name)))
+	if len(ext) < 2 || len(ext) > 16 {
+		return ""
+	}
+	for _, r := range ext[1:] {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return ""
+		}
+	}
+	return ext
+}
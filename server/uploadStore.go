@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UploadStoreConfig holds the configuration of the store that uploaded files
+// are put in.
+//
+// The built-in store types are:
+//
+//	disk  - files are stored in the upload directory (default)
+//	s3    - files are put in an S3 compatible bucket, such as AWS S3 or MinIO
+//	gcs   - files are put in a Google Cloud Storage bucket, using HMAC keys
+//	azure - files are put as block blobs in an Azure Storage container
+//
+// Other store types may be added with RegisterUploadStoreFactory.
+type UploadStoreConfig struct {
+	// Type is the upload store type. Defaults to disk.
+	Type string `json:"type"`
+	// Endpoint is the base URL of the storage service. Defaults to the
+	// public endpoint of the store type.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Region is the region of the bucket, used in S3 request signing.
+	// Defaults to us-east-1 for s3, and auto for gcs.
+	Region string `json:"region,omitempty"`
+	// Bucket is the name of the bucket, or the container for azure.
+	Bucket string `json:"bucket,omitempty"`
+	// AccessKey is the access key ID, or the storage account name for azure.
+	AccessKey string `json:"accessKey,omitempty"`
+	// SecretKey is the secret access key, or the base64 encoded storage
+	// account key for azure.
+	SecretKey string `json:"secretKey,omitempty"`
+	// Prefix is prepended to the names of the stored objects.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// UploadObject describes an uploaded file put in an upload store.
+type UploadObject struct {
+	// Name is the unique name of the object within the store.
+	Name string
+	// ContentType is the media type of the file.
+	ContentType string
+	// Size is the size of the file in bytes.
+	Size int64
+	// SHA256 is the hex encoded SHA-256 checksum of the file.
+	SHA256 string
+}
+
+// UploadStore stores uploaded files.
+//
+// A store that also implements io.Closer is closed when the service stops.
+type UploadStore interface {
+	// Put stores the object data read from r, and returns the URL of the
+	// stored object.
+	Put(obj UploadObject, r io.Reader) (string, error)
+	// Delete removes a stored object by name.
+	Delete(name string) error
+}
+
+// UploadStoreFactory creates an UploadStore for an upload configuration.
+type UploadStoreFactory func(cfg UploadConfig) (UploadStore, error)
+
+var uploadStoreFactories = make(map[string]UploadStoreFactory)
+
+// RegisterUploadStoreFactory adds an UploadStoreFactory by store type name.
+// Panics if another factory with the same name is already registered.
+func RegisterUploadStoreFactory(name string, f UploadStoreFactory) {
+	if _, ok := uploadStoreFactories[name]; ok {
+		panic("multiple registration of upload store factory " + name)
+	}
+	uploadStoreFactories[name] = f
+}
+
+func init() {
+	RegisterUploadStoreFactory("disk", func(cfg UploadConfig) (UploadStore, error) {
+		return &diskStore{dir: cfg.Dir, url: cfg.URL}, nil
+	})
+	RegisterUploadStoreFactory("s3", newS3Store)
+	RegisterUploadStoreFactory("gcs", newS3Store)
+	RegisterUploadStoreFactory("azure", newAzureStore)
+}
+
+// initUploads creates the upload stores of the upload rules.
+func (s *Service) initUploads() error {
+	for i := range s.cfg.uploadRules {
+		ur := &s.cfg.uploadRules[i]
+		st, err := uploadStoreFactories[ur.storeType()](ur.UploadConfig)
+		if err != nil {
+			s.stopUploads()
+			return fmt.Errorf("invalid uploads setting (%s)\n\t%s", ur.storeType(), err)
+		}
+		ur.store = st
+	}
+	return nil
+}
+
+// stopUploads closes the upload stores implementing io.Closer.
+func (s *Service) stopUploads() {
+	for i := range s.cfg.uploadRules {
+		ur := &s.cfg.uploadRules[i]
+		if c, ok := ur.store.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				s.Errorf("Error closing %s upload store: %s", ur.storeType(), err)
+			}
+		}
+		ur.store = nil
+	}
+}
+
+// redactUploads returns a copy of the upload configurations with the store
+// secret keys replaced.
+func redactUploads(ucs []UploadConfig) []UploadConfig {
+	if ucs == nil {
+		return nil
+	}
+	r := make([]UploadConfig, len(ucs))
+	for i, uc := range ucs {
+		if uc.Store != nil && uc.Store.SecretKey != "" {
+			sc := *uc.Store
+			sc.SecretKey = "xxxxx"
+			uc.Store = &sc
+		}
+		r[i] = uc
+	}
+	return r
+}
+
+// diskStore stores uploaded files in a directory.
+type diskStore struct {
+	dir string
+	url string
+}
+
+// Put moves the file to the directory if r is a file in the same directory,
+// or else copies the data to a new file.
+func (d *diskStore) Put(obj UploadObject, r io.Reader) (string, error) {
+	path := filepath.Join(d.dir, obj.Name)
+	if f, ok := r.(*os.File); ok && filepath.Dir(f.Name()) == filepath.Clean(d.dir) {
+		if err := os.Rename(f.Name(), path); err == nil {
+			return d.url + obj.Name, nil
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return d.url + obj.Name, nil
+}
+
+// Delete removes the file from the directory.
+func (d *diskStore) Delete(name string) error {
+	err := os.Remove(filepath.Join(d.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// objectURL returns the URL of an object in a bucket, with the path segments
+// of the object key escaped as required by both S3 and Azure signing.
+func objectURL(endpoint, bucket, key string) string {
+	segs := strings.Split(bucket+"/"+key, "/")
+	for i, seg := range segs {
+		segs[i] = uriEscape(seg)
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/" + strings.Join(segs, "/")
+}
+
+// uriEscape percent encodes all bytes of s except unreserved characters.
+func uriEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
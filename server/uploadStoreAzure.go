@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureStorageVersion is the Azure Storage REST API version of the requests.
+const azureStorageVersion = "2019-12-12"
+
+// azureStore puts uploaded files as block blobs in an Azure Storage
+// container, using requests signed with the storage account Shared Key.
+type azureStore struct {
+	endpoint  string
+	account   string
+	key       []byte
+	container string
+	prefix    string
+	client    *http.Client
+}
+
+// newAzureStore creates an azureStore for the azure store type.
+func newAzureStore(cfg UploadConfig) (UploadStore, error) {
+	sc := cfg.Store
+	if sc.Bucket == "" {
+		return nil, errors.New("bucket must not be empty")
+	}
+	if sc.AccessKey == "" {
+		return nil, errors.New("accessKey must not be empty")
+	}
+	key, err := base64.StdEncoding.DecodeString(sc.SecretKey)
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("secretKey must be a base64 encoded storage account key")
+	}
+	endpoint := sc.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + sc.AccessKey + ".blob.core.windows.net"
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("endpoint %q must be a valid http or https URL", endpoint)
+	}
+	return &azureStore{
+		endpoint:  endpoint,
+		account:   sc.AccessKey,
+		key:       key,
+		container: sc.Bucket,
+		prefix:    sc.Prefix,
+		client:    &http.Client{Timeout: UploadStoreTimeout},
+	}, nil
+}
+
+// Put uploads the object with a Put Blob request.
+func (st *azureStore) Put(obj UploadObject, r io.Reader) (string, error) {
+	u := objectURL(st.endpoint, st.container, st.prefix+obj.Name)
+	req, err := http.NewRequest("PUT", u, r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = obj.Size
+	req.Header.Set("Content-Type", obj.ContentType)
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	st.sign(req, time.Now())
+	if err := st.do(req); err != nil {
+		return "", err
+	}
+	return u, nil
+}
+
+// Delete removes the object with a Delete Blob request.
+func (st *azureStore) Delete(name string) error {
+	req, err := http.NewRequest("DELETE", objectURL(st.endpoint, st.container, st.prefix+name), nil)
+	if err != nil {
+		return err
+	}
+	st.sign(req, time.Now())
+	return st.do(req)
+}
+
+// do sends a signed request and returns an error if it was unsuccessful.
+func (st *azureStore) do(req *http.Request) error {
+	resp, err := st.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && !(req.Method == "DELETE" && resp.StatusCode == http.StatusNotFound) {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds the x-ms-date, x-ms-version, and Authorization headers to the
+// request, signing it with the Shared Key scheme.
+func (st *azureStore) sign(req *http.Request, t time.Time) {
+	req.Header.Set("X-Ms-Date", t.UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureStorageVersion)
+
+	var msHeaders []string
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-ms-") {
+			msHeaders = append(msHeaders, k+":"+strings.TrimSpace(strings.Join(v, ",")))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + st.account + req.URL.EscapedPath()
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(vs, ",")
+	}
+
+	h := req.Header
+	sts := strings.Join([]string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		contentLength(req.ContentLength),
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		"", // Date is set with x-ms-date
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, st.key)
+	mac.Write([]byte(sts))
+	req.Header.Set("Authorization", "SharedKey "+st.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// contentLength returns the Content-Length value used for signing, which is
+// empty for a zero length.
+func contentLength(n int64) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
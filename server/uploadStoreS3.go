@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the hex encoded SHA-256 checksum of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Store puts uploaded files in an S3 compatible bucket, using path style
+// requests signed with AWS Signature Version 4.
+type s3Store struct {
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	prefix    string
+	client    *http.Client
+}
+
+// newS3Store creates an s3Store for the s3 and gcs store types.
+func newS3Store(cfg UploadConfig) (UploadStore, error) {
+	sc := cfg.Store
+	if sc.Bucket == "" {
+		return nil, errors.New("bucket must not be empty")
+	}
+	if sc.AccessKey == "" || sc.SecretKey == "" {
+		return nil, errors.New("accessKey and secretKey must not be empty")
+	}
+	endpoint, region := sc.Endpoint, sc.Region
+	if sc.Type == "gcs" {
+		if endpoint == "" {
+			endpoint = "https://storage.googleapis.com"
+		}
+		if region == "" {
+			region = "auto"
+		}
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("endpoint %q must be a valid http or https URL", endpoint)
+	}
+	return &s3Store{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    sc.Bucket,
+		accessKey: sc.AccessKey,
+		secretKey: sc.SecretKey,
+		prefix:    sc.Prefix,
+		client:    &http.Client{Timeout: UploadStoreTimeout},
+	}, nil
+}
+
+// Put uploads the object with a PUT Object request.
+func (st *s3Store) Put(obj UploadObject, r io.Reader) (string, error) {
+	u := objectURL(st.endpoint, st.bucket, st.prefix+obj.Name)
+	req, err := http.NewRequest("PUT", u, r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = obj.Size
+	req.Header.Set("Content-Type", obj.ContentType)
+	st.sign(req, obj.SHA256, time.Now())
+	if err := st.do(req); err != nil {
+		return "", err
+	}
+	return u, nil
+}
+
+// Delete removes the object with a DELETE Object request.
+func (st *s3Store) Delete(name string) error {
+	req, err := http.NewRequest("DELETE", objectURL(st.endpoint, st.bucket, st.prefix+name), nil)
+	if err != nil {
+		return err
+	}
+	st.sign(req, emptySHA256, time.Now())
+	return st.do(req)
+}
+
+// do sends a signed request and returns an error if it was unsuccessful.
+func (st *s3Store) do(req *http.Request) error {
+	resp, err := st.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && !(req.Method == "DELETE" && resp.StatusCode == http.StatusNotFound) {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds the x-amz-date, x-amz-content-sha256, and Authorization headers
+// to the request, signing the host and all other set headers.
+func (st *s3Store) sign(req *http.Request, payloadHash string, t time.Time) {
+	signAWSv4(req, st.accessKey, st.secretKey, st.region, "s3", payloadHash, t)
+}
+
+// signAWSv4 signs the request for the AWS service with Signature Version 4,
+// adding the x-amz-date, x-amz-content-sha256, and Authorization headers.
+func signAWSv4(req *http.Request, accessKey, secretKey, region, service, payloadHash string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var ch strings.Builder
+	for _, k := range names {
+		ch.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	creq := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		ch.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(creq))
+	sts := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, sts))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+// canonicalQuery returns the query parameters sorted and escaped for
+// signing.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEscape(k)+"="+uriEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
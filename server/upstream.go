@@ -0,0 +1,331 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// UpstreamConfig holds the configuration for resources fetched from an
+// upstream HTTP endpoint instead of from a service over NATS.
+//
+// Get requests for resources matching the pattern are served by making an
+// HTTP GET request to the URL. A JSON object response is served as a model,
+// and a JSON array response as a collection. Nested objects and arrays are
+// JSON encoded as string values. Access requests are still sent over NATS,
+// unless the resources are public. Call requests are not served by the
+// upstream.
+type UpstreamConfig struct {
+	// Pattern is the resource pattern of the upstream resources.
+	Pattern string `json:"pattern"`
+	// URL is a text/template for the upstream URL. The template is given
+	// the fields RID, Name, Query, and Parts, where Parts are the dot
+	// separated parts of the resource name. The functions pathEscape and
+	// queryEscape are available for escaping.
+	// Eg. "https://api.example.com/users/{{index .Parts 1 | pathEscape}}"
+	URL string `json:"url"`
+	// Headers are added to each upstream request, such as an Authorization
+	// header.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Path is a dot separated path to the resource value within the JSON
+	// response, for responses wrapping the value. Empty means the whole
+	// response.
+	Path string `json:"path,omitempty"`
+	// Interval is the time in milliseconds between polling the upstream for
+	// changes to cached resources. Zero means no polling.
+	Interval int `json:"interval,omitempty"`
+	// Public grants everyone access to get the resources, without sending
+	// access requests over NATS.
+	Public bool `json:"public,omitempty"`
+}
+
+// upstreamRule is a prepared UpstreamConfig.
+type upstreamRule struct {
+	UpstreamConfig
+	pattern rescache.ResourcePattern
+	url     *template.Template
+	path    []string
+}
+
+// upstreamURLData is the data passed to the upstream URL template.
+type upstreamURLData struct {
+	RID   string
+	Name  string
+	Query string
+	Parts []string
+}
+
+// upstreamCached is a cached upstream response, used for conditional
+// requests.
+type upstreamCached struct {
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// upstreamClient is a mq.Client serving get and access requests for
+// upstream resources.
+type upstreamClient struct {
+	mqWrapper
+	rules  []upstreamRule
+	client *http.Client
+	s      *Service
+
+	mu    sync.Mutex
+	cache map[string]*upstreamCached
+}
+
+var upstreamFuncs = template.FuncMap{
+	"pathEscape":  url.PathEscape,
+	"queryEscape": url.QueryEscape,
+}
+
+var errInvalidUpstreamResponse = &reserr.Error{Code: reserr.CodeInternalError, Message: "Invalid upstream response"}
+
+// upstreamPublicAccess is the access response for public upstream resources.
+var upstreamPublicAccess = []byte(`{"result":{"get":true}}`)
+
+// prepare validates the upstream configuration, and returns the prepared
+// rule.
+func (c UpstreamConfig) prepare() (upstreamRule, error) {
+	p := rescache.ParseResourcePattern(c.Pattern)
+	if !p.IsValid() {
+		return upstreamRule{}, fmt.Errorf("pattern %q must be a valid resource pattern", c.Pattern)
+	}
+	if c.URL == "" {
+		return upstreamRule{}, errors.New("url must be set")
+	}
+	t, err := template.New("url").Funcs(upstreamFuncs).Parse(c.URL)
+	if err != nil {
+		return upstreamRule{}, fmt.Errorf("url template error: %s", err)
+	}
+	if c.Interval < 0 {
+		return upstreamRule{}, errors.New("interval must be zero or a positive number of milliseconds")
+	}
+	r := upstreamRule{UpstreamConfig: c, pattern: p, url: t}
+	if c.Path != "" {
+		r.path = strings.Split(c.Path, ".")
+	}
+	return r, nil
+}
+
+// initUpstreams wraps the messaging client to serve upstream resources, if
+// upstreams are configured.
+func (s *Service) initUpstreams() {
+	if len(s.cfg.upstreamRules) == 0 {
+		return
+	}
+	s.mq = &upstreamClient{
+		mqWrapper: mqWrapper{s.mq},
+		rules:     s.cfg.upstreamRules,
+		client:    &http.Client{Timeout: UpstreamTimeout},
+		s:         s,
+		cache:     make(map[string]*upstreamCached),
+	}
+}
+
+// rule returns the first upstream rule matching the resource name, or nil
+// if the resource is not an upstream resource.
+func (c *upstreamClient) rule(rname string) *upstreamRule {
+	for i := range c.rules {
+		if c.rules[i].pattern.Match(rname) {
+			return &c.rules[i]
+		}
+	}
+	return nil
+}
+
+// SendRequest serves get requests, and access requests for public
+// resources, for upstream resources. Other requests are sent to the
+// underlying client.
+func (c *upstreamClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	switch {
+	case strings.HasPrefix(subj, "get."):
+		if r := c.rule(subj[len("get."):]); r != nil {
+			go c.get(r, subj, subj[len("get."):], payload, cb)
+			return
+		}
+	case strings.HasPrefix(subj, "access."):
+		if r := c.rule(subj[len("access."):]); r != nil && r.Public {
+			go cb(subj, upstreamPublicAccess, nil)
+			return
+		}
+	}
+	c.Client.SendRequest(subj, payload, cb)
+}
+
+// get fetches the resource from the upstream, and responds with a get
+// response, or an error response.
+func (c *upstreamClient) get(r *upstreamRule, subj, rname string, payload []byte, cb mq.Response) {
+	var req struct {
+		Query string `json:"query"`
+	}
+	_ = json.Unmarshal(payload, &req)
+
+	result, err := c.fetch(r, rname, req.Query)
+	var data []byte
+	if err != nil {
+		data, _ = json.Marshal(struct {
+			Error *reserr.Error `json:"error"`
+		}{reserr.RESError(err)})
+	} else {
+		data, _ = json.Marshal(struct {
+			Result map[string]interface{} `json:"result"`
+		}{result})
+	}
+	cb(subj, data, nil)
+}
+
+// fetch makes the upstream request, and converts the response to a get
+// result.
+func (c *upstreamClient) fetch(r *upstreamRule, rname, query string) (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	err := r.url.Execute(&buf, upstreamURLData{
+		RID:   rname + queryPart(query),
+		Name:  rname,
+		Query: query,
+		Parts: strings.Split(rname, "."),
+	})
+	if err != nil {
+		c.s.Errorf("Upstream url template error for %s: %s", rname, err)
+		return nil, reserr.ErrInternalError
+	}
+	u := buf.String()
+
+	body, err := c.request(r, u)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		c.s.Debugf("Upstream response from %s is not valid JSON: %s", u, err)
+		return nil, errInvalidUpstreamResponse
+	}
+	for _, p := range r.path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errInvalidUpstreamResponse
+		}
+		v = m[p]
+	}
+
+	result := make(map[string]interface{}, 3)
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, pv := range t {
+			t[k] = upstreamValue(pv)
+		}
+		result["model"] = t
+	case []interface{}:
+		for i, pv := range t {
+			t[i] = upstreamValue(pv)
+		}
+		result["collection"] = t
+	default:
+		return nil, errInvalidUpstreamResponse
+	}
+	if query != "" {
+		result["query"] = query
+	}
+	if r.Interval > 0 {
+		result["ttl"] = r.Interval
+	}
+	return result, nil
+}
+
+// request makes the HTTP GET request to the upstream URL, and returns the
+// response body. A cached body is returned if the upstream responds with
+// 304 Not Modified to a conditional request.
+func (c *upstreamClient) request(r *upstreamRule, u string) ([]byte, error) {
+	hreq, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		c.s.Errorf("Invalid upstream url %s: %s", u, err)
+		return nil, reserr.ErrInternalError
+	}
+	hreq.Header.Set("Accept", "application/json")
+	for k, v := range r.Headers {
+		hreq.Header.Set(k, v)
+	}
+	c.mu.Lock()
+	cached := c.cache[u]
+	c.mu.Unlock()
+	if cached != nil {
+		if cached.etag != "" {
+			hreq.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			hreq.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	hresp, err := c.client.Do(hreq)
+	if err != nil {
+		c.s.Debugf("Upstream request to %s failed: %s", u, err)
+		return nil, reserr.ErrTimeout
+	}
+	defer hresp.Body.Close()
+
+	switch {
+	case hresp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached.body, nil
+	case hresp.StatusCode == http.StatusNotFound:
+		return nil, reserr.ErrNotFound
+	case hresp.StatusCode < 200 || hresp.StatusCode >= 300:
+		c.s.Debugf("Upstream request to %s responded with status %d", u, hresp.StatusCode)
+		return nil, &reserr.Error{Code: reserr.CodeInternalError, Message: fmt.Sprintf("Upstream responded with status %d", hresp.StatusCode)}
+	}
+
+	body, err := ioutil.ReadAll(hresp.Body)
+	if err != nil {
+		return nil, reserr.RESError(err)
+	}
+
+	etag, lastModified := hresp.Header.Get("ETag"), hresp.Header.Get("Last-Modified")
+	c.mu.Lock()
+	if etag != "" || lastModified != "" {
+		// Evict any entry when full, to keep the cache bounded.
+		if _, ok := c.cache[u]; !ok && len(c.cache) >= UpstreamCacheSize {
+			for k := range c.cache {
+				delete(c.cache, k)
+				break
+			}
+		}
+		c.cache[u] = &upstreamCached{etag: etag, lastModified: lastModified, body: body}
+	} else {
+		delete(c.cache, u)
+	}
+	c.mu.Unlock()
+	return body, nil
+}
+
+// upstreamValue returns a primitive value for a JSON decoded value, JSON
+// encoding nested objects and arrays as strings.
+func upstreamValue(v interface{}) interface{} {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return v
+}
+
+// queryPart returns the query with a question mark prefix, or an empty
+// string if there is no query.
+func queryPart(query string) string {
+	if query == "" {
+		return ""
+	}
+	return "?" + query
+}
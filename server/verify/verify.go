@@ -0,0 +1,185 @@
+// Package verify tests RES-service handlers for conformance with the RES
+// service protocol, by sending get, access, and call requests through the
+// messaging system, and validating the responses.
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// CID is the connection ID used in requests sent by the verifier.
+const CID = "resgate-verify"
+
+// Options holds the requests to make for each resource.
+type Options struct {
+	// Token is the access token to include in access and call requests.
+	Token json.RawMessage
+	// Methods are call method names to call on each resource.
+	Methods []string
+	// Params are the parameters to include in call requests.
+	Params json.RawMessage
+}
+
+// Result holds the outcome of a single request.
+type Result struct {
+	Subject    string
+	Violations []string
+	// Error is the service error responded, if any. An error response
+	// is valid, and not a violation.
+	Error *reserr.Error
+}
+
+// OK reports whether the response had no protocol violations.
+func (r Result) OK() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *Result) violation(format string, v ...interface{}) {
+	r.Violations = append(r.Violations, fmt.Sprintf(format, v...))
+}
+
+// Run sends get and access requests, and call requests for each method in
+// opts, for each resource ID in rids, and validates the responses.
+// Decoding is made in strict mode.
+func Run(c mq.Client, rids []string, opts Options) []Result {
+	defer codec.SetStrict(codec.IsStrict())
+	codec.SetStrict(true)
+
+	var token interface{}
+	if len(opts.Token) > 0 {
+		token = opts.Token
+	}
+	var params interface{}
+	if len(opts.Params) > 0 {
+		params = opts.Params
+	}
+
+	var rs []Result
+	for _, rid := range rids {
+		if !codec.IsValidRID(rid, true) {
+			rs = append(rs, Result{Subject: rid, Violations: []string{"invalid resource ID"}})
+			continue
+		}
+		rname, query := parseRID(rid)
+
+		rs = append(rs, verifyGet(c, rname, query))
+		rs = append(rs, verifyAccess(c, rname, query, token))
+		for _, method := range opts.Methods {
+			rs = append(rs, verifyCall(c, rname, query, method, token, params))
+		}
+	}
+	return rs
+}
+
+func verifyGet(c mq.Client, rname, query string) Result {
+	r := Result{Subject: "get." + rname}
+	data, ok := request(c, &r, codec.CreateGetRequest(query))
+	if !ok {
+		return r
+	}
+	result, err := codec.DecodeGetResponse(data)
+	if err != nil {
+		r.decodeError(data, err)
+		return r
+	}
+	if query == "" && result.Query != "" {
+		r.violation("query %q in response to get request without query", result.Query)
+	}
+	return r
+}
+
+func verifyAccess(c mq.Client, rname, query string, token interface{}) Result {
+	r := Result{Subject: "access." + rname}
+	payload, _ := json.Marshal(codec.Request{Token: token, Query: query, CID: CID})
+	data, ok := request(c, &r, payload)
+	if !ok {
+		return r
+	}
+	result, _, rerr := codec.DecodeAccessResponse(data)
+	if rerr != nil {
+		r.decodeError(data, rerr)
+		return r
+	}
+	if result.Call != "" && result.Call != "*" {
+		for _, m := range strings.Split(result.Call, ",") {
+			if !codec.IsValidRIDPart(m) {
+				r.violation("invalid method name %q in call access", m)
+			}
+		}
+	}
+	return r
+}
+
+func verifyCall(c mq.Client, rname, query, method string, token, params interface{}) Result {
+	r := Result{Subject: "call." + rname + "." + method}
+	if !codec.IsValidRIDPart(method) {
+		r.violation("invalid method name")
+		return r
+	}
+	payload, _ := json.Marshal(codec.Request{Params: params, Token: token, Query: query, CID: CID})
+	data, ok := request(c, &r, payload)
+	if !ok {
+		return r
+	}
+	if _, _, err := codec.DecodeCallResponse(data); err != nil {
+		r.decodeError(data, err)
+	}
+	return r
+}
+
+// request sends a request on the result subject and waits for the
+// response. If no response is received, a violation is added, and false is
+// returned.
+func request(c mq.Client, r *Result, payload []byte) ([]byte, bool) {
+	type response struct {
+		data []byte
+		err  error
+	}
+	ch := make(chan response, 1)
+	c.SendRequest(r.Subject, payload, func(_ string, data []byte, err error) {
+		ch <- response{data: data, err: err}
+	})
+	resp := <-ch
+	if resp.err != nil {
+		if reserr.IsError(resp.err, reserr.CodeTimeout) {
+			r.violation("no response received")
+		} else {
+			r.violation("request failed: %s", resp.err)
+		}
+		return nil, false
+	}
+	return resp.data, true
+}
+
+// decodeError adds err as a violation, unless the response is a valid
+// error response, in which case it is set as the result error.
+func (r *Result) decodeError(data []byte, err error) {
+	var resp struct {
+		Error *reserr.Error `json:"error"`
+	}
+	rerr := reserr.RESError(err)
+	if json.Unmarshal(data, &resp) == nil && resp.Error != nil && resp.Error.Code == rerr.Code && resp.Error.Message == rerr.Message {
+		if rerr.Code == "" || rerr.Message == "" {
+			r.violation("error response must have a code and a message")
+		}
+		r.Error = rerr
+		return
+	}
+	msg := strings.TrimPrefix(rerr.Message, "Internal error: ")
+	r.violation("%s", strings.TrimPrefix(msg, "strict mode: "))
+}
+
+// parseRID splits a resource ID into resource name and query.
+func parseRID(rid string) (string, string) {
+	i := strings.IndexByte(rid, '?')
+	if i == -1 {
+		return rid, ""
+	}
+	return rid[:i], rid[i+1:]
+}
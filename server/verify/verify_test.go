@@ -0,0 +1,146 @@
+package verify
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// testClient is a mq.Client responding to requests with predefined payloads.
+type testClient struct {
+	responses map[string]string
+	requests  map[string]json.RawMessage
+}
+
+func (c *testClient) Connect() error { return nil }
+func (c *testClient) Close()         {}
+func (c *testClient) IsClosed() bool { return false }
+
+func (c *testClient) SetClosedHandler(cb func(error)) {}
+
+func (c *testClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	return nil, nil
+}
+
+func (c *testClient) Publish(subj string, payload []byte) error { return nil }
+
+func (c *testClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	if c.requests == nil {
+		c.requests = make(map[string]json.RawMessage)
+	}
+	c.requests[subj] = payload
+	resp, ok := c.responses[subj]
+	if !ok {
+		cb("", nil, mq.ErrRequestTimeout)
+		return
+	}
+	cb("", []byte(resp), nil)
+}
+
+func TestRun(t *testing.T) {
+	tbl := []struct {
+		Get                string
+		Access             string
+		Call               string
+		ExpectedViolations [][]string
+	}{
+		// Valid responses
+		{`{"result":{"model":{"foo":"bar"}}}`, `{"result":{"get":true,"call":"set,delete"}}`, `{"result":null}`, [][]string{nil, nil, nil}},
+		{`{"result":{"collection":[1,{"rid":"test.model"}]}}`, `{"result":{"get":true,"call":"*"}}`, `{"resource":{"rid":"test.model"}}`, [][]string{nil, nil, nil}},
+		{`{"error":{"code":"system.notFound","message":"Not found"}}`, `{"error":{"code":"system.accessDenied","message":"Access denied"}}`, `{"error":{"code":"custom.error","message":"Custom","data":{"foo":42}}}`, [][]string{nil, nil, nil}},
+		// Invalid responses
+		{`{"result":{"model":{"foo":"bar"},"meta":true}}`, `{"result":{"get":true},"foo":1}`, `{"result":null,"error":{"code":"custom.error","message":"Custom"}}`, [][]string{
+			{`json: unknown field "meta" in {"result":{"model":{"foo":"bar"},"meta":true}}`},
+			{`json: unknown field "foo" in {"result":{"get":true},"foo":1}`},
+			{"response must not contain both result and error"},
+		}},
+		{`{"result":{"model":{"foo":[1]}}}`, `{"result":{"get":true,"call":"set,*"}}`, `{"error":{"message":"Missing code"}}`, [][]string{
+			{"invalid value [1]: arrays are not allowed"},
+			{`invalid method name "*" in call access`},
+			{"error response must have a code and a message"},
+		}},
+		{`{"result":{"model":{"foo":"bar"},"query":"foo=bar"}}`, `{}`, `{"resource":{"rid":"test.*"}}`, [][]string{
+			{`query "foo=bar" in response to get request without query`},
+			{"response missing result"},
+			{`invalid resource ID "test.*" in resource response`},
+		}},
+	}
+
+	for i, l := range tbl {
+		c := &testClient{responses: map[string]string{
+			"get.test.model":      l.Get,
+			"access.test.model":   l.Access,
+			"call.test.model.set": l.Call,
+		}}
+		rs := Run(c, []string{"test.model"}, Options{Methods: []string{"set"}})
+		if len(rs) != 3 {
+			t.Fatalf("#%d: expected 3 results, but got %d", i+1, len(rs))
+		}
+		for j, r := range rs {
+			if !reflect.DeepEqual(r.Violations, l.ExpectedViolations[j]) {
+				t.Errorf("#%d: expected %s violations to be:\n%#v\nbut got:\n%#v", i+1, r.Subject, l.ExpectedViolations[j], r.Violations)
+			}
+		}
+	}
+}
+
+func TestRun_WithTokenParamsAndQuery_SendsRequests(t *testing.T) {
+	c := &testClient{responses: map[string]string{
+		"get.test.model":      `{"result":{"model":{"foo":"bar"},"query":"q=1"}}`,
+		"access.test.model":   `{"result":{"get":true,"call":"*"}}`,
+		"call.test.model.set": `{"result":null}`,
+	}}
+	rs := Run(c, []string{"test.model?q=1"}, Options{
+		Token:   json.RawMessage(`{"user":42}`),
+		Methods: []string{"set"},
+		Params:  json.RawMessage(`{"foo":"baz"}`),
+	})
+	for _, r := range rs {
+		if !r.OK() {
+			t.Errorf("expected %s to have no violations, but got %#v", r.Subject, r.Violations)
+		}
+	}
+
+	expected := map[string]string{
+		"get.test.model":      `{"query":"q=1"}`,
+		"access.test.model":   `{"token":{"user":42},"query":"q=1","cid":"resgate-verify"}`,
+		"call.test.model.set": `{"params":{"foo":"baz"},"token":{"user":42},"query":"q=1","cid":"resgate-verify"}`,
+	}
+	for subj, payload := range expected {
+		if string(c.requests[subj]) != payload {
+			t.Errorf("expected %s payload to be:\n%s\nbut got:\n%s", subj, payload, c.requests[subj])
+		}
+	}
+}
+
+func TestRun_NoResponseOrInvalidRID_ReturnsViolations(t *testing.T) {
+	rs := Run(&testClient{}, []string{"test.model", "test..model"}, Options{Methods: []string{"set", "in.valid"}})
+	expected := []Result{
+		{Subject: "get.test.model", Violations: []string{"no response received"}},
+		{Subject: "access.test.model", Violations: []string{"no response received"}},
+		{Subject: "call.test.model.set", Violations: []string{"no response received"}},
+		{Subject: "call.test.model.in.valid", Violations: []string{"invalid method name"}},
+		{Subject: "test..model", Violations: []string{"invalid resource ID"}},
+	}
+	if !reflect.DeepEqual(rs, expected) {
+		t.Errorf("expected results to be:\n%#v\nbut got:\n%#v", expected, rs)
+	}
+}
+
+func TestRun_ErrorResponse_SetsResultError(t *testing.T) {
+	c := &testClient{responses: map[string]string{
+		"get.test.model":    `{"error":{"code":"system.notFound","message":"Not found"}}`,
+		"access.test.model": `{"result":{"get":true}}`,
+	}}
+	rs := Run(c, []string{"test.model"}, Options{})
+	expected := &reserr.Error{Code: reserr.CodeNotFound, Message: "Not found"}
+	if !reflect.DeepEqual(rs[0].Error, expected) {
+		t.Errorf("expected result error to be %#v, but got %#v", expected, rs[0].Error)
+	}
+	if rs[1].Error != nil {
+		t.Errorf("expected no result error, but got %#v", rs[1].Error)
+	}
+}
@@ -1,6 +0,0 @@
-package server
-
-// Last protocol version where a specific feature was not supported.
-const (
-	versionCallResourceResponse = 1001001
-)
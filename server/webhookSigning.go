@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook signature headers
+const (
+	webhookTimestampHeader = "Resgate-Timestamp"
+	webhookSignatureHeader = "Resgate-Signature"
+)
+
+// WebhookSigning holds the keys for signing HTTP requests posted by the
+// gateway to destinations with URLs starting with a prefix.
+type WebhookSigning struct {
+	// URL is the URL prefix of the destinations.
+	URL string `json:"url"`
+	// Keys are the keys signing each request. Multiple keys allow a receiver
+	// to rotate secrets by accepting either key during the rotation.
+	Keys []WebhookKey `json:"keys"`
+}
+
+// WebhookKey is a secret for signing webhook requests, identified by a key
+// ID.
+type WebhookKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// prepare validates the webhook signing configuration.
+func (c WebhookSigning) prepare() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an absolute http or https URL", c.URL)
+	}
+	if len(c.Keys) == 0 {
+		return fmt.Errorf("keys for url %q must not be empty", c.URL)
+	}
+	for _, k := range c.Keys {
+		if k.ID == "" || strings.ContainsAny(k.ID, "=, ") {
+			return fmt.Errorf("key id %q must be a non-empty string without '=', ',', or spaces", k.ID)
+		}
+		if k.Secret == "" {
+			return fmt.Errorf("secret for key id %q must not be empty", k.ID)
+		}
+	}
+	return nil
+}
+
+// webhookKeys returns the keys of the first webhook signing configuration
+// with a URL prefix matching the destination URL, or nil if none matches.
+func (s *Service) webhookKeys(dest string) []WebhookKey {
+	for _, ws := range s.cfg.WebhookSigning {
+		if strings.HasPrefix(dest, ws.URL) {
+			return ws.Keys
+		}
+	}
+	return nil
+}
+
+// postWebhook posts a JSON body to the destination URL, signed with the keys
+// of a matching webhook signing configuration.
+func (s *Service) postWebhook(client *http.Client, dest string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", dest, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if keys := s.webhookKeys(dest); keys != nil {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhookTimestampHeader, ts)
+		req.Header.Set(webhookSignatureHeader, signWebhook(keys, ts, body))
+	}
+	return client.Do(req)
+}
+
+// signWebhook returns the signature header value for a request body with the
+// timestamp. Each key signs the timestamp and body joined by a dot using
+// HMAC-SHA256, and the signatures are listed as key ID and hex encoded
+// signature pairs. Eg. "key1=5257a869..., key2=6ffbb59b..."
+func signWebhook(keys []WebhookKey, ts string, body []byte) string {
+	sigs := make([]string, len(keys))
+	for i, k := range keys {
+		mac := hmac.New(sha256.New, []byte(k.Secret))
+		mac.Write([]byte(ts))
+		mac.Write([]byte{'.'})
+		mac.Write(body)
+		sigs[i] = k.ID + "=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	return strings.Join(sigs, ", ")
+}
+
+// redactWebhookSigning returns a copy of the configuration with the secrets
+// replaced.
+func redactWebhookSigning(wss []WebhookSigning) []WebhookSigning {
+	if wss == nil {
+		return nil
+	}
+	r := make([]WebhookSigning, len(wss))
+	for i, ws := range wss {
+		keys := make([]WebhookKey, len(ws.Keys))
+		for j, k := range ws.Keys {
+			keys[j] = WebhookKey{ID: k.ID, Secret: "xxxxx"}
+		}
+		r[i] = WebhookSigning{URL: ws.URL, Keys: keys}
+	}
+	return r
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// wellKnown describes the gateway capabilities, allowing clients to
+// auto-configure.
+type wellKnown struct {
+	Version       string          `json:"version"`
+	Protocol      string          `json:"protocol"`
+	WSPath        string          `json:"wsPath"`
+	APIPath       string          `json:"apiPath"`
+	APIEncoding   string          `json:"apiEncoding"`
+	APIEncodings  []string        `json:"apiEncodings"`
+	APIMethods    []string        `json:"apiMethods"`
+	Transports    []string        `json:"transports"`
+	WSCompression bool            `json:"wsCompression"`
+	Features      []string        `json:"features"`
+	Limits        wellKnownLimits `json:"limits"`
+}
+
+// wellKnownLimits describes limits enforced by the gateway.
+type wellKnownLimits struct {
+	Subscriptions        int `json:"subscriptions"`
+	IdempotencyKeyLength int `json:"idempotencyKeyLength"`
+	IdempotencyWindow    int `json:"idempotencyWindow,omitempty"`
+}
+
+// initWellKnown creates the well-known document served on WellKnownPath.
+func (s *Service) initWellKnown() {
+	encs := make([]string, 0, len(apiEncoderFactories))
+	for k := range apiEncoderFactories {
+		encs = append(encs, k)
+	}
+	sort.Strings(encs)
+
+	features := []string{}
+	if s.cfg.IdempotencyWindow > 0 {
+		features = append(features, "idempotencyKeys")
+	}
+	if s.cfg.OutboxPath != nil {
+		features = append(features, "scheduledCalls")
+	}
+	if s.cfg.RequestCapabilities {
+		features = append(features, "requestCapabilities")
+	}
+
+	out, _ := json.Marshal(wellKnown{
+		Version:       Version,
+		Protocol:      ProtocolVersion,
+		WSPath:        s.cfg.WSPath,
+		APIPath:       s.cfg.APIPath,
+		APIEncoding:   strings.ToLower(s.cfg.APIEncoding),
+		APIEncodings:  encs,
+		APIMethods:    strings.Split(s.cfg.allowMethods, ", "),
+		Transports:    []string{"websocket", "http"},
+		WSCompression: s.cfg.WSCompression,
+		Features:      features,
+		Limits: wellKnownLimits{
+			Subscriptions:        SubscriptionCountLimit,
+			IdempotencyKeyLength: rpc.IdempotencyKeyMaxLength,
+			IdempotencyWindow:    s.cfg.IdempotencyWindow,
+		},
+	})
+	s.wellKnown = out
+}
+
+// wellKnownHandler serves the well-known document.
+func (s *Service) wellKnownHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.setCommonHeaders(w, r)
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		return
+	}
+	if err == nil && r.Method != "GET" && r.Method != "HEAD" {
+		err = reserr.ErrMethodNotAllowed
+	}
+	if err != nil {
+		s.httpError(w, r, err, s.enc)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(s.wellKnown)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"sort"
+	"time"
+)
+
+// windowTable tracks a count by key, such as failed attempts or protocol
+// errors by client IP, within a window, together with bans of the keys.
+// The owner of the table holds its lock when calling any of the methods.
+type windowTable struct {
+	window    time.Duration
+	entries   map[string]*windowEntry
+	lastSweep time.Time
+}
+
+// windowEntry holds the count for a key within the window.
+type windowEntry struct {
+	count int
+	// held is the number of operations in progress for the key, keeping the
+	// entry after the window has passed.
+	held int
+	// flagged marks the key until a window passes without being counted,
+	// such as a quarantined client IP.
+	flagged     bool
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+// newWindowTable returns a new window table, with the window in
+// milliseconds.
+func newWindowTable(window int) *windowTable {
+	return &windowTable{
+		window:  msDuration(window),
+		entries: make(map[string]*windowEntry),
+	}
+}
+
+// banned reports whether the key of the entry is banned.
+func (e *windowEntry) banned(now time.Time) bool {
+	return now.Before(e.bannedUntil)
+}
+
+// get returns the entry for the key, or nil if it is not banned, has nothing
+// counted within the window, and no operations in progress. An entry with
+// operations in progress starts a new window once its window has passed.
+func (t *windowTable) get(key string, now time.Time) *windowEntry {
+	e, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	if !t.passed(e, now) {
+		return e
+	}
+	if e.held == 0 {
+		delete(t.entries, key)
+		return nil
+	}
+	e.count = 0
+	e.flagged = false
+	e.windowStart = now
+	return e
+}
+
+// add returns the entry for the key, creating it if needed.
+func (t *windowTable) add(key string, now time.Time) *windowEntry {
+	e := t.get(key, now)
+	if e == nil {
+		e = &windowEntry{windowStart: now}
+		t.entries[key] = e
+	}
+	return e
+}
+
+// hold counts an operation in progress for the key.
+func (t *windowTable) hold(key string, now time.Time) {
+	t.add(key, now).held++
+}
+
+// release ends an operation in progress for the key.
+func (t *windowTable) release(key string) {
+	if e, ok := t.entries[key]; ok && e.held > 0 {
+		e.held--
+	}
+}
+
+// clear clears the count of the key, unless banned.
+func (t *windowTable) clear(key string, now time.Time) {
+	e, ok := t.entries[key]
+	if !ok || e.banned(now) {
+		return
+	}
+	if e.held > 0 {
+		e.count = 0
+		e.flagged = false
+	} else {
+		delete(t.entries, key)
+	}
+}
+
+// ban bans the key of the entry for the duration, clearing its count. A new
+// window starts when the ban ends.
+func (t *windowTable) ban(e *windowEntry, now time.Time, d time.Duration) {
+	e.bannedUntil = now.Add(d)
+	e.count = 0
+	e.flagged = false
+	e.windowStart = e.bannedUntil
+}
+
+// passed reports whether the ban and the window of the entry have passed.
+func (t *windowTable) passed(e *windowEntry, now time.Time) bool {
+	return !e.banned(now) && now.Sub(e.windowStart) >= t.window
+}
+
+// sweep removes the entries with the ban and window passed, and no
+// operations in progress, at most once per window.
+func (t *windowTable) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.window {
+		return
+	}
+	t.lastSweep = now
+	for k, e := range t.entries {
+		if e.held == 0 && t.passed(e, now) {
+			delete(t.entries, k)
+		}
+	}
+}
+
+// list returns the sorted keys of the entries, within the window or banned,
+// for which f returns true.
+func (t *windowTable) list(now time.Time, f func(e *windowEntry) bool) []string {
+	keys := []string{}
+	for k, e := range t.entries {
+		if (e.held > 0 || !t.passed(e, now)) && f(e) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/resgateio/resgate/server/codec"
@@ -15,7 +17,6 @@ import (
 	"github.com/resgateio/resgate/server/rescache"
 	"github.com/resgateio/resgate/server/reserr"
 	"github.com/resgateio/resgate/server/rpc"
-	"github.com/rs/xid"
 )
 
 type wsConn struct {
@@ -29,21 +30,48 @@ type wsConn struct {
 	mqSub       mq.Unsubscriber
 	connStr     string
 	protocolVer int
+	tags        map[string]string
+	headerFlags []string
+	timing      *serverTiming // Phase timing of an HTTP API request
+	connected   time.Time
+	traced      bool             // Sampled for trace logging
+	cork        *corkConn        // Network connection coalescing writes, if any
+	sse         *sseStream       // Server-Sent Events stream, if any
+	lp          *longPollSession // Long-polling session, if any
+
+	reaccessTimer *time.Timer
+	throttleTimer *time.Timer
+
+	// Bytes read from and written to the WebSocket. Accessed atomically.
+	nIn  int64
+	nOut int64
+	// Bandwidth usage of the token subject. Guarded by mu.
+	bw *bandwidthUsage
+	// Start of the bandwidth window for which a throttle hint was sent.
+	// Accessed by the listen goroutine.
+	bwHinted time.Time
 
 	queue []func()
 	work  chan struct{}
 
+	// Throttling of client requests. Guarded by mu.
+	throttle time.Duration
+	nextReq  time.Time
+
+	// Request rate limit bucket. Guarded by mu.
+	limiter tokenBucket
+
+	// Protocol errors sent by the client. Accessed atomically.
+	nProtocolErrors int64
+	// Throttled due to quarantine. Guarded by mu.
+	quarantined bool
+
 	mu sync.Mutex
 }
 
-// Protocol versions
-const (
-	legacyProtocol = 1001001 // MAJOR * 1000000 + MINOR * 1000 + PATCH
-	latestProtocol = 1999999
-)
-
 var (
 	errInvalidNewResourceResponse = reserr.InternalError(errors.New("non-resource response on new request"))
+	errSchedulingDisabled         = &reserr.Error{Code: reserr.CodeInvalidRequest, Message: "Scheduled calls not enabled"}
 )
 
 func (s *Service) newWSConn(ws *websocket.Conn, request *http.Request, protocol int) *wsConn {
@@ -55,10 +83,10 @@ func (s *Service) newWSConn(ws *websocket.Conn, request *http.Request, protocol
 		return nil
 	}
 
-	cid := xid.New()
+	cid := s.newCID()
 
 	conn := &wsConn{
-		cid:         cid.String(),
+		cid:         cid,
 		ws:          ws,
 		request:     request,
 		serv:        s,
@@ -66,12 +94,19 @@ func (s *Service) newWSConn(ws *websocket.Conn, request *http.Request, protocol
 		queue:       make([]func(), 0, WSConnWorkerQueueSize),
 		work:        make(chan struct{}, 1),
 		protocolVer: protocol,
+		connected:   time.Now(),
+		traced:      s.sampleTrace(),
+		cork:        s.corkConn(ws, request),
 	}
 	conn.connStr = "[" + conn.cid + "]"
 
 	s.conns[conn.cid] = conn
 	s.wg.Add(1)
 
+	if ws != nil {
+		conn.scheduleReaccess()
+	}
+
 	// Start an output worker that handles calls to wsConn.Enqueue and wsConn.EnqueueSend
 	go conn.outputWorker()
 
@@ -85,6 +120,29 @@ func (c *wsConn) CID() string {
 	return c.cid
 }
 
+// Capabilities returns the negotiated capabilities of the connection, or nil
+// if requestCapabilities is disabled.
+func (c *wsConn) Capabilities() *codec.Capabilities {
+	if !c.serv.cfg.RequestCapabilities {
+		return nil
+	}
+	return &codec.Capabilities{
+		Protocol:         codec.FormatProtocolVersion(c.protocolVer),
+		ResourceResponse: codec.SupportsResourceResponse(c.protocolVer),
+		HTTP:             !c.persistent(),
+	}
+}
+
+// bytesIn returns the number of bytes received from the client.
+func (c *wsConn) bytesIn() int64 {
+	return atomic.LoadInt64(&c.nIn)
+}
+
+// bytesOut returns the number of bytes sent to the client.
+func (c *wsConn) bytesOut() int64 {
+	return atomic.LoadInt64(&c.nOut)
+}
+
 func (c *wsConn) Token() json.RawMessage {
 	return c.token
 }
@@ -101,19 +159,49 @@ func (c *wsConn) listen() {
 	var in []byte
 	var err error
 
+	defer func() {
+		if v := recover(); v != nil {
+			c.handlePanic(v)
+			c.Dispose()
+		}
+	}()
+
+	c.clearHandshakeDeadlines()
 	// Loop until an error is returned when reading
 	for {
+		c.setIdleDeadline()
 		if _, in, err = c.ws.ReadMessage(); err != nil {
 			break
 		}
 
+		c.countIn(len(in))
 		c.Tracef("--> %s", in)
+		c.throttleWait()
+		c.slowStartWait()
 		in := in
+		c.bandwidthHint()
+		err := c.bandwidthError()
+		if err == nil {
+			err = c.rateLimitError()
+		}
+		if err != nil {
+			c.Enqueue(func() {
+				if rpc.RejectRequest(in, c, err) != nil {
+					c.protocolError()
+				}
+			})
+			continue
+		}
 		c.Enqueue(func() {
-			rpc.HandleRequest(in, c)
+			if rpc.HandleRequest(in, c) != nil {
+				c.protocolError()
+			}
 		})
 	}
 
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		c.Disconnect("idle timeout")
+	}
 	c.Dispose()
 	c.Tracef("Disconnected: %s", err)
 }
@@ -131,9 +219,17 @@ func (c *wsConn) dispose() {
 	c.mu.Unlock()
 
 	c.unsubscribeConn()
+	if c.reaccessTimer != nil {
+		c.reaccessTimer.Stop()
+	}
+	if c.throttleTimer != nil {
+		c.throttleTimer.Stop()
+	}
 
 	subs := c.subs
 	c.subs = nil
+	c.writeAudit(len(subs))
+	c.setBandwidthSubject("")
 	for _, sub := range subs {
 		sub.Dispose()
 	}
@@ -166,7 +262,9 @@ func (c *wsConn) Logf(format string, v ...interface{}) {
 
 // Errorf writes a formatted log message
 func (c *wsConn) Errorf(format string, v ...interface{}) {
-	c.serv.logger.Error(fmt.Sprintf(c.connStr+" "+format, v...))
+	msg := fmt.Sprintf(format, v...)
+	c.serv.logger.Error(c.connStr + " " + msg)
+	c.serv.report(ErrorEvent{Message: msg, Source: "connection", CID: c.cid})
 }
 
 // Debugf writes a formatted log message
@@ -178,7 +276,7 @@ func (c *wsConn) Debugf(format string, v ...interface{}) {
 
 // Tracef writes a formatted trace message
 func (c *wsConn) Tracef(format string, v ...interface{}) {
-	if c.serv.logger.IsTrace() {
+	if c.traced && c.serv.logger.IsTrace() {
 		c.serv.logger.Trace(fmt.Sprintf(c.connStr+" "+format, v...))
 	}
 }
@@ -188,6 +286,12 @@ func (c *wsConn) Disconnect(reason string) {
 	if c.ws != nil {
 		c.Tracef("Disconnecting - %s", reason)
 		c.ws.Close()
+	} else if c.sse != nil {
+		c.Tracef("Disconnecting - %s", reason)
+		c.sse.close()
+	} else if c.lp != nil {
+		c.Tracef("Disconnecting - %s", reason)
+		c.lp.close()
 	}
 }
 
@@ -218,14 +322,28 @@ func (c *wsConn) enqueue(f func()) {
 func (c *wsConn) Send(data []byte) {
 	if c.ws != nil {
 		c.Tracef("<<- %s", data)
-		c.ws.WriteMessage(websocket.TextMessage, data)
+		c.countOut(len(data))
+		c.writeMessage(data)
+	} else if c.sse != nil {
+		c.Tracef("<<- %s", data)
+		c.countOut(len(data))
+		c.sse.send(data)
+	} else if c.lp != nil {
+		c.Tracef("<<- %s", data)
+		c.countOut(len(data))
+		c.lp.push(data)
 	}
 }
 
 func (c *wsConn) Reply(data []byte) {
 	if c.ws != nil {
 		c.Tracef("<-- %s", data)
-		c.ws.WriteMessage(websocket.TextMessage, data)
+		c.countOut(len(data))
+		c.writeMessage(data)
+	} else if c.lp != nil {
+		c.Tracef("<-- %s", data)
+		c.countOut(len(data))
+		c.lp.push(data)
 	}
 }
 
@@ -250,7 +368,10 @@ func (c *wsConn) GetResource(rid string, cb func(data *rpc.Resources, err error)
 				return
 			}
 
-			cb(sub.GetRPCResources(), nil)
+			// Get requests include all stream entries kept
+			r := sub.GetRPCResources()
+			sub.replayStream(r, 0)
+			cb(r, nil)
 			sub.ReleaseRPCResources()
 			c.Unsubscribe(sub, true, 1, true)
 		})
@@ -263,23 +384,9 @@ func (c *wsConn) SetVersion(protocol string) (string, error) {
 		return ProtocolVersion, nil
 	}
 
-	parts := strings.Split(protocol, ".")
-	if len(parts) != 3 {
-		return "", reserr.ErrInvalidParams
-	}
-
-	v := 0
-	for i := 0; i < 3; i++ {
-		p, err := strconv.Atoi(parts[i])
-		if err != nil || p >= 1000 {
-			return "", reserr.ErrInvalidParams
-		}
-		v *= 1000
-		v += p
-	}
-
-	if v < 1000000 || v >= 2000000 {
-		return "", reserr.ErrUnsupportedProtocol
+	v, err := codec.ParseProtocolVersion(protocol)
+	if err != nil {
+		return "", err
 	}
 
 	c.protocolVer = v
@@ -288,6 +395,7 @@ func (c *wsConn) SetVersion(protocol string) (string, error) {
 }
 
 func (c *wsConn) GetSubscription(rid string, cb func(sub *Subscription, err error)) {
+	start := c.timing.now()
 	sub, err := c.Subscribe(rid, true)
 	if err != nil {
 		cb(nil, err)
@@ -302,6 +410,7 @@ func (c *wsConn) GetSubscription(rid string, cb func(sub *Subscription, err erro
 		}
 
 		sub.OnReady(func() {
+			c.timing.span("get", start)
 			err := sub.Error()
 			if err != nil {
 				cb(nil, err)
@@ -314,7 +423,7 @@ func (c *wsConn) GetSubscription(rid string, cb func(sub *Subscription, err erro
 	})
 }
 
-func (c *wsConn) SubscribeResource(rid string, cb func(data *rpc.Resources, err error)) {
+func (c *wsConn) SubscribeResource(rid string, opts rpc.SubscribeOptions, cb func(data *rpc.Resources, err error)) {
 	sub, err := c.Subscribe(rid, true)
 	if err != nil {
 		cb(nil, err)
@@ -336,60 +445,213 @@ func (c *wsConn) SubscribeResource(rid string, cb func(data *rpc.Resources, err
 				return
 			}
 
-			cb(sub.GetRPCResources(), nil)
+			r := sub.GetRPCResources()
+			if opts.Offset != nil {
+				sub.replayStream(r, *opts.Offset)
+			}
+			sub.setExpiry(c.subscriptionTTL(opts.TTL))
+			sub.setFilter(opts.Filter)
+			cb(r, nil)
 			sub.ReleaseRPCResources()
 		})
 	})
 }
 
-func (c *wsConn) CallResource(rid, action string, params interface{}, cb func(result interface{}, err error)) {
-	c.call(rid, action, params, func(result json.RawMessage, refRID string, err error) {
+func (c *wsConn) CallResource(rid, action string, params interface{}, opts rpc.CallOptions, cb func(result interface{}, err error)) {
+	c.call(rid, action, params, opts, func(result json.RawMessage, refRID string, err error) {
 		c.handleCallAuthResponse(result, refRID, err, cb)
 	})
 }
 
-func (c *wsConn) CallHTTPResource(rid, prefix, action string, params interface{}, cb func(result json.RawMessage, href string, err error)) {
-	c.call(rid, action, params, func(result json.RawMessage, refRID string, err error) {
+// CallHTTPResource calls a method on a resource for an HTTP request. On a
+// resource response, result is nil and refRID is the ID of the resource.
+func (c *wsConn) CallHTTPResource(rid, action string, params interface{}, opts rpc.CallOptions, cb func(result json.RawMessage, refRID string, err error)) {
+	c.call(rid, action, params, opts, func(result json.RawMessage, refRID string, err error) {
 		if err != nil {
 			cb(nil, "", err)
 		} else if refRID != "" {
-			cb(nil, RIDToPath(refRID, prefix), nil)
+			cb(nil, refRID, nil)
 		} else {
 			cb(result, "", nil)
 		}
 	})
 }
 
-func (c *wsConn) call(rid, action string, params interface{}, cb func(result json.RawMessage, refRID string, err error)) {
+func (c *wsConn) call(rid, action string, params interface{}, opts rpc.CallOptions, cb func(result json.RawMessage, refRID string, err error)) {
+	cb = c.auditCall(rid, action, params, cb)
+
+	c.canCall(rid, action, func(sub *Subscription, err error) {
+		if err != nil {
+			cb(nil, "", err)
+			return
+		}
+		token := c.token
+		send := func(rcb func(result json.RawMessage, refRID string, err error)) {
+			c.serv.cache.Call(c, sub.ResourceName(), sub.ResourceQuery(), action, opts.Meta(), token, params, rcb)
+		}
+		start := c.timing.now()
+		rcb := func(result json.RawMessage, refRID string, err error) {
+			c.timing.span("call", start)
+			c.Enqueue(func() {
+				cb(result, refRID, err)
+			})
+		}
+		if opts.IdempotencyKey != "" && c.serv.idem != nil {
+			key := idempotencyKey(token, sub.ResourceName()+"?"+sub.ResourceQuery()+"."+action, opts.IdempotencyKey)
+			c.serv.idem.do(key, idempotencyParams(params), send, rcb)
+		} else {
+			send(rcb)
+		}
+	})
+}
+
+// ScheduleCall stores a call request in the outbox, to be sent by the gateway
+// at the time given by opts.ExecuteAt. Access is checked when scheduled.
+func (c *wsConn) ScheduleCall(rid, action string, params interface{}, opts rpc.CallOptions, cb func(id string, err error)) {
+	if c.serv.outbox == nil {
+		cb("", errSchedulingDisabled)
+		return
+	}
+	c.canCall(rid, action, func(sub *Subscription, err error) {
+		if err != nil {
+			cb("", err)
+			return
+		}
+		cb(c.serv.cache.ScheduleCall(c, sub.ResourceName(), sub.ResourceQuery(), action, opts.Meta(), c.token, params, opts.ExecuteAt))
+	})
+}
+
+// canCall validates that the connection may call the method on the
+// resource, checking maintenance mode, resource and method policies, and
+// access. The callback is called with the subscription of the resource, or
+// with an error if the call is not allowed.
+func (c *wsConn) canCall(rid, action string, cb func(sub *Subscription, err error)) {
+	if err := c.serv.maintenanceCallError(); err != nil {
+		cb(nil, err)
+		return
+	}
+
 	sub, ok := c.subs[rid]
 	if !ok {
 		sub = NewSubscription(c, rid)
 	}
 
+	if err := c.authRequiredError(); err != nil {
+		cb(nil, err)
+		return
+	}
+
+	if err := c.serv.resourceAllowedError(sub.ResourceName()); err != nil {
+		cb(nil, err)
+		return
+	}
+
+	if err := c.serv.methodAllowedError(sub.ResourceName(), action); err != nil {
+		cb(nil, err)
+		return
+	}
+
+	if err := c.serv.methodBlockedError(sub.ResourceName(), action); err != nil {
+		cb(nil, err)
+		return
+	}
+
 	sub.CanCall(action, func(err error) {
 		if err != nil {
-			cb(nil, "", err)
+			cb(nil, err)
 			return
 		}
-		c.serv.cache.Call(c, sub.ResourceName(), sub.ResourceQuery(), action, c.token, params, func(result json.RawMessage, refRID string, err error) {
-			c.Enqueue(func() {
-				cb(result, refRID, err)
-			})
-		})
+		cb(sub, nil)
 	})
 }
 
+// CancelCall cancels a scheduled call request not yet sent.
+func (c *wsConn) CancelCall(id string, cb func(err error)) {
+	if c.serv.outbox == nil {
+		cb(errSchedulingDisabled)
+		return
+	}
+	cb(c.serv.cache.CancelCall(id, c.token))
+}
+
 func (c *wsConn) AuthResource(rid, action string, params interface{}, cb func(result interface{}, err error)) {
 	rname, query := parseRID(c.ExpandCID(rid))
-	c.serv.cache.Auth(c, rname, query, action, c.token, params, func(result json.RawMessage, refRID string, err error) {
-		c.Enqueue(func() {
-			c.handleCallAuthResponse(result, refRID, err, cb)
+	if err := c.serv.resourceAllowedError(rname); err != nil {
+		cb(nil, err)
+		return
+	}
+	g := c.serv.bruteForceGuard(rname, action)
+	var keys []string
+	var delay time.Duration
+	var challenge string
+	if g != nil {
+		ip := c.serv.remoteIP(c.request)
+		keys = g.keys(ip, params)
+		var err error
+		if delay, err = g.check(keys); err != nil {
+			cb(nil, err)
+			return
+		}
+		if g.challenged(ip) {
+			challenge, params = g.challengeResponse(c.request, params)
+			if challenge == "" {
+				g.cancel(keys)
+				cb(nil, g.challengeError())
+				return
+			}
+		}
+	}
+	send := func() {
+		c.serv.cache.Auth(c, rname, query, action, c.token, params, func(result json.RawMessage, refRID string, tags codec.Tags, err error) {
+			if g != nil {
+				for _, k := range g.done(keys, err) {
+					c.Logf("Banning %s from %s.%s for %dms", k, rname, action, g.BanDuration)
+				}
+			}
+			c.Enqueue(func() {
+				c.setTags(tags)
+				c.handleCallAuthResponse(result, refRID, err, cb)
+			})
 		})
-	})
+	}
+	schedule := func() {
+		if delay > 0 {
+			time.AfterFunc(delay, func() {
+				if !c.Enqueue(send) && g != nil {
+					g.cancel(keys)
+				}
+			})
+		} else {
+			send()
+		}
+	}
+	if challenge == "" {
+		schedule()
+		return
+	}
+	go func() {
+		defer c.serv.recoverFatal("challenge")
+		ok, err := c.serv.verifyChallenge(g, challenge, c.serv.remoteIP(c.request))
+		queued := c.Enqueue(func() {
+			if err != nil {
+				c.Errorf("Error verifying challenge response: %s", err)
+				g.cancel(keys)
+				cb(nil, reserr.ErrInternalError)
+			} else if !ok {
+				g.cancel(keys)
+				cb(nil, g.challengeError())
+			} else {
+				schedule()
+			}
+		})
+		if !queued {
+			g.cancel(keys)
+		}
+	}()
 }
 
-func (c *wsConn) NewResource(rid string, params interface{}, cb func(result interface{}, err error)) {
-	c.call(rid, "new", params, func(result json.RawMessage, refRID string, err error) {
+func (c *wsConn) NewResource(rid string, params interface{}, opts rpc.CallOptions, cb func(result interface{}, err error)) {
+	c.call(rid, "new", params, opts, func(result json.RawMessage, refRID string, err error) {
 		if err != nil {
 			cb(nil, err)
 			return
@@ -412,13 +674,9 @@ func (c *wsConn) handleCallAuthResponse(result json.RawMessage, refRID string, e
 	}
 
 	// Legacy behavior
-	if c.protocolVer <= versionCallResourceResponse {
+	if !codec.SupportsResourceResponse(c.protocolVer) {
 		// Handle resource response by just returning the resource ID without subscription
-		if refRID != "" {
-			cb(rpc.CallResourceResult{RID: refRID}, nil)
-		} else {
-			cb(result, err)
-		}
+		cb(codec.EncodeLegacyCallResult(result, refRID), nil)
 		return
 	}
 
@@ -447,7 +705,7 @@ func (c *wsConn) handleResourceResult(refRID string, cb func(result interface{},
 				RID: sub.RID(),
 				Resources: &rpc.Resources{
 					Errors: map[string]*reserr.Error{
-						sub.RID(): reserr.RESError(err),
+						sub.RID(): c.ClientError(err),
 					},
 				},
 			}, nil)
@@ -494,6 +752,16 @@ func (c *wsConn) Subscribe(rid string, direct bool) (*Subscription, error) {
 		return nil, reserr.ErrDisposing
 	}
 
+	if direct {
+		if err := c.authRequiredError(); err != nil {
+			return nil, err
+		}
+		rname, _ := parseRID(c.ExpandCID(rid))
+		if err := c.serv.resourceAllowedError(rname); err != nil {
+			return nil, err
+		}
+	}
+
 	return c.subscribe(rid, direct)
 }
 
@@ -545,6 +813,10 @@ func (c *wsConn) removeCount(s *Subscription, direct bool, count int, tryDelete
 
 	if direct {
 		s.direct -= count
+		if s.direct == 0 {
+			s.setExpiry(0)
+			s.filter = nil
+		}
 	} else {
 		s.indirect -= count
 	}
@@ -555,31 +827,77 @@ func (c *wsConn) removeCount(s *Subscription, direct bool, count int, tryDelete
 }
 
 func (c *wsConn) setToken(token json.RawMessage) {
+	if c.serv.bandwidth != nil {
+		c.setBandwidthSubject(tokenClaim(token, c.serv.cfg.Bandwidth.TokenClaim))
+	}
 	if c.token == nil {
 		// No need to revalidate nil token access
 		c.token = token
 		return
 	}
 
+	old := c.token
 	c.token = token
 	for _, sub := range c.subs {
+		if a := sub.access; a != nil && a.AccessResult != nil && a.Claims != nil && tokenClaimsEqual(old, token, a.Claims) {
+			// Access does not depend on any changed claim
+			continue
+		}
+		c.forgetGroupAccess(old, sub)
 		sub.reaccess()
 	}
 }
 
+// scheduleReaccess schedules new access requests for all subscriptions after
+// the reaccess interval, if set.
+func (c *wsConn) scheduleReaccess() {
+	if c.serv.cfg.ReaccessInterval == 0 {
+		return
+	}
+	c.reaccessTimer = time.AfterFunc(time.Duration(c.serv.cfg.ReaccessInterval)*time.Millisecond, func() {
+		c.Enqueue(func() {
+			if c.disposing {
+				return
+			}
+			for _, sub := range c.subs {
+				sub.reaccess()
+			}
+			c.scheduleReaccess()
+		})
+	})
+}
+
 func (c *wsConn) Access(s *Subscription, cb func(*rescache.Access)) {
-	c.serv.cache.Access(s, c.token, cb)
+	start := c.timing.now()
+	done := func(a *rescache.Access) {
+		c.timing.span("access", start)
+		if a.Tags != nil {
+			c.Enqueue(func() {
+				c.setTags(a.Tags)
+			})
+		}
+		cb(a)
+	}
+	if subject := c.groupSubject(); subject != "" {
+		token := c.token
+		c.serv.groupAccess.access(subject, s.ResourceName(), s.ResourceQuery(), func(cb func(*rescache.Access)) {
+			c.serv.cache.Access(s, token, cb)
+		}, done)
+		return
+	}
+	c.serv.cache.Access(s, c.token, done)
 }
 
 func (c *wsConn) outputWorker() {
 	for range c.work {
 		idx := 0
 		var f func()
+		c.cork.cork()
 		c.mu.Lock()
 		for len(c.queue) > idx {
 			f = c.queue[idx]
 			c.mu.Unlock()
-			f()
+			c.safeCall(f)
 			idx++
 			c.mu.Lock()
 		}
@@ -590,6 +908,7 @@ func (c *wsConn) outputWorker() {
 			c.queue = c.queue[0:0]
 		}
 		c.mu.Unlock()
+		c.cork.flush()
 	}
 
 	c.queue = nil
@@ -609,6 +928,8 @@ func (c *wsConn) subscribeConn() {
 			switch event {
 			case "token":
 				c.handleConnToken(payload)
+			case "subscribe":
+				c.handleConnSubscribe(payload)
 			}
 		})
 	})
@@ -633,7 +954,78 @@ func (c *wsConn) handleConnToken(payload []byte) {
 		return
 	}
 
+	rids := te.Bootstrap
+	if c.token == nil && te.Token != nil && len(c.serv.cfg.BootstrapResources) > 0 {
+		// Configured bootstrap resources are subscribed on authentication
+		rids = append(append([]string(nil), c.serv.cfg.BootstrapResources...), rids...)
+	}
 	c.setToken(te.Token)
+	if te.Token != nil {
+		c.Bootstrap(rids)
+	}
+}
+
+func (c *wsConn) handleConnSubscribe(payload []byte) {
+	se, err := codec.DecodeConnSubscribeEvent(payload)
+	if err != nil {
+		c.Errorf("Error processing subscribe event: malformed event payload: %s", err)
+		return
+	}
+
+	c.PushSubscribe(se.RID)
+}
+
+// PushSubscribe makes a direct subscription to a resource on behalf of the
+// client, and sends a subscribe event with the resource set to the client.
+// If the resource is already directly subscribed, or if access is denied,
+// nothing is sent.
+func (c *wsConn) PushSubscribe(rid string) {
+	if !c.persistent() {
+		return
+	}
+	if sub, ok := c.subs[rid]; ok && sub.direct > 0 {
+		return
+	}
+
+	sub, err := c.Subscribe(rid, true)
+	if err != nil {
+		c.Debugf("Failed to push subscription %s: %s", rid, err)
+		return
+	}
+
+	sub.CanGet(func(err error) {
+		if err != nil {
+			c.Debugf("Failed to push subscription %s: %s", rid, err)
+			c.Unsubscribe(sub, true, 1, true)
+			return
+		}
+
+		sub.OnReady(func() {
+			if err := sub.Error(); err != nil {
+				c.Debugf("Failed to push subscription %s: %s", rid, err)
+				c.Unsubscribe(sub, true, 1, true)
+				return
+			}
+
+			c.Send(rpc.NewEvent(rid, "subscribe", sub.GetRPCResources()))
+			sub.ReleaseRPCResources()
+		})
+	})
+}
+
+// RevokeSubscriptions removes the direct subscriptions of all resources with
+// a resource name matching any of the patterns, and sends an unsubscribe
+// event to the client with the reason. Cached access is cleared for any
+// remaining indirect subscription.
+func (c *wsConn) RevokeSubscriptions(patterns []rescache.ResourcePattern, reason *reserr.Error) {
+	for _, sub := range c.subs {
+		for _, p := range patterns {
+			if p.Match(sub.ResourceName()) {
+				sub.revoke(reason)
+				break
+			}
+		}
+	}
 }
 
 func (c *wsConn) ExpandCID(rid string) string {
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// stateDigest is the JSON structure encoded into a subscription state digest.
+// Direct subscriptions are stored by resource ID, in sorted order, together
+// with their respective direct subscription count.
+type stateDigest struct {
+	RIDs   []string `json:"r"`
+	Counts []int    `json:"c"`
+}
+
+// ExportState returns a compact digest of the connection's direct subscriptions.
+// The digest may later be passed to ImportState, on the same or on another
+// connection, to restore the subscriptions.
+func (c *wsConn) ExportState() (string, error) {
+	if c.disposing {
+		return "", reserr.ErrDisposing
+	}
+
+	d := stateDigest{
+		RIDs:   make([]string, 0, len(c.subs)),
+		Counts: make([]int, 0, len(c.subs)),
+	}
+	for rid, sub := range c.subs {
+		if sub.direct > 0 {
+			d.RIDs = append(d.RIDs, rid)
+		}
+	}
+	sort.Strings(d.RIDs)
+	for _, rid := range d.RIDs {
+		d.Counts = append(d.Counts, c.subs[rid].direct)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return "", reserr.InternalError(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ImportState decodes a subscription state digest created by ExportState, and
+// subscribes to all resources contained. The callback is called with a single
+// resource set once all resources are loaded. Any resource that fails to be
+// subscribed to is included as an error in the resource set.
+func (c *wsConn) ImportState(state string, cb func(data *rpc.Resources, err error)) {
+	d, err := decodeStateDigest(state)
+	if err != nil {
+		cb(nil, err)
+		return
+	}
+
+	l := len(d.RIDs)
+	if l == 0 {
+		cb(&rpc.Resources{}, nil)
+		return
+	}
+
+	subs := make([]*Subscription, 0, l)
+	counts := make([]int, 0, l)
+	for i, rid := range d.RIDs {
+		var sub *Subscription
+		n := 0
+		for ; n < d.Counts[i]; n++ {
+			sub, err = c.Subscribe(rid, true)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			// Roll back any subscription made so far
+			for j, s := range subs {
+				c.Unsubscribe(s, true, counts[j], true)
+			}
+			if n > 0 {
+				c.Unsubscribe(sub, true, n, true)
+			}
+			cb(nil, err)
+			return
+		}
+		subs = append(subs, sub)
+		counts = append(counts, n)
+	}
+
+	r := &rpc.Resources{}
+	failed := make([]bool, l)
+	pending := l
+	done := func() {
+		pending--
+		if pending > 0 {
+			return
+		}
+		for i, sub := range subs {
+			if failed[i] {
+				continue
+			}
+			sub.populateResources(r)
+		}
+		cb(r, nil)
+		for i, sub := range subs {
+			if failed[i] || sub.Error() != nil {
+				c.Unsubscribe(sub, true, counts[i], true)
+			} else {
+				sub.ReleaseRPCResources()
+			}
+		}
+	}
+
+	for i, sub := range subs {
+		i, sub := i, sub
+		sub.CanGet(func(err error) {
+			if err != nil {
+				if r.Errors == nil {
+					r.Errors = make(map[string]*reserr.Error)
+				}
+				r.Errors[sub.RID()] = c.ClientError(err)
+				failed[i] = true
+				done()
+				return
+			}
+			sub.OnReady(done)
+		})
+	}
+}
+
+// decodeStateDigest decodes and validates a subscription state digest.
+func decodeStateDigest(state string) (*stateDigest, error) {
+	data, err := base64.RawURLEncoding.DecodeString(state)
+	if err != nil {
+		return nil, reserr.ErrInvalidParams
+	}
+
+	var d stateDigest
+	if err := json.Unmarshal(data, &d); err != nil || len(d.RIDs) != len(d.Counts) {
+		return nil, reserr.ErrInvalidParams
+	}
+
+	seen := make(map[string]bool, len(d.RIDs))
+	for i, rid := range d.RIDs {
+		if seen[rid] || !codec.IsValidRID(rid, true) || d.Counts[i] < 1 || d.Counts[i] > SubscriptionCountLimit {
+			return nil, reserr.ErrInvalidParams
+		}
+		seen[rid] = true
+	}
+	return &d, nil
+}
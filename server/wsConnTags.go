@@ -0,0 +1,96 @@
+package server
+
+import (
+	"time"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// setTags merges tags set by an access or auth response into the
+// connection's tags. A nil value removes the tag.
+func (c *wsConn) setTags(tags codec.Tags) {
+	for k, v := range tags {
+		if v == nil {
+			delete(c.tags, k)
+			continue
+		}
+		if c.tags == nil {
+			c.tags = make(map[string]string, len(tags))
+		}
+		c.tags[k] = *v
+	}
+}
+
+// matches reports whether the connection's token and tags matches the
+// selector. An empty selector matches all connections.
+func (c *wsConn) matches(sel codec.ConnSelector) bool {
+	if sel.Token != nil && !tokenMatches(c.token, sel.Token) {
+		return false
+	}
+	for k, v := range sel.Tags {
+		if tv, ok := c.tags[k]; !ok || tv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// setThrottle limits the rate of client requests handled, in requests per
+// second, and sends a throttle event to the client with the resulting delay
+// between requests. A rate of 0 removes the limit. If d is not zero, the
+// limit is removed after the duration.
+// Must be called on the connection worker goroutine.
+func (c *wsConn) setThrottle(rate float64, d time.Duration) {
+	c.mu.Lock()
+	if rate == 0 {
+		c.throttle = 0
+	} else {
+		c.throttle = time.Duration(float64(time.Second) / rate)
+	}
+	throttle := c.throttle
+	c.mu.Unlock()
+
+	if c.throttleTimer != nil {
+		c.throttleTimer.Stop()
+		c.throttleTimer = nil
+	}
+	resume := 0
+	if throttle > 0 && d > 0 {
+		resume = ceilMs(d)
+		var t *time.Timer
+		t = time.AfterFunc(d, func() {
+			c.Enqueue(func() {
+				// Ignore if the limit has been replaced or removed
+				if c.throttleTimer == t {
+					c.setThrottle(0, 0)
+				}
+			})
+		})
+		c.throttleTimer = t
+	}
+	c.Send(rpc.NewThrottleEvent(ceilMs(throttle), resume))
+}
+
+// ceilMs returns the duration in milliseconds, rounded up.
+func ceilMs(d time.Duration) int {
+	return int((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// throttleWait blocks until the next client request may be handled.
+func (c *wsConn) throttleWait() {
+	c.mu.Lock()
+	if c.throttle == 0 {
+		c.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	next := c.nextReq
+	if next.Before(now) {
+		next = now
+	}
+	c.nextReq = next.Add(c.throttle)
+	c.mu.Unlock()
+
+	time.Sleep(next.Sub(now))
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server/codec"
 )
 
 func (s *Service) initWSHandler() {
@@ -41,6 +42,28 @@ func (s *Service) GetWSHandlerFunc() http.Handler {
 }
 
 func (s *Service) wsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.maintenanceConnError(); err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+	if err := s.slowStartConnError(); err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+	if err := s.quarantineConnError(r); err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+	if err := s.clientVersionError(r); err != nil {
+		s.rejectUpgradeRequired(w, r, err)
+		return
+	}
+	tags, flags, err := s.headerRequirementsError(r)
+	if err != nil {
+		s.rejectConn(w, r, err)
+		return
+	}
+
 	// Upgrade to gorilla websocket
 	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -48,10 +71,17 @@ func (s *Service) wsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn := s.newWSConn(ws, r, legacyProtocol)
+	conn := s.newWSConn(ws, r, codec.LegacyProtocol)
 	if conn == nil {
 		return
 	}
+	ws.EnableWriteCompression(s.flagBool("wsCompression", s.cfg.WSCompression, FlagContext{TargetingKey: conn.cid}))
+	if tags != nil || flags != nil {
+		conn.Enqueue(func() {
+			conn.setTags(tags)
+			conn.headerFlags = flags
+		})
+	}
 
 	conn.Tracef("Connected: %s", ws.RemoteAddr())
 
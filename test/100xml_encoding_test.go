@@ -0,0 +1,120 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func xmlEncoding(xc server.XMLEncodingConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.XMLEncoding = &xc
+	}
+}
+
+func acceptHeader(accept string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Accept", accept)
+	}
+}
+
+// Test that HTTP GET responses are XML encoded for requests accepting XML,
+// with nested references encoded as elements with an href attribute
+func TestXMLEncoding_GetWithAcceptXML_ReturnsXML(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model/parent", nil, acceptHeader("application/xml"))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+
+		hreq.GetResponse(t).
+			Equals(t, http.StatusOK, []byte(`<?xml version="1.0" encoding="UTF-8"?><resource><child href="/api/test/model"><bool>true</bool><int>42</int><null/><string>foo</string></child><name>parent</name></resource>`)).
+			AssertHeaders(t, map[string]string{"Content-Type": "application/xml; charset=utf-8"})
+	}, xmlEncoding(server.XMLEncodingConfig{}))
+}
+
+// Test that collections are encoded with an item element for each value,
+// using the configured element names
+func TestXMLEncoding_CollectionWithElementNames_ReturnsXML(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/collection", nil, acceptHeader("text/xml"))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+
+		hreq.GetResponse(t).Equals(t, http.StatusOK, []byte(`<?xml version="1.0" encoding="UTF-8"?><List><Value>foo</Value><Value>42</Value><Value>true</Value><Value/></List>`))
+	}, xmlEncoding(server.XMLEncodingConfig{RootElement: "List", ItemElement: "Value"}))
+}
+
+// Test that property names are converted to element names by the naming rule
+func TestXMLEncoding_NamingRule_ConvertsElementNames(t *testing.T) {
+	tbl := []struct {
+		Naming   string
+		Expected string
+	}{
+		{"preserve", `<_1st>true</_1st><firstName>Jane</firstName><last_name>Doe</last_name>`},
+		{"camel", `<_1st>true</_1st><firstName>Jane</firstName><lastName>Doe</lastName>`},
+		{"pascal", `<_1st>true</_1st><FirstName>Jane</FirstName><LastName>Doe</LastName>`},
+		{"snake", `<_1st>true</_1st><first_name>Jane</first_name><last_name>Doe</last_name>`},
+		{"kebab", `<_1st>true</_1st><first-name>Jane</first-name><last-name>Doe</last-name>`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, l.Naming, func(s *Session) {
+			hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, acceptHeader("application/xml"))
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"firstName":"Jane","last_name":"Doe","1st":true}`))
+
+			hreq.GetResponse(t).Equals(t, http.StatusOK, []byte(`<?xml version="1.0" encoding="UTF-8"?><resource>`+l.Expected+`</resource>`))
+		}, xmlEncoding(server.XMLEncodingConfig{Naming: tbl[i].Naming}))
+	}
+}
+
+// Test that errors are XML encoded for requests accepting XML
+func TestXMLEncoding_Error_ReturnsXMLError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil, acceptHeader("application/xml"))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+
+		hreq.GetResponse(t).Equals(t, http.StatusUnauthorized, []byte(`<?xml version="1.0" encoding="UTF-8"?><error><code>system.accessDenied</code><message>Access denied</message></error>`))
+	}, xmlEncoding(server.XMLEncodingConfig{}))
+}
+
+// Test that responses are JSON encoded when the Accept header prefers JSON,
+// or when XML encoding is disabled
+func TestXMLEncoding_NotPreferred_ReturnsJSON(t *testing.T) {
+	tbl := []struct {
+		Accept  string
+		Enabled bool
+	}{
+		{"", true},
+		{"application/json", true},
+		{"application/json, application/xml;q=0.9", true},
+		{"application/xml;q=0.5, */*", true},
+		{"application/xml", false},
+	}
+
+	for i, l := range tbl {
+		cfgFn := func(cfg *server.Config) {
+			if tbl[i].Enabled {
+				cfg.XMLEncoding = &server.XMLEncodingConfig{}
+			}
+		}
+		runNamedTest(t, l.Accept, func(s *Session) {
+			hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, acceptHeader(l.Accept))
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(reserr.ErrInvalidParams)
+
+			hreq.GetResponse(t).
+				AssertStatusCode(t, http.StatusBadRequest).
+				AssertError(t, reserr.ErrInvalidParams).
+				AssertHeaders(t, map[string]string{"Content-Type": "application/json; charset=utf-8"})
+		}, cfgFn)
+	}
+}
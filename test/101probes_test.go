@@ -0,0 +1,106 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func probes(pc server.ProbesConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.Probes = &pc
+	}
+}
+
+// Test that the probe endpoints respond with status 200 while connected to
+// NATS and running
+func TestProbes_Running_ReturnsOK(t *testing.T) {
+	for _, path := range []string{"/healthz", "/readyz"} {
+		runNamedTest(t, path, func(s *Session) {
+			s.HTTPRequest("GET", path, nil).GetResponse(t).
+				Equals(t, http.StatusOK, json.RawMessage(`{"status":"ok","nats":"connected","state":"running"}`)).
+				AssertHeaders(t, map[string]string{"Cache-Control": "no-store"})
+		}, probes(server.ProbesConfig{}))
+	}
+}
+
+// Test that the readiness endpoint responds with status 503 in maintenance
+// mode, while the health endpoint still responds with status 200
+func TestProbes_Maintenance_NotReady(t *testing.T) {
+	runTest(t, func(s *Session) {
+		setMaintenance(t, s, `{"enabled":true}`)
+
+		s.HTTPRequest("GET", "/readyz", nil).GetResponse(t).
+			Equals(t, http.StatusServiceUnavailable, json.RawMessage(`{"status":"unavailable","nats":"connected","state":"maintenance"}`))
+		s.HTTPRequest("GET", "/healthz", nil).GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(`{"status":"ok","nats":"connected","state":"maintenance"}`))
+	}, probes(server.ProbesConfig{}))
+}
+
+// Test that the probe endpoints are served on the configured paths
+func TestProbes_CustomPaths_ReturnsOK(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/live", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		s.HTTPRequest("GET", "/ready", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		s.HTTPRequest("GET", "/healthz", nil).GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+	}, probes(server.ProbesConfig{HealthPath: "/live", ReadyPath: "/ready"}))
+}
+
+// Test that the probe endpoints respond with method not allowed on methods
+// other than GET and HEAD
+func TestProbes_PostRequest_ReturnsMethodNotAllowed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("POST", "/readyz", nil).GetResponse(t).
+			AssertStatusCode(t, http.StatusMethodNotAllowed).
+			AssertError(t, reserr.ErrMethodNotAllowed).
+			AssertHeaders(t, map[string]string{"Allow": "GET, HEAD"})
+	}, probes(server.ProbesConfig{}))
+}
+
+// Test that the probe endpoints are not served when disabled
+func TestProbes_Disabled_ReturnsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/readyz", nil).GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+	})
+}
+
+// Test that the readiness endpoint responds with status 503 while the server
+// is stopping, during the drain delay, while the health endpoint still
+// responds with status 200
+func TestProbes_Stopping_NotReadyDuringDrainDelay(t *testing.T) {
+	runTest(t, func(s *Session) {
+		go s.s.Stop(nil)
+
+		deadline := time.Now().Add(timeoutSeconds * time.Second)
+		for {
+			hresp := s.HTTPRequest("GET", "/readyz", nil).GetResponse(t)
+			if hresp.Code != http.StatusOK {
+				hresp.Equals(t, http.StatusServiceUnavailable, json.RawMessage(`{"status":"unavailable","nats":"connected","state":"stopping"}`))
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expected readiness endpoint to respond with status 503 while stopping")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		s.HTTPRequest("GET", "/healthz", nil).GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(`{"status":"ok","nats":"connected","state":"stopping"}`))
+	}, probes(server.ProbesConfig{DrainDelay: 200}))
+}
+
+// Test that the probe endpoints respond with status 503 when disconnected
+// from NATS
+func TestProbes_NATSDisconnected_NotHealthyOrReady(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.NATSTestClient.Close()
+
+		for _, path := range []string{"/healthz", "/readyz"} {
+			s.HTTPRequest("GET", path, nil).GetResponse(t).
+				Equals(t, http.StatusServiceUnavailable, json.RawMessage(`{"status":"unavailable","nats":"disconnected","state":"running"}`))
+		}
+	}, probes(server.ProbesConfig{}))
+}
@@ -0,0 +1,110 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withMessageCatalogs(t *testing.T) (string, func(*server.Config)) {
+	dir, err := ioutil.TempDir("", "resgate-catalogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogs := map[string]string{
+		"sv.json":    `{"Not found":"Hittades inte","Access denied":"Åtkomst nekad"}`,
+		"de-AT.json": `{"Not found":"Nicht gefunden"}`,
+	}
+	for name, dta := range catalogs {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(dta), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func(cfg *server.Config) {
+		cfg.MessageCatalogs = &dir
+	}
+}
+
+// Test that error messages sent over WebSocket are localized by the
+// Accept-Language header of the handshake
+func TestMessageCatalogs_WebSocket_LocalizesMessage(t *testing.T) {
+	dir, cfg := withMessageCatalogs(t)
+	defer os.RemoveAll(dir)
+
+	tbl := []struct {
+		AcceptLanguage string
+		Expected       string
+	}{
+		{"", "Access denied"},
+		{"fr", "Access denied"},
+		{"sv", "Åtkomst nekad"},
+		{"sv-SE", "Åtkomst nekad"},
+		{"de-AT, sv;q=0.5", "Åtkomst nekad"},
+		{"sv;q=0", "Access denied"},
+	}
+
+	for _, l := range tbl {
+		runNamedTest(t, l.AcceptLanguage, func(s *Session) {
+			h := http.Header{}
+			if l.AcceptLanguage != "" {
+				h.Set("Accept-Language", l.AcceptLanguage)
+			}
+			c := s.ConnectWithHeader(h)
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			creq.GetResponse(t).AssertError(t, &reserr.Error{Code: reserr.CodeAccessDenied, Message: l.Expected})
+		}, cfg)
+	}
+}
+
+// Test that error messages of HTTP responses are localized by the
+// Accept-Language header of the request
+func TestMessageCatalogs_HTTP_LocalizesMessage(t *testing.T) {
+	dir, cfg := withMessageCatalogs(t)
+	defer os.RemoveAll(dir)
+
+	tbl := []struct {
+		AcceptLanguage string
+		Expected       string
+	}{
+		{"", "Not found"},
+		{"sv", "Hittades inte"},
+		{"de-AT", "Nicht gefunden"},
+		{"de", "Not found"},
+	}
+
+	for _, l := range tbl {
+		runNamedTest(t, l.AcceptLanguage, func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/model/", nil, func(r *http.Request) {
+				r.Header.Set("Accept-Language", l.AcceptLanguage)
+			})
+			hreq.GetResponse(t).Equals(t, http.StatusNotFound, &reserr.Error{Code: reserr.CodeNotFound, Message: l.Expected})
+
+			hreq = s.HTTPRequest("GET", "/api/test/model", nil, func(r *http.Request) {
+				r.Header.Set("Accept-Language", l.AcceptLanguage)
+			})
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondError(reserr.ErrNotFound)
+			hreq.GetResponse(t).Equals(t, http.StatusNotFound, &reserr.Error{Code: reserr.CodeNotFound, Message: l.Expected})
+		}, cfg)
+	}
+}
+
+// Test that messages without a translation in the catalog are sent as is
+func TestMessageCatalogs_NoTranslation_KeepsMessage(t *testing.T) {
+	dir, cfg := withMessageCatalogs(t)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithHeader(http.Header{"Accept-Language": {"sv"}})
+		callWithError(t, s, c, &reserr.Error{Code: "orders.missing", Message: "Order missing"}).
+			AssertError(t, &reserr.Error{Code: "orders.missing", Message: "Order missing"})
+	}, cfg)
+}
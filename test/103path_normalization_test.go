@@ -0,0 +1,77 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func pathNormalization(pn server.PathNormalizationConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.PathNormalization = &pn
+	}
+}
+
+// Test that GET requests on paths not in normalized form are redirected to
+// the normalized path
+func TestPathNormalization_Get_RedirectsToNormalizedPath(t *testing.T) {
+	tbl := []struct {
+		Path     string
+		Location string
+	}{
+		{"/api/test/model/", "/api/test/model"},
+		{"/api/test/model//", "/api/test/model"},
+		{"/api/Test/Model", "/api/test/model"},
+		{"/api/TEST/model/", "/api/test/model"},
+		{"/api/Test/Item/Foo", "/api/test/item/Foo"},
+		{"/api/Test/Item/Foo?q=Bar", "/api/test/item/Foo?q=Bar"},
+		{"/api/Test/Deep/Foo/Bar", "/api/test/deep/Foo/Bar"},
+	}
+
+	for _, l := range tbl {
+		runNamedTest(t, l.Path, func(s *Session) {
+			s.HTTPRequest("GET", l.Path, nil).GetResponse(t).
+				AssertStatusCode(t, http.StatusMovedPermanently).
+				AssertHeaders(t, map[string]string{"Location": l.Location})
+		}, pathNormalization(server.PathNormalizationConfig{
+			TrailingSlash: true,
+			CaseFolding:   []string{"test.model", "test.item.*", "test.deep.>"},
+		}))
+	}
+}
+
+// Test that GET requests on normalized paths, or paths not matching a case
+// folding pattern, are not redirected
+func TestPathNormalization_NormalizedPath_NotRedirected(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/Test/Other", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.Test.Other").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.Test.Other").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	}, pathNormalization(server.PathNormalizationConfig{CaseFolding: []string{"test.model"}}))
+}
+
+// Test that POST requests are handled as if made on the normalized path,
+// without case folding the method name
+func TestPathNormalization_Post_CallsNormalizedResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/Test/Model/doAction/", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.doAction").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	}, pathNormalization(server.PathNormalizationConfig{
+		TrailingSlash: true,
+		CaseFolding:   []string{"test.model"},
+	}))
+}
+
+// Test that paths with a trailing slash respond with not found when trailing
+// slashes are not removed
+func TestPathNormalization_TrailingSlashNotRemoved_ReturnsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/api/test/model/", nil).GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+	}, pathNormalization(server.PathNormalizationConfig{CaseFolding: []string{"test.model"}}))
+}
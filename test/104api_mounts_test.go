@@ -0,0 +1,71 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func apiMounts(mounts ...server.APIMount) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.APIMounts = mounts
+	}
+}
+
+var internalMount = server.APIMount{Path: "/internal/", Namespace: "int"}
+
+// Test that HTTP GET requests on an API mount get the resource within the
+// namespace, with references using the path of the mount of their namespace
+func TestAPIMounts_Get_ReturnsNamespacedResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/internal/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.int.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.int.test.model").RespondSuccess(json.RawMessage(`{"model":{"name":"int","child":{"rid":"int.test.child"},"other":{"rid":"test.model"}}}`))
+		mreqs = s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.int.test.child").RespondSuccess(json.RawMessage(`{"model":{"name":"child"}}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"name":"other"}}`))
+
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"name":"int","child":{"href":"/internal/test/child","model":{"name":"child"}},"other":{"href":"/api/test/model","model":{"name":"other"}}}`))
+	}, apiMounts(internalMount))
+}
+
+// Test that HTTP POST requests on an API mount call the resource within the
+// namespace, with the Location header using the mount path
+func TestAPIMounts_Post_CallsNamespacedResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/internal/test/model/new", nil)
+		s.GetRequest(t).AssertSubject(t, "access.int.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.int.test.model.new").RespondResource("int.test.model.42")
+
+		hreq.GetResponse(t).
+			AssertStatusCode(t, http.StatusCreated).
+			AssertHeaders(t, map[string]string{"Location": "/internal/test/model/42"})
+	}, apiMounts(internalMount))
+}
+
+// Test that the apiPath is still served without a namespace, and that the
+// longest matching path prefix is used
+func TestAPIMounts_APIPathAndNestedMount_UsesLongestPrefix(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusNoContent)
+
+		hreq = s.HTTPRequest("POST", "/api/v2/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.v2.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.v2.test.model.method").RespondSuccess(nil)
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusNoContent)
+	}, apiMounts(server.APIMount{Path: "/api/v2", Namespace: "v2"}))
+}
+
+// Test that the path of an API mount without a resource path responds with
+// not found
+func TestAPIMounts_MountPathOnly_ReturnsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/internal/", nil).GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+	}, apiMounts(internalMount))
+}
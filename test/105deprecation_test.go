@@ -0,0 +1,106 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func deprecations(ds ...server.Deprecation) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.Deprecations = ds
+	}
+}
+
+// assertWarning asserts that the warning of a client response, encoded as
+// JSON, equals the expected warning, or that there is no warning if expected
+// is empty.
+func assertWarning(t *testing.T, cresp *ClientResponse, expected string) {
+	if expected == "" {
+		if cresp.Warning != nil {
+			t.Fatalf("expected no warning, but got %v", cresp.Warning)
+		}
+		return
+	}
+	out, _ := json.Marshal(cresp.Warning)
+	if string(out) != expected {
+		t.Fatalf("expected warning:\n%s\nbut got:\n%s", expected, out)
+	}
+}
+
+// Test that HTTP GET responses for a deprecated resource include the
+// Deprecation, Sunset, and Link headers
+func TestDeprecation_HTTPGet_SetsDeprecationHeaders(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(resourceData("test.model"))).
+			AssertHeaders(t, map[string]string{
+				"Deprecation": "@1767225600",
+				"Sunset":      "Fri, 01 Jan 2027 00:00:00 GMT",
+				"Link":        `<https://example.com/deprecation>; rel="deprecation"`,
+			})
+	}, deprecations(server.Deprecation{Pattern: "test.*", Since: "2026-01-01T00:00:00Z", Sunset: "2027-01-01T00:00:00Z", Link: "https://example.com/deprecation"}))
+}
+
+// Test that HTTP call responses include the Deprecation header only for a
+// deprecated method
+func TestDeprecation_HTTPCall_SetsDeprecationHeaderForMethod(t *testing.T) {
+	tbl := []struct {
+		Method     string
+		Deprecated bool
+	}{
+		{"method", true},
+		{"other", false},
+	}
+	for i, l := range tbl {
+		runNamedTest(t, l.Method, func(s *Session) {
+			hreq := s.HTTPRequest("POST", "/api/test/model/"+l.Method, nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model."+l.Method).RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+			hresp := hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+			if tbl[i].Deprecated {
+				hresp.AssertHeaders(t, map[string]string{"Deprecation": "true"}).
+					AssertMissingHeaders(t, []string{"Sunset", "Link"})
+			} else {
+				hresp.AssertMissingHeaders(t, []string{"Deprecation"})
+			}
+		}, deprecations(server.Deprecation{Pattern: "test.>", Method: "method"}))
+	}
+}
+
+// Test that WebSocket responses for a deprecated resource include a warning
+func TestDeprecation_WebSocketGet_IncludesWarning(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("get.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		cresp := creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+resourceData("test.model")+`}}`))
+		assertWarning(t, cresp, `{"code":"system.deprecated","data":{"sunset":"2027-01-01T00:00:00Z"},"message":"Use test.newmodel"}`)
+	}, deprecations(server.Deprecation{Pattern: "test.model", Sunset: "2027-01-01T00:00:00Z", Message: "Use test.newmodel"}))
+}
+
+// Test that WebSocket call responses include a warning only for a deprecated
+// method
+func TestDeprecation_WebSocketCall_IncludesWarningForMethod(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"method"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		cresp := creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+		assertWarning(t, cresp, `{"code":"system.deprecated","message":"Deprecated method"}`)
+
+		creq = c.Request("call.test.model.other", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"method"}`))
+		cresp = creq.GetResponse(t).AssertErrorCode(t, "system.accessDenied")
+		assertWarning(t, cresp, "")
+	}, deprecations(server.Deprecation{Pattern: "test.model", Method: "method"}))
+}
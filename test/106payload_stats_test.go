@@ -0,0 +1,137 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+type payloadStatsResponse struct {
+	Samples  int `json:"samples"`
+	Patterns []struct {
+		Pattern string           `json:"pattern"`
+		Count   int64            `json:"count"`
+		Size    map[string]int64 `json:"size"`
+		Items   map[string]int64 `json:"items"`
+		Largest *struct {
+			RID   string `json:"rid"`
+			Size  int64  `json:"size"`
+			Items int64  `json:"items"`
+		} `json:"largest"`
+	} `json:"patterns"`
+}
+
+func payloadStats(samples int, patterns ...string) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.PayloadStats = &server.PayloadStatsConfig{Patterns: patterns, Samples: samples}
+	}
+}
+
+// getPayloadCollection makes a HTTP GET request for the collection resource,
+// responding to the access and get requests with a collection of n items. It
+// returns the size of the get response payload.
+func getPayloadCollection(t *testing.T, s *Session, rid string, n int) int64 {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = fmt.Sprintf("%d", i)
+	}
+	collection := "[" + strings.Join(items, ",") + "]"
+	hreq := s.HTTPRequest("GET", "/api/"+strings.Replace(rid, ".", "/", -1), nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access."+rid).RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get."+rid).RespondSuccess(json.RawMessage(`{"collection":` + collection + `}`))
+	hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(collection))
+	return int64(len(`{"result":{"collection":` + collection + `}}`))
+}
+
+func getPayloadStats(t *testing.T, s *Session) payloadStatsResponse {
+	hresp := s.AdminRequest("GET", "/payloads", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	var pr payloadStatsResponse
+	if err := json.Unmarshal(hresp.Body.Bytes(), &pr); err != nil {
+		t.Fatalf("error decoding payload stats response: %s", err)
+	}
+	return pr
+}
+
+// Test that the payload size and item count percentiles of get responses
+// are recorded per matching pattern
+func TestPayloadStats_GetResponses_RecordedPerPattern(t *testing.T) {
+	runTest(t, func(s *Session) {
+		var sizes []int64
+		for i := 1; i <= 10; i++ {
+			sizes = append(sizes, getPayloadCollection(t, s, fmt.Sprintf("test.c%d", i), i))
+		}
+		getPayloadCollection(t, s, "other.c", 3)
+
+		pr := getPayloadStats(t, s)
+		if pr.Samples != server.PayloadStatsSamples || len(pr.Patterns) != 2 {
+			t.Fatalf("expected default samples and 2 patterns, but got %+v", pr)
+		}
+		p := pr.Patterns[0]
+		if p.Pattern != "test.*" || p.Count != 10 {
+			t.Fatalf("expected pattern test.* with count 10, but got %+v", p)
+		}
+		if items := map[string]int64{"p50": 5, "p90": 9, "p99": 10, "max": 10}; !reflect.DeepEqual(p.Items, items) {
+			t.Fatalf("expected items %v, but got %v", items, p.Items)
+		}
+		if size := map[string]int64{"p50": sizes[4], "p90": sizes[8], "p99": sizes[9], "max": sizes[9]}; !reflect.DeepEqual(p.Size, size) {
+			t.Fatalf("expected size %v, but got %v", size, p.Size)
+		}
+		if p.Largest == nil || p.Largest.RID != "test.c10" || p.Largest.Size != sizes[9] || p.Largest.Items != 10 {
+			t.Fatalf("expected largest to be test.c10, but got %+v", p.Largest)
+		}
+		if p := pr.Patterns[1]; p.Pattern != ">" || p.Count != 1 || p.Items["max"] != 3 {
+			t.Fatalf("expected pattern > with count 1 and 3 items, but got %+v", p)
+		}
+	}, payloadStats(0, "test.*", ">"))
+}
+
+// Test that the percentiles are calculated over the most recent samples,
+// while the largest resource is kept
+func TestPayloadStats_SamplesExceeded_KeepsMostRecent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		size := getPayloadCollection(t, s, "test.c1", 10)
+		getPayloadCollection(t, s, "test.c2", 1)
+		getPayloadCollection(t, s, "test.c3", 2)
+
+		p := getPayloadStats(t, s).Patterns[0]
+		if p.Count != 3 || p.Items["max"] != 2 || p.Items["p50"] != 1 {
+			t.Fatalf("expected count 3 with max 2 and p50 1 items, but got %+v", p)
+		}
+		if p.Largest == nil || p.Largest.RID != "test.c1" || p.Largest.Size != size {
+			t.Fatalf("expected largest to be test.c1, but got %+v", p.Largest)
+		}
+	}, payloadStats(2))
+}
+
+// Test that error responses and resources not matching any pattern are not
+// recorded
+func TestPayloadStats_ErrorOrNoMatchingPattern_NotRecorded(t *testing.T) {
+	runTest(t, func(s *Session) {
+		getPayloadCollection(t, s, "other.c", 3)
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondError(reserr.ErrNotFound)
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+
+		p := getPayloadStats(t, s).Patterns[0]
+		if p.Count != 0 || p.Largest != nil {
+			t.Fatalf("expected no recorded responses, but got %+v", p)
+		}
+	}, payloadStats(0, "test.*"))
+}
+
+// Test that the payloads admin endpoint responds with not found when payload
+// stats are not configured
+func TestPayloadStats_NotConfigured_ReturnsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.AdminRequest("GET", "/payloads", nil).GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+type neverUpdatedResponse struct {
+	Window    int `json:"window"`
+	Resources []struct {
+		RID        string `json:"rid"`
+		Subscribed int64  `json:"subscribed"`
+		Count      int64  `json:"count"`
+	} `json:"resources"`
+}
+
+func neverUpdated(nc server.NeverUpdatedConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.NeverUpdated = &nc
+	}
+}
+
+func getNeverUpdated(t *testing.T, s *Session, url string) neverUpdatedResponse {
+	hresp := s.AdminRequest("GET", url, nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	var nr neverUpdatedResponse
+	if err := json.Unmarshal(hresp.Body.Bytes(), &nr); err != nil {
+		t.Fatalf("error decoding never updated response: %s", err)
+	}
+	return nr
+}
+
+// Test that a subscribed resource without any events is reported until an
+// event is received
+func TestNeverUpdated_NoEvents_ReportedUntilEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		nr := getNeverUpdated(t, s, "/neverupdated?window=0")
+		if nr.Window != 0 || len(nr.Resources) != 1 {
+			t.Fatalf("expected a single resource, but got %+v", nr)
+		}
+		if r := nr.Resources[0]; r.RID != "test.model" || r.Count != 1 || r.Subscribed <= 0 {
+			t.Fatalf("expected test.model with count 1, but got %+v", r)
+		}
+
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.GetEvent(t).AssertEventName(t, "test.model.custom")
+
+		if nr := getNeverUpdated(t, s, "/neverupdated?window=0"); len(nr.Resources) != 0 {
+			t.Fatalf("expected no resources, but got %+v", nr.Resources)
+		}
+	})
+}
+
+// Test that resources subscribed to for less than the window are not
+// reported
+func TestNeverUpdated_WithinWindow_NotReported(t *testing.T) {
+	for _, l := range []struct {
+		Name   string
+		Window int
+		Cfg    []func(*server.Config)
+	}{
+		{"default", server.NeverUpdatedWindow, nil},
+		{"configured", 60000, []func(*server.Config){neverUpdated(server.NeverUpdatedConfig{Window: 60000})}},
+	} {
+		runNamedTest(t, l.Name, func(s *Session) {
+			c := s.Connect()
+			subscribeToTestModel(t, s, c)
+
+			nr := getNeverUpdated(t, s, "/neverupdated")
+			if nr.Window != l.Window || len(nr.Resources) != 0 {
+				t.Fatalf("expected window %d and no resources, but got %+v", l.Window, nr)
+			}
+		}, l.Cfg...)
+	}
+}
+
+// Test that resources matching an ignore pattern are not reported
+func TestNeverUpdated_IgnoredResource_NotReported(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		if nr := getNeverUpdated(t, s, "/neverupdated?window=0"); len(nr.Resources) != 0 {
+			t.Fatalf("expected no resources, but got %+v", nr.Resources)
+		}
+	}, neverUpdated(server.NeverUpdatedConfig{Ignore: []string{"test.>"}}))
+}
+
+// Test that an invalid window query parameter responds with bad request
+func TestNeverUpdated_InvalidWindow_ReturnsBadRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.AdminRequest("GET", "/neverupdated?window=-1", nil).GetResponse(t).AssertStatusCode(t, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,40 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Test that a reconnect to NATS refetches subscribed resources and their
+// access, as events may have been lost while disconnected
+func TestNATSFailover_Reconnect_RefetchesResourcesAndAccess(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.Reconnect()
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"null":null}}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+	})
+}
+
+// Test that a reconnect to NATS with access denied on the refetched access
+// unsubscribes the resource
+func TestNATSFailover_ReconnectWithAccessDenied_UnsubscribesResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.Reconnect()
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+
+		c.GetEvent(t).AssertEventName(t, "test.model.unsubscribe")
+	})
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// subscribeToTestModelWithTTL makes a successful subscription to test.model,
+// with the get response having a ttl.
+func subscribeToTestModelWithTTL(t *testing.T, s *Session, c *Conn, ttl string) {
+	model := resourceData("test.model")
+	creq := c.Request("subscribe.test.model", nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `,"ttl":` + ttl + `}`))
+	mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+}
+
+// Test that an expired resource is refetched, sending change events to the
+// client, and refetched again using the ttl of the new response
+func TestResourceTTL_Expired_RefetchesResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModelWithTTL(t, s, c, "20")
+
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"null":null},"ttl":20}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"baz","int":42,"bool":true,"null":null}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+
+		// Validate no more refetching without ttl
+		time.Sleep(50 * time.Millisecond)
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+	})
+}
+
+// Test that a failed refetch is retried on expiry, while a not found error
+// deletes the resource and stops refetching
+func TestResourceTTL_RefetchError_RetriesUnlessNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModelWithTTL(t, s, c, "20")
+
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrInternalError)
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrNotFound)
+		c.GetEvent(t).AssertEventName(t, "test.model.delete")
+		s.AssertErrorsLogged(t, 1)
+
+		time.Sleep(50 * time.Millisecond)
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+	})
+}
+
+// Test that a negative ttl is treated as an invalid get response
+func TestResourceTTL_NegativeTTL_RespondsWithError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"},"ttl":-1}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInternalError)
+	})
+}
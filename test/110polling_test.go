@@ -0,0 +1,64 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func polling(rules ...server.PollingRule) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.Polling = rules
+	}
+}
+
+// Test that a resource matching a polling rule is refetched every interval,
+// sending change events to subscribers
+func TestPolling_MatchingResource_RefetchesEveryInterval(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"null":null}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"null":null}}`))
+		c.AssertNoEvent(t, "test.model")
+	}, polling(server.PollingRule{Pattern: "test.>", Interval: 20}))
+}
+
+// Test that the first matching polling rule is used, and that resources not
+// matching any rule are not refetched
+func TestPolling_Rules_FirstMatchingUsed(t *testing.T) {
+	for _, l := range []struct {
+		Name  string
+		Rules []server.PollingRule
+	}{
+		{"no match", []server.PollingRule{{Pattern: "test.collection", Interval: 20}}},
+		{"first match", []server.PollingRule{{Pattern: "test.model", Interval: 60000}, {Pattern: "test.>", Interval: 20}}},
+	} {
+		runNamedTest(t, l.Name, func(s *Session) {
+			c := s.Connect()
+			subscribeToTestModel(t, s, c)
+
+			time.Sleep(50 * time.Millisecond)
+			if len(s.reqs) > 0 {
+				t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+			}
+		}, polling(l.Rules...))
+	}
+}
+
+// Test that a ttl in the get response takes precedence over the polling
+// interval
+func TestPolling_ResponseTTL_TakesPrecedence(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModelWithTTL(t, s, c, "20")
+
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"null":null}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+	}, polling(server.PollingRule{Pattern: "test.>", Interval: 60000}))
+}
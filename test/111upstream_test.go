@@ -0,0 +1,148 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// upstreamServer is a HTTP server serving upstream responses by path.
+type upstreamServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	bodies   map[string]string
+	etags    map[string]string
+	requests []*http.Request
+}
+
+func newUpstreamServer(bodies map[string]string) *upstreamServer {
+	us := &upstreamServer{bodies: bodies, etags: make(map[string]string)}
+	us.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		us.mu.Lock()
+		defer us.mu.Unlock()
+		us.requests = append(us.requests, r)
+		body, ok := us.bodies[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if etag := us.etags[r.URL.RequestURI()]; etag != "" {
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+		}
+		w.Write([]byte(body))
+	}))
+	return us
+}
+
+func (us *upstreamServer) set(path, body, etag string) {
+	us.mu.Lock()
+	us.bodies[path] = body
+	us.etags[path] = etag
+	us.mu.Unlock()
+}
+
+func (us *upstreamServer) lastRequest() *http.Request {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	return us.requests[len(us.requests)-1]
+}
+
+func upstreams(ucs ...server.UpstreamConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.Upstreams = ucs
+	}
+}
+
+// Test that get requests for upstream resources are served from the
+// upstream URL, as models or collections, with access requested over NATS
+func TestUpstream_GetResource_ServedFromUpstream(t *testing.T) {
+	us := newUpstreamServer(map[string]string{
+		"/users/42":     `{"name":"Jane","tags":["a","b"],"nil":null}`,
+		"/users?page=2": `[1,"two",{"three":3}]`,
+	})
+	defer us.Close()
+
+	for _, l := range []struct {
+		Name     string
+		URL      string
+		RName    string
+		Expected string
+	}{
+		{"model", "/api/ext/user/42", "ext.user.42", `{"name":"Jane","tags":"[\"a\",\"b\"]","nil":null}`},
+		{"collection", "/api/ext/users?page=2", "ext.users", `[1,"two","{\"three\":3}"]`},
+	} {
+		runNamedTest(t, l.Name, func(s *Session) {
+			hreq := s.HTTPRequest("GET", l.URL, nil)
+			s.GetRequest(t).AssertSubject(t, "access."+l.RName).RespondSuccess(json.RawMessage(`{"get":true}`))
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(l.Expected))
+			if h := us.lastRequest().Header.Get("Authorization"); h != "Bearer secret" {
+				t.Fatalf("expected Authorization header %q, but got %q", "Bearer secret", h)
+			}
+		}, upstreams(
+			server.UpstreamConfig{Pattern: "ext.user.*", URL: us.URL + "/users/{{index .Parts 2 | pathEscape}}", Headers: map[string]string{"Authorization": "Bearer secret"}},
+			server.UpstreamConfig{Pattern: "ext.users", URL: us.URL + "/users?{{.Query}}", Headers: map[string]string{"Authorization": "Bearer secret"}},
+		))
+	}
+}
+
+// Test that public upstream resources are served without any NATS request,
+// and that a path selects the value within the response
+func TestUpstream_PublicWithPath_NoNATSRequest(t *testing.T) {
+	us := newUpstreamServer(map[string]string{"/weather": `{"data":{"temp":21}}`})
+	defer us.Close()
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.Request("subscribe.ext.weather", nil).GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"ext.weather":{"temp":21}}}`))
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+	}, upstreams(server.UpstreamConfig{Pattern: "ext.weather", URL: us.URL + "/weather", Path: "data", Public: true}))
+}
+
+// Test that polled upstream resources send change events to subscribers,
+// using conditional requests when the upstream responds with an ETag
+func TestUpstream_Interval_PollsForChanges(t *testing.T) {
+	us := newUpstreamServer(make(map[string]string))
+	us.set("/weather", `{"temp":21}`, `"v1"`)
+	defer us.Close()
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.Request("subscribe.ext.weather", nil).GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"ext.weather":{"temp":21}}}`))
+
+		us.set("/weather", `{"temp":22}`, `"v2"`)
+		c.GetEvent(t).Equals(t, "ext.weather.change", json.RawMessage(`{"values":{"temp":22}}`))
+		if h := us.lastRequest().Header.Get("If-None-Match"); h != `"v1"` && h != `"v2"` {
+			t.Fatalf("expected conditional request, but got If-None-Match %q", h)
+		}
+	}, upstreams(server.UpstreamConfig{Pattern: "ext.weather", URL: us.URL + "/weather", Interval: 20, Public: true}))
+}
+
+// Test that upstream errors and invalid responses are served as errors
+func TestUpstream_ErrorResponse_RespondsWithError(t *testing.T) {
+	us := newUpstreamServer(map[string]string{"/invalid": `42`})
+	defer us.Close()
+
+	for _, l := range []struct {
+		RID  string
+		Code string
+	}{
+		{"ext.missing", reserr.CodeNotFound},
+		{"ext.invalid", reserr.CodeInternalError},
+	} {
+		runNamedTest(t, l.RID, func(s *Session) {
+			c := s.Connect()
+			c.Request("get."+l.RID, nil).GetResponse(t).AssertErrorCode(t, l.Code)
+		}, upstreams(server.UpstreamConfig{Pattern: "ext.*", URL: us.URL + "/{{index .Parts 1}}", Public: true}))
+	}
+}
@@ -0,0 +1,221 @@
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// sseEvent is an event read from a Server-Sent Events stream.
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// sseClient reads events from a Server-Sent Events stream.
+type sseClient struct {
+	resp   *http.Response
+	events chan *sseEvent
+}
+
+func sse(sc server.SSEConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.SSE = &sc
+	}
+}
+
+// connectSSE makes a Server-Sent Events request for the path. The response
+// is returned once the response headers are received.
+func connectSSE(t *testing.T, ts *httptest.Server, path string) chan *sseClient {
+	ch := make(chan *sseClient, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Errorf("error making SSE request: %s", err)
+			close(ch)
+			return
+		}
+		sc := &sseClient{resp: resp, events: make(chan *sseEvent, 16)}
+		go sc.read()
+		ch <- sc
+	}()
+	return ch
+}
+
+func (sc *sseClient) read() {
+	r := bufio.NewReader(sc.resp.Body)
+	ev := &sseEvent{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			close(sc.events)
+			return
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if ev.Event != "" {
+				sc.events <- ev
+			}
+			ev = &sseEvent{}
+		case strings.HasPrefix(line, "event: "):
+			ev.Event = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			ev.Data = line[len("data: "):]
+		}
+	}
+}
+
+func getSSEClient(t *testing.T, ch chan *sseClient) *sseClient {
+	select {
+	case sc := <-ch:
+		if sc == nil {
+			t.FailNow()
+		}
+		return sc
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatal("expected a SSE response but found none")
+	}
+	return nil
+}
+
+// AssertEvent asserts that the next event has the event type and data.
+func (sc *sseClient) AssertEvent(t *testing.T, event string, data interface{}) {
+	select {
+	case ev, ok := <-sc.events:
+		if !ok {
+			t.Fatalf("expected SSE event %s, but the stream ended", event)
+		}
+		if ev.Event != event {
+			t.Fatalf("expected SSE event %s, but got %s: %s", event, ev.Event, ev.Data)
+		}
+		var a, b interface{}
+		dj, _ := json.Marshal(data)
+		json.Unmarshal(dj, &b)
+		if err := json.Unmarshal([]byte(ev.Data), &a); err != nil || !reflect.DeepEqual(a, b) {
+			t.Fatalf("expected SSE event %s data to be:\n%s\nbut got:\n%s", event, dj, ev.Data)
+		}
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatalf("expected SSE event %s but found none", event)
+	}
+}
+
+// AssertEnded asserts that the stream ends.
+func (sc *sseClient) AssertEnded(t *testing.T) {
+	select {
+	case ev, ok := <-sc.events:
+		if ok {
+			t.Fatalf("expected SSE stream to end, but got event %s: %s", ev.Event, ev.Data)
+		}
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatal("expected SSE stream to end, but it did not")
+	}
+}
+
+func (sc *sseClient) Close() {
+	sc.resp.Body.Close()
+}
+
+// Test that a Server-Sent Events request streams the subscribed resource,
+// followed by its events
+func TestSSE_Subscribe_StreamsResourceAndEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		ts := httptest.NewServer(s.s)
+		defer ts.Close()
+		model := resourceData("test.model")
+
+		ch := connectSSE(t, ts, "/events/test/model")
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		sc := getSSEClient(t, ch)
+		defer sc.Close()
+
+		if ct := sc.resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("expected Content-Type text/event-stream, but got %q", ct)
+		}
+		sc.AssertEvent(t, "subscribe", json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		sc.AssertEvent(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+	}, sse(server.SSEConfig{}))
+}
+
+// Test that the stream ends with an unsubscribe event when access to the
+// resource is revoked
+func TestSSE_AccessRevoked_EndsStream(t *testing.T) {
+	runTest(t, func(s *Session) {
+		ts := httptest.NewServer(s.s)
+		defer ts.Close()
+		model := resourceData("test.model")
+
+		ch := connectSSE(t, ts, "/events/test/model")
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		sc := getSSEClient(t, ch)
+		defer sc.Close()
+		sc.AssertEvent(t, "subscribe", json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+
+		s.ResourceEvent("test.model", "reaccess", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		sc.AssertEvent(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.accessDenied","message":"Access denied"}}`))
+		sc.AssertEnded(t)
+	}, sse(server.SSEConfig{}))
+}
+
+// Test that a failed subscription responds with a HTTP error
+func TestSSE_AccessDenied_RespondsWithError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/stream/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondError(reserr.ErrAccessDenied)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusUnauthorized)
+	}, sse(server.SSEConfig{Path: "/stream/"}))
+}
+
+// Test that the SSE endpoint is not found when not configured
+func TestSSE_NotConfigured_ReturnsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/events/test/model", nil).GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+	})
+}
+
+// Test that the subscribe event and the events sent over a Server-Sent Events
+// stream are counted as bytes out of the connection
+func TestSSE_Events_CountedAsBytesOut(t *testing.T) {
+	runTest(t, func(s *Session) {
+		ts := httptest.NewServer(s.s)
+		defer ts.Close()
+		model := resourceData("test.model")
+
+		ch := connectSSE(t, ts, "/events/test/model")
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		sc := getSSEClient(t, ch)
+		defer sc.Close()
+		sub := `{"models":{"test.model":` + model + `}}`
+		sc.AssertEvent(t, "subscribe", json.RawMessage(sub))
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		sc.AssertEvent(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		out := len(sub) + len(`{"event":"test.model.change","data":{"values":{"string":"bar"}}}`)
+
+		br := getBandwidth(t, s)
+		if len(br.Connections) != 1 || br.Connections[0].BytesOut != int64(out) {
+			t.Fatalf("expected SSE connection with %d bytes out, but got %+v", out, br.Connections)
+		}
+	}, sse(server.SSEConfig{}), func(cfg *server.Config) {
+		cfg.Bandwidth = &server.BandwidthConfig{}
+	})
+}
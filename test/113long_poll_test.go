@@ -0,0 +1,162 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func longPoll(lc server.LongPollConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.LongPoll = &lc
+	}
+}
+
+// longPollRequest makes a HTTP request to the test server, and returns the
+// response status code and body.
+func longPollRequest(t *testing.T, ts *httptest.Server, method, path, body string) (int, []byte) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating long-polling request: %s", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error making long-polling request: %s", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading long-polling response: %s", err)
+	}
+	return resp.StatusCode, b
+}
+
+// createLongPollSession creates a long-polling session, and returns the
+// session path.
+func createLongPollSession(t *testing.T, ts *httptest.Server) string {
+	code, b := longPollRequest(t, ts, "POST", "/poll/", "")
+	if code != http.StatusCreated {
+		t.Fatalf("expected status code %d, but got %d: %s", http.StatusCreated, code, b)
+	}
+	var r struct {
+		SID string `json:"sid"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil || r.SID == "" {
+		t.Fatalf("expected a session ID, but got: %s", b)
+	}
+	return "/poll/" + r.SID
+}
+
+// assertLongPoll polls for messages and asserts they equal the expected
+// messages.
+func assertLongPoll(t *testing.T, ts *httptest.Server, path string, expected json.RawMessage) {
+	code, b := longPollRequest(t, ts, "GET", path, "")
+	if code != http.StatusOK {
+		t.Fatalf("expected status code %d, but got %d: %s", http.StatusOK, code, b)
+	}
+	var a, e interface{}
+	json.Unmarshal(expected, &e)
+	if err := json.Unmarshal(b, &a); err != nil || !reflect.DeepEqual(a, e) {
+		t.Fatalf("expected poll response to be:\n%s\nbut got:\n%s", expected, b)
+	}
+}
+
+// Test that requests sent over a long-polling session are handled as over
+// WebSocket, with responses and events returned by polling
+func TestLongPoll_Subscribe_PollsResponseAndEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		ts := httptest.NewServer(s.s)
+		defer ts.Close()
+		model := resourceData("test.model")
+		path := createLongPollSession(t, ts)
+
+		if code, b := longPollRequest(t, ts, "POST", path, `[{"id":1,"method":"subscribe.test.model"}]`); code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, but got %d: %s", http.StatusNoContent, code, b)
+		}
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		assertLongPoll(t, ts, path, json.RawMessage(`[{"id":1,"result":{"models":{"test.model":`+model+`}}}]`))
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		assertLongPoll(t, ts, path, json.RawMessage(`[{"event":"test.model.change","data":{"values":{"string":"bar"}}}]`))
+	}, longPoll(server.LongPollConfig{}))
+}
+
+// Test that a poll without any messages responds with an empty array after
+// the timeout
+func TestLongPoll_NoMessages_RespondsAfterTimeout(t *testing.T) {
+	runTest(t, func(s *Session) {
+		ts := httptest.NewServer(s.s)
+		defer ts.Close()
+		path := createLongPollSession(t, ts)
+		assertLongPoll(t, ts, path, json.RawMessage(`[]`))
+	}, longPoll(server.LongPollConfig{Timeout: 20}))
+}
+
+// Test that a closed or unknown session responds with not found
+func TestLongPoll_ClosedSession_RespondsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		ts := httptest.NewServer(s.s)
+		defer ts.Close()
+		path := createLongPollSession(t, ts)
+
+		if code, b := longPollRequest(t, ts, "DELETE", path, ""); code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, but got %d: %s", http.StatusNoContent, code, b)
+		}
+		for _, p := range []string{path, "/poll/unknown"} {
+			if code, b := longPollRequest(t, ts, "GET", p, ""); code != http.StatusNotFound {
+				t.Fatalf("expected status code %d for %s, but got %d: %s", http.StatusNotFound, p, code, b)
+			}
+		}
+	}, longPoll(server.LongPollConfig{}))
+}
+
+// Test that a session not polled within the session timeout is closed
+func TestLongPoll_SessionTimeout_ClosesSession(t *testing.T) {
+	runTest(t, func(s *Session) {
+		ts := httptest.NewServer(s.s)
+		defer ts.Close()
+		path := createLongPollSession(t, ts)
+
+		time.Sleep(50 * time.Millisecond)
+		if code, b := longPollRequest(t, ts, "GET", path, ""); code != http.StatusNotFound {
+			t.Fatalf("expected status code %d, but got %d: %s", http.StatusNotFound, code, b)
+		}
+	}, longPoll(server.LongPollConfig{SessionTimeout: 20}))
+}
+
+// Test that a long-polling session is a client connection, with the
+// capabilities of one, and with its traffic counted as bandwidth
+func TestLongPoll_Session_CountsAsClientConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		ts := httptest.NewServer(s.s)
+		defer ts.Close()
+		path := createLongPollSession(t, ts)
+
+		in := `{"id":1,"method":"call.test.model.method"}`
+		if code, b := longPollRequest(t, ts, "POST", path, in); code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, but got %d: %s", http.StatusNoContent, code, b)
+		}
+		req := s.GetRequest(t).AssertSubject(t, "access.test.model")
+		req.AssertPathPayload(t, "capabilities", json.RawMessage(`{"protocol":"1.1.1","resourceResponse":false}`))
+		req.RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		out := `{"id":1,"result":{"foo":"bar"}}`
+		assertLongPoll(t, ts, path, json.RawMessage(`[`+out+`]`))
+
+		br := getBandwidth(t, s)
+		if len(br.Connections) != 1 || br.Connections[0].BytesIn != int64(len(in)) || br.Connections[0].BytesOut != int64(len(out)) {
+			t.Fatalf("expected long-polling connection with %d bytes in and %d bytes out, but got %+v", len(in), len(out), br.Connections)
+		}
+	}, longPoll(server.LongPollConfig{}), withRequestCapabilities, func(cfg *server.Config) {
+		cfg.Bandwidth = &server.BandwidthConfig{}
+	})
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func rateLimit(rc server.RateLimitConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.RateLimit = &rc
+	}
+}
+
+// Test that WebSocket requests exceeding the connection rate limit get a
+// system.tooManyRequests error, without any NATS request
+func TestRateLimit_WSLimitExceeded_RespondsWithError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.Request("version", versionRequest).GetResponse(t)
+
+		cresp := c.Request("call.test.model.method", nil).GetResponse(t).AssertErrorCode(t, reserr.CodeTooManyRequests)
+		data, _ := json.Marshal(cresp.Error.Data)
+		var hint struct {
+			RetryAfter int64 `json:"retryAfter"`
+		}
+		if err := json.Unmarshal(data, &hint); err != nil || hint.RetryAfter <= 0 {
+			t.Fatalf("expected error data with a positive retryAfter, but got %s", data)
+		}
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+	}, rateLimit(server.RateLimitConfig{WS: &server.RateLimit{Rate: 1}}))
+}
+
+// Test that HTTP API requests exceeding the client IP rate limit get a 429
+// Too Many Requests response, while other client IPs are not limited
+func TestRateLimit_HTTPLimitExceeded_RespondsWithTooManyRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(model))
+		// The resource is cached for the burst request
+		hreq = s.HTTPRequest("GET", "/api/test/model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(model))
+
+		s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t).
+			AssertStatusCode(t, http.StatusTooManyRequests).
+			AssertErrorCode(t, reserr.CodeTooManyRequests).
+			AssertHeaders(t, map[string]string{"Retry-After": "10"})
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+
+		hreq = s.HTTPRequest("GET", "/api/test/model", nil, func(r *http.Request) {
+			r.RemoteAddr = "192.0.2.2:1234"
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(model))
+	}, rateLimit(server.RateLimitConfig{HTTP: &server.RateLimit{Rate: 0.1, Burst: 2}}))
+}
+
+// Test that requests to any HTTP endpoint take from the same client IP rate
+// limit, and get a 429 Too Many Requests response once exceeded
+func TestRateLimit_HTTPLimitExceeded_LimitsAllHTTPEndpoints(t *testing.T) {
+	tbl := []struct {
+		Method string
+		Path   string
+		Body   []byte
+	}{
+		{"GET", "/api/test/model", nil},
+		{"POST", "/bulk", []byte(`{"operations":[{"rid":"test.model","method":"method"}]}`)},
+		{"GET", "/events/test/model", nil},
+		{"POST", "/poll/", nil},
+	}
+
+	for i, l := range tbl {
+		runTest(t, func(s *Session) {
+			s.HTTPRequest("GET", "/unknown", nil).GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+			s.HTTPRequest(l.Method, l.Path, l.Body).GetResponse(t).
+				AssertStatusCode(t, http.StatusTooManyRequests).
+				AssertErrorCode(t, reserr.CodeTooManyRequests).
+				AssertHeaders(t, map[string]string{"Retry-After": "10"})
+			if len(s.reqs) > 0 {
+				t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+			}
+		},
+			bulkCall(1),
+			sse(server.SSEConfig{}),
+			longPoll(server.LongPollConfig{}),
+			rateLimit(server.RateLimitConfig{HTTP: &server.RateLimit{Rate: 0.1, Burst: 1}}),
+		)
+		if t.Failed() {
+			t.Logf("failed on test %d", i)
+			break
+		}
+	}
+}
+
+// Test that requests sent over a long-polling session take from the client
+// IP rate limit
+func TestRateLimit_HTTPLimitExceeded_LimitsLongPollSend(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hresp := s.HTTPRequest("POST", "/poll/", nil).GetResponse(t).AssertStatusCode(t, http.StatusCreated)
+		var r struct {
+			SID string `json:"sid"`
+		}
+		if err := json.Unmarshal(hresp.Body.Bytes(), &r); err != nil || r.SID == "" {
+			t.Fatalf("expected a session ID, but got: %s", hresp.Body.Bytes())
+		}
+		s.HTTPRequest("POST", "/poll/"+r.SID, []byte(`{"id":1,"method":"subscribe.test.model"}`)).GetResponse(t).
+			AssertStatusCode(t, http.StatusTooManyRequests).
+			AssertErrorCode(t, reserr.CodeTooManyRequests)
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+	}, longPoll(server.LongPollConfig{}), rateLimit(server.RateLimitConfig{HTTP: &server.RateLimit{Rate: 0.1, Burst: 1}}))
+}
+
+// Test that with Redis, HTTP requests are counted by the fleet in shared
+// windows, and get a 429 Too Many Requests response once the requests of
+// all instances exceed the burst
+func TestRateLimit_SharedHTTPLimitExceeded_RespondsWithTooManyRequests(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	defer rs.Close()
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		keys := rs.Keys("resgate:ratelimit:")
+		if len(keys) != 1 {
+			t.Fatalf("expected a single rate limit key, but got %+v", keys)
+		}
+		// Count a request made to another instance
+		for k, v := range keys {
+			if v != "1" {
+				t.Fatalf("expected rate limit count 1, but got %#v", v)
+			}
+			rs.Set(k, "2")
+		}
+
+		s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t).
+			AssertStatusCode(t, http.StatusTooManyRequests).
+			AssertErrorCode(t, reserr.CodeTooManyRequests)
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+	}, rateLimit(server.RateLimitConfig{HTTP: &server.RateLimit{Rate: 0.1, Burst: 2}}), rs.Config)
+}
+
+// Test that the HTTP rate limit falls back to the limit of the instance when
+// Redis is unavailable
+func TestRateLimit_SharedHTTPLimitRedisUnavailable_UsesInstanceLimit(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	rs.Close()
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t).AssertStatusCode(t, http.StatusTooManyRequests)
+		s.AssertErrorsLogged(t, 2)
+	}, rateLimit(server.RateLimitConfig{HTTP: &server.RateLimit{Rate: 0.1, Burst: 1}}), rs.Config)
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// cdnRequest is a purge request received by a fake CDN API.
+type cdnRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// newCDNServer starts a fake CDN API, passing each request on the channel.
+func newCDNServer() (*httptest.Server, chan cdnRequest) {
+	ch := make(chan cdnRequest, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ch <- cdnRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header, Body: string(body)}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	return ts, ch
+}
+
+func getCDNRequest(t *testing.T, ch chan cdnRequest) cdnRequest {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatal("expected a CDN purge request but found none")
+	}
+	return cdnRequest{}
+}
+
+func surrogateKeys(sc server.SurrogateKeysConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.SurrogateKeys = &sc
+	}
+}
+
+// getParentModelOverHTTP makes a HTTP GET request for test.model.parent,
+// and asserts the surrogate key header.
+func getParentModelOverHTTP(t *testing.T, s *Session, header, keys string) {
+	hreq := s.HTTPRequest("GET", "/api/test/model/parent", nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+	s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+	hreq.GetResponse(t).
+		AssertStatusCode(t, http.StatusOK).
+		AssertHeaders(t, map[string]string{header: keys})
+}
+
+// Test that HTTP GET responses list the resource and its references as
+// surrogate keys
+func TestSurrogateKeys_HTTPGet_SetsHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		getParentModelOverHTTP(t, s, "Surrogate-Key", "v1.test.model v1.test.model.parent")
+	}, surrogateKeys(server.SurrogateKeysConfig{Prefix: "v1."}))
+}
+
+// Test that a change event on a served resource purges its surrogate key
+// with the Fastly API
+func TestSurrogateKeys_ChangeEvent_PurgesFastlyKey(t *testing.T) {
+	ts, ch := newCDNServer()
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		getParentModelOverHTTP(t, s, "Cache-Tag", "test.model test.model.parent")
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		r := getCDNRequest(t, ch)
+		if r.Method != "POST" || r.Path != "/service/SID/purge" {
+			t.Fatalf("expected POST /service/SID/purge, but got %s %s", r.Method, r.Path)
+		}
+		if r.Header.Get("Fastly-Key") != "token" || r.Header.Get("Surrogate-Key") != "test.model" || r.Header.Get("Fastly-Soft-Purge") != "1" {
+			t.Fatalf("expected Fastly purge headers for test.model, but got %v", r.Header)
+		}
+	}, surrogateKeys(server.SurrogateKeysConfig{
+		Header: "Cache-Tag",
+		Purge:  &server.SurrogatePurgeConfig{Provider: "fastly", URL: ts.URL, ServiceID: "SID", Token: "token", SoftPurge: true, Delay: 10},
+	}))
+}
+
+// Test that a system reset event purges the served resources matching the
+// reset patterns with a CloudFront invalidation
+func TestSurrogateKeys_SystemReset_CreatesCloudFrontInvalidation(t *testing.T) {
+	ts, ch := newCDNServer()
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		getParentModelOverHTTP(t, s, "Surrogate-Key", "test.model test.model.parent")
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.model.*"]}`))
+		// Cached resources matching the pattern are refetched
+		s.GetRequest(t).AssertSubject(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+
+		r := getCDNRequest(t, ch)
+		if r.Method != "POST" || r.Path != "/2020-05-31/distribution/EDFDVBD6EXAMPLE/invalidation" {
+			t.Fatalf("expected POST /2020-05-31/distribution/EDFDVBD6EXAMPLE/invalidation, but got %s %s", r.Method, r.Path)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			t.Fatalf("expected AWS signed request, but got Authorization %q", r.Header.Get("Authorization"))
+		}
+		if !strings.Contains(r.Body, "<Paths><Quantity>1</Quantity><Items><Path>/api/test/model/parent*</Path></Items></Paths>") {
+			t.Fatalf("expected invalidation of /api/test/model/parent*, but got %s", r.Body)
+		}
+	}, surrogateKeys(server.SurrogateKeysConfig{
+		Purge: &server.SurrogatePurgeConfig{Provider: "cloudfront", URL: ts.URL, DistributionID: "EDFDVBD6EXAMPLE", AccessKey: "AKID", SecretKey: "secret", Delay: 10},
+	}))
+}
@@ -0,0 +1,125 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func trustedProxies(proxies ...string) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.TrustedProxies = proxies
+	}
+}
+
+// forwardedHeader returns a header with the Forwarded and X-Forwarded-For
+// values, if not empty.
+func forwardedHeader(fwd, xff string) http.Header {
+	h := http.Header{}
+	if fwd != "" {
+		h.Set("Forwarded", fwd)
+	}
+	if xff != "" {
+		h.Set("X-Forwarded-For", xff)
+	}
+	return h
+}
+
+// Test that the HTTP rate limit is taken by the forwarded client IP of
+// requests from trusted proxies, and by the remote address otherwise
+func TestTrustedProxies_HTTPRateLimit_LimitsForwardedClientIP(t *testing.T) {
+	type request struct {
+		RemoteAddr string
+		Forwarded  string
+		XFF        string
+	}
+	tbl := []struct {
+		First   request
+		Second  request
+		Limited bool
+	}{
+		// Same client through different proxies
+		{request{"10.0.0.1:1234", "", "192.0.2.1"}, request{"10.0.0.2:1234", "", "192.0.2.1"}, true},
+		// Different clients through the same proxy
+		{request{"10.0.0.1:1234", "", "192.0.2.1"}, request{"10.0.0.1:1234", "", "192.0.2.2"}, false},
+		// Untrusted remote address
+		{request{"192.0.2.9:1234", "", "192.0.2.1"}, request{"192.0.2.9:1234", "", "192.0.2.2"}, true},
+		{request{"192.0.2.1:1234", "", ""}, request{"192.0.2.1:1234", "", "192.0.2.2"}, true},
+		// Trusted proxies in the chain are skipped
+		{request{"10.0.0.1:1234", "", "192.0.2.1, 10.0.0.5"}, request{"10.0.0.1:1234", "", "192.0.2.1"}, true},
+		{request{"10.0.0.1:1234", "", "192.0.2.1, [::1]:8080"}, request{"10.0.0.1:1234", "", "192.0.2.1"}, true},
+		// Addresses set by the client before an untrusted hop are ignored
+		{request{"10.0.0.1:1234", "", "192.0.2.1, 198.51.100.1"}, request{"10.0.0.1:1234", "", "192.0.2.2, 198.51.100.1"}, true},
+		// Invalid addresses end the chain
+		{request{"10.0.0.1:1234", "", "192.0.2.1, unknown"}, request{"10.0.0.1:1234", "", "192.0.2.2, unknown"}, true},
+		// Forwarded header
+		{request{"10.0.0.1:1234", `for=192.0.2.1;proto=https, for="[2001:db8::1]:4711"`, ""}, request{"10.0.0.1:1234", `For="[2001:db8::1]"`, ""}, true},
+		{request{"10.0.0.1:1234", `for=192.0.2.1`, ""}, request{"10.0.0.1:1234", `for=192.0.2.2;by=10.0.0.1`, ""}, false},
+		// Forwarded header takes precedence over X-Forwarded-For
+		{request{"10.0.0.1:1234", `for=192.0.2.1`, "192.0.2.2"}, request{"10.0.0.1:1234", `for=192.0.2.2`, "192.0.2.1"}, false},
+	}
+
+	for i, l := range tbl {
+		runTest(t, func(s *Session) {
+			for j, r := range []request{l.First, l.Second} {
+				hresp := s.HTTPRequest("GET", "/unknown", nil, func(req *http.Request) {
+					req.RemoteAddr = r.RemoteAddr
+					req.Header = forwardedHeader(r.Forwarded, r.XFF)
+				}).GetResponse(t)
+				if j == 1 && l.Limited {
+					hresp.AssertStatusCode(t, http.StatusTooManyRequests)
+				} else {
+					hresp.AssertStatusCode(t, http.StatusNotFound)
+				}
+			}
+		},
+			trustedProxies("10.0.0.0/8", "::1"),
+			rateLimit(server.RateLimitConfig{HTTP: &server.RateLimit{Rate: 0.1, Burst: 1}}),
+		)
+		if t.Failed() {
+			t.Logf("failed on test %d", i)
+			break
+		}
+	}
+}
+
+// Test that auth requests are banned by the forwarded client IP of
+// connections from a trusted proxy
+func TestTrustedProxies_BruteForce_BansForwardedClientIP(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithRemoteAddr("10.0.0.1:1234", forwardedHeader("", "192.0.2.1"))
+		failAuth(t, s, c, `{}`)
+		failAuth(t, s, c, `{}`)
+		c.Request("auth.test.model.login", nil).GetResponse(t).AssertError(t, errAuthBanned)
+
+		c = s.ConnectWithRemoteAddr("10.0.0.2:1234", forwardedHeader("", "192.0.2.1"))
+		c.Request("auth.test.model.login", nil).GetResponse(t).AssertError(t, errAuthBanned)
+
+		c = s.ConnectWithRemoteAddr("10.0.0.1:1234", forwardedHeader("", "192.0.2.2"))
+		failAuth(t, s, c, `{}`)
+	}, trustedProxies("10.0.0.0/8"), bruteForce(0))
+}
+
+// Test that protocol errors quarantine the forwarded client IP of
+// connections from a trusted proxy
+func TestTrustedProxies_Quarantine_BansForwardedClientIP(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithRemoteAddr("10.0.0.1:1234", forwardedHeader("for=192.0.2.1", ""))
+		c.Request("foo", nil).GetResponse(t).AssertError(t, reserr.ErrInvalidRequest)
+		c.Request("foo", nil)
+		c.AssertClosed(t)
+
+		qr := getQuarantine(t, s)
+		if len(qr.Banned) != 1 || qr.Banned[0] != "192.0.2.1" {
+			t.Fatalf("expected client IP 192.0.2.1 to be banned, but got %+v", qr)
+		}
+
+		// Other clients of the proxy are not banned
+		c = s.ConnectWithRemoteAddr("10.0.0.1:1234", forwardedHeader("for=192.0.2.2", ""))
+		c.Request("version", versionRequest).GetResponse(t).AssertResult(t, versionResult)
+	}, trustedProxies("10.0.0.0/8"), func(cfg *server.Config) {
+		cfg.Quarantine = &server.QuarantineConfig{MaxErrors: 2, Window: 60000, BanDuration: 60000}
+	})
+}
@@ -155,11 +155,11 @@ func TestHTTPPostNewResponses(t *testing.T) {
 		ExpectedErrors     int               // Expected logged errors
 	}{
 		// Params variants
-		{params, fullCallAccess, legacyCallResponse, http.StatusOK, nil, modelLocationHref, 1},
-		{nil, fullCallAccess, legacyCallResponse, http.StatusOK, nil, modelLocationHref, 1},
+		{params, fullCallAccess, legacyCallResponse, http.StatusCreated, nil, modelLocationHref, 1},
+		{nil, fullCallAccess, legacyCallResponse, http.StatusCreated, nil, modelLocationHref, 1},
 		// CallAccessResponse variants
-		{params, methodCallAccess, legacyCallResponse, http.StatusOK, nil, modelLocationHref, 1},
-		{params, multiMethodCallAccess, legacyCallResponse, http.StatusOK, nil, modelLocationHref, 1},
+		{params, methodCallAccess, legacyCallResponse, http.StatusCreated, nil, modelLocationHref, 1},
+		{params, multiMethodCallAccess, legacyCallResponse, http.StatusCreated, nil, modelLocationHref, 1},
 		{params, missingMethodCallAccess, noRequest, http.StatusUnauthorized, reserr.ErrAccessDenied, nil, 0},
 		{params, noCallAccess, noRequest, http.StatusUnauthorized, reserr.ErrAccessDenied, nil, 0},
 		{params, requestTimeout, noRequest, http.StatusNotFound, mq.ErrRequestTimeout, nil, 0},
@@ -167,7 +167,7 @@ func TestHTTPPostNewResponses(t *testing.T) {
 		{params, fullCallAccess, reserr.ErrInvalidParams, http.StatusBadRequest, reserr.ErrInvalidParams, nil, 0},
 		{params, fullCallAccess, requestTimeout, http.StatusNotFound, mq.ErrRequestTimeout, nil, 0},
 		// Non-legacy call response
-		{params, fullCallAccess, nonlegacyCallResponse, http.StatusOK, nil, modelLocationHref, 0},
+		{params, fullCallAccess, nonlegacyCallResponse, http.StatusCreated, nil, modelLocationHref, 0},
 	}
 
 	for i, l := range tbl {
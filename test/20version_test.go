@@ -38,6 +38,8 @@ func TestVersion_Request_ReturnsExpectedResponse(t *testing.T) {
 		{json.RawMessage(`{"protocol":"1.0.1000"}`), reserr.ErrInvalidParams},
 		{json.RawMessage(`{"protocol":"1.1000.0"}`), reserr.ErrInvalidParams},
 		{json.RawMessage(`{"protocol":"v1.0.0"}`), reserr.ErrInvalidParams},
+		{json.RawMessage(`{"protocol":"1.2.-5"}`), reserr.ErrInvalidParams},
+		{json.RawMessage(`{"protocol":"1.+2.0"}`), reserr.ErrInvalidParams},
 		// Unsupported protocol
 		{json.RawMessage(`{"protocol":"0.0.0"}`), reserr.ErrUnsupportedProtocol},
 		{json.RawMessage(`{"protocol":"2.0.0"}`), reserr.ErrUnsupportedProtocol},
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that an exported subscription state can be imported on a new connection
+func TestSubscriptionState_ExportImport_RestoresSubscriptions(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		// Export state
+		result := c.Request("export", nil).GetResponse(t).Result.(map[string]interface{})
+		state, ok := result["state"].(string)
+		if !ok || state == "" {
+			t.Fatalf("expected export result to contain a state string, but got %#v", result)
+		}
+		c.Disconnect()
+		c.AssertClosed(t)
+
+		// Import state on a new connection, with the model still cached
+		c2 := s.Connect()
+		creq := c2.Request("import", json.RawMessage(`{"state":"`+state+`"}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+
+		// Validate the subscription is restored
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c2.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent())
+		c2.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+	})
+}
+
+// Test that an empty subscription state is exported and imported
+func TestSubscriptionState_ExportWithoutSubscriptions_ImportReturnsEmptyResult(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		result := c.Request("export", nil).GetResponse(t).Result.(map[string]interface{})
+		state := result["state"].(string)
+		c.Request("import", json.RawMessage(`{"state":"`+state+`"}`)).GetResponse(t).AssertResult(t, json.RawMessage(`{}`))
+	})
+}
+
+// Test that a resource with denied access is returned as an error on import
+func TestSubscriptionState_ImportWithAccessDenied_ReturnsResourceError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		result := c.Request("export", nil).GetResponse(t).Result.(map[string]interface{})
+		state := result["state"].(string)
+
+		c2 := s.Connect()
+		creq := c2.Request("import", json.RawMessage(`{"state":"`+state+`"}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"errors":{"test.model":{"code":"system.accessDenied","message":"Access denied"}}}`))
+		c2.Request("unsubscribe.test.model", nil).GetResponse(t).AssertError(t, reserr.ErrNoSubscription)
+	})
+}
+
+// Test that import with invalid state returns an invalid params error
+func TestSubscriptionState_ImportWithInvalidState_ReturnsInvalidParams(t *testing.T) {
+	tbl := []json.RawMessage{
+		nil,
+		json.RawMessage(`{}`),
+		json.RawMessage(`{"state":""}`),
+		json.RawMessage(`{"state":"!invalid!"}`),
+		json.RawMessage(`{"state":42}`),
+		json.RawMessage(`"foo"`),
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			c.Request("import", l).GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withIdempotencyWindow(cfg *server.Config) {
+	cfg.IdempotencyWindow = 3000
+}
+
+// Test that a retried call request with the same idempotency key gets the
+// original response without a new call request sent to the service
+func TestIdempotency_RetriedCall_ReturnsOriginalResponse(t *testing.T) {
+	runTest(t, func(s *Session) {
+		result := json.RawMessage(`{"foo":"bar"}`)
+
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", json.RawMessage(`{"value":42}`), "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(result)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+
+		// Retry on a new connection
+		c2 := s.Connect()
+		creq = c2.RequestWithIdempotencyKey("call.test.model.method", json.RawMessage(`{"value":42}`), "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+		c2.AssertNoNATSRequest(t, "test.model")
+	}, withIdempotencyWindow)
+}
+
+// Test that a retried call request on a connection with the same token gets
+// the original response, while one with a different token is sent to the
+// service
+func TestIdempotency_RetriedCallWithToken_ScopedToToken(t *testing.T) {
+	tbl := []struct {
+		Token    string
+		Original bool
+	}{
+		{`{"user":"foo"}`, true},
+		{`{"user":"bar"}`, false},
+		{``, false},
+	}
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("test %d", i), func(s *Session) {
+			c := s.Connect()
+			s.ConnEvent(getCID(t, s, c), "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+			creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess("original")
+			creq.GetResponse(t)
+
+			c2 := s.Connect()
+			if l.Token != "" {
+				s.ConnEvent(getCID(t, s, c2), "token", json.RawMessage(`{"token":`+l.Token+`}`))
+			}
+			creq = c2.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			if l.Original {
+				creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":"original"}`))
+				c2.AssertNoNATSRequest(t, "test.model")
+			} else {
+				s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess("new")
+				creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":"new"}`))
+			}
+		}, withIdempotencyWindow)
+	}
+}
+
+// Test that a retried call request with the same idempotency key but
+// different parameters gets an invalid params error
+func TestIdempotency_RetriedCallWithDifferentParams_ReturnsInvalidParams(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", json.RawMessage(`{"value":42}`), "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		creq = c.RequestWithIdempotencyKey("call.test.model.method", json.RawMessage(`{"value":43}`), "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInvalidParams)
+		c.AssertNoNATSRequest(t, "test.model")
+
+		// Same parameters with different whitespace
+		creq = c.RequestWithIdempotencyKey("call.test.model.method", json.RawMessage(`{ "value": 42 }`), "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	}, withIdempotencyWindow)
+}
+
+// Test that call requests with different idempotency keys are both sent to the service
+func TestIdempotency_DifferentKeys_SendsBothRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for _, key := range []string{"key1", "key2"} {
+			creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, key)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(key)
+			creq.GetResponse(t)
+		}
+	}, withIdempotencyWindow)
+}
+
+// Test that a retried call request is still access checked
+func TestIdempotency_RetriedCallWithoutAccess_ReturnsAccessDenied(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		c2 := s.Connect()
+		creq = c2.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+	}, withIdempotencyWindow)
+}
+
+// Test that a timed out call request is not stored
+func TestIdempotency_TimedOutCall_IsRetried(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").Timeout()
+		creq.GetResponse(t).AssertError(t, reserr.ErrTimeout)
+
+		creq = c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	}, withIdempotencyWindow)
+}
+
+// Test that the idempotency key is ignored when no window is configured
+func TestIdempotency_WithoutWindow_SendsBothRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 0; i < 2; i++ {
+			creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+			creq.GetResponse(t)
+		}
+	})
+}
+
+// Test that a retried HTTP POST request with the same Idempotency-Key header
+// gets the original response
+func TestIdempotency_RetriedHTTPPost_ReturnsOriginalResponse(t *testing.T) {
+	runTest(t, func(s *Session) {
+		result := json.RawMessage(`{"foo":"bar"}`)
+		withKey := func(req *http.Request) {
+			req.Header.Set("Idempotency-Key", "key1")
+		}
+
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, withKey)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(result)
+		hreq.GetResponse(t).Equals(t, http.StatusOK, result)
+
+		hreq = s.HTTPRequest("POST", "/api/test/model/method", nil, withKey)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, result)
+	}, withIdempotencyWindow)
+}
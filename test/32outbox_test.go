@@ -0,0 +1,290 @@
+package test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/outbox"
+)
+
+func withOutbox(t *testing.T) (string, func(*server.Config)) {
+	dir, err := ioutil.TempDir("", "resgate-outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func(cfg *server.Config) {
+		cfg.OutboxPath = &dir
+	}
+}
+
+func assertOutboxLen(t *testing.T, dir string, n int) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != n {
+		t.Fatalf("expected outbox to contain %d request(s), but found %d", n, len(files))
+	}
+}
+
+// Test that a call request with an idempotency key is stored in the outbox
+// until a response is received, and that the key is passed to the service
+func TestOutbox_CallWithIdempotencyKey_StoredUntilResponse(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", json.RawMessage(`{"value":42}`), "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		req := s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "idempotencyKey", "key1").
+			AssertPathPayload(t, "params", json.RawMessage(`{"value":42}`))
+		assertOutboxLen(t, dir, 1)
+		req.RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+		assertOutboxLen(t, dir, 0)
+	}, cfg)
+}
+
+// Test that a call request without an idempotency key is not stored in the outbox
+func TestOutbox_CallWithoutIdempotencyKey_NotStored(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		req := s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+		assertOutboxLen(t, dir, 0)
+		req.RespondSuccess(nil)
+		creq.GetResponse(t)
+	}, cfg)
+}
+
+// Test that a timed out call request is kept in the outbox
+func TestOutbox_TimedOutCall_KeptInOutbox(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").Timeout()
+		creq.GetResponse(t)
+		assertOutboxLen(t, dir, 1)
+	}, cfg)
+}
+
+// Test that call requests remaining in the outbox are resent on start
+func TestOutbox_PendingRequests_ResentOnStart(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	o, err := outbox.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := json.RawMessage(`{"params":{"value":42},"cid":"foo","idempotencyKey":"key1"}`)
+	if _, err := o.Add("call.test.model.method", payload); err != nil {
+		t.Fatal(err)
+	}
+	// Release the requests, as on exit of a previous run
+	o.Close()
+
+	runTest(t, func(s *Session) {
+		s.GetRequest(t).Equals(t, "call.test.model.method", payload).RespondSuccess(nil)
+		c := s.Connect()
+		c.AssertNoNATSRequest(t, "test.model")
+		assertOutboxLen(t, dir, 0)
+	}, cfg)
+}
+
+// Test that a stored request that cannot be read is moved aside to a .bad
+// file and logged, while the requests after it are still resent on start
+func TestOutbox_UnreadableRequest_MovedAsideAndOthersResent(t *testing.T) {
+	tbl := []string{
+		`not json`,
+		`{"subject":"call.test.model.method","payload":{},"encryptedToken":"bad"}`,
+	}
+	for i, l := range tbl {
+		dir, cfg := withOutbox(t)
+		defer os.RemoveAll(dir)
+
+		// Named to be ordered before the valid request
+		if err := ioutil.WriteFile(filepath.Join(dir, "0000.json"), []byte(l), 0600); err != nil {
+			t.Fatal(err)
+		}
+		o, err := outbox.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := json.RawMessage(`{"params":{"value":42},"cid":"foo","idempotencyKey":"key1"}`)
+		if _, err := o.Add("call.test.model.method", payload); err != nil {
+			t.Fatal(err)
+		}
+		// Release the requests, as on exit of a previous run
+		o.Close()
+
+		runNamedTest(t, fmt.Sprintf("test %d", i), func(s *Session) {
+			s.GetRequest(t).Equals(t, "call.test.model.method", payload).RespondSuccess(nil)
+			c := s.Connect()
+			c.AssertNoNATSRequest(t, "test.model")
+			assertOutboxLen(t, dir, 1)
+			if _, err := os.Stat(filepath.Join(dir, "0000.bad")); err != nil {
+				t.Fatalf("expected unreadable request to be moved aside, but got %s", err)
+			}
+			s.AssertErrorsLogged(t, 1)
+		}, cfg)
+	}
+}
+
+// Test that a request in flight in one outbox is not acquired by another
+// outbox sharing the directory until it is released
+func TestOutbox_SharedDirectory_AcquiredByOneOutboxAtATime(t *testing.T) {
+	dir, _ := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	o1, err := outbox.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o1.Close()
+	o2, err := outbox.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o2.Close()
+
+	assertAcquired := func(o *outbox.Outbox, n int) {
+		t.Helper()
+		entries, err := o.Acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != n {
+			t.Fatalf("expected %d acquired request(s), but got %d", n, len(entries))
+		}
+	}
+
+	id, err := o1.Add("call.test.model.method", json.RawMessage(`{"cid":"foo"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAcquired(o2, 0)
+	o1.Release(id)
+	assertAcquired(o2, 1)
+	assertAcquired(o1, 0)
+	if err := o2.Remove(id); err != nil {
+		t.Fatal(err)
+	}
+	assertAcquired(o1, 0)
+	assertOutboxLen(t, dir, 0)
+}
+
+// outboxTokenKey is a base64 encoded 256-bit AES key used in tests.
+const outboxTokenKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+// readOutbox returns the contents of all files in the outbox directory.
+func readOutbox(t *testing.T, dir string) []byte {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, b...)
+	}
+	return data
+}
+
+// Test that the token of a call request stored in the outbox is encrypted
+// with outboxTokenKey or excluded with outboxExcludeTokens, while the request
+// sent to the service holds the token
+func TestOutbox_TokenSettings_ProtectsStoredTokens(t *testing.T) {
+	tbl := []struct {
+		Name      string
+		Cfg       func(*server.Config)
+		Encrypted bool
+	}{
+		{"outboxTokenKey", func(cfg *server.Config) {
+			key := outboxTokenKey
+			cfg.OutboxTokenKey = &key
+		}, true},
+		{"outboxExcludeTokens", func(cfg *server.Config) {
+			cfg.OutboxExcludeTokens = true
+		}, false},
+	}
+
+	for _, l := range tbl {
+		dir, cfg := withOutbox(t)
+		defer os.RemoveAll(dir)
+
+		runNamedTest(t, l.Name, func(s *Session) {
+			c := s.Connect()
+			s.ConnEvent(getCID(t, s, c), "token", json.RawMessage(`{"token":{"user":"secret"}}`))
+			creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			req := s.GetRequest(t).
+				AssertSubject(t, "call.test.model.method").
+				AssertPathPayload(t, "token", json.RawMessage(`{"user":"secret"}`))
+
+			data := readOutbox(t, dir)
+			if bytes.Contains(data, []byte("secret")) {
+				t.Fatalf("expected stored request not to contain the token, but got %s", data)
+			}
+			if bytes.Contains(data, []byte("encryptedToken")) != l.Encrypted {
+				t.Fatalf("expected stored request to contain an encrypted token to be %t, but got %s", l.Encrypted, data)
+			}
+
+			req.RespondSuccess(nil)
+			creq.GetResponse(t)
+		}, cfg, l.Cfg)
+	}
+}
+
+// Test that call requests stored with an encrypted token are resent on start
+// with the decrypted token
+func TestOutbox_EncryptedToken_ResentWithToken(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	key, _ := base64.StdEncoding.DecodeString(outboxTokenKey)
+	o, err := outbox.OpenWithOptions(dir, outbox.Options{TokenKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := json.RawMessage(`{"params":{"value":42},"token":{"user":"secret"},"cid":"foo","idempotencyKey":"key1"}`)
+	if _, err := o.Add("call.test.model.method", payload); err != nil {
+		t.Fatal(err)
+	}
+	// Release the requests, as on exit of a previous run
+	o.Close()
+	if data := readOutbox(t, dir); bytes.Contains(data, []byte("secret")) {
+		t.Fatalf("expected stored request not to contain the token, but got %s", data)
+	}
+
+	runTest(t, func(s *Session) {
+		s.GetRequest(t).Equals(t, "call.test.model.method", payload).RespondSuccess(nil)
+		c := s.Connect()
+		c.AssertNoNATSRequest(t, "test.model")
+		assertOutboxLen(t, dir, 0)
+	}, cfg, func(cfg *server.Config) {
+		key := outboxTokenKey
+		cfg.OutboxTokenKey = &key
+	})
+}
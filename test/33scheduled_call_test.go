@@ -0,0 +1,268 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/outbox"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func executeAt(d time.Duration) string {
+	return time.Now().Add(d).Format(time.RFC3339Nano)
+}
+
+func scheduleID(t *testing.T, creq *ClientRequest) string {
+	result := creq.GetResponse(t).Result.(map[string]interface{})
+	id, ok := result["scheduleId"].(string)
+	if !ok || id == "" {
+		t.Fatalf("expected call result to contain a scheduleId string, but got %#v", result)
+	}
+	return id
+}
+
+// Test that a call request with an execute-at time is sent to the service
+// once the time is reached, on behalf of the token
+func TestScheduledCall_ExecuteAt_SentOnTime(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		token := json.RawMessage(`{"user":"foo"}`)
+
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+string(token)+`}`))
+
+		creq := c.RequestWithExecuteAt("call.test.model.method", json.RawMessage(`{"value":42}`), executeAt(100*time.Millisecond))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		scheduleID(t, creq)
+		assertOutboxLen(t, dir, 1)
+
+		// Close the connection before the call is sent
+		c.Disconnect()
+		c.AssertClosed(t)
+
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "token", token).
+			AssertPathPayload(t, "params", json.RawMessage(`{"value":42}`)).
+			RespondSuccess(nil)
+		s.Connect().AssertNoNATSRequest(t, "test.model")
+		assertOutboxLen(t, dir, 0)
+	}, cfg)
+}
+
+// Test that a scheduled call may be cancelled before it is sent
+func TestScheduledCall_Cancel_RemovesScheduledCall(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithExecuteAt("call.test.model.method", nil, executeAt(time.Hour))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		id := scheduleID(t, creq)
+
+		c.Request("cancel", json.RawMessage(`{"scheduleId":"`+id+`"}`)).GetResponse(t).AssertResult(t, nil)
+		assertOutboxLen(t, dir, 0)
+		c.Request("cancel", json.RawMessage(`{"scheduleId":"`+id+`"}`)).GetResponse(t).AssertError(t, reserr.ErrNotFound)
+	}, cfg)
+}
+
+// Test that a scheduled call cannot be cancelled using a different token
+func TestScheduledCall_CancelWithDifferentToken_ReturnsNotFound(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+		creq := c.RequestWithExecuteAt("call.test.model.method", nil, executeAt(time.Hour))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		id := scheduleID(t, creq)
+
+		c2 := s.Connect()
+		c2.Request("cancel", json.RawMessage(`{"scheduleId":"`+id+`"}`)).GetResponse(t).AssertError(t, reserr.ErrNotFound)
+		c.Request("cancel", json.RawMessage(`{"scheduleId":"`+id+`"}`)).GetResponse(t).AssertResult(t, nil)
+	}, cfg)
+}
+
+// Test that a scheduled call remaining in the outbox is sent after a restart
+func TestScheduledCall_PendingInOutbox_SentOnTime(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	o, err := outbox.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := json.RawMessage(`{"cid":"foo"}`)
+	if _, err := o.Schedule("call.test.model.method", payload, time.Now().Add(100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	// Release the requests, as on exit of a previous run
+	o.Close()
+
+	runTest(t, func(s *Session) {
+		s.GetRequest(t).Equals(t, "call.test.model.method", payload).RespondSuccess(nil)
+		s.Connect().AssertNoNATSRequest(t, "test.model")
+		assertOutboxLen(t, dir, 0)
+	}, cfg)
+}
+
+// Test that a scheduled call without access is not scheduled
+func TestScheduledCall_WithoutAccess_ReturnsAccessDenied(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithExecuteAt("call.test.model.method", nil, executeAt(time.Hour))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+		assertOutboxLen(t, dir, 0)
+	}, cfg)
+}
+
+// Test that a scheduled call returns an error when no outbox is configured
+func TestScheduledCall_WithoutOutbox_ReturnsInvalidRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.RequestWithExecuteAt("call.test.model.method", nil, executeAt(time.Hour)).
+			GetResponse(t).
+			AssertErrorCode(t, reserr.CodeInvalidRequest)
+		c.Request("cancel", json.RawMessage(`{"scheduleId":"foo"}`)).
+			GetResponse(t).
+			AssertErrorCode(t, reserr.CodeInvalidRequest)
+	})
+}
+
+// Test that an invalid execute-at time, or one used on a request other than
+// call, returns an invalid request error
+func TestScheduledCall_InvalidExecuteAt_ReturnsInvalidRequest(t *testing.T) {
+	tbl := []struct {
+		Method    string
+		ExecuteAt string
+	}{
+		{"call.test.model.method", "foo"},
+		{"call.test.model.method", "2020-01-01"},
+		{"new.test.collection", executeAt(time.Hour)},
+		{"get.test.model", executeAt(time.Hour)},
+	}
+
+	for _, l := range tbl {
+		dir, cfg := withOutbox(t)
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			c.RequestWithExecuteAt(l.Method, nil, l.ExecuteAt).GetResponse(t).AssertError(t, reserr.ErrInvalidRequest)
+		}, cfg)
+		os.RemoveAll(dir)
+	}
+}
+
+// Test that cancel with invalid params returns an invalid params error
+func TestScheduledCall_CancelWithInvalidParams_ReturnsInvalidParams(t *testing.T) {
+	tbl := []json.RawMessage{
+		nil,
+		json.RawMessage(`{}`),
+		json.RawMessage(`{"scheduleId":""}`),
+		json.RawMessage(`{"scheduleId":42}`),
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			c.Request("cancel", l).GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+		})
+	}
+}
+
+// Test that a scheduled call held by another instance sharing the outbox may
+// be cancelled with the same token, removing it from the outbox
+func TestScheduledCall_CancelHeldByOtherInstance_RemovesScheduledCall(t *testing.T) {
+	tbl := []struct {
+		Token string
+		Err   *reserr.Error
+	}{
+		{`{"user":"foo"}`, nil},
+		{`{"user":"bar"}`, reserr.ErrNotFound},
+		{``, reserr.ErrNotFound},
+	}
+
+	for i, l := range tbl {
+		dir, cfg := withOutbox(t)
+		defer os.RemoveAll(dir)
+
+		o, err := outbox.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := o.Schedule("call.test.model.method", json.RawMessage(`{"token":{"user":"foo"},"cid":"foo"}`), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runNamedTest(t, fmt.Sprintf("test %d", i), func(s *Session) {
+			c := s.Connect()
+			if l.Token != "" {
+				s.ConnEvent(getCID(t, s, c), "token", json.RawMessage(`{"token":`+l.Token+`}`))
+			}
+			resp := c.Request("cancel", json.RawMessage(`{"scheduleId":"`+id+`"}`)).GetResponse(t)
+			if l.Err != nil {
+				resp.AssertError(t, l.Err)
+				assertOutboxLen(t, dir, 1)
+			} else {
+				resp.AssertResult(t, nil)
+				assertOutboxLen(t, dir, 0)
+				if o.Exists(id) {
+					t.Fatal("expected scheduled call to be removed for the other instance")
+				}
+			}
+		}, cfg)
+		o.Close()
+	}
+}
+
+// Test that with leader election, an instance not yet elected leader neither
+// resends the outbox on start nor sends the calls it schedules, leaving them
+// in the outbox for the leader
+func TestScheduledCall_NotLeader_LeftInOutboxForLeader(t *testing.T) {
+	dir, cfg := withOutbox(t)
+	defer os.RemoveAll(dir)
+
+	o, err := outbox.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Add("call.test.model.method", json.RawMessage(`{"cid":"foo"}`)); err != nil {
+		t.Fatal(err)
+	}
+	// Release the requests, as on exit of a previous run
+	o.Close()
+
+	runTest(t, func(s *Session) {
+		// Not elected leader until an announce interval has passed
+		if r := s.GetRequest(t); !strings.HasPrefix(r.Subject, "resgate.announce.") {
+			t.Fatalf("expected announcement, but got %#v", r.Subject)
+		}
+
+		c := s.Connect()
+		creq := c.RequestWithExecuteAt("call.test.model.method", nil, executeAt(50*time.Millisecond))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		scheduleID(t, creq)
+
+		time.Sleep(100 * time.Millisecond)
+		c.AssertNoNATSRequest(t, "test.model")
+		assertOutboxLen(t, dir, 2)
+	}, cfg, func(cfg *server.Config) {
+		cfg.AnnounceInterval = 60000
+		cfg.LeaderElection = true
+	})
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a connection subscribe event subscribes the connection to the
+// resource and sends a subscribe event to the client
+func TestPushSubscription_ConnSubscribeEvent_SendsSubscribeEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "subscribe", json.RawMessage(`{"rid":"test.model"}`))
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		c.GetEvent(t).Equals(t, "test.model.subscribe", json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+
+		// Validate the resource is directly subscribed
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent())
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+	})
+}
+
+// Test that a connection subscribe event on an already subscribed resource
+// sends no subscribe event
+func TestPushSubscription_AlreadySubscribed_SendsNoEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := subscribeToTestModel(t, s, c)
+		s.ConnEvent(cid, "subscribe", json.RawMessage(`{"rid":"test.model"}`))
+		c.AssertNoNATSRequest(t, "test.model")
+		c.AssertNoEvent(t, "test.model")
+
+		// Validate a single direct subscription
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertError(t, reserr.ErrNoSubscription)
+	})
+}
+
+// Test that a connection subscribe event without access sends no subscribe event
+func TestPushSubscription_WithoutAccess_SendsNoEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "subscribe", json.RawMessage(`{"rid":"test.model"}`))
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		c.AssertNoEvent(t, "test.model")
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertError(t, reserr.ErrNoSubscription)
+	})
+}
+
+// Test that a system subscribe event subscribes only connections with a
+// token matching the claims
+func TestPushSubscription_SystemSubscribeEvent_SubscribesMatchingConnections(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c1 := s.Connect()
+		cid1 := getCID(t, s, c1)
+		s.ConnEvent(cid1, "token", json.RawMessage(`{"token":{"user":"foo","role":"admin"}}`))
+		c2 := s.Connect()
+		cid2 := getCID(t, s, c2)
+		s.ConnEvent(cid2, "token", json.RawMessage(`{"token":{"user":"bar","role":"guest"}}`))
+		c3 := s.Connect()
+
+		s.SystemEvent("subscribe", json.RawMessage(`{"rid":"test.model","token":{"role":"admin"}}`))
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.model").
+			AssertPathPayload(t, "cid", cid1).
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		c1.GetEvent(t).Equals(t, "test.model.subscribe", json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+		c2.AssertNoNATSRequest(t, "test.model")
+		c2.AssertNoEvent(t, "test.model")
+		c3.AssertNoEvent(t, "test.model")
+	})
+}
@@ -0,0 +1,44 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Test that a system broadcast event without token claims is sent to all connections
+func TestBroadcast_WithoutTokenClaims_SentToAllConnections(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c1 := s.Connect()
+		cid1 := getCID(t, s, c1)
+		s.ConnEvent(cid1, "token", json.RawMessage(`{"token":{"role":"admin"}}`))
+		c2 := s.Connect()
+
+		s.SystemEvent("broadcast", json.RawMessage(`{"name":"banner","data":{"message":"Maintenance at 22:00"}}`))
+		for _, c := range []*Conn{c1, c2} {
+			c.GetEvent(t).Equals(t, "broadcast", json.RawMessage(`{"name":"banner","data":{"message":"Maintenance at 22:00"}}`))
+		}
+	})
+}
+
+// Test that a system broadcast event with token claims is sent only to
+// connections with a matching token
+func TestBroadcast_WithTokenClaims_SentToMatchingConnections(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c1 := s.Connect()
+		cid1 := getCID(t, s, c1)
+		s.ConnEvent(cid1, "token", json.RawMessage(`{"token":{"user":"foo","role":"admin"}}`))
+		c2 := s.Connect()
+		cid2 := getCID(t, s, c2)
+		s.ConnEvent(cid2, "token", json.RawMessage(`{"token":{"user":"bar","role":"guest"}}`))
+		c3 := s.Connect()
+
+		s.SystemEvent("broadcast", json.RawMessage(`{"name":"banner","token":{"role":"admin"}}`))
+		c1.GetEvent(t).Equals(t, "broadcast", json.RawMessage(`{"name":"banner"}`))
+
+		// Validate no other events are sent by flushing with a second broadcast
+		s.SystemEvent("broadcast", json.RawMessage(`{"name":"flush"}`))
+		c1.GetEvent(t).Equals(t, "broadcast", json.RawMessage(`{"name":"flush"}`))
+		c2.GetEvent(t).Equals(t, "broadcast", json.RawMessage(`{"name":"flush"}`))
+		c3.GetEvent(t).Equals(t, "broadcast", json.RawMessage(`{"name":"flush"}`))
+	})
+}
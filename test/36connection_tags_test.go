@@ -0,0 +1,96 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// authWithTags makes an auth request responded to with the given tags
+func authWithTags(t *testing.T, s *Session, c *Conn, tags string) {
+	creq := c.Request("auth.test.method", nil)
+	s.GetRequest(t).AssertSubject(t, "auth.test.method").RespondRaw([]byte(`{"result":null,"tags":` + tags + `}`))
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+}
+
+// Test that tags set by an auth response are used to select connections
+// for a broadcast event
+func TestConnectionTags_AuthResponseTags_SelectsBroadcast(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c1 := s.Connect()
+		authWithTags(t, s, c1, `{"plan":"free","region":"eu"}`)
+		c2 := s.Connect()
+		authWithTags(t, s, c2, `{"plan":"pro","region":"eu"}`)
+
+		s.SystemEvent("broadcast", json.RawMessage(`{"name":"upgrade","tags":{"plan":"free"}}`))
+		c1.GetEvent(t).Equals(t, "broadcast", json.RawMessage(`{"name":"upgrade"}`))
+
+		s.SystemEvent("broadcast", json.RawMessage(`{"name":"flush","tags":{"region":"eu"}}`))
+		c1.GetEvent(t).Equals(t, "broadcast", json.RawMessage(`{"name":"flush"}`))
+		c2.GetEvent(t).Equals(t, "broadcast", json.RawMessage(`{"name":"flush"}`))
+	})
+}
+
+// Test that a tag with a null value is removed from the connection
+func TestConnectionTags_NullTag_RemovesTag(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		authWithTags(t, s, c, `{"plan":"free","region":"eu"}`)
+		authWithTags(t, s, c, `{"plan":null}`)
+
+		s.SystemEvent("broadcast", json.RawMessage(`{"name":"upgrade","tags":{"plan":"free"}}`))
+		s.SystemEvent("broadcast", json.RawMessage(`{"name":"flush","tags":{"region":"eu"}}`))
+		c.GetEvent(t).Equals(t, "broadcast", json.RawMessage(`{"name":"flush"}`))
+	})
+}
+
+// Test that tags set by an access response are used to select connections
+// for a disconnect event
+func TestConnectionTags_AccessResponseTags_SelectsDisconnect(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c1 := s.Connect()
+		creq := c1.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondRaw([]byte(`{"result":{"get":true},"tags":{"plan":"free"}}`))
+		creq.GetResponse(t)
+		c2 := s.Connect()
+
+		s.SystemEvent("disconnect", json.RawMessage(`{"tags":{"plan":"free"}}`))
+		c1.AssertClosed(t)
+		c2.Request("export", nil).GetResponse(t)
+	})
+}
+
+// Test that a throttle event limits the rate of requests handled for
+// selected connections, and that a rate of 0 removes the limit
+func TestConnectionTags_ThrottleEvent_LimitsRequestRate(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		authWithTags(t, s, c, `{"plan":"free"}`)
+
+		s.SystemEvent("throttle", json.RawMessage(`{"rate":10,"tags":{"plan":"free"}}`))
+		// Flush the throttle event through the connection worker
+		c.Request("export", nil).GetResponse(t)
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			c.Request("export", nil).GetResponse(t)
+		}
+		if d := time.Since(start); d < 150*time.Millisecond {
+			t.Fatalf("expected throttled requests to take at least 150ms, but took %s", d)
+		}
+
+		s.SystemEvent("throttle", json.RawMessage(`{"rate":0,"tags":{"plan":"free"}}`))
+		c.Request("export", nil).GetResponse(t)
+		start = time.Now()
+		for i := 0; i < 3; i++ {
+			c.Request("export", nil).GetResponse(t)
+		}
+		if d := time.Since(start); d > 150*time.Millisecond {
+			t.Fatalf("expected unthrottled requests to take less than 150ms, but took %s", d)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var errMaintenance = &reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Service under maintenance"}
+
+func setMaintenance(t *testing.T, s *Session, body string) {
+	s.AdminRequest("PUT", "/maintenance", []byte(body)).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+}
+
+// Test that maintenance mode rejects calls and new connections, while keeping
+// existing subscriptions alive
+func TestMaintenance_Enabled_RejectsCallsAndConnections(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		setMaintenance(t, s, `{"enabled":true}`)
+
+		c.Request("call.test.model.method", nil).GetResponse(t).AssertError(t, errMaintenance)
+		c.Request("new.test.collection", nil).GetResponse(t).AssertError(t, errMaintenance)
+
+		// Existing subscriptions are kept alive
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent())
+
+		// New connections and HTTP requests are rejected
+		s.HTTPRequest("GET", "/", nil).GetResponse(t).Equals(t, http.StatusServiceUnavailable, errMaintenance)
+		s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t).Equals(t, http.StatusServiceUnavailable, errMaintenance)
+
+		// Disabling maintenance mode allows calls
+		setMaintenance(t, s, `{"enabled":false}`)
+		c2 := s.Connect()
+		creq := c2.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	})
+}
+
+// Test that read-only maintenance mode accepts new connections and reads,
+// but rejects calls
+func TestMaintenance_ReadOnly_AcceptsConnectionsAndRejectsCalls(t *testing.T) {
+	runTest(t, func(s *Session) {
+		setMaintenance(t, s, `{"enabled":true,"readOnly":true}`)
+
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		c.Request("call.test.model.method", nil).GetResponse(t).AssertError(t, errMaintenance)
+		s.HTTPRequest("POST", "/api/test/model/method", nil).GetResponse(t).Equals(t, http.StatusServiceUnavailable, errMaintenance)
+	})
+}
+
+// Test that maintenance mode uses a custom error if provided
+func TestMaintenance_WithCustomError_RejectsCallsWithCustomError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		setMaintenance(t, s, `{"enabled":true,"error":{"code":"system.migration","message":"Migration in progress"}}`)
+		c.Request("call.test.model.method", nil).GetResponse(t).AssertError(t, &reserr.Error{Code: "system.migration", Message: "Migration in progress"})
+	})
+}
+
+// Test getting the maintenance mode state
+func TestMaintenance_Get_ReturnsState(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.AdminRequest("GET", "/maintenance", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"enabled":false,"readOnly":false}`))
+		setMaintenance(t, s, `{"enabled":true,"readOnly":true}`)
+		s.AdminRequest("GET", "/maintenance", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"enabled":true,"readOnly":true,"error":{"code":"system.serviceUnavailable","message":"Service under maintenance"}}`))
+	})
+}
+
+// Test that invalid maintenance requests return an error
+func TestMaintenance_InvalidRequest_ReturnsError(t *testing.T) {
+	tbl := []struct {
+		Method string
+		Body   string
+		Code   int
+	}{
+		{"PUT", `foo`, http.StatusBadRequest},
+		{"PUT", `{"enabled":true,"error":{"code":"system.migration"}}`, http.StatusBadRequest},
+		{"POST", `{"enabled":true}`, http.StatusMethodNotAllowed},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			s.AdminRequest(l.Method, "/maintenance", []byte(l.Body)).GetResponse(t).AssertStatusCode(t, l.Code)
+			s.AdminRequest("GET", "/maintenance", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"enabled":false,"readOnly":false}`))
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var errMethodBlocked = &reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Method temporarily disabled"}
+
+// Test that call requests matching a blocked method pattern set in config
+// are rejected without being sent to the service
+func TestBlockedMethods_Config_RejectsMatchingCalls(t *testing.T) {
+	tbl := []struct {
+		Pattern string
+		Method  string
+		Blocked bool
+	}{
+		{"test.model.method", "method", true},
+		{"test.*.method", "method", true},
+		{"test.>", "method", true},
+		{"test.model.*", "new", true},
+		{"test.model.other", "method", false},
+		{"test.other.method", "method", false},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model."+l.Method, nil)
+			if l.Blocked {
+				creq.GetResponse(t).AssertError(t, errMethodBlocked)
+				return
+			}
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model."+l.Method).RespondSuccess(nil)
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+		}, func(cfg *server.Config) {
+			cfg.BlockedMethods = []string{l.Pattern}
+		})
+	}
+}
+
+// Test that blocked methods can be set and cleared through the admin endpoint
+func TestBlockedMethods_Admin_SetsAndClearsBlockedMethods(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.AdminRequest("GET", "/blocked", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"methods":[]}`))
+		s.AdminRequest("PUT", "/blocked", []byte(`{"methods":["test.*.method"]}`)).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"methods":["test.*.method"]}`))
+
+		c := s.Connect()
+		c.Request("call.test.model.method", nil).GetResponse(t).AssertError(t, errMethodBlocked)
+		s.HTTPRequest("POST", "/api/test/model/method", nil).GetResponse(t).Equals(t, http.StatusServiceUnavailable, errMethodBlocked)
+
+		s.AdminRequest("PUT", "/blocked", []byte(`{"methods":[]}`)).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	})
+}
+
+// Test that an invalid blocked method pattern is rejected by the admin endpoint
+func TestBlockedMethods_AdminInvalidPattern_ReturnsError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.AdminRequest("PUT", "/blocked", []byte(`{"methods":["test..method"]}`)).GetResponse(t).AssertStatusCode(t, http.StatusBadRequest)
+		s.AdminRequest("GET", "/blocked", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"methods":[]}`))
+	})
+}
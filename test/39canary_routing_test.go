@@ -0,0 +1,68 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withCanaryRoute(pattern, prefix string, percent float64) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.CanaryRoutes = []server.CanaryRoute{{Pattern: pattern, Prefix: prefix, Percent: percent}}
+	}
+}
+
+// Test that get and call requests for resources matching a canary route of
+// 100 percent are routed to the canary prefix
+func TestCanaryRouting_FullPercent_RoutesToCanaryPrefix(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		mreqs.GetRequest(t, "get.v2.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		creq.GetResponse(t)
+
+		creq = c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "call.v2.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+
+		s.AdminRequest("GET", "/canary", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"routes":[{"pattern":"test.>","prefix":"v2","percent":100,"primary":{"requests":0,"errors":0},"canary":{"requests":2,"errors":0}}]}`))
+	}, withCanaryRoute("test.>", "v2", 100))
+}
+
+// Test that requests for resources matching a canary route of 0 percent are
+// sent to the primary subject, and counted for the primary variant
+func TestCanaryRouting_ZeroPercent_RoutesToPrimary(t *testing.T) {
+	runTest(t, func(s *Session) {
+		customError := &reserr.Error{Code: "custom.error", Message: "Custom error"}
+		c := s.Connect()
+
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(customError)
+		creq.GetResponse(t).AssertError(t, customError)
+
+		s.AdminRequest("GET", "/canary", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"routes":[{"pattern":"test.>","prefix":"v2","percent":0,"primary":{"requests":1,"errors":1},"canary":{"requests":0,"errors":0}}]}`))
+	}, withCanaryRoute("test.>", "v2", 0))
+}
+
+// Test that requests for resources not matching a canary route are sent to
+// the primary subject without being counted
+func TestCanaryRouting_NonMatchingResource_IsNotRouted(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+
+		s.AdminRequest("GET", "/canary", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"routes":[{"pattern":"other.>","prefix":"v2","percent":100,"primary":{"requests":0,"errors":0},"canary":{"requests":0,"errors":0}}]}`))
+	}, withCanaryRoute("other.>", "v2", 100))
+}
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withShadowRoute(pattern, prefix string, percent float64) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.ShadowRoutes = []server.ShadowRoute{{CanaryRoute: server.CanaryRoute{Pattern: pattern, Prefix: prefix, Percent: percent}}}
+	}
+}
+
+// Test that get and call requests are mirrored to the shadow prefix, and that
+// shadow responses do not affect the client responses
+func TestShadowMirroring_FullPercent_MirrorsRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 3)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "get.shadow.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"baz"}}`))
+		creq.GetResponse(t)
+
+		creq = c.Request("call.test.model.method", json.RawMessage(`{"value":42}`))
+		mreqs = s.GetParallelRequests(t, 2)
+		req := mreqs.GetRequest(t, "call.test.model.method")
+		sreq := mreqs.GetRequest(t, "call.shadow.test.model.method")
+		sreq.AssertPathPayload(t, "params", json.RawMessage(`{"value":42}`))
+		sreq.RespondError(reserr.ErrInternalError)
+		req.RespondSuccess(json.RawMessage(`"ok"`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":"ok"}`))
+
+		s.AdminRequest("GET", "/shadow", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"routes":[{"pattern":"test.>","prefix":"shadow","percent":100,"compare":false,"shadow":{"requests":2,"errors":1,"matches":0,"mismatches":0}}]}`))
+	}, withShadowRoute("test.>", "shadow", 100))
+}
+
+// Test that requests are not mirrored when the sampling percentage is 0
+func TestShadowMirroring_ZeroPercent_DoesNotMirror(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+		c.AssertNoNATSRequest(t, "test.model")
+
+		s.AdminRequest("GET", "/shadow", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"routes":[{"pattern":"test.>","prefix":"shadow","percent":0,"compare":false,"shadow":{"requests":0,"errors":0,"matches":0,"mismatches":0}}]}`))
+	}, withShadowRoute("test.>", "shadow", 0))
+}
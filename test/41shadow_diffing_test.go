@@ -0,0 +1,42 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that shadow responses are compared with primary responses, counting
+// matches and mismatches
+func TestShadowDiffing_CompareEnabled_CountsMatchesAndMismatches(t *testing.T) {
+	tbl := []struct {
+		Primary  string
+		Shadow   string
+		Expected string
+	}{
+		{`{"result":{"foo":"bar","list":[1,2]}}`, `{"result":{"list":[1,2],"foo":"bar"}}`, `{"requests":1,"errors":0,"matches":1,"mismatches":0}`},
+		{`{"result":{"foo":"bar"}}`, `{"result":{"foo":"baz"}}`, `{"requests":1,"errors":0,"matches":0,"mismatches":1}`},
+		{`{"result":{"foo":"bar"}}`, `{"result":{"foo":"bar","extra":true}}`, `{"requests":1,"errors":0,"matches":0,"mismatches":1}`},
+		{`{"result":[1,2]}`, `{"result":[1]}`, `{"requests":1,"errors":0,"matches":0,"mismatches":1}`},
+		{`{"result":null}`, `{"error":{"code":"system.internalError","message":"Internal error"}}`, `{"requests":1,"errors":1,"matches":0,"mismatches":1}`},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "call.shadow.test.model.method").RespondRaw([]byte(l.Shadow))
+			mreqs.GetRequest(t, "call.test.model.method").RespondRaw([]byte(l.Primary))
+			creq.GetResponse(t)
+
+			s.AdminRequest("GET", "/shadow", nil).GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"routes":[{"pattern":"test.>","prefix":"shadow","percent":100,"compare":true,"shadow":`+l.Expected+`}]}`))
+		}, func(cfg *server.Config) {
+			cfg.ShadowRoutes = []server.ShadowRoute{{CanaryRoute: server.CanaryRoute{Pattern: "test.>", Prefix: "shadow", Percent: 100}, Compare: true}}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withFlaggedCanaryRoute(flag server.FeatureFlag) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.CanaryRoutes = []server.CanaryRoute{{Pattern: "test.>", Prefix: "v2", Percent: 0, Flag: "testV2"}}
+		cfg.FeatureFlags = map[string]server.FeatureFlag{"testV2": flag}
+	}
+}
+
+// callWithToken sets the connection token and makes a call request,
+// asserting it is sent on the expected subject.
+func callWithToken(t *testing.T, s *Session, token string, subj string) {
+	c := s.Connect()
+	if token != "" {
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+token+`}`))
+	}
+	creq := c.Request("call.test.model.method", nil)
+	s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+	s.GetRequest(t).AssertSubject(t, subj).RespondSuccess(nil)
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+}
+
+// Test that a feature flag targeting token claims sets the canary percentage
+// for matching connections only
+func TestFeatureFlags_TokenTargetedFlag_SetsCanaryPercent(t *testing.T) {
+	tbl := []struct {
+		Token   string
+		Subject string
+	}{
+		{`{"user":"beta"}`, "call.v2.test.model.method"},
+		{`{"user":"foo"}`, "call.test.model.method"},
+		{``, "call.test.model.method"},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			callWithToken(t, s, l.Token, l.Subject)
+		}, withFlaggedCanaryRoute(server.FeatureFlag{
+			Value: json.RawMessage(`100`),
+			Token: map[string]json.RawMessage{"user": json.RawMessage(`"beta"`)},
+		}))
+	}
+}
+
+// Test that a feature flag rollout percentage controls whether the flag
+// applies
+func TestFeatureFlags_RolloutPercent_AppliesFlag(t *testing.T) {
+	tbl := []struct {
+		Percent float64
+		Subject string
+	}{
+		{100, "call.v2.test.model.method"},
+		{0, "call.test.model.method"},
+	}
+
+	for _, l := range tbl {
+		percent := l.Percent
+		runTest(t, func(s *Session) {
+			callWithToken(t, s, "", l.Subject)
+		}, withFlaggedCanaryRoute(server.FeatureFlag{
+			Value:   json.RawMessage(`100`),
+			Percent: &percent,
+		}))
+	}
+}
+
+// Test that a boolean feature flag can enable WebSocket compression for
+// connections without failing the connection
+func TestFeatureFlags_WSCompressionFlag_ConnectsSuccessfully(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.Request("export", nil).GetResponse(t)
+	}, func(cfg *server.Config) {
+		cfg.FeatureFlags = map[string]server.FeatureFlag{"wsCompression": {Value: json.RawMessage(`true`)}}
+	})
+}
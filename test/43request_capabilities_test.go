@@ -0,0 +1,71 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withRequestCapabilities(cfg *server.Config) {
+	cfg.RequestCapabilities = true
+}
+
+// Test that the connection capabilities are included in access and call
+// requests when requestCapabilities is enabled
+func TestRequestCapabilities_Enabled_IncludesCapabilities(t *testing.T) {
+	tbl := []struct {
+		Version      string
+		Capabilities string
+	}{
+		{"", `{"protocol":"1.1.1","resourceResponse":false}`},
+		{"1.1.1", `{"protocol":"1.1.1","resourceResponse":false}`},
+		{"1.2.0", `{"protocol":"1.2.0","resourceResponse":true}`},
+		{"1.999.999", `{"protocol":"1.999.999","resourceResponse":true}`},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.ConnectWithoutVersion()
+			if l.Version != "" {
+				c.Request("version", json.RawMessage(`{"protocol":"`+l.Version+`"}`)).GetResponse(t)
+			}
+
+			creq := c.Request("call.test.model.method", nil)
+			req := s.GetRequest(t).AssertSubject(t, "access.test.model")
+			req.AssertPathPayload(t, "capabilities", json.RawMessage(l.Capabilities))
+			req.RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			req = s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+			req.AssertPathPayload(t, "capabilities", json.RawMessage(l.Capabilities))
+			req.RespondSuccess(nil)
+			creq.GetResponse(t)
+		}, withRequestCapabilities)
+	}
+}
+
+// Test that the capabilities of HTTP requests are flagged as HTTP
+func TestRequestCapabilities_HTTPRequest_IncludesHTTPFlag(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		req := s.GetRequest(t).AssertSubject(t, "access.test.model")
+		req.AssertPathPayload(t, "capabilities", json.RawMessage(`{"protocol":"1.999.999","resourceResponse":true,"http":true}`))
+		req.RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		hreq.GetResponse(t)
+	}, withRequestCapabilities)
+}
+
+// Test that capabilities are not included in requests by default
+func TestRequestCapabilities_Disabled_OmitsCapabilities(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		req := s.GetRequest(t).AssertSubject(t, "access.test.model")
+		if _, ok := req.Payload.(map[string]interface{})["capabilities"]; ok {
+			t.Fatalf("expected access request payload to have no capabilities, but got:\n%s", req.RawPayload)
+		}
+		req.RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t)
+	})
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withStrict(cfg *server.Config) {
+	cfg.Strict = true
+}
+
+func strictError(msg string) *reserr.Error {
+	return &reserr.Error{Code: reserr.CodeInternalError, Message: "Internal error: strict mode: " + msg}
+}
+
+// Test that call responses deviating from the protocol are rejected with a
+// detailed error in strict mode
+func TestStrictMode_InvalidCallResponse_ReturnsDetailedError(t *testing.T) {
+	tbl := []struct {
+		Response string
+		Expected *reserr.Error
+	}{
+		{`{"result":{"foo":1},"bar":true}`, strictError(`json: unknown field "bar" in {"result":{"foo":1},"bar":true}`)},
+		{`{"result":null,"error":{"code":"custom.error","message":"Custom error"}}`, strictError("response must not contain both result and error")},
+		{`{"error":{"code":"custom.error","message":"Custom error","foo":true}}`, strictError(`json: unknown field "foo" in {"error":{"code":"custom.error","message":"Custom error","foo":true}}`)},
+		{`{"result":null,"resource":{"rid":"test.model"}}`, strictError("response must not contain both result and resource")},
+		{`{"resource":{"rid":"test..model"}}`, strictError(`invalid resource ID "test..model" in resource response`)},
+		{`{"result":null} {}`, strictError(`unexpected data after JSON value in {"result":null} {}`)},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondRaw([]byte(l.Response))
+			creq.GetResponse(t).AssertError(t, l.Expected)
+		}, withStrict)
+	}
+}
+
+// Test that unknown fields in service responses are tolerated when strict
+// mode is disabled
+func TestStrictMode_Disabled_ToleratesUnknownFields(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondRaw([]byte(`{"result":{"call":"*","foo":1}}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondRaw([]byte(`{"result":{"foo":1},"bar":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":1}}`))
+	})
+}
+
+// Test that invalid values in get responses are rejected with a detailed
+// error in strict mode
+func TestStrictMode_InvalidGetResponse_ReturnsDetailedError(t *testing.T) {
+	tbl := []struct {
+		Response string
+		Expected *reserr.Error
+	}{
+		{`{"result":{"model":{"foo":"bar"},"meta":{}}}`, strictError(`json: unknown field "meta" in {"result":{"model":{"foo":"bar"},"meta":{}}}`)},
+		{`{"result":{"model":{"ref":{"rid":"test.model","soft":true}}}}`, strictError(`json: unknown field "soft" in {"rid":"test.model","soft":true}`)},
+		{`{"result":{"model":{"ref":{"rid":"test.*"}}}}`, strictError(`invalid value {"rid":"test.*"}: invalid resource ID`)},
+		{`{"result":{"collection":[[1,2]]}}`, strictError(`invalid value [1,2]: arrays are not allowed`)},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("get.test.model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "get.test.model").RespondRaw([]byte(l.Response))
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			creq.GetResponse(t).AssertError(t, l.Expected)
+		}, withStrict)
+	}
+}
+
+// Test that a legacy new call result is rejected in strict mode
+func TestStrictMode_LegacyNewResult_ReturnsDetailedError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("new.test.collection", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.collection.new").RespondSuccess(json.RawMessage(`{"rid":"test.model"}`))
+		creq.GetResponse(t).AssertError(t, strictError(`legacy new call result {"rid":"test.model"}; use a resource response instead`))
+		s.AssertErrorsLogged(t, 1)
+	}, withStrict)
+}
+
+// Test that a legacy model change event is rejected and logged in strict mode
+func TestStrictMode_LegacyChangeEvent_IsRejected(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"string":"bar"}`))
+		c.AssertNoEvent(t, "test.model")
+		s.AssertErrorsLogged(t, 2)
+	}, withStrict)
+}
@@ -0,0 +1,132 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withHideErrorDetails(cfg *server.Config) {
+	cfg.HideErrorDetails = true
+}
+
+var errInternalWithDetails = &reserr.Error{Code: reserr.CodeInternalError, Message: "Internal error: database failure", Data: map[string]interface{}{"host": "db1"}}
+
+// assertCorrelatedError asserts that the error has the expected code and
+// message, and a correlation ID as data.
+func assertCorrelatedError(t *testing.T, rerr *reserr.Error, expected *reserr.Error) {
+	if rerr == nil {
+		t.Fatalf("expected error %#v, but got none", expected.Code)
+	}
+	if rerr.Code != expected.Code || rerr.Message != expected.Message {
+		t.Fatalf("expected error to be %#v (%#v), but got %#v (%#v)", expected.Code, expected.Message, rerr.Code, rerr.Message)
+	}
+	data, ok := rerr.Data.(map[string]interface{})
+	if !ok || len(data) != 1 {
+		t.Fatalf("expected error data to contain only a correlation ID, but got %#v", rerr.Data)
+	}
+	if id, ok := data["correlationId"].(string); !ok || id == "" {
+		t.Fatalf("expected error data to contain a correlation ID, but got %#v", rerr.Data)
+	}
+}
+
+// Test that internal errors and timeouts are replaced with generic errors
+// with a correlation ID, and logged, when hideErrorDetails is set
+func TestHideErrorDetails_InternalErrorOrTimeout_ReturnsGenericError(t *testing.T) {
+	tbl := []struct {
+		Response interface{}
+		Expected *reserr.Error
+	}{
+		{errInternalWithDetails, reserr.ErrInternalError},
+		{[]byte(`{"invalid":true}`), reserr.ErrInternalError},
+		{requestTimeout, reserr.ErrTimeout},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			req := s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+			switch v := l.Response.(type) {
+			case *reserr.Error:
+				req.RespondError(v)
+			case []byte:
+				req.RespondRaw(v)
+			default:
+				req.Timeout()
+			}
+			assertCorrelatedError(t, creq.GetResponse(t).Error, l.Expected)
+			s.AssertErrorsLogged(t, 1)
+		}, withHideErrorDetails)
+	}
+}
+
+// Test that other errors are passed through to the client when
+// hideErrorDetails is set
+func TestHideErrorDetails_CustomError_ReturnsError(t *testing.T) {
+	customError := &reserr.Error{Code: "custom.error", Message: "Custom error", Data: map[string]interface{}{"foo": "bar"}}
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(customError)
+		creq.GetResponse(t).AssertError(t, customError)
+		s.AssertNoErrorsLogged(t)
+	}, withHideErrorDetails)
+}
+
+// Test that the internal error of a referenced resource is replaced with a
+// generic error when hideErrorDetails is set
+func TestHideErrorDetails_ReferencedResourceError_ReturnsGenericError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model.parent", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(errInternalWithDetails)
+
+		var result struct {
+			Errors map[string]*reserr.Error `json:"errors"`
+		}
+		resp := creq.GetResponse(t)
+		data, _ := json.Marshal(resp.Result)
+		json.Unmarshal(data, &result)
+		assertCorrelatedError(t, result.Errors["test.model"], reserr.ErrInternalError)
+		s.AssertErrorsLogged(t, 1)
+	}, withHideErrorDetails)
+}
+
+// Test that internal errors in HTTP responses are replaced with a generic
+// error when hideErrorDetails is set
+func TestHideErrorDetails_HTTPGet_ReturnsGenericError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondError(errInternalWithDetails)
+
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusInternalServerError)
+		var rerr reserr.Error
+		if err := json.Unmarshal(hresp.Body.Bytes(), &rerr); err != nil {
+			t.Fatalf("error decoding response body: %s", err)
+		}
+		assertCorrelatedError(t, &rerr, reserr.ErrInternalError)
+		s.AssertErrorsLogged(t, 1)
+	}, withHideErrorDetails)
+}
+
+// Test that error details are passed through to the client by default
+func TestHideErrorDetails_Disabled_ReturnsErrorDetails(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(errInternalWithDetails)
+		creq.GetResponse(t).AssertError(t, errInternalWithDetails)
+	})
+}
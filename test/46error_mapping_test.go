@@ -0,0 +1,95 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withErrorMappings(cfg *server.Config) {
+	cfg.ErrorMappings = map[string]server.ErrorMapping{
+		"orders.outOfStock": {
+			Code:    "shop.outOfStock",
+			Message: "Out of stock",
+			Messages: map[string]string{
+				"sv":    "Slut i lager",
+				"de-AT": "Nicht auf Lager",
+			},
+		},
+		"orders.missing": {
+			Code: reserr.CodeNotFound,
+		},
+	}
+}
+
+var errOutOfStock = &reserr.Error{Code: "orders.outOfStock", Message: "Item 42 out of stock", Data: map[string]interface{}{"item": float64(42)}}
+
+// callWithError makes a call request on conn c, responded to with the
+// service error rerr, and returns the client response.
+func callWithError(t *testing.T, s *Session, c *Conn, rerr *reserr.Error) *ClientResponse {
+	creq := c.Request("call.test.model.method", nil)
+	s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+	s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(rerr)
+	return creq.GetResponse(t)
+}
+
+// Test that service errors are mapped over WebSocket, with messages
+// localized by the Accept-Language header of the connection
+func TestErrorMappings_WebSocket_MapsError(t *testing.T) {
+	tbl := []struct {
+		AcceptLanguage string
+		Expected       string
+	}{
+		{"", "Out of stock"},
+		{"fr", "Out of stock"},
+		{"sv", "Slut i lager"},
+		{"sv-SE", "Slut i lager"},
+		{"fr, sv;q=0.5", "Slut i lager"},
+		{"sv;q=0.5, de-AT", "Nicht auf Lager"},
+		{"DE-at", "Nicht auf Lager"},
+		{"de", "Out of stock"},
+		{"sv;q=0", "Out of stock"},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			h := http.Header{}
+			if l.AcceptLanguage != "" {
+				h.Set("Accept-Language", l.AcceptLanguage)
+			}
+			c := s.ConnectWithHeader(h)
+			callWithError(t, s, c, errOutOfStock).AssertError(t, &reserr.Error{Code: "shop.outOfStock", Message: l.Expected, Data: errOutOfStock.Data})
+		}, withErrorMappings)
+	}
+}
+
+// Test that errors without a mapping are passed through unchanged, and that
+// a mapping without a message keeps the service message
+func TestErrorMappings_PartialOrNoMapping_KeepsError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		callWithError(t, s, c, &reserr.Error{Code: "orders.missing", Message: "Order missing"}).AssertError(t, &reserr.Error{Code: reserr.CodeNotFound, Message: "Order missing"})
+		callWithError(t, s, c, &reserr.Error{Code: "orders.other", Message: "Other"}).AssertError(t, &reserr.Error{Code: "orders.other", Message: "Other"})
+	}, withErrorMappings)
+}
+
+// Test that service errors are mapped over HTTP, with the status code
+// based on the mapped error code
+func TestErrorMappings_HTTP_MapsError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(r *http.Request) {
+			r.Header.Set("Accept-Language", "sv-SE, en;q=0.8")
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(errOutOfStock)
+		hreq.GetResponse(t).Equals(t, http.StatusBadRequest, &reserr.Error{Code: "shop.outOfStock", Message: "Slut i lager", Data: errOutOfStock.Data})
+
+		hreq = s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(&reserr.Error{Code: "orders.missing", Message: "Order missing"})
+		hreq.GetResponse(t).Equals(t, http.StatusNotFound, &reserr.Error{Code: reserr.CodeNotFound, Message: "Order missing"})
+	}, withErrorMappings)
+}
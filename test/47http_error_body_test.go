@@ -0,0 +1,90 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withHTTPErrorBodies(cfg *server.Config) {
+	cfg.HTTPErrorBodies = map[string]server.HTTPErrorBody{
+		"system.notFound": {
+			JSON: `{"error":{{json .Message}},"status":{{.Status}},"path":{{json .Path}},"support":"https://example.com/support"}`,
+			HTML: `<h1>{{.Message}}</h1><p>{{.Path}}</p>`,
+		},
+		"system.timeout": {
+			JSON: `{"error":{{json .Message}},"hasCorrelationId":{{if .CorrelationID}}true{{else}}false{{end}}}`,
+		},
+		"system.internalError": {
+			JSON: `{"error":{{json .Message}},"correlationId":{{json .CorrelationID}}}`,
+		},
+	}
+}
+
+func acceptHTML(r *http.Request) {
+	r.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9")
+}
+
+// Test that a not found error on the HTTP API uses the JSON error body template
+func TestHTTPErrorBodies_NotFound_UsesJSONTemplate(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/api/test..model", nil).GetResponse(t).
+			Equals(t, http.StatusNotFound, json.RawMessage(`{"error":"Not found","status":404,"path":"/api/test..model","support":"https://example.com/support"}`)).
+			AssertHeaders(t, map[string]string{"Content-Type": "application/json; charset=utf-8"})
+	}, withHTTPErrorBodies)
+}
+
+// Test that a not found error on the HTTP API uses the HTML error body
+// template for requests accepting text/html
+func TestHTTPErrorBodies_NotFoundAcceptingHTML_UsesHTMLTemplate(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/api/test/<model>/", nil, acceptHTML).GetResponse(t).
+			AssertStatusCode(t, http.StatusNotFound).
+			AssertHeaders(t, map[string]string{"Content-Type": "text/html; charset=utf-8"}).
+			AssertBody(t, []byte(`<h1>Not found</h1><p>/api/test/&lt;model&gt;/</p>`))
+	}, withHTTPErrorBodies)
+}
+
+// Test that a timeout on the HTTP API uses the error body template
+func TestHTTPErrorBodies_Timeout_UsesTemplate(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").Timeout()
+		hreq.GetResponse(t).Equals(t, http.StatusNotFound, json.RawMessage(`{"error":"Request timeout","hasCorrelationId":true}`))
+	}, withHTTPErrorBodies)
+}
+
+// Test that the error body template uses the correlation ID of a hidden
+// error
+func TestHTTPErrorBodies_HiddenErrorDetails_UsesCorrelationID(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(errInternalWithDetails)
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusInternalServerError)
+
+		var body struct {
+			Error         string `json:"error"`
+			CorrelationID string `json:"correlationId"`
+		}
+		if err := json.Unmarshal(hresp.Body.Bytes(), &body); err != nil {
+			t.Fatalf("error decoding response body: %s", err)
+		}
+		if body.Error != "Internal error" || body.CorrelationID == "" {
+			t.Fatalf("expected generic error with correlation ID, but got %s", hresp.Body.String())
+		}
+		s.AssertErrorsLogged(t, 1)
+	}, withHTTPErrorBodies, withHideErrorDetails)
+}
+
+// Test that errors without an error body template use the default body
+func TestHTTPErrorBodies_NoTemplate_UsesDefaultBody(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("DELETE", "/api/test/model", nil, acceptHTML).GetResponse(t).
+			Equals(t, http.StatusMethodNotAllowed, json.RawMessage(`{"code":"system.methodNotAllowed","message":"Method not allowed"}`))
+	}, withHTTPErrorBodies)
+}
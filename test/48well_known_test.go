@@ -0,0 +1,49 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that the well-known endpoint describes the gateway capabilities
+func TestWellKnown_Get_ReturnsCapabilities(t *testing.T) {
+	putMethod := "set"
+	tbl := []struct {
+		CfgFn    func(cfg *server.Config)
+		Expected string
+	}{
+		{nil, `{"version":"` + server.Version + `","protocol":"` + server.ProtocolVersion + `","wsPath":"/","apiPath":"/api/","apiEncoding":"json","apiEncodings":["json","jsonflat","xml"],"apiMethods":["GET","HEAD","OPTIONS","POST"],"transports":["websocket","http"],"wsCompression":false,"features":[],"limits":{"subscriptions":256,"idempotencyKeyLength":256}}`},
+		{func(cfg *server.Config) {
+			cfg.PUTMethod = &putMethod
+			cfg.APIEncoding = "jsonFlat"
+			cfg.WSCompression = true
+			cfg.IdempotencyWindow = 60000
+			cfg.RequestCapabilities = true
+		}, `{"version":"` + server.Version + `","protocol":"` + server.ProtocolVersion + `","wsPath":"/","apiPath":"/api/","apiEncoding":"jsonflat","apiEncodings":["json","jsonflat","xml"],"apiMethods":["GET","HEAD","OPTIONS","POST","PUT"],"transports":["websocket","http"],"wsCompression":true,"features":["idempotencyKeys","requestCapabilities"],"limits":{"subscriptions":256,"idempotencyKeyLength":256,"idempotencyWindow":60000}}`},
+	}
+
+	for _, l := range tbl {
+		cfgFn := l.CfgFn
+		if cfgFn == nil {
+			cfgFn = func(cfg *server.Config) {}
+		}
+		runTest(t, func(s *Session) {
+			s.HTTPRequest("GET", "/.well-known/resgate", nil).GetResponse(t).
+				Equals(t, http.StatusOK, json.RawMessage(l.Expected)).
+				AssertHeaders(t, map[string]string{"Content-Type": "application/json; charset=utf-8"})
+		}, cfgFn)
+	}
+}
+
+// Test that the well-known endpoint only allows GET, HEAD, and OPTIONS
+func TestWellKnown_InvalidMethod_ReturnsMethodNotAllowed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("POST", "/.well-known/resgate", nil).GetResponse(t).AssertStatusCode(t, http.StatusMethodNotAllowed)
+		s.HTTPRequest("OPTIONS", "/.well-known/resgate", nil).GetResponse(t).
+			AssertStatusCode(t, http.StatusOK).
+			AssertHeaders(t, map[string]string{"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS"})
+	})
+}
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withAnnounceInterval(ms int) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.AnnounceInterval = ms
+	}
+}
+
+func withDiscoverPeers(cfg *server.Config) {
+	cfg.DiscoverPeers = true
+}
+
+type peersResponse struct {
+	Self struct {
+		ID          string `json:"id"`
+		Version     string `json:"version"`
+		Connections int    `json:"connections"`
+	} `json:"self"`
+	Peers []struct {
+		ID          string `json:"id"`
+		Addr        string `json:"addr"`
+		Connections int    `json:"connections"`
+		LastSeen    string `json:"lastSeen"`
+	} `json:"peers"`
+}
+
+// getPeers requests the admin peers endpoint and returns the decoded body.
+func getPeers(t *testing.T, s *Session) peersResponse {
+	hresp := s.AdminRequest("GET", "/peers", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	var pr peersResponse
+	if err := json.Unmarshal(hresp.Body.Bytes(), &pr); err != nil {
+		t.Fatalf("error decoding peers response: %s", err)
+	}
+	return pr
+}
+
+// Test that an announcement is published on start
+func TestDiscovery_AnnounceInterval_PublishesAnnouncementOnStart(t *testing.T) {
+	runTest(t, func(s *Session) {
+		r := s.GetRequest(t)
+		if !strings.HasPrefix(r.Subject, "resgate.announce.") {
+			t.Fatalf("expected announcement subject, but got %#v", r.Subject)
+		}
+		r.AssertPathPayload(t, "id", r.Subject[len("resgate.announce."):])
+		r.AssertPathPayload(t, "version", server.Version)
+		r.AssertPathPayload(t, "protocol", server.ProtocolVersion)
+		r.AssertPathPayload(t, "connections", float64(0))
+		r.AssertPathPayload(t, "interval", float64(60000))
+	}, withAnnounceInterval(60000))
+}
+
+// Test that announcements are published on interval with the connection
+// count
+func TestDiscovery_AnnounceInterval_PublishesConnectionCount(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.GetRequest(t).AssertPathPayload(t, "connections", float64(0))
+		s.Connect()
+		for i := 0; ; i++ {
+			r := s.GetRequest(t)
+			if r.PathPayload(t, "connections") == float64(1) {
+				break
+			}
+			if i == 10 {
+				t.Fatalf("expected announcement with connection count 1, but got %s", r.RawPayload)
+			}
+		}
+	}, withAnnounceInterval(10))
+}
+
+// Test that no announcements are published by default
+func TestDiscovery_NoAnnounceInterval_PublishesNoAnnouncement(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+	})
+}
+
+// Test that announcements from peers are listed by the admin endpoint, and
+// that a stopping announcement removes the peer
+func TestDiscovery_DiscoverPeers_ListsPeers(t *testing.T) {
+	runTest(t, func(s *Session) {
+		pr := getPeers(t, s)
+		if pr.Self.ID == "" || pr.Self.Version != server.Version || len(pr.Peers) != 0 {
+			t.Fatalf("expected self without peers, but got %+v", pr)
+		}
+
+		s.event("resgate.announce", "peer2", json.RawMessage(`{"id":"peer2","version":"1.5.0","addr":"http://10.0.0.2:8080","connections":3,"interval":5000}`))
+		s.event("resgate.announce", "peer1", json.RawMessage(`{"id":"peer1","version":"1.5.0","addr":"http://10.0.0.1:8080","connections":5,"interval":5000}`))
+		s.event("resgate.announce", pr.Self.ID, json.RawMessage(`{"id":"`+pr.Self.ID+`","interval":5000}`))
+		pr = getPeers(t, s)
+		if len(pr.Peers) != 2 || pr.Peers[0].ID != "peer1" || pr.Peers[0].Connections != 5 || pr.Peers[1].ID != "peer2" || pr.Peers[1].Addr != "http://10.0.0.2:8080" || pr.Peers[0].LastSeen == "" {
+			t.Fatalf("expected peer1 and peer2, but got %+v", pr.Peers)
+		}
+
+		s.event("resgate.announce", "peer1", json.RawMessage(`{"id":"peer1","interval":5000,"stopping":true}`))
+		pr = getPeers(t, s)
+		if len(pr.Peers) != 1 || pr.Peers[0].ID != "peer2" {
+			t.Fatalf("expected peer2 only, but got %+v", pr.Peers)
+		}
+	}, withDiscoverPeers)
+}
+
+// Test that the admin peers endpoint only allows GET
+func TestDiscovery_AdminPeersInvalidMethod_ReturnsMethodNotAllowed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.AdminRequest("PUT", "/peers", nil).GetResponse(t).AssertStatusCode(t, http.StatusMethodNotAllowed)
+	})
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withLeaderElection(cfg *server.Config) {
+	cfg.AnnounceInterval = 10
+	cfg.LeaderElection = true
+}
+
+// awaitLeaderAnnouncement gets announcements until one with the leader
+// flag set to leader is received.
+func awaitLeaderAnnouncement(t *testing.T, s *Session, leader bool) {
+	for i := 0; ; i++ {
+		r := s.GetRequest(t)
+		var a struct {
+			Leader bool `json:"leader"`
+		}
+		if err := json.Unmarshal(r.RawPayload, &a); err != nil {
+			t.Fatalf("error decoding announcement: %s", err)
+		}
+		if a.Leader == leader {
+			return
+		}
+		if i == 20 {
+			t.Fatalf("expected announcement with leader %v, but got %s", leader, r.RawPayload)
+		}
+	}
+}
+
+// getLeader requests the admin peers endpoint and returns the leader ID.
+func getLeader(t *testing.T, s *Session) string {
+	hresp := s.AdminRequest("GET", "/peers", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	var pr struct {
+		Leader string `json:"leader"`
+	}
+	if err := json.Unmarshal(hresp.Body.Bytes(), &pr); err != nil {
+		t.Fatalf("error decoding peers response: %s", err)
+	}
+	return pr.Leader
+}
+
+// Test that a single instance is elected leader
+func TestLeaderElection_SingleInstance_IsElectedLeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		awaitLeaderAnnouncement(t, s, true)
+		if !s.s.IsLeader() {
+			t.Fatal("expected instance to be leader")
+		}
+	}, withLeaderElection)
+}
+
+// Test that leadership is handed to an older peer, and fails over back when
+// the peer stops
+func TestLeaderElection_OlderPeer_FailsOver(t *testing.T) {
+	runTest(t, func(s *Session) {
+		awaitLeaderAnnouncement(t, s, true)
+
+		s.event("resgate.announce", "0000", json.RawMessage(`{"id":"0000","version":"1.5.0","interval":5000}`))
+		awaitLeaderAnnouncement(t, s, false)
+		if leader := getLeader(t, s); leader != "0000" {
+			t.Fatalf("expected leader 0000, but got %#v", leader)
+		}
+
+		s.event("resgate.announce", "0000", json.RawMessage(`{"id":"0000","interval":5000,"stopping":true}`))
+		awaitLeaderAnnouncement(t, s, true)
+	}, withLeaderElection)
+}
+
+// Test that a newer peer does not take over leadership
+func TestLeaderElection_NewerPeer_KeepsLeadership(t *testing.T) {
+	runTest(t, func(s *Session) {
+		awaitLeaderAnnouncement(t, s, true)
+		s.event("resgate.announce", "zzzz", json.RawMessage(`{"id":"zzzz","version":"1.5.0","interval":5000}`))
+		awaitLeaderAnnouncement(t, s, true)
+		if !s.s.IsLeader() {
+			t.Fatal("expected instance to remain leader")
+		}
+	}, withLeaderElection)
+}
+
+// Test that an instance is never leader when leader election is disabled
+func TestLeaderElection_Disabled_IsNotLeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		if s.s.IsLeader() {
+			t.Fatal("expected instance not to be leader")
+		}
+		if leader := getLeader(t, s); leader != "" {
+			t.Fatalf("expected no leader, but got %#v", leader)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+const sharedIdempotencyKey = "resgate:idempotency:test.model?.method::key1"
+
+// sharedHash returns the hash of a token or of call parameters, as used for
+// shared idempotency keys and responses.
+func sharedHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+// nullParams is the hash stored for a call without parameters.
+var nullParams = sharedHash("null")
+
+// Test that a call response is stored in Redis for other instances
+func TestSharedIdempotency_Call_StoresResponse(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	defer rs.Close()
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+
+		for i := 0; ; i++ {
+			v, _ := rs.Get(sharedIdempotencyKey)
+			if v == `{"done":true,"params":"`+nullParams+`","result":{"foo":"bar"}}` {
+				break
+			}
+			if i == 50 {
+				t.Fatalf("expected stored response, but got %#v", v)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}, withIdempotencyWindow, rs.Config)
+}
+
+// Test that a response stored by another instance is returned without a
+// call request sent to the service
+func TestSharedIdempotency_StoredResponse_ReturnsStoredResponse(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	defer rs.Close()
+	runTest(t, func(s *Session) {
+		rs.Set(sharedIdempotencyKey, `{"done":true,"params":"`+nullParams+`","result":{"foo":"bar"}}`)
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+		c.AssertNoNATSRequest(t, "test.model")
+	}, withIdempotencyWindow, rs.Config)
+}
+
+// Test that a response stored for a request with different parameters
+// returns an invalid params error without a call request sent to the service
+func TestSharedIdempotency_StoredResponseWithDifferentParams_ReturnsInvalidParams(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	defer rs.Close()
+	runTest(t, func(s *Session) {
+		rs.Set(sharedIdempotencyKey, `{"done":true,"params":"`+sharedHash(`{"value":42}`)+`","result":{"foo":"bar"}}`)
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInvalidParams)
+		c.AssertNoNATSRequest(t, "test.model")
+	}, withIdempotencyWindow, rs.Config)
+}
+
+// Test that a response is stored under a key scoped to the token
+func TestSharedIdempotency_CallWithToken_StoresResponseScopedToToken(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	defer rs.Close()
+	runTest(t, func(s *Session) {
+		rs.Set(sharedIdempotencyKey, `{"done":true,"params":"`+nullParams+`","result":"anonymous"}`)
+		c := s.Connect()
+		s.ConnEvent(getCID(t, s, c), "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+
+		key := "resgate:idempotency:test.model?.method:" + sharedHash(`{"user":"foo"}`) + ":key1"
+		for i := 0; ; i++ {
+			if _, ok := rs.Get(key); ok {
+				break
+			}
+			if i == 50 {
+				t.Fatal("expected response stored under token scoped key")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}, withIdempotencyWindow, rs.Config)
+}
+
+// Test that a stored error response is returned
+func TestSharedIdempotency_StoredError_ReturnsError(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	defer rs.Close()
+	runTest(t, func(s *Session) {
+		rs.Set(sharedIdempotencyKey, `{"done":true,"params":"`+nullParams+`","error":{"code":"test.custom","message":"Custom"}}`)
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		creq.GetResponse(t).AssertError(t, &reserr.Error{Code: "test.custom", Message: "Custom"})
+	}, withIdempotencyWindow, rs.Config)
+}
+
+// Test that a request in progress on another instance is awaited
+func TestSharedIdempotency_RequestInProgress_AwaitsResponse(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	defer rs.Close()
+	runTest(t, func(s *Session) {
+		rs.Set(sharedIdempotencyKey, `{"done":false,"params":"`+nullParams+`"}`)
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		c.AssertNoNATSRequest(t, "test.model")
+		rs.Set(sharedIdempotencyKey, `{"done":true,"params":"`+nullParams+`","result":{"foo":"bar"}}`)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+	}, withIdempotencyWindow, rs.Config)
+}
+
+// Test that a timeout removes the request in progress marker
+func TestSharedIdempotency_Timeout_RemovesKey(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	defer rs.Close()
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").Timeout()
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeTimeout)
+
+		for i := 0; ; i++ {
+			if _, ok := rs.Get(sharedIdempotencyKey); !ok {
+				break
+			}
+			if i == 50 {
+				t.Fatal("expected idempotency key to be removed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}, withIdempotencyWindow, rs.Config)
+}
+
+// Test that calls are sent to the service when Redis is unavailable
+func TestSharedIdempotency_RedisUnavailable_SendsRequest(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	rs.Close()
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIdempotencyKey("call.test.model.method", nil, "key1")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+		s.AssertErrorsLogged(t, 1)
+	}, withIdempotencyWindow, rs.Config)
+}
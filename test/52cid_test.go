@@ -0,0 +1,24 @@
+package test
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test that connection IDs start with the instance ID, followed by a
+// monotonically increasing counter
+func TestCID_NewConnections_EmbedsInstanceIDAndCounter(t *testing.T) {
+	runTest(t, func(s *Session) {
+		id := getPeers(t, s).Self.ID
+		cid1 := getCID(t, s, s.Connect())
+		cid2 := getCID(t, s, s.Connect())
+		for _, cid := range []string{cid1, cid2} {
+			if !strings.HasPrefix(cid, id+"-") {
+				t.Fatalf("expected cid %#v to start with instance ID %#v", cid, id)
+			}
+		}
+		if cid1 != id+"-1" || cid2 != id+"-2" {
+			t.Fatalf("expected cids with increasing counter, but got %#v and %#v", cid1, cid2)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// assertAuditRecord asserts that data is an audit record for the connection
+// with the given cid, token subject, and subscription count.
+func assertAuditRecord(t *testing.T, data []byte, cid, subject string, subs int) {
+	var rec server.AuditRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("error decoding audit record: %s", err)
+	}
+	if rec.CID != cid || rec.Subject != subject || rec.Subscriptions != subs {
+		t.Fatalf("expected audit record for cid %#v with subject %#v and %d subscription(s), but got %s", cid, subject, subs, data)
+	}
+	if rec.BytesIn == 0 || rec.BytesOut == 0 || rec.Duration < 0 {
+		t.Fatalf("expected audit record with bytes and duration, but got %s", data)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, rec.Connected); err != nil {
+		t.Fatalf("expected audit record with connected time, but got %s", data)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, rec.Disconnected); err != nil {
+		t.Fatalf("expected audit record with disconnected time, but got %s", data)
+	}
+}
+
+// connectWithToken connects a client, sets the token through a conn token
+// event, and subscribes to test.model. Returns the connection and cid.
+func connectWithToken(t *testing.T, s *Session, token string) (*Conn, string) {
+	c := s.Connect()
+	cid := getCID(t, s, c)
+	s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+token+`}`))
+	subscribeToTestModel(t, s, c)
+	return c, cid
+}
+
+// Test that an audit record is appended to the audit file on disconnect
+func TestAudit_File_WritesRecordOnDisconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resgate-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	runTest(t, func(s *Session) {
+		c, cid := connectWithToken(t, s, `{"sub":"jane","role":"admin"}`)
+		c.Disconnect()
+		c.AssertClosed(t)
+
+		for i := 0; ; i++ {
+			data, _ := ioutil.ReadFile(path)
+			if len(data) > 0 && data[len(data)-1] == '\n' {
+				lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+				if len(lines) != 1 {
+					t.Fatalf("expected 1 audit record, but got %d", len(lines))
+				}
+				assertAuditRecord(t, []byte(lines[0]), cid, "jane", 1)
+				break
+			}
+			if i == 50 {
+				t.Fatal("expected audit record to be written")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}, func(cfg *server.Config) {
+		cfg.Audit = &server.AuditConfig{File: &path}
+	})
+}
+
+// Test that an audit record is published on NATS on disconnect, with the
+// subject taken from the configured token claim
+func TestAudit_Subject_PublishesRecordOnDisconnect(t *testing.T) {
+	subject := "audit.connections"
+	runTest(t, func(s *Session) {
+		c, cid := connectWithToken(t, s, `{"sub":"jane","user":42}`)
+		c.Disconnect()
+		r := s.GetRequest(t).AssertSubject(t, subject)
+		assertAuditRecord(t, r.RawPayload, cid, "42", 1)
+	}, func(cfg *server.Config) {
+		cfg.Audit = &server.AuditConfig{Subject: &subject, TokenClaim: "user"}
+	})
+}
+
+// Test that an audit record is posted to the HTTP endpoint on disconnect
+func TestAudit_URL_PostsRecordOnDisconnect(t *testing.T) {
+	ch := make(chan []byte, 1)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		ch <- data
+	}))
+	defer hs.Close()
+
+	runTest(t, func(s *Session) {
+		c, cid := connectWithToken(t, s, `{"sub":"jane"}`)
+		c.Disconnect()
+		select {
+		case data := <-ch:
+			assertAuditRecord(t, data, cid, "jane", 1)
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("expected audit record to be posted")
+		}
+	}, func(cfg *server.Config) {
+		cfg.Audit = &server.AuditConfig{URL: &hs.URL}
+	})
+}
+
+// Test that a call audit record is published for call and new requests, with
+// parameter fields matching the redact paths replaced
+func TestAudit_Calls_PublishesRedactedCallRecords(t *testing.T) {
+	subject := "audit.calls"
+	params := json.RawMessage(`{"user":"jane","password":"secret","card":{"number":"4111","exp":"12/30"},"items":[{"id":1,"secret":"a"}]}`)
+	tbl := []struct {
+		Method string
+		Action string
+		Error  *reserr.Error
+	}{
+		{"call.test.other.method", "method", nil},
+		{"call.test.other.method", "method", reserr.ErrInvalidParams},
+		{"new.test.collection", "new", nil},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c, cid := connectWithToken(t, s, `{"sub":"jane"}`)
+			rid := strings.TrimSuffix(strings.SplitN(l.Method, ".", 2)[1], "."+l.Action)
+			creq := c.Request(l.Method, params)
+			s.GetRequest(t).AssertSubject(t, "access."+rid).RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			req := s.GetRequest(t).AssertSubject(t, "call."+rid+"."+l.Action).AssertPathPayload(t, "params", params)
+			if l.Error != nil {
+				req.RespondError(l.Error)
+			} else {
+				req.RespondSuccess(nil)
+			}
+
+			r := s.GetRequest(t).AssertSubject(t, subject)
+			var rec server.CallAuditRecord
+			if err := json.Unmarshal(r.RawPayload, &rec); err != nil {
+				t.Fatalf("error decoding call audit record: %s", err)
+			}
+			expectedError := ""
+			if l.Error != nil {
+				expectedError = l.Error.Code
+			}
+			if rec.Type != "call" || rec.CID != cid || rec.Subject != "jane" || rec.Resource != rid || rec.Method != l.Action || rec.Error != expectedError {
+				t.Fatalf("expected call audit record for %s.%s with error %#v, but got %s", rid, l.Action, expectedError, r.RawPayload)
+			}
+			redacted := `{"card":{"exp":"12/30","number":"[REDACTED]"},"items":[{"id":1,"secret":"[REDACTED]"}],"password":"[REDACTED]","user":"jane"}`
+			if string(rec.Params) != redacted {
+				t.Fatalf("expected call audit record params to be:\n%s\nbut got:\n%s", redacted, rec.Params)
+			}
+			creq.GetResponse(t)
+		}, func(cfg *server.Config) {
+			cfg.Audit = &server.AuditConfig{
+				Subject: &subject,
+				Calls:   true,
+				Redact:  []string{"password", "card.number", "items.*.secret"},
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+type bandwidthResponse struct {
+	Connections []struct {
+		CID      string `json:"cid"`
+		Subject  string `json:"subject"`
+		BytesIn  int64  `json:"bytesIn"`
+		BytesOut int64  `json:"bytesOut"`
+	} `json:"connections"`
+	Subjects []struct {
+		Subject     string `json:"subject"`
+		Connections int    `json:"connections"`
+		BytesIn     int64  `json:"bytesIn"`
+		BytesOut    int64  `json:"bytesOut"`
+		WindowBytes *int64 `json:"windowBytes"`
+	} `json:"subjects"`
+}
+
+// getBandwidth requests the admin bandwidth endpoint and returns the decoded
+// body.
+func getBandwidth(t *testing.T, s *Session) bandwidthResponse {
+	hresp := s.AdminRequest("GET", "/bandwidth", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	var br bandwidthResponse
+	if err := json.Unmarshal(hresp.Body.Bytes(), &br); err != nil {
+		t.Fatalf("error decoding bandwidth response: %s", err)
+	}
+	return br
+}
+
+// Test that the admin endpoint returns the bytes transferred by each
+// connection and token subject
+func TestBandwidth_AdminBandwidth_ReturnsUsage(t *testing.T) {
+	runTest(t, func(s *Session) {
+		for i := 0; i < 2; i++ {
+			c := s.Connect()
+			cid := getCID(t, s, c)
+			s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"sub":"jane"}}`))
+			getCID(t, s, c)
+		}
+		c := s.Connect()
+		getCID(t, s, c)
+
+		br := getBandwidth(t, s)
+		if len(br.Connections) != 3 {
+			t.Fatalf("expected 3 connections, but got %+v", br.Connections)
+		}
+		var subs int
+		for _, bc := range br.Connections {
+			if bc.BytesIn == 0 || bc.BytesOut == 0 {
+				t.Fatalf("expected connection bytes, but got %+v", bc)
+			}
+			if bc.Subject == "jane" {
+				subs++
+			}
+		}
+		if subs != 2 {
+			t.Fatalf("expected 2 connections with subject jane, but got %+v", br.Connections)
+		}
+		if len(br.Subjects) != 1 || br.Subjects[0].Subject != "jane" || br.Subjects[0].Connections != 2 || br.Subjects[0].BytesOut == 0 || br.Subjects[0].WindowBytes != nil {
+			t.Fatalf("expected usage for subject jane, but got %+v", br.Subjects)
+		}
+	}, func(cfg *server.Config) {
+		cfg.Bandwidth = &server.BandwidthConfig{}
+	})
+}
+
+// Test that requests are rejected once the token subject exceeds the
+// bandwidth cap
+func TestBandwidth_CapExceeded_RejectsRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"sub":"jane"}}`))
+		// Sync with the token event, which is handled before the request
+		getCID(t, s, c)
+
+		params := json.RawMessage(`{"value":"` + strings.Repeat("x", 300) + `"}`)
+		c.Request("call.test.model.method", params).GetResponse(t).AssertErrorCode(t, "system.bandwidthExceeded")
+		br := getBandwidth(t, s)
+		if len(br.Subjects) != 1 || br.Subjects[0].WindowBytes == nil || *br.Subjects[0].WindowBytes <= 200 {
+			t.Fatalf("expected window bytes exceeding cap, but got %+v", br.Subjects)
+		}
+	}, func(cfg *server.Config) {
+		cfg.Bandwidth = &server.BandwidthConfig{Cap: 200, Window: 60000}
+	})
+}
+
+// Test that with Redis, the bytes transferred by the fleet are counted
+// against the bandwidth cap of the token subject
+func TestBandwidth_SharedCapExceededByFleet_RejectsRequests(t *testing.T) {
+	rs := NewRedisTestServer(t)
+	defer rs.Close()
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"sub":"jane"}}`))
+		getCID(t, s, c)
+
+		// Await the bytes of the instance being added to the shared counter
+		var key string
+		for i := 0; key == ""; i++ {
+			for k := range rs.Keys("resgate:bandwidth:jane:") {
+				key = k
+			}
+			if i == 50 {
+				t.Fatal("expected shared bandwidth counter for subject jane")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		// Count bytes transferred by another instance
+		rs.Set(key, "1000")
+		getCID(t, s, c)
+		for i := 0; ; i++ {
+			br := getBandwidth(t, s)
+			if len(br.Subjects) == 1 && br.Subjects[0].WindowBytes != nil && *br.Subjects[0].WindowBytes > 1000 {
+				break
+			}
+			if i == 50 {
+				t.Fatalf("expected window bytes of the fleet, but got %+v", br.Subjects)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		c.Request("call.test.model.method", nil).GetResponse(t).AssertErrorCode(t, "system.bandwidthExceeded")
+	}, rs.Config, func(cfg *server.Config) {
+		cfg.Bandwidth = &server.BandwidthConfig{Cap: 500, Window: 60000}
+	})
+}
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that new connections exceeding the slow-start connection rate are
+// rejected during the ramp-up
+func TestSlowStart_Connections_RejectsExcessConnections(t *testing.T) {
+	errSlowStart := &reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Service starting up"}
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.HTTPRequest("GET", "/", nil).GetResponse(t).Equals(t, http.StatusServiceUnavailable, errSlowStart)
+	}, func(cfg *server.Config) {
+		cfg.SlowStart = &server.SlowStartConfig{Duration: 60000, Connections: 1}
+	})
+}
+
+// Test that connections are not limited once the ramp-up is over
+func TestSlowStart_AfterDuration_AcceptsConnections(t *testing.T) {
+	runTest(t, func(s *Session) {
+		time.Sleep(20 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			c := s.Connect()
+			getCID(t, s, c)
+		}
+	}, func(cfg *server.Config) {
+		cfg.SlowStart = &server.SlowStartConfig{Duration: 10, Connections: 1}
+	})
+}
+
+// Test that client requests are paced by the slow-start request rate during
+// the ramp-up
+func TestSlowStart_Requests_DelaysRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		start := time.Now()
+		// The initial rate is 10 requests per second, with one token
+		// available at start.
+		for i := 0; i < 3; i++ {
+			getCID(t, s, c)
+		}
+		if d := time.Since(start); d < 150*time.Millisecond {
+			t.Fatalf("expected requests to be delayed, but took %s", d)
+		}
+	}, func(cfg *server.Config) {
+		cfg.SlowStart = &server.SlowStartConfig{Duration: 60000, Requests: 100}
+	})
+}
@@ -0,0 +1,63 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/posener/wstest"
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that HTTP requests rejected in maintenance mode include a Retry-After
+// header and the delay as error data
+func TestRetryAfter_HTTPRejection_IncludesHints(t *testing.T) {
+	runTest(t, func(s *Session) {
+		setMaintenance(t, s, `{"enabled":true}`)
+		s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t).
+			Equals(t, http.StatusServiceUnavailable, &reserr.Error{
+				Code:    reserr.CodeServiceUnavailable,
+				Message: "Service under maintenance",
+				Data:    json.RawMessage(`{"retryAfter":2500}`),
+			}).
+			AssertHeaders(t, map[string]string{"Retry-After": "3"})
+	}, func(cfg *server.Config) {
+		cfg.RetryAfter = &server.RetryAfterConfig{Delay: 2500}
+	})
+}
+
+// Test that rejected WebSocket connections are closed with a Try Again Later
+// close frame holding a jittered delay
+func TestRetryAfter_WSRejection_ClosesWithHint(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		getCID(t, s, c)
+
+		d := wstest.NewDialer(s.s.GetWSHandlerFunc())
+		ws, _, err := d.Dial("ws://example.org/", nil)
+		if err != nil {
+			t.Fatalf("expected rejected connection to be upgraded, but got error: %s", err)
+		}
+		defer ws.Close()
+		_, _, err = ws.ReadMessage()
+		cerr, ok := err.(*websocket.CloseError)
+		if !ok || cerr.Code != websocket.CloseTryAgainLater {
+			t.Fatalf("expected close error with code %d, but got %#v", websocket.CloseTryAgainLater, err)
+		}
+		var hint struct {
+			Code       string `json:"code"`
+			RetryAfter int64  `json:"retryAfter"`
+		}
+		if err := json.Unmarshal([]byte(cerr.Text), &hint); err != nil {
+			t.Fatalf("error decoding close reason %#v: %s", cerr.Text, err)
+		}
+		if hint.Code != reserr.CodeServiceUnavailable || hint.RetryAfter < 1000 || hint.RetryAfter > 1500 {
+			t.Fatalf("expected close reason with code and jittered delay, but got %#v", cerr.Text)
+		}
+	}, func(cfg *server.Config) {
+		cfg.SlowStart = &server.SlowStartConfig{Duration: 60000, Connections: 1}
+		cfg.RetryAfter = &server.RetryAfterConfig{Delay: 1000, Jitter: 500}
+	})
+}
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that the HTTP server listens on a Unix domain socket with the
+// configured permissions
+func TestSocket_HTTPRequest_ServedOnSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resgate-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resgate.sock")
+
+	runTest(t, func(s *Session) {
+		var fi os.FileInfo
+		for i := 0; ; i++ {
+			if fi, err = os.Stat(path); err == nil && fi.Mode().Perm() == 0600 {
+				break
+			}
+			if i == 50 {
+				t.Fatalf("expected socket with mode 0600, but got %v, %v", fi, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+		}}
+		resp, err := client.Get("http://resgate/notfound")
+		if err != nil {
+			t.Fatalf("expected request over socket to succeed, but got: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected status code %d, but got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	}, func(cfg *server.Config) {
+		cfg.NoHTTP = false
+		cfg.Socket = &path
+		cfg.SocketMode = "0600"
+	})
+}
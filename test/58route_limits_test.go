@@ -0,0 +1,56 @@
+package test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that WebSocket connections are closed when no message is received
+// within the idle timeout
+func TestRouteLimits_WSIdleTimeout_ClosesConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.AssertClosed(t)
+	}, func(cfg *server.Config) {
+		cfg.WSLimits = &server.WSLimitsConfig{IdleTimeout: 50}
+	})
+}
+
+// Test that the HTTP API header limit rejects large headers, without
+// affecting WebSocket upgrades
+func TestRouteLimits_HTTPMaxHeaderBytes_RejectsLargeHeaders(t *testing.T) {
+	errHeaderTooLarge := &reserr.Error{Code: reserr.CodeBadRequest, Message: "Request header too large"}
+	large := strings.Repeat("x", 2000)
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/api/test/model", nil, func(r *http.Request) {
+			r.Header.Set("X-Large", large)
+		}).GetResponse(t).Equals(t, http.StatusBadRequest, errHeaderTooLarge)
+
+		c := s.ConnectWithHeader(http.Header{"X-Large": {large}})
+		getCID(t, s, c)
+	}, func(cfg *server.Config) {
+		cfg.HTTPLimits = &server.HTTPLimitsConfig{MaxHeaderBytes: 1000}
+	})
+}
+
+// Test that the WebSocket header limit rejects large upgrade request
+// headers, without affecting HTTP API requests
+func TestRouteLimits_WSMaxHeaderBytes_RejectsLargeHeaders(t *testing.T) {
+	errHeaderTooLarge := &reserr.Error{Code: reserr.CodeBadRequest, Message: "Request header too large"}
+	large := strings.Repeat("x", 2000)
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/", nil, func(r *http.Request) {
+			r.Header.Set("X-Large", large)
+		}).GetResponse(t).Equals(t, http.StatusBadRequest, errHeaderTooLarge)
+
+		s.HTTPRequest("GET", "/notfound", nil, func(r *http.Request) {
+			r.Header.Set("X-Large", large)
+		}).GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+	}, func(cfg *server.Config) {
+		cfg.WSLimits = &server.WSLimitsConfig{MaxHeaderBytes: 1000}
+	})
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+type logsResponse struct {
+	Entries []struct {
+		Time    string `json:"time"`
+		Level   string `json:"level"`
+		Message string `json:"message"`
+	} `json:"entries"`
+}
+
+// getLogs requests the admin logs endpoint and returns the decoded body.
+func getLogs(t *testing.T, s *Session, query string) logsResponse {
+	hresp := s.AdminRequest("GET", "/logs"+query, nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	var lr logsResponse
+	if err := json.Unmarshal(hresp.Body.Bytes(), &lr); err != nil {
+		t.Fatalf("error decoding logs response: %s", err)
+	}
+	return lr
+}
+
+// Test that the admin endpoint returns the most recent log entries
+func TestLogBuffer_AdminLogs_ReturnsRecentEntries(t *testing.T) {
+	runTest(t, func(s *Session) {
+		lr := getLogs(t, s, "")
+		if len(lr.Entries) != 3 {
+			t.Fatalf("expected 3 entries, but got %+v", lr.Entries)
+		}
+		last := lr.Entries[len(lr.Entries)-1]
+		if last.Level != "INF" || last.Message != "Server ready" || last.Time == "" {
+			t.Fatalf("expected last entry to be server ready, but got %+v", last)
+		}
+	}, func(cfg *server.Config) {
+		cfg.LogBufferSize = 3
+	})
+}
+
+// Test that the admin endpoint filters log entries by level and limit
+func TestLogBuffer_AdminLogsWithQuery_FiltersEntries(t *testing.T) {
+	runTest(t, func(s *Session) {
+		lr := getLogs(t, s, "?level=info&limit=2")
+		if len(lr.Entries) != 2 {
+			t.Fatalf("expected 2 entries, but got %+v", lr.Entries)
+		}
+		for _, e := range lr.Entries {
+			if e.Level != "INF" && e.Level != "ERR" {
+				t.Fatalf("expected only info and error entries, but got %+v", lr.Entries)
+			}
+		}
+		s.AdminRequest("GET", "/logs?level=warn", nil).GetResponse(t).AssertStatusCode(t, http.StatusBadRequest)
+	}, func(cfg *server.Config) {
+		cfg.LogBufferSize = 100
+	})
+}
+
+// Test that the admin endpoint responds with not found when the log buffer
+// is disabled
+func TestLogBuffer_Disabled_ReturnsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.AdminRequest("GET", "/logs", nil).GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+	})
+}
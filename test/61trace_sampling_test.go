@@ -0,0 +1,50 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that NATS messages are only traced for resources matching the trace
+// sampling patterns, and that connections outside the sample are not traced
+func TestTraceSampling_Patterns_TracesMatchingResources(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		creq := c.Request("call.test.other.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.other").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.other.method").RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		log := s.CountLogger.String()
+		if !strings.Contains(log, "<== get.test.model") || !strings.Contains(log, "==> access.test.model") {
+			t.Fatalf("expected messages for test.model to be traced, but got:\n%s", log)
+		}
+		if strings.Contains(log, "test.other") {
+			t.Fatalf("expected messages for test.other not to be traced, but got:\n%s", log)
+		}
+		if strings.Contains(log, "--> ") {
+			t.Fatalf("expected connection not to be traced, but got:\n%s", log)
+		}
+	}, func(cfg *server.Config) {
+		cfg.TraceSampling = &server.TraceSamplingConfig{Percent: 0, Patterns: []string{"test.model"}}
+	})
+}
+
+// Test that sampled connections are traced
+func TestTraceSampling_Percent_TracesSampledConnections(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		getCID(t, s, c)
+
+		if log := s.CountLogger.String(); !strings.Contains(log, "--> ") {
+			t.Fatalf("expected connection to be traced, but got:\n%s", log)
+		}
+	}, func(cfg *server.Config) {
+		cfg.TraceSampling = &server.TraceSamplingConfig{Percent: 100}
+	})
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+type sentryTestEvent struct {
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Tags    map[string]string `json:"tags"`
+	auth    string
+	path    string
+}
+
+// newSentryTestServer returns a server acting as a Sentry endpoint, passing
+// received events on the channel.
+func newSentryTestServer(t *testing.T) (*httptest.Server, chan sentryTestEvent) {
+	ch := make(chan sentryTestEvent, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev sentryTestEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("error decoding sentry event: %s", err)
+		}
+		ev.auth = r.Header.Get("X-Sentry-Auth")
+		ev.path = r.URL.Path
+		ch <- ev
+		w.Write([]byte(`{}`))
+	}))
+	return ts, ch
+}
+
+// Test that protocol violations by services are reported to Sentry with the
+// resource ID
+func TestErrorReporter_ProtocolViolation_ReportsWithRID(t *testing.T) {
+	ts, ch := newSentryTestServer(t)
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "add", json.RawMessage(`{"value":"foo","idx":0}`))
+
+		select {
+		case ev := <-ch:
+			if ev.path != "/api/42/store/" {
+				t.Errorf("expected path /api/42/store/, but got %s", ev.path)
+			}
+			if !strings.Contains(ev.auth, "sentry_key=public") {
+				t.Errorf("expected auth header to contain sentry_key=public, but got %s", ev.auth)
+			}
+			if ev.Level != "error" {
+				t.Errorf("expected level error, but got %s", ev.Level)
+			}
+			if !strings.Contains(ev.Message, "add event on model") {
+				t.Errorf("expected message about add event on model, but got %s", ev.Message)
+			}
+			if ev.Tags["source"] != "cache" || ev.Tags["rid"] != "test.model" {
+				t.Errorf("expected source cache and rid test.model tags, but got %+v", ev.Tags)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("expected error to be reported, but got nothing")
+		}
+		s.AssertErrorsLogged(t, 1)
+	}, sentryDSN(ts))
+}
+
+// Test that connection errors are reported to Sentry with the connection ID
+func TestErrorReporter_ConnectionError_ReportsWithCID(t *testing.T) {
+	ts, ch := newSentryTestServer(t)
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+
+		s.ConnEvent(cid, "token", json.RawMessage(`[]`))
+
+		select {
+		case ev := <-ch:
+			if !strings.Contains(ev.Message, "malformed event payload") {
+				t.Errorf("expected message about malformed event payload, but got %s", ev.Message)
+			}
+			if ev.Tags["source"] != "connection" || ev.Tags["cid"] != cid {
+				t.Errorf("expected source connection and cid %s tags, but got %+v", cid, ev.Tags)
+			}
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("expected error to be reported, but got nothing")
+		}
+		s.AssertErrorsLogged(t, 1)
+	}, sentryDSN(ts))
+}
+
+// sentryDSN returns a config function setting the Sentry DSN for the test
+// server.
+func sentryDSN(ts *httptest.Server) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		dsn := strings.Replace(ts.URL, "http://", "http://public@", 1) + "/42"
+		cfg.SentryDSN = &dsn
+	}
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func testAEAD() cipher.AEAD {
+	block, _ := aes.NewCipher(testEncryptionKey)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// testEncrypt encrypts the payload as a service would.
+func testEncrypt(payload string) string {
+	aead := testAEAD()
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(payload), nil))
+}
+
+// testDecrypt decrypts the encrypted payload as a service would.
+func testDecrypt(t *testing.T, r *Request) json.RawMessage {
+	var str string
+	if err := json.Unmarshal(r.RawPayload, &str); err != nil {
+		t.Fatalf("expected %s payload to be encrypted, but got %s", r.Subject, r.RawPayload)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(str)
+	aead := testAEAD()
+	n := aead.NonceSize()
+	data, err := aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		t.Fatalf("error decrypting %s payload: %s", r.Subject, err)
+	}
+	return data
+}
+
+func payloadEncryption(pattern string) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.PayloadEncryption = []server.PayloadEncryption{{
+			Pattern: pattern,
+			Key:     base64.StdEncoding.EncodeToString(testEncryptionKey),
+		}}
+	}
+}
+
+// subscribeToEncryptedTestModel makes a successful subscription to
+// test.model, with encrypted requests and responses.
+func subscribeToEncryptedTestModel(t *testing.T, s *Session, c *Conn) {
+	model := resourceData("test.model")
+	creq := c.Request("subscribe.test.model", nil)
+	mreqs := s.GetParallelRequests(t, 2)
+
+	req := mreqs.GetRequest(t, "get.test.model")
+	if data := testDecrypt(t, req); string(data) != `{}` {
+		t.Fatalf("expected decrypted get payload to be {}, but got %s", data)
+	}
+	req.Respond(testEncrypt(`{"result":{"model":` + model + `}}`))
+	req = mreqs.GetRequest(t, "access.test.model")
+	testDecrypt(t, req)
+	req.Respond(testEncrypt(`{"result":{"get":true}}`))
+
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+}
+
+// Test that requests and responses for matching resources are encrypted,
+// while others are not
+func TestPayloadEncryption_Subscribe_EncryptsMatchingRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToEncryptedTestModel(t, s, c)
+
+		c.Request("subscribe.test.other", nil)
+		s.GetParallelRequests(t, 2).GetRequest(t, "get.test.other").AssertPayload(t, json.RawMessage(`{}`))
+	}, payloadEncryption("test.model"))
+}
+
+// Test that encrypted events for matching resources are decrypted
+func TestPayloadEncryption_Event_DecryptsEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToEncryptedTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", testEncrypt(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+	}, payloadEncryption("test.model"))
+}
+
+// Test that unencrypted responses for matching resources are rejected
+func TestPayloadEncryption_UnencryptedResponse_ReturnsError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").Respond(testEncrypt(`{"result":{"get":true,"call":"*"}}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInternalError)
+		s.AssertErrorsLogged(t, 1)
+	}, payloadEncryption("test.model"))
+}
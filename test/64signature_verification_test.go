@@ -0,0 +1,87 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var testSigningKey = ed25519.NewKeyFromSeed([]byte("0123456789abcdef0123456789abcdef"))
+
+// testSign returns a signed message with the payload, as a service would.
+func testSign(key ed25519.PrivateKey, payload string) json.RawMessage {
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(payload)))
+	return json.RawMessage(`{"payload":` + payload + `,"signature":"` + sig + `"}`)
+}
+
+func signatureVerification(pattern string) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.SignatureVerification = []server.SignatureVerification{{
+			Pattern:    pattern,
+			PublicKeys: []string{base64.StdEncoding.EncodeToString(testSigningKey.Public().(ed25519.PublicKey))},
+		}}
+	}
+}
+
+// subscribeToSignedTestModel makes a successful subscription to test.model,
+// with a signed get response.
+func subscribeToSignedTestModel(t *testing.T, s *Session, c *Conn) {
+	model := resourceData("test.model")
+	creq := c.Request("subscribe.test.model", nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "get.test.model").Respond(testSign(testSigningKey, `{"result":{"model":`+model+`}}`))
+	mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+}
+
+// Test that signed get responses and events are verified and passed on
+func TestSignatureVerification_SignedMessages_AreServed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToSignedTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", testSign(testSigningKey, `{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+	}, signatureVerification("test.model"))
+}
+
+// Test that get responses that are unsigned, or signed with an unknown key,
+// are rejected
+func TestSignatureVerification_InvalidGetResponse_ReturnsError(t *testing.T) {
+	otherKey := ed25519.NewKeyFromSeed([]byte("abcdef0123456789abcdef0123456789"))
+	model := resourceData("test.model")
+	tbl := []json.RawMessage{
+		json.RawMessage(`{"result":{"model":` + model + `}}`),
+		testSign(otherKey, `{"result":{"model":`+model+`}}`),
+		json.RawMessage(`{"payload":{"result":{"model":` + model + `}},"signature":"invalid"}`),
+	}
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "get.test.model").Respond(l)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInternalError)
+			s.AssertErrorsLogged(t, 1)
+		}, signatureVerification("test.model"))
+	}
+}
+
+// Test that unsigned events are dropped
+func TestSignatureVerification_UnsignedEvent_IsDropped(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToSignedTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "change", testSign(testSigningKey, `{"values":{"string":"baz"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+		s.AssertErrorsLogged(t, 1)
+	}, signatureVerification("test.model"))
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var errResourceNotAllowed = &reserr.Error{Code: reserr.CodeAccessDenied, Message: "Resource not allowed"}
+
+// Test that client requests for resources not allowed by the allowed and
+// denied resource patterns are rejected without being sent to the service
+func TestResourcePolicy_Requests_RejectsResourcesNotAllowed(t *testing.T) {
+	tbl := []struct {
+		Allowed []string
+		Denied  []string
+		Allow   bool
+	}{
+		{nil, nil, true},
+		{[]string{"test.>"}, nil, true},
+		{[]string{"test.model"}, nil, true},
+		{[]string{"api.>"}, nil, false},
+		{nil, []string{"test.*"}, false},
+		{[]string{"test.>"}, []string{"test.model"}, false},
+		{[]string{"test.>"}, []string{"test.other"}, true},
+	}
+
+	for i, l := range tbl {
+		for _, method := range []string{"subscribe", "get", "call", "auth", "new"} {
+			runNamedTest(t, fmt.Sprintf("#%d %s", i+1, method), func(s *Session) {
+				c := s.Connect()
+				rid := "test.model"
+				if method == "call" || method == "auth" {
+					rid += ".method"
+				}
+				creq := c.Request(method+"."+rid, nil)
+				if l.Allow {
+					s.GetRequest(t)
+					return
+				}
+				creq.GetResponse(t).AssertError(t, errResourceNotAllowed)
+				if len(s.reqs) > 0 {
+					t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+				}
+			}, func(cfg *server.Config) {
+				cfg.AllowedResources = l.Allowed
+				cfg.DeniedResources = l.Denied
+			})
+		}
+	}
+}
+
+// Test that HTTP requests for resources not allowed are rejected
+func TestResourcePolicy_HTTPGet_RejectsResourcesNotAllowed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t).Equals(t, http.StatusUnauthorized, errResourceNotAllowed)
+
+		hreq := s.HTTPRequest("GET", "/api/api/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.api.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		mreqs.GetRequest(t, "access.api.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	}, func(cfg *server.Config) {
+		cfg.AllowedResources = []string{"api.>"}
+	})
+}
+
+var errMethodNotAllowed = &reserr.Error{Code: reserr.CodeAccessDenied, Message: "Method not allowed"}
+
+// Test that call and new requests for methods not allowed by the method
+// policy of a matching resource pattern are rejected without being sent to
+// the service
+func TestResourcePolicy_AllowedMethods_RejectsMethodsNotAllowed(t *testing.T) {
+	tbl := []struct {
+		Method string
+		Allow  bool
+	}{
+		{"set", true},
+		{"delete", true},
+		{"other", false},
+		{"new", false},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model."+l.Method, nil)
+			if !l.Allow {
+				creq.GetResponse(t).AssertError(t, errMethodNotAllowed)
+				if len(s.reqs) > 0 {
+					t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+				}
+				return
+			}
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model."+l.Method).RespondSuccess(nil)
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+		}, func(cfg *server.Config) {
+			cfg.AllowedMethods = []server.MethodPolicy{
+				{Pattern: "test.model", Methods: []string{"set", "delete"}},
+				{Pattern: "test.>", Methods: []string{"other"}},
+			}
+		})
+	}
+}
+
+// Test that resources not matching any method policy may be called with any
+// method
+func TestResourcePolicy_AllowedMethods_AllowsUnmatchedResources(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.other.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.other").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.other.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	}, func(cfg *server.Config) {
+		cfg.AllowedMethods = []server.MethodPolicy{{Pattern: "test.model", Methods: []string{"set"}}}
+	})
+}
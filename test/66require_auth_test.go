@@ -0,0 +1,74 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var errAuthRequired = &reserr.Error{Code: reserr.CodeAccessDenied, Message: "Authentication required"}
+
+func requireAuth(cfg *server.Config) {
+	cfg.RequireAuth = true
+}
+
+// Test that get, subscribe, call, and new requests on connections without a
+// token are rejected without being sent to the service
+func TestRequireAuth_NoToken_RejectsRequests(t *testing.T) {
+	for i, method := range []string{"subscribe.test.model", "get.test.model", "call.test.model.method", "new.test.collection"} {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			c.Request(method, nil).GetResponse(t).AssertError(t, errAuthRequired)
+			if len(s.reqs) > 0 {
+				t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+			}
+		}, requireAuth)
+	}
+}
+
+// Test that auth requests are sent on connections without a token, and that
+// other requests are sent once the auth service has set a token
+func TestRequireAuth_AuthRequest_AllowsRequestsAfterToken(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("auth.test.model.login", nil)
+		req := s.GetRequest(t).AssertSubject(t, "auth.test.model.login")
+		cid := req.PathPayload(t, "cid").(string)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+		req.RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		subscribeToTestModel(t, s, c)
+
+		// Clearing the token requires authentication again
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":null}`))
+		c.Request("call.test.model.method", nil).GetResponse(t).AssertError(t, errAuthRequired)
+	}, requireAuth)
+}
+
+// Test that HTTP requests are allowed once header authentication sets a token
+func TestRequireAuth_HeaderAuth_AllowsHTTPRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		req := s.GetRequest(t).AssertSubject(t, "auth.test.header")
+		s.ConnEvent(req.PathPayload(t, "cid").(string), "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+		req.RespondSuccess(nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+
+		// Header authentication without setting a token
+		hreq = s.HTTPRequest("GET", "/api/test/model", nil)
+		s.GetRequest(t).AssertSubject(t, "auth.test.header").RespondSuccess(nil)
+		hreq.GetResponse(t).Equals(t, http.StatusUnauthorized, errAuthRequired)
+	}, requireAuth, func(cfg *server.Config) {
+		headerAuth := "test.header"
+		cfg.HeaderAuth = &headerAuth
+	})
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var errAuthBanned = &reserr.Error{Code: reserr.CodeAccessDenied, Message: "Too many failed attempts"}
+
+func bruteForce(delay int) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.BruteForce = []server.BruteForceRule{{
+			Pattern:         "test.*.login",
+			IdentifierParam: "user",
+			MaxAttempts:     2,
+			Window:          60000,
+			Delay:           delay,
+			BanDuration:     60000,
+		}}
+	}
+}
+
+// failAuth makes an auth login request that fails with access denied.
+func failAuth(t *testing.T, s *Session, c *Conn, params string) {
+	creq := c.Request("auth.test.model.login", json.RawMessage(params))
+	s.GetRequest(t).AssertSubject(t, "auth.test.model.login").RespondError(reserr.ErrAccessDenied)
+	creq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+}
+
+// Test that auth requests are rejected without being sent to the service
+// once the maximum number of failed attempts is reached, and that the ban
+// is shown on the admin endpoint
+func TestBruteForce_MaxAttempts_BansClient(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		failAuth(t, s, c, `{"user":"foo"}`)
+		failAuth(t, s, c, `{"user":"foo"}`)
+
+		c.Request("auth.test.model.login", json.RawMessage(`{"user":"bar"}`)).GetResponse(t).AssertError(t, errAuthBanned)
+
+		// Methods not matching the pattern are not protected
+		creq := c.Request("auth.test.model.other", nil)
+		s.GetRequest(t).AssertSubject(t, "auth.test.model.other").RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		var stats struct {
+			Rules []struct {
+				Failures int      `json:"failures"`
+				Bans     int      `json:"bans"`
+				Rejected int      `json:"rejected"`
+				Banned   []string `json:"banned"`
+			} `json:"rules"`
+		}
+		resp := s.AdminRequest("GET", "/bruteforce", nil).GetResponse(t)
+		resp.AssertStatusCode(t, http.StatusOK)
+		if err := json.Unmarshal(resp.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		r := stats.Rules[0]
+		if r.Failures != 2 || r.Bans != 2 || r.Rejected != 1 || len(r.Banned) != 2 || r.Banned[0] != "id:foo" {
+			t.Fatalf("expected 2 failures, 2 bans of ip and id:foo, and 1 rejected, but got %+v", r)
+		}
+	}, bruteForce(0))
+}
+
+// Test that a successful auth request clears the failed attempts
+func TestBruteForce_Success_ClearsFailures(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		failAuth(t, s, c, `{"user":"foo"}`)
+
+		creq := c.Request("auth.test.model.login", json.RawMessage(`{"user":"foo"}`))
+		s.GetRequest(t).AssertSubject(t, "auth.test.model.login").RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		failAuth(t, s, c, `{"user":"foo"}`)
+		failAuth(t, s, c, `{"user":"foo"}`)
+		c.Request("auth.test.model.login", nil).GetResponse(t).AssertError(t, errAuthBanned)
+	}, bruteForce(0))
+}
+
+// Test that auth requests are delayed after failed attempts
+func TestBruteForce_Delay_DelaysRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		failAuth(t, s, c, `{"user":"foo"}`)
+
+		start := time.Now()
+		failAuth(t, s, c, `{"user":"foo"}`)
+		if d := time.Since(start); d < 100*time.Millisecond {
+			t.Fatalf("expected auth request to be delayed at least 100ms, but got %s", d)
+		}
+	}, bruteForce(100))
+}
+
+// Test that auth requests awaiting a response count against the maximum
+// number of attempts, and that a successful response frees the attempt
+func TestBruteForce_ParallelAttempts_RejectedBeyondMaxAttempts(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq1 := c.Request("auth.test.model.login", json.RawMessage(`{"user":"foo"}`))
+		creq2 := c.Request("auth.test.model.login", json.RawMessage(`{"user":"foo"}`))
+		mreqs := s.GetParallelRequests(t, 2)
+
+		c.Request("auth.test.model.login", json.RawMessage(`{"user":"foo"}`)).GetResponse(t).AssertError(t, errAuthBanned)
+		c.AssertNoNATSRequest(t, "test.model")
+
+		for _, req := range mreqs {
+			req.RespondSuccess(nil)
+		}
+		creq1.GetResponse(t)
+		creq2.GetResponse(t)
+
+		creq := c.Request("auth.test.model.login", json.RawMessage(`{"user":"foo"}`))
+		s.GetRequest(t).AssertSubject(t, "auth.test.model.login").RespondSuccess(nil)
+		creq.GetResponse(t)
+	}, bruteForce(0))
+}
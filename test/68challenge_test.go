@@ -0,0 +1,123 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var errChallengeRequired = &reserr.Error{Code: "system.challengeRequired", Message: "Challenge required", Data: map[string]interface{}{"url": "https://example.com/challenge"}}
+
+// newChallengeTestServer returns a server acting as a challenge webhook,
+// accepting the challenge response "valid", and passing received challenge
+// responses on the channel.
+func newChallengeTestServer(t *testing.T) (*httptest.Server, chan string) {
+	ch := make(chan string, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Response string `json:"response"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("error decoding challenge request: %s", err)
+		}
+		ch <- req.Response
+		if req.Response == "valid" {
+			w.Write([]byte(`{"success":true}`))
+		} else {
+			w.Write([]byte(`{"success":false}`))
+		}
+	}))
+	return ts, ch
+}
+
+func challenge(ts *httptest.Server, pattern string) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.BruteForce = []server.BruteForceRule{{
+			Pattern:     pattern,
+			MaxAttempts: 5,
+			Window:      60000,
+			BanDuration: 60000,
+			Challenge: &server.ChallengeConfig{
+				After:     1,
+				Param:     "captcha",
+				Header:    "X-Captcha",
+				VerifyURL: ts.URL,
+				URL:       "https://example.com/challenge",
+			},
+		}}
+	}
+}
+
+// Test that auth requests from a flagged client IP require a challenge
+// response, and that a verified response is removed from the parameters
+// before the request is sent to the service
+func TestChallenge_FlaggedIP_RequiresChallengeResponse(t *testing.T) {
+	ts, ch := newChallengeTestServer(t)
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		failAuth(t, s, c, `{"user":"foo"}`)
+
+		// Missing challenge response
+		c.Request("auth.test.model.login", json.RawMessage(`{"user":"foo"}`)).GetResponse(t).AssertError(t, errChallengeRequired)
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+
+		// Rejected challenge response
+		c.Request("auth.test.model.login", json.RawMessage(`{"user":"foo","captcha":"invalid"}`)).GetResponse(t).AssertError(t, errChallengeRequired)
+		if resp := <-ch; resp != "invalid" {
+			t.Fatalf("expected challenge response invalid, but got %s", resp)
+		}
+
+		// Accepted challenge response
+		creq := c.Request("auth.test.model.login", json.RawMessage(`{"user":"foo","captcha":"valid"}`))
+		req := s.GetRequest(t).AssertSubject(t, "auth.test.model.login").AssertPathPayload(t, "params", json.RawMessage(`{"user":"foo"}`))
+		req.RespondSuccess(nil)
+		creq.GetResponse(t)
+		if resp := <-ch; resp != "valid" {
+			t.Fatalf("expected challenge response valid, but got %s", resp)
+		}
+
+		// Successful attempt clears the flag
+		creq = c.Request("auth.test.model.login", nil)
+		s.GetRequest(t).AssertSubject(t, "auth.test.model.login").RespondSuccess(nil)
+		creq.GetResponse(t)
+	}, challenge(ts, "test.*.login"))
+}
+
+// Test that HTTP requests with header authentication from a flagged client IP
+// are redirected to the challenge page, and allowed with a challenge response
+// header
+func TestChallenge_HeaderAuth_RedirectsHTTPRequests(t *testing.T) {
+	ts, _ := newChallengeTestServer(t)
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		s.GetRequest(t).AssertSubject(t, "auth.test.header").RespondError(reserr.ErrAccessDenied)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		mreqs.GetRequest(t, "access.test.model").RespondError(reserr.ErrAccessDenied)
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusUnauthorized)
+
+		s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t).
+			AssertStatusCode(t, http.StatusSeeOther).
+			AssertHeaders(t, map[string]string{"Location": "https://example.com/challenge"})
+
+		hreq = s.HTTPRequest("GET", "/api/test/model", nil, func(r *http.Request) {
+			r.Header.Set("X-Captcha", "valid")
+		})
+		s.GetRequest(t).AssertSubject(t, "auth.test.header").RespondSuccess(nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	}, challenge(ts, "test.header"), func(cfg *server.Config) {
+		headerAuth := "test.header"
+		cfg.HeaderAuth = &headerAuth
+	})
+}
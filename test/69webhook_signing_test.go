@@ -0,0 +1,105 @@
+package test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+type webhookTestRequest struct {
+	body      []byte
+	timestamp string
+	signature string
+}
+
+// newWebhookTestServer returns a server passing received requests on the
+// channel.
+func newWebhookTestServer() (*httptest.Server, chan webhookTestRequest) {
+	ch := make(chan webhookTestRequest, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ch <- webhookTestRequest{
+			body:      body,
+			timestamp: r.Header.Get("Resgate-Timestamp"),
+			signature: r.Header.Get("Resgate-Signature"),
+		}
+	}))
+	return ts, ch
+}
+
+func webhookSignature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getWebhookRequest returns the next request received by the webhook server.
+func getWebhookRequest(t *testing.T, ch chan webhookTestRequest) webhookTestRequest {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatal("expected webhook request")
+	}
+	return webhookTestRequest{}
+}
+
+// Test that audit records posted to a destination matching a webhook signing
+// URL prefix are signed with each key, together with a timestamp
+func TestWebhookSigning_AuditURL_SignsRequests(t *testing.T) {
+	ts, ch := newWebhookTestServer()
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		c, _ := connectWithToken(t, s, `{"sub":"jane"}`)
+		c.Disconnect()
+
+		r := getWebhookRequest(t, ch)
+		sec, err := strconv.ParseInt(r.timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sec, 0)) > time.Minute {
+			t.Fatalf("expected a current Unix timestamp, but got %#v", r.timestamp)
+		}
+		expected := "new=" + webhookSignature("newsecret", r.timestamp, r.body) + ", old=" + webhookSignature("oldsecret", r.timestamp, r.body)
+		if r.signature != expected {
+			t.Fatalf("expected signature header to be:\n%s\nbut got:\n%s", expected, r.signature)
+		}
+	}, func(cfg *server.Config) {
+		url := ts.URL + "/audit"
+		cfg.Audit = &server.AuditConfig{URL: &url}
+		cfg.WebhookSigning = []server.WebhookSigning{
+			{URL: "http://example.com/", Keys: []server.WebhookKey{{ID: "other", Secret: "othersecret"}}},
+			{URL: ts.URL + "/", Keys: []server.WebhookKey{{ID: "new", Secret: "newsecret"}, {ID: "old", Secret: "oldsecret"}}},
+		}
+	})
+}
+
+// Test that requests posted to a destination not matching any webhook signing
+// URL prefix are not signed
+func TestWebhookSigning_UnmatchedURL_DoesNotSignRequests(t *testing.T) {
+	ts, ch := newWebhookTestServer()
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		c, _ := connectWithToken(t, s, `{"sub":"jane"}`)
+		c.Disconnect()
+
+		r := getWebhookRequest(t, ch)
+		if r.timestamp != "" || r.signature != "" {
+			t.Fatalf("expected no signature headers, but got timestamp %#v and signature %#v", r.timestamp, r.signature)
+		}
+	}, func(cfg *server.Config) {
+		cfg.Audit = &server.AuditConfig{URL: &ts.URL}
+		cfg.WebhookSigning = []server.WebhookSigning{
+			{URL: "http://example.com/", Keys: []server.WebhookKey{{ID: "other", Secret: "othersecret"}}},
+		}
+	})
+}
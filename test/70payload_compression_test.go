@@ -0,0 +1,103 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// testCompress compresses the payload as a service would.
+func testCompress(payload string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(payload))
+	w.Close()
+	return buf.Bytes()
+}
+
+func payloadCompression(pattern string, threshold, maxSize int) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.PayloadCompression = []server.PayloadCompression{{
+			Pattern:   pattern,
+			Threshold: threshold,
+			MaxSize:   maxSize,
+		}}
+	}
+}
+
+// Test that compressed responses and events for matching resources are
+// decompressed, while uncompressed ones are still accepted
+func TestPayloadCompression_Subscribe_DecompressesResponsesAndEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		model := resourceData("test.model")
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondRaw(testCompress(`{"result":{"model":` + model + `}}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+
+		s.ResourceEvent("test.model", "change", testCompress(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+	}, payloadCompression("test.>", 0, 0))
+}
+
+// Test that request payloads for matching resources are compressed once they
+// reach the threshold size
+func TestPayloadCompression_CallRequest_CompressesLargePayloads(t *testing.T) {
+	large := `{"value":"` + strings.Repeat("a", 100) + `"}`
+	tbl := []struct {
+		Params     string
+		Compressed bool
+	}{
+		{`{"value":"a"}`, false},
+		{large, true},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model.method", json.RawMessage(l.Params))
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			req := s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+			if bytes.HasPrefix(req.RawPayload, []byte{0x1f, 0x8b}) != l.Compressed {
+				t.Fatalf("expected payload compressed to be %t, but got %q", l.Compressed, req.RawPayload)
+			}
+			if l.Compressed {
+				zr, err := gzip.NewReader(bytes.NewReader(req.RawPayload))
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := ioutil.ReadAll(zr)
+				var p struct {
+					Params json.RawMessage `json:"params"`
+				}
+				if err := json.Unmarshal(data, &p); err != nil || string(p.Params) != large {
+					t.Fatalf("expected decompressed params to be %s, but got %s", large, data)
+				}
+			}
+			req.RespondRaw(testCompress(`{"result":{"foo":"bar"}}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+		}, payloadCompression("test.model", 128, 0))
+	}
+}
+
+// Test that compressed responses exceeding the maximum decompressed size are
+// rejected
+func TestPayloadCompression_ResponseTooLarge_ReturnsError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").
+			RespondRaw(testCompress(`{"result":{"value":"` + strings.Repeat("a", 100) + `"}}`))
+		creq.GetResponse(t).AssertError(t, &reserr.Error{Code: reserr.CodeInternalError, Message: "Decompressed payload too large"})
+		s.AssertErrorsLogged(t, 1)
+	}, payloadCompression("test.model", 0, 64))
+}
@@ -0,0 +1,74 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func chunking(maxChunks, maxSize int) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.Chunking = &server.ChunkingConfig{MaxChunks: maxChunks, MaxSize: maxSize}
+	}
+}
+
+// Test that a chunked get response is reassembled from the chunks
+// requested on the chunk subject
+func TestChunking_GetResponse_ReassemblesChunks(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		model := resourceData("test.model")
+		response := `{"result":{"model":` + model + `}}`
+		chunks := []string{response[:10], response[10:20], response[20:]}
+
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondRaw([]byte(`{"chunked":{"subject":"_CHUNK.test.1","count":3}}`))
+		for i, chunk := range chunks {
+			s.GetRequest(t).
+				Equals(t, "_CHUNK.test.1", json.RawMessage(fmt.Sprintf(`{"index":%d}`, i))).
+				RespondRaw([]byte(chunk))
+		}
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+	}, chunking(0, 0))
+}
+
+// Test that chunked responses are rejected if exceeding the chunk limits, or
+// if a chunk request fails
+func TestChunking_CallResponse_ReturnsErrors(t *testing.T) {
+	errTooLarge := &reserr.Error{Code: reserr.CodeInternalError, Message: "Chunked response too large"}
+	tbl := []struct {
+		Response string
+		Chunks   []string
+		Error    *reserr.Error
+		Logged   int
+	}{
+		{`{"chunked":{"subject":"_CHUNK.test.1","count":3}}`, nil, errTooLarge, 1},
+		{`{"chunked":{"subject":"_CHUNK.test.1","count":2}}`, []string{`{"result":`, `{"foo":"barbazqux"}}`}, errTooLarge, 1},
+		{`{"chunked":{"subject":"","count":1}}`, nil, &reserr.Error{Code: reserr.CodeInternalError, Message: "Invalid chunked response"}, 1},
+		{`{"chunked":{"subject":"_CHUNK.test.1","count":2}}`, []string{`{"result":`}, reserr.ErrTimeout, 0},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondRaw([]byte(l.Response))
+			for _, chunk := range l.Chunks {
+				s.GetRequest(t).AssertSubject(t, "_CHUNK.test.1").RespondRaw([]byte(chunk))
+			}
+			if l.Error == reserr.ErrTimeout {
+				s.GetRequest(t).AssertSubject(t, "_CHUNK.test.1").Timeout()
+			}
+			creq.GetResponse(t).AssertError(t, l.Error)
+			if l.Logged > 0 {
+				s.AssertErrorsLogged(t, l.Logged)
+			}
+		}, chunking(2, 24))
+	}
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+const testStream = `{"stream":["foo","bar","baz"],"offset":10}`
+
+// subscribeToTestStream makes a successful subscription to test.stream with
+// the subscribe params, and returns the client response.
+func subscribeToTestStream(t *testing.T, s *Session, c *Conn, params interface{}) *ClientResponse {
+	creq := c.Request("subscribe.test.stream", params)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "get.test.stream").RespondSuccess(json.RawMessage(testStream))
+	mreqs.GetRequest(t, "access.test.stream").RespondSuccess(json.RawMessage(`{"get":true}`))
+	return creq.GetResponse(t)
+}
+
+// Test that subscribing to a stream returns the stream offset without
+// entries, and that append events are sent with the entry offset
+func TestStream_Subscribe_SendsAppendEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestStream(t, s, c, nil).
+			AssertResult(t, json.RawMessage(`{"streams":{"test.stream":{"offset":13,"entries":[]}}}`))
+
+		s.ResourceEvent("test.stream", "append", json.RawMessage(`{"value":"qux"}`))
+		c.GetEvent(t).Equals(t, "test.stream.append", json.RawMessage(`{"offset":13,"value":"qux"}`))
+		s.ResourceEvent("test.stream", "append", json.RawMessage(`{"value":42}`))
+		c.GetEvent(t).Equals(t, "test.stream.append", json.RawMessage(`{"offset":14,"value":42}`))
+	})
+}
+
+// Test that subscribing to a stream with an offset replays the cached
+// entries from the offset
+func TestStream_SubscribeWithOffset_ReplaysEntries(t *testing.T) {
+	tbl := []struct {
+		Params   interface{}
+		Expected string
+	}{
+		{json.RawMessage(`{"offset":0}`), `{"offset":10,"entries":["foo","bar","baz"]}`},
+		{json.RawMessage(`{"offset":10}`), `{"offset":10,"entries":["foo","bar","baz"]}`},
+		{json.RawMessage(`{"offset":12}`), `{"offset":12,"entries":["baz"]}`},
+		{json.RawMessage(`{"offset":13}`), `{"offset":13,"entries":[]}`},
+		{json.RawMessage(`{"offset":20}`), `{"offset":13,"entries":[]}`},
+		{json.RawMessage(`{}`), `{"offset":13,"entries":[]}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			subscribeToTestStream(t, s, c, l.Params).
+				AssertResult(t, json.RawMessage(`{"streams":{"test.stream":`+l.Expected+`}}`))
+		})
+	}
+}
+
+// Test that appended entries are cached and replayed on subscriptions by
+// other clients
+func TestStream_AppendEvent_UpdatesCachedStream(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestStream(t, s, c, nil)
+		s.ResourceEvent("test.stream", "append", json.RawMessage(`{"value":"qux"}`))
+		c.GetEvent(t).Equals(t, "test.stream.append", json.RawMessage(`{"offset":13,"value":"qux"}`))
+
+		c2 := s.Connect()
+		creq := c2.Request("subscribe.test.stream", json.RawMessage(`{"offset":12}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.stream").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"streams":{"test.stream":{"offset":12,"entries":["baz","qux"]}}}`))
+	})
+}
+
+// Test that a negative or invalid subscribe offset results in an invalid
+// params error
+func TestStream_SubscribeWithInvalidOffset_ReturnsInvalidParams(t *testing.T) {
+	for i, params := range []string{`{"offset":-1}`, `{"offset":"foo"}`, `[]`} {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			c.Request("subscribe.test.stream", json.RawMessage(params)).GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+		})
+	}
+}
+
+// Test that stream get responses with resource references, or with both a
+// stream and a collection, are invalid
+func TestStream_InvalidGetResponse_ReturnsError(t *testing.T) {
+	for i, result := range []string{
+		`{"stream":[{"rid":"test.model"}]}`,
+		`{"stream":["foo"],"collection":["foo"]}`,
+		`{"stream":["foo"],"offset":-1}`,
+	} {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.stream", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "get.test.stream").RespondSuccess(json.RawMessage(result))
+			mreqs.GetRequest(t, "access.test.stream").RespondSuccess(json.RawMessage(`{"get":true}`))
+			creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInternalError)
+		})
+	}
+}
+
+// Test that HTTP get requests for a stream return all cached entries
+func TestStream_HTTPGet_ReturnsCachedEntries(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/stream", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.stream").RespondSuccess(json.RawMessage(testStream))
+		mreqs.GetRequest(t, "access.test.stream").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"offset":10,"entries":["foo","bar","baz"]}`))
+	})
+}
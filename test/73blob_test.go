@@ -0,0 +1,124 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func blobs(maxAge int) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.Blobs = []server.BlobConfig{{Pattern: "test.blob.>", MaxAge: maxAge}}
+	}
+}
+
+// Test that HTTP get requests for a blob resource serve the blob data
+// responded by the service, with content type and cache headers
+func TestBlob_Data_ServesContent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/blob/file", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.blob.file").RespondSuccess(json.RawMessage(`{"get":true}`))
+		s.GetRequest(t).AssertSubject(t, "blob.test.blob.file").RespondSuccess(json.RawMessage(`{"contentType":"text/plain","etag":"v1","data":"aGVsbG8gd29ybGQ="}`))
+		hreq.GetResponse(t).
+			Equals(t, http.StatusOK, []byte("hello world")).
+			AssertHeaders(t, map[string]string{
+				"Content-Type":  "text/plain",
+				"ETag":          `"v1"`,
+				"Cache-Control": "max-age=60",
+				"Accept-Ranges": "bytes",
+			})
+	}, blobs(60))
+}
+
+// Test that range and conditional HTTP get requests for blob data are
+// handled by the gateway
+func TestBlob_DataWithRangeOrCondition_ServesPartialContent(t *testing.T) {
+	tbl := []struct {
+		Header       string
+		Value        string
+		ExpectedCode int
+		ExpectedBody string
+	}{
+		{"Range", "bytes=0-4", http.StatusPartialContent, "hello"},
+		{"Range", "bytes=6-", http.StatusPartialContent, "world"},
+		{"If-None-Match", `"v1"`, http.StatusNotModified, ""},
+		{"If-None-Match", `"v0"`, http.StatusOK, "hello world"},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/blob/file", nil, func(r *http.Request) {
+				r.Header.Set(l.Header, l.Value)
+			})
+			s.GetRequest(t).AssertSubject(t, "access.test.blob.file").RespondSuccess(json.RawMessage(`{"get":true}`))
+			s.GetRequest(t).AssertSubject(t, "blob.test.blob.file").RespondSuccess(json.RawMessage(`{"contentType":"text/plain","etag":"v1","data":"aGVsbG8gd29ybGQ="}`))
+			hreq.GetResponse(t).Equals(t, l.ExpectedCode, []byte(l.ExpectedBody))
+		}, blobs(0))
+	}
+}
+
+// Test that a blob responded with a URL is fetched by the gateway, passing
+// on range headers
+func TestBlob_URL_FetchesContent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte("hello world")))
+	}))
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/blob/file", nil, func(r *http.Request) {
+			r.Header.Set("Range", "bytes=6-")
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.blob.file").RespondSuccess(json.RawMessage(`{"get":true}`))
+		s.GetRequest(t).AssertSubject(t, "blob.test.blob.file").RespondSuccess(json.RawMessage(`{"contentType":"text/plain","url":"` + ts.URL + `/file"}`))
+		hreq.GetResponse(t).
+			Equals(t, http.StatusPartialContent, []byte("world")).
+			AssertHeaders(t, map[string]string{
+				"Content-Type":  "text/plain",
+				"Content-Range": "bytes 6-10/11",
+				"ETag":          `"abc"`,
+			})
+	}, blobs(0))
+}
+
+// Test that blob requests are not sent when access is denied
+func TestBlob_AccessDenied_ReturnsUnauthorized(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/blob/file", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.blob.file").RespondError(reserr.ErrAccessDenied)
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusUnauthorized)
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+	}, blobs(0))
+}
+
+// Test that invalid blob responses result in an internal error
+func TestBlob_InvalidResponse_ReturnsInternalError(t *testing.T) {
+	tbl := []struct {
+		Result         string
+		ExpectedLogged int
+	}{
+		{`{"contentType":"text/plain"}`, 0},
+		{`{"data":"aGVsbG8=","url":"https://example.com/file"}`, 0},
+		{`{"url":"/file"}`, 1},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/blob/file", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.blob.file").RespondSuccess(json.RawMessage(`{"get":true}`))
+			s.GetRequest(t).AssertSubject(t, "blob.test.blob.file").RespondSuccess(json.RawMessage(l.Result))
+			hreq.GetResponse(t).AssertStatusCode(t, http.StatusInternalServerError)
+			s.AssertErrorsLogged(t, l.ExpectedLogged)
+		}, blobs(0))
+	}
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// newUploadDir returns a temporary upload directory and the config option
+// accepting uploads on test.model.upload.
+func newUploadDir(t *testing.T, maxSize int64) (string, func(*server.Config)) {
+	dir, err := ioutil.TempDir("", "resgate-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func(cfg *server.Config) {
+		cfg.Uploads = []server.UploadConfig{{
+			Pattern: "test.>",
+			Method:  "upload",
+			Dir:     dir,
+			URL:     "https://files.example.com/",
+			MaxSize: maxSize,
+		}}
+	}
+}
+
+// multipartBody returns a multipart/form-data body with a text file and a
+// form field, and a request option setting its content type.
+func multipartBody(t *testing.T) ([]byte, func(*http.Request)) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("title", "Greeting")
+	fw, err := mw.CreateFormFile("file", "hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("hello world"))
+	mw.Close()
+	return buf.Bytes(), func(r *http.Request) {
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+	}
+}
+
+// assertUploadDirFiles asserts the number of files in the upload directory.
+func assertUploadDirFiles(t *testing.T, dir string, n int) []os.FileInfo {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != n {
+		t.Fatalf("expected %d files in upload directory, but found %d", n, len(fis))
+	}
+	return fis
+}
+
+// Test that multipart uploads are stored, and passed as file references in
+// the call parameters
+func TestUpload_MultipartForm_StoresFileAndPassesReference(t *testing.T) {
+	dir, opt := newUploadDir(t, 0)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		body, ct := multipartBody(t)
+		hreq := s.HTTPRequest("POST", "/api/test/model/upload", body, ct)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		req := s.GetRequest(t).AssertSubject(t, "call.test.model.upload")
+
+		fis := assertUploadDirFiles(t, dir, 1)
+		name := fis[0].Name()
+		if filepath.Ext(name) != ".txt" {
+			t.Fatalf("expected stored file extension .txt, but got %s", name)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != "hello world" {
+			t.Fatalf("expected stored file content hello world, but got %q (%v)", data, err)
+		}
+		req.AssertPathPayload(t, "params.title", "Greeting")
+		req.AssertPathPayload(t, "params.file", json.RawMessage(`{
+			"url":"https://files.example.com/`+name+`",
+			"name":"hello.txt",
+			"contentType":"application/octet-stream",
+			"size":11,
+			"sha256":"b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+		}`))
+		req.RespondSuccess(json.RawMessage(`{"id":42}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"id":42}`))
+	}, opt)
+}
+
+// Test that stored files are removed when the call request fails
+func TestUpload_CallError_RemovesStoredFile(t *testing.T) {
+	dir, opt := newUploadDir(t, 0)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		body, ct := multipartBody(t)
+		hreq := s.HTTPRequest("POST", "/api/test/model/upload", body, ct)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.upload").RespondError(reserr.ErrInvalidParams)
+		hreq.GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+		assertUploadDirFiles(t, dir, 0)
+	}, opt)
+}
+
+// Test that uploads exceeding the max size are rejected without sending a
+// call request, and without storing any file
+func TestUpload_TooLarge_ReturnsBadRequest(t *testing.T) {
+	dir, opt := newUploadDir(t, 10)
+	defer os.RemoveAll(dir)
+
+	runTest(t, func(s *Session) {
+		body, ct := multipartBody(t)
+		s.HTTPRequest("POST", "/api/test/model/upload", body, ct).GetResponse(t).
+			AssertStatusCode(t, http.StatusBadRequest).
+			AssertError(t, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Upload too large"})
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+		assertUploadDirFiles(t, dir, 0)
+	}, opt)
+}
@@ -0,0 +1,156 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// storeRequest is a request received by a fake storage service.
+type storeRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// newFakeStore returns a fake storage service recording received requests,
+// and the config option putting uploads on test.>.upload in its bucket.
+func newFakeStore(t *testing.T, typ, secretKey string) (*httptest.Server, chan storeRequest, func(*server.Config)) {
+	ch := make(chan storeRequest, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ch <- storeRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header, Body: string(body)}
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	return ts, ch, func(cfg *server.Config) {
+		cfg.Uploads = []server.UploadConfig{{
+			Pattern: "test.>",
+			Method:  "upload",
+			Store: &server.UploadStoreConfig{
+				Type:      typ,
+				Endpoint:  ts.URL,
+				Bucket:    "files",
+				AccessKey: "account",
+				SecretKey: secretKey,
+				Prefix:    "uploads/",
+			},
+		}}
+	}
+}
+
+// getStoreRequest returns the next request received by the fake storage
+// service.
+func getStoreRequest(t *testing.T, ch chan storeRequest, method string) storeRequest {
+	select {
+	case r := <-ch:
+		if r.Method != method {
+			t.Fatalf("expected store request method %s, but got %s", method, r.Method)
+		}
+		if !strings.HasPrefix(r.Path, "/files/uploads/") || !strings.HasSuffix(r.Path, ".txt") {
+			t.Fatalf("expected store request path /files/uploads/<name>.txt, but got %s", r.Path)
+		}
+		return r
+	default:
+		t.Fatalf("expected a %s store request, but found none", method)
+	}
+	return storeRequest{}
+}
+
+// Test that uploads to an S3 or Azure store are put with signed requests,
+// and passed with the object URL in the call parameters
+func TestUploadStore_RemoteStore_PutsSignedObject(t *testing.T) {
+	tbl := []struct {
+		Type                string
+		SecretKey           string
+		ExpectedAuthPrefix  string
+		ExpectedSignedField string
+	}{
+		{"s3", "secret", "AWS4-HMAC-SHA256 Credential=account/", "X-Amz-Content-Sha256"},
+		{"azure", "c2VjcmV0", "SharedKey account:", "X-Ms-Blob-Type"},
+	}
+
+	for _, l := range tbl {
+		ts, ch, opt := newFakeStore(t, l.Type, l.SecretKey)
+		runNamedTest(t, l.Type, func(s *Session) {
+			body, ct := multipartBody(t)
+			hreq := s.HTTPRequest("POST", "/api/test/model/upload", body, ct)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+			req := s.GetRequest(t).AssertSubject(t, "call.test.model.upload")
+
+			sreq := getStoreRequest(t, ch, "PUT")
+			if auth := sreq.Header.Get("Authorization"); !strings.HasPrefix(auth, l.ExpectedAuthPrefix) {
+				t.Fatalf("expected Authorization header prefix %q, but got %q", l.ExpectedAuthPrefix, auth)
+			}
+			if sreq.Header.Get(l.ExpectedSignedField) == "" {
+				t.Fatalf("expected %s header to be set", l.ExpectedSignedField)
+			}
+			if sreq.Body != "hello world" {
+				t.Fatalf("expected stored object content hello world, but got %q", sreq.Body)
+			}
+			req.AssertPathPayload(t, "params.file.url", ts.URL+sreq.Path)
+			req.AssertPathPayload(t, "params.file.size", 11)
+			req.RespondSuccess(json.RawMessage(`{"id":42}`))
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"id":42}`))
+		}, opt)
+		ts.Close()
+	}
+}
+
+// Test that objects put in a remote store are deleted when the call request
+// fails
+func TestUploadStore_CallError_DeletesObject(t *testing.T) {
+	ts, ch, opt := newFakeStore(t, "s3", "secret")
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		body, ct := multipartBody(t)
+		hreq := s.HTTPRequest("POST", "/api/test/model/upload", body, ct)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.upload").RespondError(reserr.ErrInvalidParams)
+		hreq.GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+
+		put := getStoreRequest(t, ch, "PUT")
+		del := getStoreRequest(t, ch, "DELETE")
+		if del.Path != put.Path {
+			t.Fatalf("expected deleted object %s, but got %s", put.Path, del.Path)
+		}
+		if !strings.HasPrefix(del.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			t.Fatalf("expected signed delete request, but got Authorization %q", del.Header.Get("Authorization"))
+		}
+	}, opt)
+}
+
+// Test that a failing store results in an internal error without sending a
+// call request
+func TestUploadStore_PutFailure_ReturnsInternalError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	runTest(t, func(s *Session) {
+		body, ct := multipartBody(t)
+		s.HTTPRequest("POST", "/api/test/model/upload", body, ct).GetResponse(t).
+			AssertStatusCode(t, http.StatusInternalServerError).
+			AssertError(t, &reserr.Error{Code: reserr.CodeInternalError, Message: "Failed to store upload"})
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no NATS request, but found %s", (<-s.reqs).Subject)
+		}
+		s.AssertErrorsLogged(t, 1)
+	}, func(cfg *server.Config) {
+		cfg.Uploads = []server.UploadConfig{{
+			Pattern: "test.>",
+			Method:  "upload",
+			Store:   &server.UploadStoreConfig{Type: "s3", Endpoint: ts.URL, Bucket: "files", AccessKey: "key", SecretKey: "secret"},
+		}}
+	})
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func bootstrapResources(rids ...string) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.BootstrapResources = rids
+	}
+}
+
+// Test that the configured bootstrap resources are subscribed when the
+// connection gets a token, and sent in a single bootstrap event
+func TestBootstrap_ConfiguredResources_SendsBootstrapEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		collection := resourceData("test.collection")
+
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+
+		mreqs := s.GetParallelRequests(t, 4)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + collection + `}`))
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		c.GetEvent(t).Equals(t, "bootstrap", json.RawMessage(`{
+			"rids":["test.model","test.collection"],
+			"models":{"test.model":`+model+`},
+			"collections":{"test.collection":`+collection+`}
+		}`))
+
+		// Validate the resources are directly subscribed
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+		c.Request("unsubscribe.test.collection", nil).GetResponse(t).AssertResult(t, nil)
+	}, bootstrapResources("test.model", "test.collection"))
+}
+
+// Test that bootstrap resources provided in the token event are subscribed
+// and sent in a bootstrap event
+func TestBootstrap_TokenEventResources_SendsBootstrapEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"},"bootstrap":["test.model"]}`))
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		c.GetEvent(t).Equals(t, "bootstrap", json.RawMessage(`{"rids":["test.model"],"models":{"test.model":`+model+`}}`))
+	})
+}
+
+// Test that bootstrap resources with access denied are included as errors,
+// and are not subscribed
+func TestBootstrap_AccessDenied_IncludesError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+
+		mreqs := s.GetParallelRequests(t, 4)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":false}`))
+		c.GetEvent(t).Equals(t, "bootstrap", json.RawMessage(`{
+			"rids":["test.model"],
+			"models":{"test.model":`+model+`},
+			"errors":{"test.collection":{"code":"system.accessDenied","message":"Access denied"}}
+		}`))
+	}, bootstrapResources("test.model", "test.collection"))
+}
+
+// Test that configured bootstrap resources are not subscribed again when the
+// token is changed, or when already directly subscribed
+func TestBootstrap_TokenChangedOrAlreadySubscribed_SendsNoEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := subscribeToTestModel(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+		c.AssertNoNATSRequest(t, "test.model")
+		c.AssertNoEvent(t, "test.model")
+
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"bar"}}`))
+		// Reaccess of the subscribed model with the new token
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		c.AssertNoNATSRequest(t, "test.model")
+		c.AssertNoEvent(t, "test.model")
+	}, bootstrapResources("test.model"))
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func subscriptionTTL(ttl int) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.SubscriptionTTL = ttl
+	}
+}
+
+// subscribeToTestModelWithParams makes a successful subscription to
+// test.model with the subscribe params.
+func subscribeToTestModelWithParams(t *testing.T, s *Session, c *Conn, params interface{}) {
+	model := resourceData("test.model")
+	creq := c.Request("subscribe.test.model", params)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+	mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+}
+
+// Test that a subscription expires after the ttl set by the client, or by the
+// gateway, sending an unsubscribe event and removing the subscription
+func TestSubscriptionTTL_Expired_SendsUnsubscribeEvent(t *testing.T) {
+	tbl := []struct {
+		Params interface{}
+		TTL    int
+	}{
+		{json.RawMessage(`{"ttl":20}`), 0},
+		{nil, 20},
+		{json.RawMessage(`{"ttl":60000}`), 20},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			subscribeToTestModelWithParams(t, s, c, l.Params)
+			c.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.subscriptionExpired","message":"Subscription expired"}}`))
+			c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertError(t, reserr.ErrNoSubscription)
+		}, subscriptionTTL(l.TTL))
+	}
+}
+
+// Test that a subscription unsubscribed before the ttl, or refreshed with a
+// subscribe request without ttl, does not expire
+func TestSubscriptionTTL_UnsubscribedOrRefreshed_SendsNoEvent(t *testing.T) {
+	runNamedTest(t, "unsubscribed", func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModelWithParams(t, s, c, json.RawMessage(`{"ttl":20}`))
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+		time.Sleep(50 * time.Millisecond)
+		c.AssertNoEvent(t, "test.model")
+	})
+
+	runNamedTest(t, "refreshed", func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModelWithParams(t, s, c, json.RawMessage(`{"ttl":20}`))
+		c.Request("subscribe.test.model", nil).GetResponse(t).AssertResult(t, json.RawMessage(`{}`))
+		time.Sleep(50 * time.Millisecond)
+		c.AssertNoEvent(t, "test.model")
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+	})
+}
+
+// Test that an invalid subscribe ttl results in an invalid params error
+func TestSubscriptionTTL_InvalidTTL_ReturnsInvalidParams(t *testing.T) {
+	for i, params := range []string{`{"ttl":0}`, `{"ttl":-1}`, `{"ttl":"foo"}`} {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			c.Request("subscribe.test.model", json.RawMessage(params)).GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func reaccessInterval(ms int) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.ReaccessInterval = ms
+	}
+}
+
+// Test that access to subscribed resources is requested at the reaccess
+// interval, and that subscriptions no longer accessible are unsubscribed
+func TestReaccess_Interval_RevokesDeniedSubscription(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		c.AssertNoEvent(t, "test.model")
+
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		c.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.accessDenied","message":"Access denied"}}`))
+	}, reaccessInterval(100))
+}
@@ -0,0 +1,71 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// Test that a system revoke event removes direct subscriptions of matching
+// resources and sends an unsubscribe event with the reason
+func TestRevoke_SystemRevokeEvent_SendsUnsubscribeEvent(t *testing.T) {
+	tbl := []struct {
+		Event          string
+		ExpectedReason string
+	}{
+		{`{"resources":["test.>"]}`, `{"code":"system.accessDenied","message":"Access denied"}`},
+		{`{"resources":["test.model"],"reason":{"code":"test.suspended","message":"Account suspended"}}`, `{"code":"test.suspended","message":"Account suspended"}`},
+		{`{"resources":["foo.bar","test.*"],"token":{"user":"foo"}}`, `{"code":"system.accessDenied","message":"Access denied"}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			cid := subscribeToTestModel(t, s, c)
+			s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+			s.SystemEvent("revoke", json.RawMessage(l.Event))
+			c.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":`+l.ExpectedReason+`}`))
+
+			// Validate access is requested anew
+			creq := c.Request("get.test.model", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			creq.GetResponse(t)
+		})
+	}
+}
+
+// Test that a system revoke event not matching the resource or the
+// connection selector leaves the subscription
+func TestRevoke_NotMatching_SendsNoEvent(t *testing.T) {
+	for i, ev := range []string{
+		`{"resources":["test.other"]}`,
+		`{"resources":["test.>"],"token":{"user":"bar"}}`,
+		`{"resources":["test.>"],"tags":{"plan":"free"}}`,
+	} {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			cid := subscribeToTestModel(t, s, c)
+			s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+			s.SystemEvent("revoke", json.RawMessage(ev))
+			c.AssertNoEvent(t, "test.model")
+			c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+		})
+	}
+}
+
+// Test that a malformed system revoke event is logged as an error
+func TestRevoke_MalformedEvent_LogsError(t *testing.T) {
+	for i, ev := range []string{
+		`{}`,
+		`{"resources":["test..model"]}`,
+		`{"resources":["test.>"],"reason":{"message":"No code"}}`,
+	} {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			subscribeToTestModel(t, s, c)
+			s.SystemEvent("revoke", json.RawMessage(ev))
+			c.AssertNoEvent(t, "test.model")
+			s.AssertErrorsLogged(t, 1)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// Test that a token change only triggers new access requests for
+// subscriptions whose access depends on a changed claim
+func TestAccessClaims_TokenEvent_ReaccessesOnChangedClaims(t *testing.T) {
+	tbl := []struct {
+		Claims           string
+		Token            string
+		ExpectedReaccess bool
+	}{
+		{`["role"]`, `{"user":"foo","role":"admin","exp":2}`, false},
+		{`["role"]`, `{"user":"foo","role":"guest","exp":2}`, true},
+		{`["role","org"]`, `{"user":"foo","role":"admin","org":1,"exp":2}`, true},
+		{`[]`, `{"user":"bar"}`, false},
+		{`[]`, `null`, true},
+		{`null`, `{"user":"foo","role":"admin","exp":2}`, true},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			model := resourceData("test.model")
+
+			c := s.Connect()
+			cid := getCID(t, s, c)
+			s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo","role":"admin","exp":1}}`))
+
+			creq := c.Request("subscribe.test.model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"claims":` + l.Claims + `}`))
+			creq.GetResponse(t)
+
+			s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+l.Token+`}`))
+			if l.ExpectedReaccess {
+				s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+				c.GetEvent(t).AssertEventName(t, "test.model.unsubscribe")
+			} else {
+				c.AssertNoNATSRequest(t, "test.model")
+				c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+			}
+		})
+	}
+}
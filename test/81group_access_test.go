@@ -0,0 +1,90 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func groupAccess(cfg *server.Config) {
+	cfg.GroupAccess = &server.GroupAccessConfig{}
+}
+
+// connectWithGroupToken connects a client and sets the token of the
+// connection, returning the connection ID.
+func connectWithGroupToken(t *testing.T, s *Session, token string) (*Conn, string) {
+	c := s.Connect()
+	cid := getCID(t, s, c)
+	s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+token+`}`))
+	return c, cid
+}
+
+// Test that connections of the same token subject share a single access
+// request
+func TestGroupAccess_SameSubject_SharesAccessResponse(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c1, _ := connectWithGroupToken(t, s, `{"sub":"foo","tab":1}`)
+		c2, _ := connectWithGroupToken(t, s, `{"sub":"foo","tab":2}`)
+		subscribeToTestModel(t, s, c1)
+
+		c2.Request("subscribe.test.model", nil).GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no requests, but found %d", len(s.reqs))
+		}
+	}, groupAccess)
+}
+
+// Test that connections of different token subjects, or without the token
+// claim, do not share access responses
+func TestGroupAccess_DifferentSubject_SendsAccessRequest(t *testing.T) {
+	for _, token := range []string{`{"sub":"bar"}`, `{"user":"foo"}`} {
+		runNamedTest(t, token, func(s *Session) {
+			model := resourceData("test.model")
+
+			c1, _ := connectWithGroupToken(t, s, `{"sub":"foo"}`)
+			c2, _ := connectWithGroupToken(t, s, token)
+			subscribeToTestModel(t, s, c1)
+
+			creq := c2.Request("subscribe.test.model", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+		}, groupAccess)
+	}
+}
+
+// Test that a system reset of access results in a single shared access
+// request for the connections of the same token subject
+func TestGroupAccess_SystemResetAccess_SendsSingleAccessRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c1, _ := connectWithGroupToken(t, s, `{"sub":"foo"}`)
+		c2, _ := connectWithGroupToken(t, s, `{"sub":"foo"}`)
+		subscribeToTestModel(t, s, c1)
+		c2.Request("subscribe.test.model", nil).GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+
+		s.SystemEvent("reset", json.RawMessage(`{"access":["test.model"]}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		c1.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.accessDenied","message":"Access denied"}}`))
+		c2.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.accessDenied","message":"Access denied"}}`))
+		if len(s.reqs) > 0 {
+			t.Fatalf("expected no requests, but found %d", len(s.reqs))
+		}
+	}, groupAccess)
+}
+
+// Test that a token change drops the access response shared under the
+// previous token subject
+func TestGroupAccess_TokenChanged_SendsAccessRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c, cid := connectWithGroupToken(t, s, `{"sub":"foo","role":"admin"}`)
+		subscribeToTestModel(t, s, c)
+
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"sub":"foo","role":"guest"}}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").AssertPathPayload(t, "token", json.RawMessage(`{"sub":"foo","role":"guest"}`)).RespondSuccess(json.RawMessage(`{"get":false}`))
+		c.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.accessDenied","message":"Access denied"}}`))
+	}, groupAccess)
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a subscribe filter hides collection add events not matching the
+// filter, and adjusts the idx of later add and remove events
+func TestEventFilter_CollectionAddRemove_SendsMatchingEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		collection := resourceData("test.collection")
+
+		c := s.Connect()
+		creq := c.Request("subscribe.test.collection", json.RawMessage(`{"filter":"value == \"bar\" || value > 100"}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + collection + `}`))
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.collection":`+collection+`}}`))
+
+		// Hidden add
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":4,"value":5}`))
+		// Matching add before the hidden item
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":200}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":0,"value":200}`))
+		// Remove of the hidden item
+		s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":5}`))
+		// Hidden add
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":2,"value":7}`))
+		// Remove after the hidden item
+		s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":3}`))
+		c.GetEvent(t).Equals(t, "test.collection.remove", json.RawMessage(`{"idx":2}`))
+		// Matching add after the hidden item
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":3,"value":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":2,"value":"bar"}`))
+		c.AssertNoEvent(t, "test.collection")
+	})
+}
+
+// Test that a subscribe filter hides model change events not matching the
+// filter
+func TestEventFilter_ModelChange_SendsMatchingEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModelWithParams(t, s, c, json.RawMessage(`{"filter":"values.int > 50"}`))
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":10}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":60,"string":"baz"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"int":60,"string":"baz"}}`))
+		c.AssertNoEvent(t, "test.model")
+	})
+}
+
+// Test that a filter in the access response hides events not matching the
+// filter, in addition to the subscribe filter
+func TestEventFilter_AccessFilter_SendsMatchingEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", json.RawMessage(`{"filter":"values.int != 0"}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"filter":"values.string != \"hidden\""}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":1,"string":"hidden"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":0}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":2,"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"int":2,"string":"bar"}}`))
+		c.AssertNoEvent(t, "test.model")
+	})
+}
+
+// Test that an invalid subscribe filter results in an invalid params error
+func TestEventFilter_InvalidFilter_ReturnsInvalidParams(t *testing.T) {
+	for _, params := range []string{`{"filter":"values.int >"}`, `{"filter":42}`} {
+		runNamedTest(t, params, func(s *Session) {
+			c := s.Connect()
+			c.Request("subscribe.test.model", json.RawMessage(params)).GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+		})
+	}
+}
+
+// Test that an invalid filter in the access response results in an internal
+// error
+func TestEventFilter_InvalidAccessFilter_ReturnsInternalError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"filter":"(values.int"}`))
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInternalError)
+		s.AssertErrorsLogged(t, 1)
+	})
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a system throttle event sends a throttle event with the delay
+// between requests to selected connections, and that a rate of 0 sends a
+// zero delay
+func TestThrottleHint_SystemThrottle_SendsThrottleEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		authWithTags(t, s, c, `{"plan":"free"}`)
+
+		s.SystemEvent("throttle", json.RawMessage(`{"rate":4,"tags":{"plan":"free"}}`))
+		c.GetEvent(t).Equals(t, "throttle", json.RawMessage(`{"delay":250}`))
+
+		s.SystemEvent("throttle", json.RawMessage(`{"rate":0,"tags":{"plan":"free"}}`))
+		c.GetEvent(t).Equals(t, "throttle", json.RawMessage(`{"delay":0}`))
+	})
+}
+
+// Test that a system throttle event with a duration sends a resume time, and
+// removes the limit after the duration
+func TestThrottleHint_SystemThrottleWithDuration_SendsResume(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		authWithTags(t, s, c, `{"plan":"free"}`)
+
+		s.SystemEvent("throttle", json.RawMessage(`{"rate":2,"duration":30,"tags":{"plan":"free"}}`))
+		c.GetEvent(t).Equals(t, "throttle", json.RawMessage(`{"delay":500,"resume":30}`))
+		c.GetEvent(t).Equals(t, "throttle", json.RawMessage(`{"delay":0}`))
+	})
+}
+
+// Test that a connection approaching the bandwidth cap is sent a single
+// throttle event for the window
+func TestThrottleHint_BandwidthApproachingCap_SendsThrottleEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"sub":"jane"}}`))
+		// Sync with the token event, which is handled before the request
+		getCID(t, s, c)
+
+		params := json.RawMessage(`{"value":"` + strings.Repeat("x", 600) + `"}`)
+		c.Request("unsubscribe.test.model", params).GetResponse(t).AssertError(t, reserr.ErrNoSubscription)
+		ev := c.GetEvent(t).AssertEventName(t, "throttle")
+		data := ev.Data.(map[string]interface{})
+		if data["delay"] != float64(200) {
+			t.Fatalf("expected delay 200, but got %v", data["delay"])
+		}
+		if resume, ok := data["resume"].(float64); !ok || resume <= 0 || resume > 60000 {
+			t.Fatalf("expected resume within the window, but got %v", data["resume"])
+		}
+
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertError(t, reserr.ErrNoSubscription)
+		c.AssertNoEvent(t, "throttle")
+	}, func(cfg *server.Config) {
+		cfg.Bandwidth = &server.BandwidthConfig{Cap: 2000, Window: 60000, ThrottleAt: 0.25, ThrottleDelay: 200}
+	})
+}
@@ -0,0 +1,138 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/posener/wstest"
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+type quarantineResponse struct {
+	Errors      uint64   `json:"errors"`
+	Quarantines uint64   `json:"quarantines"`
+	Bans        uint64   `json:"bans"`
+	Rejected    uint64   `json:"rejected"`
+	Quarantined []string `json:"quarantined"`
+	Banned      []string `json:"banned"`
+	Connections []struct {
+		CID         string `json:"cid"`
+		Errors      int64  `json:"errors"`
+		Quarantined bool   `json:"quarantined"`
+	} `json:"connections"`
+}
+
+// sendRaw writes a raw text frame to the gateway.
+func sendRaw(c *Conn, msg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		panic("test: error writing raw frame: " + err.Error())
+	}
+}
+
+// getQuarantine requests the admin quarantine endpoint and returns the
+// decoded body.
+func getQuarantine(t *testing.T, s *Session) quarantineResponse {
+	hresp := s.AdminRequest("GET", "/quarantine", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	var qr quarantineResponse
+	if err := json.Unmarshal(hresp.Body.Bytes(), &qr); err != nil {
+		t.Fatalf("error decoding quarantine response: %s", err)
+	}
+	return qr
+}
+
+// Test that protocol errors are counted per connection by the admin endpoint,
+// without quarantine configured
+func TestQuarantine_ProtocolErrors_CountedPerConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		sendRaw(c, `not json`)
+		sendRaw(c, `{"method":"subscribe.test.model"}`)
+		c.Request("foo", nil).GetResponse(t).AssertError(t, reserr.ErrInvalidRequest)
+
+		qr := getQuarantine(t, s)
+		if len(qr.Connections) != 1 || qr.Connections[0].CID != cid || qr.Connections[0].Errors != 3 {
+			t.Fatalf("expected 3 protocol errors for %s, but got %+v", cid, qr.Connections)
+		}
+		if qr.Errors != 0 || qr.Quarantined == nil || qr.Banned == nil {
+			t.Fatalf("expected no quarantine counters, but got %+v", qr)
+		}
+	})
+}
+
+// Test that a client IP reaching maxErrors is quarantined, and that the
+// offending connection is sent a throttle event
+func TestQuarantine_MaxErrors_ThrottlesConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 0; i < 3; i++ {
+			sendRaw(c, `{"id":`)
+		}
+		c.GetEvent(t).Equals(t, "throttle", json.RawMessage(`{"delay":500}`))
+
+		qr := getQuarantine(t, s)
+		if qr.Errors != 3 || qr.Quarantines != 1 || len(qr.Quarantined) != 1 || qr.Bans != 0 {
+			t.Fatalf("expected a quarantined client IP, but got %+v", qr)
+		}
+		if len(qr.Connections) != 1 || !qr.Connections[0].Quarantined {
+			t.Fatalf("expected a quarantined connection, but got %+v", qr.Connections)
+		}
+	}, func(cfg *server.Config) {
+		cfg.Quarantine = &server.QuarantineConfig{MaxErrors: 3, Window: 60000, Rate: 2, BanDuration: 60000}
+	})
+}
+
+// Test that a quarantined client IP reaching maxErrors again is banned, that
+// the offending connection is disconnected, and that new connections are
+// rejected
+func TestQuarantine_MaxErrorsWhileQuarantined_BansClientIP(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 0; i < 2; i++ {
+			sendRaw(c, `[]`)
+		}
+		c.GetEvent(t).Equals(t, "throttle", json.RawMessage(`{"delay":10}`))
+		for i := 0; i < 2; i++ {
+			sendRaw(c, `[]`)
+		}
+		c.AssertClosed(t)
+
+		d := wstest.NewDialer(s.s.GetWSHandlerFunc())
+		_, hresp, err := d.Dial("ws://example.org/", nil)
+		if err == nil {
+			t.Fatal("expected connection to be rejected, but it was not")
+		}
+		if hresp == nil || hresp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected status %d, but got %#v", http.StatusForbidden, hresp)
+		}
+
+		qr := getQuarantine(t, s)
+		if qr.Bans != 1 || qr.Rejected != 1 || len(qr.Banned) != 1 || len(qr.Quarantined) != 0 {
+			t.Fatalf("expected a banned client IP, but got %+v", qr)
+		}
+	}, func(cfg *server.Config) {
+		cfg.Quarantine = &server.QuarantineConfig{MaxErrors: 2, Window: 60000, Rate: 100, BanDuration: 60000}
+	})
+}
+
+// Test that without a rate, a client IP reaching maxErrors is banned directly
+func TestQuarantine_MaxErrorsWithoutRate_BansClientIP(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.Request("foo", nil).GetResponse(t).AssertError(t, reserr.ErrInvalidRequest)
+		c.Request("foo", nil)
+		c.AssertClosed(t)
+
+		qr := getQuarantine(t, s)
+		if qr.Quarantines != 0 || qr.Bans != 1 {
+			t.Fatalf("expected a ban without quarantine, but got %+v", qr)
+		}
+	}, func(cfg *server.Config) {
+		cfg.Quarantine = &server.QuarantineConfig{MaxErrors: 2, Window: 60000, BanDuration: 60000}
+	})
+}
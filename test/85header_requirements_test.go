@@ -0,0 +1,74 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/posener/wstest"
+	"github.com/resgateio/resgate/server"
+)
+
+func appVersionRequirement(flag bool) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.HeaderRequirements = []server.HeaderRequirement{
+			{Header: "x-app-version", Pattern: `^(2|[3-9]|\d{2,})\.`, Flag: flag, Tag: "appVersion"},
+		}
+	}
+}
+
+// Test that a WebSocket handshake not meeting a header requirement is
+// rejected
+func TestHeaderRequirements_MissingOrInvalidHeader_RejectsConnection(t *testing.T) {
+	for _, v := range []string{"", "1.9.0", "abc"} {
+		runNamedTest(t, v, func(s *Session) {
+			h := http.Header{}
+			if v != "" {
+				h.Set("X-App-Version", v)
+			}
+			d := wstest.NewDialer(s.s.GetWSHandlerFunc())
+			_, hresp, err := d.Dial("ws://example.org/", h)
+			if err == nil {
+				t.Fatal("expected connection to be rejected, but it was not")
+			}
+			if hresp == nil || hresp.StatusCode != http.StatusForbidden {
+				t.Fatalf("expected status %d, but got %#v", http.StatusForbidden, hresp)
+			}
+		}, appVersionRequirement(false))
+	}
+}
+
+// Test that a WebSocket handshake meeting a header requirement is accepted,
+// and that the connection is tagged with the header value
+func TestHeaderRequirements_ValidHeader_TagsConnection(t *testing.T) {
+	for _, v := range []string{"2.0.1", "10.0.0"} {
+		runNamedTest(t, v, func(s *Session) {
+			c := s.ConnectWithHeader(http.Header{"X-App-Version": {v}})
+			c.Request("version", versionRequest).GetResponse(t).AssertResult(t, versionResult)
+
+			s.SystemEvent("throttle", json.RawMessage(`{"rate":4,"tags":{"appVersion":"`+v+`"}}`))
+			c.GetEvent(t).Equals(t, "throttle", json.RawMessage(`{"delay":250}`))
+		}, appVersionRequirement(false))
+	}
+}
+
+// Test that a flagged header requirement accepts the connection, and lists the
+// header in the flags of auth requests
+func TestHeaderRequirements_FlaggedHeader_SendsFlagsInAuthRequest(t *testing.T) {
+	for _, v := range []string{"1.9.0", "2.0.0"} {
+		runNamedTest(t, v, func(s *Session) {
+			c := s.ConnectWithHeader(http.Header{"X-App-Version": {v}})
+			c.Request("version", versionRequest).GetResponse(t).AssertResult(t, versionResult)
+
+			creq := c.Request("auth.test.method", nil)
+			req := s.GetRequest(t).AssertSubject(t, "auth.test.method")
+			if v == "1.9.0" {
+				req.AssertPathPayload(t, "flags", []string{"X-App-Version"})
+			} else if _, ok := req.Payload.(map[string]interface{})["flags"]; ok {
+				t.Fatalf("expected no flags in auth request, but got %s", req.RawPayload)
+			}
+			req.RespondSuccess(nil)
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+		}, appVersionRequirement(true))
+	}
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/posener/wstest"
+	"github.com/resgateio/resgate/server"
+)
+
+func clientVersions(require bool) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.ClientVersions = &server.ClientVersionConfig{
+			Query:       "client",
+			MinVersions: map[string]string{"ios": "2.3.0", "*": "1.0"},
+			UpgradeURLs: map[string]string{"ios": "https://x.io/ios"},
+			Require:     require,
+		}
+	}
+}
+
+// dialClientVersion dials the gateway with the URL and headers, and returns
+// the close error and decoded close reason of the rejected connection.
+func dialClientVersion(t *testing.T, s *Session, url string, h http.Header) (*websocket.CloseError, map[string]interface{}) {
+	d := wstest.NewDialer(s.s.GetWSHandlerFunc())
+	ws, _, err := d.Dial(url, h)
+	if err != nil {
+		t.Fatalf("expected rejected connection to be upgraded, but got error: %s", err)
+	}
+	defer ws.Close()
+	_, _, err = ws.ReadMessage()
+	cerr, ok := err.(*websocket.CloseError)
+	if !ok || cerr.Code != websocket.ClosePolicyViolation {
+		t.Fatalf("expected close error with code %d, but got %#v", websocket.ClosePolicyViolation, err)
+	}
+	var reason map[string]interface{}
+	if err := json.Unmarshal([]byte(cerr.Text), &reason); err != nil {
+		t.Fatalf("expected close reason to be JSON, but got %q", cerr.Text)
+	}
+	return cerr, reason
+}
+
+// Test that a client older than its minimum version is rejected with an
+// upgrade-required close reason
+func TestClientVersion_OutdatedClient_RejectsConnection(t *testing.T) {
+	table := []struct {
+		Header   string
+		URL      string
+		Expected map[string]interface{}
+	}{
+		{"ios/2.2.9", "ws://example.org/", map[string]interface{}{"code": "system.upgradeRequired", "client": "ios", "version": "2.2.9", "minVersion": "2.3.0", "upgradeUrl": "https://x.io/ios"}},
+		{"ios/2.3-rc", "ws://example.org/?client=ios/3.0.0", map[string]interface{}{"code": "system.upgradeRequired", "client": "ios", "version": "2.3-rc", "minVersion": "2.3.0", "upgradeUrl": "https://x.io/ios"}},
+		{"ios", "ws://example.org/", map[string]interface{}{"code": "system.upgradeRequired", "client": "ios", "minVersion": "2.3.0", "upgradeUrl": "https://x.io/ios"}},
+		{"", "ws://example.org/?client=web/0.9.5", map[string]interface{}{"code": "system.upgradeRequired", "client": "web", "version": "0.9.5", "minVersion": "1.0"}},
+	}
+	for i, l := range table {
+		runNamedTest(t, l.Header+" "+l.URL, func(s *Session) {
+			h := http.Header{}
+			if l.Header != "" {
+				h.Set("X-Client-Version", l.Header)
+			}
+			_, reason := dialClientVersion(t, s, l.URL, h)
+			for k, v := range l.Expected {
+				if reason[k] != v {
+					t.Fatalf("#%d: expected reason %s to be %v, but got %v", i, k, v, reason[k])
+				}
+			}
+			if len(reason) != len(l.Expected) {
+				t.Fatalf("#%d: expected reason %v, but got %v", i, l.Expected, reason)
+			}
+		}, clientVersions(false))
+	}
+}
+
+// Test that clients at or above their minimum version, and clients not
+// declaring a version, are accepted
+func TestClientVersion_SupportedClient_AcceptsConnection(t *testing.T) {
+	for _, v := range []string{"ios/2.3.0", "ios/v2.10.1", "web/1.0.0", ""} {
+		runNamedTest(t, v, func(s *Session) {
+			h := http.Header{}
+			if v != "" {
+				h.Set("X-Client-Version", v)
+			}
+			c := s.ConnectWithHeader(h)
+			c.Request("version", versionRequest).GetResponse(t).AssertResult(t, versionResult)
+		}, clientVersions(false))
+	}
+}
+
+// Test that a client not declaring a version is rejected if required
+func TestClientVersion_RequiredVersionMissing_RejectsConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		_, reason := dialClientVersion(t, s, "ws://example.org/", nil)
+		if reason["code"] != "system.upgradeRequired" {
+			t.Fatalf("expected upgrade required code, but got %v", reason)
+		}
+	}, clientVersions(true))
+}
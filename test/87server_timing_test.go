@@ -0,0 +1,102 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func serverTiming(ws bool) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.ServerTiming = &server.ServerTimingConfig{WebSocket: ws}
+	}
+}
+
+// assertServerTiming asserts that the Server-Timing header lists the metrics
+// in order, each with a duration.
+func assertServerTiming(t *testing.T, hresp *HTTPResponse, metrics string) {
+	v := hresp.Header().Get("Server-Timing")
+	m := regexp.MustCompile(`;dur=\d+\.\d{3}`)
+	if got := m.ReplaceAllString(v, ""); got != metrics {
+		t.Fatalf("expected Server-Timing metrics %q, but got header %q", metrics, v)
+	}
+}
+
+// Test that HTTP GET responses include a Server-Timing header with the
+// access, get, and encode durations
+func TestServerTiming_HTTPGet_SetsServerTimingHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		assertServerTiming(t, hresp, "access, get, encode, total")
+	}, serverTiming(false))
+}
+
+// Test that HTTP POST responses include a Server-Timing header with the
+// access, call, and encode durations
+func TestServerTiming_HTTPCall_SetsServerTimingHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		assertServerTiming(t, hresp, "access, call, encode, total")
+	}, serverTiming(false))
+}
+
+// Test that HTTP error responses include a Server-Timing header
+func TestServerTiming_HTTPError_SetsServerTimingHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		s.GetParallelRequests(t, 2).GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		hresp := hreq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+		assertServerTiming(t, hresp, "access, total")
+	}, serverTiming(false))
+}
+
+// Test that HTTP responses have no Server-Timing header when not configured
+func TestServerTiming_NotConfigured_NoServerTimingHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK).AssertMissingHeaders(t, []string{"Server-Timing"})
+	})
+}
+
+// Test that WebSocket responses include the total handling time only if
+// configured
+func TestServerTiming_WebSocket_IncludesTimingField(t *testing.T) {
+	for _, ws := range []bool{true, false} {
+		runNamedTest(t, map[bool]string{true: "enabled", false: "disabled"}[ws], func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+			cresps := []*ClientResponse{
+				creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`)),
+				c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertError(t, reserr.ErrNoSubscription),
+			}
+			for i, cresp := range cresps {
+				timing, ok := cresp.Timing.(map[string]interface{})
+				if !ws {
+					if cresp.Timing != nil {
+						t.Fatalf("#%d: expected no timing, but got %v", i, cresp.Timing)
+					}
+					continue
+				}
+				if total, isNum := timing["total"].(float64); !ok || !isNum || total < 0 || len(timing) != 1 {
+					t.Fatalf("#%d: expected timing with total, but got %v", i, cresp.Timing)
+				}
+			}
+		}, serverTiming(ws))
+	}
+}
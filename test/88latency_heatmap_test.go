@@ -0,0 +1,121 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+type latencyResponse struct {
+	Interval   int       `json:"interval"`
+	Buckets    []float64 `json:"buckets"`
+	Histograms []struct {
+		Type    string    `json:"type"`
+		Pattern string    `json:"pattern"`
+		Count   int64     `json:"count"`
+		Totals  []int64   `json:"totals"`
+		Slots   [][]int64 `json:"slots"`
+	} `json:"histograms"`
+}
+
+func latencyHeatmap(patterns ...string) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.LatencyHeatmap = &server.LatencyHeatmapConfig{Patterns: patterns, Slots: 5}
+	}
+}
+
+// getLatencyModel makes a HTTP GET request for test.model, responding to the
+// access and get requests.
+func getLatencyModel(t *testing.T, s *Session) {
+	hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+	hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+}
+
+// Test that the latency of service requests is recorded per request type and
+// matching pattern
+func TestLatencyHeatmap_ServiceRequests_RecordedPerTypeAndPattern(t *testing.T) {
+	runTest(t, func(s *Session) {
+		getLatencyModel(t, s)
+
+		hresp := s.AdminRequest("GET", "/latency", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		var lr latencyResponse
+		if err := json.Unmarshal(hresp.Body.Bytes(), &lr); err != nil {
+			t.Fatalf("error decoding latency response: %s", err)
+		}
+		if lr.Interval != server.LatencyHeatmapInterval || len(lr.Buckets) != len(server.LatencyHeatmapBuckets) {
+			t.Fatalf("expected default interval and buckets, but got %+v", lr)
+		}
+		if len(lr.Histograms) != 2 {
+			t.Fatalf("expected 2 histograms, but got %+v", lr.Histograms)
+		}
+		for i, typ := range []string{"access", "get"} {
+			h := lr.Histograms[i]
+			if h.Type != typ || h.Pattern != "test.*" || h.Count != 1 {
+				t.Fatalf("expected histogram %d to be %s test.* with count 1, but got %+v", i, typ, h)
+			}
+			if len(h.Totals) != len(lr.Buckets)+1 || len(h.Slots) != 5 {
+				t.Fatalf("expected %d buckets and 5 slots, but got %+v", len(lr.Buckets)+1, h)
+			}
+			var n int64
+			for _, v := range h.Slots[4] {
+				n += v
+			}
+			if n != 1 {
+				t.Fatalf("expected the request in the last slot, but got %v", h.Slots)
+			}
+		}
+	}, latencyHeatmap("other.>", "test.*"))
+}
+
+// Test that requests for resources not matching any pattern are not recorded
+func TestLatencyHeatmap_NoMatchingPattern_NotRecorded(t *testing.T) {
+	runTest(t, func(s *Session) {
+		getLatencyModel(t, s)
+		hresp := s.AdminRequest("GET", "/latency", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		if !strings.Contains(hresp.Body.String(), `"histograms":[]`) {
+			t.Fatalf("expected no histograms, but got %s", hresp.Body.String())
+		}
+	}, latencyHeatmap("other.>"))
+}
+
+// Test that the latency heatmap is returned as HTML when requested
+func TestLatencyHeatmap_HTMLFormat_ReturnsHeatmap(t *testing.T) {
+	table := []struct {
+		URL    string
+		Accept string
+	}{
+		{"/latency?format=html", ""},
+		{"/latency", "text/html,application/xhtml+xml"},
+	}
+	for _, l := range table {
+		runNamedTest(t, l.URL+" "+l.Accept, func(s *Session) {
+			getLatencyModel(t, s)
+			hresp := s.AdminRequest("GET", l.URL, nil, func(r *http.Request) {
+				if l.Accept != "" {
+					r.Header.Set("Accept", l.Accept)
+				}
+			}).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+			if ct := hresp.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Fatalf("expected HTML content type, but got %q", ct)
+			}
+			body := hresp.Body.String()
+			if !strings.Contains(body, "<h2>get &gt; (1)</h2>") || !strings.Contains(body, "<td") {
+				t.Fatalf("expected heatmap for get requests, but got:\n%s", body)
+			}
+		}, latencyHeatmap())
+	}
+}
+
+// Test that the latency endpoint responds with not found if not configured
+func TestLatencyHeatmap_NotConfigured_ReturnsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.AdminRequest("GET", "/latency", nil).GetResponse(t).AssertStatusCode(t, http.StatusNotFound).AssertErrorCode(t, reserr.CodeNotFound)
+	})
+}
@@ -0,0 +1,120 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+type allocationsResponse struct {
+	Enabled    bool    `json:"enabled"`
+	SampleRate int     `json:"sampleRate"`
+	Since      *string `json:"since"`
+	Messages   []struct {
+		Type    string `json:"type"`
+		Samples int64  `json:"samples"`
+	} `json:"messages"`
+	TopAllocators []struct {
+		Function string `json:"function"`
+		Bytes    int64  `json:"bytes"`
+	} `json:"topAllocators"`
+}
+
+// decodeAllocations decodes the body of an allocations admin response.
+func decodeAllocations(t *testing.T, hresp *HTTPResponse) allocationsResponse {
+	var ar allocationsResponse
+	if err := json.Unmarshal(hresp.Body.Bytes(), &ar); err != nil {
+		t.Fatalf("error decoding allocations response: %s", err)
+	}
+	if ar.Messages == nil || ar.TopAllocators == nil {
+		t.Fatalf("expected messages and topAllocators lists, but got %s", hresp.Body.String())
+	}
+	return ar
+}
+
+// assertAllocationSamples asserts that each message type has been sampled.
+func assertAllocationSamples(t *testing.T, ar allocationsResponse, types ...string) {
+	for _, typ := range types {
+		found := false
+		for _, m := range ar.Messages {
+			if m.Type == typ && m.Samples > 0 {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("expected samples for %s, but got %+v", typ, ar.Messages)
+		}
+	}
+}
+
+// syncConn waits for a response to a request, to ensure the connection has
+// finished handling any previous message.
+func syncConn(t *testing.T, c *Conn) {
+	c.Request("unsubscribe.test.sync", nil).GetResponse(t).AssertError(t, reserr.ErrNoSubscription)
+}
+
+// Test that the allocation audit is disabled by default
+func TestAllocationAudit_Default_Disabled(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		syncConn(t, c)
+		ar := decodeAllocations(t, s.AdminRequest("GET", "/allocations", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK))
+		if ar.Enabled || ar.SampleRate != server.AllocationAuditSampleRate || ar.Since != nil || len(ar.Messages) != 0 {
+			t.Fatalf("expected disabled allocation audit, but got %+v", ar)
+		}
+	})
+}
+
+// Test that enabling the allocation audit through the admin endpoint samples
+// client requests and service events per type
+func TestAllocationAudit_EnabledByAdmin_SamplesMessageTypes(t *testing.T) {
+	runTest(t, func(s *Session) {
+		ar := decodeAllocations(t, s.AdminRequest("PUT", "/allocations", []byte(`{"enabled":true,"sampleRate":1}`)).GetResponse(t).AssertStatusCode(t, http.StatusOK))
+		if !ar.Enabled || ar.SampleRate != 1 || ar.Since == nil {
+			t.Fatalf("expected enabled allocation audit, but got %+v", ar)
+		}
+
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.model.custom", json.RawMessage(`{"foo":"bar"}`))
+		c.Request("foo.bar", nil).GetResponse(t).AssertError(t, reserr.ErrInvalidRequest)
+		syncConn(t, c)
+
+		ar = decodeAllocations(t, s.AdminRequest("GET", "/allocations", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK))
+		assertAllocationSamples(t, ar, "request.version", "request.subscribe", "request.invalid", "event.change", "event.custom")
+
+		ar = decodeAllocations(t, s.AdminRequest("PUT", "/allocations", []byte(`{"enabled":false}`)).GetResponse(t).AssertStatusCode(t, http.StatusOK))
+		if ar.Enabled || ar.SampleRate != 1 {
+			t.Fatalf("expected disabled allocation audit, but got %+v", ar)
+		}
+		assertAllocationSamples(t, ar, "request.subscribe")
+	})
+}
+
+// Test that the allocation audit may be enabled on start by configuration
+func TestAllocationAudit_EnabledByConfig_SamplesMessageTypes(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		syncConn(t, c)
+		ar := decodeAllocations(t, s.AdminRequest("GET", "/allocations", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK))
+		if !ar.Enabled || ar.SampleRate != 1 {
+			t.Fatalf("expected enabled allocation audit, but got %+v", ar)
+		}
+		assertAllocationSamples(t, ar, "request.version")
+	}, func(cfg *server.Config) {
+		cfg.AllocationAudit = &server.AllocationAuditConfig{Enabled: true, SampleRate: 1}
+	})
+}
+
+// Test that setting an invalid sample rate responds with bad request
+func TestAllocationAudit_InvalidSampleRate_ReturnsBadRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.AdminRequest("PUT", "/allocations", []byte(`{"enabled":true,"sampleRate":-1}`)).GetResponse(t).AssertStatusCode(t, http.StatusBadRequest).AssertErrorCode(t, reserr.CodeBadRequest)
+	})
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+)
+
+// dialSocket dials a WebSocket connection over the Unix domain socket, once
+// the gateway is listening on it.
+func dialSocket(t *testing.T, path string) *websocket.Conn {
+	d := &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		},
+	}
+	for i := 0; ; i++ {
+		ws, _, err := d.Dial("ws://resgate/", nil)
+		if err == nil {
+			return ws
+		}
+		if i == 50 {
+			t.Fatalf("expected WebSocket connection over socket, but got: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Test that WebSocket messages are written when coalescing writes in
+// batches, both for responses and for events sent in the same batch
+func TestSocketTuning_CoalesceBatch_WritesMessages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resgate-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resgate.sock")
+
+	runTest(t, func(s *Session) {
+		ws := dialSocket(t, path)
+		defer ws.Close()
+		ws.SetReadDeadline(time.Now().Add(timeoutSeconds * time.Second))
+
+		read := func() map[string]interface{} {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatalf("expected a message, but got error: %s", err)
+			}
+			var m map[string]interface{}
+			if err := json.Unmarshal(data, &m); err != nil {
+				t.Fatalf("expected JSON message, but got %q", data)
+			}
+			return m
+		}
+
+		ws.WriteMessage(websocket.TextMessage, []byte(`{"id":1,"method":"version","params":{"protocol":"1.2.1"}}`))
+		if m := read(); m["id"] != float64(1) || m["result"] == nil {
+			t.Fatalf("expected version response, but got %v", m)
+		}
+
+		ws.WriteMessage(websocket.TextMessage, []byte(`{"id":2,"method":"subscribe.test.model"}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		if m := read(); m["id"] != float64(2) || m["result"] == nil {
+			t.Fatalf("expected subscribe response, but got %v", m)
+		}
+
+		for _, v := range []string{"bar", "baz", "qux"} {
+			s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"`+v+`"}}`))
+		}
+		for _, v := range []string{"bar", "baz", "qux"} {
+			m := read()
+			if m["event"] != "test.model.change" || m["data"].(map[string]interface{})["values"].(map[string]interface{})["string"] != v {
+				t.Fatalf("expected change event with %q, but got %v", v, m)
+			}
+		}
+	}, func(cfg *server.Config) {
+		noDelay := false
+		cfg.NoHTTP = false
+		cfg.Socket = &path
+		cfg.SocketTuning = []server.SocketTuning{{Listener: "socket", NoDelay: &noDelay, Coalesce: server.CoalesceBatch, CoalesceSize: 64}}
+	})
+}
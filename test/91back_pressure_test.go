@@ -0,0 +1,67 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func backPressure(queueSize int) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.BackPressure = &server.BackPressureConfig{QueueSize: queueSize, Interval: 50}
+	}
+}
+
+// Test that a saturated outbound queue publishes a flow-control signal for
+// the resource, followed by a resumed signal once no longer saturated
+func TestBackPressure_SaturatedQueue_PublishesFlowControl(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.GetRequest(t).AssertSubject(t, "flow.test.model").AssertPayload(t, json.RawMessage(`{"saturated":true}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		s.GetRequest(t).AssertSubject(t, "flow.test.model").AssertPayload(t, json.RawMessage(`{"saturated":false}`))
+
+		// A new saturation signals again
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz"}}`))
+		s.GetRequest(t).AssertSubject(t, "flow.test.model").AssertPayload(t, json.RawMessage(`{"saturated":true}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+	}, backPressure(1))
+}
+
+// Test that sustained saturation repeats the flow-control signal once per
+// interval
+func TestBackPressure_SustainedSaturation_RepeatsSignal(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.GetRequest(t).AssertSubject(t, "flow.test.model").AssertPayload(t, json.RawMessage(`{"saturated":true}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		time.Sleep(30 * time.Millisecond)
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.model.custom", json.RawMessage(`{"foo":"bar"}`))
+
+		s.GetRequest(t).AssertSubject(t, "flow.test.model").AssertPayload(t, json.RawMessage(`{"saturated":true}`))
+		s.GetRequest(t).AssertSubject(t, "flow.test.model").AssertPayload(t, json.RawMessage(`{"saturated":false}`))
+	}, backPressure(1))
+}
+
+// Test that no flow-control signal is published while below the queue size
+func TestBackPressure_BelowQueueSize_NoSignal(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		time.Sleep(60 * time.Millisecond)
+		c.AssertNoNATSRequest(t, "test.model")
+	}, backPressure(100))
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+type eventsResponse struct {
+	Suppressed struct {
+		Change    uint64 `json:"change"`
+		Add       uint64 `json:"add"`
+		Duplicate uint64 `json:"duplicate"`
+	} `json:"suppressed"`
+	Sequence struct {
+		Gaps  uint64 `json:"gaps"`
+		Stale uint64 `json:"stale"`
+	} `json:"sequence"`
+}
+
+// getEventStats requests the admin events endpoint and returns the decoded
+// body.
+func getEventStats(t *testing.T, s *Session) eventsResponse {
+	hresp := s.AdminRequest("GET", "/events", nil).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	var er eventsResponse
+	if err := json.Unmarshal(hresp.Body.Bytes(), &er); err != nil {
+		t.Fatalf("error decoding events response: %s", err)
+	}
+	return er
+}
+
+func uniqueCollections(patterns ...string) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.UniqueCollections = patterns
+	}
+}
+
+// Test that change events not changing the model are suppressed and counted
+func TestDuplicateEvents_NoOpChangeEvent_SuppressedAndCounted(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"foo","int":42}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		if er := getEventStats(t, s); er.Suppressed.Change != 1 || er.Suppressed.Add != 0 {
+			t.Fatalf("expected 1 suppressed change event, but got %+v", er.Suppressed)
+		}
+	})
+}
+
+// Test that add events re-adding a value to a unique collection are
+// suppressed and counted
+func TestDuplicateEvents_ReaddedUniqueCollectionValue_SuppressedAndCounted(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":42}`))
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":1,"value":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":1,"value":"bar"}`))
+
+		if er := getEventStats(t, s); er.Suppressed.Add != 1 {
+			t.Fatalf("expected 1 suppressed add event, but got %+v", er.Suppressed)
+		}
+	}, uniqueCollections("test.*"))
+}
+
+// Test that add events adding a duplicate value to a collection not matching
+// a unique collection pattern are sent
+func TestDuplicateEvents_DuplicateValueInCollection_NotSuppressed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":42}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":0,"value":42}`))
+
+		if er := getEventStats(t, s); er.Suppressed.Add != 0 {
+			t.Fatalf("expected no suppressed add events, but got %+v", er.Suppressed)
+		}
+	}, uniqueCollections("other.>"))
+}
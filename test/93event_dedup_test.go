@@ -0,0 +1,121 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func eventDedupWindow(window int) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.EventDedupWindow = window
+	}
+}
+
+// Test that a resource event with an ID already received for the resource is
+// dropped and counted
+func TestEventDedup_DuplicateEventID_DroppedAndCounted(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"},"meta":{"id":"e1"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"qux"},"meta":{"id":"e1"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz"},"meta":{"id":"e2"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+
+		if er := getEventStats(t, s); er.Suppressed.Duplicate != 1 {
+			t.Fatalf("expected 1 duplicate event, but got %+v", er.Suppressed)
+		}
+	}, eventDedupWindow(60000))
+}
+
+// Test that event IDs are scoped to the resource
+func TestEventDedup_SameEventIDOnOtherResource_Sent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"},"meta":{"id":"e1"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"bar","meta":{"id":"e1"}}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+	}, eventDedupWindow(60000))
+}
+
+// Test that an event ID is forgotten once the window has passed
+func TestEventDedup_EventIDAfterWindow_Sent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"},"meta":{"id":"e1"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		time.Sleep(80 * time.Millisecond)
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz"},"meta":{"id":"e1"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+	}, eventDedupWindow(20))
+}
+
+// Test that events with an event ID already received are not dropped when
+// deduplication is disabled
+func TestEventDedup_Disabled_EventIDIgnored(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"bar","meta":{"id":"e1"}}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"bar","meta":{"id":"e1"}}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+	})
+}
+
+// Test that a custom event is sent as is, with any meta property, when
+// deduplication and sequence checking is enabled
+func TestEventDedup_CustomEventWithMeta_SentAsIs(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar","meta":{"id":"e1","seq":1}}`))
+		c.GetEvent(t).Equals(t, "test.model.custom", json.RawMessage(`{"foo":"bar","meta":{"id":"e1","seq":1}}`))
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar","meta":{"id":"e1","seq":1}}`))
+		c.GetEvent(t).Equals(t, "test.model.custom", json.RawMessage(`{"foo":"bar","meta":{"id":"e1","seq":1}}`))
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar","meta":"e1"}`))
+		c.GetEvent(t).Equals(t, "test.model.custom", json.RawMessage(`{"foo":"bar","meta":"e1"}`))
+
+		if er := getEventStats(t, s); er.Suppressed.Duplicate != 0 || er.Sequence.Gaps != 0 || er.Sequence.Stale != 0 {
+			t.Fatalf("expected no dropped events, but got %+v", er)
+		}
+	}, eventDedupWindow(60000), eventSequence)
+}
+
+// Test that a custom event with a payload other than an object is sent as is,
+// even when containing a meta property
+func TestEventDedup_NonObjectPayload_SentAsIs(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`["meta",{"meta":{"id":"e1"}}]`))
+		c.GetEvent(t).Equals(t, "test.model.custom", json.RawMessage(`["meta",{"meta":{"id":"e1"}}]`))
+	}, eventDedupWindow(60000))
+}
+
+// Test that a malformed meta property is logged as an error, and removed
+// from the payload of the event
+func TestEventDedup_MalformedMeta_LoggedAndRemoved(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"},"meta":"e1"}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.AssertErrorsLogged(t, 1)
+	}, eventDedupWindow(60000))
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func eventSequence(cfg *server.Config) {
+	cfg.EventSequence = true
+}
+
+// Test that events in sequence are applied
+func TestEventSequence_InSequence_Applied(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"},"meta":{"seq":5}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz"},"meta":{"seq":6}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+
+		if er := getEventStats(t, s); er.Sequence.Gaps != 0 || er.Sequence.Stale != 0 {
+			t.Fatalf("expected no out of sequence events, but got %+v", er.Sequence)
+		}
+	}, eventSequence)
+}
+
+// Test that an event skipping a sequence number is not applied, and the
+// resource is refetched instead
+func TestEventSequence_Gap_RefetchesResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"},"meta":{"seq":1}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":12},"meta":{"seq":3}}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"baz","int":12,"bool":true,"null":null}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz","int":12}}`))
+
+		// Following events are applied
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"qux"},"meta":{"seq":4}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"qux"}}`))
+
+		if er := getEventStats(t, s); er.Sequence.Gaps != 1 || er.Sequence.Stale != 0 {
+			t.Fatalf("expected 1 sequence gap, but got %+v", er.Sequence)
+		}
+	}, eventSequence)
+}
+
+// Test that an event with a sequence number already received is not applied
+func TestEventSequence_Stale_NotApplied(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"bar","meta":{"seq":2}}`))
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"baz","meta":{"seq":1}}`))
+		s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":0,"meta":{"seq":3}}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.collection.remove", json.RawMessage(`{"idx":0}`))
+
+		if er := getEventStats(t, s); er.Sequence.Gaps != 0 || er.Sequence.Stale != 1 {
+			t.Fatalf("expected 1 stale event, but got %+v", er.Sequence)
+		}
+	}, eventSequence)
+}
+
+// Test that a system reset allows the service to restart its sequence
+// numbers
+func TestEventSequence_SystemReset_RestartsSequence(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"},"meta":{"seq":7}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.model"]}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"null":null}}`))
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz"},"meta":{"seq":1}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+	}, eventSequence)
+}
+
+// Test that an event with a seq other than a positive integer is logged as an
+// error, and applied without a sequence number
+func TestEventSequence_MalformedSeq_LoggedAndApplied(t *testing.T) {
+	tbl := []string{`"5"`, `0`, `-1`, `1.5`}
+
+	for i, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			subscribeToTestModel(t, s, c)
+
+			s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"},"meta":{"seq":3}}`))
+			c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+			s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz"},"meta":{"seq":`+l+`}}`))
+			c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+			// The sequence continues after the last valid sequence number
+			s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"qux"},"meta":{"seq":4}}`))
+			c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"qux"}}`))
+
+			s.AssertErrorsLogged(t, 1)
+			if er := getEventStats(t, s); er.Sequence.Gaps != 0 || er.Sequence.Stale != 0 {
+				t.Fatalf("expected no out of sequence events, but got %+v", er.Sequence)
+			}
+		}, eventSequence)
+		if t.Failed() {
+			t.Logf("failed on test %d", i)
+			break
+		}
+	}
+}
+
+// Test that sequence numbers are not checked when sequence checking is
+// disabled, even with the meta property removed for event deduplication
+func TestEventSequence_Disabled_NotChecked(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"},"meta":{"seq":1}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz"},"meta":{"seq":3}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"qux"},"meta":{"seq":2}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"qux"}}`))
+
+		if er := getEventStats(t, s); er.Sequence.Gaps != 0 || er.Sequence.Stale != 0 {
+			t.Fatalf("expected no out of sequence events, but got %+v", er.Sequence)
+		}
+	}, eventDedupWindow(60000))
+}
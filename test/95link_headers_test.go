@@ -0,0 +1,68 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func linkHeaders(patterns ...string) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.LinkHeaders = patterns
+	}
+}
+
+// Test that HTTP GET responses include a Link header for each referenced
+// resource, including nested references
+func TestLinkHeaders_NestedReferences_IncludesLinkHeaders(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model/grandparent", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model.grandparent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model.grandparent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.grandparent") + `}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		expected := []string{`</api/test/model/parent>; rel="related"`, `</api/test/model>; rel="related"`}
+		links := hresp.Result().Header["Link"]
+		if len(links) != len(expected) {
+			t.Fatalf("expected Link headers %v, but got %v", expected, links)
+		}
+		for i, l := range expected {
+			if links[i] != l {
+				t.Fatalf("expected Link headers %v, but got %v", expected, links)
+			}
+		}
+	}, linkHeaders("test.model.>"))
+}
+
+// Test that references to resources with errors are not included as Link
+// headers
+func TestLinkHeaders_BrokenReference_ExcludesLinkHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model/brokenchild", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model.brokenchild").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model.brokenchild").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.brokenchild") + `}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.err.notFound").RespondError(resources["test.err.notFound"].err)
+
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK).AssertMissingHeaders(t, []string{"Link"})
+	}, linkHeaders("test.>"))
+}
+
+// Test that resources not matching a link headers pattern respond without
+// Link headers
+func TestLinkHeaders_NotMatchingPattern_NoLinkHeaders(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/collection/parent", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection.parent").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection.parent") + `}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK).AssertMissingHeaders(t, []string{"Link"})
+	}, linkHeaders("test.model.>"))
+}
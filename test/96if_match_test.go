@@ -0,0 +1,94 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that the ifMatch property of a call request is passed to the service
+func TestIfMatch_CallRequest_PassesIfMatch(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIfMatch("call.test.model.method", json.RawMessage(`{"value":42}`), "v3")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "ifMatch", "v3").
+			RespondSuccess(nil)
+		creq.GetResponse(t)
+	})
+}
+
+// Test that a call request without ifMatch property omits it in the request
+// to the service
+func TestIfMatch_CallRequestWithoutIfMatch_OmitsIfMatch(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		req := s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+		if _, ok := req.Payload.(map[string]interface{})["ifMatch"]; ok {
+			t.Fatalf("expected no ifMatch in request payload, but got %v", req.Payload)
+		}
+		req.RespondSuccess(nil)
+		creq.GetResponse(t)
+	})
+}
+
+// Test that a precondition failed error from the service is sent to the
+// client
+func TestIfMatch_PreconditionFailed_ReturnsError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.RequestWithIfMatch("call.test.model.method", nil, "v3")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(reserr.ErrPreconditionFailed)
+		creq.GetResponse(t).AssertError(t, reserr.ErrPreconditionFailed)
+	})
+}
+
+// Test that the If-Match header of a HTTP call request is passed to the
+// service as ifMatch
+func TestIfMatch_HTTPHeader_PassesIfMatch(t *testing.T) {
+	tbl := []struct {
+		Header   string
+		Expected string
+	}{
+		{`"v3"`, "v3"},
+		{`W/"v3"`, `W/"v3"`},
+		{`"v3", "v4"`, `"v3", "v4"`},
+		{`*`, `*`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(r *http.Request) {
+				r.Header.Set("If-Match", l.Header)
+			})
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).
+				AssertSubject(t, "call.test.model.method").
+				AssertPathPayload(t, "ifMatch", l.Expected).
+				RespondSuccess(nil)
+			hreq.GetResponse(t).AssertStatusCode(t, http.StatusNoContent)
+		})
+	}
+}
+
+// Test that a precondition failed error results in HTTP status 412
+func TestIfMatch_HTTPPreconditionFailed_ReturnsStatus412(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(r *http.Request) {
+			r.Header.Set("If-Match", `"v3"`)
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(reserr.ErrPreconditionFailed)
+		hreq.GetResponse(t).
+			AssertStatusCode(t, http.StatusPreconditionFailed).
+			AssertError(t, reserr.ErrPreconditionFailed)
+	})
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// Test that a call with a resource response gets status 201 with a Location
+// header and no body
+func TestHTTPCreated_ResourceResponse_Returns201WithLocation(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/new", []byte(`{"value":42}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.new").RespondResource("test.model.parent")
+
+		hreq.GetResponse(t).
+			Equals(t, http.StatusCreated, []byte{}).
+			AssertHeaders(t, map[string]string{"Location": "/api/test/model/parent"}).
+			AssertMissingHeaders(t, []string{"Preference-Applied"})
+	})
+}
+
+// Test that a call with a resource response, made with a Prefer header asking
+// for the representation, gets the resource as body
+func TestHTTPCreated_PreferRepresentation_ReturnsResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/new", []byte(`{"value":42}`), func(r *http.Request) {
+			r.Header.Set("Prefer", "respond-async, return=representation")
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.new").RespondResource("test.model")
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+
+		hreq.GetResponse(t).
+			Equals(t, http.StatusCreated, json.RawMessage(resourceData("test.model"))).
+			AssertHeaders(t, map[string]string{
+				"Location":           "/api/test/model",
+				"Preference-Applied": "return=representation",
+			})
+	})
+}
+
+// Test that a created resource that cannot be fetched still gets status 201,
+// without a body
+func TestHTTPCreated_PreferRepresentationWithoutAccess_Returns201WithoutBody(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/collection/new", nil, func(r *http.Request) {
+			r.Header.Set("Prefer", "return=representation")
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.collection.new").RespondResource("test.model")
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+
+		hreq.GetResponse(t).
+			Equals(t, http.StatusCreated, []byte{}).
+			AssertHeaders(t, map[string]string{"Location": "/api/test/model"}).
+			AssertMissingHeaders(t, []string{"Preference-Applied"})
+	})
+}
+
+// Test that a call other than new with a resource response gets status 200
+// with a Location header, and no body even with a Prefer header asking for the
+// representation
+func TestHTTPCreated_NonNewCallResourceResponse_Returns200WithLocation(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(r *http.Request) {
+			r.Header.Set("Prefer", "return=representation")
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondResource("test.model.parent")
+
+		hreq.GetResponse(t).
+			Equals(t, http.StatusOK, []byte{}).
+			AssertHeaders(t, map[string]string{"Location": "/api/test/model/parent"}).
+			AssertMissingHeaders(t, []string{"Preference-Applied"})
+	})
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func bulkCall(parallelism int) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.BulkCall = &server.BulkCallConfig{Parallelism: parallelism}
+	}
+}
+
+// Test that a bulk call request calls each operation, and responds with the
+// results in order
+func TestBulkCall_Operations_ReturnsResultsInOrder(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/bulk", []byte(`{"atomic":true,"operations":[
+			{"rid":"test.model","method":"set","params":{"string":"bar"},"ifMatch":"v1"},
+			{"rid":"test.collection","method":"new","params":["baz"]},
+			{"rid":"test.model","method":"fail"}
+		]}`))
+
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.set").
+			AssertPathPayload(t, "params", json.RawMessage(`{"string":"bar"}`)).
+			AssertPathPayload(t, "ifMatch", "v1").
+			AssertPathPayload(t, "bulk.index", 0).
+			AssertPathPayload(t, "bulk.count", 3).
+			AssertPathPayload(t, "bulk.atomic", true).
+			RespondSuccess(nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.collection.new").
+			AssertPathPayload(t, "bulk.index", 1).
+			RespondResource("test.model.new")
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"set"}`))
+
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"results":[
+			{"result":null},
+			{"href":"/api/test/model/new"},
+			{"error":{"code":"system.accessDenied","message":"Access denied"}}
+		]}`))
+	}, bulkCall(1))
+}
+
+// Test that the operations of a bulk call request are called in parallel up
+// to the parallelism limit, with the same bulk ID
+func TestBulkCall_Parallelism_LimitsConcurrentCalls(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/bulk", []byte(`{"operations":[
+			{"rid":"test.a","method":"method"},
+			{"rid":"test.b","method":"method"},
+			{"rid":"test.c","method":"method"}
+		]}`))
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.a").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		mreqs.GetRequest(t, "access.test.b").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		mreqs = s.GetParallelRequests(t, 2)
+		ra := mreqs.GetRequest(t, "call.test.a.method")
+		rb := mreqs.GetRequest(t, "call.test.b.method")
+		id := ra.PathPayload(t, "bulk.id")
+		rb.AssertPathPayload(t, "bulk.id", id)
+		if _, ok := ra.PathPayload(t, "bulk").(map[string]interface{})["atomic"]; ok {
+			t.Fatalf("expected no atomic property, but got %v", ra.Payload)
+		}
+		rb.RespondSuccess("b")
+		s.GetRequest(t).AssertSubject(t, "access.test.c").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.c.method").AssertPathPayload(t, "bulk.id", id).RespondSuccess("c")
+		ra.RespondError(reserr.ErrInvalidParams)
+
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"results":[
+			{"error":{"code":"system.invalidParams","message":"Invalid parameters"}},
+			{"result":"b"},
+			{"result":"c"}
+		]}`))
+	}, bulkCall(2))
+}
+
+// Test that invalid bulk call requests get a bad request error
+func TestBulkCall_InvalidRequest_ReturnsBadRequest(t *testing.T) {
+	for _, body := range []string{
+		`{"operations":[]}`,
+		`{"operations":[{"rid":"test..model","method":"set"}]}`,
+		`{"operations":[{"rid":"test.model","method":""}]}`,
+		`[]`,
+	} {
+		runNamedTest(t, body, func(s *Session) {
+			s.HTTPRequest("POST", "/bulk", []byte(body)).
+				GetResponse(t).
+				AssertStatusCode(t, http.StatusBadRequest).
+				AssertErrorCode(t, reserr.CodeBadRequest)
+		}, bulkCall(0))
+	}
+}
+
+// Test that the bulk call endpoint only accepts POST requests
+func TestBulkCall_GetRequest_ReturnsMethodNotAllowed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/bulk", nil).
+			GetResponse(t).
+			AssertStatusCode(t, http.StatusMethodNotAllowed)
+	}, bulkCall(0))
+}
+
+// Test that the bulk call endpoint is not found when not configured
+func TestBulkCall_NotConfigured_ReturnsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("POST", "/bulk", []byte(`{"operations":[{"rid":"test.model","method":"set"}]}`)).
+			GetResponse(t).
+			AssertStatusCode(t, http.StatusNotFound)
+	})
+}
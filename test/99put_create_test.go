@@ -0,0 +1,92 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func putCreate(cfg *server.Config) {
+	put := "put"
+	create := "create"
+	cfg.PUTMethod = &put
+	cfg.PUTCreateMethod = &create
+}
+
+// Test that a PUT request on an existing resource calls the put method
+func TestPUTCreate_ExistingResource_CallsPutMethod(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("PUT", "/api/test/model", []byte(`{"string":"bar"}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.put").RespondSuccess(nil)
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusNoContent)
+	}, putCreate)
+}
+
+// Test that a PUT request on a resource not found calls the create method on
+// the parent resource, and responds with 201 and a Location header
+func TestPUTCreate_ResourceNotFound_CallsCreateMethod(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("PUT", "/api/test/model/42", []byte(`{"string":"bar"}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model.42").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.42.put").RespondError(reserr.ErrNotFound)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.create").
+			AssertPathPayload(t, "params", json.RawMessage(`{"rid":"test.model.42","params":{"string":"bar"}}`)).
+			RespondSuccess(nil)
+		hreq.GetResponse(t).
+			Equals(t, http.StatusCreated, []byte{}).
+			AssertHeaders(t, map[string]string{"Location": "/api/test/model/42"})
+	}, putCreate)
+}
+
+// Test that a create call returning a result responds with 201 and the
+// result as body
+func TestPUTCreate_CreateResult_ReturnsResult(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("PUT", "/api/test/model/42", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model.42").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.42.put").RespondError(reserr.ErrNotFound)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.create").
+			AssertPathPayload(t, "params", json.RawMessage(`{"rid":"test.model.42"}`)).
+			RespondSuccess(json.RawMessage(`{"id":42}`))
+		hreq.GetResponse(t).
+			Equals(t, http.StatusCreated, json.RawMessage(`{"id":42}`)).
+			AssertHeaders(t, map[string]string{"Location": "/api/test/model/42"})
+	}, putCreate)
+}
+
+// Test that a failing create call responds with the error
+func TestPUTCreate_CreateError_ReturnsError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("PUT", "/api/test/model/42", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model.42").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.42.put").RespondError(reserr.ErrNotFound)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"put"}`))
+		hreq.GetResponse(t).
+			AssertStatusCode(t, http.StatusUnauthorized).
+			AssertError(t, reserr.ErrAccessDenied)
+	}, putCreate)
+}
+
+// Test that a PUT request on a resource not found responds with the error
+// when no create method is set
+func TestPUTCreate_NotConfigured_ReturnsNotFound(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("PUT", "/api/test/model/42", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model.42").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.42.put").RespondError(reserr.ErrNotFound)
+		hreq.GetResponse(t).
+			AssertStatusCode(t, http.StatusNotFound).
+			AssertError(t, reserr.ErrNotFound)
+	}, func(cfg *server.Config) {
+		put := "put"
+		cfg.PUTMethod = &put
+	})
+}
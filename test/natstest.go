@@ -1,7 +1,9 @@
 package test
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
@@ -38,6 +40,8 @@ type NATSTestClient struct {
 	subs      map[string]*Subscription
 	reqs      chan *Request
 	connected bool
+	filter    func(string) bool
+	reconnect func()
 	mu        sync.Mutex
 }
 
@@ -77,6 +81,19 @@ func (c *NATSTestClient) Tracef(format string, v ...interface{}) {
 	}
 }
 
+// tracef writes a formatted trace message for a message on the subject,
+// unless excluded by the trace filter.
+func (c *NATSTestClient) tracef(subj string, format string, v ...interface{}) {
+	if c.filter == nil || c.filter(subj) {
+		c.Tracef(format, v...)
+	}
+}
+
+// SetTraceFilter sets a filter that returns true for subjects to trace.
+func (c *NATSTestClient) SetTraceFilter(f func(subject string) bool) {
+	c.filter = f
+}
+
 // Connect establishes a connection to the MQ
 func (c *NATSTestClient) Connect() error {
 	c.mu.Lock()
@@ -111,10 +128,13 @@ func (c *NATSTestClient) SendRequest(subj string, payload []byte, cb mq.Response
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Gzip compressed payloads are kept raw only
 	var p interface{}
-	err := json.Unmarshal(payload, &p)
-	if err != nil {
-		panic("test: error unmarshaling request payload: " + err.Error())
+	if !bytes.HasPrefix(payload, []byte{0x1f, 0x8b}) {
+		err := json.Unmarshal(payload, &p)
+		if err != nil {
+			panic("test: error unmarshaling request payload: " + err.Error())
+		}
 	}
 
 	r := &Request{
@@ -125,7 +145,7 @@ func (c *NATSTestClient) SendRequest(subj string, payload []byte, cb mq.Response
 		cb:         cb,
 	}
 
-	c.Tracef("<== %s: %s", subj, payload)
+	c.tracef(subj, "<== %s: %s", subj, payload)
 	if c.connected {
 		c.reqs <- r
 	} else {
@@ -133,6 +153,31 @@ func (c *NATSTestClient) SendRequest(subj string, payload []byte, cb mq.Response
 	}
 }
 
+// Publish sends a message on a subject without expecting a response.
+// The message is queued as a request that cannot be responded to.
+func (c *NATSTestClient) Publish(subj string, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var p interface{}
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		panic("test: error unmarshaling published payload: " + err.Error())
+	}
+
+	c.tracef(subj, "<=P %s: %s", subj, payload)
+	if !c.connected {
+		return errors.New("connection closed")
+	}
+	c.reqs <- &Request{
+		Subject:    subj,
+		RawPayload: payload,
+		Payload:    p,
+		c:          c,
+	}
+	return nil
+}
+
 // Subscribe to all events on a resource namespace.
 // The namespace has the format "event."+resource
 func (c *NATSTestClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
@@ -145,7 +190,7 @@ func (c *NATSTestClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubsc
 
 	s := &Subscription{c: c, ns: namespace, cb: cb}
 	c.subs[namespace] = s
-	c.Tracef("<=S %s", namespace)
+	c.tracef(namespace, "<=S %s", namespace)
 	return s, nil
 }
 
@@ -154,6 +199,19 @@ func (c *NATSTestClient) SetClosedHandler(_ func(error)) {
 	// Does nothing
 }
 
+// SetReconnectHandler sets the handler called on Reconnect
+func (c *NATSTestClient) SetReconnectHandler(cb func()) {
+	c.reconnect = cb
+}
+
+// Reconnect simulates a reconnect, such as a fail over to another server,
+// by calling the reconnect handler
+func (c *NATSTestClient) Reconnect() {
+	if c.reconnect != nil {
+		c.reconnect()
+	}
+}
+
 // HasSubscriptions asserts that there is a subscription for the given resource IDs
 func (c *NATSTestClient) HasSubscriptions(t *testing.T, rids ...string) {
 	c.mu.Lock()
@@ -223,7 +281,7 @@ func (c *NATSTestClient) event(ns string, event string, payload interface{}) {
 
 	c.mu.Unlock()
 	subj := ns + "." + event
-	c.Tracef("=>> %s: %s", subj, data)
+	c.tracef(subj, "=>> %s: %s", subj, data)
 	s.cb(subj, data, nil)
 }
 
@@ -240,7 +298,7 @@ func (s *Subscription) Unsubscribe() error {
 		panic("test: subscription inconsistency")
 	}
 
-	s.c.Tracef("U=> %s", s.ns)
+	s.c.tracef(s.ns, "U=> %s", s.ns)
 	delete(s.c.subs, s.ns)
 	return nil
 }
@@ -295,14 +353,14 @@ func (r *Request) Respond(data interface{}) {
 
 // RespondRaw sends a raw byte response
 func (r *Request) RespondRaw(out []byte) {
-	r.c.Tracef("==> %s: %s", r.Subject, out)
+	r.c.tracef(r.Subject, "==> %s: %s", r.Subject, out)
 	r.getCallback()("__RESPONSE_SUBJECT__", out, nil)
 }
 
 // SendError sends an error response
 func (r *Request) SendError(err error) {
 	cb := r.getCallback()
-	r.c.Tracef("X== %s: %s", r.Subject, err)
+	r.c.tracef(r.Subject, "X== %s: %s", r.Subject, err)
 	cb("", nil, err)
 }
 
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// RedisTestServer is an in-memory server implementing the subset of the
+// Redis protocol used by resgate.
+type RedisTestServer struct {
+	l    net.Listener
+	mu   sync.Mutex
+	keys map[string]string
+	wg   sync.WaitGroup
+}
+
+// NewRedisTestServer starts a new RedisTestServer on a random local port.
+func NewRedisTestServer(t *testing.T) *RedisTestServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting redis test server: %s", err)
+	}
+	rs := &RedisTestServer{l: l, keys: make(map[string]string)}
+	rs.wg.Add(1)
+	go rs.accept()
+	return rs
+}
+
+// Config sets the server configuration to use the RedisTestServer.
+func (rs *RedisTestServer) Config(cfg *server.Config) {
+	u := "redis://" + rs.l.Addr().String()
+	cfg.RedisURL = &u
+}
+
+// Close stops the RedisTestServer.
+func (rs *RedisTestServer) Close() {
+	rs.l.Close()
+	rs.wg.Wait()
+}
+
+// Get returns the value of a key, and whether it exists.
+func (rs *RedisTestServer) Get(key string) (string, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	v, ok := rs.keys[key]
+	return v, ok
+}
+
+// Keys returns the keys with the prefix, and their values.
+func (rs *RedisTestServer) Keys(prefix string) map[string]string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	m := make(map[string]string)
+	for k, v := range rs.keys {
+		if strings.HasPrefix(k, prefix) {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// Set sets the value of a key.
+func (rs *RedisTestServer) Set(key, value string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.keys[key] = value
+}
+
+func (rs *RedisTestServer) accept() {
+	defer rs.wg.Done()
+	for {
+		conn, err := rs.l.Accept()
+		if err != nil {
+			return
+		}
+		go rs.serve(conn)
+	}
+}
+
+func (rs *RedisTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, rs.handle(args)); err != nil {
+			return
+		}
+	}
+}
+
+func (rs *RedisTestServer) handle(args []string) string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := rs.keys[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	case "SET":
+		for _, a := range args[3:] {
+			if strings.ToUpper(a) == "NX" {
+				if _, ok := rs.keys[args[1]]; ok {
+					return "$-1\r\n"
+				}
+			}
+		}
+		rs.keys[args[1]] = args[2]
+		return "+OK\r\n"
+	case "INCRBY":
+		v, _ := strconv.ParseInt(rs.keys[args[1]], 10, 64)
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+		v += n
+		rs.keys[args[1]] = strconv.FormatInt(v, 10)
+		return ":" + strconv.FormatInt(v, 10) + "\r\n"
+	case "PEXPIRE":
+		if _, ok := rs.keys[args[1]]; ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "DEL":
+		_, ok := rs.keys[args[1]]
+		delete(rs.keys, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		l, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, l+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:l])
+	}
+	return args, nil
+}
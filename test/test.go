@@ -61,7 +61,11 @@ func (s *Session) ConnectWithChannel(evs chan *ClientEvent) *Conn {
 }
 
 func (s *Session) connect(evs chan *ClientEvent, h http.Header) *Conn {
-	d := wstest.NewDialer(s.s.GetWSHandlerFunc())
+	return s.dial(s.s.GetWSHandlerFunc(), evs, h)
+}
+
+func (s *Session) dial(hf http.Handler, evs chan *ClientEvent, h http.Header) *Conn {
+	d := wstest.NewDialer(hf)
 	c, _, err := d.Dial("ws://example.org/", h)
 	if err != nil {
 		panic(err)
@@ -96,8 +100,28 @@ func (s *Session) ConnectWithHeader(h http.Header) *Conn {
 	return s.connect(make(chan *ClientEvent, 256), h)
 }
 
+// ConnectWithRemoteAddr makes a new mock client websocket connection from
+// the remote address addr, using provided headers. It does not send a version
+// handshake.
+func (s *Session) ConnectWithRemoteAddr(addr string, h http.Header) *Conn {
+	hf := s.s.GetWSHandlerFunc()
+	return s.dial(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = addr
+		hf.ServeHTTP(w, r)
+	}), make(chan *ClientEvent, 256), h)
+}
+
 // HTTPRequest sends a request over HTTP
 func (s *Session) HTTPRequest(method, url string, body []byte, opts ...func(r *http.Request)) *HTTPRequest {
+	return s.httpRequest(s.s, method, url, body, opts...)
+}
+
+// AdminRequest sends a request over HTTP to the admin endpoint
+func (s *Session) AdminRequest(method, url string, body []byte, opts ...func(r *http.Request)) *HTTPRequest {
+	return s.httpRequest(s.s.AdminHandler(), method, url, body, opts...)
+}
+
+func (s *Session) httpRequest(h http.Handler, method, url string, body []byte, opts ...func(r *http.Request)) *HTTPRequest {
 	r := bytes.NewReader(body)
 
 	req, err := http.NewRequest(method, url, r)
@@ -120,7 +144,7 @@ func (s *Session) HTTPRequest(method, url string, body []byte, opts ...func(r *h
 
 	go func() {
 		s.Tracef("H-> %s %s: %s", method, url, body)
-		s.s.ServeHTTP(rr, req)
+		h.ServeHTTP(rr, req)
 		s.Tracef("<-H %s %s: (%d) %s", method, url, rr.Code, rr.Body.String())
 		hr.ch <- &HTTPResponse{ResponseRecorder: rr}
 	}()
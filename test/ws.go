@@ -28,17 +28,22 @@ type Conn struct {
 }
 
 type clientRequest struct {
-	Method string      `json:"method"`
-	Params interface{} `json:"params,omitempty"`
-	ID     uint64      `json:"id"`
+	Method         string      `json:"method"`
+	Params         interface{} `json:"params,omitempty"`
+	ID             uint64      `json:"id"`
+	IdempotencyKey string      `json:"idempotencyKey,omitempty"`
+	IfMatch        string      `json:"ifMatch,omitempty"`
+	ExecuteAt      string      `json:"executeAt,omitempty"`
 }
 
 type clientResponse struct {
-	Result interface{}   `json:"result"`
-	Error  *reserr.Error `json:"error"`
-	ID     uint64        `json:"id"`
-	Event  *string       `json:"event"`
-	Data   interface{}   `json:"data"`
+	Result  interface{}   `json:"result"`
+	Error   *reserr.Error `json:"error"`
+	ID      uint64        `json:"id"`
+	Event   *string       `json:"event"`
+	Data    interface{}   `json:"data"`
+	Timing  interface{}   `json:"timing"`
+	Warning interface{}   `json:"warning"`
 }
 
 var clientRequestID uint64
@@ -53,8 +58,10 @@ type ClientRequest struct {
 
 // ClientResponse represents a response to a RES-client request
 type ClientResponse struct {
-	Result interface{}
-	Error  *reserr.Error
+	Result  interface{}
+	Error   *reserr.Error
+	Timing  interface{}
+	Warning interface{}
 }
 
 // ClientEvent represents a RES-client event sent to the client
@@ -83,6 +90,28 @@ func NewConn(s *Session, d *websocket.Dialer, ws *websocket.Conn, evs chan *Clie
 // Request sends a properly formatted request to the gateway
 // using the method and parameters provided.
 func (c *Conn) Request(method string, params interface{}) *ClientRequest {
+	return c.request(clientRequest{Method: method, Params: params})
+}
+
+// RequestWithIdempotencyKey sends a properly formatted request to the gateway
+// using the method, parameters, and idempotency key provided.
+func (c *Conn) RequestWithIdempotencyKey(method string, params interface{}, key string) *ClientRequest {
+	return c.request(clientRequest{Method: method, Params: params, IdempotencyKey: key})
+}
+
+// RequestWithIfMatch sends a properly formatted request to the gateway
+// using the method, parameters, and expected resource version provided.
+func (c *Conn) RequestWithIfMatch(method string, params interface{}, ifMatch string) *ClientRequest {
+	return c.request(clientRequest{Method: method, Params: params, IfMatch: ifMatch})
+}
+
+// RequestWithExecuteAt sends a properly formatted request to the gateway
+// using the method, parameters, and execute-at time provided.
+func (c *Conn) RequestWithExecuteAt(method string, params interface{}, executeAt string) *ClientRequest {
+	return c.request(clientRequest{Method: method, Params: params, ExecuteAt: executeAt})
+}
+
+func (c *Conn) request(cr clientRequest) *ClientRequest {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -92,18 +121,15 @@ func (c *Conn) Request(method string, params interface{}) *ClientRequest {
 
 	id := clientRequestID
 	clientRequestID++
-	err := c.ws.WriteJSON(clientRequest{
-		ID:     id,
-		Method: method,
-		Params: params,
-	})
+	cr.ID = id
+	err := c.ws.WriteJSON(cr)
 	if err != nil {
 		panic("test: error marshaling client request: " + err.Error())
 	}
 
 	req := &ClientRequest{
-		Method: method,
-		Params: params,
+		Method: cr.Method,
+		Params: cr.Params,
 		c:      c,
 		ch:     make(chan *ClientResponse, 1),
 	}
@@ -228,8 +254,10 @@ Loop:
 			c.mu.Unlock()
 			select {
 			case req.ch <- &ClientResponse{
-				Result: cr.Result,
-				Error:  cr.Error,
+				Result:  cr.Result,
+				Error:   cr.Error,
+				Timing:  cr.Timing,
+				Warning: cr.Warning,
 			}:
 			default:
 				c.setError(err)
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/nats"
+	"github.com/resgateio/resgate/server/verify"
+)
+
+var verifyUsageStr = `
+Usage: resgate verify [options] <resourceID> [<resourceID> ...]
+
+Sends get and access requests, and optional call requests, for each
+resource through NATS, and reports any responses violating the RES
+service protocol.
+
+Verify Options:
+    -n, --nats <url>[,<url>...]      NATS Server URLs, comma separated (default: nats://127.0.0.1:4222)
+    -r, --reqtimeout <milliseconds>  Timeout duration for NATS requests (default: 3000)
+        --creds <file>               NATS User Credentials file
+        --natstlscert <file>         NATS client certificate file
+        --natstlskey <file>          Private key for NATS client certificate
+        --natsrootca <file>          NATS root CA certificate file
+        --token <json>               Access token to include in access and call requests
+        --call <methodName>          Call method to call on each resource (may be repeated)
+        --params <json>              Parameters to include in call requests
+    -h, --help                       Show this message
+`
+
+func verifyUsage() {
+	fmt.Printf("%s\n", verifyUsageStr)
+	os.Exit(0)
+}
+
+// runVerify runs the verify command, exiting with status 1 if any protocol
+// violations are found.
+func runVerify(args []string) {
+	var (
+		showHelp       bool
+		natsURL        string
+		natsCreds      string
+		natsTLSCert    string
+		natsTLSKey     string
+		natsRootCA     string
+		requestTimeout int
+		token          string
+		params         string
+		methods        StringSlice
+	)
+
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = verifyUsage
+	fs.BoolVar(&showHelp, "h", false, "Show this message.")
+	fs.BoolVar(&showHelp, "help", false, "Show this message.")
+	fs.StringVar(&natsURL, "n", DefaultNatsURL, "NATS Server URL(s), comma separated.")
+	fs.StringVar(&natsURL, "nats", DefaultNatsURL, "NATS Server URL(s), comma separated.")
+	fs.IntVar(&requestTimeout, "r", DefaultRequestTimeout, "Timeout in milliseconds for NATS requests.")
+	fs.IntVar(&requestTimeout, "reqtimeout", DefaultRequestTimeout, "Timeout in milliseconds for NATS requests.")
+	fs.StringVar(&natsCreds, "creds", "", "NATS User Credentials file.")
+	fs.StringVar(&natsTLSCert, "natstlscert", "", "NATS client certificate file.")
+	fs.StringVar(&natsTLSKey, "natstlskey", "", "Private key for NATS client certificate.")
+	fs.StringVar(&natsRootCA, "natsrootca", "", "NATS root CA certificate file.")
+	fs.StringVar(&token, "token", "", "Access token.")
+	fs.Var(&methods, "call", "Call method name.")
+	fs.StringVar(&params, "params", "", "Call request parameters.")
+
+	if err := fs.Parse(args); err != nil {
+		printAndDie(fmt.Sprintf("Error parsing command arguments: %s", err.Error()), false)
+	}
+	if showHelp {
+		verifyUsage()
+	}
+	if fs.NArg() == 0 {
+		printAndDie("Missing resource ID", false)
+	}
+
+	opts := verify.Options{Methods: methods}
+	if token != "" {
+		if !json.Valid([]byte(token)) {
+			printAndDie("Invalid token: must be valid JSON", false)
+		}
+		opts.Token = json.RawMessage(token)
+	}
+	if params != "" {
+		if !json.Valid([]byte(params)) {
+			printAndDie("Invalid params: must be valid JSON", false)
+		}
+		opts.Params = json.RawMessage(params)
+	}
+
+	c := &nats.Client{
+		URL:            natsURL,
+		RequestTimeout: time.Duration(requestTimeout) * time.Millisecond,
+		Logger:         logger.NewStdLogger(false, false),
+	}
+	if natsCreds != "" {
+		c.Creds = &natsCreds
+	}
+	if (natsTLSCert == "") != (natsTLSKey == "") {
+		printAndDie("NATS client certificate requires both --natstlscert and --natstlskey", false)
+	}
+	if natsTLSCert != "" {
+		c.TLSCert = &natsTLSCert
+		c.TLSKey = &natsTLSKey
+	}
+	if natsRootCA != "" {
+		c.RootCA = &natsRootCA
+	}
+	if err := c.Connect(); err != nil {
+		printAndDie(fmt.Sprintf("Failed to connect to NATS: %s", err), false)
+	}
+	rs := verify.Run(c, fs.Args(), opts)
+	c.Close()
+
+	violations := 0
+	for _, r := range rs {
+		switch {
+		case !r.OK():
+			fmt.Printf("FAIL %s\n", r.Subject)
+			for _, v := range r.Violations {
+				fmt.Printf("     - %s\n", v)
+			}
+			violations += len(r.Violations)
+		case r.Error != nil:
+			fmt.Printf("OK   %s (error: %s)\n", r.Subject, r.Error.Code)
+		default:
+			fmt.Printf("OK   %s\n", r.Subject)
+		}
+	}
+
+	if violations > 0 {
+		printAndDie(fmt.Sprintf("\n%d protocol violation(s) found", violations), false)
+	}
+}